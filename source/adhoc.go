@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ad-hoc announcements play a one-off recording, such as an event notice
+// recorded on a phone, without adding it to a catalog. The upload is checked,
+// kept in the adhoc directory under the log directory while it waits in the
+// queue, and deleted once it has played, failed, been cancelled or expired.
+
+// Upload limits
+const (
+	maxAdhocUploadBytes = 20 << 20
+	maxAdhocDuration    = 5 * time.Minute
+)
+
+var adhocExtensions = map[string]bool{".mp3": true, ".wav": true}
+
+// adhocAudioDir is where uploads wait to be played
+func adhocAudioDir() string {
+	return filepath.Join(app.Config.LogDir, "adhoc")
+}
+
+// adhocAudioPath returns an ad-hoc announcement's recording, refusing paths
+// outside the adhoc directory so other callers can't play arbitrary files
+func adhocAudioPath(parameters map[string]interface{}) (string, error) {
+	path, _ := parameters["audio_file"].(string)
+	if path == "" {
+		return "", fmt.Errorf("adhoc announcement requires 'audio_file' parameter")
+	}
+	if filepath.Dir(filepath.Clean(path)) != filepath.Clean(adhocAudioDir()) {
+		return "", fmt.Errorf("adhoc audio must be uploaded through /api/announce/adhoc")
+	}
+	return path, nil
+}
+
+// removeAdhocAudio deletes a finished ad-hoc announcement's recording
+func removeAdhocAudio(announcement *Announcement) {
+	path, err := adhocAudioPath(announcement.Parameters)
+	if err != nil {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: failed to delete ad-hoc recording %s: %v", path, err)
+	}
+}
+
+// clearAdhocAudio deletes recordings left behind by a previous run; the queue
+// is not kept across restarts, so nothing still needs them
+func clearAdhocAudio() {
+	entries, err := os.ReadDir(adhocAudioDir())
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			os.Remove(filepath.Join(adhocAudioDir(), entry.Name()))
+		}
+	}
+}
+
+// checkAdhocAudio decodes a recording to make sure it will play and is not too long
+func checkAdhocAudio(path string) (time.Duration, error) {
+	streamer, format, err := decodeAudioFile(path)
+	if err != nil {
+		return 0, err
+	}
+	defer streamer.Close()
+
+	duration := format.SampleRate.D(streamer.Len())
+	if duration <= 0 {
+		return 0, fmt.Errorf("recording is empty")
+	}
+	if duration > maxAdhocDuration {
+		return 0, fmt.Errorf("recording is %s long; the limit is %s", duration.Round(time.Second), maxAdhocDuration)
+	}
+	return duration, nil
+}
+
+// limitRequestBody rejects request bodies over maxBytes
+func limitRequestBody(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > maxBytes {
+			respondError(c, http.StatusRequestEntityTooLarge, ErrCodeBadRequest, fmt.Sprintf("Request body is larger than %d MB", maxBytes>>20))
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
+	}
+}
+
+// apiAdhocAnnouncementHandler queues an uploaded recording once.
+// The multipart form has the recording in "file" and the usual priority,
+// delay, callback_url and expires_in fields.
+func apiAdhocAnnouncementHandler(c *gin.Context) {
+	if !requireAnnouncementManager(c) {
+		return
+	}
+
+	upload, err := c.FormFile("file")
+	if err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			respondError(c, http.StatusRequestEntityTooLarge, ErrCodeBadRequest, fmt.Sprintf("Recording is larger than %d MB", maxAdhocUploadBytes>>20))
+			return
+		}
+		respondValidationError(c, "Invalid adhoc announcement request", FieldError{Field: "file", Message: "is required (multipart/form-data upload)"})
+		return
+	}
+	data, ok := bindRequestData(c, "priority", "delay", "callback_url", "expires_in")
+	if !ok {
+		return
+	}
+
+	var details []FieldError
+	extension := strings.ToLower(filepath.Ext(upload.Filename))
+	if message := checkUploadExtension(extension); message != "" {
+		details = append(details, FieldError{Field: "file", Message: message})
+	}
+	priority, scheduledAt, schedulingErrors := parseAnnouncementScheduling(data, "normal")
+	details = append(details, schedulingErrors...)
+	options, optionErrors := parseAnnouncementOptions(data)
+	details = append(details, optionErrors...)
+	if len(details) > 0 {
+		respondValidationError(c, "Invalid adhoc announcement request", details...)
+		return
+	}
+
+	// Save the upload where only its announcement will use it
+	if err := storageWritable(adhocAudioDir(), "storing the recording"); err != nil {
+		respondError(c, http.StatusInsufficientStorage, ErrCodeUnavailable, err.Error())
+		return
+	}
+	if err := os.MkdirAll(adhocAudioDir(), 0755); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to store recording: "+err.Error())
+		return
+	}
+	source, err := upload.Open()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Failed to read recording: "+err.Error())
+		return
+	}
+	defer source.Close()
+	stored, err := os.CreateTemp(adhocAudioDir(), "adhoc_*"+extension)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to store recording: "+err.Error())
+		return
+	}
+	path := stored.Name()
+	_, err = io.Copy(stored, source)
+	stored.Close()
+	if err != nil {
+		os.Remove(path)
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to store recording: "+err.Error())
+		return
+	}
+
+	// Normalized before the check, which can't decode every upload format
+	transcoded, err := transcodeUpload(path)
+	if err != nil {
+		os.Remove(path)
+		respondValidationError(c, "Invalid adhoc announcement request", FieldError{Field: "file", Message: err.Error()})
+		return
+	}
+	path = transcoded
+	duration, err := checkAdhocAudio(path)
+	if err != nil {
+		os.Remove(path)
+		respondValidationError(c, "Invalid adhoc announcement request", FieldError{Field: "file", Message: err.Error()})
+		return
+	}
+
+	parameters := map[string]interface{}{
+		"audio_file": path,
+		"name":       filepath.Base(upload.Filename),
+	}
+	options.RequestedBy = c.GetString("announcement_requester")
+	announcement, err := announcementManager.QueueAnnouncementWithOptions(TypeAdhoc, priority, parameters, scheduledAt, options)
+	if err != nil {
+		os.Remove(path)
+		respondQueueError(c, "Failed to queue adhoc announcement", err)
+		return
+	}
+	log.Printf("Ad-hoc announcement queued: %s (%s, %s)", announcement.ID, filepath.Base(upload.Filename), duration.Round(time.Second))
+
+	respondSuccess(c, http.StatusAccepted, "Adhoc announcement queued", gin.H{
+		"announcement": gin.H{
+			"id":           announcement.ID,
+			"type":         string(TypeAdhoc),
+			"priority":     priority.String(),
+			"status":       string(announcement.Status),
+			"name":         filepath.Base(upload.Filename),
+			"duration":     duration.Seconds(),
+			"scheduled_at": announcement.ScheduledAt.Format(time.RFC3339),
+		},
+	})
+}
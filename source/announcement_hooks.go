@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Announcement hooks run before and after an announcement plays, to drive
+// hardware the annunciator doesn't talk to directly: closing a relay to unmute
+// an amplifier zone, say, and opening it again afterwards. A hook is either a
+// shell command or an HTTP request. Pre hooks run in order before the audio
+// output is taken, each optionally followed by a settle delay. Post hooks run
+// after playback ends, whether it completed, failed or was stopped, so
+// whatever the pre hooks switched on is switched off again. A pre hook that
+// fails with on_failure "abort" fails the announcement instead of playing it
+// to a muted amplifier; the post hooks still run.
+
+const (
+	hookStagePre  = "pre"
+	hookStagePost = "post"
+
+	// Output kept from a hook for the status
+	hookOutputLimit = 500
+)
+
+// AnnouncementHook is one entry in the hooks section of audio_settings.json
+type AnnouncementHook struct {
+	Name           string   `json:"name"`
+	Stage          string   `json:"stage"`             // pre or post
+	Command        string   `json:"command,omitempty"` // Run by sh -c (cmd /C on Windows)
+	URL            string   `json:"url,omitempty"`     // Requested instead of a command
+	Method         string   `json:"method,omitempty"`  // GET or POST (default); POST sends the announcement as JSON
+	Types          []string `json:"types,omitempty"`   // Announcement types; empty for all
+	Zones          []string `json:"zones,omitempty"`   // Zones; "default" matches announcements without zones; empty for all
+	TimeoutSeconds int      `json:"timeout_seconds"`
+	DelayMs        int      `json:"delay_ms"`   // Wait after a pre hook succeeds, for relays and amplifiers to settle
+	OnFailure      string   `json:"on_failure"` // continue (default) or abort; abort only applies to pre hooks
+}
+
+// AnnouncementHookSettings is the hooks section of audio_settings.json
+type AnnouncementHookSettings struct {
+	Enabled bool               `json:"enabled"`
+	Hooks   []AnnouncementHook `json:"hooks"`
+}
+
+func getDefaultAnnouncementHookSettings() AnnouncementHookSettings {
+	return AnnouncementHookSettings{
+		Enabled: false,
+		Hooks:   []AnnouncementHook{},
+	}
+}
+
+// hookRun is the result of a hook's last run
+type hookRun struct {
+	Hook           string    `json:"hook"`
+	Stage          string    `json:"stage"`
+	AnnouncementID string    `json:"announcement_id"`
+	At             time.Time `json:"at"`
+	DurationMs     int64     `json:"duration_ms"`
+	OK             bool      `json:"ok"`
+	Error          string    `json:"error,omitempty"`
+	Output         string    `json:"output,omitempty"`
+}
+
+var announcementHookRuns = struct {
+	sync.Mutex
+	last map[string]hookRun // by hook name
+}{last: make(map[string]hookRun)}
+
+// validateAnnouncementHook checks a hook from audio_settings.json
+func validateAnnouncementHook(hook AnnouncementHook) error {
+	if strings.TrimSpace(hook.Name) == "" {
+		return fmt.Errorf("a hook has no name")
+	}
+	if hook.Stage != hookStagePre && hook.Stage != hookStagePost {
+		return fmt.Errorf("hook %q: stage must be pre or post", hook.Name)
+	}
+	if (hook.Command == "") == (hook.URL == "") {
+		return fmt.Errorf("hook %q: set either command or url", hook.Name)
+	}
+	if hook.URL != "" {
+		if err := validateCallbackURL(hook.URL); err != nil {
+			return fmt.Errorf("hook %q: url %v", hook.Name, err)
+		}
+		if method := strings.ToUpper(hook.Method); method != "" && method != http.MethodGet && method != http.MethodPost {
+			return fmt.Errorf("hook %q: method must be GET or POST", hook.Name)
+		}
+	}
+	if hook.OnFailure != "" && hook.OnFailure != "continue" && hook.OnFailure != "abort" {
+		return fmt.Errorf("hook %q: on_failure must be continue or abort", hook.Name)
+	}
+	return nil
+}
+
+// hookTimeout is how long a hook may run
+func hookTimeout(hook AnnouncementHook) time.Duration {
+	if hook.TimeoutSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(hook.TimeoutSeconds) * time.Second
+}
+
+// matches reports whether the hook applies to an announcement
+func (hook AnnouncementHook) matches(announcement *Announcement) bool {
+	if len(hook.Types) > 0 && !containsString(hook.Types, string(announcement.Type)) {
+		return false
+	}
+	if len(hook.Zones) == 0 {
+		return true
+	}
+	zones := announcementZones(announcement.Parameters)
+	if len(zones) == 0 {
+		return containsString(hook.Zones, "default")
+	}
+	for _, zone := range zones {
+		if containsString(hook.Zones, zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// announcementHooksFor returns the valid hooks that apply to an announcement,
+// in the order they are configured
+func announcementHooksFor(announcement *Announcement) []AnnouncementHook {
+	settings := getAudioSettings().Hooks
+	if !settings.Enabled {
+		return nil
+	}
+	var hooks []AnnouncementHook
+	for _, hook := range settings.Hooks {
+		if err := validateAnnouncementHook(hook); err != nil {
+			log.Printf("⚠️  Skipping announcement hook: %v", err)
+			continue
+		}
+		if hook.matches(announcement) {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+// runAnnouncementHooks runs the hooks of one stage in order. outcome is the
+// announcement's result for post hooks (completed, failed or cancelled). It
+// returns an error only when a pre hook set to abort fails.
+func runAnnouncementHooks(hooks []AnnouncementHook, stage string, announcement Announcement, outcome string) error {
+	for _, hook := range hooks {
+		if hook.Stage != stage {
+			continue
+		}
+		run := runAnnouncementHook(hook, announcement, outcome)
+		if run.OK {
+			if stage == hookStagePre && hook.DelayMs > 0 {
+				time.Sleep(time.Duration(hook.DelayMs) * time.Millisecond)
+			}
+			continue
+		}
+
+		abort := stage == hookStagePre && hook.OnFailure == "abort"
+		log.Printf("⚠️  %s-announcement hook %s failed for %s: %s", stage, hook.Name, announcement.ID, run.Error)
+		severity := "warning"
+		consequence := "The announcement played anyway."
+		if abort {
+			severity = "critical"
+			consequence = "The announcement was not played."
+		} else if stage == hookStagePost {
+			consequence = "Whatever its pre hook switched on may still be on."
+		}
+		notify(NotifyHookFailed, hook.Name, severity, "Announcement hook failed",
+			fmt.Sprintf("The %s-announcement hook %q failed for announcement %s (%s): %s. %s",
+				stage, hook.Name, announcement.ID, announcement.Type, run.Error, consequence))
+		if abort {
+			return fmt.Errorf("pre-announcement hook %s failed: %s", hook.Name, run.Error)
+		}
+	}
+	return nil
+}
+
+// runAnnouncementHook runs one hook and records the result
+func runAnnouncementHook(hook AnnouncementHook, announcement Announcement, outcome string) hookRun {
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout(hook))
+	defer cancel()
+
+	var output string
+	var err error
+	if hook.Command != "" {
+		output, err = runHookCommand(ctx, hook, announcement, outcome)
+	} else {
+		output, err = runHookRequest(ctx, hook, announcement, outcome)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", hookTimeout(hook))
+	}
+
+	output = strings.TrimSpace(output)
+	if len(output) > hookOutputLimit {
+		output = output[:hookOutputLimit]
+	}
+	run := hookRun{
+		Hook:           hook.Name,
+		Stage:          hook.Stage,
+		AnnouncementID: announcement.ID,
+		At:             started,
+		DurationMs:     time.Since(started).Milliseconds(),
+		OK:             err == nil,
+		Output:         output,
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	announcementHookRuns.Lock()
+	announcementHookRuns.last[hook.Name] = run
+	announcementHookRuns.Unlock()
+	return run
+}
+
+// runHookCommand runs a command hook through the shell. The announcement is
+// described in TARR_* environment variables.
+func runHookCommand(ctx context.Context, hook AnnouncementHook, announcement Announcement, outcome string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", hook.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", hook.Command)
+	}
+	cmd.Dir = app.Config.BaseDir
+	cmd.Env = append(os.Environ(),
+		"TARR_HOOK="+hook.Name,
+		"TARR_HOOK_STAGE="+hook.Stage,
+		"TARR_ANNOUNCEMENT_ID="+announcement.ID,
+		"TARR_ANNOUNCEMENT_TYPE="+string(announcement.Type),
+		"TARR_ANNOUNCEMENT_PRIORITY="+announcement.Priority.String(),
+		"TARR_ZONES="+strings.Join(announcementZones(announcement.Parameters), ","),
+		"TARR_ANNOUNCEMENT_STATUS="+outcome,
+	)
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+// hookPayload is POSTed by URL hooks
+type hookPayload struct {
+	Event        string       `json:"event"` // announcement.pre_play or announcement.post_play
+	Hook         string       `json:"hook"`
+	Outcome      string       `json:"outcome,omitempty"` // Post hooks only
+	Zones        []string     `json:"zones"`
+	Announcement Announcement `json:"announcement"`
+	Timestamp    string       `json:"timestamp"`
+}
+
+// runHookRequest requests a URL hook. POSTs are signed like announcement
+// webhooks; any response other than 2xx is a failure.
+func runHookRequest(ctx context.Context, hook AnnouncementHook, announcement Announcement, outcome string) (string, error) {
+	event := "announcement." + hook.Stage + "_play"
+	var req *http.Request
+	var err error
+	if strings.ToUpper(hook.Method) == http.MethodGet {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, hook.URL, nil)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		body, err := json.Marshal(hookPayload{
+			Event:        event,
+			Hook:         hook.Name,
+			Outcome:      outcome,
+			Zones:        announcementZones(announcement.Parameters),
+			Announcement: announcement,
+			Timestamp:    time.Now().Format(time.RFC3339),
+		})
+		if err != nil {
+			return "", err
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-TARR-Timestamp", timestamp)
+		if secret := webhookSecret(); secret != "" {
+			req.Header.Set("X-TARR-Signature", "sha256="+signWebhook(secret, timestamp, body))
+		}
+	}
+	req.Header.Set("User-Agent", "TARR-Annunciator-Hook")
+	req.Header.Set("X-TARR-Event", event)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, hookOutputLimit))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return string(body), fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return string(body), nil
+}
+
+// playbackOutcome names how playback ended, for post hooks
+func (am *AnnouncementManager) playbackOutcome(announcement *Announcement, err error) string {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	switch {
+	case announcement.CompletedAt != nil:
+		// StopCurrent or the playback watchdog finished it first
+		return string(announcement.Status)
+	case err == nil:
+		return string(StatusCompleted)
+	case errors.Is(err, errPlaybackCancelled) || err.Error() == "announcement cancelled":
+		return string(StatusCancelled)
+	}
+	return string(StatusFailed)
+}
+
+// snapshotAnnouncement copies an announcement under the mutex
+func (am *AnnouncementManager) snapshotAnnouncement(announcement *Announcement) Announcement {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	return *announcement
+}
+
+// Handlers
+
+// apiHooksStatusHandler lists the configured hooks and each one's last run
+func apiHooksStatusHandler(c *gin.Context) {
+	settings := getAudioSettings().Hooks
+	type hookStatus struct {
+		AnnouncementHook
+		Error   string   `json:"error,omitempty"` // Why the hook is skipped
+		LastRun *hookRun `json:"last_run,omitempty"`
+	}
+	hooks := make([]hookStatus, 0, len(settings.Hooks))
+	announcementHookRuns.Lock()
+	for _, hook := range settings.Hooks {
+		status := hookStatus{AnnouncementHook: hook}
+		if err := validateAnnouncementHook(hook); err != nil {
+			status.Error = err.Error()
+		}
+		if run, ok := announcementHookRuns.last[hook.Name]; ok {
+			status.LastRun = &run
+		}
+		hooks = append(hooks, status)
+	}
+	announcementHookRuns.Unlock()
+	respondOK(c, gin.H{"enabled": settings.Enabled, "hooks": hooks})
+}
+
+// apiTestHookHandler runs one hook now against a test announcement, to check
+// the wiring without playing anything
+func apiTestHookHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	data, ok := bindRequestData(c, "name", "outcome")
+	if !ok {
+		return
+	}
+	name, _ := data["name"].(string)
+	if strings.TrimSpace(name) == "" {
+		respondValidationError(c, "name is required", FieldError{Field: "name", Message: "is required"})
+		return
+	}
+	var hook *AnnouncementHook
+	for _, configured := range getAudioSettings().Hooks.Hooks {
+		if configured.Name == name {
+			configured := configured
+			hook = &configured
+			break
+		}
+	}
+	if hook == nil {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("No hook named %q in audio_settings.json", name))
+		return
+	}
+	if err := validateAnnouncementHook(*hook); err != nil {
+		respondValidationError(c, err.Error(), FieldError{Field: "name", Message: err.Error()})
+		return
+	}
+
+	outcome := ""
+	if hook.Stage == hookStagePost {
+		outcome = string(StatusCompleted)
+		if requested, _ := data["outcome"].(string); requested != "" {
+			outcome = requested
+		}
+	}
+	announcement := Announcement{
+		ID:         "hook-test",
+		Type:       TypeSafety,
+		Priority:   PriorityNormal,
+		Status:     StatusPlaying,
+		CreatedAt:  time.Now(),
+		Parameters: map[string]interface{}{},
+		AudioFiles: []string{},
+	}
+	if len(hook.Zones) > 0 && hook.Zones[0] != "default" {
+		announcement.Parameters["zones"] = []interface{}{hook.Zones[0]}
+	}
+	log.Printf("Announcement hook %s tested by %s", hook.Name, requestOperator(c))
+	run := runAnnouncementHook(*hook, announcement, outcome)
+	if !run.OK {
+		respondError(c, http.StatusBadGateway, ErrCodeUnavailable, fmt.Sprintf("Hook %s failed: %s", hook.Name, run.Error))
+		return
+	}
+	respondSuccess(c, http.StatusOK, "Hook ran", gin.H{"run": run})
+}
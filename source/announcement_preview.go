@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gopxl/beep"
+)
+
+// An announcement preview renders the exact audio an announcement would play
+// (chime, lead-in tone, clips, gaps and fades) to a WAV file without queuing
+// it, so a new clip set can be listened to before it goes live. The output
+// volume is not applied and nothing reaches the speakers.
+
+// Longest preview rendered, to bound the memory a request can use
+const maxPreviewDuration = 5 * time.Minute
+
+// Fields an announcement needs before its sequence can be built, by type
+var previewRequiredFields = map[AnnouncementType][]string{
+	TypeStation:       {"train_number", "direction", "destination", "track_number"},
+	TypeSafety:        {"language"},
+	TypePromo:         {"file"},
+	TypeEmergency:     {"file"},
+	TypeLightning:     {"condition"},
+	TypeServiceChange: {"train_number", "old_track", "new_track"},
+	TypeDelay:         {"train_number", "delay_minutes"},
+	TypeCancellation:  {"train_number"},
+	TypeWeather:       {"text"},
+	TypeClock:         {"mode"},
+	TypeCountdown:     {"train_number", "destination", "hour", "minute", "minutes"},
+}
+
+var previewFields = []string{
+	"type", "train_number", "direction", "destination", "track_number", "language", "languages",
+	"file", "condition", "old_track", "new_track", "reason", "delay_minutes", "zone", "text",
+	"mode", "hour", "source", "strike", "chime",
+}
+
+// renderAnnouncementPreview composes an announcement's audio files and
+// renders them to WAV at the output sample rate
+func renderAnnouncementPreview(announcementType AnnouncementType, parameters map[string]interface{}, files []string) ([]byte, error) {
+	sampleRate := sampleRateOrDefault()
+	composed, closeAll, err := composeSequence(files, sequenceSettingsFor(announcementType), leadInSettingsFor(announcementType, parameters), sampleRate)
+	defer closeAll()
+	if err != nil {
+		return nil, err
+	}
+	if composed == nil {
+		return nil, fmt.Errorf("announcement has no audio")
+	}
+	return renderWAV(composed, sampleRate, maxPreviewDuration)
+}
+
+// renderWAV reads a stream to its end into an in-memory 16-bit stereo WAV file
+func renderWAV(streamer beep.Streamer, sampleRate beep.SampleRate, maxDuration time.Duration) ([]byte, error) {
+	var pcm bytes.Buffer
+	limit := sampleRate.N(maxDuration)
+	samples := make([][2]float64, 4096)
+	total := 0
+	for {
+		n, ok := streamer.Stream(samples)
+		pcm.Write(encodePCM16(samples[:n]))
+		total += n
+		if !ok {
+			break
+		}
+		if total > limit {
+			return nil, fmt.Errorf("preview is longer than %s", maxDuration)
+		}
+	}
+	if err := streamer.Err(); err != nil {
+		return nil, err
+	}
+
+	wav := make([]byte, 0, 44+pcm.Len())
+	wav = append(wav, wavFileHeader(sampleRate, uint32(pcm.Len()))...)
+	return append(wav, pcm.Bytes()...), nil
+}
+
+// previewRequestData reads the preview fields from the query string for GET,
+// so the URL can be used directly as an <audio> source, or from the body for POST
+func previewRequestData(c *gin.Context) (map[string]interface{}, bool) {
+	if c.Request.Method != http.MethodGet {
+		return bindRequestData(c, previewFields...)
+	}
+	data := make(map[string]interface{})
+	for _, field := range previewFields {
+		if value, ok := c.GetQuery(field); ok {
+			data[field] = value
+		}
+	}
+	return data, true
+}
+
+// apiAnnouncementPreviewHandler returns the composed audio of an announcement as WAV
+func apiAnnouncementPreviewHandler(c *gin.Context) {
+	data, ok := previewRequestData(c)
+	if !ok {
+		return
+	}
+
+	details := requiredStringFields(data, "type")
+	announcementType := AnnouncementType(strings.TrimSpace(fmt.Sprint(data["type"])))
+	required, known := previewRequiredFields[announcementType]
+	if len(details) == 0 && !known {
+		details = append(details, FieldError{Field: "type", Message: "unknown announcement type '" + string(announcementType) + "'"})
+	}
+	details = append(details, requiredStringFields(data, required...)...)
+	languages := languageList(data["languages"])
+	if err := validateLanguages(languages); err != nil {
+		details = append(details, FieldError{Field: "languages", Message: err.Error()})
+	}
+	if len(details) > 0 {
+		respondValidationError(c, "Invalid announcement preview request", details...)
+		return
+	}
+
+	parameters := make(map[string]interface{})
+	for _, field := range previewFields {
+		if value, ok := data[field].(string); ok && value != "" && field != "type" && field != "languages" {
+			parameters[field] = value
+		}
+	}
+	if len(languages) > 0 {
+		parameters["languages"] = languages
+	}
+
+	if err := validateAnnouncementParameters(announcementType, parameters); err != nil {
+		respondQueueError(c, "Failed to render preview", err)
+		return
+	}
+	files, err := announcementManager.buildAudioSequence(announcementType, parameters)
+	if err != nil {
+		respondValidationError(c, "Invalid announcement preview request", FieldError{Field: "type", Message: err.Error()})
+		return
+	}
+	var missing []string
+	for _, file := range files {
+		if !fileExists(file) {
+			missing = append(missing, audioDisplayPath(file))
+		}
+	}
+	if len(missing) > 0 {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Missing audio files: "+strings.Join(missing, ", "))
+		return
+	}
+
+	wav, err := renderAnnouncementPreview(announcementType, parameters, files)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to render preview: "+err.Error())
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", "preview_"+string(announcementType)+".wav"))
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Preview-Clips", fmt.Sprint(len(files)))
+	c.Data(http.StatusOK, "audio/wav", wav)
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"container/heap"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -30,6 +32,14 @@ const (
 	TypeEmergency   AnnouncementType = "emergency"
 	TypeLightning   AnnouncementType = "lightning"
 	TypeMaintenance AnnouncementType = "maintenance"
+	TypeServiceChange AnnouncementType = "service_change"
+	TypeDelay         AnnouncementType = "delay"
+	TypeCancellation  AnnouncementType = "cancellation"
+	TypeWeather       AnnouncementType = "weather"
+	TypeClock         AnnouncementType = "clock"
+	TypeCountdown     AnnouncementType = "countdown"
+	TypeLivePage      AnnouncementType = "live_page"
+	TypeAdhoc         AnnouncementType = "adhoc"
 )
 
 // AnnouncementStatus defines the current status of an announcement
@@ -41,6 +51,8 @@ const (
 	StatusCompleted AnnouncementStatus = "completed"
 	StatusCancelled AnnouncementStatus = "cancelled"
 	StatusFailed    AnnouncementStatus = "failed"
+	StatusExpired   AnnouncementStatus = "expired"
+	StatusSuppressed AnnouncementStatus = "suppressed"
 )
 
 // Announcement represents a single announcement in the queue
@@ -57,9 +69,19 @@ type Announcement struct {
 	AudioFiles  []string              `json:"audio_files"`
 	Duration    time.Duration         `json:"duration,omitempty"`
 	Error       string                `json:"error,omitempty"`
+	ExpiresAt   *time.Time            `json:"expires_at,omitempty"`
+	CallbackURL string                `json:"callback_url,omitempty"`
+	ArchiveFile string                `json:"archive_file,omitempty"` // Recording in the audio archive
+	RequestedBy string                `json:"requested_by,omitempty"` // "user:<id>" or "key:<id>" for manual announcements
+	EstimatedDuration time.Duration   `json:"estimated_duration,omitempty"` // Expected length from the clips, set when queued
+	Caption     string                `json:"caption,omitempty"`      // The words spoken, for display boards
+	Simulated   bool                  `json:"simulated,omitempty"`    // Logged instead of played, in maintenance mode
+	Requeued    bool                  `json:"requeued,omitempty"`     // Queued again once after a transient audio error
+	ETA         *time.Time            `json:"eta,omitempty"`          // Expected start, in the queue status only
 	
 	// Internal fields for queue management
-	index int // Index in the heap
+	index     int  // Index in the heap
+	stormHeld bool // Already logged as held back by the lightning storm policy
 }
 
 // AnnouncementQueue is a priority queue for managing announcements
@@ -106,6 +128,8 @@ type AnnouncementManager struct {
 	history         []*Announcement
 	mutex           sync.RWMutex
 	playing         *Announcement
+	recovering      bool // The playback watchdog is resetting the audio output
+	lastFinished    time.Time // When the last announcement stopped playing, for spacing_ms
 	stopChan        chan bool
 	cancelChan      chan bool
 	isRunning       bool
@@ -133,7 +157,7 @@ func InitializeAnnouncementManager() {
 	heap.Init(announcementManager.queue)
 	
 	// Start the announcement processor
-	go announcementManager.processQueue()
+	superviseGoroutine("announcement queue", announcementManager.processQueue)
 	log.Printf("Announcement manager initialized with queuing system")
 }
 
@@ -143,8 +167,20 @@ func (am *AnnouncementManager) generateID() string {
 	return fmt.Sprintf("ann_%d_%d", time.Now().Unix(), am.nextID)
 }
 
+// AnnouncementOptions holds optional per-announcement delivery settings
+type AnnouncementOptions struct {
+	CallbackURL string     // Receives a signed POST when the announcement finishes
+	ExpiresAt   *time.Time // Drop the announcement if it has not started by then
+	RequestedBy string     // Admin user or API key that asked for it, for quotas
+}
+
 // QueueAnnouncement adds a new announcement to the queue
 func (am *AnnouncementManager) QueueAnnouncement(announcementType AnnouncementType, priority AnnouncementPriority, parameters map[string]interface{}, scheduledAt time.Time) (*Announcement, error) {
+	return am.QueueAnnouncementWithOptions(announcementType, priority, parameters, scheduledAt, AnnouncementOptions{})
+}
+
+// QueueAnnouncementWithOptions adds a new announcement with a callback URL and/or expiry
+func (am *AnnouncementManager) QueueAnnouncementWithOptions(announcementType AnnouncementType, priority AnnouncementPriority, parameters map[string]interface{}, scheduledAt time.Time, options AnnouncementOptions) (*Announcement, error) {
 	am.mutex.Lock()
 	defer am.mutex.Unlock()
 	
@@ -156,21 +192,56 @@ func (am *AnnouncementManager) QueueAnnouncement(announcementType AnnouncementTy
 		CreatedAt:   time.Now(),
 		ScheduledAt: scheduledAt,
 		Parameters:  parameters,
+		ExpiresAt:   options.ExpiresAt,
+		CallbackURL: options.CallbackURL,
+		RequestedBy: options.RequestedBy,
+	}
+	
+	// Types switched off by an operator aren't queued at all
+	if err := checkAnnouncementSwitch(announcementType); err != nil {
+		return nil, err
+	}
+	
+	// Nor is anything but an emergency while a restart waits for the queue
+	if err := checkRestartDrain(announcementType, priority); err != nil {
+		return nil, err
+	}
+	
+	// Refuse unknown catalog IDs and unsafe path segments before building paths
+	if err := validateAnnouncementParameters(announcementType, parameters); err != nil {
+		return nil, err
 	}
 	
+	// Free text is spoken and captioned, so it goes through the content filter
+	if err := filterAnnouncementText(announcementType, parameters, options.RequestedBy); err != nil {
+		return nil, err
+	}
+	
+	// Announcements for a track play in that track's zones
+	applyTrackLayoutZones(parameters)
+	
 	// Build audio file paths based on announcement type
 	var err error
 	announcement.AudioFiles, err = am.buildAudioSequence(announcementType, parameters)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build audio sequence: %v", err)
 	}
+	announcement.EstimatedDuration = expectedPlaybackDuration(announcement)
+	announcement.Caption = announcementCaption(announcementType, parameters)
 	
 	// Add to queue
 	heap.Push(announcementManager.queue, announcement)
+	queueEvents.publish()
 	
 	log.Printf("Queued announcement: ID=%s, Type=%s, Priority=%d, Scheduled=%s", 
 		announcement.ID, announcement.Type, announcement.Priority, announcement.ScheduledAt.Format(time.RFC3339))
 	
+	// Emergency and lightning announcements also alert people off site
+	if announcementType == TypeEmergency || announcementType == TypeLightning {
+		snapshot := *announcement
+		goRecovered("announcement notification", func() { notifyAnnouncementQueued(&snapshot) })
+	}
+	
 	return announcement, nil
 }
 
@@ -181,39 +252,40 @@ func (am *AnnouncementManager) buildAudioSequence(announcementType AnnouncementT
 	log.Printf("DEBUG buildAudioSequence: Type=%s, Parameters=%+v", announcementType, parameters)
 	
 	switch announcementType {
-	case TypeStation:
-		// Station announcement sequence: chime + train + direction + destination + track
-		audioFiles = []string{
-			fmt.Sprintf("%s/chime.mp3", app.Config.MP3Dir),
-			fmt.Sprintf("%s/train/%s.mp3", app.Config.MP3Dir, parameters["train_number"]),
-			fmt.Sprintf("%s/direction/%s.mp3", app.Config.MP3Dir, parameters["direction"]),
-			fmt.Sprintf("%s/destination/%s.mp3", app.Config.MP3Dir, parameters["destination"]),
-			fmt.Sprintf("%s/track/%s.mp3", app.Config.MP3Dir, parameters["track_number"]),
+	case TypeStation, TypePromo, TypeServiceChange, TypeDelay, TypeCancellation:
+		// Composed from the segment templates in locales.json, once per selected language
+		localized, handled, err := localizedAudioSequence(announcementType, parameters)
+		if err != nil {
+			return nil, err
 		}
+		if !handled {
+			return nil, fmt.Errorf("no audio template configured for %s announcements", announcementType)
+		}
+		audioFiles = localized
 		
 	case TypeSafety:
 		// Safety announcement
-		language := parameters["language"].(string)
-		audioFiles = []string{
-			fmt.Sprintf("%s/safety/safety_%s.mp3", app.Config.MP3Dir, language),
+		language, ok := parameters["language"].(string)
+		if !ok || language == "" {
+			return nil, fmt.Errorf("safety announcement requires 'language' parameter")
 		}
-		
-	case TypePromo:
-		// Promotional announcement
-		file := parameters["file"].(string)
-		audioFiles = []string{
-			fmt.Sprintf("%s/promo/%s.mp3", app.Config.MP3Dir, file),
+		safetyFile, err := catalogAudioFile("safety", language)
+		if err != nil {
+			return nil, err
 		}
+		audioFiles = []string{safetyFile}
 		
 	case TypeEmergency:
 		// Emergency announcement (highest priority, audio files only)
-		if emergencyFile, ok := parameters["file"].(string); ok {
-			audioFiles = []string{
-				fmt.Sprintf("%s/emergency/%s.mp3", app.Config.MP3Dir, emergencyFile),
-			}
-		} else {
+		emergencyID, ok := parameters["file"].(string)
+		if !ok || emergencyID == "" {
 			return nil, fmt.Errorf("emergency announcement requires 'file' parameter")
 		}
+		emergencyFile, err := catalogAudioFile("emergencies", emergencyID)
+		if err != nil {
+			return nil, err
+		}
+		audioFiles = []string{emergencyFile}
 		
 	case TypeLightning:
 		// Lightning announcement (emergency priority, lightning audio files)
@@ -225,31 +297,76 @@ func (am *AnnouncementManager) buildAudioSequence(announcementType AnnouncementT
 		log.Printf("DEBUG: Lightning announcement for condition: %s", condition)
 		
 		// Build lightning-specific audio sequence based on condition
+		var clips []string
 		switch strings.ToLower(condition) {
 		case "redalert":
-			audioFiles = []string{
-				fmt.Sprintf("%s/lightning/thor_red_alert.mp3", app.Config.MP3Dir),   // Horn first
-				fmt.Sprintf("%s/lightning/redalert.mp3", app.Config.MP3Dir),        // Then announcement
-			}
+			clips = []string{"thor_red_alert", "redalert"} // Horn first, then announcement
 		case "allclear":
-			audioFiles = []string{
-				fmt.Sprintf("%s/lightning/thor_all_clear.mp3", app.Config.MP3Dir),  // Horn first
-				fmt.Sprintf("%s/lightning/all_clear.mp3", app.Config.MP3Dir),       // Then announcement
-			}
+			clips = []string{"thor_all_clear", "all_clear"} // Horn first, then announcement
 		case "warning":
-			audioFiles = []string{
-				fmt.Sprintf("%s/lightning/warning.mp3", app.Config.MP3Dir),         // Warning only
-			}
+			clips = []string{"warning"} // Warning only
 		default:
 			return nil, fmt.Errorf("unsupported lightning condition: %s", condition)
 		}
+		for _, clip := range clips {
+			file, err := resolveAudioPath("lightning/" + clip + ".mp3")
+			if err != nil {
+				return nil, err
+			}
+			audioFiles = append(audioFiles, file)
+		}
 		
 		log.Printf("DEBUG: Lightning audio sequence: %v", audioFiles)
 		
+	case TypeWeather:
+		// Weather report spoken by the TTS engine (normally already cached by
+		// queueWeatherAnnouncement, so the queue is not held up synthesizing)
+		text, _ := parameters["text"].(string)
+		if strings.TrimSpace(text) == "" {
+			return nil, fmt.Errorf("weather announcement requires 'text' parameter")
+		}
+		speech, err := synthesizeSpeech(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to synthesize weather report: %v", err)
+		}
+		audioFiles = []string{speech}
+		
+	case TypeCountdown:
+		// "The 3:30 train to Lakeview departs in five minutes", from the countdown template
+		localized, handled, err := localizedAudioSequence(announcementType, withCountdownUnit(parameters))
+		if err != nil {
+			return nil, err
+		}
+		if !handled {
+			return nil, fmt.Errorf("no audio template configured for %s announcements", announcementType)
+		}
+		audioFiles = localized
+		
+	case TypeAdhoc:
+		// One-off uploaded recording
+		path, err := adhocAudioPath(parameters)
+		if err != nil {
+			return nil, err
+		}
+		audioFiles = []string{path}
+		
+	case TypeClock:
+		// Hourly chime or spoken time
+		clockFiles, err := clockAudioSequence(parameters)
+		if err != nil {
+			return nil, err
+		}
+		audioFiles = clockFiles
+		
 	default:
 		return nil, fmt.Errorf("unsupported announcement type: %s", announcementType)
 	}
 	
+	// Prepend the attention chime selected for this type/zone
+	if chime := leadInSettingsFor(announcementType, parameters).chimePath(); chime != "" {
+		audioFiles = append([]string{chime}, audioFiles...)
+	}
+	
 	return audioFiles, nil
 }
 
@@ -276,13 +393,19 @@ func (am *AnnouncementManager) processNextAnnouncement() {
 	am.mutex.Lock()
 	defer am.mutex.Unlock()
 	
+	// Drop anything that missed its expiry, even while paused
+	am.expireStale()
+	am.suppressSwitchedOff()
+	am.suppressWhileStandby()
+	
 	// If paused, don't process any announcements
 	if am.isPaused {
 		return
 	}
 	
-	// If currently playing, don't start another
-	if am.playing != nil {
+	// If currently playing, or the watchdog is resetting the audio output,
+	// don't start another
+	if am.playing != nil || am.recovering {
 		return
 	}
 	
@@ -301,6 +424,20 @@ func (am *AnnouncementManager) processNextAnnouncement() {
 		return
 	}
 	
+	// Leave spacing_ms of silence after the last announcement; emergencies
+	// go straight out
+	if wait := am.spacingWait(next, time.Now()); wait > 0 {
+		heap.Push(am.queue, next)
+		return
+	}
+	
+	// During a lightning RedAlert, held types wait or are dropped while
+	// anything else behind them still plays
+	next = am.applyStormPolicy(next)
+	if next == nil {
+		return
+	}
+	
 	// Start playing the announcement
 	am.playing = next
 	next.Status = StatusPlaying
@@ -310,8 +447,40 @@ func (am *AnnouncementManager) processNextAnnouncement() {
 	log.Printf("Starting announcement: ID=%s, Type=%s, Priority=%d", 
 		next.ID, next.Type, next.Priority)
 	
-	// Play the announcement in a separate goroutine
-	go am.playAnnouncement(next)
+	queueEvents.publish()
+	
+	// Play the announcement in a separate goroutine. One that panics is
+	// failed, so the queue doesn't wait on it forever.
+	go func() {
+		defer func() {
+			if value := recover(); value != nil {
+				report := recordCrash("announcement playback", value, nil)
+				am.completeAnnouncement(next, now, "", false, fmt.Errorf("playback crashed (crash report %s)", report.ID))
+			}
+		}()
+		am.playAnnouncement(next)
+	}()
+}
+
+// announcementSpacing returns the silence to leave between announcements
+func announcementSpacing() time.Duration {
+	return time.Duration(currentRuntimeSettings().SpacingMs) * time.Millisecond
+}
+
+// spacesOut reports whether an announcement waits for the spacing after the
+// previous one; emergencies never do
+func spacesOut(announcement *Announcement) bool {
+	return announcement.Type != TypeEmergency && announcement.Priority < PriorityEmergency
+}
+
+// spacingWait returns how long next must still wait after the last
+// announcement finished. Caller must hold the mutex.
+func (am *AnnouncementManager) spacingWait(next *Announcement, now time.Time) time.Duration {
+	spacing := announcementSpacing()
+	if spacing <= 0 || am.lastFinished.IsZero() || !spacesOut(next) {
+		return 0
+	}
+	return am.lastFinished.Add(spacing).Sub(now)
 }
 
 // playAnnouncement plays a single announcement
@@ -326,14 +495,71 @@ func (am *AnnouncementManager) playAnnouncement(announcement *Announcement) {
 	
 	startTime := time.Now()
 	
-	// Play the audio sequence
-	err := am.playAnnouncementAudio(announcement.AudioFiles)
+	// In maintenance mode nothing reaches the speakers, relays or displays
+	if maintenanceActive() {
+		err := am.simulateAnnouncement(announcement)
+		am.completeAnnouncement(announcement, startTime, "", true, err)
+		return
+	}
 	
+	// Emergencies go up on the displays and strobe first, in case the audio fails
+	raiseVisualAlert(am.snapshotAnnouncement(announcement))
+	
+	// Pre-announcement hooks (amplifier relays and the like) run first; one
+	// set to abort that fails stops the announcement
+	hooks := announcementHooksFor(announcement)
+	err := runAnnouncementHooks(hooks, hookStagePre, am.snapshotAnnouncement(announcement), "")
+	
+	archiveFile := ""
+	if err == nil {
+		// Zone amplifiers are switched on and warmed up before the audio starts
+		releaseAmplifiers := powerZoneAmplifiers(amplifierZonesFor(announcement.Parameters))
+		
+		// Record what is played when the audio archive is on
+		var archive *audioArchiveWriter
+		if app.AudioEnabled && audioBackend != nil {
+			archive = startAudioArchive(announcement, audioBackend.SampleRate())
+		}
+		
+		// Play the audio sequence; the watchdog fails it if the output wedges
+		stopWatchdog := am.watchPlayback(announcement)
+		err = am.playAnnouncementAudio(announcement, archive)
+		stopWatchdog()
+		releaseAmplifiers()
+		
+		if archive != nil {
+			archiveFile = archive.Close()
+		}
+	}
+	
+	// Post hooks run however playback ended
+	runAnnouncementHooks(hooks, hookStagePost, am.snapshotAnnouncement(announcement), am.playbackOutcome(announcement, err))
+	
+	am.completeAnnouncement(announcement, startTime, archiveFile, false, err)
+}
+
+// completeAnnouncement records how playing (or simulating) an announcement
+// ended and frees the queue for the next one
+func (am *AnnouncementManager) completeAnnouncement(announcement *Announcement, startTime time.Time, archiveFile string, simulated bool, err error) {
 	am.mutex.Lock()
 	defer am.mutex.Unlock()
 	
+	announcement.ArchiveFile = archiveFile
+	announcement.Simulated = simulated
+	
+	// StopCurrent or the playback watchdog already finished this announcement
+	if announcement.CompletedAt != nil {
+		return
+	}
+	
+	// A transient audio error gives a high priority announcement one more go
+	if err != nil && !simulated && am.requeueAfterFailure(announcement, err) {
+		return
+	}
+	
 	// Update announcement status
 	now := time.Now()
+	am.lastFinished = now
 	announcement.CompletedAt = &now
 	announcement.Duration = now.Sub(startTime)
 	
@@ -347,59 +573,175 @@ func (am *AnnouncementManager) playAnnouncement(announcement *Announcement) {
 			announcement.ID, announcement.Duration.String())
 	}
 	
+	// A simulated announcement says nothing about the speakers
+	if !simulated {
+		snapshot := *announcement
+		goRecovered("playback result", func() { notePlaybackResult(&snapshot, err) })
+	}
+	
 	// Move to history
-	am.addToHistory(announcement)
+	am.finishAnnouncement(announcement)
 	
 	// Clear currently playing
-	am.playing = nil
+	if am.playing == announcement {
+		am.playing = nil
+	}
 }
 
 // playAnnouncementAudio plays the audio files for an announcement with proper synchronization and cancellation support
-func (am *AnnouncementManager) playAnnouncementAudio(audioFiles []string) error {
+func (am *AnnouncementManager) playAnnouncementAudio(announcement *Announcement, archive *audioArchiveWriter) error {
 	// Lock the global audio mutex to prevent any audio overlap
 	globalAudioMutex.Lock()
 	defer globalAudioMutex.Unlock()
 	
 	log.Printf("🔒 Audio mutex locked - starting announcement playback")
 	
-	for _, filePath := range audioFiles {
-		if !fileExists(filePath) {
-			log.Printf("Missing audio file: %s", filePath)
+	// Check for cancellation before starting playback
+	select {
+	case <-am.cancelChan:
+		log.Printf("🔓 Audio mutex unlocked - announcement cancelled")
+		return fmt.Errorf("announcement cancelled")
+	default:
+		// Continue with playback
+	}
+	
+	// The whole sequence is composed into one stream using the type's gap/crossfade template
+	// and the lead-in tone/envelope for its type or zone
+	sequence := sequenceSettingsFor(announcement.Type)
+	leadIn := leadInSettingsFor(announcement.Type, announcement.Parameters)
+	play := func(first bool) error {
+		// Only the first pass is archived
+		passArchive := archive
+		if !first {
+			passArchive = nil
+		}
+		return retryTransientAudio("Playing announcement "+announcement.ID, am.cancelChan, func() error {
+			return playSequenceWithCancellation(announcement.AudioFiles, sequence, leadIn, am.cancelChan, passArchive)
+		})
+	}
+	var err error
+	if devices := zoneOutputDevices(announcement.Parameters); len(devices) > 0 {
+		log.Printf("Routing announcement %s to zones %v (%d devices)", announcement.ID, announcementZones(announcement.Parameters), len(devices))
+		err = playOnZoneDevices(devices, play)
+	} else {
+		err = play(true)
+	}
+	if err != nil {
+		if errors.Is(err, errPlaybackCancelled) {
+			log.Printf("🔓 Audio mutex unlocked - announcement cancelled during playback")
+			return err
+		}
+		log.Printf("🔓 Audio mutex unlocked due to error")
+		return err
+	}
+	
+	log.Printf("🔓 Audio mutex unlocked - announcement playback complete")
+	return nil
+}
+
+// expireStale removes queued announcements whose expiry has passed.
+// Caller must hold the mutex.
+func (am *AnnouncementManager) expireStale() {
+	now := time.Now()
+	for i := 0; i < am.queue.Len(); {
+		announcement := (*am.queue)[i]
+		if announcement.ExpiresAt == nil || now.Before(*announcement.ExpiresAt) {
+			i++
 			continue
 		}
 		
-		// Check for cancellation before playing each file
-		select {
-		case <-am.cancelChan:
-			log.Printf("🔓 Audio mutex unlocked - announcement cancelled")
-			return fmt.Errorf("announcement cancelled")
-		default:
-			// Continue with playback
+		heap.Remove(am.queue, i)
+		announcement.Status = StatusExpired
+		announcement.CompletedAt = &now
+		announcement.Error = "announcement expired before it could be played"
+		log.Printf("Expired announcement: ID=%s", announcement.ID)
+		am.finishAnnouncement(announcement)
+		i = 0 // heap order changed, rescan
+	}
+}
+
+// applyStormPolicy returns the first announcement, starting with next, that
+// the lightning storm policy lets play now. Deferred announcements go back in
+// the queue and suppressed ones are finished. Caller must hold the mutex.
+func (am *AnnouncementManager) applyStormPolicy(next *Announcement) *Announcement {
+	var deferred []*Announcement
+	defer func() {
+		for _, announcement := range deferred {
+			heap.Push(am.queue, announcement)
+		}
+	}()
+	
+	for next != nil {
+		action := stormPolicyAction(next.Type)
+		if action == "" {
+			return next
 		}
 		
-		if err := playAudioWithCancellation(filePath, am.cancelChan); err != nil {
-			if err.Error() == "playback cancelled" {
-				log.Printf("🔓 Audio mutex unlocked - announcement cancelled during playback")
-				return err
+		if action == StormActionSuppress {
+			now := time.Now()
+			next.Status = StatusSuppressed
+			next.CompletedAt = &now
+			next.Error = describeStormHold(action)
+			log.Printf("⚡ Suppressed announcement during lightning RedAlert: ID=%s, Type=%s", next.ID, next.Type)
+			am.finishAnnouncement(next)
+		} else {
+			if !next.stormHeld {
+				next.stormHeld = true
+				log.Printf("⚡ Holding announcement until lightning AllClear: ID=%s, Type=%s", next.ID, next.Type)
 			}
-			log.Printf("🔓 Audio mutex unlocked due to error")
-			return fmt.Errorf("error playing %s: %v", filePath, err)
+			deferred = append(deferred, next)
 		}
 		
-		// Small gap between audio files (with cancellation check)
-		select {
-		case <-am.cancelChan:
-			log.Printf("🔓 Audio mutex unlocked - announcement cancelled during gap")
-			return fmt.Errorf("announcement cancelled")
-		case <-time.After(300 * time.Millisecond):
-			// Continue
+		next = nil
+		if am.queue.Len() > 0 {
+			candidate := heap.Pop(am.queue).(*Announcement)
+			if candidate.ScheduledAt.After(time.Now()) {
+				heap.Push(am.queue, candidate)
+			} else {
+				next = candidate
+			}
 		}
 	}
-	
-	log.Printf("🔓 Audio mutex unlocked - announcement playback complete")
 	return nil
 }
 
+// finishAnnouncement records a terminal state and notifies the callback URL.
+// Caller must hold the mutex.
+func (am *AnnouncementManager) finishAnnouncement(announcement *Announcement) {
+	am.addToHistory(announcement)
+	history := *announcement
+	goRecovered("announcement history", func() { recordAnnouncementHistory(history) })
+	queueEvents.publish()
+	if announcement.Type == TypeAdhoc {
+		removeAdhocAudio(announcement)
+	}
+	if announcement.CallbackURL != "" {
+		snapshot := *announcement
+		goRecovered("announcement webhook", func() { deliverAnnouncementWebhook(snapshot) })
+	}
+}
+
+// GetAnnouncement finds an announcement by ID in the playing slot, queue or history
+func (am *AnnouncementManager) GetAnnouncement(id string) (Announcement, bool) {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+	
+	if am.playing != nil && am.playing.ID == id {
+		return *am.playing, true
+	}
+	for _, announcement := range *am.queue {
+		if announcement.ID == id {
+			return *announcement, true
+		}
+	}
+	for i := len(am.history) - 1; i >= 0; i-- {
+		if am.history[i].ID == id {
+			return *am.history[i], true
+		}
+	}
+	return Announcement{}, false
+}
+
 // addToHistory adds an announcement to the history and manages history size
 func (am *AnnouncementManager) addToHistory(announcement *Announcement) {
 	am.history = append(am.history, announcement)
@@ -412,11 +754,17 @@ func (am *AnnouncementManager) addToHistory(announcement *Announcement) {
 
 // GetQueueStatus returns the current status of the announcement queue
 func (am *AnnouncementManager) GetQueueStatus() map[string]interface{} {
+	output := outputMeterStatus()
+	watchdog := currentWatchdogStatus()
+	switchedOff := switchedOffAnnouncements()
+	maintenanceMode := maintenanceStatus()
+	drain := currentRestartDrain()
+
 	am.mutex.RLock()
 	defer am.mutex.RUnlock()
 	
-	queueItems := make([]*Announcement, len(*am.queue))
-	copy(queueItems, *am.queue)
+	// Queued announcements in play order, with their expected start
+	queueItems, estimate := am.estimateQueue(time.Now())
 	
 	return map[string]interface{}{
 		"queue_length":    len(*am.queue),
@@ -425,9 +773,33 @@ func (am *AnnouncementManager) GetQueueStatus() map[string]interface{} {
 		"history_count":   len(am.history),
 		"is_running":      am.isRunning,
 		"is_paused":       am.isPaused,
+		"output":          output,
+		"watchdog":        watchdog,
+		"recovering":      am.recovering,
+		"estimate":        estimate,
+		"switched_off":    switchedOff,
+		"maintenance":     maintenanceMode,
+		"restart_drain":   drain,
 	}
 }
 
+// CountActiveBy counts the queued and playing announcements a requester asked for
+func (am *AnnouncementManager) CountActiveBy(requester string) int {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+
+	count := 0
+	if am.playing != nil && am.playing.RequestedBy == requester {
+		count++
+	}
+	for _, announcement := range *am.queue {
+		if announcement.RequestedBy == requester {
+			count++
+		}
+	}
+	return count
+}
+
 // GetHistory returns the announcement history
 func (am *AnnouncementManager) GetHistory(limit int) []*Announcement {
 	am.mutex.RLock()
@@ -467,7 +839,7 @@ func (am *AnnouncementManager) CancelAnnouncement(id string) error {
 				heap.Remove(am.queue, i)
 				
 				// Add to history
-				am.addToHistory(announcement)
+				am.finishAnnouncement(announcement)
 				
 				log.Printf("Cancelled announcement: ID=%s", id)
 				return nil
@@ -532,7 +904,10 @@ func (am *AnnouncementManager) StopCurrent() {
 		}
 		
 		am.playing.Status = StatusCancelled
-		am.addToHistory(am.playing)
+		now := time.Now()
+		am.playing.CompletedAt = &now
+		am.lastFinished = now
+		am.finishAnnouncement(am.playing)
 		am.playing = nil
 	} else {
 		log.Printf("No announcement currently playing")
@@ -573,4 +948,26 @@ func (p AnnouncementPriority) String() string {
 	default:
 		return "normal"
 	}
-}
\ No newline at end of file
+}
+// NowPlaying returns copies of the playing announcement (nil if idle) and the
+// queued announcements in the order they will play
+func (am *AnnouncementManager) NowPlaying() (*Announcement, []Announcement) {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+
+	var playing *Announcement
+	if am.playing != nil {
+		snapshot := *am.playing
+		playing = &snapshot
+	}
+
+	ordered := make(AnnouncementQueue, len(*am.queue))
+	copy(ordered, *am.queue)
+	sort.Slice(ordered, func(i, j int) bool { return ordered.Less(i, j) })
+
+	upcoming := make([]Announcement, 0, len(ordered))
+	for _, announcement := range ordered {
+		upcoming = append(upcoming, *announcement)
+	}
+	return playing, upcoming
+}
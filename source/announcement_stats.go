@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The queue keeps only the last few announcements in memory, so every
+// finished announcement is also appended to a daily file in the log
+// directory, history/<date>.jsonl, one record per line. The statistics
+// endpoint aggregates those files: plays per type and per day, week or
+// month, the busiest hours, average queue wait, failure rates and the most
+// played promos, for the admin dashboard and monthly operations reports.
+// The storage monitor applies the "history" retention to the files.
+
+// Longest range a statistics request may cover
+const maxStatisticsDays = 400
+
+// historyRecord is one finished announcement in the history files
+type historyRecord struct {
+	ID          string               `json:"id"`
+	Type        AnnouncementType     `json:"type"`
+	Priority    AnnouncementPriority `json:"priority"`
+	Status      AnnouncementStatus   `json:"status"`
+	CreatedAt   time.Time            `json:"created_at"`
+	ScheduledAt time.Time            `json:"scheduled_at"`
+	StartedAt   *time.Time           `json:"started_at,omitempty"`
+	CompletedAt *time.Time           `json:"completed_at,omitempty"`
+	DurationMs  int64                `json:"duration_ms,omitempty"`
+	File        string               `json:"file,omitempty"` // Promo played
+	RequestedBy string               `json:"requested_by,omitempty"`
+	Simulated   bool                 `json:"simulated,omitempty"`
+	Requeued    bool                 `json:"requeued,omitempty"`
+	Error       string               `json:"error,omitempty"`
+}
+
+// queueWait is how long an announcement waited to start after it was due
+func (r historyRecord) queueWait() (time.Duration, bool) {
+	if r.StartedAt == nil {
+		return 0, false
+	}
+	due := r.CreatedAt
+	if r.ScheduledAt.After(due) {
+		due = r.ScheduledAt
+	}
+	wait := r.StartedAt.Sub(due)
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
+
+var historyFileMutex sync.Mutex
+
+// announcementHistoryDir holds the daily history files
+func announcementHistoryDir() string {
+	return filepath.Join(app.Config.LogDir, "history")
+}
+
+// recordAnnouncementHistory appends a finished announcement to its day's
+// history file
+func recordAnnouncementHistory(announcement Announcement) {
+	record := historyRecord{
+		ID:          announcement.ID,
+		Type:        announcement.Type,
+		Priority:    announcement.Priority,
+		Status:      announcement.Status,
+		CreatedAt:   announcement.CreatedAt,
+		ScheduledAt: announcement.ScheduledAt,
+		StartedAt:   announcement.StartedAt,
+		CompletedAt: announcement.CompletedAt,
+		DurationMs:  announcement.Duration.Milliseconds(),
+		RequestedBy: announcement.RequestedBy,
+		Simulated:   announcement.Simulated,
+		Requeued:    announcement.Requeued,
+		Error:       announcement.Error,
+	}
+	if announcement.Type == TypePromo {
+		record.File, _ = announcement.Parameters["file"].(string)
+	}
+	finished := time.Now()
+	if record.CompletedAt != nil {
+		finished = *record.CompletedAt
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Warning: failed to record announcement %s in the history: %v", record.ID, err)
+		return
+	}
+
+	historyFileMutex.Lock()
+	defer historyFileMutex.Unlock()
+	if err := os.MkdirAll(announcementHistoryDir(), 0755); err != nil {
+		log.Printf("Warning: failed to record announcement %s in the history: %v", record.ID, err)
+		return
+	}
+	path := filepath.Join(announcementHistoryDir(), finished.Format("2006-01-02")+".jsonl")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Warning: failed to record announcement %s in the history: %v", record.ID, err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Printf("Warning: failed to record announcement %s in the history: %v", record.ID, err)
+	}
+}
+
+// readAnnouncementHistory returns the records of the days from first to last,
+// inclusive. Unreadable lines are skipped.
+func readAnnouncementHistory(first, last time.Time) ([]historyRecord, error) {
+	historyFileMutex.Lock()
+	defer historyFileMutex.Unlock()
+
+	var records []historyRecord
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		file, err := os.Open(filepath.Join(announcementHistoryDir(), day.Format("2006-01-02")+".jsonl"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var record historyRecord
+			if json.Unmarshal(scanner.Bytes(), &record) == nil {
+				records = append(records, record)
+			}
+		}
+		file.Close()
+	}
+	return records, nil
+}
+
+// playCounts tallies one group of announcements
+type playCounts struct {
+	Total       int     `json:"total"`
+	Completed   int     `json:"completed"`
+	Failed      int     `json:"failed"`
+	Cancelled   int     `json:"cancelled"`
+	Expired     int     `json:"expired"`
+	Suppressed  int     `json:"suppressed"`
+	Simulated   int     `json:"simulated"`
+	FailureRate float64 `json:"failure_rate"` // Failed share of those that were played, 0-1
+	AverageWait float64 `json:"average_wait_seconds"`
+
+	waitTotal time.Duration
+	waited    int
+}
+
+func (p *playCounts) add(record historyRecord) {
+	p.Total++
+	switch record.Status {
+	case StatusCompleted:
+		p.Completed++
+	case StatusFailed:
+		p.Failed++
+	case StatusCancelled:
+		p.Cancelled++
+	case StatusExpired:
+		p.Expired++
+	case StatusSuppressed:
+		p.Suppressed++
+	}
+	if record.Simulated {
+		p.Simulated++
+	}
+	if wait, ok := record.queueWait(); ok {
+		p.waitTotal += wait
+		p.waited++
+	}
+}
+
+func (p *playCounts) finish() {
+	if played := p.Completed + p.Failed; played > 0 {
+		p.FailureRate = roundTo(float64(p.Failed)/float64(played), 4)
+	}
+	if p.waited > 0 {
+		p.AverageWait = roundTo(p.waitTotal.Seconds()/float64(p.waited), 1)
+	}
+}
+
+// statisticsPeriod is one day, week or month of the report
+type statisticsPeriod struct {
+	Start  string                           `json:"start"`
+	Counts *playCounts                      `json:"counts"`
+	ByType map[AnnouncementType]*playCounts `json:"by_type"`
+}
+
+// periodStart returns the first day of the period holding day
+func periodStart(day time.Time, period string) time.Time {
+	switch period {
+	case "week":
+		// Weeks start on Monday
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case "month":
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, day.Location())
+	}
+	return day
+}
+
+// announcementStatistics aggregates the records between first and last
+func announcementStatistics(records []historyRecord, first, last time.Time, period string) gin.H {
+	totals := &playCounts{}
+	byType := make(map[AnnouncementType]*playCounts)
+	var hours [24]int
+	promos := make(map[string]int)
+	periodsByStart := make(map[string]*statisticsPeriod)
+	var periods []*statisticsPeriod
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		start := periodStart(day, period).Format("2006-01-02")
+		if periodsByStart[start] == nil {
+			entry := &statisticsPeriod{Start: start, Counts: &playCounts{}, ByType: make(map[AnnouncementType]*playCounts)}
+			periodsByStart[start] = entry
+			periods = append(periods, entry)
+		}
+	}
+
+	for _, record := range records {
+		totals.add(record)
+		if byType[record.Type] == nil {
+			byType[record.Type] = &playCounts{}
+		}
+		byType[record.Type].add(record)
+
+		finished := record.CreatedAt.Local()
+		if record.CompletedAt != nil {
+			finished = record.CompletedAt.Local()
+		}
+		day := time.Date(finished.Year(), finished.Month(), finished.Day(), 0, 0, 0, 0, time.Local)
+		if entry := periodsByStart[periodStart(day, period).Format("2006-01-02")]; entry != nil {
+			entry.Counts.add(record)
+			if entry.ByType[record.Type] == nil {
+				entry.ByType[record.Type] = &playCounts{}
+			}
+			entry.ByType[record.Type].add(record)
+		}
+
+		// Only announcements that reached the speakers count toward the hours and promos
+		if record.StartedAt == nil || record.Status != StatusCompleted {
+			continue
+		}
+		hours[record.StartedAt.Local().Hour()]++
+		if record.File != "" {
+			promos[record.File]++
+		}
+	}
+
+	totals.finish()
+	for _, counts := range byType {
+		counts.finish()
+	}
+	for _, entry := range periods {
+		entry.Counts.finish()
+		for _, counts := range entry.ByType {
+			counts.finish()
+		}
+	}
+
+	busiest := make([]gin.H, 0, 24)
+	for hour, plays := range hours {
+		if plays > 0 {
+			busiest = append(busiest, gin.H{"hour": hour, "plays": plays})
+		}
+	}
+	sort.SliceStable(busiest, func(i, j int) bool { return busiest[i]["plays"].(int) > busiest[j]["plays"].(int) })
+	if len(busiest) > 5 {
+		busiest = busiest[:5]
+	}
+
+	topPromos := make([]gin.H, 0, len(promos))
+	for file, plays := range promos {
+		topPromos = append(topPromos, gin.H{"file": file, "plays": plays})
+	}
+	sort.Slice(topPromos, func(i, j int) bool {
+		if topPromos[i]["plays"].(int) != topPromos[j]["plays"].(int) {
+			return topPromos[i]["plays"].(int) > topPromos[j]["plays"].(int)
+		}
+		return topPromos[i]["file"].(string) < topPromos[j]["file"].(string)
+	})
+	if len(topPromos) > 10 {
+		topPromos = topPromos[:10]
+	}
+
+	return gin.H{
+		"from":          first.Format("2006-01-02"),
+		"to":            last.Format("2006-01-02"),
+		"period":        period,
+		"totals":        totals,
+		"by_type":       byType,
+		"periods":       periods,
+		"plays_by_hour": hours,
+		"busiest_hours": busiest,
+		"top_promos":    topPromos,
+	}
+}
+
+// roundTo rounds value to a number of decimal places
+func roundTo(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
+
+// statisticsDay parses a YYYY-MM-DD query value as local midnight
+func statisticsDay(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// dateRangeQuery reads the ?from= and ?to= dates of a history request, by
+// default the days up to today. It writes the error response itself.
+func dateRangeQuery(c *gin.Context, defaultDays int) (time.Time, time.Time, bool) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	last, err := statisticsDay(c.Query("to"), today)
+	if err != nil {
+		respondValidationError(c, "Invalid date range", FieldError{Field: "to", Message: "must be a date (YYYY-MM-DD)"})
+		return time.Time{}, time.Time{}, false
+	}
+	first, err := statisticsDay(c.Query("from"), last.AddDate(0, 0, 1-defaultDays))
+	if err != nil {
+		respondValidationError(c, "Invalid date range", FieldError{Field: "from", Message: "must be a date (YYYY-MM-DD)"})
+		return time.Time{}, time.Time{}, false
+	}
+	if first.After(last) {
+		respondValidationError(c, "Invalid date range", FieldError{Field: "from", Message: "must not be after to"})
+		return time.Time{}, time.Time{}, false
+	}
+	if last.Sub(first) > maxStatisticsDays*24*time.Hour {
+		respondValidationError(c, "Invalid date range", FieldError{Field: "from", Message: fmt.Sprintf("range must be at most %d days", maxStatisticsDays)})
+		return time.Time{}, time.Time{}, false
+	}
+	return first, last, true
+}
+
+// Handlers
+
+// apiAnnouncementStatisticsHandler reports statistics for a date range:
+// ?from= and ?to= (YYYY-MM-DD, default the last 7 days) and ?period= day,
+// week or month
+func apiAnnouncementStatisticsHandler(c *gin.Context) {
+	first, last, ok := dateRangeQuery(c, 7)
+	if !ok {
+		return
+	}
+	period := strings.ToLower(c.DefaultQuery("period", "day"))
+	if period != "day" && period != "week" && period != "month" {
+		respondValidationError(c, "Invalid statistics period", FieldError{Field: "period", Message: "must be day, week or month"})
+		return
+	}
+
+	records, err := readAnnouncementHistory(first, last)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to read announcement history: "+err.Error())
+		return
+	}
+	respondOK(c, announcementStatistics(records, first, last, period))
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Announcement switches turn a whole type of announcement off, e.g. promos
+// during a private charter, without touching the schedule. While a type is
+// off, new announcements of it are refused when queued (scheduled ones are
+// logged and skipped) and any already waiting are suppressed instead of
+// played. A switch can be set to turn back on by itself at a given time.
+// Emergency and lightning announcements can't be switched off. Switches are
+// kept in announcement_switches.json so a restart doesn't turn them back on.
+
+// Announcement types that can be switched off
+var switchableAnnouncementTypes = []AnnouncementType{
+	TypeStation, TypeSafety, TypePromo, TypeServiceChange, TypeDelay, TypeCancellation,
+	TypeWeather, TypeClock, TypeCountdown, TypeAdhoc,
+}
+
+// AnnouncementSwitch records a type that is switched off
+type AnnouncementSwitch struct {
+	Type   AnnouncementType `json:"type"`
+	Reason string           `json:"reason,omitempty"`
+	Until  *time.Time       `json:"until,omitempty"` // Back on by itself then; off until switched on when unset
+	SetBy  string           `json:"set_by"`
+	SetAt  time.Time        `json:"set_at"`
+}
+
+// activeAt reports whether the switch still holds the type off at t
+func (s AnnouncementSwitch) activeAt(t time.Time) bool {
+	return s.Until == nil || t.Before(*s.Until)
+}
+
+// describe explains why announcements of the type aren't played
+func (s AnnouncementSwitch) describe() string {
+	message := fmt.Sprintf("%s announcements are switched off", s.Type)
+	if s.Reason != "" {
+		message += " (" + s.Reason + ")"
+	}
+	if s.Until != nil {
+		message += " until " + s.Until.Format("2006-01-02 15:04")
+	}
+	return message
+}
+
+// announcementDisabledError refuses an announcement of a switched-off type
+type announcementDisabledError struct {
+	setting AnnouncementSwitch
+}
+
+func (e *announcementDisabledError) Error() string {
+	return e.setting.describe()
+}
+
+var (
+	announcementSwitches      = make(map[AnnouncementType]AnnouncementSwitch)
+	announcementSwitchesMutex sync.Mutex
+)
+
+// loadAnnouncementSwitches restores the switched-off types after a restart,
+// dropping any whose time ran out while the annunciator was stopped
+func loadAnnouncementSwitches() error {
+	filePath, _ := jsonFilePath("announcement_switches")
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read announcement_switches.json: %v", err)
+	}
+
+	var switches []AnnouncementSwitch
+	if err := json.Unmarshal(data, &switches); err != nil {
+		return fmt.Errorf("failed to parse announcement_switches.json: %v", err)
+	}
+
+	announcementSwitchesMutex.Lock()
+	defer announcementSwitchesMutex.Unlock()
+	now := time.Now()
+	for _, setting := range switches {
+		if setting.activeAt(now) {
+			announcementSwitches[setting.Type] = setting
+			log.Printf("⛔ %s", setting.describe())
+		}
+	}
+	return nil
+}
+
+// saveAnnouncementSwitchesLocked writes the switched-off types; the caller
+// holds the mutex
+func saveAnnouncementSwitchesLocked() error {
+	return saveJSON("announcement_switches", announcementSwitchListLocked())
+}
+
+func announcementSwitchListLocked() []AnnouncementSwitch {
+	list := make([]AnnouncementSwitch, 0, len(announcementSwitches))
+	for _, setting := range announcementSwitches {
+		list = append(list, setting)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].SetAt.Before(list[j].SetAt)
+	})
+	return list
+}
+
+// announcementTypeSwitch returns the switch holding a type off, if any
+func announcementTypeSwitch(announcementType AnnouncementType) (AnnouncementSwitch, bool) {
+	announcementSwitchesMutex.Lock()
+	defer announcementSwitchesMutex.Unlock()
+	setting, ok := announcementSwitches[announcementType]
+	if !ok || !setting.activeAt(time.Now()) {
+		return AnnouncementSwitch{}, false
+	}
+	return setting, true
+}
+
+// checkAnnouncementSwitch refuses an announcement whose type is switched off
+func checkAnnouncementSwitch(announcementType AnnouncementType) error {
+	if setting, off := announcementTypeSwitch(announcementType); off {
+		return &announcementDisabledError{setting: setting}
+	}
+	return nil
+}
+
+// switchedOffAnnouncements lists the switches in force, oldest first
+func switchedOffAnnouncements() []AnnouncementSwitch {
+	announcementSwitchesMutex.Lock()
+	defer announcementSwitchesMutex.Unlock()
+	now := time.Now()
+	list := make([]AnnouncementSwitch, 0, len(announcementSwitches))
+	for _, setting := range announcementSwitchListLocked() {
+		if setting.activeAt(now) {
+			list = append(list, setting)
+		}
+	}
+	return list
+}
+
+// suppressSwitchedOff finishes queued announcements whose type was switched
+// off after they were queued. Caller must hold the mutex.
+func (am *AnnouncementManager) suppressSwitchedOff() {
+	for i := 0; i < am.queue.Len(); {
+		announcement := (*am.queue)[i]
+		setting, off := announcementTypeSwitch(announcement.Type)
+		if !off {
+			i++
+			continue
+		}
+
+		heap.Remove(am.queue, i)
+		now := time.Now()
+		announcement.Status = StatusSuppressed
+		announcement.CompletedAt = &now
+		announcement.Error = setting.describe()
+		log.Printf("⛔ Suppressed announcement, its type is switched off: ID=%s, Type=%s", announcement.ID, announcement.Type)
+		am.finishAnnouncement(announcement)
+		i = 0 // heap order changed, rescan
+	}
+}
+
+// startAnnouncementSwitchMonitor turns switches back on when their time is up
+func startAnnouncementSwitchMonitor() {
+	go func() {
+		for {
+			time.Sleep(30 * time.Second)
+			expireAnnouncementSwitches()
+		}
+	}()
+}
+
+// expireAnnouncementSwitches removes the switches whose time is up. They stop
+// holding their type off at that moment; this tidies the file and logs it.
+func expireAnnouncementSwitches() {
+	announcementSwitchesMutex.Lock()
+	defer announcementSwitchesMutex.Unlock()
+	now := time.Now()
+	changed := false
+	for announcementType, setting := range announcementSwitches {
+		if !setting.activeAt(now) {
+			delete(announcementSwitches, announcementType)
+			log.Printf("✅ %s announcements switched back on (timer)", announcementType)
+			changed = true
+		}
+	}
+	if changed {
+		if err := saveAnnouncementSwitchesLocked(); err != nil {
+			log.Printf("Warning: failed to save announcement_switches.json: %v", err)
+		}
+		queueEvents.publish()
+	}
+}
+
+// announcementSwitchView is one type's entry in the switch status
+func announcementSwitchView(announcementType AnnouncementType, now time.Time) gin.H {
+	view := gin.H{"type": announcementType, "enabled": true}
+	setting, off := announcementTypeSwitch(announcementType)
+	if !off {
+		return view
+	}
+	view["enabled"] = false
+	view["reason"] = setting.Reason
+	view["set_by"] = setting.SetBy
+	view["set_at"] = setting.SetAt
+	view["message"] = setting.describe()
+	if setting.Until != nil {
+		view["until"] = setting.Until
+		view["remaining_seconds"] = int(setting.Until.Sub(now).Seconds())
+	}
+	return view
+}
+
+// Handlers
+
+// apiListAnnouncementSwitchesHandler returns every switchable type and
+// whether it is on
+func apiListAnnouncementSwitchesHandler(c *gin.Context) {
+	now := time.Now()
+	views := make([]gin.H, 0, len(switchableAnnouncementTypes))
+	off := make([]AnnouncementType, 0)
+	for _, announcementType := range switchableAnnouncementTypes {
+		view := announcementSwitchView(announcementType, now)
+		if view["enabled"] == false {
+			off = append(off, announcementType)
+		}
+		views = append(views, view)
+	}
+	respondOK(c, gin.H{
+		"switches":     views,
+		"switched_off": off,
+	})
+}
+
+// apiSetAnnouncementSwitchHandler switches a type on or off. Switching off
+// takes an optional "reason", and "until" (a timestamp) or "hours" to switch
+// back on by itself; without either it stays off until switched on.
+func apiSetAnnouncementSwitchHandler(c *gin.Context) {
+	announcementType := AnnouncementType(c.Param("type"))
+	switchable := false
+	for _, candidate := range switchableAnnouncementTypes {
+		switchable = switchable || candidate == announcementType
+	}
+	if !switchable {
+		names := make([]string, len(switchableAnnouncementTypes))
+		for i, candidate := range switchableAnnouncementTypes {
+			names[i] = string(candidate)
+		}
+		respondError(c, http.StatusNotFound, ErrCodeNotFound,
+			fmt.Sprintf("'%s' can't be switched off. Switchable types: %s", announcementType, strings.Join(names, ", ")))
+		return
+	}
+
+	data, ok := bindRequestData(c, "enabled", "reason", "until", "hours")
+	if !ok {
+		return
+	}
+	enabled, ok := data["enabled"].(bool)
+	if !ok {
+		respondValidationError(c, "Invalid switch", FieldError{Field: "enabled", Message: "is required (true or false)"})
+		return
+	}
+
+	if enabled {
+		announcementSwitchesMutex.Lock()
+		_, wasOff := announcementSwitches[announcementType]
+		delete(announcementSwitches, announcementType)
+		err := saveAnnouncementSwitchesLocked()
+		announcementSwitchesMutex.Unlock()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save announcement switches: "+err.Error())
+			return
+		}
+		if wasOff {
+			log.Printf("✅ %s announcements switched back on by %s", announcementType, requestOperator(c))
+			queueEvents.publish()
+		}
+		respondSuccess(c, http.StatusOK, fmt.Sprintf("%s announcements switched on", announcementType),
+			gin.H{"switch": announcementSwitchView(announcementType, time.Now())})
+		return
+	}
+
+	var until *time.Time
+	if raw, ok := data["until"].(string); ok && raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			respondValidationError(c, "Invalid until", FieldError{Field: "until", Message: "must be an RFC 3339 timestamp"})
+			return
+		}
+		until = &parsed
+	} else if raw, ok := data["hours"]; ok && raw != "" && raw != nil {
+		hours, err := strconv.ParseFloat(fmt.Sprint(raw), 64)
+		if err != nil || hours <= 0 {
+			respondValidationError(c, "Invalid hours", FieldError{Field: "hours", Message: "must be a positive number"})
+			return
+		}
+		end := time.Now().Add(time.Duration(hours * float64(time.Hour)))
+		until = &end
+	}
+	if until != nil && !until.After(time.Now()) {
+		respondValidationError(c, "Invalid until", FieldError{Field: "until", Message: "must be in the future"})
+		return
+	}
+	reason, _ := data["reason"].(string)
+
+	setting := AnnouncementSwitch{
+		Type:   announcementType,
+		Reason: strings.TrimSpace(reason),
+		Until:  until,
+		SetBy:  requestOperator(c),
+		SetAt:  time.Now(),
+	}
+	announcementSwitchesMutex.Lock()
+	announcementSwitches[announcementType] = setting
+	err := saveAnnouncementSwitchesLocked()
+	announcementSwitchesMutex.Unlock()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save announcement switches: "+err.Error())
+		return
+	}
+	log.Printf("⛔ %s, by %s", setting.describe(), setting.SetBy)
+	queueEvents.publish()
+	respondSuccess(c, http.StatusOK, setting.describe(), gin.H{"switch": announcementSwitchView(announcementType, time.Now())})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Announcement parameters name audio files, so they are checked before an
+// announcement is queued: every value that ends up in a file path must be a
+// plain ID (no slashes or dots), and IDs that refer to a catalog must be in
+// it. A typo is then refused with the list of valid IDs instead of the
+// announcement playing with a gap where the missing clip should be. A catalog
+// with no entries is not checked, so installs that never set one up keep
+// working.
+
+// catalogParameters maps a parameter to the catalogs that may contain its
+// value, for every announcement type
+var catalogParameters = map[string][]string{
+	"train_number": {"trains", "trains-available"},
+	"direction":    {"directions"},
+	"destination":  {"destinations", "destinations-available"},
+	"reason":       {"service-change-reasons"},
+}
+
+// Track parameters are checked against trackChoices, which follows the track layout
+var trackParameters = []string{"track_number", "new_track", "old_track"}
+
+// typeCatalogParameters adds parameters whose catalog depends on the type
+var typeCatalogParameters = map[AnnouncementType]map[string][]string{
+	TypePromo:     {"file": {"promos"}},
+	TypeSafety:    {"language": {"safety"}},
+	TypeEmergency: {"file": {"emergencies"}},
+}
+
+// Types whose parameters are catalog IDs; the rest (clock, weather, adhoc,
+// lightning...) are checked by their own audio builders
+var catalogCheckedTypes = map[AnnouncementType]bool{
+	TypeStation: true, TypePromo: true, TypeServiceChange: true, TypeDelay: true,
+	TypeCancellation: true, TypeCountdown: true, TypeSafety: true, TypeEmergency: true,
+}
+
+// announcementParameterError lists the parameters that failed validation
+type announcementParameterError struct {
+	details []FieldError
+}
+
+func (e *announcementParameterError) Error() string {
+	messages := make([]string, len(e.details))
+	for i, detail := range e.details {
+		messages[i] = detail.Field + " " + detail.Message
+	}
+	return "invalid announcement parameters: " + strings.Join(messages, "; ")
+}
+
+// validPathSegment reports whether a parameter value is safe to use as part
+// of an audio file name
+func validPathSegment(value string) bool {
+	return catalogIDPattern.MatchString(value)
+}
+
+// catalogIDs returns the IDs in the named catalogs
+func catalogIDs(catalogs []string) map[string]bool {
+	ids := make(map[string]bool)
+	for _, catalog := range catalogs {
+		for id := range catalogNames(catalog) {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// unknownIDMessage explains a value missing from a catalog, listing the valid IDs
+func unknownIDMessage(value string, ids map[string]bool) string {
+	known := make([]string, 0, len(ids))
+	for id := range ids {
+		known = append(known, id)
+	}
+	sort.Strings(known)
+	if len(known) > 20 {
+		known = append(known[:20], "...")
+	}
+	return fmt.Sprintf("unknown ID '%s'. Available: %s", value, strings.Join(known, ", "))
+}
+
+// validateAnnouncementParameters checks an announcement's catalog IDs and
+// the values that become part of file paths
+func validateAnnouncementParameters(announcementType AnnouncementType, parameters map[string]interface{}) error {
+	if !catalogCheckedTypes[announcementType] {
+		return nil
+	}
+
+	var details []FieldError
+	check := func(name string, ids func() map[string]bool) {
+		raw, present := parameters[name]
+		if !present || raw == nil {
+			return
+		}
+		value := strings.TrimSpace(fmt.Sprint(raw))
+		if value == "" {
+			return
+		}
+		if !validPathSegment(value) {
+			details = append(details, FieldError{Field: name, Message: "must be an ID of letters, digits, '_' or '-'"})
+			return
+		}
+		if known := ids(); len(known) > 0 && !known[value] {
+			details = append(details, FieldError{Field: name, Message: unknownIDMessage(value, known)})
+		}
+	}
+
+	names := make([]string, 0, len(catalogParameters))
+	for name := range catalogParameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		catalogs := catalogParameters[name]
+		check(name, func() map[string]bool { return catalogIDs(catalogs) })
+	}
+	for _, name := range trackParameters {
+		check(name, func() map[string]bool {
+			ids := make(map[string]bool)
+			for _, track := range trackChoices() {
+				ids[track.ID] = true
+			}
+			return ids
+		})
+	}
+	for name, catalogs := range typeCatalogParameters[announcementType] {
+		catalogs := catalogs
+		check(name, func() map[string]bool { return catalogIDs(catalogs) })
+	}
+
+	if len(details) > 0 {
+		return &announcementParameterError{details: details}
+	}
+	return nil
+}
+
+// respondQueueError reports a failure to queue an announcement: invalid
+// parameters are the caller's mistake (422), a switched-off type is a 409 and
+// anything else is ours (500)
+func respondQueueError(c *gin.Context, message string, err error) {
+	if paramErr, ok := err.(*announcementParameterError); ok {
+		respondValidationError(c, "Invalid announcement parameters", paramErr.details...)
+		return
+	}
+	if _, ok := err.(*announcementDisabledError); ok {
+		respondError(c, http.StatusConflict, ErrCodeConflict, err.Error())
+		return
+	}
+	if _, ok := err.(*restartDrainError); ok {
+		c.Header("Retry-After", "60")
+		respondError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, err.Error())
+		return
+	}
+	respondError(c, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("%s: %v", message, err))
+}
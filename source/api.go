@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -15,17 +16,20 @@ func apiStatusHandler(c *gin.Context) {
 	platformInfo := getPlatformInfo()
 	devices := getAudioDevices()
 	
-	c.JSON(http.StatusOK, gin.H{
+	respondOK(c, gin.H{
 		"status":               "online",
 		"audio_available":      app.AudioEnabled,
-		"audio_backend":        "beep",
+		"audio_backend":        audioBackendName(),
 		"api_enabled":          app.Config.APIEnabled,
-		"scheduler_running":    true,
-		"volume":              int(app.Config.CurrentVolume * 100),
-		"selected_audio_device": app.Config.SelectedAudioDevice,
+		"api_version":          "v1",
+		"version":              appVersion,
+		"scheduler_running":    schedulerRunning(),
+		"clock":                currentClockStatus(),
+		"volume":              int(currentVolume() * 100),
+		"selected_audio_device": currentAudioDevice(),
 		"available_devices":    len(devices),
 		"platform":            platformInfo,
-		"timestamp":           time.Now().Format(time.RFC3339),
+		"maintenance_mode":     maintenanceActive(),
 	})
 }
 
@@ -34,33 +38,119 @@ func apiDocsHandler(c *gin.Context) {
 	c.HTML(http.StatusOK, "api_docs.html", nil)
 }
 
+// parseAnnouncementScheduling reads the optional priority and delay fields
+func parseAnnouncementScheduling(data map[string]interface{}, defaultPriority string) (AnnouncementPriority, time.Time, []FieldError) {
+	var details []FieldError
+
+	priorityStr := defaultPriority
+	if value, ok := data["priority"].(string); ok && value != "" {
+		switch value {
+		case "low", "normal", "high", "critical", "emergency":
+			priorityStr = value
+		default:
+			details = append(details, FieldError{Field: "priority", Message: "must be one of low, normal, high, critical, emergency"})
+		}
+	}
+
+	// Get scheduled time (default to immediate)
+	scheduledAt := time.Now()
+	var delaySeconds int
+	switch value := data["delay"].(type) {
+	case nil:
+	case float64:
+		delaySeconds = int(value)
+	case string:
+		if value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				details = append(details, FieldError{Field: "delay", Message: "must be a whole number of seconds"})
+			}
+			delaySeconds = parsed
+		}
+	default:
+		details = append(details, FieldError{Field: "delay", Message: "must be a whole number of seconds"})
+	}
+	if delaySeconds < 0 {
+		details = append(details, FieldError{Field: "delay", Message: "must not be negative"})
+	} else if delaySeconds > 0 {
+		scheduledAt = scheduledAt.Add(time.Duration(delaySeconds) * time.Second)
+	}
+
+	return ParsePriority(priorityStr), scheduledAt, details
+}
+
+// parseAnnouncementOptions reads the optional callback_url and expires_in fields
+func parseAnnouncementOptions(data map[string]interface{}) (AnnouncementOptions, []FieldError) {
+	var options AnnouncementOptions
+	var details []FieldError
+
+	if callbackURL, ok := data["callback_url"].(string); ok && callbackURL != "" {
+		if err := validateCallbackURL(callbackURL); err != nil {
+			details = append(details, FieldError{Field: "callback_url", Message: err.Error()})
+		} else {
+			options.CallbackURL = callbackURL
+		}
+	}
+
+	var expiresIn int
+	switch value := data["expires_in"].(type) {
+	case nil:
+	case float64:
+		expiresIn = int(value)
+	case string:
+		if value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				details = append(details, FieldError{Field: "expires_in", Message: "must be a whole number of seconds"})
+			}
+			expiresIn = parsed
+		}
+	default:
+		details = append(details, FieldError{Field: "expires_in", Message: "must be a whole number of seconds"})
+	}
+	if expiresIn < 0 {
+		details = append(details, FieldError{Field: "expires_in", Message: "must not be negative"})
+	} else if expiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second)
+		options.ExpiresAt = &expiresAt
+	}
+
+	return options, details
+}
+
+// requireAnnouncementManager writes a 503 when the queue is not running
+func requireAnnouncementManager(c *gin.Context) bool {
+	if announcementManager == nil {
+		respondError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "Announcement manager not initialized")
+		return false
+	}
+	return true
+}
+
 // Station Announcement API
 func apiStationAnnouncementHandler(c *gin.Context) {
-	var data map[string]interface{}
-	
-	// Handle both JSON and form data
-	if c.ContentType() == "application/json" {
-		if err := c.ShouldBindJSON(&data); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
-			return
-		}
-	} else {
-		data = make(map[string]interface{})
-		data["train_number"] = c.PostForm("train_number")
-		data["direction"] = c.PostForm("direction")
-		data["destination"] = c.PostForm("destination")
-		data["track_number"] = c.PostForm("track_number")
+	if !requireAnnouncementManager(c) {
+		return
+	}
+
+	data, ok := bindRequestData(c, "train_number", "direction", "destination", "track_number", "languages", "priority", "delay", "callback_url", "expires_in")
+	if !ok {
+		return
 	}
 
 	// Validate required fields
-	requiredFields := []string{"train_number", "direction", "destination", "track_number"}
-	for _, field := range requiredFields {
-		if val, exists := data[field]; !exists || val == "" {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Missing required field: " + field,
-			})
-			return
-		}
+	details := requiredStringFields(data, "train_number", "direction", "destination", "track_number")
+	priority, scheduledAt, schedulingErrors := parseAnnouncementScheduling(data, "normal")
+	details = append(details, schedulingErrors...)
+	options, optionErrors := parseAnnouncementOptions(data)
+	details = append(details, optionErrors...)
+	languages := languageList(data["languages"])
+	if err := validateLanguages(languages); err != nil {
+		details = append(details, FieldError{Field: "languages", Message: err.Error()})
+	}
+	if len(details) > 0 {
+		respondValidationError(c, "Invalid station announcement request", details...)
+		return
 	}
 
 	// Extract values
@@ -69,18 +159,6 @@ func apiStationAnnouncementHandler(c *gin.Context) {
 	destination := data["destination"].(string)
 	trackNumber := data["track_number"].(string)
 
-	// Get priority from request or default to normal
-	priorityStr := c.DefaultPostForm("priority", "normal")
-	priority := ParsePriority(priorityStr)
-	
-	// Get scheduled time (default to immediate)
-	scheduledAt := time.Now()
-	if delayStr := c.PostForm("delay"); delayStr != "" {
-		if delaySeconds, err := strconv.Atoi(delayStr); err == nil && delaySeconds > 0 {
-			scheduledAt = scheduledAt.Add(time.Duration(delaySeconds) * time.Second)
-		}
-	}
-
 	// Queue the announcement
 	parameters := map[string]interface{}{
 		"train_number": trainNumber,
@@ -88,19 +166,18 @@ func apiStationAnnouncementHandler(c *gin.Context) {
 		"destination":  destination,
 		"track_number": trackNumber,
 	}
+	if len(languages) > 0 {
+		parameters["languages"] = languages
+	}
 	
-	announcement, err := announcementManager.QueueAnnouncement(TypeStation, priority, parameters, scheduledAt)
+	options.RequestedBy = c.GetString("announcement_requester")
+	announcement, err := announcementManager.QueueAnnouncementWithOptions(TypeStation, priority, parameters, scheduledAt, options)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   fmt.Sprintf("Failed to queue announcement: %v", err),
-		})
+		respondQueueError(c, "Failed to queue announcement", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Station announcement queued",
+	respondSuccess(c, http.StatusAccepted, "Station announcement queued", gin.H{
 		"announcement": gin.H{
 			"id":           announcement.ID,
 			"type":         "station",
@@ -110,39 +187,40 @@ func apiStationAnnouncementHandler(c *gin.Context) {
 			"direction":    direction,
 			"destination":  destination,
 			"track_number": trackNumber,
+			"languages":    announcementLanguages(TypeStation, parameters),
 			"scheduled_at": announcement.ScheduledAt.Format(time.RFC3339),
 		},
-		"timestamp": time.Now().Format(time.RFC3339),
 	})
 }
 
 // Safety Announcement API
 func apiSafetyAnnouncementHandler(c *gin.Context) {
-	var data map[string]interface{}
-	
-	// Handle both JSON and form data
-	if c.ContentType() == "application/json" {
-		if err := c.ShouldBindJSON(&data); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
-			return
-		}
-	} else {
-		data = make(map[string]interface{})
-		data["language"] = c.PostForm("language")
+	if !requireAnnouncementManager(c) {
+		return
+	}
+
+	data, ok := bindRequestData(c, "language", "priority", "delay", "callback_url", "expires_in")
+	if !ok {
+		return
 	}
 
 	// Validate language field
-	language, exists := data["language"]
-	if !exists || language == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required field: language"})
+	details := requiredStringFields(data, "language")
+	priority, scheduledAt, schedulingErrors := parseAnnouncementScheduling(data, "high")
+	details = append(details, schedulingErrors...)
+	options, optionErrors := parseAnnouncementOptions(data)
+	details = append(details, optionErrors...)
+	if len(details) > 0 {
+		respondValidationError(c, "Invalid safety announcement request", details...)
 		return
 	}
+	language := data["language"].(string)
 
 	// Validate language exists
 	safetyLanguages := loadJSON("safety", []SafetyLanguage{}).([]SafetyLanguage)
 	validLanguage := false
 	for _, lang := range safetyLanguages {
-		if lang.ID == language.(string) {
+		if lang.ID == language {
 			validLanguage = true
 			break
 		}
@@ -153,41 +231,26 @@ func apiSafetyAnnouncementHandler(c *gin.Context) {
 		for i, lang := range safetyLanguages {
 			availableLanguages[i] = lang.ID
 		}
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid language '" + language.(string) + "'. Available: " + joinStrings(availableLanguages, ", "),
+		respondValidationError(c, "Invalid safety announcement request", FieldError{
+			Field:   "language",
+			Message: "unknown language '" + language + "'. Available: " + joinStrings(availableLanguages, ", "),
 		})
 		return
 	}
 
-	// Get priority from request or default to high (safety is important)
-	priorityStr := c.DefaultPostForm("priority", "high")
-	priority := ParsePriority(priorityStr)
-	
-	// Get scheduled time (default to immediate)
-	scheduledAt := time.Now()
-	if delayStr := c.PostForm("delay"); delayStr != "" {
-		if delaySeconds, err := strconv.Atoi(delayStr); err == nil && delaySeconds > 0 {
-			scheduledAt = scheduledAt.Add(time.Duration(delaySeconds) * time.Second)
-		}
-	}
-
 	// Queue the announcement
 	parameters := map[string]interface{}{
-		"language": language.(string),
+		"language": language,
 	}
 	
-	announcement, err := announcementManager.QueueAnnouncement(TypeSafety, priority, parameters, scheduledAt)
+	options.RequestedBy = c.GetString("announcement_requester")
+	announcement, err := announcementManager.QueueAnnouncementWithOptions(TypeSafety, priority, parameters, scheduledAt, options)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   fmt.Sprintf("Failed to queue announcement: %v", err),
-		})
+		respondQueueError(c, "Failed to queue announcement", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Safety announcement queued",
+	respondSuccess(c, http.StatusAccepted, "Safety announcement queued", gin.H{
 		"announcement": gin.H{
 			"id":           announcement.ID,
 			"type":         "safety",
@@ -196,37 +259,37 @@ func apiSafetyAnnouncementHandler(c *gin.Context) {
 			"language":     language,
 			"scheduled_at": announcement.ScheduledAt.Format(time.RFC3339),
 		},
-		"timestamp": time.Now().Format(time.RFC3339),
 	})
 }
 
 // Promo Announcement API
 func apiPromoAnnouncementHandler(c *gin.Context) {
-	var data map[string]interface{}
-	
-	// Handle both JSON and form data
-	if c.ContentType() == "application/json" {
-		if err := c.ShouldBindJSON(&data); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
-			return
-		}
-	} else {
-		data = make(map[string]interface{})
-		data["file"] = c.PostForm("file")
+	if !requireAnnouncementManager(c) {
+		return
+	}
+
+	data, ok := bindRequestData(c, "file", "priority", "delay", "callback_url", "expires_in")
+	if !ok {
+		return
 	}
 
 	// Validate file field
-	file, exists := data["file"]
-	if !exists || file == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required field: file"})
+	details := requiredStringFields(data, "file")
+	priority, scheduledAt, schedulingErrors := parseAnnouncementScheduling(data, "low")
+	details = append(details, schedulingErrors...)
+	options, optionErrors := parseAnnouncementOptions(data)
+	details = append(details, optionErrors...)
+	if len(details) > 0 {
+		respondValidationError(c, "Invalid promo announcement request", details...)
 		return
 	}
+	file := data["file"].(string)
 
 	// Validate promo file exists
 	promoAnnouncements := loadJSON("promo", []PromoAnnouncement{}).([]PromoAnnouncement)
 	validFile := false
 	for _, promo := range promoAnnouncements {
-		if promo.ID == file.(string) {
+		if promo.ID == file {
 			validFile = true
 			break
 		}
@@ -237,41 +300,26 @@ func apiPromoAnnouncementHandler(c *gin.Context) {
 		for i, promo := range promoAnnouncements {
 			availableFiles[i] = promo.ID
 		}
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid promo file '" + file.(string) + "'. Available: " + joinStrings(availableFiles, ", "),
+		respondValidationError(c, "Invalid promo announcement request", FieldError{
+			Field:   "file",
+			Message: "unknown promo '" + file + "'. Available: " + joinStrings(availableFiles, ", "),
 		})
 		return
 	}
 
-	// Get priority from request or default to low (promos are typically low priority)
-	priorityStr := c.DefaultPostForm("priority", "low")
-	priority := ParsePriority(priorityStr)
-	
-	// Get scheduled time (default to immediate)
-	scheduledAt := time.Now()
-	if delayStr := c.PostForm("delay"); delayStr != "" {
-		if delaySeconds, err := strconv.Atoi(delayStr); err == nil && delaySeconds > 0 {
-			scheduledAt = scheduledAt.Add(time.Duration(delaySeconds) * time.Second)
-		}
-	}
-
 	// Queue the announcement
 	parameters := map[string]interface{}{
-		"file": file.(string),
+		"file": file,
 	}
 	
-	announcement, err := announcementManager.QueueAnnouncement(TypePromo, priority, parameters, scheduledAt)
+	options.RequestedBy = c.GetString("announcement_requester")
+	announcement, err := announcementManager.QueueAnnouncementWithOptions(TypePromo, priority, parameters, scheduledAt, options)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   fmt.Sprintf("Failed to queue announcement: %v", err),
-		})
+		respondQueueError(c, "Failed to queue announcement", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Promo announcement queued",
+	respondSuccess(c, http.StatusAccepted, "Promo announcement queued", gin.H{
 		"announcement": gin.H{
 			"id":           announcement.ID,
 			"type":         "promo",
@@ -280,35 +328,106 @@ func apiPromoAnnouncementHandler(c *gin.Context) {
 			"file":         file,
 			"scheduled_at": announcement.ScheduledAt.Format(time.RFC3339),
 		},
-		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// Service Change API: a train moving to a different track
+func apiServiceChangeAnnouncementHandler(c *gin.Context) {
+	if !requireAnnouncementManager(c) {
+		return
+	}
+
+	data, ok := bindRequestData(c, "train_number", "old_track", "new_track", "reason", "languages", "priority", "delay", "callback_url", "expires_in")
+	if !ok {
+		return
+	}
+
+	details := requiredStringFields(data, "train_number", "old_track", "new_track")
+	priority, scheduledAt, schedulingErrors := parseAnnouncementScheduling(data, "high")
+	details = append(details, schedulingErrors...)
+	options, optionErrors := parseAnnouncementOptions(data)
+	details = append(details, optionErrors...)
+	languages := languageList(data["languages"])
+	if err := validateLanguages(languages); err != nil {
+		details = append(details, FieldError{Field: "languages", Message: err.Error()})
+	}
+
+	trainNumber, _ := data["train_number"].(string)
+	oldTrack, _ := data["old_track"].(string)
+	newTrack, _ := data["new_track"].(string)
+	reason, _ := data["reason"].(string)
+
+	// Tracks and reasons are spoken from their catalog recordings
+	tracks := catalogNames("tracks")
+	for _, field := range []string{"old_track", "new_track"} {
+		track, _ := data[field].(string)
+		if _, known := tracks[track]; track != "" && !known {
+			details = append(details, FieldError{Field: field, Message: "unknown track '" + track + "'"})
+		}
+	}
+	if oldTrack != "" && oldTrack == newTrack {
+		details = append(details, FieldError{Field: "new_track", Message: "must differ from old_track"})
+	}
+	if reason != "" {
+		if _, known := catalogNames("service-change-reasons")[reason]; !known {
+			details = append(details, FieldError{Field: "reason", Message: "unknown reason '" + reason + "'"})
+		}
+	}
+	if len(details) > 0 {
+		respondValidationError(c, "Invalid service change announcement request", details...)
+		return
+	}
+
+	parameters := map[string]interface{}{
+		"train_number": trainNumber,
+		"old_track":    oldTrack,
+		"new_track":    newTrack,
+		"reason":       reason,
+	}
+	if len(languages) > 0 {
+		parameters["languages"] = languages
+	}
+
+	options.RequestedBy = c.GetString("announcement_requester")
+	announcement, err := announcementManager.QueueAnnouncementWithOptions(TypeServiceChange, priority, parameters, scheduledAt, options)
+	if err != nil {
+		respondQueueError(c, "Failed to queue announcement", err)
+		return
+	}
+
+	respondSuccess(c, http.StatusAccepted, "Service change announcement queued", gin.H{
+		"announcement": gin.H{
+			"id":           announcement.ID,
+			"type":         string(TypeServiceChange),
+			"priority":     announcement.Priority.String(),
+			"status":       string(announcement.Status),
+			"train_number": trainNumber,
+			"old_track":    oldTrack,
+			"new_track":    newTrack,
+			"reason":       reason,
+			"scheduled_at": announcement.ScheduledAt.Format(time.RFC3339),
+		},
 	})
 }
 
 // Volume API handlers
 func apiGetVolumeHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"volume":         app.Config.CurrentVolume,
-		"volume_percent": int(app.Config.CurrentVolume * 100),
+	respondOK(c, gin.H{
+		"volume":          currentVolume(),
+		"volume_percent":  int(currentVolume() * 100),
+		"hardware_volume": hardwareVolumeStatus(),
 	})
 }
 
 func apiSetVolumeHandler(c *gin.Context) {
-	var data map[string]interface{}
-	
-	// Handle both JSON and form data
-	if c.ContentType() == "application/json" {
-		if err := c.ShouldBindJSON(&data); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
-			return
-		}
-	} else {
-		data = make(map[string]interface{})
-		data["volume"] = c.PostForm("volume")
+	data, ok := bindRequestData(c, "volume")
+	if !ok {
+		return
 	}
 
 	volumeVal, exists := data["volume"]
 	if !exists {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Volume parameter required (0.0 to 1.0 or 0 to 100)"})
+		respondValidationError(c, "Volume parameter required", FieldError{Field: "volume", Message: "is required (0.0 to 1.0 or 0 to 100)"})
 		return
 	}
 
@@ -320,15 +439,12 @@ func apiSetVolumeHandler(c *gin.Context) {
 		volume, err = strconv.ParseFloat(v, 64)
 	case float64:
 		volume = v
-	case int:
-		volume = float64(v)
 	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid volume value"})
-		return
+		err = fmt.Errorf("unsupported type")
 	}
 
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid volume value"})
+		respondValidationError(c, "Invalid volume value", FieldError{Field: "volume", Message: "must be a number (0.0 to 1.0 or 0 to 100)"})
 		return
 	}
 
@@ -344,49 +460,35 @@ func apiSetVolumeHandler(c *gin.Context) {
 		volume = 1.0
 	}
 
-	app.Config.CurrentVolume = volume
+	setCurrentVolume(volume)
+	persistVolume(volume)
 
-	c.JSON(http.StatusOK, gin.H{
-		"success":        true,
-		"volume":         app.Config.CurrentVolume,
-		"volume_percent": int(app.Config.CurrentVolume * 100),
+	respondSuccess(c, http.StatusOK, "Volume updated", gin.H{
+		"volume":         currentVolume(),
+		"volume_percent": int(currentVolume() * 100),
 	})
 }
 
 // Audio Device API handlers
 func apiGetAudioDevicesHandler(c *gin.Context) {
 	devices := getAudioDevices()
-	c.JSON(http.StatusOK, gin.H{
+	respondOK(c, gin.H{
 		"devices": devices,
-		"current_device": app.Config.SelectedAudioDevice,
+		"current_device": currentAudioDevice(),
 	})
 }
 
 func apiSetAudioDeviceHandler(c *gin.Context) {
-	var data map[string]interface{}
-	
-	// Handle both JSON and form data
-	if c.ContentType() == "application/json" {
-		if err := c.ShouldBindJSON(&data); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
-			return
-		}
-	} else {
-		data = make(map[string]interface{})
-		data["device_id"] = c.PostForm("device_id")
-	}
-
-	deviceID, exists := data["device_id"]
-	if !exists {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Device ID parameter required"})
+	data, ok := bindRequestData(c, "device_id")
+	if !ok {
 		return
 	}
 
-	deviceIDStr, ok := deviceID.(string)
-	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+	if details := requiredStringFields(data, "device_id"); len(details) > 0 {
+		respondValidationError(c, "Device ID parameter required", details...)
 		return
 	}
+	deviceIDStr := data["device_id"].(string)
 
 	// Validate device exists
 	devices := getAudioDevices()
@@ -401,22 +503,27 @@ func apiSetAudioDeviceHandler(c *gin.Context) {
 	}
 
 	if !validDevice {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Audio device not found: "+deviceIDStr)
 		return
 	}
 
 	// Set the device
 	if err := setAudioDevice(deviceIDStr); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set audio device: " + err.Error()})
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to set audio device: "+err.Error())
 		return
 	}
 
-	app.Config.SelectedAudioDevice = deviceIDStr
+	// Re-open the output so the new device takes effect
+	if err := reinitAudioForDevice(deviceIDStr); err != nil {
+		log.Printf("Warning: audio backend re-init after device change failed: %v", err)
+	}
+
+	setCurrentAudioDevice(deviceIDStr)
+	clearAudioFallback()
+	persistAudioDevice(deviceIDStr)
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
+	respondSuccess(c, http.StatusOK, "Audio device set successfully", gin.H{
 		"device": selectedDevice,
-		"message": "Audio device set successfully",
 	})
 }
 
@@ -425,11 +532,11 @@ func apiPlatformInfoHandler(c *gin.Context) {
 	platformInfo := getPlatformInfo()
 	devices := getAudioDevices()
 	
-	c.JSON(http.StatusOK, gin.H{
+	respondOK(c, gin.H{
 		"platform_info":     platformInfo,
 		"audio_devices":     devices,
-		"current_device":    app.Config.SelectedAudioDevice,
-		"audio_backend":     "beep (faiface/beep)",
+		"current_device":    currentAudioDevice(),
+		"audio_backend":     audioBackendName(),
 		"cross_platform":    true,
 	})
 }
@@ -439,12 +546,12 @@ func apiGetConfigHandler(c *gin.Context) {
 	trains := loadJSON("trains", []Train{}).([]Train)
 	directions := loadJSON("directions", []Direction{}).([]Direction)
 	destinations := loadJSON("destinations", []Destination{}).([]Destination)
-	tracks := loadJSON("tracks", []Track{}).([]Track)
+	tracks := trackChoices()
 	promoAnnouncements := loadJSON("promo", []PromoAnnouncement{}).([]PromoAnnouncement)
 	safetyLanguages := loadJSON("safety", []SafetyLanguage{}).([]SafetyLanguage)
 	emergencies := loadJSON("emergencies", []Emergency{}).([]Emergency)
 
-	c.JSON(http.StatusOK, gin.H{
+	respondOK(c, gin.H{
 		"trains":               trains,
 		"directions":           directions,
 		"destinations":         destinations,
@@ -458,20 +565,72 @@ func apiGetConfigHandler(c *gin.Context) {
 // Schedule API handlers
 func apiGetScheduleHandler(c *gin.Context) {
 	schedule := loadJSON("cron", CronData{}).(CronData)
-	c.JSON(http.StatusOK, gin.H{"schedule": schedule})
+	respondOK(c, gin.H{"schedule": schedule})
+}
+
+// validateSchedule checks the parts of a schedule with their own rules
+func validateSchedule(cronData CronData) []FieldError {
+	var details []FieldError
+	for i, item := range cronData.ClockAnnouncements {
+		if err := item.validate(); err != nil {
+			details = append(details, FieldError{Field: fmt.Sprintf("schedule.clock_announcements[%d]", i), Message: err.Error()})
+		}
+	}
+	details = append(details, scheduleProfileDetails(cronData)...)
+	if cronData.Timezone != "" {
+		if _, err := time.LoadLocation(cronData.Timezone); err != nil {
+			details = append(details, FieldError{Field: "schedule.timezone", Message: "must be an IANA time zone such as America/Chicago"})
+		}
+	}
+	for _, spec := range scheduleCronSpecs(cronData) {
+		if err := validateCronExpression(spec.cron); err != nil {
+			details = append(details, FieldError{Field: "schedule." + spec.entry + ".cron", Message: err.Error()})
+		}
+	}
+	details = append(details, cronData.Collisions.validate()...)
+	return append(details, cronData.Countdown.validate()...)
+}
+
+// scheduleCronSpec is the cron expression of a schedule entry
+type scheduleCronSpec struct {
+	entry, cron string
+}
+
+// scheduleCronSpecs lists the cron expressions of the enabled entries that
+// have one
+func scheduleCronSpecs(cronData CronData) []scheduleCronSpec {
+	var specs []scheduleCronSpec
+	add := func(section string, index int, enabled bool, cron string) {
+		if enabled {
+			specs = append(specs, scheduleCronSpec{scheduleEntryKey(section, index), cron})
+		}
+	}
+	for i, item := range cronData.StationAnnouncements {
+		add("station", i, item.Enabled, item.Cron)
+	}
+	for i, item := range cronData.PromoAnnouncements {
+		add("promo", i, item.Enabled, item.Cron)
+	}
+	for i, item := range cronData.SafetyAnnouncements {
+		add("safety", i, item.Enabled, item.Cron)
+	}
+	for i, item := range cronData.WeatherAnnouncements {
+		add("weather", i, item.Enabled, item.Cron)
+	}
+	return specs
 }
 
 func apiPostScheduleHandler(c *gin.Context) {
 	var data map[string]interface{}
 	
 	if err := c.ShouldBindJSON(&data); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
 		return
 	}
 
 	scheduleData, exists := data["schedule"]
 	if !exists {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Schedule data required"})
+		respondValidationError(c, "Schedule data required", FieldError{Field: "schedule", Message: "is required"})
 		return
 	}
 
@@ -479,38 +638,56 @@ func apiPostScheduleHandler(c *gin.Context) {
 	scheduleJSON, _ := json.Marshal(scheduleData)
 	var cronData CronData
 	if err := json.Unmarshal(scheduleJSON, &cronData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule data"})
+		respondValidationError(c, "Invalid schedule data", FieldError{Field: "schedule", Message: err.Error()})
+		return
+	}
+	if details := validateSchedule(cronData); len(details) > 0 {
+		respondValidationError(c, "Invalid schedule data", details...)
+		return
+	}
+
+	comment, _ := data["comment"].(string)
+	offsets, collisions := offsetScheduleCollisions(&cronData)
+
+	// Operators' edits wait for an admin to approve them when that is required
+	if scheduleNeedsApproval(c) {
+		change, err := submitScheduleChange(cronData, sessionUserID(c), comment)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to submit schedule change: "+err.Error())
+			return
+		}
+		view := scheduleChangeView(change)
+		view["collisions"] = collisions
+		view["offsets"] = offsets
+		respondSuccess(c, http.StatusAccepted, "Schedule change submitted for approval", view)
 		return
 	}
 
-	if err := saveJSON("cron", cronData); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule: " + err.Error()})
+	if err := saveJSONBy("cron", cronData, requestOperator(c), comment); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to update schedule: "+err.Error())
 		return
 	}
 
 	updateScheduler()
 
-	c.JSON(http.StatusOK, gin.H{
-		"success":     true,
-		"message":     "Schedule updated successfully",
+	respondSuccess(c, http.StatusOK, "Schedule updated successfully", gin.H{
 		"active_jobs": len(app.Scheduler.Entries()),
+		"collisions":  collisions,
+		"offsets":     offsets,
 	})
 }
 
 // Queue Management API handlers
 func apiGetQueueStatusHandler(c *gin.Context) {
-	if announcementManager == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Announcement manager not initialized"})
+	if !requireAnnouncementManager(c) {
 		return
 	}
 
-	status := announcementManager.GetQueueStatus()
-	c.JSON(http.StatusOK, status)
+	respondOK(c, announcementManager.GetQueueStatus())
 }
 
 func apiGetQueueHistoryHandler(c *gin.Context) {
-	if announcementManager == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Announcement manager not initialized"})
+	if !requireAnnouncementManager(c) {
 		return
 	}
 
@@ -522,7 +699,7 @@ func apiGetQueueHistoryHandler(c *gin.Context) {
 	}
 
 	history := announcementManager.GetHistory(limit)
-	c.JSON(http.StatusOK, gin.H{
+	respondOK(c, gin.H{
 		"history": history,
 		"count":   len(history),
 	})
@@ -530,79 +707,58 @@ func apiGetQueueHistoryHandler(c *gin.Context) {
 
 
 func apiCancelAnnouncementHandler(c *gin.Context) {
-	if announcementManager == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Announcement manager not initialized"})
+	if !requireAnnouncementManager(c) {
 		return
 	}
 
-	var data map[string]interface{}
-	
-	// Handle both JSON and form data
-	if c.ContentType() == "application/json" {
-		if err := c.ShouldBindJSON(&data); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
-			return
-		}
-	} else {
-		data = make(map[string]interface{})
-		data["id"] = c.PostForm("id")
+	data, ok := bindRequestData(c, "id")
+	if !ok {
+		return
 	}
 
-	id, exists := data["id"]
-	if !exists || id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Announcement ID required"})
+	if details := requiredStringFields(data, "id"); len(details) > 0 {
+		respondValidationError(c, "Announcement ID required", details...)
 		return
 	}
+	id := data["id"].(string)
 
-	err := announcementManager.CancelAnnouncement(id.(string))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+	if err := announcementManager.CancelAnnouncement(id); err != nil {
+		respondError(c, http.StatusConflict, ErrCodeConflict, err.Error())
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Announcement cancelled successfully",
-		"id":      id,
+	respondSuccess(c, http.StatusOK, "Announcement cancelled successfully", gin.H{
+		"id": id,
 	})
 }
 
 // Emergency announcement API (highest priority, audio files only)
 func apiEmergencyAnnouncementHandler(c *gin.Context) {
-	if announcementManager == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Announcement manager not initialized"})
+	if !requireAnnouncementManager(c) {
 		return
 	}
 
-	var data map[string]interface{}
-	
-	// Handle both JSON and form data
-	if c.ContentType() == "application/json" {
-		if err := c.ShouldBindJSON(&data); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
-			return
-		}
-	} else {
-		data = make(map[string]interface{})
-		data["file"] = c.PostForm("file")
+	data, ok := bindRequestData(c, "file", "callback_url", "expires_in")
+	if !ok {
+		return
 	}
 
 	// Emergency announcements require a file parameter
-	file, hasFile := data["file"]
-	if !hasFile || file == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Emergency announcement requires 'file' parameter"})
+	details := requiredStringFields(data, "file")
+	options, optionErrors := parseAnnouncementOptions(data)
+	details = append(details, optionErrors...)
+	if len(details) > 0 {
+		respondValidationError(c, "Invalid emergency announcement request", details...)
 		return
 	}
+	file := data["file"].(string)
 
 	// Validate emergency file exists in the emergency list
 	emergencies := loadJSON("emergencies", []Emergency{}).([]Emergency)
 	validFile := false
 	var selectedEmergency Emergency
 	for _, emergency := range emergencies {
-		if emergency.ID == file.(string) {
+		if emergency.ID == file {
 			validFile = true
 			selectedEmergency = emergency
 			break
@@ -614,29 +770,26 @@ func apiEmergencyAnnouncementHandler(c *gin.Context) {
 		for i, emergency := range emergencies {
 			availableFiles[i] = emergency.ID
 		}
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": fmt.Sprintf("Invalid emergency file '%s'. Available: %s", file.(string), joinStrings(availableFiles, ", ")),
+		respondValidationError(c, "Invalid emergency announcement request", FieldError{
+			Field:   "file",
+			Message: fmt.Sprintf("unknown emergency '%s'. Available: %s", file, joinStrings(availableFiles, ", ")),
 		})
 		return
 	}
 
 	// Emergency announcements are always immediate and highest priority
 	parameters := map[string]interface{}{
-		"file": file.(string),
+		"file": file,
 	}
 	
-	announcement, err := announcementManager.QueueAnnouncement(TypeEmergency, PriorityEmergency, parameters, time.Now())
+	options.RequestedBy = c.GetString("announcement_requester")
+	announcement, err := announcementManager.QueueAnnouncementWithOptions(TypeEmergency, PriorityEmergency, parameters, time.Now(), options)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   fmt.Sprintf("Failed to queue emergency announcement: %v", err),
-		})
+		respondQueueError(c, "Failed to queue emergency announcement", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": fmt.Sprintf("Emergency announcement '%s' queued with highest priority", selectedEmergency.Name),
+	respondSuccess(c, http.StatusAccepted, fmt.Sprintf("Emergency announcement '%s' queued with highest priority", selectedEmergency.Name), gin.H{
 		"announcement": gin.H{
 			"id":          announcement.ID,
 			"type":        "emergency",
@@ -648,54 +801,48 @@ func apiEmergencyAnnouncementHandler(c *gin.Context) {
 			"category":    selectedEmergency.Category,
 			"scheduled_at": announcement.ScheduledAt.Format(time.RFC3339),
 		},
-		"timestamp": time.Now().Format(time.RFC3339),
 	})
 }
 
+// Announcement status lookup
+func apiGetAnnouncementHandler(c *gin.Context) {
+	if !requireAnnouncementManager(c) {
+		return
+	}
+
+	id := c.Param("id")
+	announcement, found := announcementManager.GetAnnouncement(id)
+	if !found {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Announcement not found: "+id)
+		return
+	}
+
+	respondOK(c, gin.H{"announcement": announcement})
+}
+
 // Announcement Control Handlers
 func apiPauseAnnouncementsHandler(c *gin.Context) {
-	if announcementManager != nil {
-		announcementManager.PauseQueue()
-		c.JSON(http.StatusOK, gin.H{
-			"success": true,
-			"message": "All announcements paused",
-		})
-	} else {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error": "Announcement manager not initialized",
-		})
+	if !requireAnnouncementManager(c) {
+		return
 	}
+	announcementManager.PauseQueue()
+	respondSuccess(c, http.StatusOK, "All announcements paused", nil)
 }
 
 func apiResumeAnnouncementsHandler(c *gin.Context) {
-	if announcementManager != nil {
-		announcementManager.ResumeQueue()
-		c.JSON(http.StatusOK, gin.H{
-			"success": true,
-			"message": "All announcements resumed",
-		})
-	} else {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error": "Announcement manager not initialized",
-		})
+	if !requireAnnouncementManager(c) {
+		return
 	}
+	announcementManager.ResumeQueue()
+	respondSuccess(c, http.StatusOK, "All announcements resumed", nil)
 }
 
 func apiStopCurrentAnnouncementHandler(c *gin.Context) {
-	if announcementManager != nil {
-		announcementManager.StopCurrent()
-		c.JSON(http.StatusOK, gin.H{
-			"success": true,
-			"message": "Current announcement stopped",
-		})
-	} else {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error": "Announcement manager not initialized",
-		})
+	if !requireAnnouncementManager(c) {
+		return
 	}
+	announcementManager.StopCurrent()
+	respondSuccess(c, http.StatusOK, "Current announcement stopped", nil)
 }
 
 // Track Layout Handlers
@@ -722,9 +869,12 @@ func getTrackLayoutHandler(c *gin.Context) {
 		})
 	}
 	
+	layout := getTrackLayout()
 	c.JSON(http.StatusOK, gin.H{
 		"selected_trains": selectedTrainsList,
 		"selected_destinations": selectedDestinationsList,
+		"layout": layout,
+		"layout_warnings": layout.warnings(),
 	})
 }
 
@@ -738,6 +888,42 @@ func postTrackLayoutHandler(c *gin.Context) {
 		return
 	}
 	
+	// Platforms, tracks and zones may be saved on their own
+	if layoutData, ok := data["layout"]; ok {
+		var layout TrackLayout
+		raw, _ := json.Marshal(layoutData)
+		if err := json.Unmarshal(raw, &layout); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error": "Invalid layout: " + err.Error(),
+			})
+			return
+		}
+		if details := layout.validate(); len(details) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error": "Invalid layout: " + details[0].Field + " " + details[0].Message,
+				"details": details,
+			})
+			return
+		}
+		if err := saveTrackLayout(&layout); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"error": "Failed to save track layout",
+			})
+			return
+		}
+		if _, hasSelections := data["selected_trains"]; !hasSelections {
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"message": "Track layout saved successfully",
+				"warnings": layout.warnings(),
+			})
+			return
+		}
+	}
+	
 	// Extract selected trains and destinations
 	selectedTrainsData, ok1 := data["selected_trains"].([]interface{})
 	selectedDestinationsData, ok2 := data["selected_destinations"].([]interface{})
@@ -779,7 +965,7 @@ func postTrackLayoutHandler(c *gin.Context) {
 		Destinations []Destination `json:"destinations"`
 	}{Destinations: selectedDestinations}
 	
-	if err := saveJSON("trains", trainsWrapper); err != nil {
+	if err := saveJSONBy("trains", trainsWrapper, requestOperator(c), "Track layout selections"); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
 			"error": "Failed to save trains configuration",
@@ -787,7 +973,7 @@ func postTrackLayoutHandler(c *gin.Context) {
 		return
 	}
 	
-	if err := saveJSON("destinations", destinationsWrapper); err != nil {
+	if err := saveJSONBy("destinations", destinationsWrapper, requestOperator(c), "Track layout selections"); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
 			"error": "Failed to save destinations configuration",
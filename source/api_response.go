@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes returned in the API envelope
+const (
+	ErrCodeBadRequest   = "bad_request"
+	ErrCodeValidation   = "validation_error"
+	ErrCodeUnauthorized = "unauthorized"
+	ErrCodeForbidden    = "forbidden"
+	ErrCodeNotFound     = "not_found"
+	ErrCodeConflict     = "conflict"
+	ErrCodeRateLimited  = "rate_limited"
+	ErrCodeUnavailable  = "service_unavailable"
+	ErrCodeInternal     = "internal_error"
+)
+
+// APIResponse is the envelope returned by every /api endpoint
+type APIResponse struct {
+	Success   bool         `json:"success"`
+	Data      interface{}  `json:"data,omitempty"`
+	Message   string       `json:"message,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Code      string       `json:"code,omitempty"`
+	Details   []FieldError `json:"details,omitempty"`
+	RequestID string       `json:"request_id"`
+	Timestamp string       `json:"timestamp"`
+}
+
+// FieldError describes one invalid request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// requestIDMiddleware tags every request with an ID (honouring X-Request-ID)
+// so API responses and log lines can be correlated
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader("X-Request-ID")
+		if requestID == "" || len(requestID) > 64 {
+			buf := make([]byte, 8)
+			rand.Read(buf)
+			requestID = hex.EncodeToString(buf)
+		}
+		c.Set("request_id", requestID)
+		c.Header("X-Request-ID", requestID)
+		c.Next()
+	}
+}
+
+// getRequestID returns the ID assigned by requestIDMiddleware
+func getRequestID(c *gin.Context) string {
+	return c.GetString("request_id")
+}
+
+func newAPIResponse(c *gin.Context, success bool) APIResponse {
+	return APIResponse{
+		Success:   success,
+		RequestID: getRequestID(c),
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+}
+
+// respondOK writes a 200 response with data
+func respondOK(c *gin.Context, data interface{}) {
+	respondSuccess(c, http.StatusOK, "", data)
+}
+
+// respondSuccess writes a successful response with an explicit status and message
+func respondSuccess(c *gin.Context, status int, message string, data interface{}) {
+	response := newAPIResponse(c, true)
+	response.Message = message
+	response.Data = data
+	c.JSON(status, response)
+}
+
+// respondError writes an error response and aborts the handler chain
+func respondError(c *gin.Context, status int, code, message string) {
+	response := newAPIResponse(c, false)
+	response.Error = message
+	response.Code = code
+	c.AbortWithStatusJSON(status, response)
+}
+
+// respondValidationError writes a 422 response listing the invalid fields
+func respondValidationError(c *gin.Context, message string, details ...FieldError) {
+	response := newAPIResponse(c, false)
+	response.Error = message
+	response.Code = ErrCodeValidation
+	response.Details = details
+	c.AbortWithStatusJSON(http.StatusUnprocessableEntity, response)
+}
+
+// bindRequestData reads a JSON body or the named form fields into a map.
+// It writes the error response itself and returns false on malformed JSON.
+func bindRequestData(c *gin.Context, formFields ...string) (map[string]interface{}, bool) {
+	data := make(map[string]interface{})
+	if c.ContentType() == "application/json" {
+		if err := c.ShouldBindJSON(&data); err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+			return nil, false
+		}
+		return data, true
+	}
+
+	for _, field := range formFields {
+		if value, ok := c.GetPostForm(field); ok {
+			data[field] = value
+		}
+	}
+	return data, true
+}
+
+// requiredStringFields returns a FieldError for each missing or non-string field
+func requiredStringFields(data map[string]interface{}, fields ...string) []FieldError {
+	var details []FieldError
+	for _, field := range fields {
+		value, ok := data[field].(string)
+		if !ok || strings.TrimSpace(value) == "" {
+			details = append(details, FieldError{Field: field, Message: "is required"})
+		}
+	}
+	return details
+}
+
+// apiNotFoundHandler returns the envelope for unknown /api paths and the
+// default 404 page for everything else
+func apiNotFoundHandler(c *gin.Context) {
+	if strings.HasPrefix(c.Request.URL.Path, "/api/") {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Endpoint not found: "+c.Request.Method+" "+c.Request.URL.Path)
+		return
+	}
+	c.String(http.StatusNotFound, "404 page not found")
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scopes a token can carry. They reuse the API key permission names.
+const (
+	ScopeAnnounce = "announce" // Trigger and control announcements
+	ScopeStatus   = "status"   // Read-only GET endpoints
+	ScopeConfig   = "config"   // Change audio, schedule, catalog and lightning settings
+)
+
+const (
+	apiTokenIssuer     = "tarr-annunciator"
+	defaultTokenTTL    = 15 * time.Minute
+	maxTokenTTL        = 24 * time.Hour
+	apiTokenAuthMethod = "token"
+)
+
+// apiTokenClaims is the payload of a token issued by /api/token
+type apiTokenClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"` // API key ID the token was exchanged for
+	Scope     string `json:"scope"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	ID        string `json:"jti"`
+}
+
+func (t apiTokenClaims) scopes() []string {
+	return strings.Fields(t.Scope)
+}
+
+// signAPIToken encodes the claims as an HS256 JWT
+func signAPIToken(claims apiTokenClaims, secret string) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// parseAPIToken verifies an HS256 token from /api/token and returns its claims
+func parseAPIToken(token, secret string) (*apiTokenClaims, error) {
+	header, _, signingInput, signature, err := splitJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	if alg, _ := header["alg"].(string); alg != "HS256" {
+		return nil, fmt.Errorf("unsupported token algorithm")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingInput))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+
+	payload, _ := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[1])
+	var claims apiTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+	if claims.Issuer != apiTokenIssuer {
+		return nil, fmt.Errorf("token was not issued by this server")
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("token has expired")
+	}
+	return &claims, nil
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header
+func bearerToken(c *gin.Context) string {
+	authorization := c.GetHeader("Authorization")
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+	return ""
+}
+
+// apiScopeFor works out which scope a request needs from its method and path
+func apiScopeFor(c *gin.Context) string {
+	path := c.Request.URL.Path
+	for _, prefix := range []string{"/api/v1/", "/api/"} {
+		if strings.HasPrefix(path, prefix) {
+			path = "/" + strings.TrimPrefix(path, prefix)
+			break
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(path, "/announce/"), strings.HasPrefix(path, "/announcements/"), strings.HasPrefix(path, "/departures/"), strings.HasPrefix(path, "/lightning/test/"):
+		if c.Request.Method == http.MethodGet {
+			return ScopeStatus
+		}
+		return ScopeAnnounce
+	case c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead:
+		return ScopeStatus
+	default:
+		return ScopeConfig
+	}
+}
+
+// authenticateBearerToken validates a bearer token and its scope for the
+// request. The API key it was issued for must still be enabled, so disabling
+// a key revokes its tokens too. It writes the error response itself.
+func authenticateBearerToken(c *gin.Context, token string) bool {
+	adminConfig, err := loadAdminConfig(filepath.Join(app.Config.JSONDir, "admin_config.json"))
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "Token authentication unavailable")
+		return false
+	}
+	if adminConfig.API.TokenSecret == "" {
+		respondError(c, http.StatusUnauthorized, ErrCodeUnauthorized, "Bearer tokens are not enabled (api.token_secret is not set)")
+		return false
+	}
+
+	claims, err := parseAPIToken(token, adminConfig.API.TokenSecret)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, ErrCodeUnauthorized, "Invalid bearer token: "+err.Error())
+		return false
+	}
+
+	var apiKeyData *APIKey
+	for i, key := range adminConfig.APIKeys {
+		if key.ID == claims.Subject && key.Enabled {
+			apiKeyData = &adminConfig.APIKeys[i]
+			break
+		}
+	}
+	if apiKeyData == nil {
+		respondError(c, http.StatusUnauthorized, ErrCodeUnauthorized, "Invalid bearer token: API key has been disabled or removed")
+		return false
+	}
+
+	if !apiKeyAllowsIP(apiKeyData, c.ClientIP()) {
+		respondError(c, http.StatusForbidden, ErrCodeForbidden, "API key is not allowed from this address")
+		return false
+	}
+
+	// Narrow the key to the token's scopes, dropping any the key has since lost
+	scoped := *apiKeyData
+	scoped.Permissions = nil
+	for _, scope := range claims.scopes() {
+		if hasAPIPermission(apiKeyData, scope) {
+			scoped.Permissions = append(scoped.Permissions, scope)
+		}
+	}
+
+	required := apiScopeFor(c)
+	if !hasAPIPermission(&scoped, required) {
+		respondError(c, http.StatusForbidden, ErrCodeForbidden, fmt.Sprintf("Token is missing the '%s' scope", required))
+		return false
+	}
+
+	c.Set("api_key_data", &scoped)
+	c.Set("auth_method", apiTokenAuthMethod)
+	return true
+}
+
+// apiIssueTokenHandler exchanges an API key for a short-lived scoped token
+func apiIssueTokenHandler(c *gin.Context) {
+	if c.GetString("auth_method") == apiTokenAuthMethod {
+		respondError(c, http.StatusForbidden, ErrCodeForbidden, "Tokens must be requested with an API key, not another token")
+		return
+	}
+
+	data, ok := bindRequestData(c, "scope", "ttl_seconds")
+	if !ok {
+		return
+	}
+
+	adminConfig, err := loadAdminConfig(filepath.Join(app.Config.JSONDir, "admin_config.json"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to load admin config")
+		return
+	}
+	if adminConfig.API.TokenSecret == "" {
+		respondError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "Bearer tokens are not enabled (api.token_secret is not set)")
+		return
+	}
+
+	value, _ := c.Get("api_key_data")
+	apiKeyData, _ := value.(*APIKey)
+	if apiKeyData == nil {
+		respondError(c, http.StatusForbidden, ErrCodeForbidden, "Tokens can only be issued for keys in admin_config.json")
+		return
+	}
+
+	// Scopes may be a space separated string or an array; default to everything the key has
+	var requested []string
+	switch scope := data["scope"].(type) {
+	case string:
+		requested = strings.Fields(scope)
+	case []interface{}:
+		requested = claimStrings(scope)
+	}
+	if len(requested) == 0 {
+		for _, scope := range []string{ScopeAnnounce, ScopeStatus, ScopeConfig} {
+			if hasAPIPermission(apiKeyData, scope) {
+				requested = append(requested, scope)
+			}
+		}
+	}
+
+	var details []FieldError
+	for _, scope := range requested {
+		if scope != ScopeAnnounce && scope != ScopeStatus && scope != ScopeConfig {
+			details = append(details, FieldError{Field: "scope", Message: fmt.Sprintf("unknown scope '%s'", scope)})
+		} else if !hasAPIPermission(apiKeyData, scope) {
+			details = append(details, FieldError{Field: "scope", Message: fmt.Sprintf("API key does not have the '%s' permission", scope)})
+		}
+	}
+
+	ttl := defaultTokenTTL
+	if minutes := adminConfig.API.TokenTTLMinutes; minutes > 0 {
+		ttl = time.Duration(minutes) * time.Minute
+	}
+	switch value := data["ttl_seconds"].(type) {
+	case float64:
+		ttl = time.Duration(value) * time.Second
+	case string:
+		if value != "" {
+			var seconds int
+			if _, err := fmt.Sscanf(value, "%d", &seconds); err != nil {
+				details = append(details, FieldError{Field: "ttl_seconds", Message: "must be a whole number of seconds"})
+			}
+			ttl = time.Duration(seconds) * time.Second
+		}
+	}
+	if ttl <= 0 || ttl > maxTokenTTL {
+		details = append(details, FieldError{Field: "ttl_seconds", Message: fmt.Sprintf("must be between 1 and %d", int(maxTokenTTL.Seconds()))})
+	}
+
+	if len(details) > 0 {
+		respondValidationError(c, "Invalid token request", details...)
+		return
+	}
+
+	now := time.Now()
+	claims := apiTokenClaims{
+		Issuer:    apiTokenIssuer,
+		Subject:   apiKeyData.ID,
+		Scope:     strings.Join(requested, " "),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+		ID:        randomToken(),
+	}
+	token, err := signAPIToken(claims, adminConfig.API.TokenSecret)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to sign token: "+err.Error())
+		return
+	}
+
+	respondSuccess(c, http.StatusCreated, "Token issued", gin.H{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(ttl.Seconds()),
+		"expires_at":   time.Unix(claims.ExpiresAt, 0).Format(time.RFC3339),
+		"scope":        claims.Scope,
+	})
+}
@@ -0,0 +1,698 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Asset sync keeps the mp3 directory in step with a central copy, so a
+// content team can manage the audio for every unit in one place. The source
+// is one of:
+//
+//	s3://bucket/prefix          S3 or an S3-compatible store such as MinIO (set endpoint)
+//	https://host/path/          a web server with a manifest.json listing the files
+//	/mnt/audio or file:///...   a directory; mount SMB or NFS shares there
+//
+// Files are compared by hash and only new or changed ones are downloaded.
+// Each download is checked against the source's hash and then renamed into
+// place, so an announcement never plays a half-written file. The audio cache
+// notices replaced files by their size and time. Sync runs on request and,
+// with interval_minutes set, in the background.
+
+// HTTP manifest, served at <source>/manifest.json:
+//
+//	{"files": [{"path": "train/101.mp3", "sha256": "...", "size": 48213}]}
+
+// Audio file types that are synced; everything else in the source is ignored
+var assetSyncExtensions = map[string]bool{".mp3": true, ".wav": true}
+
+// AssetSyncSettings is the asset_sync section of admin_config.json
+type AssetSyncSettings struct {
+	Enabled         bool   `json:"enabled"`
+	Source          string `json:"source"`
+	Endpoint        string `json:"endpoint,omitempty"` // S3 endpoint; defaults to AWS for the region
+	Region          string `json:"region,omitempty"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	IntervalMinutes int    `json:"interval_minutes"` // 0 syncs only on request
+	DeleteExtra     bool   `json:"delete_extra"`     // Remove local audio files the source doesn't have
+}
+
+func getDefaultAssetSyncSettings() AssetSyncSettings {
+	return AssetSyncSettings{
+		Enabled: false,
+		Region:  "us-east-1",
+	}
+}
+
+// remoteAsset is a file in the source
+type remoteAsset struct {
+	Path     string // Slash-separated, relative to the source root
+	Size     int64
+	Hash     string // Hex digest, or "" when the source doesn't give one
+	HashType string // "sha256" or "md5"
+}
+
+// assetSource lists and fetches the files of a sync source
+type assetSource interface {
+	list() ([]remoteAsset, error)
+	open(asset remoteAsset) (io.ReadCloser, error)
+}
+
+// assetSyncFailure is a file that could not be synced
+type assetSyncFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// AssetSyncReport describes one sync run
+type AssetSyncReport struct {
+	Source     string             `json:"source"`
+	DryRun     bool               `json:"dry_run"`
+	StartedAt  time.Time          `json:"started_at"`
+	FinishedAt time.Time          `json:"finished_at"`
+	Checked    int                `json:"checked"`
+	Unchanged  int                `json:"unchanged"`
+	Added      []string           `json:"added"`
+	Updated    []string           `json:"updated"`
+	Deleted    []string           `json:"deleted"`
+	Failed     []assetSyncFailure `json:"failed"`
+	Error      string             `json:"error,omitempty"`
+}
+
+var (
+	assetSyncMutex   sync.Mutex // One run at a time
+	assetSyncRunning bool
+	lastAssetSync    *AssetSyncReport
+	assetSyncState   sync.Mutex // Guards assetSyncRunning and lastAssetSync
+)
+
+// loadAssetSyncSettings reads the asset_sync section of admin_config.json
+func loadAssetSyncSettings() AssetSyncSettings {
+	adminConfig, err := loadAdminConfig(adminConfigPath())
+	if err != nil {
+		return getDefaultAssetSyncSettings()
+	}
+	settings := adminConfig.AssetSync
+	if settings.Region == "" {
+		settings.Region = getDefaultAssetSyncSettings().Region
+	}
+	return settings
+}
+
+// newAssetSource picks the source type from the source URL
+func newAssetSource(settings AssetSyncSettings) (assetSource, error) {
+	source := strings.TrimSpace(settings.Source)
+	switch {
+	case source == "":
+		return nil, fmt.Errorf("no asset_sync.source configured")
+	case strings.HasPrefix(source, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(source, "s3://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("s3 source needs a bucket: s3://bucket/prefix")
+		}
+		if settings.AccessKeyID == "" || settings.SecretAccessKey == "" {
+			return nil, fmt.Errorf("s3 source needs access_key_id and secret_access_key")
+		}
+		endpoint := strings.TrimSuffix(settings.Endpoint, "/")
+		if endpoint == "" {
+			endpoint = "https://s3." + settings.Region + ".amazonaws.com"
+		}
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		return &s3AssetSource{settings: settings, endpoint: endpoint, bucket: bucket, prefix: prefix}, nil
+	case strings.HasPrefix(source, "http://"), strings.HasPrefix(source, "https://"):
+		base := source
+		if !strings.HasSuffix(base, ".json") {
+			base = strings.TrimSuffix(base, "/") + "/manifest.json"
+		}
+		return &httpAssetSource{manifestURL: base}, nil
+	case strings.HasPrefix(source, "smb://"):
+		return nil, fmt.Errorf("mount the SMB share (mount -t cifs) and use its directory as the source")
+	default:
+		dir := strings.TrimPrefix(source, "file://")
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("source directory %s is not available", dir)
+		}
+		return &dirAssetSource{dir: dir}, nil
+	}
+}
+
+// startAssetSync syncs on the configured interval. Settings are re-read every
+// minute, so turning scheduled sync on doesn't need a restart.
+func startAssetSync() {
+	go func() {
+		var lastRun time.Time
+		for {
+			time.Sleep(time.Minute)
+			settings := loadAssetSyncSettings()
+			if !settings.Enabled || settings.IntervalMinutes <= 0 {
+				continue
+			}
+			if time.Since(lastRun) < time.Duration(settings.IntervalMinutes)*time.Minute {
+				continue
+			}
+			lastRun = time.Now()
+			runAssetSync(settings, false)
+		}
+	}()
+}
+
+// runAssetSync brings the mp3 directory in line with the source. With dryRun
+// it only reports what would change.
+func runAssetSync(settings AssetSyncSettings, dryRun bool) *AssetSyncReport {
+	assetSyncMutex.Lock()
+	defer assetSyncMutex.Unlock()
+	assetSyncState.Lock()
+	assetSyncRunning = true
+	assetSyncState.Unlock()
+
+	report := &AssetSyncReport{
+		Source:    settings.Source,
+		DryRun:    dryRun,
+		StartedAt: time.Now(),
+		Added:     []string{},
+		Updated:   []string{},
+		Deleted:   []string{},
+		Failed:    []assetSyncFailure{},
+	}
+	if err := syncAssets(settings, dryRun, report); err != nil {
+		report.Error = err.Error()
+	}
+	report.FinishedAt = time.Now()
+
+	assetSyncState.Lock()
+	assetSyncRunning = false
+	lastAssetSync = report
+	assetSyncState.Unlock()
+
+	switch {
+	case report.Error != "":
+		log.Printf("❌ Asset sync from %s failed: %s", settings.Source, report.Error)
+		if !dryRun {
+			notifyAssetSyncFailed(settings.Source, report.Error)
+		}
+	case len(report.Failed) > 0:
+		log.Printf("⚠️  Asset sync from %s: %d added, %d updated, %d deleted, %d failed",
+			settings.Source, len(report.Added), len(report.Updated), len(report.Deleted), len(report.Failed))
+		if !dryRun {
+			notifyAssetSyncFailed(settings.Source, fmt.Sprintf("%d files failed, first: %s: %s", len(report.Failed), report.Failed[0].Path, report.Failed[0].Error))
+		}
+	default:
+		log.Printf("✓ Asset sync from %s: %d added, %d updated, %d deleted, %d unchanged",
+			settings.Source, len(report.Added), len(report.Updated), len(report.Deleted), report.Unchanged)
+	}
+	return report
+}
+
+// syncAssets compares the source with the mp3 directory and copies what differs
+func syncAssets(settings AssetSyncSettings, dryRun bool, report *AssetSyncReport) error {
+	source, err := newAssetSource(settings)
+	if err != nil {
+		return err
+	}
+	if !dryRun {
+		if err := storageWritable(app.Config.MP3Dir, "asset sync"); err != nil {
+			return err
+		}
+	}
+	assets, err := source.list()
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %v", settings.Source, err)
+	}
+
+	wanted := make(map[string]bool)
+	for _, asset := range assets {
+		if !assetSyncExtensions[strings.ToLower(path.Ext(asset.Path))] {
+			continue
+		}
+		local, err := mp3Path(filepath.FromSlash(asset.Path))
+		if err != nil {
+			report.Failed = append(report.Failed, assetSyncFailure{Path: asset.Path, Error: err.Error()})
+			continue
+		}
+		report.Checked++
+		wanted[filepath.Clean(local)] = true
+
+		exists := fileExists(local)
+		if exists && localAssetMatches(local, asset) {
+			report.Unchanged++
+			continue
+		}
+		if !dryRun {
+			if err := downloadAsset(source, asset, local); err != nil {
+				report.Failed = append(report.Failed, assetSyncFailure{Path: asset.Path, Error: err.Error()})
+				continue
+			}
+		}
+		if exists {
+			report.Updated = append(report.Updated, asset.Path)
+		} else {
+			report.Added = append(report.Added, asset.Path)
+		}
+	}
+
+	if settings.DeleteExtra {
+		// An empty listing is more likely a broken source than a request to
+		// delete every clip
+		if report.Checked == 0 {
+			return fmt.Errorf("the source has no audio files; nothing was deleted")
+		}
+		err := filepath.WalkDir(app.Config.MP3Dir, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || !assetSyncExtensions[strings.ToLower(filepath.Ext(file))] {
+				return nil
+			}
+			if wanted[filepath.Clean(file)] {
+				return nil
+			}
+			relative, _ := filepath.Rel(app.Config.MP3Dir, file)
+			if !dryRun {
+				if err := os.Remove(file); err != nil {
+					report.Failed = append(report.Failed, assetSyncFailure{Path: filepath.ToSlash(relative), Error: err.Error()})
+					return nil
+				}
+			}
+			report.Deleted = append(report.Deleted, filepath.ToSlash(relative))
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to scan %s: %v", app.Config.MP3Dir, err)
+		}
+	}
+	return nil
+}
+
+// newAssetHash returns a hash of the type the source reports
+func newAssetHash(hashType string) hash.Hash {
+	if hashType == "md5" {
+		return md5.New()
+	}
+	return sha256.New()
+}
+
+// localAssetMatches reports whether a local file is the same as the source's.
+// Without a hash from the source only the size can be compared.
+func localAssetMatches(local string, asset remoteAsset) bool {
+	info, err := os.Stat(local)
+	if err != nil || (asset.Size >= 0 && info.Size() != asset.Size) {
+		return false
+	}
+	if asset.Hash == "" {
+		return true
+	}
+	file, err := os.Open(local)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	digest := newAssetHash(asset.HashType)
+	if _, err := io.Copy(digest, file); err != nil {
+		return false
+	}
+	return strings.EqualFold(hex.EncodeToString(digest.Sum(nil)), asset.Hash)
+}
+
+// downloadAsset copies one file from the source, checking its hash before
+// renaming it into place
+func downloadAsset(source assetSource, asset remoteAsset, local string) error {
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return err
+	}
+	reader, err := source.open(asset)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	partial := local + ".sync"
+	file, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+	digest := newAssetHash(asset.HashType)
+	written, err := io.Copy(io.MultiWriter(file, digest), reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && asset.Size >= 0 && written != asset.Size {
+		err = fmt.Errorf("got %d bytes, expected %d", written, asset.Size)
+	}
+	if err == nil && asset.Hash != "" {
+		if sum := hex.EncodeToString(digest.Sum(nil)); !strings.EqualFold(sum, asset.Hash) {
+			err = fmt.Errorf("checksum mismatch: got %s", sum)
+		}
+	}
+	if err != nil {
+		os.Remove(partial)
+		return err
+	}
+	return os.Rename(partial, local)
+}
+
+// Directory source (local disk or a mounted SMB/NFS share)
+
+type dirAssetSource struct {
+	dir string
+}
+
+func (s *dirAssetSource) list() ([]remoteAsset, error) {
+	var assets []remoteAsset
+	err := filepath.WalkDir(s.dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !assetSyncExtensions[strings.ToLower(filepath.Ext(file))] {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		relative, _ := filepath.Rel(s.dir, file)
+		// Sizes are compared and the copy is checked against this hash
+		sum, err := fileSHA256(file)
+		if err != nil {
+			return err
+		}
+		assets = append(assets, remoteAsset{Path: filepath.ToSlash(relative), Size: info.Size(), Hash: sum, HashType: "sha256"})
+		return nil
+	})
+	return assets, err
+}
+
+func (s *dirAssetSource) open(asset remoteAsset) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.dir, filepath.FromSlash(asset.Path)))
+}
+
+// fileSHA256 returns the hex SHA-256 of a file
+func fileSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	digest := sha256.New()
+	if _, err := io.Copy(digest, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// HTTP manifest source
+
+type httpAssetSource struct {
+	manifestURL string
+}
+
+var assetSyncClient = &http.Client{Timeout: 5 * time.Minute}
+
+func (s *httpAssetSource) list() ([]remoteAsset, error) {
+	resp, err := assetSyncClient.Get(s.manifestURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("manifest: HTTP %d", resp.StatusCode)
+	}
+	var manifest struct {
+		Files []struct {
+			Path   string `json:"path"`
+			SHA256 string `json:"sha256"`
+			Size   *int64 `json:"size"`
+		} `json:"files"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	assets := make([]remoteAsset, 0, len(manifest.Files))
+	for _, file := range manifest.Files {
+		asset := remoteAsset{Path: file.Path, Size: -1, Hash: file.SHA256, HashType: "sha256"}
+		if file.Size != nil {
+			asset.Size = *file.Size
+		}
+		assets = append(assets, asset)
+	}
+	return assets, nil
+}
+
+func (s *httpAssetSource) open(asset remoteAsset) (io.ReadCloser, error) {
+	base, err := url.Parse(s.manifestURL)
+	if err != nil {
+		return nil, err
+	}
+	target := base.ResolveReference(&url.URL{Path: asset.Path})
+	resp, err := assetSyncClient.Get(target.String())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// S3 source, signed with AWS Signature Version 4 and addressed path-style so
+// MinIO and other S3-compatible stores work without DNS set up per bucket
+
+type s3AssetSource struct {
+	settings AssetSyncSettings
+	endpoint string
+	bucket   string
+	prefix   string
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		ETag string `xml:"ETag"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *s3AssetSource) list() ([]remoteAsset, error) {
+	var assets []remoteAsset
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.get("", query)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket listing: %v", err)
+		}
+		for _, object := range result.Contents {
+			asset := remoteAsset{Path: strings.TrimPrefix(object.Key, s.prefix), Size: object.Size}
+			// A single-part upload's ETag is its MD5; multipart ETags end in -<parts>
+			if etag := strings.Trim(object.ETag, `"`); len(etag) == 32 && !strings.Contains(etag, "-") {
+				asset.Hash, asset.HashType = etag, "md5"
+			}
+			if asset.Path != "" && !strings.HasSuffix(asset.Path, "/") {
+				assets = append(assets, asset)
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return assets, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *s3AssetSource) open(asset remoteAsset) (io.ReadCloser, error) {
+	resp, err := s.get(s.prefix+asset.Path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// get sends a signed GET for a key ("" for the bucket itself)
+func (s *s3AssetSource) get(key string, query url.Values) (*http.Response, error) {
+	endpoint, err := url.Parse(s.endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %v", err)
+	}
+	canonicalPath := "/" + awsURIEncode(s.bucket, false)
+	if key != "" {
+		canonicalPath += "/" + awsURIEncode(key, true)
+	}
+	canonicalQuery := awsCanonicalQuery(query)
+	target := endpoint.Scheme + "://" + endpoint.Host + strings.TrimSuffix(endpoint.EscapedPath(), "/") + canonicalPath
+	if canonicalQuery != "" {
+		target += "?" + canonicalQuery
+	}
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, strings.TrimSuffix(endpoint.EscapedPath(), "/")+canonicalPath, canonicalQuery, time.Now().UTC())
+
+	resp, err := assetSyncClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// sign adds the Signature Version 4 headers to a request with no body
+func (s *s3AssetSource) sign(req *http.Request, canonicalPath, canonicalQuery string, now time.Time) {
+	const payloadHash = "UNSIGNED-PAYLOAD"
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath,
+		canonicalQuery,
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.settings.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.settings.SecretAccessKey)
+	for _, part := range []string{day, s.settings.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.settings.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsURIEncode percent-encodes everything but unreserved characters, and
+// slashes too unless keepSlash is set, as Signature Version 4 requires
+func awsURIEncode(value string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', keepSlash && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsCanonicalQuery encodes query parameters sorted by name
+func awsCanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, awsURIEncode(name, false)+"="+awsURIEncode(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Handlers
+
+// assetSyncSettingsView leaves out the secret key
+func assetSyncSettingsView(settings AssetSyncSettings) gin.H {
+	return gin.H{
+		"enabled":          settings.Enabled,
+		"source":           settings.Source,
+		"endpoint":         settings.Endpoint,
+		"region":           settings.Region,
+		"access_key_id":    settings.AccessKeyID,
+		"secret_key_set":   settings.SecretAccessKey != "",
+		"interval_minutes": settings.IntervalMinutes,
+		"delete_extra":     settings.DeleteExtra,
+	}
+}
+
+// apiAssetSyncStatusHandler returns the settings and the last run's report
+func apiAssetSyncStatusHandler(c *gin.Context) {
+	assetSyncState.Lock()
+	running, report := assetSyncRunning, lastAssetSync
+	assetSyncState.Unlock()
+	respondOK(c, gin.H{
+		"settings":    assetSyncSettingsView(loadAssetSyncSettings()),
+		"running":     running,
+		"last_report": report,
+	})
+}
+
+// apiRunAssetSyncHandler syncs now and returns the report. "dry_run": true
+// reports what would change without touching any file.
+func apiRunAssetSyncHandler(c *gin.Context) {
+	data, ok := bindRequestData(c, "dry_run")
+	if !ok {
+		return
+	}
+	dryRun := data["dry_run"] == true || data["dry_run"] == "true"
+
+	settings := loadAssetSyncSettings()
+	if strings.TrimSpace(settings.Source) == "" {
+		respondError(c, http.StatusConflict, ErrCodeConflict, "No asset_sync.source is configured in admin_config.json")
+		return
+	}
+	assetSyncState.Lock()
+	running := assetSyncRunning
+	assetSyncState.Unlock()
+	if running {
+		respondError(c, http.StatusConflict, ErrCodeConflict, "An asset sync is already running")
+		return
+	}
+
+	log.Printf("Asset sync requested by %s (dry run: %v)", requestOperator(c), dryRun)
+	report := runAssetSync(settings, dryRun)
+	if report.Error != "" {
+		respondError(c, http.StatusBadGateway, ErrCodeUnavailable, report.Error)
+		return
+	}
+	respondSuccess(c, http.StatusOK, "Asset sync finished", gin.H{"report": report})
+}
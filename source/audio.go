@@ -1,81 +1,23 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
 	"time"
 
-	"github.com/faiface/beep"
-	"github.com/faiface/beep/effects"
-	"github.com/faiface/beep/mp3"
-	"github.com/faiface/beep/speaker"
+	"github.com/gopxl/beep"
+	"github.com/gopxl/beep/effects"
 )
 
 // Audio playback functions
 func playAudio(filePath string) error {
-	if !app.AudioEnabled {
-		log.Printf("Audio not available - would play: %s", filePath)
-		return fmt.Errorf("audio not available")
-	}
-
-	if !fileExists(filePath) {
-		log.Printf("Audio file not found: %s", filePath)
-		return fmt.Errorf("audio file not found: %s", filePath)
-	}
-
-	log.Printf("Playing audio: %s (Volume: %d%%)", filePath, int(app.Config.CurrentVolume*100))
-
-	// Open the file
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to open audio file: %v", err)
-	}
-	defer file.Close()
-
-	// Decode the MP3
-	streamer, format, err := mp3.Decode(file)
-	if err != nil {
-		return fmt.Errorf("failed to decode MP3: %v", err)
-	}
-	defer streamer.Close()
-
-	// Resample if necessary
-	resampled := beep.Resample(4, format.SampleRate, beep.SampleRate(44100), streamer)
-
-	// Apply volume
-	volume := &effects.Volume{
-		Streamer: resampled,
-		Base:     2,
-		Volume:   0, // Will be set below
-		Silent:   false,
-	}
-	
-	// Convert linear volume (0.0-1.0) to logarithmic scale
-	if app.Config.CurrentVolume <= 0.0 {
-		volume.Silent = true
-	} else {
-		// Convert to decibels: 20 * log10(volume)
-		// But since beep uses base 2, we need different calculation
-		volume.Volume = (app.Config.CurrentVolume - 1.0) * 5 // Approximate conversion
-	}
-
-	// Create a done channel to wait for playback completion
-	done := make(chan bool)
-	speaker.Play(beep.Seq(volume, beep.Callback(func() {
-		done <- true
-	})))
-
-	// Wait for playback to complete
-	<-done
-
-	return nil
+	return playAudioWithCancellation(filePath, nil)
 }
 
 // playAudioWithCancellation plays audio but can be cancelled via a channel
 func playAudioWithCancellation(filePath string, cancelChan chan bool) error {
-	if !app.AudioEnabled {
+	if !app.AudioEnabled || audioBackend == nil {
 		log.Printf("Audio not available - would play: %s", filePath)
 		return fmt.Errorf("audio not available")
 	}
@@ -85,73 +27,59 @@ func playAudioWithCancellation(filePath string, cancelChan chan bool) error {
 		return fmt.Errorf("audio file not found: %s", filePath)
 	}
 
-	log.Printf("Playing audio: %s (Volume: %d%%)", filePath, int(app.Config.CurrentVolume*100))
+	log.Printf("Playing audio: %s (Volume: %d%%)", filePath, int(playbackVolume()*100))
 
-	// Open the file
-	file, err := os.Open(filePath)
+	// Decoded clips come from the cache when available
+	streamer, closeStream, err := openAudioStream(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to open audio file: %v", err)
+		return err
 	}
-	defer file.Close()
+	defer closeStream()
+	streamer = newSilenceDetector(streamer, filePath)
 
-	// Decode the MP3
-	streamer, format, err := mp3.Decode(file)
-	if err != nil {
-		return fmt.Errorf("failed to decode MP3: %v", err)
+	// Play and wait for either completion or cancellation
+	if err := audioBackend.Play(withMeter(applyVolume(streamer)), cancelChan); err != nil {
+		if errors.Is(err, errPlaybackCancelled) {
+			log.Printf("Audio playback cancelled: %s", filePath)
+		}
+		return err
 	}
-	defer streamer.Close()
 
-	// Resample if necessary
-	resampled := beep.Resample(4, format.SampleRate, beep.SampleRate(44100), streamer)
+	return nil
+}
 
-	// Apply volume
+// applyVolume wraps a streamer with the current software volume, lowered
+// during quiet hours. With hardware volume on, the mixer is set instead and
+// the streamer plays at full scale.
+func applyVolume(streamer beep.Streamer) beep.Streamer {
+	level := playbackVolume()
+	if level > 0.0 && applyHardwareVolume(level) {
+		return streamer
+	}
 	volume := &effects.Volume{
-		Streamer: resampled,
+		Streamer: streamer,
 		Base:     2,
 		Volume:   0, // Will be set below
 		Silent:   false,
 	}
-	
+
 	// Convert linear volume (0.0-1.0) to logarithmic scale
-	if app.Config.CurrentVolume <= 0.0 {
+	if level <= 0.0 {
 		volume.Silent = true
 	} else {
 		// Convert to decibels: 20 * log10(volume)
 		// But since beep uses base 2, we need different calculation
-		volume.Volume = (app.Config.CurrentVolume - 1.0) * 5 // Approximate conversion
+		volume.Volume = (level - 1.0) * 5 // Approximate conversion
 	}
 
-	// Create a done channel to wait for playback completion
-	done := make(chan bool)
-	speaker.Play(beep.Seq(volume, beep.Callback(func() {
-		done <- true
-	})))
-
-	// Wait for either playback completion or cancellation
-	select {
-	case <-done:
-		return nil
-	case <-cancelChan:
-		// Clear the speaker to stop playback immediately
-		speaker.Clear()
-		log.Printf("Audio playback cancelled: %s", filePath)
-		return fmt.Errorf("playback cancelled")
-	}
+	return volume
 }
 
 func playAudioSequence(filePaths []string) {
 	// Note: This function should only be called when already holding the globalAudioMutex
 	// The mutex locking is handled by the caller to prevent deadlocks
-	for _, filePath := range filePaths {
-		if fileExists(filePath) {
-			log.Printf("Playing: %s", filepath.Base(filePath))
-			if err := playAudio(filePath); err != nil {
-				log.Printf("Error playing %s: %v", filePath, err)
-			}
-			time.Sleep(300 * time.Millisecond) // Small gap between announcements
-		} else {
-			log.Printf("Missing audio file: %s", filePath)
-		}
+	if err := playSequenceWithCancellation(filePaths, defaultSequenceSettings(), LeadInSettings{}, nil, nil); err != nil {
+		log.Printf("Error playing sequence: %v", err)
 	}
 }
 
@@ -174,12 +102,17 @@ func playStationAnnouncement(trainNumber, direction, destination, trackNumber st
 		globalAudioMutex.Lock()
 		defer globalAudioMutex.Unlock()
 		
-		audioSequence := []string{
-			filepath.Join(app.Config.MP3Dir, "chime.mp3"),
-			filepath.Join(app.Config.MP3Dir, "train", trainNumber+".mp3"),
-			filepath.Join(app.Config.MP3Dir, "direction", direction+".mp3"),
-			filepath.Join(app.Config.MP3Dir, "destination", destination+".mp3"),
-			filepath.Join(app.Config.MP3Dir, "track", trackNumber+".mp3"),
+		chime, _ := resolveAudioPath("chime.mp3")
+		audioSequence := []string{chime}
+		for _, part := range []struct{ catalog, id string }{
+			{"trains", trainNumber}, {"directions", direction}, {"destinations", destination}, {"tracks", trackNumber},
+		} {
+			file, err := catalogAudioFile(part.catalog, part.id)
+			if err != nil {
+				log.Printf("Error playing station announcement: %v", err)
+				return
+			}
+			audioSequence = append(audioSequence, file)
 		}
 		playAudioSequence(audioSequence)
 	}
@@ -201,7 +134,11 @@ func playPromo(file string) {
 		globalAudioMutex.Lock()
 		defer globalAudioMutex.Unlock()
 		
-		promoFile := filepath.Join(app.Config.MP3Dir, "promo", file+".mp3")
+		promoFile, err := catalogAudioFile("promos", file)
+		if err != nil {
+			log.Printf("Error playing promo: %v", err)
+			return
+		}
 		if err := playAudio(promoFile); err != nil {
 			log.Printf("Error playing promo: %v", err)
 		}
@@ -224,7 +161,11 @@ func playSafety(language string) {
 		globalAudioMutex.Lock()
 		defer globalAudioMutex.Unlock()
 		
-		safetyFile := filepath.Join(app.Config.MP3Dir, "safety", "safety_"+language+".mp3")
+		safetyFile, err := catalogAudioFile("safety", language)
+		if err != nil {
+			log.Printf("Error playing safety announcement: %v", err)
+			return
+		}
 		if err := playAudio(safetyFile); err != nil {
 			log.Printf("Error playing safety announcement: %v", err)
 		}
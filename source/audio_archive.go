@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gopxl/beep"
+)
+
+// The audio archive keeps a WAV recording of every announcement as it was
+// composed for playback, so incident reviews can hear exactly what went out.
+// Recordings are taken before the output volume is applied and are named
+// after the announcement, with the path kept in its history entry.
+
+// AudioArchiveSettings controls recording of played announcements
+type AudioArchiveSettings struct {
+	Enabled       bool   `json:"enabled"`
+	Dir           string `json:"dir"`            // Defaults to audio-archive in the log directory
+	RetentionDays int    `json:"retention_days"` // Recordings older than this are deleted
+	MaxSizeMB     int    `json:"max_size_mb"`    // Oldest recordings are deleted beyond this total
+}
+
+func getDefaultAudioArchiveSettings() AudioArchiveSettings {
+	return AudioArchiveSettings{
+		Enabled:       false,
+		RetentionDays: 30,
+		MaxSizeMB:     1024,
+	}
+}
+
+// audioArchiveDir returns the directory recordings are written to
+func audioArchiveDir() string {
+	if dir := getAudioSettings().Archive.Dir; dir != "" {
+		return dir
+	}
+	return filepath.Join(app.Config.LogDir, "audio-archive")
+}
+
+// Recordings are written as 16-bit stereo PCM
+const (
+	archiveChannels      = 2
+	archiveBitsPerSample = 16
+)
+
+// audioArchiveWriter records a stream to a WAV file as it plays
+type audioArchiveWriter struct {
+	mutex      sync.Mutex
+	file       *os.File
+	buffer     *bufio.Writer
+	path       string
+	sampleRate beep.SampleRate
+	dataBytes  int64
+	err        error
+}
+
+var unsafeArchiveChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// startAudioArchive opens a recording for an announcement, or returns nil when
+// archiving is off or the file cannot be created
+func startAudioArchive(announcement *Announcement, sampleRate beep.SampleRate) *audioArchiveWriter {
+	if !getAudioSettings().Archive.Enabled {
+		return nil
+	}
+
+	if err := storageWritable(audioArchiveDir(), "audio archive recording"); err != nil {
+		log.Printf("Warning: %v", err)
+		return nil
+	}
+
+	now := time.Now()
+	dir := filepath.Join(audioArchiveDir(), now.Format("2006-01-02"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("Warning: cannot create audio archive directory: %v", err)
+		return nil
+	}
+	name := fmt.Sprintf("%s_%s_%s.wav", now.Format("20060102-150405"),
+		unsafeArchiveChars.ReplaceAllString(announcement.ID, "_"),
+		unsafeArchiveChars.ReplaceAllString(string(announcement.Type), "_"))
+	path := filepath.Join(dir, name)
+
+	file, err := os.Create(path)
+	if err != nil {
+		log.Printf("Warning: cannot create audio archive file: %v", err)
+		return nil
+	}
+	writer := &audioArchiveWriter{
+		file:       file,
+		buffer:     bufio.NewWriterSize(file, 64*1024),
+		path:       path,
+		sampleRate: sampleRate,
+	}
+	// Sizes are filled in on Close
+	writer.buffer.Write(wavFileHeader(sampleRate, 0))
+	return writer
+}
+
+// wavFileHeader builds a 44-byte PCM WAV header for dataBytes of samples
+func wavFileHeader(sampleRate beep.SampleRate, dataBytes uint32) []byte {
+	blockAlign := archiveChannels * archiveBitsPerSample / 8
+	header := make([]byte, 44)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], 36+dataBytes)
+	copy(header[8:], "WAVE")
+	copy(header[12:], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(header[22:], archiveChannels)
+	binary.LittleEndian.PutUint32(header[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(header[28:], uint32(int(sampleRate)*blockAlign))
+	binary.LittleEndian.PutUint16(header[32:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(header[34:], archiveBitsPerSample)
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], dataBytes)
+	return header
+}
+
+// write appends samples as 16-bit PCM
+func (w *audioArchiveWriter) write(samples [][2]float64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.err != nil {
+		return
+	}
+	if _, err := w.buffer.Write(encodePCM16(samples)); err != nil {
+		w.err = err
+		return
+	}
+	w.dataBytes += int64(len(samples) * 4)
+}
+
+// encodePCM16 converts samples to interleaved little-endian 16-bit stereo PCM
+func encodePCM16(samples [][2]float64) []byte {
+	pcm := make([]byte, len(samples)*4)
+	for i, sample := range samples {
+		for channel := 0; channel < 2; channel++ {
+			value := math.Max(-1, math.Min(1, sample[channel]))
+			binary.LittleEndian.PutUint16(pcm[i*4+channel*2:], uint16(int16(value*math.MaxInt16)))
+		}
+	}
+	return pcm
+}
+
+// tap returns a streamer that records everything streamed through it
+func (w *audioArchiveWriter) tap(streamer beep.Streamer) beep.Streamer {
+	return &archiveTapStreamer{streamer: streamer, writer: w}
+}
+
+type archiveTapStreamer struct {
+	streamer beep.Streamer
+	writer   *audioArchiveWriter
+}
+
+func (t *archiveTapStreamer) Stream(samples [][2]float64) (int, bool) {
+	n, ok := t.streamer.Stream(samples)
+	if n > 0 {
+		t.writer.write(samples[:n])
+	}
+	return n, ok
+}
+
+func (t *archiveTapStreamer) Err() error {
+	return t.streamer.Err()
+}
+
+// Close finishes the WAV file and returns its path. Empty or failed
+// recordings are removed and return "".
+func (w *audioArchiveWriter) Close() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.err == nil {
+		w.err = w.buffer.Flush()
+	}
+	if w.err == nil {
+		_, w.err = w.file.WriteAt(wavFileHeader(w.sampleRate, uint32(w.dataBytes)), 0)
+	}
+	if err := w.file.Close(); w.err == nil {
+		w.err = err
+	}
+	if w.err != nil || w.dataBytes == 0 {
+		if w.err != nil {
+			log.Printf("Warning: audio archive recording failed: %v", w.err)
+		}
+		os.Remove(w.path)
+		return ""
+	}
+	return w.path
+}
+
+// Handlers
+
+// apiGetAnnouncementAudioHandler serves the archived recording of an announcement
+func apiGetAnnouncementAudioHandler(c *gin.Context) {
+	id := c.Param("id")
+	if announcementManager == nil {
+		respondError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "Announcement queue not available")
+		return
+	}
+	announcement, found := announcementManager.GetAnnouncement(id)
+	if !found {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Announcement '"+id+"' not found")
+		return
+	}
+	if announcement.ArchiveFile == "" || !fileExists(announcement.ArchiveFile) {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "No recording archived for announcement '"+id+"'")
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(announcement.ArchiveFile)))
+	c.File(announcement.ArchiveFile)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gopxl/beep"
+	"github.com/gopxl/beep/speaker"
+)
+
+// AudioBackend abstracts the audio output engine so playback code does not
+// depend on a specific library or its global speaker state
+type AudioBackend interface {
+	// Name returns a human readable backend identifier for status endpoints
+	Name() string
+	// SampleRate returns the output sample rate streams must be resampled to
+	SampleRate() beep.SampleRate
+	// Init opens (or re-opens) the output device
+	Init(deviceID string) error
+	// Play blocks until the streamer is drained or cancelChan fires.
+	// Multiple streams may be played concurrently and are mixed together.
+	Play(streamer beep.Streamer, cancelChan chan bool) error
+	// StopAll immediately silences every active stream
+	StopAll()
+	// Close releases the output device
+	Close() error
+	// IsInitialized reports whether the output device is currently open
+	IsInitialized() bool
+	// DeviceID returns the device the output was last opened on
+	DeviceID() string
+}
+
+// Global audio backend instance
+var audioBackend AudioBackend
+
+// errPlaybackCancelled is returned when playback is stopped before it ends
+var errPlaybackCancelled = errors.New("playback cancelled")
+
+// beepBackend plays audio through gopxl/beep's speaker package
+type beepBackend struct {
+	mutex       sync.Mutex
+	sampleRate  beep.SampleRate
+	bufferSize  time.Duration
+	initialized bool
+	deviceID    string
+	opened      bool // speaker.Init has succeeded in this process
+	openFailed  bool // speaker.Init failed or the speaker was closed; beep can't open it again
+	// mixer holds the streams being played. It is played on the speaker
+	// once, through the live tap, so /listen hears the mixed output.
+	mixer *beep.Mixer
+}
+
+// newBeepBackend creates a beep backend with the default output format
+func newBeepBackend() *beepBackend {
+	return &beepBackend{
+		sampleRate: beep.SampleRate(44100),
+		bufferSize: time.Second / 10,
+		mixer:      &beep.Mixer{},
+	}
+}
+
+func (b *beepBackend) Name() string {
+	return "beep (gopxl/beep)"
+}
+
+func (b *beepBackend) SampleRate() beep.SampleRate {
+	return b.sampleRate
+}
+
+// Init opens the speaker. beep creates its audio context once per process and
+// can't create it again, whether the first attempt succeeded or failed, so an
+// open speaker is kept: it plays to the system default output, which follows
+// the device setAudioDevice selected. After a failed attempt only a restart
+// can open it (errAudioRestartRequired).
+func (b *beepBackend) Init(deviceID string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch {
+	case b.opened:
+		b.clearMixer()
+	case b.openFailed:
+		b.initialized = false
+		return errAudioRestartRequired
+	default:
+		if err := speaker.Init(b.sampleRate, b.sampleRate.N(b.bufferSize)); err != nil {
+			b.openFailed = true
+			return fmt.Errorf("failed to initialize speaker: %v", err)
+		}
+		b.opened = true
+		speaker.Play(withLiveTap(b.mixer))
+	}
+
+	b.initialized = true
+	b.deviceID = deviceID
+	log.Printf("Audio backend %s initialized (device: %s, %d Hz)", b.Name(), deviceID, b.sampleRate)
+	return nil
+}
+
+func (b *beepBackend) IsInitialized() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.initialized
+}
+
+func (b *beepBackend) DeviceID() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.deviceID
+}
+
+func (b *beepBackend) Play(streamer beep.Streamer, cancelChan chan bool) error {
+	if !b.IsInitialized() {
+		return fmt.Errorf("audio backend not initialized")
+	}
+
+	// Wrap the stream in a Ctrl so this stream alone can be stopped without
+	// clearing anything else the speaker is mixing
+	ctrl := &beep.Ctrl{Streamer: streamer}
+	done := make(chan bool, 1)
+	speaker.Lock()
+	b.mixer.Add(beep.Seq(ctrl, beep.Callback(func() {
+		done <- true
+	})))
+	speaker.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-cancelChan:
+		speaker.Lock()
+		ctrl.Streamer = nil
+		speaker.Unlock()
+		return errPlaybackCancelled
+	}
+}
+
+func (b *beepBackend) StopAll() {
+	if b.IsInitialized() {
+		b.clearMixer()
+	}
+}
+
+// clearMixer silences every stream while leaving the mixer playing
+func (b *beepBackend) clearMixer() {
+	speaker.Lock()
+	b.mixer.Clear()
+	speaker.Unlock()
+}
+
+func (b *beepBackend) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.initialized {
+		speaker.Clear()
+		speaker.Close()
+		b.initialized = false
+		b.opened, b.openFailed = false, true
+		log.Printf("Audio backend %s closed", b.Name())
+	}
+	return nil
+}
+
+// initAudio creates the audio backend and opens the selected output device
+func initAudio() error {
+	if audioBackend == nil {
+		audioBackend = newBeepBackend()
+	}
+	return audioBackend.Init(currentAudioDevice())
+}
+
+// reinitAudioForDevice re-opens the backend after the OS output device changed.
+// It waits for any in-progress announcement so playback is not cut off.
+func reinitAudioForDevice(deviceID string) error {
+	if audioBackend == nil || !app.AudioEnabled {
+		return nil
+	}
+
+	globalAudioMutex.Lock()
+	defer globalAudioMutex.Unlock()
+
+	if err := audioBackend.Init(deviceID); err != nil {
+		notifyAudioBackendLost(deviceID, err)
+		return err
+	}
+	return nil
+}
+
+// closeAudio releases the audio device during shutdown
+func closeAudio() {
+	if audioBackend != nil {
+		audioBackend.Close()
+	}
+}
+
+// audioBackendName returns the active backend name for status reporting
+func audioBackendName() string {
+	if audioBackend == nil {
+		return "none"
+	}
+	return audioBackend.Name()
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gopxl/beep"
+	"github.com/gopxl/beep/mp3"
+	"github.com/gopxl/beep/wav"
+)
+
+// cachedClip is a fully decoded clip resampled to the backend output rate
+type cachedClip struct {
+	path       string
+	buffer     *beep.Buffer
+	sampleRate beep.SampleRate
+	modTime    time.Time
+	size       int64
+	bytes      int64
+}
+
+// AudioCache keeps recently played clips decoded in memory so sequences of
+// short clips (chime, train, direction, track) play back without disk and
+// decode latency between segments
+type AudioCache struct {
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	used    int64
+	hits    int64
+	misses  int64
+	evicted int64
+}
+
+// Global audio cache instance
+var audioCache = &AudioCache{
+	entries: make(map[string]*list.Element),
+	lru:     list.New(),
+}
+
+// get returns a cached clip if it is still current on disk
+func (ac *AudioCache) get(filePath string, info os.FileInfo, sampleRate beep.SampleRate) *cachedClip {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+
+	elem, ok := ac.entries[filePath]
+	if !ok {
+		ac.misses++
+		return nil
+	}
+
+	clip := elem.Value.(*cachedClip)
+	if !clip.modTime.Equal(info.ModTime()) || clip.size != info.Size() || clip.sampleRate != sampleRate {
+		// File was replaced or output format changed
+		ac.removeElement(elem)
+		ac.misses++
+		return nil
+	}
+
+	ac.lru.MoveToFront(elem)
+	ac.hits++
+	return clip
+}
+
+// put stores a decoded clip, evicting least recently used clips to fit
+func (ac *AudioCache) put(clip *cachedClip, maxBytes int64) {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+
+	if clip.bytes > maxBytes {
+		return
+	}
+
+	if elem, ok := ac.entries[clip.path]; ok {
+		ac.removeElement(elem)
+	}
+
+	for ac.used+clip.bytes > maxBytes && ac.lru.Len() > 0 {
+		ac.removeElement(ac.lru.Back())
+		ac.evicted++
+	}
+
+	ac.entries[clip.path] = ac.lru.PushFront(clip)
+	ac.used += clip.bytes
+}
+
+func (ac *AudioCache) removeElement(elem *list.Element) {
+	clip := elem.Value.(*cachedClip)
+	ac.lru.Remove(elem)
+	delete(ac.entries, clip.path)
+	ac.used -= clip.bytes
+}
+
+// Clear drops every cached clip
+func (ac *AudioCache) Clear() {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	ac.entries = make(map[string]*list.Element)
+	ac.lru.Init()
+	ac.used = 0
+}
+
+// Stats returns cache usage information for status endpoints
+func (ac *AudioCache) Stats() map[string]interface{} {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+
+	settings := getAudioSettings().Cache
+	return map[string]interface{}{
+		"enabled":          settings.Enabled,
+		"entries":          ac.lru.Len(),
+		"used_bytes":       ac.used,
+		"max_bytes":        int64(settings.MaxSizeMB) * 1024 * 1024,
+		"max_clip_seconds": settings.MaxClipSeconds,
+		"hits":             ac.hits,
+		"misses":           ac.misses,
+		"evicted":          ac.evicted,
+	}
+}
+
+// openAudioStream returns a streamer for filePath at the backend output rate, or
+// 44.1kHz when no backend is running. Short clips are decoded once and served
+// from memory; long files are streamed from disk. The returned close function
+// must always be called.
+func openAudioStream(filePath string) (beep.Streamer, func(), error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("audio file not found: %s", filePath)
+	}
+
+	outputRate := sampleRateOrDefault()
+	settings := getAudioSettings().Cache
+
+	if settings.Enabled {
+		if clip := audioCache.get(filePath, info, outputRate); clip != nil {
+			return clip.buffer.Streamer(0, clip.buffer.Len()), func() {}, nil
+		}
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open audio file: %v", err)
+	}
+
+	var streamer beep.StreamSeekCloser
+	var format beep.Format
+	if strings.EqualFold(filepath.Ext(filePath), ".wav") {
+		// Synthesized speech is written as WAV
+		streamer, format, err = wav.Decode(file)
+	} else {
+		streamer, format, err = mp3.Decode(file)
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to decode %s: %v", strings.ToUpper(strings.TrimPrefix(filepath.Ext(filePath), ".")), err)
+	}
+
+	resampled := beep.Resample(4, format.SampleRate, outputRate, streamer)
+
+	maxClip := time.Duration(settings.MaxClipSeconds) * time.Second
+	if !settings.Enabled || format.SampleRate.D(streamer.Len()) > maxClip {
+		return resampled, func() { streamer.Close() }, nil
+	}
+
+	// Decode the whole clip into memory and cache it
+	buffer := beep.NewBuffer(beep.Format{SampleRate: outputRate, NumChannels: 2, Precision: 2})
+	buffer.Append(resampled)
+	streamer.Close()
+
+	audioCache.put(&cachedClip{
+		path:       filePath,
+		buffer:     buffer,
+		sampleRate: outputRate,
+		modTime:    info.ModTime(),
+		size:       info.Size(),
+		bytes:      int64(buffer.Len()) * int64(buffer.Format().Width()),
+	}, int64(settings.MaxSizeMB)*1024*1024)
+
+	return buffer.Streamer(0, buffer.Len()), func() {}, nil
+}
+
+// preloadAudioCache decodes the configured preload clips so the first
+// announcement after startup does not pay the decode cost
+func preloadAudioCache() {
+	if audioBackend == nil || !app.AudioEnabled {
+		return
+	}
+
+	settings := getAudioSettings().Cache
+	if !settings.Enabled {
+		return
+	}
+
+	start := time.Now()
+	loaded := 0
+	for _, pattern := range settings.PreloadPatterns {
+		// Every audio root, skipping files an earlier root already has
+		var matches []string
+		seen := make(map[string]bool)
+		for _, root := range audioRoots() {
+			found, err := filepath.Glob(filepath.Join(root, audioLayoutPath(pattern)))
+			if err != nil {
+				log.Printf("Invalid audio preload pattern %q: %v", pattern, err)
+				break
+			}
+			for _, match := range found {
+				relative, _ := filepath.Rel(root, match)
+				if !seen[relative] {
+					seen[relative] = true
+					matches = append(matches, match)
+				}
+			}
+		}
+		for _, match := range matches {
+			_, closeStream, err := openAudioStream(match)
+			if err != nil {
+				log.Printf("Failed to preload %s: %v", match, err)
+				continue
+			}
+			closeStream()
+			loaded++
+		}
+	}
+
+	stats := audioCache.Stats()
+	log.Printf("✓ Preloaded %d audio clips in %v (%d entries, %.1f MB cached)",
+		loaded, time.Since(start).Round(time.Millisecond), stats["entries"], float64(stats["used_bytes"].(int64))/(1024*1024))
+}
+
+// API handlers
+
+func apiGetAudioCacheHandler(c *gin.Context) {
+	respondOK(c, gin.H{
+		"cache": audioCache.Stats(),
+	})
+}
+
+func apiClearAudioCacheHandler(c *gin.Context) {
+	audioCache.Clear()
+	go preloadAudioCache()
+	respondSuccess(c, http.StatusOK, "Audio cache cleared and preload restarted", nil)
+}
@@ -936,6 +936,12 @@ func getPlatformInfo() map[string]interface{} {
 		} else {
 			info["preferred_audio_system"] = "none"
 		}
+
+		// Sockets mounted into a container by the host
+		if containerAudio != nil {
+			info["container"] = true
+			info["audio_sockets"] = containerAudio
+		}
 		
 		// Raspberry Pi specific audio checks
 		if isRaspberryPi {
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// On Linux the sound server or udev reports devices as they come and go, so a
+// USB DAC being plugged in or pulled out is handled within a couple of seconds
+// instead of at the next audioRecoveryInterval poll. Other platforms rely on
+// the poll.
+
+const (
+	audioHotplugSettle       = 2 * time.Second  // Lets a device finish appearing before devices are listed
+	audioHotplugRestartDelay = 30 * time.Second // Before restarting a monitor command that exited
+)
+
+// Commands that print a line per sound device event, in order of preference:
+// the sound server also sees Bluetooth and network sinks, udev only sound cards
+var audioHotplugMonitors = [][]string{
+	{"pactl", "subscribe"},
+	{"udevadm", "monitor", "--udev", "--subsystem-match=sound"},
+}
+
+var (
+	audioHotplugTimer *time.Timer
+	audioHotplugMutex sync.Mutex
+)
+
+// startAudioHotplugWatcher runs the first available monitor command in the background
+func startAudioHotplugWatcher() {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	for _, command := range audioHotplugMonitors {
+		if _, err := exec.LookPath(command[0]); err == nil {
+			go watchAudioHotplug(command)
+			return
+		}
+	}
+	log.Printf("Neither pactl nor udevadm is installed; audio devices are checked every %s", audioRecoveryInterval)
+}
+
+// watchAudioHotplug runs a monitor command, restarting it if it exits (the
+// sound server restarting, say), and checks the devices on every add or
+// remove event
+func watchAudioHotplug(command []string) {
+	for {
+		cmd := exec.Command(command[0], command[1:]...)
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			log.Printf("Warning: cannot watch for audio device changes with %s: %v", command[0], err)
+			time.Sleep(audioHotplugRestartDelay)
+			continue
+		}
+		log.Printf("🔌 Watching for audio device changes with %s", command[0])
+
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if isAudioHotplugEvent(scanner.Text()) {
+				scheduleAudioDeviceCheck()
+			}
+		}
+		err = cmd.Wait()
+		log.Printf("Audio device monitor %s exited (%v), restarting in %s", command[0], err, audioHotplugRestartDelay)
+		time.Sleep(audioHotplugRestartDelay)
+	}
+}
+
+// isAudioHotplugEvent picks device add and remove events out of the monitor
+// output, which looks like
+//
+//	Event 'new' on sink #3                                  (pactl)
+//	UDEV  [1234.567890] remove   /devices/.../sound/card1 (sound)  (udevadm)
+func isAudioHotplugEvent(line string) bool {
+	if strings.HasPrefix(line, "Event '") {
+		addOrRemove := strings.HasPrefix(line, "Event 'new'") || strings.HasPrefix(line, "Event 'remove'")
+		return addOrRemove && (strings.Contains(line, " on sink ") || strings.Contains(line, " on card "))
+	}
+	if strings.HasPrefix(line, "UDEV") {
+		fields := strings.Fields(line)
+		return len(fields) > 2 && (fields[2] == "add" || fields[2] == "remove")
+	}
+	return false
+}
+
+// scheduleAudioDeviceCheck checks the devices once a burst of events has
+// settled; plugging in one device produces several
+func scheduleAudioDeviceCheck() {
+	audioHotplugMutex.Lock()
+	defer audioHotplugMutex.Unlock()
+	if audioHotplugTimer != nil {
+		audioHotplugTimer.Stop()
+	}
+	audioHotplugTimer = time.AfterFunc(audioHotplugSettle, func() {
+		checkAudioOutput(true)
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"github.com/gopxl/beep"
+)
+
+// Short ramp applied to generated tones so they start and stop without clicks
+const toneRampDuration = 10 * time.Millisecond
+
+// LeadInSettings controls what plays before an announcement and how its
+// volume is shaped
+type LeadInSettings struct {
+	Chime           string    `json:"chime"`            // Chime file relative to the mp3 directory ("" for none)
+	ToneMode        string    `json:"tone_mode"`        // "none", "single" or "dual"
+	ToneFrequencies []float64 `json:"tone_frequencies"` // Hz; dual mode plays the first two in sequence
+	ToneMs          int       `json:"tone_ms"`          // Length of each generated tone
+	ToneLevel       float64   `json:"tone_level"`       // Linear amplitude 0.0-1.0
+	FadeInMs        int       `json:"fade_in_ms"`       // Volume ramp at the start of the announcement
+	FadeOutMs       int       `json:"fade_out_ms"`      // Volume ramp at the end of the announcement
+}
+
+// leadInSettingsFor resolves lead-in settings for an announcement. A zone
+// override wins over a type override, which wins over the default.
+func leadInSettingsFor(announcementType AnnouncementType, parameters map[string]interface{}) LeadInSettings {
+	settings := getAudioSettings()
+
+	if zone, ok := parameters["zone"].(string); ok && zone != "" {
+		if leadIn, ok := settings.LeadInZones[zone]; ok {
+			return leadIn
+		}
+	}
+	if leadIn, ok := settings.LeadInTypes[string(announcementType)]; ok {
+		return leadIn
+	}
+	return settings.LeadIn
+}
+
+// chimePath returns the absolute chime file path, or "" when no chime is
+// configured or the configured one is outside the mp3 directory
+func (l LeadInSettings) chimePath() string {
+	if l.Chime == "" {
+		return ""
+	}
+	path, err := resolveAudioPath(l.Chime)
+	if err != nil {
+		log.Printf("Warning: ignoring lead-in chime: %v", err)
+		return ""
+	}
+	return path
+}
+
+// toneStreamer generates the configured attention tone(s), or nil for none
+func (l LeadInSettings) toneStreamer(sampleRate beep.SampleRate) beep.Streamer {
+	count := 0
+	switch l.ToneMode {
+	case "single":
+		count = 1
+	case "dual":
+		count = 2
+	default:
+		return nil
+	}
+
+	frequencies := l.ToneFrequencies
+	if len(frequencies) == 0 {
+		frequencies = []float64{659.25, 523.25} // E5 then C5, the classic two-tone chime
+	}
+
+	length := sampleRate.N(time.Duration(l.ToneMs) * time.Millisecond)
+	if length <= 0 {
+		length = sampleRate.N(400 * time.Millisecond)
+	}
+	level := l.ToneLevel
+	if level <= 0 || level > 1 {
+		level = 0.3
+	}
+
+	var tones []beep.Streamer
+	for i := 0; i < count; i++ {
+		frequency := frequencies[i%len(frequencies)]
+		tones = append(tones, newToneStreamer(sampleRate, frequency, level, length))
+	}
+	return beep.Seq(tones...)
+}
+
+// newToneStreamer returns a sine tone with short attack/release ramps
+func newToneStreamer(sampleRate beep.SampleRate, frequency, level float64, length int) beep.Streamer {
+	ramp := sampleRate.N(toneRampDuration)
+	if ramp*2 > length {
+		ramp = length / 2
+	}
+	step := 2 * math.Pi * frequency / float64(sampleRate)
+	position := 0
+
+	return beep.StreamerFunc(func(samples [][2]float64) (int, bool) {
+		if position >= length {
+			return 0, false
+		}
+		n := 0
+		for n < len(samples) && position < length {
+			gain := level
+			if position < ramp {
+				gain *= float64(position) / float64(ramp)
+			} else if remaining := length - position; remaining < ramp {
+				gain *= float64(remaining) / float64(ramp)
+			}
+			v := math.Sin(step*float64(position)) * gain
+			samples[n] = [2]float64{v, v}
+			n++
+			position++
+		}
+		return n, true
+	})
+}
+
+// envelopeStreamer ramps the volume up at the start and down at the end of a
+// stream. The end is detected with a lookahead of one fade-out length.
+type envelopeStreamer struct {
+	streamer beep.Streamer
+	fadeIn   int
+	fadeOut  int
+	position int
+	drained  bool
+	pending  [][2]float64
+	buf      [][2]float64
+}
+
+// withEnvelope wraps streamer in a fade-in/fade-out envelope when configured
+func withEnvelope(streamer beep.Streamer, sampleRate beep.SampleRate, fadeInMs, fadeOutMs int) beep.Streamer {
+	if fadeInMs <= 0 && fadeOutMs <= 0 {
+		return streamer
+	}
+	return &envelopeStreamer{
+		streamer: streamer,
+		fadeIn:   sampleRate.N(time.Duration(fadeInMs) * time.Millisecond),
+		fadeOut:  sampleRate.N(time.Duration(fadeOutMs) * time.Millisecond),
+		buf:      make([][2]float64, 1024),
+	}
+}
+
+func (e *envelopeStreamer) Stream(samples [][2]float64) (int, bool) {
+	// Fill the lookahead so the final fade-out window is always held back
+	for !e.drained && len(e.pending) < len(samples)+e.fadeOut {
+		n, ok := e.streamer.Stream(e.buf)
+		e.pending = append(e.pending, e.buf[:n]...)
+		if !ok {
+			e.drained = true
+			e.applyFadeOut()
+		}
+	}
+
+	available := len(e.pending)
+	if !e.drained {
+		available -= e.fadeOut
+	}
+	if available <= 0 {
+		return 0, false
+	}
+
+	n := copy(samples, e.pending[:available])
+	e.pending = e.pending[n:]
+
+	for i := 0; i < n && e.position < e.fadeIn; i++ {
+		gain := float64(e.position) / float64(e.fadeIn)
+		samples[i][0] *= gain
+		samples[i][1] *= gain
+		e.position++
+	}
+	return n, true
+}
+
+// applyFadeOut scales the held tail once the end of the stream is known
+func (e *envelopeStreamer) applyFadeOut() {
+	length := e.fadeOut
+	if length > len(e.pending) {
+		length = len(e.pending)
+	}
+	start := len(e.pending) - length
+	for i := 0; i < length; i++ {
+		gain := float64(length-i) / float64(length)
+		e.pending[start+i][0] *= gain
+		e.pending[start+i][1] *= gain
+	}
+}
+
+func (e *envelopeStreamer) Err() error {
+	return e.streamer.Err()
+}
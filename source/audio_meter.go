@@ -0,0 +1,225 @@
+package main
+
+import (
+	"log"
+	"math"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gopxl/beep"
+)
+
+// The output meter measures what is sent to each output device, which is how
+// zones are told apart: a zone is played on its speakers' devices. Levels are
+// the peak and RMS of the last meterWindow, in dBFS, and are shown in the queue
+// status. Separately each clip is watched as it is decoded; a clip that
+// decodes to nothing but zero samples is almost certainly a corrupt or empty
+// file, and is flagged until it plays with sound again.
+
+const (
+	meterWindow  = 100 * time.Millisecond // Levels are measured over this much audio
+	meterFloorDB = -96.0                  // Reported for digital silence (16-bit floor)
+)
+
+// outputLevel is the latest level sent to one output device
+type outputLevel struct {
+	Device    string    `json:"device"`
+	Zones     []string  `json:"zones,omitempty"`
+	PeakDB    float64   `json:"peak_db"`
+	RMSDB     float64   `json:"rms_db"`
+	Active    bool      `json:"active"` // Audio is being sent to the device now
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// silentAudioFile is a clip that decoded to silence
+type silentAudioFile struct {
+	File       string    `json:"file"`
+	DetectedAt time.Time `json:"detected_at"`
+	Count      int       `json:"count"` // Times it played silent since it last had sound
+}
+
+var outputMeter = struct {
+	mutex  sync.Mutex
+	levels map[string]*outputLevel
+	silent map[string]*silentAudioFile
+}{
+	levels: make(map[string]*outputLevel),
+	silent: make(map[string]*silentAudioFile),
+}
+
+// levelDB converts a linear level to dBFS, never below meterFloorDB
+func levelDB(level float64) float64 {
+	if level <= 0 {
+		return meterFloorDB
+	}
+	return math.Max(meterFloorDB, math.Round(20*math.Log10(level)*10)/10)
+}
+
+// meterStreamer measures the audio streamed through it for one device
+type meterStreamer struct {
+	streamer beep.Streamer
+	device   string
+	window   int // Samples per measurement
+	count    int
+	peak     float64
+	sumSq    float64
+	done     bool
+}
+
+// withMeter wraps the audio sent to the current output device so its level is
+// shown in the queue status
+func withMeter(streamer beep.Streamer) beep.Streamer {
+	device := "default"
+	if audioBackend != nil && audioBackend.DeviceID() != "" {
+		device = audioBackend.DeviceID()
+	}
+	window := 4410
+	if audioBackend != nil {
+		window = audioBackend.SampleRate().N(meterWindow)
+	}
+	return &meterStreamer{streamer: streamer, device: device, window: window}
+}
+
+func (m *meterStreamer) Stream(samples [][2]float64) (int, bool) {
+	n, ok := m.streamer.Stream(samples)
+	for _, s := range samples[:n] {
+		for _, v := range s {
+			m.peak = math.Max(m.peak, math.Abs(v))
+			m.sumSq += v * v
+		}
+		m.count++
+		if m.count >= m.window {
+			m.publish(true)
+		}
+	}
+	if !ok && !m.done {
+		m.done = true
+		m.publish(false)
+	}
+	return n, ok
+}
+
+func (m *meterStreamer) Err() error {
+	return m.streamer.Err()
+}
+
+// publish records the level measured since the last call and starts a new
+// measurement
+func (m *meterStreamer) publish(active bool) {
+	rms := 0.0
+	if m.count > 0 {
+		rms = math.Sqrt(m.sumSq / float64(m.count*2))
+	}
+	outputMeter.mutex.Lock()
+	level, ok := outputMeter.levels[m.device]
+	if !ok {
+		level = &outputLevel{Device: m.device}
+		outputMeter.levels[m.device] = level
+	}
+	if m.count > 0 || !active {
+		level.PeakDB, level.RMSDB = levelDB(m.peak), levelDB(rms)
+	}
+	level.Active = active
+	level.UpdatedAt = time.Now()
+	outputMeter.mutex.Unlock()
+	m.count, m.peak, m.sumSq = 0, 0, 0
+}
+
+// silenceDetector watches one clip as it is decoded and flags it if every
+// sample is zero
+type silenceDetector struct {
+	streamer beep.Streamer
+	file     string
+	samples  int
+	audible  bool
+	checked  bool
+}
+
+func newSilenceDetector(streamer beep.Streamer, file string) *silenceDetector {
+	return &silenceDetector{streamer: streamer, file: file}
+}
+
+func (d *silenceDetector) Stream(samples [][2]float64) (int, bool) {
+	n, ok := d.streamer.Stream(samples)
+	if !d.audible {
+		for _, s := range samples[:n] {
+			if s[0] != 0 || s[1] != 0 {
+				d.audible = true
+				break
+			}
+		}
+	}
+	d.samples += n
+	if !ok && !d.checked {
+		d.checked = true
+		recordClipSilence(d.file, d.samples, d.audible)
+	}
+	return n, ok
+}
+
+func (d *silenceDetector) Err() error {
+	return d.streamer.Err()
+}
+
+// recordClipSilence flags a clip that decoded to silence, or clears the flag
+// once it plays with sound
+func recordClipSilence(file string, samples int, audible bool) {
+	outputMeter.mutex.Lock()
+	defer outputMeter.mutex.Unlock()
+	if audible {
+		if _, flagged := outputMeter.silent[file]; flagged {
+			log.Printf("✓ Audio file has sound again: %s", filepath.Base(file))
+			delete(outputMeter.silent, file)
+		}
+		return
+	}
+	entry, flagged := outputMeter.silent[file]
+	if !flagged {
+		entry = &silentAudioFile{File: file}
+		outputMeter.silent[file] = entry
+		log.Printf("⚠️  Audio file decoded to silence (%d samples, all zero), it may be corrupt: %s", samples, file)
+	}
+	entry.DetectedAt = time.Now()
+	entry.Count++
+}
+
+// outputMeterStatus returns the levels by device, with the zones each device
+// serves, and the clips flagged as silent
+func outputMeterStatus() map[string]interface{} {
+	zonesByDevice := make(map[string][]string)
+	for _, zone := range getTrackLayout().Zones {
+		for _, speaker := range zone.Speakers {
+			if speaker == "" || speaker == "default" {
+				speaker = selectedAudioDevice()
+			}
+			zonesByDevice[speaker] = append(zonesByDevice[speaker], zone.ID)
+		}
+	}
+
+	outputMeter.mutex.Lock()
+	defer outputMeter.mutex.Unlock()
+	levels := make([]outputLevel, 0, len(outputMeter.levels))
+	for _, level := range outputMeter.levels {
+		entry := *level
+		entry.Zones = zonesByDevice[level.Device]
+		// A cancelled stream stops without saying so
+		if entry.Active && time.Since(entry.UpdatedAt) > time.Second {
+			entry.Active = false
+			entry.PeakDB, entry.RMSDB = meterFloorDB, meterFloorDB
+		}
+		levels = append(levels, entry)
+	}
+	sort.Slice(levels, func(i, j int) bool { return levels[i].Device < levels[j].Device })
+	silent := make([]silentAudioFile, 0, len(outputMeter.silent))
+	for _, entry := range outputMeter.silent {
+		silent = append(silent, *entry)
+	}
+	sort.Slice(silent, func(i, j int) bool { return silent[i].DetectedAt.After(silent[j].DetectedAt) })
+
+	return map[string]interface{}{
+		"levels":       levels,
+		"silent_files": silent,
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// Announcement parameters arrive from the API, the schedule, triggers and the
+// fleet manager, so they are never joined onto a path as they are. Catalog IDs
+// are resolved to their file through the catalog definition, and any other
+// relative path (chimes) must stay inside the mp3 directory.
+//
+// Audio is read from several roots: the mp3 directory, which uploads and
+// asset sync write to, then any read-only libraries (audio_library, e.g. a
+// shared NFS mount), the first root with the file winning. The layout in
+// audio_settings.json moves a category to another sub-path under every root,
+// so a library organised as numbers/trains/4.mp3 can stand in for train/4.mp3.
+
+// mp3Path returns a file under the mp3 directory, refusing absolute paths and
+// paths that climb out of it with ".."
+func mp3Path(relative string) (string, error) {
+	if !filepath.IsLocal(relative) {
+		return "", fmt.Errorf("audio path %q must be relative to the mp3 directory", relative)
+	}
+	return filepath.Join(app.Config.MP3Dir, relative), nil
+}
+
+// audioRoots lists the directories searched for audio, in order
+func audioRoots() []string {
+	return append([]string{app.Config.MP3Dir}, app.Config.AudioLibrary...)
+}
+
+// audioLayoutPath moves a relative audio path to its category's sub-path
+// from the layout, the category being its first directory
+func audioLayoutPath(relative string) string {
+	category, rest, found := strings.Cut(filepath.ToSlash(relative), "/")
+	if !found {
+		return relative
+	}
+	dir, ok := getAudioSettings().Layout[category]
+	if !ok || dir == "" {
+		return relative
+	}
+	if !filepath.IsLocal(dir) {
+		log.Printf("Warning: ignoring audio layout for %s: %q must be a relative path", category, dir)
+		return relative
+	}
+	return filepath.Join(filepath.FromSlash(dir), filepath.FromSlash(rest))
+}
+
+// resolveAudioPath returns the file to play for a path relative to the audio
+// roots: laid out by category and taken from the first root that has it.
+// When none has it the mp3 directory's path is returned, so the missing file
+// is reported where it would be uploaded.
+func resolveAudioPath(relative string) (string, error) {
+	if !filepath.IsLocal(relative) {
+		return "", fmt.Errorf("audio path %q must be relative to the mp3 directory", relative)
+	}
+	relative = audioLayoutPath(relative)
+	roots := audioRoots()
+	for _, root := range roots {
+		if candidate := filepath.Join(root, relative); fileExists(candidate) {
+			return candidate, nil
+		}
+	}
+	return filepath.Join(roots[0], relative), nil
+}
+
+// storedAudioPath is where a relative audio path is written: laid out by
+// category in the mp3 directory, never in a library
+func storedAudioPath(relative string) string {
+	return filepath.Join(app.Config.MP3Dir, audioLayoutPath(relative))
+}
+
+// audioDisplayPath shortens an audio file to its path under its root
+func audioDisplayPath(file string) string {
+	for _, root := range audioRoots() {
+		if relative, err := filepath.Rel(root, file); err == nil && filepath.IsLocal(relative) {
+			return filepath.ToSlash(relative)
+		}
+	}
+	return filepath.ToSlash(file)
+}
+
+// catalogAudioFile resolves a catalog ID to the audio file that announces it.
+// The ID must be a plain ID and, unless the catalog has no entries, one of
+// the catalog's IDs.
+func catalogAudioFile(catalogName, id string) (string, error) {
+	def, ok := findCatalogDefinition(catalogName)
+	if !ok {
+		return "", fmt.Errorf("unknown catalog: %s", catalogName)
+	}
+	if !validPathSegment(id) {
+		return "", fmt.Errorf("%s ID %q must be letters, digits, '_' or '-'", catalogName, id)
+	}
+	if ids := catalogIDs([]string{catalogName}); len(ids) > 0 && !ids[id] {
+		return "", fmt.Errorf("%s: %s", catalogName, unknownIDMessage(id, ids))
+	}
+	if item, found := findCatalogItem(def, id); found {
+		return def.itemAudioPath(item), nil
+	}
+	return def.audioPath(id), nil
+}
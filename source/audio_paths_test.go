@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// useTempAudioDirs points the JSON and mp3 directories at fresh temporary
+// ones and fills every catalog with the single entry "known"
+func useTempAudioDirs(t *testing.T) (jsonDir, mp3Dir string) {
+	t.Helper()
+	jsonDir, mp3Dir = t.TempDir(), t.TempDir()
+	previous := app
+	app = &App{Config: &Config{JSONDir: jsonDir, MP3Dir: mp3Dir}}
+	t.Cleanup(func() { app = previous })
+
+	for _, def := range catalogDefinitions {
+		path, _ := jsonFilePath(def.JSONName)
+		writeTestFile(t, path, `[{"id": "known", "name": "Known"}]`)
+	}
+	return jsonDir, mp3Dir
+}
+
+// insideDir reports whether path is dir or somewhere below it
+func insideDir(dir, path string) bool {
+	relative, err := filepath.Rel(dir, path)
+	return err == nil && filepath.IsLocal(relative)
+}
+
+// Values that must never reach a file path as they are
+var hostileAudioIDs = []string{
+	"../secret",
+	"../../etc/passwd",
+	"..",
+	".",
+	"train/../../secret",
+	"/etc/passwd",
+	`..\secret`,
+	`..\..\windows\win.ini`,
+	`C:\Windows\win.ini`,
+	"%2e%2e%2fsecret",
+	"%2e%2e/secret",
+	"..%2fsecret",
+	"%2F%2Fetc%2Fpasswd",
+	"known/../known",
+	"known.mp3",
+	"known\x00",
+	"",
+}
+
+func TestMP3Path(t *testing.T) {
+	_, mp3Dir := useTempAudioDirs(t)
+	windows := runtime.GOOS == "windows"
+
+	tests := []struct {
+		name     string
+		relative string
+		wantErr  bool
+	}{
+		{"file", "chime.mp3", false},
+		{"sub-directory", "train/4.mp3", false},
+		{"dot segment inside", "train/./4.mp3", false},
+		{"parent inside", "train/../chime.mp3", false},
+		{"empty", "", true},
+		{"parent", "../secret.mp3", true},
+		{"parent after a directory", "train/../../secret.mp3", true},
+		{"parent only", "..", true},
+		{"absolute", "/etc/passwd", true},
+		// Backslashes only separate paths on Windows; elsewhere they are
+		// part of a file name inside the mp3 directory
+		{"backslash parent", `..\secret.mp3`, windows},
+		{"backslash absolute", `C:\Windows\win.ini`, windows},
+		// Paths are never URL-decoded, so these are names inside the directory
+		{"encoded parent", "%2e%2e%2fsecret.mp3", false},
+		{"encoded parent directory", "%2e%2e/secret.mp3", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := mp3Path(tt.relative)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mp3Path(%q) = %q, %v; want error %v", tt.relative, path, err, tt.wantErr)
+			}
+			if err == nil && (!insideDir(mp3Dir, path) || path == mp3Dir) {
+				t.Errorf("mp3Path(%q) = %q, outside %s", tt.relative, path, mp3Dir)
+			}
+		})
+	}
+}
+
+// Every announcement parameter that names a catalog entry, with the file
+// the entry "known" plays
+var catalogAudioParameters = []struct {
+	announcementType AnnouncementType
+	parameter        string
+	catalog          string
+	file             string
+}{
+	{TypeStation, "train_number", "trains", "train/known.mp3"},
+	{TypeStation, "direction", "directions", "direction/known.mp3"},
+	{TypeStation, "destination", "destinations", "destination/known.mp3"},
+	{TypeStation, "track_number", "tracks", "track/known.mp3"},
+	{TypePromo, "file", "promos", "promo/known.mp3"},
+	{TypeSafety, "language", "safety", "safety/safety_known.mp3"},
+	{TypeEmergency, "file", "emergencies", "emergency/known.mp3"},
+	{TypeServiceChange, "reason", "service-change-reasons", "reason/known.mp3"},
+	{TypeServiceChange, "new_track", "tracks", "track/known.mp3"},
+	{TypeDelay, "train_number", "trains", "train/known.mp3"},
+	{TypeCancellation, "train_number", "trains", "train/known.mp3"},
+	{TypeCountdown, "destination", "destinations", "destination/known.mp3"},
+}
+
+func TestCatalogAudioFile(t *testing.T) {
+	_, mp3Dir := useTempAudioDirs(t)
+
+	for _, tt := range catalogAudioParameters {
+		t.Run(string(tt.announcementType)+"/"+tt.parameter, func(t *testing.T) {
+			path, err := catalogAudioFile(tt.catalog, "known")
+			if err != nil {
+				t.Fatalf("catalogAudioFile(%s, known): %v", tt.catalog, err)
+			}
+			if want := filepath.Join(mp3Dir, filepath.FromSlash(tt.file)); path != want {
+				t.Errorf("catalogAudioFile(%s, known) = %q, want %q", tt.catalog, path, want)
+			}
+
+			for _, id := range append(hostileAudioIDs, "unknown") {
+				if path, err := catalogAudioFile(tt.catalog, id); err == nil {
+					t.Errorf("catalogAudioFile(%s, %q) = %q, want an error", tt.catalog, id, path)
+				}
+			}
+		})
+	}
+
+	if _, err := catalogAudioFile("no-such-catalog", "known"); err == nil {
+		t.Error("catalogAudioFile of an unknown catalog succeeded")
+	}
+}
+
+func TestCatalogAudioFileEmptyCatalog(t *testing.T) {
+	_, mp3Dir := useTempAudioDirs(t)
+	path, _ := jsonFilePath("promo")
+	writeTestFile(t, path, `[]`)
+
+	// Without entries any plain ID is accepted, but still kept to its directory
+	got, err := catalogAudioFile("promos", "anything")
+	if err != nil {
+		t.Fatalf("catalogAudioFile(promos, anything): %v", err)
+	}
+	if want := filepath.Join(mp3Dir, "promo", "anything.mp3"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for _, id := range hostileAudioIDs {
+		if path, err := catalogAudioFile("promos", id); err == nil {
+			t.Errorf("catalogAudioFile(promos, %q) = %q, want an error", id, path)
+		}
+	}
+}
+
+func TestValidateAnnouncementParametersRejectsPaths(t *testing.T) {
+	useTempAudioDirs(t)
+
+	for _, tt := range catalogAudioParameters {
+		t.Run(string(tt.announcementType)+"/"+tt.parameter, func(t *testing.T) {
+			valid := map[string]interface{}{tt.parameter: "known"}
+			if err := validateAnnouncementParameters(tt.announcementType, valid); err != nil {
+				t.Errorf("known ID refused: %v", err)
+			}
+
+			for _, id := range append(hostileAudioIDs, "unknown") {
+				// An empty value is left to the audio builder, which requires it
+				if id == "" {
+					continue
+				}
+				err := validateAnnouncementParameters(tt.announcementType, map[string]interface{}{tt.parameter: id})
+				var paramErr *announcementParameterError
+				if !errors.As(err, &paramErr) {
+					t.Errorf("%s=%q: got %v, want a parameter error", tt.parameter, id, err)
+					continue
+				}
+				if len(paramErr.details) != 1 || paramErr.details[0].Field != tt.parameter {
+					t.Errorf("%s=%q: details %+v, want one for %s", tt.parameter, id, paramErr.details, tt.parameter)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveAudioPathStaysInRoots(t *testing.T) {
+	_, mp3Dir := useTempAudioDirs(t)
+	library := t.TempDir()
+	app.Config.AudioLibrary = []string{library}
+	if err := os.MkdirAll(filepath.Join(library, "train"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(library, "train", "4.mp3"), "")
+
+	if got, err := resolveAudioPath("train/4.mp3"); err != nil || got != filepath.Join(library, "train", "4.mp3") {
+		t.Errorf("resolveAudioPath(train/4.mp3) = %q, %v; want the library's file", got, err)
+	}
+	if got, err := resolveAudioPath("train/5.mp3"); err != nil || got != filepath.Join(mp3Dir, "train", "5.mp3") {
+		t.Errorf("resolveAudioPath(train/5.mp3) = %q, %v; want the mp3 directory's path", got, err)
+	}
+	for _, relative := range []string{"", "../secret.mp3", "train/../../secret.mp3", "/etc/passwd"} {
+		if got, err := resolveAudioPath(relative); err == nil {
+			t.Errorf("resolveAudioPath(%q) = %q, want an error", relative, got)
+		}
+	}
+	if got := audioDisplayPath(filepath.Join(library, "train", "4.mp3")); !strings.HasPrefix(got, "train/") {
+		t.Errorf("audioDisplayPath = %q, want train/4.mp3", got)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// When the audio output can't be opened, for example because a USB DAC has not
+// enumerated yet at boot, the annunciator runs with audio disabled and keeps
+// trying to open it every audioRecoveryInterval. The sound hardware is watched
+// at the same interval, and straight away when audio_hotplug.go sees a device
+// come or go: when the selected device disappears, audio switches to the first
+// connected device in audio_settings.json's preferred_devices, and back when
+// the selected device returns. With none of them connected audio is disabled
+// and an alert sent, so announcements fail visibly rather than playing to
+// nothing. POST /admin/audio/reinit tries straight away.
+//
+// A backend that can only open its output once per process (beep) reports
+// errAudioRestartRequired, and the annunciator restarts itself to recover, but
+// only once the sound hardware has changed since the failure so a missing
+// device doesn't cause a restart loop.
+
+const audioRecoveryInterval = 30 * time.Second
+
+var (
+	errAudioRestartRequired = errors.New("the audio output can only be reopened by restarting the annunciator")
+	errNoAudioDevice        = errors.New("neither the selected audio device nor any preferred device is connected")
+)
+
+// audioRecoveryStatus reports the recovery attempts for the admin UI
+type audioRecoveryStatus struct {
+	Attempts       int        `json:"attempts"`
+	LastAttempt    *time.Time `json:"last_attempt,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	RecoveredAt    *time.Time `json:"recovered_at,omitempty"`
+	DeviceMissing  bool       `json:"device_missing"`
+	FallbackDevice string     `json:"fallback_device,omitempty"` // Preferred device playing while the selected one is missing
+}
+
+var (
+	audioRecovery      audioRecoveryStatus
+	audioHardware      string // Sound hardware when last checked
+	audioFailedOn      string // Sound hardware when the output last failed to open
+	audioRecoveryMutex sync.Mutex
+
+	audioCheckMutex sync.Mutex // One device check at a time
+)
+
+// currentAudioRecoveryStatus returns a copy of the recovery status
+func currentAudioRecoveryStatus() audioRecoveryStatus {
+	audioRecoveryMutex.Lock()
+	defer audioRecoveryMutex.Unlock()
+	return audioRecovery
+}
+
+// selectedAudioDevice returns the operator's chosen device, "default" if none
+func selectedAudioDevice() string {
+	if currentAudioDevice() == "" {
+		return "default"
+	}
+	return currentAudioDevice()
+}
+
+// activeAudioDevice returns the device audio plays to: the fallback while
+// the selected device is missing, otherwise the selected device
+func activeAudioDevice() string {
+	audioRecoveryMutex.Lock()
+	defer audioRecoveryMutex.Unlock()
+	if audioRecovery.FallbackDevice != "" {
+		return audioRecovery.FallbackDevice
+	}
+	return selectedAudioDevice()
+}
+
+// clearAudioFallback forgets the fallback device after the operator selects
+// a device themselves
+func clearAudioFallback() {
+	audioRecoveryMutex.Lock()
+	audioRecovery.FallbackDevice = ""
+	audioRecovery.DeviceMissing = false
+	audioRecoveryMutex.Unlock()
+}
+
+// chooseAudioDevice returns the most preferred connected device: the selected
+// device, then preferred_devices in order. It returns "" when none of them is
+// connected. The system default is always connected.
+func chooseAudioDevice() string {
+	choices := append([]string{selectedAudioDevice()}, getAudioSettings().PreferredDevices...)
+	connected := make(map[string]bool)
+	for _, device := range getAudioDevices() {
+		connected[device.ID] = true
+	}
+	for _, choice := range choices {
+		if choice == "default" || connected[choice] {
+			return choice
+		}
+	}
+	return ""
+}
+
+// audioHardwareFingerprint summarises the connected sound hardware so changes
+// can be spotted cheaply; on Linux that's the ALSA card list
+func audioHardwareFingerprint() string {
+	if runtime.GOOS == "linux" {
+		if cards, err := os.ReadFile("/proc/asound/cards"); err == nil {
+			return string(cards)
+		}
+	}
+	var ids []string
+	for _, device := range getAudioDevices() {
+		ids = append(ids, device.ID)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, "\n")
+}
+
+// startAudioRecovery watches the audio output in the background. Called once
+// at startup after the first attempt to open it.
+func startAudioRecovery() {
+	audioRecoveryMutex.Lock()
+	audioHardware = audioHardwareFingerprint()
+	if !app.AudioEnabled {
+		audioFailedOn = audioHardware
+	}
+	audioRecoveryMutex.Unlock()
+
+	go func() {
+		// The selected device may not have been connected at startup
+		if app.AudioEnabled {
+			checkAudioOutput(true)
+		}
+		ticker := time.NewTicker(audioRecoveryInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			checkAudioOutput(false)
+		}
+	}()
+	startAudioHotplugWatcher()
+}
+
+// checkAudioOutput retries a failed output and follows devices being
+// unplugged and plugged back in. hotplug is set when a device event prompted
+// the check, since events for sound server sinks (Bluetooth, network) don't
+// change the ALSA card list.
+func checkAudioOutput(hotplug bool) {
+	audioCheckMutex.Lock()
+	defer audioCheckMutex.Unlock()
+
+	hardware := audioHardwareFingerprint()
+	audioRecoveryMutex.Lock()
+	changed := hotplug || hardware != audioHardware
+	audioHardware = hardware
+	missing := audioRecovery.DeviceMissing
+	audioRecoveryMutex.Unlock()
+
+	if !changed {
+		// Nothing was plugged in, so only an output that failed to open is
+		// worth another try
+		if !app.AudioEnabled && !missing {
+			if restart, _ := openAudioOutput(activeAudioDevice(), false); restart {
+				restartApplication()
+			}
+		}
+		return
+	}
+
+	previous := activeAudioDevice()
+	device := chooseAudioDevice()
+	if app.AudioEnabled && device == previous {
+		return
+	}
+	switch {
+	case device == "":
+		log.Printf("🔇 Audio device %s disconnected and no preferred device is connected", previous)
+	case device != previous:
+		log.Printf("🔊 Switching audio output from %s to %s", previous, device)
+	}
+	restart, err := switchAudioDevice(device, false)
+	if err == nil && device != previous {
+		notifyAudioDeviceChanged(previous, device)
+	}
+	if restart {
+		restartApplication()
+	}
+}
+
+// switchAudioDevice makes device the system output and opens the audio
+// output on it; device is "" when none is connected
+func switchAudioDevice(device string, forceRestart bool) (bool, error) {
+	if device != "" {
+		if err := setAudioDevice(device); err != nil {
+			log.Printf("Warning: failed to select audio device %s: %v", device, err)
+		}
+	}
+	return openAudioOutput(device, forceRestart)
+}
+
+// openAudioOutput opens the audio output on device ("" when none is
+// connected) and records the attempt. It reports whether the process must
+// restart to recover: always when forced, otherwise only when the hardware has
+// changed since the output last failed to open.
+func openAudioOutput(device string, forceRestart bool) (bool, error) {
+	err := errNoAudioDevice
+	if device != "" {
+		if audioBackend == nil {
+			audioBackend = newBeepBackend()
+		}
+		globalAudioMutex.Lock()
+		err = audioBackend.Init(device)
+		globalAudioMutex.Unlock()
+	}
+
+	audioRecoveryMutex.Lock()
+	defer audioRecoveryMutex.Unlock()
+	now := time.Now()
+	audioRecovery.Attempts++
+	audioRecovery.LastAttempt = &now
+	audioRecovery.DeviceMissing = device == ""
+
+	if err == nil {
+		// A replugged device comes back with its mixer at the default level
+		resetHardwareVolume()
+		audioRecovery.LastError = ""
+		audioRecovery.FallbackDevice = ""
+		if device != selectedAudioDevice() {
+			audioRecovery.FallbackDevice = device
+		}
+		if !app.AudioEnabled {
+			audioRecovery.RecoveredAt = &now
+			app.AudioEnabled = true
+			log.Println("✓ Audio system recovered")
+			go preloadAudioCache()
+		}
+		return false, nil
+	}
+
+	if err.Error() != audioRecovery.LastError {
+		log.Printf("Audio re-initialization failed: %v", err)
+	}
+	audioRecovery.LastError = err.Error()
+	if app.AudioEnabled {
+		app.AudioEnabled = false
+		lost := device
+		if lost == "" {
+			lost = selectedAudioDevice()
+		}
+		notifyAudioBackendLost(lost, err)
+	}
+	if errors.Is(err, errAudioRestartRequired) {
+		if forceRestart {
+			return true, err
+		}
+		if audioHardware != audioFailedOn {
+			log.Printf("🔄 Sound hardware changed since audio failed, restarting to reopen the output")
+			return true, err
+		}
+		return false, err
+	}
+	audioFailedOn = audioHardware
+	return false, err
+}
+
+// Handlers
+
+// reinitAudioHandler re-opens the audio output now on the most preferred
+// connected device, restarting the annunciator if that is the only way to
+// open it
+func reinitAudioHandler(c *gin.Context) {
+	log.Printf("Audio re-initialization requested by admin user")
+	audioCheckMutex.Lock()
+	restart, err := switchAudioDevice(chooseAudioDevice(), true)
+	audioCheckMutex.Unlock()
+	status := currentAudioRecoveryStatus()
+
+	if restart {
+		c.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"restarting": true,
+			"message":    "The audio output can only be reopened by restarting; the annunciator is restarting",
+			"recovery":   status,
+		})
+		go restartApplication()
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success":  false,
+			"error":    "Audio re-initialization failed: " + err.Error(),
+			"recovery": status,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":         true,
+		"message":         "Audio output re-initialized",
+		"audio_available": app.AudioEnabled,
+		"recovery":        status,
+	})
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+	"time"
+
+	"github.com/gopxl/beep"
+)
+
+// Longest quiet run held back while trimming silence; longer pauses are
+// treated as intentional and played as-is
+const maxHeldSilence = 2 * time.Second
+
+// SequenceSettings controls how clips are joined into one announcement
+type SequenceSettings struct {
+	GapMs            int     `json:"gap_ms"`            // Silence inserted between clips
+	CrossfadeMs      int     `json:"crossfade_ms"`      // Overlap between clips (replaces the gap when > 0)
+	TrimSilence      bool    `json:"trim_silence"`      // Strip leading/trailing silence from each clip
+	SilenceThreshold float64 `json:"silence_threshold"` // Linear amplitude below which a sample counts as silence
+}
+
+// sequenceSettingsFor returns the template for an announcement type, falling
+// back to the default sequence settings
+func sequenceSettingsFor(announcementType AnnouncementType) SequenceSettings {
+	settings := getAudioSettings()
+	if template, ok := settings.Templates[string(announcementType)]; ok {
+		return template
+	}
+	return defaultSequenceSettings()
+}
+
+// defaultSequenceSettings returns the sequence settings with the gap from
+// settings.json
+func defaultSequenceSettings() SequenceSettings {
+	sequence := getAudioSettings().Sequence
+	sequence.GapMs = currentRuntimeSettings().GapMs
+	return sequence
+}
+
+// playSequenceWithCancellation composes the lead-in tone and clips into a
+// single stream and plays it, so gaps, crossfades and fades are sample accurate.
+// When archive is not nil the composed stream is also recorded to it.
+func playSequenceWithCancellation(filePaths []string, settings SequenceSettings, leadIn LeadInSettings, cancelChan chan bool, archive *audioArchiveWriter) error {
+	if !app.AudioEnabled || audioBackend == nil {
+		log.Printf("Audio not available - would play sequence: %v", filePaths)
+		return fmt.Errorf("audio not available")
+	}
+
+	composed, closeAll, err := composeSequence(filePaths, settings, leadIn, audioBackend.SampleRate())
+	defer closeAll()
+	if err != nil {
+		return err
+	}
+	if composed == nil {
+		return nil
+	}
+
+	if archive != nil {
+		composed = archive.tap(composed)
+	}
+	return audioBackend.Play(withMeter(applyVolume(composed)), cancelChan)
+}
+
+// composeSequence builds the lead-in tone and clips into one stream at
+// sampleRate. It returns nil if there is nothing to play. closeAll must be
+// called once the stream is no longer needed, even on error.
+func composeSequence(filePaths []string, settings SequenceSettings, leadIn LeadInSettings, sampleRate beep.SampleRate) (beep.Streamer, func(), error) {
+	var segments []beep.Streamer
+	var closers []func()
+	closeAll := func() {
+		for _, closeStream := range closers {
+			closeStream()
+		}
+	}
+
+	if tone := leadIn.toneStreamer(sampleRate); tone != nil {
+		segments = append(segments, tone)
+	}
+
+	for _, filePath := range filePaths {
+		if !fileExists(filePath) {
+			log.Printf("Missing audio file: %s", filePath)
+			continue
+		}
+
+		var streamer beep.Streamer
+		var closeStream func()
+		err := retryTransientAudio("Opening "+filepath.Base(filePath), nil, func() error {
+			var err error
+			streamer, closeStream, err = openAudioStream(filePath)
+			return err
+		})
+		if err != nil {
+			return nil, closeAll, fmt.Errorf("error playing %s: %v", filePath, err)
+		}
+		closers = append(closers, closeStream)
+		streamer = newSilenceDetector(streamer, filePath)
+
+		if settings.TrimSilence {
+			streamer = newTrimSilenceStreamer(streamer, settings.SilenceThreshold, sampleRate.N(maxHeldSilence))
+		}
+		segments = append(segments, streamer)
+		log.Printf("Queued segment: %s", filepath.Base(filePath))
+	}
+
+	if len(segments) == 0 {
+		return nil, closeAll, nil
+	}
+
+	var composed beep.Streamer
+	if settings.CrossfadeMs > 0 && len(segments) > 1 {
+		composed = newCrossfadeStreamer(segments, sampleRate.N(time.Duration(settings.CrossfadeMs)*time.Millisecond))
+	} else {
+		gap := sampleRate.N(time.Duration(settings.GapMs) * time.Millisecond)
+		var parts []beep.Streamer
+		for i, segment := range segments {
+			if i > 0 && gap > 0 {
+				parts = append(parts, beep.Silence(gap))
+			}
+			parts = append(parts, segment)
+		}
+		composed = beep.Seq(parts...)
+	}
+
+	return withEnvelope(composed, sampleRate, leadIn.FadeInMs, leadIn.FadeOutMs), closeAll, nil
+}
+
+// trimSilenceStreamer drops silence before the first and after the last
+// audible sample while keeping pauses inside the clip
+type trimSilenceStreamer struct {
+	streamer  beep.Streamer
+	threshold float64
+	maxHold   int
+	started   bool
+	drained   bool
+	quiet     [][2]float64
+	ready     [][2]float64
+	buf       [][2]float64
+}
+
+func newTrimSilenceStreamer(streamer beep.Streamer, threshold float64, maxHold int) *trimSilenceStreamer {
+	if threshold <= 0 {
+		threshold = 0.01
+	}
+	return &trimSilenceStreamer{
+		streamer:  streamer,
+		threshold: threshold,
+		maxHold:   maxHold,
+		buf:       make([][2]float64, 1024),
+	}
+}
+
+func (t *trimSilenceStreamer) Stream(samples [][2]float64) (int, bool) {
+	for len(t.ready) < len(samples) && !t.drained {
+		n, ok := t.streamer.Stream(t.buf)
+		for _, s := range t.buf[:n] {
+			loud := math.Abs(s[0]) > t.threshold || math.Abs(s[1]) > t.threshold
+			if !t.started {
+				if !loud {
+					continue
+				}
+				t.started = true
+			}
+			if loud {
+				t.ready = append(t.ready, t.quiet...)
+				t.quiet = t.quiet[:0]
+				t.ready = append(t.ready, s)
+			} else {
+				t.quiet = append(t.quiet, s)
+				if len(t.quiet) > t.maxHold {
+					t.ready = append(t.ready, t.quiet...)
+					t.quiet = t.quiet[:0]
+				}
+			}
+		}
+		if !ok {
+			t.drained = true
+		}
+	}
+
+	n := copy(samples, t.ready)
+	t.ready = t.ready[n:]
+	return n, n > 0
+}
+
+func (t *trimSilenceStreamer) Err() error {
+	return t.streamer.Err()
+}
+
+// crossfadeStreamer plays segments back to back, overlapping the end of each
+// segment with the start of the next using linear fades
+type crossfadeStreamer struct {
+	segments []beep.Streamer
+	fade     int
+	index    int
+	pending  [][2]float64
+	buf      [][2]float64
+}
+
+func newCrossfadeStreamer(segments []beep.Streamer, fade int) *crossfadeStreamer {
+	return &crossfadeStreamer{
+		segments: segments,
+		fade:     fade,
+		buf:      make([][2]float64, 1024),
+	}
+}
+
+func (cs *crossfadeStreamer) Stream(samples [][2]float64) (int, bool) {
+	filled := 0
+	for filled < len(samples) {
+		active := cs.index < len(cs.segments)
+
+		// Keep a lookahead of one fade length so the tail is available to mix
+		if active && len(cs.pending) <= cs.fade {
+			n, ok := cs.segments[cs.index].Stream(cs.buf)
+			cs.pending = append(cs.pending, cs.buf[:n]...)
+			if !ok {
+				cs.advance()
+			}
+			continue
+		}
+
+		available := len(cs.pending)
+		if active {
+			available -= cs.fade
+		}
+		if available <= 0 {
+			break
+		}
+
+		n := copy(samples[filled:], cs.pending[:available])
+		cs.pending = cs.pending[n:]
+		filled += n
+	}
+	return filled, filled > 0
+}
+
+// advance mixes the held tail of the finished segment with the head of the next
+func (cs *crossfadeStreamer) advance() {
+	cs.index++
+	if cs.index >= len(cs.segments) {
+		return
+	}
+
+	overlap := cs.fade
+	if overlap > len(cs.pending) {
+		overlap = len(cs.pending)
+	}
+	tail := cs.pending[len(cs.pending)-overlap:]
+
+	head := make([][2]float64, overlap)
+	read := 0
+	drained := false
+	for read < overlap {
+		n, ok := cs.segments[cs.index].Stream(head[read:])
+		read += n
+		if !ok {
+			drained = true
+			break
+		}
+	}
+
+	for i := 0; i < read; i++ {
+		mix := float64(i) / float64(overlap)
+		tail[i][0] = tail[i][0]*(1-mix) + head[i][0]*mix
+		tail[i][1] = tail[i][1]*(1-mix) + head[i][1]*mix
+	}
+
+	if drained {
+		cs.advance()
+	}
+}
+
+func (cs *crossfadeStreamer) Err() error {
+	for _, segment := range cs.segments {
+		if err := segment.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
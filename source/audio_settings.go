@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// AudioCacheSettings controls the in-memory decoded clip cache
+type AudioCacheSettings struct {
+	Enabled         bool     `json:"enabled"`
+	MaxSizeMB       int      `json:"max_size_mb"`      // Total memory budget for decoded clips
+	MaxClipSeconds  int      `json:"max_clip_seconds"` // Longer files (promos, safety) are streamed from disk
+	PreloadPatterns []string `json:"preload_patterns"` // Globs relative to the mp3 directory warmed at startup
+}
+
+// AudioSettings holds playback tuning loaded from json/audio_settings.json
+type AudioSettings struct {
+	Cache     AudioCacheSettings          `json:"cache"`
+	Sequence  SequenceSettings            `json:"sequence"`  // Default clip joining
+	Templates map[string]SequenceSettings `json:"templates"` // Per announcement type overrides
+
+	LeadIn      LeadInSettings            `json:"lead_in"`       // Default attention tone and envelope
+	LeadInTypes map[string]LeadInSettings `json:"lead_in_types"` // Per announcement type overrides
+	LeadInZones map[string]LeadInSettings `json:"lead_in_zones"` // Per zone overrides (highest precedence)
+
+	SpeakerTest SpeakerTestSettings `json:"speaker_test"` // Scheduled test tone on each output
+
+	// Layout stores a category under another sub-path of every audio root,
+	// e.g. "train": "numbers/trains" plays numbers/trains/4.mp3 for train/4
+	Layout map[string]string `json:"layout"`
+
+	PreferredDevices []string `json:"preferred_devices"` // Output devices to fall back to, in order, while the selected one is disconnected
+
+	Archive AudioArchiveSettings `json:"archive"` // Recordings of played announcements
+
+	TTS TTSSettings `json:"tts"` // Speech synthesizer for spoken text
+
+	Transcode TranscodeSettings `json:"transcode"` // Normalizing uploaded recordings
+
+	Watchdog PlaybackWatchdogSettings `json:"watchdog"` // Time limit on announcements stuck in the audio output
+
+	Retry PlaybackRetrySettings `json:"retry"` // Trying again after transient audio errors
+
+	Hooks AnnouncementHookSettings `json:"hooks"` // Commands and requests run before and after each announcement
+}
+
+var (
+	audioSettings      *AudioSettings
+	audioSettingsMutex sync.RWMutex
+)
+
+// getDefaultAudioSettings returns settings used when audio_settings.json is missing
+func getDefaultAudioSettings() *AudioSettings {
+	return &AudioSettings{
+		Cache: AudioCacheSettings{
+			Enabled:        true,
+			MaxSizeMB:      32,
+			MaxClipSeconds: 15,
+			PreloadPatterns: []string{
+				"chime.mp3",
+				"track/*.mp3",
+				"direction/*.mp3",
+			},
+		},
+		Sequence: SequenceSettings{
+			GapMs:            300,
+			SilenceThreshold: 0.01,
+		},
+		Templates: map[string]SequenceSettings{},
+		Layout:    map[string]string{},
+		LeadIn: LeadInSettings{
+			ToneMode: "none",
+		},
+		LeadInTypes: map[string]LeadInSettings{
+			// Station announcements have always opened with the chime
+			string(TypeStation):       {Chime: "chime.mp3", ToneMode: "none"},
+			string(TypeServiceChange): {Chime: "chime.mp3", ToneMode: "none"},
+			string(TypeDelay):         {Chime: "chime.mp3", ToneMode: "none"},
+			string(TypeCancellation):  {Chime: "chime.mp3", ToneMode: "none"},
+		},
+		LeadInZones: map[string]LeadInSettings{},
+		SpeakerTest: SpeakerTestSettings{
+			Enabled:     false,
+			Cron:        "0 6 * * *",
+			FrequencyHz: 1000,
+			DurationMs:  500,
+			Level:       0.2,
+		},
+		Archive:   getDefaultAudioArchiveSettings(),
+		TTS:       getDefaultTTSSettings(),
+		Transcode: getDefaultTranscodeSettings(),
+		Watchdog:  getDefaultPlaybackWatchdogSettings(),
+		Retry:     getDefaultPlaybackRetrySettings(),
+		Hooks:     getDefaultAnnouncementHookSettings(),
+	}
+}
+
+// loadAudioSettings reads audio_settings.json, falling back to defaults
+func loadAudioSettings() error {
+	settings := getDefaultAudioSettings()
+	configPath := filepath.Join(app.Config.JSONDir, "audio_settings.json")
+
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		setAudioSettings(settings)
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		setAudioSettings(settings)
+		return fmt.Errorf("failed to read audio_settings.json: %v", err)
+	}
+
+	if err := json.Unmarshal(data, settings); err != nil {
+		setAudioSettings(getDefaultAudioSettings())
+		return fmt.Errorf("failed to parse audio_settings.json: %v", err)
+	}
+
+	setAudioSettings(settings)
+	log.Printf("✓ Loaded audio settings")
+	return nil
+}
+
+// saveAudioSettings writes the settings back to audio_settings.json
+func saveAudioSettings(settings *AudioSettings) error {
+	configPath := filepath.Join(app.Config.JSONDir, "audio_settings.json")
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal audio settings: %v", err)
+	}
+	if err := ioutil.WriteFile(configPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write audio_settings.json: %v", err)
+	}
+	setAudioSettings(settings)
+	return nil
+}
+
+func setAudioSettings(settings *AudioSettings) {
+	audioSettingsMutex.Lock()
+	audioSettings = settings
+	audioSettingsMutex.Unlock()
+}
+
+// getAudioSettings returns the current audio settings (defaults if not loaded)
+func getAudioSettings() *AudioSettings {
+	audioSettingsMutex.RLock()
+	defer audioSettingsMutex.RUnlock()
+	if audioSettings == nil {
+		return getDefaultAudioSettings()
+	}
+	return audioSettings
+}
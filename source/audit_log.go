@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Events that leave no other record, such as text stopped by the content
+// filter, are appended to the audit log in logs/audit/, one JSON line each in
+// a file per day. The audit export merges them with the revisions and
+// schedule changes (see exports.go).
+
+// auditEntry is one event in the audit trail
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	Source    string    `json:"source"` // "revision", "schedule_change" or "content_filter"
+	Action    string    `json:"action"`
+	User      string    `json:"user,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	Reference string    `json:"reference,omitempty"` // Revision or change ID
+	Detail    string    `json:"detail,omitempty"`
+}
+
+var auditFileMutex sync.Mutex
+
+// auditLogDir is where the audit log is kept
+func auditLogDir() string {
+	return filepath.Join(app.Config.LogDir, "audit")
+}
+
+// recordAuditEvent appends an event to its day's audit log file
+func recordAuditEvent(entry auditEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Warning: failed to record %s %s in the audit log: %v", entry.Source, entry.Action, err)
+		return
+	}
+
+	auditFileMutex.Lock()
+	defer auditFileMutex.Unlock()
+	if err := os.MkdirAll(auditLogDir(), 0755); err != nil {
+		log.Printf("Warning: failed to record %s %s in the audit log: %v", entry.Source, entry.Action, err)
+		return
+	}
+	path := filepath.Join(auditLogDir(), entry.Time.Format("2006-01-02")+".jsonl")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Warning: failed to record %s %s in the audit log: %v", entry.Source, entry.Action, err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Printf("Warning: failed to record %s %s in the audit log: %v", entry.Source, entry.Action, err)
+	}
+}
+
+// readAuditLog returns the logged events between from (inclusive) and until
+// (exclusive), in the order they were logged
+func readAuditLog(from, until time.Time) ([]auditEntry, error) {
+	auditFileMutex.Lock()
+	defer auditFileMutex.Unlock()
+
+	var entries []auditEntry
+	first := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	for day := first; day.Before(until); day = day.AddDate(0, 0, 1) {
+		file, err := os.Open(filepath.Join(auditLogDir(), day.Format("2006-01-02")+".jsonl"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var entry auditEntry
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil && !entry.Time.Before(from) && entry.Time.Before(until) {
+				entries = append(entries, entry)
+			}
+		}
+		file.Close()
+	}
+	return entries, nil
+}
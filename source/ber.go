@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// BER encoding helpers for the SNMP trap receiver
+
+const berMaxElementLength = 1 << 20
+
+// berElement is one decoded tag-length-value
+type berElement struct {
+	tag      byte
+	content  []byte
+	children []berElement
+}
+
+func berEncode(tag byte, content []byte) []byte {
+	out := []byte{tag}
+	length := len(content)
+	switch {
+	case length < 0x80:
+		out = append(out, byte(length))
+	case length <= 0xff:
+		out = append(out, 0x81, byte(length))
+	case length <= 0xffff:
+		out = append(out, 0x82, byte(length>>8), byte(length))
+	default:
+		out = append(out, 0x83, byte(length>>16), byte(length>>8), byte(length))
+	}
+	return append(out, content...)
+}
+
+func berParseInt(content []byte) int {
+	value := 0
+	for i, b := range content {
+		if i == 0 && b&0x80 != 0 {
+			value = -1
+		}
+		value = value<<8 | int(b)
+	}
+	return value
+}
+
+func concatBytes(parts ...[]byte) []byte {
+	var out []byte
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+	return out
+}
+
+// berRead reads one element from the stream and decodes its children
+func berRead(reader *bufio.Reader) (berElement, error) {
+	tag, err := reader.ReadByte()
+	if err != nil {
+		return berElement{}, err
+	}
+	length, err := berReadLength(reader)
+	if err != nil {
+		return berElement{}, err
+	}
+	if length > berMaxElementLength {
+		return berElement{}, fmt.Errorf("message too large (%d bytes)", length)
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(reader, content); err != nil {
+		return berElement{}, err
+	}
+	return berDecode(tag, content)
+}
+
+func berReadLength(reader io.ByteReader) (int, error) {
+	first, err := reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if first < 0x80 {
+		return int(first), nil
+	}
+	count := int(first & 0x7f)
+	if count == 0 || count > 4 {
+		return 0, fmt.Errorf("unsupported BER length encoding")
+	}
+	length := 0
+	for i := 0; i < count; i++ {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length = length<<8 | int(b)
+	}
+	return length, nil
+}
+
+// berDecode builds an element, parsing children of constructed types
+func berDecode(tag byte, content []byte) (berElement, error) {
+	element := berElement{tag: tag, content: content}
+	if tag&0x20 == 0 {
+		return element, nil
+	}
+
+	reader := bufio.NewReader(strings.NewReader(string(content)))
+	for {
+		child, err := berRead(reader)
+		if err == io.EOF {
+			return element, nil
+		}
+		if err != nil {
+			return berElement{}, fmt.Errorf("malformed BER: %v", err)
+		}
+		element.children = append(element.children, child)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BoardTheme sets the display colors
+type BoardTheme struct {
+	Background       string `json:"background"`
+	Text             string `json:"text"`
+	Accent           string `json:"accent"`
+	HeaderBackground string `json:"header_background"`
+	FontFamily       string `json:"font_family"`
+}
+
+// BoardRotation controls which panels the board cycles through
+type BoardRotation struct {
+	Enabled         bool     `json:"enabled"`
+	IntervalSeconds int      `json:"interval_seconds"`
+	Panels          []string `json:"panels"` // "departures", "safety", "promos"
+}
+
+// BoardPromo is one promo banner shown in the rotation
+type BoardPromo struct {
+	Title    string `json:"title"`
+	Text     string `json:"text"`
+	ImageURL string `json:"image_url"`
+}
+
+// BoardSettings holds the platform display configuration from json/board_settings.json
+type BoardSettings struct {
+	Title          string        `json:"title"`
+	Locale         string        `json:"locale"` // Language of board labels and titles (locales.json)
+	Layout         string        `json:"layout"` // "split" keeps departures beside the rotation, "full" rotates full screen
+	Clock24Hour    bool          `json:"clock_24_hour"`
+	MaxDepartures  int           `json:"max_departures"`
+	Theme          BoardTheme    `json:"theme"`
+	Rotation       BoardRotation `json:"rotation"`
+	SafetyMessages []string      `json:"safety_messages"`
+	PromoBanners   []BoardPromo  `json:"promo_banners"`
+
+	VisualAlerts VisualAlertSettings `json:"visual_alerts"` // On-screen alerts and strobe for emergencies
+}
+
+func getDefaultBoardSettings() BoardSettings {
+	return BoardSettings{
+		Title:         "TARR Departures",
+		Locale:        "en",
+		Layout:        "split",
+		MaxDepartures: 8,
+		Theme: BoardTheme{
+			Background:       "#0b0b0b",
+			Text:             "#ffd200",
+			Accent:           "#ff7a00",
+			HeaderBackground: "#1c1c1c",
+			FontFamily:       "'Helvetica Neue', Arial, sans-serif",
+		},
+		Rotation: BoardRotation{
+			Enabled:         true,
+			IntervalSeconds: 12,
+			Panels:          []string{"departures", "safety", "promos"},
+		},
+		SafetyMessages: []string{
+			"Please stand behind the yellow line until the train has stopped.",
+			"Keep hands and feet inside the train at all times.",
+		},
+		PromoBanners: []BoardPromo{},
+		VisualAlerts: getDefaultVisualAlertSettings(),
+	}
+}
+
+// loadBoardSettings reads board_settings.json, falling back to defaults
+func loadBoardSettings() BoardSettings {
+	settings := getDefaultBoardSettings()
+	data, err := os.ReadFile(filepath.Join(app.Config.JSONDir, "board_settings.json"))
+	if err != nil {
+		return settings
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		log.Printf("Error parsing board_settings.json, using defaults: %v", err)
+		return getDefaultBoardSettings()
+	}
+	if settings.MaxDepartures <= 0 {
+		settings.MaxDepartures = 8
+	}
+	if settings.Rotation.IntervalSeconds <= 0 {
+		settings.Rotation.IntervalSeconds = 12
+	}
+	return settings
+}
+
+// boardSettingsVersion fingerprints the settings so open boards reload when they change
+func boardSettingsVersion(settings BoardSettings) string {
+	data, _ := json.Marshal(settings)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// queueEventHub wakes display clients whenever the announcement queue changes
+type queueEventHub struct {
+	mutex       sync.Mutex
+	subscribers map[chan struct{}]bool
+}
+
+var queueEvents = &queueEventHub{subscribers: make(map[chan struct{}]bool)}
+
+func (h *queueEventHub) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	h.mutex.Lock()
+	h.subscribers[ch] = true
+	h.mutex.Unlock()
+	return ch
+}
+
+func (h *queueEventHub) unsubscribe(ch chan struct{}) {
+	h.mutex.Lock()
+	delete(h.subscribers, ch)
+	h.mutex.Unlock()
+}
+
+// publish signals every subscriber without blocking; a pending signal already
+// covers any later change
+func (h *queueEventHub) publish() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// boardState is the payload pushed to the board on every change
+func boardState(settings BoardSettings) gin.H {
+	var current *PublicAnnouncement
+	next := make([]PublicAnnouncement, 0)
+	if announcementManager != nil {
+		playing, upcoming := announcementManager.NowPlaying()
+		if playing != nil {
+			view := publicAnnouncementView(*playing, settings.Locale)
+			current = &view
+		}
+		for _, announcement := range upcoming {
+			if len(next) == 3 {
+				break
+			}
+			next = append(next, publicAnnouncementView(announcement, settings.Locale))
+		}
+	}
+
+	return gin.H{
+		"now_playing":      current,
+		"up_next":          next,
+		"departures":       upcomingDepartures(settings.MaxDepartures),
+		"visual_alert":     currentVisualAlert(),
+		"server_time":      time.Now().Format(time.RFC3339),
+		"settings_version": boardSettingsVersion(settings),
+	}
+}
+
+// boardLabels returns the board's fixed text in its locale
+func boardLabels(locale string) map[string]string {
+	return map[string]string{
+		"departures":    translate(locale, "board.departures", "Departures"),
+		"time":          translate(locale, "board.time", "Time"),
+		"train":         translate(locale, "board.train", "Train"),
+		"destination":   translate(locale, "board.destination", "Destination"),
+		"track":         translate(locale, "board.track", "Track"),
+		"safety":        translate(locale, "board.safety", "Safety"),
+		"no_departures": translate(locale, "board.no_departures", "No departures scheduled"),
+		"delayed":       translate(locale, "board.delayed", "Delayed"),
+		"cancelled":     translate(locale, "board.cancelled", "Cancelled"),
+	}
+}
+
+// boardHandler renders the full-screen platform display
+func boardHandler(c *gin.Context) {
+	settings := loadBoardSettings()
+	settingsJSON, _ := json.Marshal(settings)
+	c.HTML(http.StatusOK, "board.html", gin.H{
+		"settings":         settings,
+		"settings_json":    template.JS(settingsJSON),
+		"settings_version": boardSettingsVersion(settings),
+		"labels":           boardLabels(settings.Locale),
+	})
+}
+
+// boardEventsHandler streams board state as server-sent events. State is sent
+// when the queue changes and every 30 seconds so departures roll forward.
+func boardEventsHandler(c *gin.Context) {
+	events := queueEvents.subscribe()
+	defer queueEvents.unsubscribe(events)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	send := func() bool {
+		data, err := json.Marshal(boardState(loadBoardSettings()))
+		if err != nil {
+			return false
+		}
+		if _, err := fmt.Fprintf(c.Writer, "event: state\ndata: %s\n\n", data); err != nil {
+			return false
+		}
+		c.Writer.Flush()
+		return true
+	}
+
+	if !send() {
+		return
+	}
+
+	refresh := time.NewTicker(30 * time.Second)
+	defer refresh.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-events:
+			if !send() {
+				return
+			}
+		case <-refresh.C:
+			if !send() {
+				return
+			}
+		}
+	}
+}
+
+// boardStateHandler returns the same state as JSON for displays that poll
+func boardStateHandler(c *gin.Context) {
+	settings := loadBoardSettings()
+	state := boardState(settings)
+	state["settings"] = settings
+	c.JSON(http.StatusOK, state)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Captions are the words of an announcement as text, composed when it is
+// queued so display boards can show exactly what is being spoken. Template
+// announcements get a caption per clip, looked up in this order:
+//  1. the locale's captions in locales.json, keyed by segment ("train/4")
+//  2. the catalog entry's caption (catalogs share captions across locales)
+//  3. built-in English for the fixed clips, numbers and delays
+//  4. the catalog entry's display name
+//
+// Clips with no caption are left out. Each language becomes one sentence.
+// Other types are captioned from their text, catalog entry or condition, and
+// a "caption" parameter overrides the lot, e.g. for an uploaded recording.
+
+// Built-in captions for the fixed clips of the default templates
+var builtinCaptions = map[string]string{
+	"service_change/attention":            "Attention please.",
+	"service_change/will_now_depart_from": "will now depart from",
+	"service_change/instead_of":           "instead of",
+	"service_change/has_been_cancelled":   "has been cancelled",
+	"countdown/the":                       "The",
+	"countdown/train_to":                  "train to",
+	"countdown/departs_in":                "departs in",
+	"countdown/minutes":                   "minutes",
+	"countdown/minute":                    "minute",
+}
+
+// catalogCaption returns the caption, or failing that the display name, of
+// the catalog entry behind a clip. ok is false when no catalog has the clip.
+func catalogCaption(set, name string) (caption string, displayName string, ok bool) {
+	dictionary := currentPronunciations()
+	for _, def := range catalogDefinitions {
+		if def.AudioDir != set || !strings.HasPrefix(name, def.FilePrefix) {
+			continue
+		}
+		id := strings.TrimPrefix(name, def.FilePrefix)
+		items, _ := loadCatalog(def)
+		for _, item := range items {
+			if item.ID != id {
+				continue
+			}
+			display := item.Name
+			if entry, found := dictionary.lookup(def.Name, id); found && entry.Display != "" {
+				display = entry.Display
+			}
+			return item.Caption, display, true
+		}
+	}
+	return "", "", false
+}
+
+// segmentCaption is the text of one template clip, or "" for none
+func segmentCaption(settings *LocaleSettings, segment templateSegment) string {
+	if caption := settings.Locales[segment.Language].Captions[segment.Path]; caption != "" {
+		return caption
+	}
+	caption, display, inCatalog := catalogCaption(segment.Set, segment.Name)
+	if caption != "" {
+		return caption
+	}
+	if text, ok := builtinCaptions[segment.Path]; ok {
+		return text
+	}
+	switch {
+	case segment.Set == "number":
+		return segment.Name
+	case segment.Set == "delay":
+		return fmt.Sprintf("is delayed by %s minutes", segment.Name)
+	case segment.Set == "clock" && strings.HasPrefix(segment.Name, "hour_"):
+		return strings.TrimPrefix(segment.Name, "hour_")
+	case segment.Set == "clock" && strings.HasPrefix(segment.Name, "minute_"):
+		// Joined to the hour by captionSentence
+		return ":" + strings.TrimPrefix(segment.Name, "minute_")
+	}
+	if inCatalog {
+		return display
+	}
+	return ""
+}
+
+// captionSentence joins clip captions into a sentence: single spaces, minutes
+// joined to the hour, a capital first letter and a full stop
+func captionSentence(parts []string) string {
+	sentence := strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+	sentence = strings.ReplaceAll(sentence, " :", ":")
+	if sentence == "" {
+		return ""
+	}
+	runes := []rune(sentence)
+	runes[0] = unicode.ToUpper(runes[0])
+	sentence = string(runes)
+	if !strings.ContainsAny(sentence[len(sentence)-1:], ".!?") {
+		sentence += "."
+	}
+	return sentence
+}
+
+// announcementCaption composes the caption of an announcement about to be
+// queued; parameters are as given to buildAudioSequence
+func announcementCaption(announcementType AnnouncementType, parameters map[string]interface{}) string {
+	if caption, _ := parameters["caption"].(string); strings.TrimSpace(caption) != "" {
+		return strings.TrimSpace(caption)
+	}
+	settings := getLocaleSettings()
+	locale := settings.DefaultLocale
+	param := func(key string) string {
+		value, _ := parameters[key].(string)
+		return value
+	}
+
+	if announcementType == TypeCountdown {
+		parameters = withCountdownUnit(parameters)
+	}
+	if segments, handled, err := localizedSegments(announcementType, parameters); handled {
+		if err != nil {
+			return ""
+		}
+		var sentences, parts []string
+		for i, segment := range segments {
+			parts = append(parts, segmentCaption(settings, segment))
+			if i == len(segments)-1 || segments[i+1].Language != segment.Language {
+				if sentence := captionSentence(parts); sentence != "" {
+					sentences = append(sentences, sentence)
+				}
+				parts = nil
+			}
+		}
+		return strings.Join(sentences, " ")
+	}
+
+	switch announcementType {
+	case TypeSafety:
+		if caption, _, _ := catalogCaption("safety", "safety_"+param("language")); caption != "" {
+			return caption
+		}
+		return translate(locale, "title.safety", "Safety announcement")
+	case TypeEmergency:
+		caption, display, _ := catalogCaption("emergency", param("file"))
+		if caption != "" {
+			return caption
+		}
+		return display
+	case TypeLightning:
+		return lightningAlertText(locale, param("condition"))
+	case TypeWeather, TypeAdhoc:
+		return strings.TrimSpace(param("text"))
+	case TypeClock:
+		if param("mode") != "time" {
+			return ""
+		}
+		hour, err := clockHourParameter(parameters)
+		if err != nil {
+			return ""
+		}
+		spokenHour, suffix := twelveHour(hour)
+		return fmt.Sprintf("The time is now %d %s.", spokenHour, suffix)
+	}
+	return ""
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CatalogItem is the common shape of every catalog entry. Description and
+// Category are only used by emergencies.
+type CatalogItem struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Caption     string `json:"caption,omitempty"` // The words spoken in the clip, for captions
+	File        string `json:"file,omitempty"`    // Audio file in the catalog's directory, where the catalog allows its own (safety)
+	Description string `json:"description,omitempty"`
+	Category    string `json:"category,omitempty"`
+}
+
+// catalogDefinition describes how a catalog is stored and which audio file
+// backs each entry
+type catalogDefinition struct {
+	Name       string // URL segment, e.g. "trains"
+	JSONName   string // Name registered in jsonFiles
+	AudioDir   string // Sub-directory of the mp3 directory
+	FilePrefix string // Prefix added to the ID to form the file name
+	// CustomFiles lets entries name their own audio file instead of the one
+	// formed from the ID, for catalogs played from a single clip
+	CustomFiles bool
+}
+
+// wrapperKey is the top-level key of the catalog file ("" for a bare array)
+func (d catalogDefinition) wrapperKey() string {
+	return jsonFiles[d.JSONName].WrapperKey
+}
+
+// audioFile returns the audio file of an entry relative to the audio roots:
+// its own file where the catalog allows one, otherwise the file named after
+// its ID
+func (d catalogDefinition) audioFile(item CatalogItem) string {
+	if d.CustomFiles && item.File != "" {
+		return filepath.Join(d.AudioDir, item.File)
+	}
+	return filepath.Join(d.AudioDir, d.FilePrefix+item.ID+".mp3")
+}
+
+// audioPath returns the mp3 file announcing the given catalog entry
+func (d catalogDefinition) audioPath(id string) string {
+	return d.itemAudioPath(CatalogItem{ID: id})
+}
+
+// itemAudioPath returns the file played for an entry (see resolveAudioPath)
+func (d catalogDefinition) itemAudioPath(item CatalogItem) string {
+	path, err := resolveAudioPath(d.audioFile(item))
+	if err != nil {
+		return storedAudioPath(d.audioFile(item))
+	}
+	return path
+}
+
+// findCatalogItem returns the entry with an ID
+func findCatalogItem(def catalogDefinition, id string) (CatalogItem, bool) {
+	items, _ := loadCatalog(def)
+	for _, item := range items {
+		if item.ID == id {
+			return item, true
+		}
+	}
+	return CatalogItem{}, false
+}
+
+// Catalogs exposed through the API, matching buildAudioSequence's file layout
+var catalogDefinitions = []catalogDefinition{
+	{Name: "trains", JSONName: "trains", AudioDir: "train"},
+	{Name: "trains-available", JSONName: "trains_available", AudioDir: "train"},
+	{Name: "directions", JSONName: "directions", AudioDir: "direction"},
+	{Name: "destinations", JSONName: "destinations", AudioDir: "destination"},
+	{Name: "destinations-available", JSONName: "destinations_available", AudioDir: "destination"},
+	{Name: "tracks", JSONName: "tracks", AudioDir: "track"},
+	{Name: "promos", JSONName: "promo", AudioDir: "promo"},
+	{Name: "safety", JSONName: "safety", AudioDir: "safety", FilePrefix: "safety_", CustomFiles: true},
+	{Name: "emergencies", JSONName: "emergencies", AudioDir: "emergency"},
+	{Name: "service-change-reasons", JSONName: "service_change_reasons", AudioDir: "reason"},
+}
+
+// IDs end up in file paths, so keep them to a safe character set
+var catalogIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Serialises read-modify-write cycles on catalog files
+var catalogMutex sync.Mutex
+
+// findCatalogDefinition looks up a catalog by its URL name
+func findCatalogDefinition(name string) (catalogDefinition, bool) {
+	for _, def := range catalogDefinitions {
+		if def.Name == name {
+			return def, true
+		}
+	}
+	return catalogDefinition{}, false
+}
+
+// loadCatalog reads a catalog file in either wrapped or bare array format
+func loadCatalog(def catalogDefinition) ([]CatalogItem, error) {
+	filePath, _ := jsonFilePath(def.JSONName)
+	if !fileExists(filePath) {
+		return []CatalogItem{}, nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", filepath.Base(filePath), err)
+	}
+
+	items, err := decodeJSONList[CatalogItem](data, def.wrapperKey())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", filepath.Base(filePath), err)
+	}
+	if items == nil {
+		items = []CatalogItem{}
+	}
+	return items, nil
+}
+
+// saveCatalog writes a catalog back in the format the rest of the app reads,
+// keeping any other settings in the wrapper, and records who changed it in
+// the revision history
+func saveCatalog(def catalogDefinition, items []CatalogItem, author string) error {
+	if items == nil {
+		items = []CatalogItem{}
+	}
+	if def.wrapperKey() == "" {
+		return saveJSONBy(def.JSONName, items, author, "")
+	}
+	wrapper := make(map[string]interface{})
+	filePath, _ := jsonFilePath(def.JSONName)
+	if data, err := os.ReadFile(filePath); err == nil {
+		var existing map[string]json.RawMessage
+		if json.Unmarshal(data, &existing) == nil {
+			for key, value := range existing {
+				wrapper[key] = value
+			}
+		}
+	}
+	wrapper[def.wrapperKey()] = items
+	return saveJSONBy(def.JSONName, wrapper, author, "")
+}
+
+// validAudioFileName accepts a plain .mp3 or .wav file name
+func validAudioFileName(name string) bool {
+	extension := strings.ToLower(filepath.Ext(name))
+	return (extension == ".mp3" || extension == ".wav") && catalogIDPattern.MatchString(strings.TrimSuffix(name, filepath.Ext(name)))
+}
+
+// validateCatalogItem checks the item fields and that its audio file exists
+func validateCatalogItem(def catalogDefinition, item CatalogItem, allowMissingAudio bool) []FieldError {
+	var details []FieldError
+	fileOK := false
+	if item.File != "" && !def.CustomFiles {
+		details = append(details, FieldError{Field: "file", Message: "is not supported for " + def.Name})
+	} else if item.File != "" && !validAudioFileName(item.File) {
+		details = append(details, FieldError{Field: "file", Message: "must be an .mp3 or .wav file name of letters, digits, '_' or '-'"})
+	} else {
+		fileOK = true
+	}
+	if !catalogIDPattern.MatchString(item.ID) {
+		details = append(details, FieldError{Field: "id", Message: "must be 1-64 letters, digits, '_' or '-'"})
+	} else if fileOK && !allowMissingAudio && !fileExists(def.itemAudioPath(item)) {
+		relative := audioDisplayPath(def.itemAudioPath(item))
+		details = append(details, FieldError{
+			Field:   "id",
+			Message: fmt.Sprintf("no audio file %s (set allow_missing_audio=true to add it anyway)", filepath.ToSlash(relative)),
+		})
+	}
+	if strings.TrimSpace(item.Name) == "" {
+		details = append(details, FieldError{Field: "name", Message: "is required"})
+	}
+	return details
+}
+
+// catalogItemView adds audio availability, and any display name and spelling
+// from the pronunciation dictionary, to an item for API responses
+func catalogItemView(def catalogDefinition, item CatalogItem, dictionary PronunciationDictionary) gin.H {
+	view := gin.H{
+		"id":              item.ID,
+		"name":            item.Name,
+		"audio_file":      audioDisplayPath(def.itemAudioPath(item)),
+		"audio_available": fileExists(def.itemAudioPath(item)),
+	}
+	if item.Caption != "" {
+		view["caption"] = item.Caption
+	}
+	if item.Description != "" {
+		view["description"] = item.Description
+	}
+	if item.Category != "" {
+		view["category"] = item.Category
+	}
+	if entry, ok := dictionary.lookup(def.Name, item.ID); ok {
+		if entry.Display != "" {
+			view["display_name"] = entry.Display
+		}
+		if entry.Say != "" {
+			view["say"] = entry.Say
+		}
+	}
+	return view
+}
+
+// registerCatalogRoutes adds CRUD endpoints for every catalog to an API group
+func registerCatalogRoutes(api *gin.RouterGroup) {
+	api.GET("/catalogs", apiListCatalogsHandler)
+	for _, def := range catalogDefinitions {
+		group := api.Group("/" + def.Name)
+		handler := catalogHandlers{def: def}
+		group.GET("", handler.list)
+		group.GET("/:id", handler.get)
+		group.POST("", handler.create)
+		group.PUT("/:id", handler.update)
+		group.DELETE("/:id", handler.remove)
+	}
+}
+
+func apiListCatalogsHandler(c *gin.Context) {
+	catalogs := make([]gin.H, 0, len(catalogDefinitions))
+	for _, def := range catalogDefinitions {
+		items, err := loadCatalog(def)
+		entry := gin.H{
+			"name":      def.Name,
+			"audio_dir": def.AudioDir,
+			"count":     len(items),
+		}
+		if err != nil {
+			entry["error"] = err.Error()
+		}
+		catalogs = append(catalogs, entry)
+	}
+	respondOK(c, gin.H{"catalogs": catalogs})
+}
+
+// catalogHandlers binds the CRUD handlers to one catalog
+type catalogHandlers struct {
+	def catalogDefinition
+}
+
+func (h catalogHandlers) list(c *gin.Context) {
+	items, err := loadCatalog(h.def)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	dictionary := currentPronunciations()
+	views := make([]gin.H, 0, len(items))
+	for _, item := range items {
+		views = append(views, catalogItemView(h.def, item, dictionary))
+	}
+	respondOK(c, gin.H{
+		"catalog": h.def.Name,
+		"items":   views,
+		"count":   len(views),
+	})
+}
+
+func (h catalogHandlers) get(c *gin.Context) {
+	items, err := loadCatalog(h.def)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	id := c.Param("id")
+	for _, item := range items {
+		if item.ID == id {
+			respondOK(c, gin.H{"item": catalogItemView(h.def, item, currentPronunciations())})
+			return
+		}
+	}
+	respondError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("%s entry '%s' not found", h.def.Name, id))
+}
+
+func (h catalogHandlers) create(c *gin.Context) {
+	var item CatalogItem
+	if err := c.ShouldBindJSON(&item); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+
+	if details := validateCatalogItem(h.def, item, c.Query("allow_missing_audio") == "true"); len(details) > 0 {
+		respondValidationError(c, "Invalid "+h.def.Name+" entry", details...)
+		return
+	}
+
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+
+	items, err := loadCatalog(h.def)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	for _, existing := range items {
+		if existing.ID == item.ID {
+			respondError(c, http.StatusConflict, ErrCodeConflict, fmt.Sprintf("%s entry '%s' already exists", h.def.Name, item.ID))
+			return
+		}
+	}
+
+	items = append(items, item)
+	if err := saveCatalog(h.def, items, requestOperator(c)); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save catalog: "+err.Error())
+		return
+	}
+
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+item.ID)
+	respondSuccess(c, http.StatusCreated, "Entry created", gin.H{"item": catalogItemView(h.def, item, currentPronunciations())})
+}
+
+func (h catalogHandlers) update(c *gin.Context) {
+	id := c.Param("id")
+
+	var item CatalogItem
+	if err := c.ShouldBindJSON(&item); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	if item.ID == "" {
+		item.ID = id
+	}
+	if item.ID != id {
+		respondValidationError(c, "ID cannot be changed", FieldError{Field: "id", Message: "must match the ID in the URL"})
+		return
+	}
+	// An entry keeps its own audio file unless the request names another
+	if h.def.CustomFiles && item.File == "" {
+		if existing, ok := findCatalogItem(h.def, id); ok {
+			item.File = existing.File
+		}
+	}
+
+	if details := validateCatalogItem(h.def, item, c.Query("allow_missing_audio") == "true"); len(details) > 0 {
+		respondValidationError(c, "Invalid "+h.def.Name+" entry", details...)
+		return
+	}
+
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+
+	items, err := loadCatalog(h.def)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	found := false
+	for i := range items {
+		if items[i].ID == id {
+			items[i] = item
+			found = true
+			break
+		}
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("%s entry '%s' not found", h.def.Name, id))
+		return
+	}
+
+	if err := saveCatalog(h.def, items, requestOperator(c)); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save catalog: "+err.Error())
+		return
+	}
+	respondSuccess(c, http.StatusOK, "Entry updated", gin.H{"item": catalogItemView(h.def, item, currentPronunciations())})
+}
+
+func (h catalogHandlers) remove(c *gin.Context) {
+	id := c.Param("id")
+
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+
+	items, err := loadCatalog(h.def)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	remaining := make([]CatalogItem, 0, len(items))
+	for _, item := range items {
+		if item.ID != id {
+			remaining = append(remaining, item)
+		}
+	}
+	if len(remaining) == len(items) {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("%s entry '%s' not found", h.def.Name, id))
+		return
+	}
+
+	if err := saveCatalog(h.def, remaining, requestOperator(c)); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save catalog: "+err.Error())
+		return
+	}
+	respondSuccess(c, http.StatusOK, "Entry deleted", gin.H{"id": id})
+}
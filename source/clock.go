@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clock announcements mark the hour, either with a chime (optionally struck
+// once per hour, like a clock tower) or by speaking the time: "The time is
+// now 2 PM". Spoken times are assembled from clips in mp3/clock/ or
+// synthesized by the TTS engine. Each entry in clock_announcements in
+// cron.json covers a range of hours on chosen days.
+
+// Default clip locations, relative to the mp3 directory
+const (
+	defaultClockChime = "clock/chime.mp3"
+	clockClipDir      = "clock"
+)
+
+var clockDays = map[string]string{
+	"sun": "SUN", "mon": "MON", "tue": "TUE", "wed": "WED", "thu": "THU", "fri": "FRI", "sat": "SAT",
+}
+
+// parseClockHours expands an hour range such as "7-22", "22-2" (wrapping past
+// midnight) or "8,12,17" into a sorted list of hours. Empty means every hour.
+func parseClockHours(spec string) ([]int, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		spec = "0-23"
+	}
+	var selected [24]bool
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		start, end := part, part
+		if dash := strings.Index(part, "-"); dash >= 0 {
+			start, end = part[:dash], part[dash+1:]
+		}
+		first, err := strconv.Atoi(strings.TrimSpace(start))
+		if err != nil || first < 0 || first > 23 {
+			return nil, fmt.Errorf("invalid hour '%s'", start)
+		}
+		last, err := strconv.Atoi(strings.TrimSpace(end))
+		if err != nil || last < 0 || last > 23 {
+			return nil, fmt.Errorf("invalid hour '%s'", end)
+		}
+		for hour := first; ; hour = (hour + 1) % 24 {
+			selected[hour] = true
+			if hour == last {
+				break
+			}
+		}
+	}
+	var hours []int
+	for hour, ok := range selected {
+		if ok {
+			hours = append(hours, hour)
+		}
+	}
+	return hours, nil
+}
+
+// clockCronSpec builds the cron expression for a clock entry: minute 0 of
+// each selected hour, on the selected days
+func clockCronSpec(item ClockCronJob) (string, error) {
+	hours, err := parseClockHours(item.Hours)
+	if err != nil {
+		return "", err
+	}
+	hourList := make([]string, len(hours))
+	for i, hour := range hours {
+		hourList[i] = strconv.Itoa(hour)
+	}
+
+	days := "*"
+	if len(item.Days) > 0 {
+		names := make([]string, 0, len(item.Days))
+		for _, day := range item.Days {
+			key := strings.ToLower(strings.TrimSpace(day))
+			if len(key) > 3 {
+				key = key[:3] // "monday" as well as "mon"
+			}
+			name, ok := clockDays[key]
+			if !ok {
+				return "", fmt.Errorf("invalid day '%s'", day)
+			}
+			names = append(names, name)
+		}
+		days = strings.Join(names, ",")
+	}
+	return fmt.Sprintf("0 %s * * %s", strings.Join(hourList, ","), days), nil
+}
+
+// validate checks an entry's mode, chime, source, hours and days
+func (item ClockCronJob) validate() error {
+	if item.Mode != "" && item.Mode != "chime" && item.Mode != "time" {
+		return fmt.Errorf("mode must be chime or time")
+	}
+	if item.Chime != "" && !filepath.IsLocal(item.Chime) {
+		return fmt.Errorf("chime must be relative to the mp3 directory")
+	}
+	if item.Source != "" && item.Source != "clips" && item.Source != "tts" {
+		return fmt.Errorf("source must be clips or tts")
+	}
+	_, err := clockCronSpec(item)
+	return err
+}
+
+// scheduleClockAnnouncements adds the clock_announcements entries
+func scheduleClockAnnouncements(jobs []ClockCronJob, timezone string) {
+	location := scheduleLocation(timezone)
+	for i, item := range jobs {
+		if !item.Enabled {
+			continue
+		}
+		if err := item.validate(); err != nil {
+			log.Printf("Error scheduling clock announcement %d: %v", i, err)
+			continue
+		}
+		spec, _ := clockCronSpec(item)
+		spec = zonedCronSpec(timezone, spec)
+		mode := item.Mode
+		if mode == "" {
+			mode = "chime"
+		}
+		priority := PriorityLow
+		if item.Priority != "" {
+			priority = ParsePriority(item.Priority)
+		}
+		baseParameters := map[string]interface{}{"mode": mode}
+		if item.Source != "" {
+			baseParameters["source"] = item.Source
+		}
+		if item.Strike {
+			baseParameters["strike"] = true
+		}
+		if item.Chime != "" {
+			baseParameters["chime"] = item.Chime
+		}
+		if item.Zone != "" {
+			baseParameters["zone"] = item.Zone
+		}
+		profiles, index := item.Profiles, i
+
+		err := addScheduledJob(spec, "clock", scheduleEntryKey("clock", i), "Clock "+mode, item, func() error {
+			if !scheduledEntryRuns("clock", index, spec, profiles, "Scheduled clock "+mode) {
+				return errScheduleHeld
+			}
+			hour := time.Now().In(location).Hour()
+			log.Printf("🕐 Scheduled clock %s triggered for hour %d", mode, hour)
+			// The hour is fixed now so a delayed announcement still names the right one
+			parameters := map[string]interface{}{"hour": hour}
+			for key, value := range baseParameters {
+				parameters[key] = value
+			}
+			return queueScheduled(TypeClock, priority, parameters, "clock announcement")
+		})
+		if err != nil {
+			log.Printf("Error scheduling clock announcement %d: %v", i, err)
+		} else {
+			log.Printf("Scheduled: %s - clock %s", spec, mode)
+		}
+	}
+}
+
+// clockHourParameter reads the hour of a clock announcement, which may be an
+// int (scheduler), a float64 (JSON) or a string (preview query). Defaults to
+// the current hour.
+func clockHourParameter(parameters map[string]interface{}) (int, error) {
+	var hour int
+	switch value := parameters["hour"].(type) {
+	case nil:
+		return time.Now().Hour(), nil
+	case int:
+		hour = value
+	case float64:
+		hour = int(value)
+	case string:
+		if strings.TrimSpace(value) == "" {
+			return time.Now().Hour(), nil
+		}
+		parsed, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return 0, fmt.Errorf("invalid hour '%s'", value)
+		}
+		hour = parsed
+	default:
+		return 0, fmt.Errorf("invalid hour '%v'", value)
+	}
+	if hour < 0 || hour > 23 {
+		return 0, fmt.Errorf("hour must be between 0 and 23")
+	}
+	return hour, nil
+}
+
+// twelveHour converts 0-23 to 1-12 and AM or PM
+func twelveHour(hour int) (int, string) {
+	suffix := "AM"
+	if hour >= 12 {
+		suffix = "PM"
+	}
+	hour %= 12
+	if hour == 0 {
+		hour = 12
+	}
+	return hour, suffix
+}
+
+// clockAudioSequence builds the files for a clock announcement
+func clockAudioSequence(parameters map[string]interface{}) ([]string, error) {
+	hour, err := clockHourParameter(parameters)
+	if err != nil {
+		return nil, err
+	}
+	spokenHour, suffix := twelveHour(hour)
+	mode, _ := parameters["mode"].(string)
+
+	switch mode {
+	case "", "chime":
+		chime, _ := parameters["chime"].(string)
+		if chime == "" {
+			chime = defaultClockChime
+		}
+		chimePath, err := resolveAudioPath(chime)
+		if err != nil {
+			return nil, err
+		}
+		strikes := 1
+		if strike, _ := parameters["strike"].(bool); strike || parameters["strike"] == "true" {
+			strikes = spokenHour
+		}
+		files := make([]string, strikes)
+		for i := range files {
+			files[i] = chimePath
+		}
+		return files, nil
+
+	case "time":
+		source, _ := parameters["source"].(string)
+		switch source {
+		case "", "clips":
+			var files []string
+			for _, clip := range []string{"time_is_now", fmt.Sprintf("hour_%d", spokenHour), strings.ToLower(suffix)} {
+				file, err := resolveAudioPath(filepath.Join(clockClipDir, clip+".mp3"))
+				if err != nil {
+					return nil, err
+				}
+				files = append(files, file)
+			}
+			return files, nil
+		case "tts":
+			speech, err := synthesizeSpeech(fmt.Sprintf("The time is now %d %s.", spokenHour, suffix))
+			if err != nil {
+				return nil, fmt.Errorf("failed to synthesize time announcement: %v", err)
+			}
+			return []string{speech}, nil
+		default:
+			return nil, fmt.Errorf("unsupported clock source: %s", source)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported clock mode: %s", mode)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Raspberry Pi has no battery-backed clock. It boots with the time it last
+// saved, or 1970, until NTP sets it, and cron jobs computed from a wrong
+// clock fire at the wrong times. The clock check holds the scheduler until
+// the time is plausible: past minPlausibleTime and, when a reference can be
+// reached, within max_skew_seconds of it. The reference is NTP when
+// timedatectl reports the clock synchronized, otherwise the Date header of
+// the first of the sources to answer. With no reference reachable a clock
+// past minPlausibleTime is trusted. The check runs again every
+// interval_minutes, and every 30 seconds while the clock is wrong; a clock
+// found wrong later holds the scheduler again.
+//
+// The wall clock is also compared with the monotonic clock between ticks.
+// When it steps, e.g. when NTP corrects it after boot, the scheduler is
+// restarted so every job's next run is worked out again from the new time.
+
+// ClockCheckSettings is the clock section of admin_config.json
+type ClockCheckSettings struct {
+	Enabled         bool     `json:"enabled"`
+	Sources         []string `json:"sources"`          // URLs whose Date header is compared; plain http, since TLS fails with a wrong clock
+	IntervalMinutes int      `json:"interval_minutes"` // Between checks while the clock is plausible
+	MaxSkewSeconds  int      `json:"max_skew_seconds"` // Furthest the clock may be off the reference
+}
+
+func getDefaultClockCheckSettings() ClockCheckSettings {
+	return ClockCheckSettings{
+		Enabled:         true,
+		Sources:         []string{"http://www.google.com", "http://www.cloudflare.com"},
+		IntervalMinutes: 60,
+		MaxSkewSeconds:  60,
+	}
+}
+
+// Clock states
+const (
+	ClockOK         = "ok"         // Checked against a reference
+	ClockUnverified = "unverified" // Plausible, but no reference answered
+	ClockWrong      = "wrong"
+	ClockUnchecked  = "unchecked"
+)
+
+// minPlausibleTime is earlier than any real clock running this release
+var minPlausibleTime = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+	clockTick          = 5 * time.Second
+	clockRetryInterval = 30 * time.Second
+	clockStepThreshold = 30 * time.Second // A smaller step is left to the next run of each job
+	clockSourceTimeout = 5 * time.Second
+)
+
+// ClockStatus is the outcome of the last clock check
+type ClockStatus struct {
+	State           string     `json:"state"`
+	Message         string     `json:"message"`
+	Source          string     `json:"source,omitempty"`       // ntp or the URL compared with
+	SkewSeconds     *float64   `json:"skew_seconds,omitempty"` // Reference minus the system clock
+	NTPSynchronized *bool      `json:"ntp_synchronized,omitempty"`
+	CheckedAt       *time.Time `json:"checked_at,omitempty"`
+	SchedulerHeld   bool       `json:"scheduler_held"`
+	HeldSince       *time.Time `json:"held_since,omitempty"`
+	LastStep        *time.Time `json:"last_step,omitempty"` // When the clock last stepped
+	LastStepSeconds float64    `json:"last_step_seconds,omitempty"`
+}
+
+var clockState = struct {
+	mutex            sync.Mutex
+	status           ClockStatus
+	schedulerRunning bool
+}{status: ClockStatus{State: ClockUnchecked, Message: "Not checked yet", SchedulerHeld: true}}
+
+// loadClockCheckSettings reads the clock section of admin_config.json
+func loadClockCheckSettings() ClockCheckSettings {
+	adminConfig, err := loadAdminConfig(adminConfigPath())
+	if err != nil || adminConfig.Clock == nil {
+		return getDefaultClockCheckSettings()
+	}
+	settings := *adminConfig.Clock
+	defaults := getDefaultClockCheckSettings()
+	if settings.IntervalMinutes <= 0 {
+		settings.IntervalMinutes = defaults.IntervalMinutes
+	}
+	if settings.MaxSkewSeconds <= 0 {
+		settings.MaxSkewSeconds = defaults.MaxSkewSeconds
+	}
+	return settings
+}
+
+// ntpSynchronized asks timedatectl whether NTP has set the clock; nil when
+// it can't tell
+func ntpSynchronized() *bool {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	output, err := exec.Command("timedatectl", "show", "-p", "NTPSynchronized", "--value").Output()
+	if err != nil {
+		return nil
+	}
+	synchronized := strings.TrimSpace(string(output)) == "yes"
+	return &synchronized
+}
+
+// referenceSkew returns how far a source's Date header is ahead of the
+// system clock, allowing for half the round trip
+func referenceSkew(source string) (float64, error) {
+	client := &http.Client{
+		Timeout: clockSourceTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	started := time.Now()
+	resp, err := client.Head(source)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	received := time.Now()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("no usable Date header")
+	}
+	// The header has whole seconds; take the middle of the second
+	reference := date.Add(500 * time.Millisecond)
+	local := started.Add(received.Sub(started) / 2)
+	return reference.Sub(local).Seconds(), nil
+}
+
+// checkClock decides whether the system clock is plausible
+func checkClock(settings ClockCheckSettings) ClockStatus {
+	now := time.Now()
+	status := ClockStatus{CheckedAt: &now, NTPSynchronized: ntpSynchronized()}
+	if now.Before(minPlausibleTime) {
+		status.State = ClockWrong
+		status.Message = fmt.Sprintf("The clock reads %s, before %s; it hasn't been set since boot", now.Format("2006-01-02 15:04"), minPlausibleTime.Format("2006-01-02"))
+		return status
+	}
+	if status.NTPSynchronized != nil && *status.NTPSynchronized {
+		status.State, status.Source, status.Message = ClockOK, "ntp", "Synchronized by NTP"
+		return status
+	}
+	for _, source := range settings.Sources {
+		skew, err := referenceSkew(source)
+		if err != nil {
+			continue
+		}
+		status.Source, status.SkewSeconds = source, &skew
+		if math.Abs(skew) > float64(settings.MaxSkewSeconds) {
+			status.State = ClockWrong
+			status.Message = fmt.Sprintf("The clock is %s off %s", time.Duration(math.Abs(skew)*float64(time.Second)).Round(time.Second), source)
+			return status
+		}
+		status.State = ClockOK
+		status.Message = fmt.Sprintf("Within %.1f seconds of %s", math.Abs(skew), source)
+		return status
+	}
+	status.State = ClockUnverified
+	status.Message = "No reference answered; the clock is trusted"
+	return status
+}
+
+// applyClockStatus records a check and starts or holds the scheduler
+func applyClockStatus(status ClockStatus) {
+	clockState.mutex.Lock()
+	previous := clockState.status
+	status.LastStep, status.LastStepSeconds = previous.LastStep, previous.LastStepSeconds
+	plausible := status.State != ClockWrong
+	start := plausible && !clockState.schedulerRunning
+	hold := !plausible && clockState.schedulerRunning
+	if plausible {
+		status.SchedulerHeld = false
+	} else {
+		status.SchedulerHeld = true
+		status.HeldSince = previous.HeldSince
+		if status.HeldSince == nil {
+			status.HeldSince = status.CheckedAt
+		}
+	}
+	clockState.schedulerRunning = plausible
+	clockState.status = status
+	clockState.mutex.Unlock()
+
+	if start {
+		log.Printf("🕐 Clock %s (%s), starting the scheduler", status.State, status.Message)
+		app.Scheduler.Start()
+	}
+	if hold {
+		app.Scheduler.Stop()
+	}
+	if !plausible && previous.State != ClockWrong {
+		log.Printf("⚠️  %s; scheduled announcements are held until it is corrected", status.Message)
+		notify(NotifyClockWrong, "", "critical", "Clock is wrong",
+			status.Message+". Scheduled announcements are held until the clock is corrected.")
+	}
+}
+
+// noteClockStep restarts the scheduler after the wall clock steps, so next
+// runs are worked out from the new time
+func noteClockStep(step time.Duration) {
+	now := time.Now()
+	clockState.mutex.Lock()
+	clockState.status.LastStep, clockState.status.LastStepSeconds = &now, step.Seconds()
+	running := clockState.schedulerRunning
+	clockState.mutex.Unlock()
+
+	log.Printf("🕐 The clock stepped %s", step.Round(time.Second))
+	if running {
+		app.Scheduler.Stop()
+		app.Scheduler.Start()
+		log.Printf("Scheduler restarted for the new time")
+	}
+}
+
+// startClockCheck starts the scheduler once the clock is plausible and keeps
+// checking it. With the check off the scheduler starts straight away.
+func startClockCheck() {
+	go func() {
+		last := time.Now()
+		var nextCheck time.Time
+		for {
+			now := time.Now()
+			// Round(0) drops the monotonic reading, leaving wall clock time
+			step := now.Round(0).Sub(last.Round(0)) - now.Sub(last)
+			last = now
+			if step > clockStepThreshold || step < -clockStepThreshold {
+				noteClockStep(step)
+				nextCheck = time.Time{}
+			}
+
+			settings := loadClockCheckSettings()
+			if !settings.Enabled {
+				if !schedulerRunning() {
+					applyClockStatus(ClockStatus{State: ClockUnchecked, Message: "The clock check is off"})
+				}
+				nextCheck = time.Time{}
+			} else if nextCheck.IsZero() || !now.Before(nextCheck) {
+				status := checkClock(settings)
+				applyClockStatus(status)
+				if status.State == ClockWrong {
+					nextCheck = time.Now().Add(clockRetryInterval)
+				} else {
+					nextCheck = time.Now().Add(time.Duration(settings.IntervalMinutes) * time.Minute)
+				}
+			}
+			time.Sleep(clockTick)
+		}
+	}()
+}
+
+// schedulerRunning reports whether the clock check has let the scheduler run
+func schedulerRunning() bool {
+	clockState.mutex.Lock()
+	defer clockState.mutex.Unlock()
+	return clockState.schedulerRunning
+}
+
+// currentClockStatus returns the last clock check
+func currentClockStatus() ClockStatus {
+	clockState.mutex.Lock()
+	defer clockState.mutex.Unlock()
+	return clockState.status
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Config says which annunciator to talk to and how to authenticate
+type Config struct {
+	URL    string `json:"url"`
+	APIKey string `json:"api_key"`
+}
+
+// defaultConfigPath is $TARRCTL_CONFIG or ~/.config/tarrctl/config.json
+func defaultConfigPath() string {
+	if path := os.Getenv("TARRCTL_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "tarrctl", "config.json")
+}
+
+// loadConfig reads the config file, then lets TARR_URL and TARR_API_KEY
+// override it. A missing file is not an error.
+func loadConfig(path string) (Config, error) {
+	config := Config{URL: "http://localhost:8080"}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return config, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &config); err != nil {
+				return config, fmt.Errorf("failed to parse %s: %v", path, err)
+			}
+		}
+	}
+
+	if url := os.Getenv("TARR_URL"); url != "" {
+		config.URL = url
+	}
+	if key := os.Getenv("TARR_API_KEY"); key != "" {
+		config.APIKey = key
+	}
+	return config, nil
+}
+
+// apiResponse is the envelope every /api endpoint returns
+type apiResponse struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data"`
+	Message string          `json:"message"`
+	Error   string          `json:"error"`
+	Code    string          `json:"code"`
+	Details []struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	} `json:"details"`
+	RequestID string `json:"request_id"`
+}
+
+// Client calls the annunciator REST API
+type Client struct {
+	config         Config
+	http           *http.Client
+	idempotencyKey string
+}
+
+func newClient(config Config, timeout time.Duration) *Client {
+	return &Client{config: config, http: &http.Client{Timeout: timeout}}
+}
+
+// do sends a request and returns the envelope, turning API failures into errors
+func (c *Client) do(method, path string, body interface{}) (*apiResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(c.config.URL, "/")+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.config.APIKey != "" {
+		req.Header.Set("X-API-Key", c.config.APIKey)
+	}
+	// Lets a script rerun a command without queuing the announcement twice
+	if c.idempotencyKey != "" && method == http.MethodPost {
+		req.Header.Set("Idempotency-Key", c.idempotencyKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach %s: %v", c.config.URL, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var envelope apiResponse
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("unexpected response (HTTP %d): %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if !envelope.Success {
+		message := envelope.Error
+		if message == "" {
+			message = envelope.Message
+		}
+		for _, detail := range envelope.Details {
+			message += fmt.Sprintf("\n  %s: %s", detail.Field, detail.Message)
+		}
+		return &envelope, fmt.Errorf("HTTP %d: %s", resp.StatusCode, message)
+	}
+	return &envelope, nil
+}
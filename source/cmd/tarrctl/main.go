@@ -0,0 +1,310 @@
+// Command tarrctl scripts a TARR Annunciator over its REST API.
+//
+// The server and API key come from ~/.config/tarrctl/config.json
+// ({"url": "http://annunciator:8080", "api_key": "..."}), the TARR_URL and
+// TARR_API_KEY environment variables, or the -url and -api-key flags, in
+// increasing order of precedence.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const usage = `Usage: tarrctl [flags] <command> [arguments]
+
+Commands:
+  announce station -train N -direction D -destination D -track N [-languages en,es]
+  announce safety -language english
+  announce promo -file ID
+  announce emergency -file ID
+  announce service-change -train N -from TRACK -to TRACK [-reason ID]
+  queue [status|history]      Show the announcement queue or recent history
+  pause | resume | stop       Pause or resume the queue, or stop the current announcement
+  volume [0-100]              Show or set the volume
+  schedule export [FILE]      Write the schedule (cron.json) to FILE or stdout
+  schedule import FILE        Replace the schedule with FILE ("-" for stdin)
+  health                      Check the server is up; exits non-zero if not
+
+Announce commands also take -priority and -delay (seconds).
+
+Flags:
+`
+
+func main() {
+	global := flag.NewFlagSet("tarrctl", flag.ExitOnError)
+	configPath := global.String("config", defaultConfigPath(), "config file")
+	serverURL := global.String("url", "", "annunciator URL (overrides config and TARR_URL)")
+	apiKey := global.String("api-key", "", "API key (overrides config and TARR_API_KEY)")
+	timeout := global.Duration("timeout", 15*time.Second, "request timeout")
+	idempotencyKey := global.String("idempotency-key", "", "send this Idempotency-Key so a retried command is not queued twice")
+	global.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		global.PrintDefaults()
+	}
+	global.Parse(os.Args[1:])
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		fail(err)
+	}
+	if *serverURL != "" {
+		config.URL = *serverURL
+	}
+	if *apiKey != "" {
+		config.APIKey = *apiKey
+	}
+
+	client := newClient(config, *timeout)
+	client.idempotencyKey = *idempotencyKey
+
+	args := global.Args()
+	if len(args) == 0 {
+		global.Usage()
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "announce":
+		err = runAnnounce(client, args[1:])
+	case "queue":
+		err = runQueue(client, args[1:])
+	case "pause":
+		err = printMessage(client.do(http.MethodPost, "/api/v1/announcements/pause", nil))
+	case "resume":
+		err = printMessage(client.do(http.MethodPost, "/api/v1/announcements/resume", nil))
+	case "stop":
+		err = printMessage(client.do(http.MethodPost, "/api/v1/announcements/stop-current", nil))
+	case "volume":
+		err = runVolume(client, args[1:])
+	case "schedule":
+		err = runSchedule(client, args[1:])
+	case "health":
+		err = runHealth(client)
+	case "help", "-h", "--help":
+		global.Usage()
+	default:
+		fmt.Fprintf(os.Stderr, "tarrctl: unknown command %q\n\n", args[0])
+		global.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "tarrctl:", err)
+	os.Exit(1)
+}
+
+// printMessage prints the server's message for commands with nothing else to show
+func printMessage(response *apiResponse, err error) error {
+	if err != nil {
+		return err
+	}
+	fmt.Println(response.Message)
+	return nil
+}
+
+// printData pretty-prints the data of a response
+func printData(response *apiResponse, err error) error {
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, response.Data, "", "  "); err != nil {
+		return err
+	}
+	fmt.Println(out.String())
+	return nil
+}
+
+func runAnnounce(client *Client, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("announce needs a type: station, safety, promo, emergency or service-change")
+	}
+
+	kind := args[0]
+	flags := flag.NewFlagSet("announce "+kind, flag.ExitOnError)
+	priority := flags.String("priority", "", "low, normal, high, critical or emergency")
+	delay := flags.Int("delay", 0, "seconds to wait before playing")
+	body := map[string]interface{}{}
+
+	// Each type's flags map straight onto its request fields
+	fields := map[string]*string{}
+	field := func(name, requestField, help string) {
+		fields[requestField] = flags.String(name, "", help)
+	}
+	var languages *string
+	switch kind {
+	case "station":
+		field("train", "train_number", "train number")
+		field("direction", "direction", "direction ID")
+		field("destination", "destination", "destination ID")
+		field("track", "track_number", "track number")
+		languages = flags.String("languages", "", "comma separated locales to play back to back")
+	case "safety":
+		field("language", "language", "safety announcement language")
+	case "promo", "emergency":
+		field("file", "file", "announcement ID")
+	case "service-change":
+		field("train", "train_number", "train number")
+		field("from", "old_track", "track the train was due on")
+		field("to", "new_track", "track the train now departs from")
+		field("reason", "reason", "reason ID (optional)")
+		languages = flags.String("languages", "", "comma separated locales to play back to back")
+	default:
+		return fmt.Errorf("unknown announcement type %q", kind)
+	}
+	flags.Parse(args[1:])
+
+	for requestField, value := range fields {
+		if *value != "" {
+			body[requestField] = *value
+		}
+	}
+	if languages != nil && *languages != "" {
+		body["languages"] = strings.Split(*languages, ",")
+	}
+	if *priority != "" {
+		body["priority"] = *priority
+	}
+	if *delay > 0 {
+		body["delay"] = *delay
+	}
+
+	response, err := client.do(http.MethodPost, "/api/v1/announce/"+kind, body)
+	if err != nil {
+		return err
+	}
+	var data struct {
+		Announcement struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+		} `json:"announcement"`
+	}
+	json.Unmarshal(response.Data, &data)
+	fmt.Printf("%s (ID: %s)\n", response.Message, data.Announcement.ID)
+	return nil
+}
+
+func runQueue(client *Client, args []string) error {
+	what := "status"
+	if len(args) > 0 {
+		what = args[0]
+	}
+	switch what {
+	case "status":
+		return printData(client.do(http.MethodGet, "/api/queue/status", nil))
+	case "history":
+		flags := flag.NewFlagSet("queue history", flag.ExitOnError)
+		limit := flags.Int("limit", 20, "number of announcements to show")
+		flags.Parse(args[1:])
+		return printData(client.do(http.MethodGet, "/api/queue/history?limit="+url.QueryEscape(strconv.Itoa(*limit)), nil))
+	default:
+		return fmt.Errorf("unknown queue command %q (status or history)", what)
+	}
+}
+
+func runVolume(client *Client, args []string) error {
+	if len(args) == 0 {
+		response, err := client.do(http.MethodGet, "/api/v1/audio/volume", nil)
+		if err != nil {
+			return err
+		}
+		var data struct {
+			Percent int `json:"volume_percent"`
+		}
+		json.Unmarshal(response.Data, &data)
+		fmt.Printf("%d%%\n", data.Percent)
+		return nil
+	}
+
+	volume, err := strconv.ParseFloat(strings.TrimSuffix(args[0], "%"), 64)
+	if err != nil || volume < 0 || volume > 100 {
+		return fmt.Errorf("volume must be 0-100")
+	}
+	return printMessage(client.do(http.MethodPost, "/api/v1/audio/volume", map[string]interface{}{"volume": volume / 100}))
+}
+
+func runSchedule(client *Client, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("schedule needs export or import")
+	}
+
+	switch args[0] {
+	case "export":
+		response, err := client.do(http.MethodGet, "/api/v1/schedule", nil)
+		if err != nil {
+			return err
+		}
+		var data struct {
+			Schedule json.RawMessage `json:"schedule"`
+		}
+		if err := json.Unmarshal(response.Data, &data); err != nil {
+			return err
+		}
+		var out bytes.Buffer
+		json.Indent(&out, data.Schedule, "", "    ")
+		out.WriteString("\n")
+		if len(args) > 1 && args[1] != "-" {
+			return os.WriteFile(args[1], out.Bytes(), 0644)
+		}
+		_, err = os.Stdout.Write(out.Bytes())
+		return err
+
+	case "import":
+		if len(args) < 2 {
+			return fmt.Errorf("schedule import needs a file (or - for stdin)")
+		}
+		var data []byte
+		var err error
+		if args[1] == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(args[1])
+		}
+		if err != nil {
+			return err
+		}
+		var schedule map[string]interface{}
+		if err := json.Unmarshal(data, &schedule); err != nil {
+			return fmt.Errorf("%s is not valid JSON: %v", args[1], err)
+		}
+		return printMessage(client.do(http.MethodPost, "/api/v1/schedule", map[string]interface{}{"schedule": schedule}))
+
+	default:
+		return fmt.Errorf("unknown schedule command %q (export or import)", args[0])
+	}
+}
+
+func runHealth(client *Client) error {
+	response, err := client.do(http.MethodGet, "/api/v1/status", nil)
+	if err != nil {
+		return err
+	}
+	var data struct {
+		Status         string `json:"status"`
+		AudioAvailable bool   `json:"audio_available"`
+		AudioBackend   string `json:"audio_backend"`
+		Volume         int    `json:"volume"`
+	}
+	json.Unmarshal(response.Data, &data)
+	fmt.Printf("%s: %s, audio %s (%s), volume %d%%\n", client.config.URL, data.Status,
+		map[bool]string{true: "available", false: "unavailable"}[data.AudioAvailable], data.AudioBackend, data.Volume)
+	if !data.AudioAvailable {
+		return fmt.Errorf("audio is not available")
+	}
+	return nil
+}
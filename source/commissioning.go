@@ -0,0 +1,518 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gopxl/beep"
+)
+
+// Commissioning a new install means hearing every clip the catalogs refer
+// to: each train number, direction, destination, track and so on. A
+// commissioning run steps through every catalog entry's audio file, plays it
+// with a short gap before the next, and records how each went. Files that
+// are missing, don't decode, decode to nothing or silence, or don't play
+// make up the punch list, which can be downloaded as CSV. With play off the
+// files are only decoded, which is quicker and quiet.
+//
+// Each clip takes the audio device only while it plays, so announcements
+// still go out between clips. The last run is kept in logs/commissioning.json.
+
+// Commissioning run states
+const (
+	CommissioningRunning   = "running"
+	CommissioningCompleted = "completed"
+	CommissioningCancelled = "cancelled"
+)
+
+// Outcomes of checking one clip
+const (
+	ClipOK             = "ok"
+	ClipMissing        = "missing"
+	ClipUnreadable     = "unreadable" // Won't open or decode
+	ClipCorrupt        = "corrupt"    // Stopped decoding partway
+	ClipEmpty          = "empty"      // Decodes to no samples
+	ClipSilent         = "silent"     // Decodes to nothing but silence
+	ClipPlaybackFailed = "playback_failed"
+)
+
+const (
+	defaultCommissioningGap = 750 * time.Millisecond
+	maxCommissioningGap     = 10 * time.Second
+	// Longest a clip may play before the output counts as stalled
+	maxCommissioningClip     = 2 * time.Minute
+	commissioningStallMargin = 5 * time.Second
+)
+
+// CommissioningRequest starts a run
+type CommissioningRequest struct {
+	Catalogs []string `json:"catalogs"` // Catalog names; empty for all of them
+	Play     *bool    `json:"play"`     // Play each clip (default) or only decode it
+	GapMs    *int     `json:"gap_ms"`   // Pause between clips; default 750
+}
+
+// CommissioningResult is the outcome for one catalog entry
+type CommissioningResult struct {
+	Catalog         string  `json:"catalog"`
+	ID              string  `json:"id"`
+	Name            string  `json:"name"`
+	File            string  `json:"file"`
+	Status          string  `json:"status"`
+	Error           string  `json:"error,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+}
+
+// CommissioningRun is one pass over the catalogs
+type CommissioningRun struct {
+	State       string                `json:"state"`
+	StartedBy   string                `json:"started_by"`
+	StartedAt   time.Time             `json:"started_at"`
+	EndedAt     *time.Time            `json:"ended_at,omitempty"`
+	Play        bool                  `json:"play"`
+	GapMs       int                   `json:"gap_ms"`
+	Catalogs    []string              `json:"catalogs"`
+	Total       int                   `json:"total"`
+	Checked     int                   `json:"checked"`
+	Current     string                `json:"current,omitempty"` // File being checked
+	Counts      map[string]int        `json:"counts"`            // Results by status
+	Results     []CommissioningResult `json:"results"`
+	Skipped     []string              `json:"skipped,omitempty"` // Catalogs that couldn't be read
+	CancelledBy string                `json:"cancelled_by,omitempty"`
+
+	cancel chan struct{}
+}
+
+var (
+	commissioningRun   *CommissioningRun
+	commissioningMutex sync.Mutex
+)
+
+// commissioningEntry is a catalog entry waiting to be checked
+type commissioningEntry struct {
+	catalog string
+	item    CatalogItem
+	file    string
+}
+
+// commissioningPath is where the last run is kept
+func commissioningPath() string {
+	return filepath.Join(app.Config.LogDir, "commissioning.json")
+}
+
+// commissioningEntries lists the entries of the named catalogs, or all of
+// them, leaving out files already listed by an earlier catalog (the trains
+// and available trains share their clips, say). It returns the catalogs
+// that couldn't be read.
+func commissioningEntries(catalogs []string) ([]commissioningEntry, []string) {
+	wanted := make(map[string]bool)
+	for _, name := range catalogs {
+		wanted[name] = true
+	}
+	var entries []commissioningEntry
+	var skipped []string
+	seen := make(map[string]bool)
+	for _, def := range catalogDefinitions {
+		if len(wanted) > 0 && !wanted[def.Name] {
+			continue
+		}
+		items, err := loadCatalog(def)
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", def.Name, err))
+			continue
+		}
+		for _, item := range items {
+			file := def.itemAudioPath(item)
+			if seen[file] {
+				continue
+			}
+			seen[file] = true
+			entries = append(entries, commissioningEntry{catalog: def.Name, item: item, file: file})
+		}
+	}
+	return entries, skipped
+}
+
+// startCommissioning begins a run in the background
+func startCommissioning(request CommissioningRequest, startedBy string) (CommissioningRun, error) {
+	for _, name := range request.Catalogs {
+		if _, ok := findCatalogDefinition(name); !ok {
+			return CommissioningRun{}, fmt.Errorf("unknown catalog: %s", name)
+		}
+	}
+	play := request.Play == nil || *request.Play
+	if play && (!app.AudioEnabled || audioBackend == nil) {
+		return CommissioningRun{}, fmt.Errorf("audio system not available; start with play off to only decode the files")
+	}
+	if play && maintenanceActive() {
+		return CommissioningRun{}, fmt.Errorf("maintenance mode is on; start with play off to only decode the files")
+	}
+	gap := defaultCommissioningGap
+	if request.GapMs != nil {
+		gap = time.Duration(*request.GapMs) * time.Millisecond
+		if gap < 0 || gap > maxCommissioningGap {
+			return CommissioningRun{}, fmt.Errorf("gap_ms must be between 0 and %d", maxCommissioningGap.Milliseconds())
+		}
+	}
+
+	commissioningMutex.Lock()
+	defer commissioningMutex.Unlock()
+	if commissioningRun != nil && commissioningRun.State == CommissioningRunning {
+		return *commissioningRun, fmt.Errorf("a commissioning run is already in progress")
+	}
+	entries, skipped := commissioningEntries(request.Catalogs)
+	catalogs := request.Catalogs
+	if len(catalogs) == 0 {
+		for _, def := range catalogDefinitions {
+			catalogs = append(catalogs, def.Name)
+		}
+	}
+	commissioningRun = &CommissioningRun{
+		State:     CommissioningRunning,
+		StartedBy: startedBy,
+		StartedAt: time.Now(),
+		Play:      play,
+		GapMs:     int(gap.Milliseconds()),
+		Catalogs:  catalogs,
+		Total:     len(entries),
+		Counts:    make(map[string]int),
+		Results:   []CommissioningResult{},
+		Skipped:   skipped,
+		cancel:    make(chan struct{}),
+	}
+	mode := "playing each"
+	if !play {
+		mode = "decoding only"
+	}
+	log.Printf("🧰 Commissioning run started by %s: %d clips, %s", startedBy, len(entries), mode)
+	started := *commissioningRun
+	started.Counts = make(map[string]int)
+	go runCommissioning(commissioningRun, entries, gap)
+	return started, nil
+}
+
+// runCommissioning checks each entry in turn
+func runCommissioning(run *CommissioningRun, entries []commissioningEntry, gap time.Duration) {
+	var releaseAmplifiers func()
+	if run.Play {
+		releaseAmplifiers = powerZoneAmplifiers(zonesForDevices([]string{currentAudioDevice()}))
+		defer releaseAmplifiers()
+	}
+
+	for i, entry := range entries {
+		select {
+		case <-run.cancel:
+			return
+		default:
+		}
+		commissioningMutex.Lock()
+		run.Current = audioDisplayPath(entry.file)
+		commissioningMutex.Unlock()
+
+		result := checkCommissioningClip(entry, run.Play)
+		if result.Status != ClipOK {
+			log.Printf("🧰 %s %s (%s): %s %s", entry.catalog, entry.item.ID, result.File, result.Status, result.Error)
+		}
+
+		commissioningMutex.Lock()
+		if run.State != CommissioningRunning {
+			commissioningMutex.Unlock()
+			return
+		}
+		run.Results = append(run.Results, result)
+		run.Counts[result.Status]++
+		run.Checked++
+		commissioningMutex.Unlock()
+
+		if run.Play && i < len(entries)-1 && gap > 0 {
+			select {
+			case <-run.cancel:
+				return
+			case <-time.After(gap):
+			}
+		}
+	}
+	finishCommissioning(run, CommissioningCompleted, "")
+}
+
+// finishCommissioning ends a run and keeps it
+func finishCommissioning(run *CommissioningRun, state, cancelledBy string) {
+	commissioningMutex.Lock()
+	if run.State != CommissioningRunning {
+		commissioningMutex.Unlock()
+		return
+	}
+	now := time.Now()
+	run.State, run.EndedAt, run.Current, run.CancelledBy = state, &now, "", cancelledBy
+	if state == CommissioningCancelled {
+		close(run.cancel)
+	}
+	data, _ := json.MarshalIndent(run, "", "  ")
+	problems := run.Checked - run.Counts[ClipOK]
+	commissioningMutex.Unlock()
+
+	if err := os.WriteFile(commissioningPath(), data, 0644); err != nil {
+		log.Printf("Warning: Failed to save the commissioning run: %v", err)
+	}
+	log.Printf("🧰 Commissioning run %s: %d clips checked, %d on the punch list", state, run.Checked, problems)
+}
+
+// checkCommissioningClip decodes an entry's file and, when play is set,
+// plays it
+func checkCommissioningClip(entry commissioningEntry, play bool) CommissioningResult {
+	result := CommissioningResult{
+		Catalog: entry.catalog,
+		ID:      entry.item.ID,
+		Name:    entry.item.Name,
+		File:    audioDisplayPath(entry.file),
+	}
+	if !fileExists(entry.file) {
+		result.Status = ClipMissing
+		return result
+	}
+	streamer, closeStream, err := openAudioStream(entry.file)
+	if err != nil {
+		result.Status, result.Error = ClipUnreadable, err.Error()
+		return result
+	}
+	defer closeStream()
+
+	counter := &commissioningCounter{streamer: streamer}
+	sampleRate := sampleRateOrDefault()
+	if play {
+		err = playCommissioningClip(counter)
+	} else {
+		samples := make([][2]float64, 4096)
+		for {
+			if _, ok := counter.Stream(samples); !ok {
+				break
+			}
+		}
+	}
+	result.DurationSeconds = roundTo(sampleRate.D(counter.samples).Seconds(), 2)
+
+	switch {
+	case err != nil:
+		result.Status, result.Error = ClipPlaybackFailed, err.Error()
+	case streamer.Err() != nil:
+		result.Status, result.Error = ClipCorrupt, streamer.Err().Error()
+	case counter.samples == 0:
+		result.Status = ClipEmpty
+	case !counter.audible:
+		result.Status = ClipSilent
+	default:
+		result.Status = ClipOK
+	}
+	return result
+}
+
+// playCommissioningClip plays a clip once no announcement is playing,
+// treating a clip that never finishes as a stalled output
+func playCommissioningClip(streamer beep.Streamer) error {
+	globalAudioMutex.Lock()
+	defer globalAudioMutex.Unlock()
+
+	cancelChan := make(chan bool, 1)
+	timer := time.AfterFunc(maxCommissioningClip+commissioningStallMargin, func() {
+		cancelChan <- true
+	})
+	err := audioBackend.Play(withMeter(applyVolume(streamer)), cancelChan)
+	if !timer.Stop() {
+		return fmt.Errorf("the clip did not finish playing (output stalled)")
+	}
+	return err
+}
+
+// commissioningCounter counts the samples of a clip and whether any is
+// audible
+type commissioningCounter struct {
+	streamer beep.Streamer
+	samples  int
+	audible  bool
+}
+
+func (c *commissioningCounter) Stream(samples [][2]float64) (int, bool) {
+	n, ok := c.streamer.Stream(samples)
+	if !c.audible {
+		for _, s := range samples[:n] {
+			if s[0] != 0 || s[1] != 0 {
+				c.audible = true
+				break
+			}
+		}
+	}
+	c.samples += n
+	return n, ok
+}
+
+func (c *commissioningCounter) Err() error {
+	return c.streamer.Err()
+}
+
+// cancelCommissioning stops a run once the clip playing has finished
+func cancelCommissioning(operator string) error {
+	commissioningMutex.Lock()
+	run := commissioningRun
+	commissioningMutex.Unlock()
+	if run == nil || run.State != CommissioningRunning {
+		return fmt.Errorf("no commissioning run is in progress")
+	}
+	finishCommissioning(run, CommissioningCancelled, operator)
+	return nil
+}
+
+// currentCommissioning returns the run in progress or the last one, read
+// from disk after a restart, or nil
+func currentCommissioning() *CommissioningRun {
+	commissioningMutex.Lock()
+	defer commissioningMutex.Unlock()
+	if commissioningRun == nil {
+		data, err := os.ReadFile(commissioningPath())
+		if err != nil {
+			return nil
+		}
+		var run CommissioningRun
+		if err := json.Unmarshal(data, &run); err != nil {
+			return nil
+		}
+		commissioningRun = &run
+	}
+	run := *commissioningRun
+	run.Results = append([]CommissioningResult(nil), commissioningRun.Results...)
+	counts := make(map[string]int, len(run.Counts))
+	for status, count := range run.Counts {
+		counts[status] = count
+	}
+	run.Counts = counts
+	return &run
+}
+
+// punchList returns the results that need attention
+func (run *CommissioningRun) punchList() []CommissioningResult {
+	list := []CommissioningResult{}
+	for _, result := range run.Results {
+		if result.Status != ClipOK {
+			list = append(list, result)
+		}
+	}
+	return list
+}
+
+// commissioningView is a run with its punch list
+func commissioningView(run *CommissioningRun) gin.H {
+	if run == nil {
+		return gin.H{"run": nil, "punch_list": []CommissioningResult{}}
+	}
+	return gin.H{"run": run, "punch_list": run.punchList()}
+}
+
+// writePunchList sends a run's punch list as CSV
+func writePunchList(c *gin.Context, run *CommissioningRun) {
+	rows := [][]string{{"catalog", "id", "name", "file", "status", "error"}}
+	for _, result := range run.punchList() {
+		rows = append(rows, []string{result.Catalog, result.ID, result.Name, result.File, result.Status, result.Error})
+	}
+	writeCSVDownload(c, "punch-list", run.StartedAt, run.StartedAt, rows)
+}
+
+// parseCommissioningRequest reads an optional request body
+func parseCommissioningRequest(c *gin.Context) (CommissioningRequest, error) {
+	var request CommissioningRequest
+	if c.Request.ContentLength == 0 {
+		return request, nil
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		return request, fmt.Errorf("invalid request: %v", err)
+	}
+	for i, name := range request.Catalogs {
+		request.Catalogs[i] = strings.TrimSpace(name)
+	}
+	return request, nil
+}
+
+// Handlers
+
+func getCommissioningHandler(c *gin.Context) {
+	view := commissioningView(currentCommissioning())
+	view["success"] = true
+	c.JSON(http.StatusOK, view)
+}
+
+func startCommissioningHandler(c *gin.Context) {
+	request, err := parseCommissioningRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	run, err := startCommissioning(request, requestOperator(c))
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": fmt.Sprintf("Checking %d clips", run.Total),
+		"run":     run,
+	})
+}
+
+func cancelCommissioningHandler(c *gin.Context) {
+	if err := cancelCommissioning(requestOperator(c)); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Commissioning run cancelled"})
+}
+
+func punchListHandler(c *gin.Context) {
+	run := currentCommissioning()
+	if run == nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "No commissioning run yet"})
+		return
+	}
+	writePunchList(c, run)
+}
+
+// API handlers
+
+func apiGetCommissioningHandler(c *gin.Context) {
+	respondOK(c, commissioningView(currentCommissioning()))
+}
+
+func apiStartCommissioningHandler(c *gin.Context) {
+	request, err := parseCommissioningRequest(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+	run, err := startCommissioning(request, requestOperator(c))
+	if err != nil {
+		respondError(c, http.StatusConflict, ErrCodeConflict, err.Error())
+		return
+	}
+	respondSuccess(c, http.StatusAccepted, fmt.Sprintf("Checking %d clips", run.Total), run)
+}
+
+func apiCancelCommissioningHandler(c *gin.Context) {
+	if err := cancelCommissioning(requestOperator(c)); err != nil {
+		respondError(c, http.StatusConflict, ErrCodeConflict, err.Error())
+		return
+	}
+	respondSuccess(c, http.StatusOK, "Commissioning run cancelled", nil)
+}
+
+func apiPunchListHandler(c *gin.Context) {
+	run := currentCommissioning()
+	if run == nil {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "No commissioning run yet")
+		return
+	}
+	writePunchList(c, run)
+}
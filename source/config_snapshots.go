@@ -0,0 +1,627 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Every night the JSON files in the configuration directory are zipped into
+// json/snapshots/, so yesterday's working schedule can be had back even when
+// no one took a backup before a bad day of edits. Snapshots are kept for
+// keep_days and at most keep_count of them, the newest always stays. A
+// snapshot is restored through the API; what is on disk is snapshotted first,
+// so a restore can be undone the same way. Unlike the revisions in
+// json/history/, which follow one file at a time, a snapshot is the whole
+// configuration at one moment.
+
+// Files that hold live state rather than configuration are left out
+var unsnapshottedJSON = map[string]bool{
+	"departure_status.json": true,
+	"fleet_state.json":      true,
+	"lightning_state.json":  true,
+}
+
+// Snapshot reasons, kept in the archive comment
+const (
+	snapshotNightly       = "nightly"
+	snapshotManual        = "manual"
+	snapshotBeforeRestore = "before_restore"
+	// Taken before a replication bundle from the primary is applied
+	snapshotBeforeReplication = "before_replication"
+	// Uploaded from another unit or an earlier download
+	snapshotImported = "imported"
+)
+
+// ConfigSnapshotSettings is the snapshots section of admin_config.json
+type ConfigSnapshotSettings struct {
+	Enabled   bool   `json:"enabled"`
+	Time      string `json:"time"`       // Nightly snapshot time, HH:MM local
+	KeepDays  int    `json:"keep_days"`  // Snapshots older than this are deleted; 0 turns the limit off
+	KeepCount int    `json:"keep_count"` // Most snapshots kept; 0 turns the limit off
+}
+
+func getDefaultConfigSnapshotSettings() ConfigSnapshotSettings {
+	return ConfigSnapshotSettings{
+		Enabled:   true,
+		Time:      "03:00",
+		KeepDays:  30,
+		KeepCount: 60,
+	}
+}
+
+// ConfigSnapshot describes one archive in the snapshot directory
+type ConfigSnapshot struct {
+	ID        string    `json:"id"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+	SizeBytes int64     `json:"size_bytes"`
+	Files     []string  `json:"files"`
+}
+
+var (
+	configSnapshotMutex sync.Mutex
+	lastNightlySnapshot string // Date of the last nightly snapshot, YYYY-MM-DD
+)
+
+// configSnapshotDir is where the snapshot archives are kept
+func configSnapshotDir() string {
+	return filepath.Join(app.Config.JSONDir, "snapshots")
+}
+
+// loadConfigSnapshotSettings reads the snapshots section of admin_config.json.
+// Configs written before snapshots existed get the defaults.
+func loadConfigSnapshotSettings() ConfigSnapshotSettings {
+	adminConfig, err := loadAdminConfig(adminConfigPath())
+	if err != nil || adminConfig.Snapshots == nil {
+		return getDefaultConfigSnapshotSettings()
+	}
+	settings := *adminConfig.Snapshots
+	if _, err := time.Parse("15:04", settings.Time); err != nil {
+		settings.Time = getDefaultConfigSnapshotSettings().Time
+	}
+	return settings
+}
+
+// snapshotArchivePath is the archive of a snapshot ID
+func snapshotArchivePath(id string) string {
+	return filepath.Join(configSnapshotDir(), "config-"+id+".zip")
+}
+
+// validSnapshotID keeps IDs from the API inside the snapshot directory
+func validSnapshotID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, `/\.`) && filepath.Base(id) == id
+}
+
+// snapshottedFiles lists the JSON files a snapshot takes
+func snapshottedFiles() ([]string, error) {
+	entries, err := os.ReadDir(app.Config.JSONDir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && filepath.Ext(name) == ".json" && !unsnapshottedJSON[name] {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
+
+// takeConfigSnapshot zips the configuration into a new snapshot and applies
+// the retention. Caller must hold configSnapshotMutex.
+func takeConfigSnapshot(reason string) (*ConfigSnapshot, error) {
+	files, err := snapshottedFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %v", app.Config.JSONDir, err)
+	}
+	if err := os.MkdirAll(configSnapshotDir(), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", configSnapshotDir(), err)
+	}
+
+	now := time.Now()
+	id := newSnapshotID(now)
+	archivePath := snapshotArchivePath(id)
+	partial := archivePath + ".partial"
+
+	// admin_config.json holds credentials, so the archives are private
+	out, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = writeConfigArchive(out, files, reason, now)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partial)
+		return nil, err
+	}
+	if err := os.Rename(partial, archivePath); err != nil {
+		os.Remove(partial)
+		return nil, err
+	}
+
+	snapshot := &ConfigSnapshot{ID: id, Reason: reason, CreatedAt: now, Files: files}
+	if info, err := os.Stat(archivePath); err == nil {
+		snapshot.SizeBytes = info.Size()
+	}
+	log.Printf("📦 Configuration snapshot %s taken (%s, %d files)", id, reason, len(files))
+	if removed, err := pruneConfigSnapshots(loadConfigSnapshotSettings()); err != nil {
+		log.Printf("Warning: snapshot cleanup failed: %v", err)
+	} else if removed > 0 {
+		log.Printf("Removed %d old configuration snapshots", removed)
+	}
+	return snapshot, nil
+}
+
+// newSnapshotID returns an unused ID for a snapshot taken at now
+func newSnapshotID(now time.Time) string {
+	id := now.Format("20060102-150405")
+	for n := 2; fileExists(snapshotArchivePath(id)); n++ {
+		id = fmt.Sprintf("%s-%d", now.Format("20060102-150405"), n)
+	}
+	return id
+}
+
+// importConfigSnapshot adds an uploaded archive to the snapshots, so it can
+// be restored like one taken here. The JSON files are checked and copied into
+// a new archive; anything else in the upload is left out. The caller holds
+// configSnapshotMutex.
+func importConfigSnapshot(path string) (*ConfigSnapshot, error) {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("not a zip archive: %v", err)
+	}
+	defer reader.Close()
+	var files []*zip.File
+	wanted := make(map[string]bool)
+	for _, file := range reader.File {
+		if filepath.Base(file.Name) == file.Name && filepath.Ext(file.Name) == ".json" {
+			files = append(files, file)
+			wanted[file.Name] = true
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("the archive has no configuration files")
+	}
+	if _, err := readConfigArchive(files, wanted); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(configSnapshotDir(), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", configSnapshotDir(), err)
+	}
+
+	id := newSnapshotID(time.Now())
+	archivePath := snapshotArchivePath(id)
+	partial := archivePath + ".partial"
+	out, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	writer := zip.NewWriter(out)
+	for _, file := range files {
+		if err = writer.Copy(file); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		if err = writer.SetComment(snapshotImported); err == nil {
+			err = writer.Close()
+		}
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partial)
+		return nil, err
+	}
+	if err := os.Rename(partial, archivePath); err != nil {
+		os.Remove(partial)
+		return nil, err
+	}
+	return readConfigSnapshot(id)
+}
+
+// writeConfigArchive zips JSON files from the configuration directory to out
+func writeConfigArchive(out io.Writer, files []string, comment string, modified time.Time) error {
+	writer := zip.NewWriter(out)
+	writer.SetComment(comment)
+	for _, name := range files {
+		data, err := os.ReadFile(filepath.Join(app.Config.JSONDir, name))
+		if err == nil {
+			var entry io.Writer
+			if entry, err = writer.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified}); err == nil {
+				_, err = entry.Write(data)
+			}
+		}
+		if err != nil {
+			writer.Close()
+			return fmt.Errorf("failed to add %s: %v", name, err)
+		}
+	}
+	return writer.Close()
+}
+
+// readConfigSnapshot describes the snapshot in an archive
+func readConfigSnapshot(id string) (*ConfigSnapshot, error) {
+	archivePath := snapshotArchivePath(id)
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	snapshot := &ConfigSnapshot{ID: id, Reason: reader.Comment, Files: []string{}}
+	// The ID starts with the time it was taken; a suffix follows it when two
+	// were taken within the same second
+	if len(id) >= 15 {
+		if created, err := time.ParseInLocation("20060102-150405", id[:15], time.Local); err == nil {
+			snapshot.CreatedAt = created
+		}
+	}
+	if info, err := os.Stat(archivePath); err == nil {
+		snapshot.SizeBytes = info.Size()
+	}
+	for _, file := range reader.File {
+		snapshot.Files = append(snapshot.Files, file.Name)
+	}
+	return snapshot, nil
+}
+
+// listConfigSnapshots returns the snapshots, newest first. Caller must hold
+// configSnapshotMutex.
+func listConfigSnapshots() ([]ConfigSnapshot, error) {
+	matches, err := filepath.Glob(filepath.Join(configSnapshotDir(), "config-*.zip"))
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]ConfigSnapshot, 0, len(matches))
+	for _, match := range matches {
+		id := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), "config-"), ".zip")
+		snapshot, err := readConfigSnapshot(id)
+		if err != nil {
+			log.Printf("Warning: skipping unreadable snapshot %s: %v", filepath.Base(match), err)
+			continue
+		}
+		snapshots = append(snapshots, *snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].ID > snapshots[j].ID
+	})
+	return snapshots, nil
+}
+
+// pruneConfigSnapshots deletes snapshots beyond keep_days and keep_count. The
+// newest is always kept. Caller must hold configSnapshotMutex.
+func pruneConfigSnapshots(settings ConfigSnapshotSettings) (int, error) {
+	snapshots, err := listConfigSnapshots()
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().AddDate(0, 0, -settings.KeepDays)
+	removed := 0
+	for i, snapshot := range snapshots {
+		if i == 0 {
+			continue
+		}
+		tooMany := settings.KeepCount > 0 && i >= settings.KeepCount
+		tooOld := settings.KeepDays > 0 && snapshot.CreatedAt.Before(cutoff)
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.Remove(snapshotArchivePath(snapshot.ID)); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// startConfigSnapshots takes the nightly snapshot once the configured time
+// has passed each day. A unit that was off at that time takes it when it
+// comes back.
+func startConfigSnapshots() {
+	configSnapshotMutex.Lock()
+	if snapshots, err := listConfigSnapshots(); err == nil {
+		for _, snapshot := range snapshots {
+			if snapshot.Reason == snapshotNightly {
+				lastNightlySnapshot = snapshot.CreatedAt.Format("2006-01-02")
+				break
+			}
+		}
+	}
+	configSnapshotMutex.Unlock()
+
+	go func() {
+		for {
+			checkNightlySnapshot(loadConfigSnapshotSettings(), time.Now())
+			time.Sleep(time.Minute)
+		}
+	}()
+}
+
+// checkNightlySnapshot takes today's snapshot if it is due
+func checkNightlySnapshot(settings ConfigSnapshotSettings, now time.Time) {
+	if !settings.Enabled {
+		return
+	}
+	at, _ := time.Parse("15:04", settings.Time)
+	due := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	today := now.Format("2006-01-02")
+
+	configSnapshotMutex.Lock()
+	defer configSnapshotMutex.Unlock()
+	if now.Before(due) || lastNightlySnapshot == today {
+		return
+	}
+	if _, err := takeConfigSnapshot(snapshotNightly); err != nil {
+		log.Printf("❌ Nightly configuration snapshot failed: %v", err)
+		notify(NotifyConfigSnapshotFailed, "", "warning", "Configuration snapshot failed",
+			fmt.Sprintf("The nightly snapshot of %s failed: %v", app.Config.JSONDir, err))
+		return
+	}
+	lastNightlySnapshot = today
+}
+
+// Files that are put back into effect after a restore; the others are read
+// where they are used, or need a restart
+var snapshotReloaders = map[string]func() error{
+	"cron.json":           func() error { updateScheduler(); return nil },
+	"audio_settings.json": loadAudioSettings,
+	"locales.json":        loadLocaleSettings,
+	"track_layout.json":   loadTrackLayout,
+	"trigger_rules.json":  loadTriggerRules,
+	"maintenance.json":    loadMaintenanceMode,
+	"lightning.json":      loadLightningConfig,
+	"schedule_holds.json": loadScheduleHolds,
+	"settings.json": func() error {
+		old := currentRuntimeSettings()
+		if err := loadRuntimeSettings(); err != nil {
+			return err
+		}
+		applyRuntimeSettings(old, currentRuntimeSettings())
+		return nil
+	},
+}
+
+// Files only read at startup
+var snapshotRestartFiles = map[string]bool{
+	"admin_config.json":          true,
+	"triggers.json":              true,
+	"announcement_switches.json": true,
+}
+
+// readConfigArchive reads the JSON files in an archive. With no files
+// wanted, it reads every file but admin_config.json.
+func readConfigArchive(files []*zip.File, wanted map[string]bool) (map[string][]byte, error) {
+	contents := make(map[string][]byte)
+	for _, file := range files {
+		name := file.Name
+		if filepath.Base(name) != name || filepath.Ext(name) != ".json" {
+			continue
+		}
+		if len(wanted) > 0 {
+			if !wanted[name] {
+				continue
+			}
+		} else if name == "admin_config.json" {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("%s is not valid JSON", name)
+		}
+		contents[name] = data
+	}
+	return contents, nil
+}
+
+// writeConfigFiles writes JSON files into the configuration directory and
+// puts them into effect. Schedule and catalog files are saved as revisions,
+// so the history shows where they came from. It returns the files written
+// and those that need a restart.
+func writeConfigFiles(contents map[string][]byte, author, note string) (written, restart []string, err error) {
+	logicalNames := make(map[string]string)
+	for name, file := range jsonFiles {
+		logicalNames[file.FileName] = name
+	}
+	for name, data := range contents {
+		if logical, ok := logicalNames[name]; ok && revisionTracked(logical) {
+			err = saveJSONBy(logical, json.RawMessage(data), author, note)
+		} else {
+			err = os.WriteFile(filepath.Join(app.Config.JSONDir, name), data, 0644)
+			if name == "admin_config.json" {
+				os.Chmod(filepath.Join(app.Config.JSONDir, name), 0600)
+			}
+		}
+		if err != nil {
+			return written, restart, fmt.Errorf("failed to write %s: %v", name, err)
+		}
+		written = append(written, name)
+	}
+	sort.Strings(written)
+
+	restart = []string{}
+	for _, name := range written {
+		if reload, ok := snapshotReloaders[name]; ok {
+			if err := reload(); err != nil {
+				log.Printf("Warning: %s did not load: %v", name, err)
+			}
+		} else if snapshotRestartFiles[name] {
+			restart = append(restart, name)
+		}
+	}
+	return written, restart, nil
+}
+
+// restoreConfigSnapshot writes the files of a snapshot back into the JSON
+// directory and puts them into effect. With no files named, everything but
+// admin_config.json is restored. It returns the files restored and those
+// that need a restart. Caller must hold configSnapshotMutex.
+func restoreConfigSnapshot(id string, only []string, operator string) (restored, restart []string, err error) {
+	reader, err := zip.OpenReader(snapshotArchivePath(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+
+	wanted := make(map[string]bool)
+	for _, name := range only {
+		wanted[name] = true
+	}
+	contents, err := readConfigArchive(reader.File, wanted)
+	if err != nil {
+		return nil, nil, err
+	}
+	for name := range wanted {
+		if _, ok := contents[name]; !ok {
+			return nil, nil, fmt.Errorf("%s is not in snapshot %s", name, id)
+		}
+	}
+
+	if _, err := takeConfigSnapshot(snapshotBeforeRestore); err != nil {
+		return nil, nil, fmt.Errorf("failed to snapshot the current configuration first: %v", err)
+	}
+	return writeConfigFiles(contents, operator, "Restored from snapshot "+id)
+}
+
+// Handlers
+
+// apiListConfigSnapshotsHandler lists the snapshots, newest first
+func apiListConfigSnapshotsHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	configSnapshotMutex.Lock()
+	snapshots, err := listConfigSnapshots()
+	configSnapshotMutex.Unlock()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to list snapshots: "+err.Error())
+		return
+	}
+	respondOK(c, gin.H{
+		"settings":  loadConfigSnapshotSettings(),
+		"snapshots": snapshots,
+	})
+}
+
+// apiTakeConfigSnapshotHandler takes a snapshot now
+func apiTakeConfigSnapshotHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	configSnapshotMutex.Lock()
+	snapshot, err := takeConfigSnapshot(snapshotManual)
+	configSnapshotMutex.Unlock()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to take snapshot: "+err.Error())
+		return
+	}
+	log.Printf("Configuration snapshot %s requested by %s", snapshot.ID, requestOperator(c))
+	respondSuccess(c, http.StatusCreated, "Snapshot taken", snapshot)
+}
+
+// apiDownloadConfigSnapshotHandler sends a snapshot archive
+func apiDownloadConfigSnapshotHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	id := c.Param("id")
+	if !validSnapshotID(id) || !fileExists(snapshotArchivePath(id)) {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Snapshot not found")
+		return
+	}
+	c.FileAttachment(snapshotArchivePath(id), "tarr-config-"+id+".zip")
+}
+
+// apiImportConfigSnapshotHandler adds a snapshot archive sent as a chunked
+// upload: {"upload_id": "..."}
+func apiImportConfigSnapshotHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	var request struct {
+		UploadID string `json:"upload_id"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || request.UploadID == "" {
+		respondValidationError(c, "Invalid snapshot import", FieldError{Field: "upload_id", Message: "is required"})
+		return
+	}
+	_, path, release, err := claimUpload(request.UploadID, uploadSnapshot)
+	if err != nil {
+		respondValidationError(c, "Invalid snapshot import", FieldError{Field: "upload_id", Message: err.Error()})
+		return
+	}
+
+	configSnapshotMutex.Lock()
+	snapshot, err := importConfigSnapshot(path)
+	configSnapshotMutex.Unlock()
+	if err != nil {
+		respondValidationError(c, "Invalid snapshot import", FieldError{Field: "upload_id", Message: err.Error()})
+		return
+	}
+	release()
+	log.Printf("Configuration snapshot %s imported by %s (%d files)", snapshot.ID, requestOperator(c), len(snapshot.Files))
+	respondSuccess(c, http.StatusCreated, "Snapshot imported", snapshot)
+}
+
+// apiRestoreConfigSnapshotHandler restores a snapshot. The body may name the
+// files to restore: {"files": ["cron.json"]}.
+func apiRestoreConfigSnapshotHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	id := c.Param("id")
+	if !validSnapshotID(id) || !fileExists(snapshotArchivePath(id)) {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Snapshot not found")
+		return
+	}
+	var request struct {
+		Files []string `json:"files"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+	}
+
+	configSnapshotMutex.Lock()
+	restored, restart, err := restoreConfigSnapshot(id, request.Files, requestOperator(c))
+	configSnapshotMutex.Unlock()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to restore snapshot: "+err.Error())
+		return
+	}
+	log.Printf("↩️  Configuration restored from snapshot %s by %s (%d files)", id, requestOperator(c), len(restored))
+	message := fmt.Sprintf("Restored %d files from snapshot %s", len(restored), id)
+	if len(restart) > 0 {
+		message += "; restart to apply " + strings.Join(restart, ", ")
+	}
+	respondSuccess(c, http.StatusOK, message, gin.H{
+		"restored":       restored,
+		"restart_needed": restart,
+	})
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// envOrDefault returns the environment variable, or fallback when it is unset
+func envOrDefault(name, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// runningInContainer reports whether we appear to be inside Docker, Podman or
+// another OCI runtime
+func runningInContainer() bool {
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if fileExists(marker) {
+			return true
+		}
+	}
+	data, err := os.ReadFile("/proc/1/cgroup")
+	if err != nil {
+		return false
+	}
+	cgroup := string(data)
+	for _, runtimeName := range []string{"docker", "containerd", "kubepods", "libpod", "lxc"} {
+		if strings.Contains(cgroup, runtimeName) {
+			return true
+		}
+	}
+	return false
+}
+
+// dirWritable checks a directory can be written by creating a scratch file.
+// With a read-only root filesystem only mounted volumes pass.
+func dirWritable(dir string) bool {
+	file, err := os.CreateTemp(dir, ".tarr-write-test-*")
+	if err != nil {
+		return false
+	}
+	name := file.Name()
+	file.Close()
+	os.Remove(name)
+	return true
+}
+
+// seedConfigDir copies any JSON files missing from jsonDir out of defaultsDir.
+// The image ships its defaults outside the config volume, so an empty volume
+// mounted over json/ is filled on first start and never overwritten after.
+func seedConfigDir(jsonDir, defaultsDir string) error {
+	if defaultsDir == "" || !dirExists(defaultsDir) {
+		return nil
+	}
+	if err := os.MkdirAll(jsonDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", jsonDir, err)
+	}
+
+	entries, err := os.ReadDir(defaultsDir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", defaultsDir, err)
+	}
+	seeded := 0
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		target := filepath.Join(jsonDir, entry.Name())
+		if fileExists(target) {
+			continue
+		}
+		if err := copyFile(filepath.Join(defaultsDir, entry.Name()), target); err != nil {
+			return err
+		}
+		seeded++
+	}
+	if seeded > 0 {
+		log.Printf("✓ Seeded %d default config files into %s", seeded, jsonDir)
+	}
+	return nil
+}
+
+func copyFile(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", target, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to write %s: %v", target, err)
+	}
+	return out.Close()
+}
+
+// localURLHost turns a listen address like ":8080" into "localhost:8080" for log messages
+func localURLHost(listenAddr string) string {
+	if strings.HasPrefix(listenAddr, ":") {
+		return "localhost" + listenAddr
+	}
+	return listenAddr
+}
+
+// AudioSockets lists the sound server sockets reachable from this process
+type AudioSockets struct {
+	PulseAudio string `json:"pulseaudio,omitempty"` // pulse/native, also served by pipewire-pulse
+	PipeWire   string `json:"pipewire,omitempty"`
+	ALSADevice bool   `json:"alsa_device"` // /dev/snd passed through
+}
+
+// runtimeDirs are the places a host's XDG_RUNTIME_DIR is usually mounted
+func runtimeDirs() []string {
+	dirs := []string{}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	dirs = append(dirs, fmt.Sprintf("/run/user/%d", os.Getuid()), "/run/user/1000", "/run")
+	return dirs
+}
+
+// detectAudioSockets finds PulseAudio and PipeWire sockets. In a container
+// these are only there when the host's are mounted in, e.g.
+//
+//	-v /run/user/1000/pulse/native:/run/user/1000/pulse/native
+//	-v /run/user/1000/pipewire-0:/run/user/1000/pipewire-0
+//
+// or a single socket at /tmp/pulse-socket.
+func detectAudioSockets() AudioSockets {
+	var sockets AudioSockets
+
+	if server := os.Getenv("PULSE_SERVER"); server != "" {
+		sockets.PulseAudio = server
+	} else {
+		candidates := []string{}
+		for _, dir := range runtimeDirs() {
+			candidates = append(candidates, filepath.Join(dir, "pulse", "native"))
+		}
+		candidates = append(candidates, "/tmp/pulse-socket", "/tmp/pulseaudio.socket")
+		for _, path := range candidates {
+			if isSocket(path) {
+				sockets.PulseAudio = "unix:" + path
+				break
+			}
+		}
+	}
+
+	remote := envOrDefault("PIPEWIRE_REMOTE", "pipewire-0")
+	if filepath.IsAbs(remote) {
+		if isSocket(remote) {
+			sockets.PipeWire = remote
+		}
+	} else {
+		for _, dir := range runtimeDirs() {
+			if path := filepath.Join(dir, remote); isSocket(path) {
+				sockets.PipeWire = path
+				break
+			}
+		}
+	}
+
+	sockets.ALSADevice = dirExists("/dev/snd")
+	return sockets
+}
+
+func isSocket(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeSocket != 0
+}
+
+// containerAudio is what configureContainerAudio found at startup (nil outside a container)
+var containerAudio *AudioSockets
+
+// configureContainerAudio points the audio libraries at the sockets mounted
+// into a container. libpulse (and ALSA's pulse plugin) only look in the
+// current user's runtime dir, which rarely matches the host's, so PULSE_SERVER
+// and XDG_RUNTIME_DIR are set explicitly when we find one elsewhere.
+func configureContainerAudio() *AudioSockets {
+	sockets := detectAudioSockets()
+
+	if sockets.PulseAudio != "" && os.Getenv("PULSE_SERVER") == "" {
+		os.Setenv("PULSE_SERVER", sockets.PulseAudio)
+	}
+	if sockets.PipeWire != "" && os.Getenv("XDG_RUNTIME_DIR") == "" {
+		os.Setenv("XDG_RUNTIME_DIR", filepath.Dir(sockets.PipeWire))
+	}
+
+	switch {
+	case sockets.PipeWire != "":
+		log.Printf("🔊 Container audio: PipeWire socket %s", sockets.PipeWire)
+	case sockets.PulseAudio != "":
+		log.Printf("🔊 Container audio: PulseAudio at %s", sockets.PulseAudio)
+	case sockets.ALSADevice:
+		log.Printf("🔊 Container audio: ALSA devices from /dev/snd")
+	default:
+		log.Printf("⚠️  Container audio: no sound server socket or /dev/snd found. Mount the host's pulse/native or pipewire-0 socket, or pass --device /dev/snd")
+	}
+	return &sockets
+}
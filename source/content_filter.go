@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Text that ends up spoken by the TTS engine or shown on the displays can
+// come from outside: weather templates sent through the API, fleet and
+// integration requests carrying a "text" parameter, visual alert text. The
+// content filter in json/content_filter.json checks it against a blocklist
+// of words and phrases before it is announced. Flagged text is either
+// rejected or has the flagged words replaced, and each time the event goes
+// to the audit log. A blocklist entry ending in * matches any word starting
+// with it; the allowlist names words that are never flagged, for the place
+// names such an entry would otherwise catch.
+
+// Content filter actions
+const (
+	ContentFilterReject = "reject"
+	ContentFilterMask   = "mask"
+)
+
+// ContentFilterSettings is the content of content_filter.json
+type ContentFilterSettings struct {
+	Enabled     bool     `json:"enabled"`
+	Action      string   `json:"action"`      // reject or mask
+	Replacement string   `json:"replacement"` // Said or shown in place of a masked word
+	Blocklist   []string `json:"blocklist"`
+	Allowlist   []string `json:"allowlist"`
+}
+
+func getDefaultContentFilterSettings() ContentFilterSettings {
+	return ContentFilterSettings{
+		Enabled:     true,
+		Action:      ContentFilterMask,
+		Replacement: "beep",
+		Blocklist:   []string{},
+		Allowlist:   []string{},
+	}
+}
+
+// loadContentFilterSettings reads content_filter.json; a missing file is the
+// defaults, which flag nothing until words are added
+func loadContentFilterSettings() (ContentFilterSettings, error) {
+	settings := getDefaultContentFilterSettings()
+	path, _ := jsonFilePath("content_filter")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, fmt.Errorf("failed to read content_filter.json: %v", err)
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return settings, fmt.Errorf("failed to parse content_filter.json: %v", err)
+	}
+	return settings, nil
+}
+
+// validate checks the settings before they are saved
+func (s ContentFilterSettings) validate() []FieldError {
+	var details []FieldError
+	if s.Action != ContentFilterReject && s.Action != ContentFilterMask {
+		details = append(details, FieldError{Field: "action", Message: "must be reject or mask"})
+	}
+	for i, entry := range s.Blocklist {
+		word := strings.TrimSuffix(strings.TrimSpace(entry), "*")
+		if word == "" || strings.Contains(word, "*") {
+			details = append(details, FieldError{Field: fmt.Sprintf("blocklist[%d]", i), Message: "must be a word or phrase, optionally ending in *"})
+		}
+	}
+	for i, entry := range s.Allowlist {
+		if strings.TrimSpace(entry) == "" {
+			details = append(details, FieldError{Field: fmt.Sprintf("allowlist[%d]", i), Message: "must not be empty"})
+		}
+	}
+	return details
+}
+
+// pattern builds the case-insensitive whole-word pattern of the blocklist.
+// Longer entries come first so a phrase wins over a word inside it.
+func (s ContentFilterSettings) pattern() *regexp.Regexp {
+	entries := make([]string, 0, len(s.Blocklist))
+	for _, entry := range s.Blocklist {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return len(entries[i]) > len(entries[j])
+	})
+	alternatives := make([]string, len(entries))
+	for i, entry := range entries {
+		wildcard := strings.HasSuffix(entry, "*")
+		words := strings.Fields(strings.TrimSuffix(entry, "*"))
+		for j, word := range words {
+			words[j] = regexp.QuoteMeta(word)
+		}
+		alternatives[i] = strings.Join(words, `\s+`)
+		if wildcard {
+			alternatives[i] += `\w*`
+		}
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(alternatives, "|") + `)\b`)
+}
+
+// checkContent returns the text with flagged words masked and the words
+// flagged, lowercased and without repeats
+func (s ContentFilterSettings) checkContent(text string) (string, []string) {
+	pattern := s.pattern()
+	if !s.Enabled || pattern == nil {
+		return text, nil
+	}
+	allowed := make(map[string]bool)
+	for _, word := range s.Allowlist {
+		allowed[strings.ToLower(strings.TrimSpace(word))] = true
+	}
+
+	var flagged []string
+	seen := make(map[string]bool)
+	masked := pattern.ReplaceAllStringFunc(text, func(match string) string {
+		word := strings.ToLower(match)
+		if allowed[word] {
+			return match
+		}
+		if !seen[word] {
+			seen[word] = true
+			flagged = append(flagged, word)
+		}
+		return s.Replacement
+	})
+	return masked, flagged
+}
+
+// applyContentFilter checks text from field before it is announced or shown.
+// It returns the text to use, masked if need be, or an announcementParameterError
+// when the filter rejects it. Flagged text is recorded in the audit log.
+func applyContentFilter(field, text, source, user string) (string, error) {
+	settings, err := loadContentFilterSettings()
+	if err != nil {
+		// A broken filter file shouldn't silence weather reports and alerts
+		log.Printf("Warning: content filter not applied: %v", err)
+		return text, nil
+	}
+	masked, flagged := settings.checkContent(text)
+	if len(flagged) == 0 {
+		return text, nil
+	}
+
+	action := "masked"
+	if settings.Action == ContentFilterReject {
+		action = "rejected"
+	}
+	log.Printf("🚫 Content filter %s %s text from %s (%d flagged)", action, source, user, len(flagged))
+	recordAuditEvent(auditEntry{
+		Source:  "content_filter",
+		Action:  action,
+		User:    user,
+		Subject: source,
+		Detail:  "Flagged: " + strings.Join(flagged, ", "),
+	})
+	if settings.Action == ContentFilterReject {
+		return "", &announcementParameterError{details: []FieldError{{Field: field, Message: "contains blocked words: " + strings.Join(flagged, ", ")}}}
+	}
+	return masked, nil
+}
+
+// filterAnnouncementText runs an announcement's "text" parameter through the
+// content filter, replacing it when words are masked
+func filterAnnouncementText(announcementType AnnouncementType, parameters map[string]interface{}, requestedBy string) error {
+	text, _ := parameters["text"].(string)
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	filtered, err := applyContentFilter("text", text, string(announcementType), requestedBy)
+	if err != nil {
+		return err
+	}
+	parameters["text"] = filtered
+	return nil
+}
+
+// Handlers
+
+// apiGetContentFilterHandler returns the filter settings
+func apiGetContentFilterHandler(c *gin.Context) {
+	settings, err := loadContentFilterSettings()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	respondOK(c, settings)
+}
+
+// apiPutContentFilterHandler replaces the filter settings
+func apiPutContentFilterHandler(c *gin.Context) {
+	settings := getDefaultContentFilterSettings()
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	if settings.Blocklist == nil {
+		settings.Blocklist = []string{}
+	}
+	if settings.Allowlist == nil {
+		settings.Allowlist = []string{}
+	}
+	if details := settings.validate(); len(details) > 0 {
+		respondValidationError(c, "Invalid content filter", details...)
+		return
+	}
+	if err := saveJSONBy("content_filter", settings, requestOperator(c), ""); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save content filter: "+err.Error())
+		return
+	}
+	log.Printf("Content filter updated by %s (%d blocked, %d allowed)", requestOperator(c), len(settings.Blocklist), len(settings.Allowlist))
+	respondSuccess(c, http.StatusOK, "Content filter updated", settings)
+}
+
+// apiTestContentFilterHandler shows what the filter would do with "text",
+// without recording anything
+func apiTestContentFilterHandler(c *gin.Context) {
+	data, ok := bindRequestData(c, "text")
+	if !ok {
+		return
+	}
+	text, _ := data["text"].(string)
+	if strings.TrimSpace(text) == "" {
+		respondValidationError(c, "Invalid content filter test", FieldError{Field: "text", Message: "is required"})
+		return
+	}
+	settings, err := loadContentFilterSettings()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	masked, flagged := settings.checkContent(text)
+	result := gin.H{"allowed": len(flagged) == 0, "flagged": flagged, "action": settings.Action}
+	if len(flagged) > 0 && settings.Action == ContentFilterMask {
+		result["text"] = masked
+	}
+	respondOK(c, result)
+}
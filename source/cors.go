@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORSSettings controls cross-origin access to the /api endpoints
+type CORSSettings struct {
+	Enabled          bool     `json:"enabled"`
+	AllowedOrigins   []string `json:"allowed_origins"` // "*" allows any origin
+	AllowedMethods   []string `json:"allowed_methods"`
+	AllowedHeaders   []string `json:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers"`
+	AllowCredentials bool     `json:"allow_credentials"` // Only for origins listed by name, never for "*"
+	MaxAgeSeconds    int      `json:"max_age_seconds"`
+}
+
+// getDefaultCORSSettings allows nothing until origins are configured
+func getDefaultCORSSettings() CORSSettings {
+	return CORSSettings{
+		Enabled:        false,
+		AllowedOrigins: []string{},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Content-Type", "Authorization", "X-API-Key", "X-Request-ID", "Idempotency-Key"},
+		ExposedHeaders: []string{"X-Request-ID", "Idempotent-Replayed", "Location"},
+		MaxAgeSeconds:  600,
+	}
+}
+
+// corsSettings reads the CORS section of admin_config.json, filling gaps with defaults
+func corsSettings() CORSSettings {
+	defaults := getDefaultCORSSettings()
+	adminConfig, err := loadAdminConfig(filepath.Join(app.Config.JSONDir, "admin_config.json"))
+	if err != nil {
+		return defaults
+	}
+
+	settings := adminConfig.API.CORS
+	if len(settings.AllowedMethods) == 0 {
+		settings.AllowedMethods = defaults.AllowedMethods
+	}
+	if len(settings.AllowedHeaders) == 0 {
+		settings.AllowedHeaders = defaults.AllowedHeaders
+	}
+	if settings.ExposedHeaders == nil {
+		settings.ExposedHeaders = defaults.ExposedHeaders
+	}
+	if settings.MaxAgeSeconds <= 0 {
+		settings.MaxAgeSeconds = defaults.MaxAgeSeconds
+	}
+	return settings
+}
+
+// originAllowed reports whether the request origin is in the allow list, and
+// whether it is listed by name rather than only matching "*"
+func (s CORSSettings) originAllowed(origin string) (allowed, named bool) {
+	for _, entry := range s.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(entry, "/"), origin) {
+			return true, true
+		}
+		if entry == "*" {
+			allowed = true
+		}
+	}
+	return allowed, false
+}
+
+// corsMiddleware adds CORS headers to /api responses and answers preflight
+// requests. It runs before routing so OPTIONS never reaches NoRoute.
+func corsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" || !strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			c.Next()
+			return
+		}
+
+		settings := corsSettings()
+		c.Header("Vary", "Origin")
+		allowed, named := settings.originAllowed(origin)
+		if !settings.Enabled || !allowed {
+			// Leave the headers off and let the browser block the response
+			if c.Request.Method == http.MethodOptions {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		// Credentials are only allowed for origins listed by name. Echoing
+		// any origin with them would let every website make calls with the
+		// admin's session cookie, so "*" is sent as is and without them.
+		if named {
+			c.Header("Access-Control-Allow-Origin", origin)
+			if settings.AllowCredentials {
+				c.Header("Access-Control-Allow-Credentials", "true")
+			}
+		} else {
+			c.Header("Access-Control-Allow-Origin", "*")
+		}
+
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Header("Access-Control-Allow-Methods", strings.Join(settings.AllowedMethods, ", "))
+			c.Header("Access-Control-Allow-Headers", strings.Join(settings.AllowedHeaders, ", "))
+			c.Header("Access-Control-Max-Age", strconv.Itoa(settings.MaxAgeSeconds))
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		if len(settings.ExposedHeaders) > 0 {
+			c.Header("Access-Control-Expose-Headers", strings.Join(settings.ExposedHeaders, ", "))
+		}
+		c.Next()
+	}
+}
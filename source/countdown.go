@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Countdown announcements ("The 3:30 train to Lakeview departs in five
+// minutes") are generated from the departure board rather than scheduled one
+// by one: every station announcement in cron.json is a departure, and each is
+// counted down at the offsets in the "countdown" section of cron.json. A
+// delayed departure is counted down to its expected time and a cancelled one
+// is skipped. The sentence is composed from the "countdown" template in
+// locales.json using the clock clips for the departure time and
+// mp3/number/<n>.mp3 for the minutes.
+
+// CountdownSettings is the "countdown" section of cron.json
+type CountdownSettings struct {
+	Enabled   bool     `json:"enabled"`
+	Offsets   []int    `json:"offsets"`             // Minutes before departure, e.g. [10, 5, 1]
+	Priority  string   `json:"priority,omitempty"`  // Default: normal
+	Languages []string `json:"languages,omitempty"` // Default: the station entry's languages
+}
+
+// Longest offset accepted, in minutes
+const maxCountdownOffset = 120
+
+// A countdown that comes due while the annunciator is down is dropped once
+// it is this late, instead of telling guests about a train already gone
+const countdownGrace = time.Minute
+
+var (
+	// Countdowns already queued, keyed by train, departure time and offset
+	countdownsQueued      = make(map[string]time.Time)
+	countdownsQueuedMutex sync.Mutex
+)
+
+// validate checks the offsets
+func (s CountdownSettings) validate() []FieldError {
+	var details []FieldError
+	seen := make(map[int]bool)
+	for i, offset := range s.Offsets {
+		field := fmt.Sprintf("schedule.countdown.offsets[%d]", i)
+		if offset < 1 || offset > maxCountdownOffset {
+			details = append(details, FieldError{Field: field, Message: fmt.Sprintf("must be between 1 and %d minutes", maxCountdownOffset)})
+		} else if seen[offset] {
+			details = append(details, FieldError{Field: field, Message: "is listed twice"})
+		}
+		seen[offset] = true
+	}
+	if s.Enabled && len(s.Offsets) == 0 {
+		details = append(details, FieldError{Field: "schedule.countdown.offsets", Message: "is required when countdowns are enabled"})
+	}
+	if err := validateLanguages(s.Languages); err != nil {
+		details = append(details, FieldError{Field: "schedule.countdown.languages", Message: err.Error()})
+	}
+	return details
+}
+
+// countdownParameters builds the announcement parameters for a departure
+// counted down with minutes to go
+func countdownParameters(departure Departure, minutes int, languages []string) map[string]interface{} {
+	hour, _ := twelveHour(departure.DepartsAt.Hour())
+	parameters := map[string]interface{}{
+		"train_number": departure.TrainNumber,
+		"destination":  departure.destinationID,
+		"track_number": departure.trackNumber,
+		"hour":         strconv.Itoa(hour),
+		"minute":       fmt.Sprintf("%02d", departure.DepartsAt.Minute()),
+		"minutes":      strconv.Itoa(minutes),
+	}
+	if len(languages) == 0 {
+		languages = departure.languages
+	}
+	if len(languages) > 0 {
+		parameters["languages"] = languages
+	}
+	return parameters
+}
+
+// withCountdownUnit adds the "unit" segment name (minute or minutes) to a
+// countdown's parameters so templates can say "one minute" and "five minutes"
+func withCountdownUnit(parameters map[string]interface{}) map[string]interface{} {
+	withUnit := make(map[string]interface{}, len(parameters)+1)
+	for key, value := range parameters {
+		withUnit[key] = value
+	}
+	if _, ok := withUnit["unit"]; !ok {
+		withUnit["unit"] = "minutes"
+		if fmt.Sprint(parameters["minutes"]) == "1" {
+			withUnit["unit"] = "minute"
+		}
+	}
+	return withUnit
+}
+
+// queueDueCountdowns queues every countdown whose offset has been reached
+func queueDueCountdowns() {
+	settings := loadJSON("cron", CronData{}).(CronData).Countdown
+	if !settings.Enabled || len(settings.Offsets) == 0 || announcementManager == nil {
+		return
+	}
+	priority := PriorityNormal
+	if settings.Priority != "" {
+		priority = ParsePriority(settings.Priority)
+	}
+
+	now := time.Now()
+	countdownsQueuedMutex.Lock()
+	defer countdownsQueuedMutex.Unlock()
+
+	for _, departure := range upcomingDepartures(0) {
+		if departure.Status == DepartureCancelled {
+			continue
+		}
+		if departure.DepartsAt.Sub(now) > maxCountdownOffset*time.Minute {
+			break // Departures are sorted, so the rest are further out
+		}
+		departsAt := departure.DepartsAt
+		if departure.ExpectedAt != nil {
+			departsAt = *departure.ExpectedAt
+		}
+		for _, offset := range settings.Offsets {
+			announceAt := departsAt.Add(-time.Duration(offset) * time.Minute)
+			if now.Before(announceAt) || now.Sub(announceAt) > countdownGrace {
+				continue
+			}
+			key := fmt.Sprintf("%s|%d|%d", departure.TrainNumber, departure.DepartsAt.Unix(), offset)
+			if _, queued := countdownsQueued[key]; queued {
+				continue
+			}
+			countdownsQueued[key] = departsAt
+
+			parameters := countdownParameters(departure, offset, settings.Languages)
+			announcement, err := announcementManager.QueueAnnouncement(TypeCountdown, priority, parameters, now)
+			if err != nil {
+				log.Printf("Error queuing countdown for train %s: %v", departure.TrainNumber, err)
+			} else {
+				log.Printf("⏱️  Countdown queued: train %s departs in %d minutes (ID: %s)", departure.TrainNumber, offset, announcement.ID)
+			}
+		}
+	}
+
+	// Forget countdowns for trains that have left
+	for key, departsAt := range countdownsQueued {
+		if now.Sub(departsAt) > time.Hour {
+			delete(countdownsQueued, key)
+		}
+	}
+}
+
+// startCountdownMonitor checks for due countdowns every 15 seconds
+func startCountdownMonitor() {
+	go func() {
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			queueDueCountdowns()
+		}
+	}()
+}
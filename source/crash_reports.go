@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A panic is caught rather than left to end the process. A web or API
+// request that panics gets a 500, and a goroutine started through the
+// wrappers below is recovered. Either way a crash report with the stack is
+// written to logs/crashes, the panic is logged and the crash notification
+// sent. With upload_crash_reports on in the fleet section, reports are also
+// sent to the fleet server after the next status report.
+//
+// The announcement queue, playback and the trigger goroutines run under
+// these wrappers, so a bug hit by one feed or one announcement doesn't stop
+// the rest. A long-lived loop is started again after a panic; an
+// announcement that panics while playing is failed so the queue moves on.
+
+const (
+	maxCrashReports   = 50 // The oldest are deleted past this
+	crashRestartDelay = 5 * time.Second
+)
+
+// CrashRequest is the web request being handled when a panic happened
+type CrashRequest struct {
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	RequestID string `json:"request_id,omitempty"`
+	ClientIP  string `json:"client_ip,omitempty"`
+}
+
+// CrashReport is one recovered panic
+type CrashReport struct {
+	ID       string        `json:"id"`
+	Time     time.Time     `json:"time"`
+	Source   string        `json:"source"` // http, or the goroutine that panicked
+	Panic    string        `json:"panic"`
+	Stack    string        `json:"stack,omitempty"`
+	Version  string        `json:"version"`
+	Platform string        `json:"platform"`
+	Request  *CrashRequest `json:"request,omitempty"`
+	Uploaded bool          `json:"uploaded"` // Sent to the fleet server
+}
+
+// Serializes writing, pruning and marking reports uploaded
+var crashMutex sync.Mutex
+
+func crashReportDir() string {
+	return filepath.Join(app.Config.LogDir, "crashes")
+}
+
+func crashReportPath(id string) string {
+	return filepath.Join(crashReportDir(), "crash-"+id+".json")
+}
+
+// recordCrash writes a crash report for a recovered panic. It is called
+// from the deferred function that recovered, so the stack still shows where
+// the panic happened.
+func recordCrash(source string, value interface{}, request *CrashRequest) *CrashReport {
+	now := time.Now()
+	report := &CrashReport{
+		ID:       strings.Replace(now.Format("20060102-150405.000000"), ".", "-", 1),
+		Time:     now,
+		Source:   source,
+		Panic:    fmt.Sprint(value),
+		Stack:    string(debug.Stack()),
+		Version:  appVersion,
+		Platform: runtime.GOOS + "/" + runtime.GOARCH,
+		Request:  request,
+	}
+	log.Printf("❌ Panic in %s: %s (crash report %s)\n%s", source, report.Panic, report.ID, report.Stack)
+
+	crashMutex.Lock()
+	if err := saveCrashReportLocked(report); err != nil {
+		log.Printf("Warning: failed to save crash report %s: %v", report.ID, err)
+	}
+	pruneCrashReportsLocked()
+	crashMutex.Unlock()
+
+	notify(NotifyCrash, source, "critical", "Recovered from a crash",
+		fmt.Sprintf("A panic in %s was recovered: %s. Crash report %s has the details.", source, report.Panic, report.ID))
+	return report
+}
+
+// saveCrashReportLocked writes a report; the caller holds crashMutex
+func saveCrashReportLocked(report *CrashReport) error {
+	if err := os.MkdirAll(crashReportDir(), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(crashReportPath(report.ID), data, 0644)
+}
+
+// pruneCrashReportsLocked deletes the oldest reports past maxCrashReports
+func pruneCrashReportsLocked() {
+	names, _ := filepath.Glob(filepath.Join(crashReportDir(), "crash-*.json"))
+	if len(names) <= maxCrashReports {
+		return
+	}
+	// The IDs start with the time, so names sort oldest first
+	sort.Strings(names)
+	for _, name := range names[:len(names)-maxCrashReports] {
+		os.Remove(name)
+	}
+}
+
+// listCrashReports returns the saved reports, newest first
+func listCrashReports() []*CrashReport {
+	names, _ := filepath.Glob(filepath.Join(crashReportDir(), "crash-*.json"))
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	reports := []*CrashReport{}
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		var report CrashReport
+		if json.Unmarshal(data, &report) == nil {
+			reports = append(reports, &report)
+		}
+	}
+	return reports
+}
+
+// loadCrashReport reads one report by ID
+func loadCrashReport(id string) (*CrashReport, error) {
+	if id == "" || id != filepath.Base(id) || strings.ContainsAny(id, `\/`) {
+		return nil, fmt.Errorf("invalid crash report ID")
+	}
+	data, err := os.ReadFile(crashReportPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("crash report %s not found", id)
+	}
+	var report CrashReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("crash report %s is unreadable: %v", id, err)
+	}
+	return &report, nil
+}
+
+// crashRecoveryMiddleware replaces gin's own recovery: a handler that
+// panics gets a 500 naming the crash report instead of a bare stack on
+// stderr
+func crashRecoveryMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+			// The client went away mid-response; nothing crashed
+			if value == http.ErrAbortHandler {
+				panic(value)
+			}
+			report := recordCrash("http", value, &CrashRequest{
+				Method:    c.Request.Method,
+				Path:      c.Request.URL.Path,
+				RequestID: getRequestID(c),
+				ClientIP:  c.ClientIP(),
+			})
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			message := "Internal error; crash report " + report.ID + " was saved"
+			if strings.HasPrefix(c.Request.URL.Path, "/api/") {
+				respondError(c, http.StatusInternalServerError, ErrCodeInternal, message)
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"success": false, "error": message})
+		}()
+		c.Next()
+	}
+}
+
+// runRecovered runs fn and reports whether it panicked
+func runRecovered(source string, fn func()) (panicked bool) {
+	defer func() {
+		if value := recover(); value != nil {
+			recordCrash(source, value, nil)
+			panicked = true
+		}
+	}()
+	fn()
+	return false
+}
+
+// goRecovered runs fn in its own goroutine, recording a panic instead of
+// ending the process
+func goRecovered(source string, fn func()) {
+	go runRecovered(source, fn)
+}
+
+// superviseGoroutine runs a long-lived loop in its own goroutine, starting
+// it again after a panic. A loop that returns is not restarted.
+func superviseGoroutine(source string, loop func()) {
+	go func() {
+		for runRecovered(source, loop) {
+			log.Printf("🔁 Restarting %s in %s after a panic", source, crashRestartDelay)
+			time.Sleep(crashRestartDelay)
+		}
+	}()
+}
+
+// uploadCrashReports sends the reports not yet uploaded to the fleet server
+func uploadCrashReports(settings FleetSettings) {
+	if !settings.UploadCrashReports {
+		return
+	}
+	crashMutex.Lock()
+	reports := listCrashReports()
+	crashMutex.Unlock()
+	// Oldest first, so the server sees them in order
+	for i := len(reports) - 1; i >= 0; i-- {
+		report := reports[i]
+		if report.Uploaded {
+			continue
+		}
+		if err := postCrashReport(settings, report); err != nil {
+			log.Printf("Crash report upload failed: %v", err)
+			return
+		}
+		report.Uploaded = true
+		crashMutex.Lock()
+		// Unless it was pruned while uploading
+		if _, err := os.Stat(crashReportPath(report.ID)); err == nil {
+			if err := saveCrashReportLocked(report); err != nil {
+				log.Printf("Warning: failed to mark crash report %s uploaded: %v", report.ID, err)
+			}
+		}
+		crashMutex.Unlock()
+	}
+}
+
+// postCrashReport sends one report to {server_url}/api/v1/devices/{device_id}/crashes
+func postCrashReport(settings FleetSettings, report *CrashReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	endpoint := strings.TrimRight(settings.ServerURL, "/") + "/api/v1/devices/" + url.PathEscape(settings.DeviceID) + "/crashes"
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tarr-annunciator/"+appVersion)
+	if settings.DeviceToken != "" {
+		req.Header.Set("Authorization", "Bearer "+settings.DeviceToken)
+	}
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("fleet server returned HTTP %d for crash report %s", resp.StatusCode, report.ID)
+	}
+	return nil
+}
+
+// Handlers
+
+func listCrashReportsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "crashes": crashSummaries(listCrashReports())})
+}
+
+func getCrashReportHandler(c *gin.Context) {
+	report, err := loadCrashReport(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "crash": report})
+}
+
+func apiListCrashReportsHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	respondOK(c, gin.H{"crashes": crashSummaries(listCrashReports())})
+}
+
+func apiGetCrashReportHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	report, err := loadCrashReport(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	}
+	respondOK(c, report)
+}
+
+// crashSummaries drops the stacks for listing
+func crashSummaries(reports []*CrashReport) []*CrashReport {
+	for _, report := range reports {
+		report.Stack = ""
+	}
+	return reports
+}
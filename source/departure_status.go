@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Departure states set through the API
+const (
+	DepartureOnTime    = "on_time"
+	DepartureDelayed   = "delayed"
+	DepartureCancelled = "cancelled"
+)
+
+// Re-announce a disruption this often unless the request says otherwise
+const defaultReannounceMinutes = 10
+
+// DepartureStatus records a delay or cancellation until it is resolved
+type DepartureStatus struct {
+	TrainNumber       string     `json:"train_number"`
+	Status            string     `json:"status"`
+	DelayMinutes      int        `json:"delay_minutes,omitempty"`
+	Reason            string     `json:"reason,omitempty"`
+	Languages         []string   `json:"languages,omitempty"`
+	ReannounceMinutes int        `json:"reannounce_minutes"` // 0 announces once
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	LastAnnouncedAt   *time.Time `json:"last_announced_at,omitempty"`
+}
+
+var (
+	departureStatuses      = make(map[string]*DepartureStatus)
+	departureStatusesMutex sync.Mutex
+)
+
+// loadDepartureStatuses restores open delays and cancellations after a restart
+func loadDepartureStatuses() error {
+	filePath, _ := jsonFilePath("departure_status")
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read departure_status.json: %v", err)
+	}
+
+	var statuses []*DepartureStatus
+	if err := json.Unmarshal(data, &statuses); err != nil {
+		return fmt.Errorf("failed to parse departure_status.json: %v", err)
+	}
+
+	departureStatusesMutex.Lock()
+	defer departureStatusesMutex.Unlock()
+	for _, status := range statuses {
+		departureStatuses[status.TrainNumber] = status
+	}
+	if len(statuses) > 0 {
+		log.Printf("✓ Restored %d open departure delays/cancellations", len(statuses))
+	}
+	return nil
+}
+
+// saveDepartureStatusesLocked writes the open statuses; the caller holds the mutex
+func saveDepartureStatusesLocked() error {
+	return saveJSON("departure_status", departureStatusListLocked())
+}
+
+func departureStatusListLocked() []DepartureStatus {
+	list := make([]DepartureStatus, 0, len(departureStatuses))
+	for _, status := range departureStatuses {
+		list = append(list, *status)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+// currentDepartureStatuses returns a copy of the open statuses keyed by train
+func currentDepartureStatuses() map[string]DepartureStatus {
+	departureStatusesMutex.Lock()
+	defer departureStatusesMutex.Unlock()
+	statuses := make(map[string]DepartureStatus, len(departureStatuses))
+	for train, status := range departureStatuses {
+		statuses[train] = *status
+	}
+	return statuses
+}
+
+// queueDepartureStatusAnnouncement composes the delay or cancellation announcement
+func queueDepartureStatusAnnouncement(status DepartureStatus) (*Announcement, error) {
+	if announcementManager == nil {
+		return nil, fmt.Errorf("announcement manager not initialized")
+	}
+
+	announcementType := TypeDelay
+	if status.Status == DepartureCancelled {
+		announcementType = TypeCancellation
+	}
+	parameters := map[string]interface{}{
+		"train_number": status.TrainNumber,
+		"reason":       status.Reason,
+	}
+	if status.Status == DepartureDelayed {
+		parameters["delay_minutes"] = strconv.Itoa(status.DelayMinutes)
+	}
+	if len(status.Languages) > 0 {
+		parameters["languages"] = status.Languages
+	}
+	return announcementManager.QueueAnnouncement(announcementType, PriorityHigh, parameters, time.Now())
+}
+
+// reannounceDepartureStatuses repeats each open delay or cancellation once its
+// interval has passed
+func reannounceDepartureStatuses() {
+	departureStatusesMutex.Lock()
+	var due []DepartureStatus
+	now := time.Now()
+	for _, status := range departureStatuses {
+		if status.ReannounceMinutes <= 0 || status.LastAnnouncedAt == nil {
+			continue
+		}
+		if now.Sub(*status.LastAnnouncedAt) >= time.Duration(status.ReannounceMinutes)*time.Minute {
+			announcedAt := now
+			status.LastAnnouncedAt = &announcedAt
+			due = append(due, *status)
+		}
+	}
+	if len(due) > 0 {
+		if err := saveDepartureStatusesLocked(); err != nil {
+			log.Printf("Error saving departure status: %v", err)
+		}
+	}
+	departureStatusesMutex.Unlock()
+
+	for _, status := range due {
+		if _, err := queueDepartureStatusAnnouncement(status); err != nil {
+			log.Printf("Error re-announcing %s train %s: %v", status.Status, status.TrainNumber, err)
+		} else {
+			log.Printf("🔁 Re-announced %s train %s", status.Status, status.TrainNumber)
+		}
+	}
+}
+
+// startDepartureStatusMonitor checks for due re-announcements every 30 seconds
+func startDepartureStatusMonitor() {
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			reannounceDepartureStatuses()
+		}
+	}()
+}
+
+// setDepartureStatus records a delay or cancellation and announces it now
+func setDepartureStatus(c *gin.Context, statusName string) {
+	if !requireAnnouncementManager(c) {
+		return
+	}
+
+	trainNumber := c.Param("train_number")
+	data, ok := bindRequestData(c, "minutes", "reason", "reannounce_minutes", "languages")
+	if !ok {
+		return
+	}
+
+	var details []FieldError
+	if _, known := catalogNames("trains")[trainNumber]; !known {
+		details = append(details, FieldError{Field: "train_number", Message: "unknown train '" + trainNumber + "'"})
+	}
+
+	minutes, err := intField(data, "minutes", 0)
+	if err != nil {
+		details = append(details, FieldError{Field: "minutes", Message: err.Error()})
+	} else if statusName == DepartureDelayed {
+		// Each delay length is spoken from mp3/delay/<minutes>.mp3
+		if minutes <= 0 {
+			details = append(details, FieldError{Field: "minutes", Message: "is required and must be positive"})
+		} else if !fileExists(delayClipPath(minutes)) {
+			details = append(details, FieldError{Field: "minutes", Message: fmt.Sprintf("no recording for a %d minute delay", minutes)})
+		}
+	}
+
+	reannounce, err := intField(data, "reannounce_minutes", defaultReannounceMinutes)
+	if err != nil || reannounce < 0 {
+		details = append(details, FieldError{Field: "reannounce_minutes", Message: "must be a whole number of minutes, 0 to announce once"})
+	}
+
+	reason, _ := data["reason"].(string)
+	if reason != "" {
+		if _, known := catalogNames("service-change-reasons")[reason]; !known {
+			details = append(details, FieldError{Field: "reason", Message: "unknown reason '" + reason + "'"})
+		}
+	}
+
+	languages := languageList(data["languages"])
+	if err := validateLanguages(languages); err != nil {
+		details = append(details, FieldError{Field: "languages", Message: err.Error()})
+	}
+
+	if len(details) > 0 {
+		respondValidationError(c, "Invalid departure status request", details...)
+		return
+	}
+
+	now := time.Now()
+	departureStatusesMutex.Lock()
+	status, exists := departureStatuses[trainNumber]
+	if !exists {
+		status = &DepartureStatus{TrainNumber: trainNumber, CreatedAt: now}
+		departureStatuses[trainNumber] = status
+	}
+	status.Status = statusName
+	status.DelayMinutes = 0
+	if statusName == DepartureDelayed {
+		status.DelayMinutes = minutes
+	}
+	status.Reason = reason
+	status.Languages = languages
+	status.ReannounceMinutes = reannounce
+	status.UpdatedAt = now
+	status.LastAnnouncedAt = &now
+	saveErr := saveDepartureStatusesLocked()
+	snapshot := *status
+	departureStatusesMutex.Unlock()
+
+	if saveErr != nil {
+		log.Printf("Error saving departure status: %v", saveErr)
+	}
+	queueEvents.publish()
+
+	announcement, err := queueDepartureStatusAnnouncement(snapshot)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Status recorded but the announcement failed: %v", err))
+		return
+	}
+
+	log.Printf("Train %s marked %s", trainNumber, statusName)
+	respondSuccess(c, http.StatusAccepted, fmt.Sprintf("Train %s marked %s", trainNumber, statusName), gin.H{
+		"departure_status": snapshot,
+		"announcement_id":  announcement.ID,
+	})
+}
+
+// intField reads an optional whole number sent as JSON or form data
+func intField(data map[string]interface{}, field string, defaultValue int) (int, error) {
+	switch value := data[field].(type) {
+	case nil:
+		return defaultValue, nil
+	case float64:
+		if value != float64(int(value)) {
+			return 0, fmt.Errorf("must be a whole number")
+		}
+		return int(value), nil
+	case string:
+		if value == "" {
+			return defaultValue, nil
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("must be a whole number")
+		}
+		return parsed, nil
+	default:
+		return 0, fmt.Errorf("must be a whole number")
+	}
+}
+
+// delayClipPath is the recording for "delayed by <minutes> minutes"
+func delayClipPath(minutes int) string {
+	settings := getLocaleSettings()
+	path, _ := resolveAudioPath(fmt.Sprintf("%s/%d.mp3", settings.segmentDir(settings.DefaultLocale, "delay"), minutes))
+	return path
+}
+
+// apiDelayDepartureHandler marks a train delayed by a number of minutes
+func apiDelayDepartureHandler(c *gin.Context) {
+	setDepartureStatus(c, DepartureDelayed)
+}
+
+// apiCancelDepartureHandler marks a train cancelled
+func apiCancelDepartureHandler(c *gin.Context) {
+	setDepartureStatus(c, DepartureCancelled)
+}
+
+// apiResolveDepartureHandler returns a train to on time and stops re-announcing
+func apiResolveDepartureHandler(c *gin.Context) {
+	trainNumber := c.Param("train_number")
+
+	departureStatusesMutex.Lock()
+	_, exists := departureStatuses[trainNumber]
+	delete(departureStatuses, trainNumber)
+	var saveErr error
+	if exists {
+		saveErr = saveDepartureStatusesLocked()
+	}
+	departureStatusesMutex.Unlock()
+
+	if !exists {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Train "+trainNumber+" has no open delay or cancellation")
+		return
+	}
+	if saveErr != nil {
+		log.Printf("Error saving departure status: %v", saveErr)
+	}
+	queueEvents.publish()
+
+	log.Printf("Train %s back on time", trainNumber)
+	respondSuccess(c, http.StatusOK, "Train "+trainNumber+" is back on time", gin.H{
+		"train_number": trainNumber,
+		"status":       DepartureOnTime,
+	})
+}
+
+// apiListDepartureStatusHandler lists open delays and cancellations
+func apiListDepartureStatusHandler(c *gin.Context) {
+	departureStatusesMutex.Lock()
+	list := departureStatusListLocked()
+	departureStatusesMutex.Unlock()
+	respondOK(c, gin.H{"departure_statuses": list})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// The binary carries its HTML templates and default JSON configuration, so it
+// runs from systemd or any working directory without templates/ beside it.
+// Files on disk still win: a template in the templates directory replaces the
+// embedded one of the same name, and the embedded JSON only fills in files
+// missing from the configuration directory. Announcement audio isn't embedded.
+// `make assets` copies data/templates and data/json into assets/ before each
+// build; see assets/README.md.
+
+//go:embed all:assets
+var embeddedAssets embed.FS
+
+// Never seeded from the embedded defaults, whatever a build picked up
+var unseededJSON = map[string]bool{
+	"admin_config.json": true,
+}
+
+// loadHTMLTemplates parses the embedded templates, then the ones in dir,
+// which replace embedded templates of the same name
+func loadHTMLTemplates(dir string, funcs template.FuncMap) (*template.Template, error) {
+	templates := template.New("").Funcs(funcs)
+	embedded, _ := fs.Glob(embeddedAssets, "assets/templates/*.html")
+	if len(embedded) > 0 {
+		if _, err := templates.ParseFS(embeddedAssets, embedded...); err != nil {
+			return nil, fmt.Errorf("failed to parse embedded templates: %v", err)
+		}
+	}
+
+	var onDisk []string
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+			onDisk = append(onDisk, match)
+		}
+	}
+	if len(onDisk) > 0 {
+		if _, err := templates.ParseFiles(onDisk...); err != nil {
+			return nil, fmt.Errorf("failed to parse templates in %s: %v", dir, err)
+		}
+	}
+
+	switch {
+	case len(embedded) == 0 && len(onDisk) == 0:
+		return nil, fmt.Errorf("no HTML templates in %s and none built in", dir)
+	case len(onDisk) == 0:
+		log.Printf("✓ Using the %d built-in HTML templates", len(embedded))
+	case len(embedded) > 0:
+		log.Printf("✓ Using %d HTML templates from %s over the built-in ones", len(onDisk), dir)
+	}
+	return templates, nil
+}
+
+// seedEmbeddedJSON copies the built-in default JSON files missing from the
+// configuration directory
+func seedEmbeddedJSON(jsonDir string) error {
+	entries, err := fs.ReadDir(embeddedAssets, "assets/json")
+	if err != nil || len(entries) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(jsonDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", jsonDir, err)
+	}
+
+	seeded := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".json" || unseededJSON[name] {
+			continue
+		}
+		target := filepath.Join(jsonDir, name)
+		if fileExists(target) {
+			continue
+		}
+		data, err := embeddedAssets.ReadFile(path.Join("assets/json", name))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", target, err)
+		}
+		seeded++
+	}
+	if seeded > 0 {
+		log.Printf("✓ Seeded %d built-in default config files into %s", seeded, jsonDir)
+	}
+	return nil
+}
@@ -0,0 +1,608 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Event scripts run a ceremony or event-day timeline: announcements and
+// volume or zone changes at times relative to the moment the event starts,
+// where fixed cron times don't work because nobody knows exactly when the
+// parade leaves. A script is armed ahead of time, which checks every step
+// and its audio, and started with one call when the event begins. Only one
+// script runs at a time. Scripts are kept in json/event_scripts.json; the run
+// itself is held in memory and lost on restart.
+
+// EventScript is a named timeline
+type EventScript struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Steps      []EventScriptStep `json:"steps"`
+	KeepVolume bool              `json:"keep_volume,omitempty"` // Leave the last volume set instead of restoring it at the end
+}
+
+// EventScriptStep is one entry in the timeline. Action "announce" queues an
+// announcement, "volume" sets the playback volume and "zones" sets the zones
+// the script's later announcements play in (empty for the usual routing).
+type EventScriptStep struct {
+	At           string                   `json:"at"` // From the start: "90s", "5m", "1h30m", "2:30" or "1:02:00"
+	Action       string                   `json:"action"`
+	Note         string                   `json:"note,omitempty"`
+	Announcement *TriggerRuleAnnouncement `json:"announcement,omitempty"`
+	Priority     string                   `json:"priority,omitempty"` // Default: high
+	Volume       *float64                 `json:"volume,omitempty"`   // 0.0 to 1.0
+	Zones        []string                 `json:"zones,omitempty"`
+}
+
+// eventStepResult is what happened to a step in the current run
+type eventStepResult struct {
+	Index          int        `json:"index"`
+	At             string     `json:"at"`
+	Action         string     `json:"action"`
+	Note           string     `json:"note,omitempty"`
+	Due            *time.Time `json:"due,omitempty"` // Set once the script starts
+	Done           *time.Time `json:"done,omitempty"`
+	OK             bool       `json:"ok"`
+	Error          string     `json:"error,omitempty"`
+	AnnouncementID string     `json:"announcement_id,omitempty"`
+}
+
+// eventScriptRun is the armed or running script
+type eventScriptRun struct {
+	Script     string            `json:"script"`
+	Name       string            `json:"name"`
+	State      string            `json:"state"` // armed, running, finished or aborted
+	ArmedBy    string            `json:"armed_by"`
+	ArmedAt    time.Time         `json:"armed_at"`
+	StartedBy  string            `json:"started_by,omitempty"`
+	StartedAt  *time.Time        `json:"started_at,omitempty"`
+	EndedAt    *time.Time        `json:"ended_at,omitempty"`
+	Zones      []string          `json:"zones,omitempty"` // Set by the last zones step
+	Steps      []eventStepResult `json:"steps"`
+	NextStep   *eventStepResult  `json:"next_step,omitempty"`
+	EndsAt     *time.Time        `json:"ends_at,omitempty"` // When the last step is due
+	keepVolume bool
+	offsets    []time.Duration
+	script     EventScript
+	volume     *float64 // Volume before the first volume step, to restore
+	abort      chan struct{}
+}
+
+var eventScripts = struct {
+	sync.Mutex
+	run *eventScriptRun
+}{}
+
+// Serialises changes to event_scripts.json
+var eventScriptsFileMutex sync.Mutex
+
+// loadEventScripts reads event_scripts.json
+func loadEventScripts() ([]EventScript, error) {
+	filePath, _ := jsonFilePath("event_scripts")
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return []EventScript{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event_scripts.json: %v", err)
+	}
+	var wrapper struct {
+		Scripts []EventScript `json:"scripts"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return nil, fmt.Errorf("failed to parse event_scripts.json: %v", err)
+	}
+	if wrapper.Scripts == nil {
+		wrapper.Scripts = []EventScript{}
+	}
+	return wrapper.Scripts, nil
+}
+
+// findEventScript returns the script with an ID
+func findEventScript(id string) (EventScript, bool, error) {
+	scripts, err := loadEventScripts()
+	if err != nil {
+		return EventScript{}, false, err
+	}
+	for _, script := range scripts {
+		if script.ID == id {
+			return script, true, nil
+		}
+	}
+	return EventScript{}, false, nil
+}
+
+// parseScriptOffset reads a step's time from the start: a Go duration such as
+// "90s" or "1h30m", or a clock-style "m:ss" or "h:mm:ss"
+func parseScriptOffset(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "0" {
+		return 0, nil
+	}
+	if !strings.Contains(value, ":") {
+		offset, err := time.ParseDuration(value)
+		if err != nil || offset < 0 {
+			return 0, fmt.Errorf("must be a duration such as 90s or 5m, or m:ss or h:mm:ss")
+		}
+		return offset, nil
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("must be m:ss or h:mm:ss")
+	}
+	var offset time.Duration
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 || (i > 0 && number > 59) {
+			return 0, fmt.Errorf("must be m:ss or h:mm:ss")
+		}
+		offset = offset*60 + time.Duration(number)
+	}
+	return offset * time.Second, nil
+}
+
+// validate checks a script's ID, name and steps
+func (s EventScript) validate() []FieldError {
+	var details []FieldError
+	if !catalogIDPattern.MatchString(s.ID) {
+		details = append(details, FieldError{Field: "id", Message: "must be 1-64 letters, digits, '-' or '_'"})
+	}
+	if strings.TrimSpace(s.Name) == "" {
+		details = append(details, FieldError{Field: "name", Message: "is required"})
+	}
+	if len(s.Steps) == 0 {
+		details = append(details, FieldError{Field: "steps", Message: "must list at least one step"})
+	}
+	for i, step := range s.Steps {
+		prefix := fmt.Sprintf("steps[%d].", i)
+		if _, err := parseScriptOffset(step.At); err != nil {
+			details = append(details, FieldError{Field: prefix + "at", Message: err.Error()})
+		}
+		switch step.Action {
+		case "announce":
+			if step.Announcement == nil {
+				details = append(details, FieldError{Field: prefix + "announcement", Message: "is required for announce steps"})
+				continue
+			}
+			details = append(details, validateTriggerAnnouncement(prefix, *step.Announcement, step.Priority)...)
+		case "volume":
+			if step.Volume == nil || *step.Volume < 0 || *step.Volume > 1 {
+				details = append(details, FieldError{Field: prefix + "volume", Message: "must be between 0.0 and 1.0"})
+			}
+		case "zones":
+			layout := getTrackLayout()
+			for j, zone := range step.Zones {
+				if _, ok := layout.findZone(zone); !ok {
+					details = append(details, FieldError{Field: fmt.Sprintf("%szones[%d]", prefix, j), Message: "unknown zone '" + zone + "'"})
+				}
+			}
+		default:
+			details = append(details, FieldError{Field: prefix + "action", Message: "must be announce, volume or zones"})
+		}
+	}
+	return details
+}
+
+// stepParameters builds an announce step's parameters, routing it to the
+// script's zones unless it names its own zones or track
+func stepParameters(script EventScript, step EventScriptStep, zones []string) map[string]interface{} {
+	parameters := map[string]interface{}{
+		"trigger_source": "EVENT_SCRIPT:" + script.ID,
+	}
+	for key, value := range step.Announcement.Parameters {
+		parameters[key] = value
+	}
+	_, hasZones := parameters["zones"]
+	_, hasZone := parameters["zone"]
+	_, hasTrack := parameters["track_number"]
+	if len(zones) > 0 && !hasZones && !hasZone && !hasTrack {
+		parameters["zones"] = append([]string{}, zones...)
+	}
+	return parameters
+}
+
+// checkEventScriptAudio builds the audio of every announce step, so a missing
+// clip is found when the script is armed rather than in front of the crowd
+func checkEventScriptAudio(script EventScript) []FieldError {
+	if announcementManager == nil {
+		return []FieldError{{Field: "steps", Message: "the announcement queue is not available"}}
+	}
+	var details []FieldError
+	for i, step := range script.Steps {
+		if step.Action != "announce" {
+			continue
+		}
+		announcementType := AnnouncementType(step.Announcement.Type)
+		parameters := stepParameters(script, step, nil)
+		if err := validateAnnouncementParameters(announcementType, parameters); err != nil {
+			details = append(details, FieldError{Field: fmt.Sprintf("steps[%d].announcement", i), Message: err.Error()})
+			continue
+		}
+		if _, err := announcementManager.buildAudioSequence(announcementType, parameters); err != nil {
+			details = append(details, FieldError{Field: fmt.Sprintf("steps[%d].announcement", i), Message: err.Error()})
+		}
+	}
+	return details
+}
+
+// armEventScript checks a script and holds it ready to start. Caller must
+// hold eventScripts.
+func armEventScript(script EventScript, operator string) ([]FieldError, error) {
+	if run := eventScripts.run; run != nil && run.State == "running" {
+		return nil, fmt.Errorf("event script '%s' is running; abort it first", run.Script)
+	}
+	details := append(script.validate(), checkEventScriptAudio(script)...)
+	if len(details) > 0 {
+		return details, nil
+	}
+
+	// Steps run in time order; steps at the same time keep their order
+	order := make([]int, len(script.Steps))
+	for i := range order {
+		order[i] = i
+	}
+	offsets := make([]time.Duration, len(script.Steps))
+	for i, step := range script.Steps {
+		offsets[i], _ = parseScriptOffset(step.At)
+	}
+	sort.SliceStable(order, func(a, b int) bool { return offsets[order[a]] < offsets[order[b]] })
+
+	run := &eventScriptRun{
+		Script:     script.ID,
+		Name:       script.Name,
+		State:      "armed",
+		ArmedBy:    operator,
+		ArmedAt:    time.Now(),
+		keepVolume: script.KeepVolume,
+		script:     EventScript{ID: script.ID, Name: script.Name, KeepVolume: script.KeepVolume},
+		abort:      make(chan struct{}),
+	}
+	for _, index := range order {
+		step := script.Steps[index]
+		run.script.Steps = append(run.script.Steps, step)
+		run.offsets = append(run.offsets, offsets[index])
+		run.Steps = append(run.Steps, eventStepResult{Index: index, At: step.At, Action: step.Action, Note: step.Note})
+	}
+	eventScripts.run = run
+	log.Printf("🎬 Event script '%s' armed by %s (%d steps)", script.ID, operator, len(script.Steps))
+	return nil, nil
+}
+
+// startEventScript starts the armed script now. Caller must hold eventScripts.
+func startEventScript(operator string) error {
+	run := eventScripts.run
+	if run == nil || run.State != "armed" {
+		return fmt.Errorf("no event script is armed")
+	}
+	now := time.Now()
+	run.State = "running"
+	run.StartedBy = operator
+	run.StartedAt = &now
+	for i := range run.Steps {
+		due := now.Add(run.offsets[i])
+		run.Steps[i].Due = &due
+	}
+	endsAt := now.Add(run.offsets[len(run.offsets)-1])
+	run.EndsAt = &endsAt
+	log.Printf("🎬 Event script '%s' started by %s", run.Script, operator)
+	go runEventScript(run)
+	return nil
+}
+
+// runEventScript works through the timeline until the last step or an abort
+func runEventScript(run *eventScriptRun) {
+	for i := range run.script.Steps {
+		eventScripts.Lock()
+		due := *run.Steps[i].Due
+		eventScripts.Unlock()
+
+		timer := time.NewTimer(time.Until(due))
+		select {
+		case <-run.abort:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		eventScripts.Lock()
+		if run.State != "running" {
+			eventScripts.Unlock()
+			return
+		}
+		announcementID, err := run.execute(run.script.Steps[i])
+		done := time.Now()
+		result := &run.Steps[i]
+		result.Done = &done
+		result.OK = err == nil
+		result.AnnouncementID = announcementID
+		if err != nil {
+			result.Error = err.Error()
+			log.Printf("⚠️  Event script '%s' step %d (%s at %s) failed: %v", run.Script, result.Index, result.Action, result.At, err)
+		}
+		eventScripts.Unlock()
+	}
+
+	eventScripts.Lock()
+	defer eventScripts.Unlock()
+	if run.State == "running" {
+		run.finish("finished")
+	}
+}
+
+// execute carries out a step. Caller must hold eventScripts.
+func (run *eventScriptRun) execute(step EventScriptStep) (string, error) {
+	switch step.Action {
+	case "announce":
+		if announcementManager == nil {
+			return "", fmt.Errorf("announcement manager not available")
+		}
+		priority := step.Priority
+		if priority == "" {
+			priority = "high"
+		}
+		parameters := stepParameters(run.script, step, run.Zones)
+		announcement, err := announcementManager.QueueAnnouncement(AnnouncementType(step.Announcement.Type), ParsePriority(priority), parameters, time.Now())
+		if err != nil {
+			return "", err
+		}
+		log.Printf("🎬 Event script '%s' queued %s announcement %s", run.Script, step.Announcement.Type, announcement.ID)
+		return announcement.ID, nil
+	case "volume":
+		if run.volume == nil {
+			previous := currentVolume()
+			run.volume = &previous
+		}
+		setCurrentVolume(*step.Volume)
+		log.Printf("🎬 Event script '%s' set the volume to %d%%", run.Script, int(*step.Volume*100))
+	case "zones":
+		run.Zones = append([]string{}, step.Zones...)
+		log.Printf("🎬 Event script '%s' now announces in zones %v", run.Script, run.Zones)
+	}
+	return "", nil
+}
+
+// finish ends the run, restoring the volume unless the script keeps it.
+// Caller must hold eventScripts.
+func (run *eventScriptRun) finish(state string) {
+	now := time.Now()
+	run.State = state
+	run.EndedAt = &now
+	if run.volume != nil && !run.keepVolume {
+		setCurrentVolume(*run.volume)
+		log.Printf("🎬 Event script '%s' restored the volume to %d%%", run.Script, int(*run.volume*100))
+	}
+	log.Printf("🎬 Event script '%s' %s", run.Script, state)
+}
+
+// abortEventScript disarms an armed script or stops a running one, cancelling
+// its announcements still waiting in the queue. Caller must hold eventScripts.
+func abortEventScript(operator string) error {
+	run := eventScripts.run
+	if run == nil || (run.State != "armed" && run.State != "running") {
+		return fmt.Errorf("no event script is armed or running")
+	}
+	log.Printf("🎬 Event script '%s' aborted by %s", run.Script, operator)
+	if run.State == "armed" {
+		eventScripts.run = nil
+		return nil
+	}
+	close(run.abort)
+	run.finish("aborted")
+	if announcementManager != nil {
+		for _, step := range run.Steps {
+			if step.AnnouncementID != "" {
+				announcementManager.CancelAnnouncement(step.AnnouncementID)
+			}
+		}
+	}
+	return nil
+}
+
+// eventScriptStatus copies the run for the API. Caller must hold eventScripts.
+func eventScriptStatus() *eventScriptRun {
+	run := eventScripts.run
+	if run == nil {
+		return nil
+	}
+	status := *run
+	status.Steps = append([]eventStepResult{}, run.Steps...)
+	if run.State == "running" {
+		for i := range status.Steps {
+			if status.Steps[i].Done == nil {
+				next := status.Steps[i]
+				status.NextStep = &next
+				break
+			}
+		}
+	}
+	return &status
+}
+
+// Handlers
+
+// apiListEventScriptsHandler lists the scripts and the armed or running one
+func apiListEventScriptsHandler(c *gin.Context) {
+	scripts, err := loadEventScripts()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	eventScripts.Lock()
+	run := eventScriptStatus()
+	eventScripts.Unlock()
+	respondOK(c, gin.H{"scripts": scripts, "count": len(scripts), "run": run})
+}
+
+// apiGetEventScriptHandler returns one script
+func apiGetEventScriptHandler(c *gin.Context) {
+	script, found, err := findEventScript(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("Event script '%s' not found", c.Param("id")))
+		return
+	}
+	respondOK(c, gin.H{"script": script})
+}
+
+// apiPutEventScriptHandler creates or replaces a script
+func apiPutEventScriptHandler(c *gin.Context) {
+	var script EventScript
+	if err := c.ShouldBindJSON(&script); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	script.ID = c.Param("id")
+	if details := script.validate(); len(details) > 0 {
+		respondValidationError(c, "Invalid event script", details...)
+		return
+	}
+
+	eventScriptsFileMutex.Lock()
+	defer eventScriptsFileMutex.Unlock()
+	scripts, err := loadEventScripts()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	replaced := false
+	for i := range scripts {
+		if scripts[i].ID == script.ID {
+			scripts[i] = script
+			replaced = true
+		}
+	}
+	if !replaced {
+		scripts = append(scripts, script)
+	}
+	if err := saveJSON("event_scripts", map[string]interface{}{"scripts": scripts}); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save event scripts: "+err.Error())
+		return
+	}
+	log.Printf("Event script '%s' saved by %s", script.ID, requestOperator(c))
+	respondSuccess(c, http.StatusOK, "Event script '"+script.ID+"' saved", gin.H{"script": script})
+}
+
+// apiDeleteEventScriptHandler removes a script that isn't armed or running
+func apiDeleteEventScriptHandler(c *gin.Context) {
+	id := c.Param("id")
+	eventScripts.Lock()
+	if run := eventScripts.run; run != nil && run.Script == id && (run.State == "armed" || run.State == "running") {
+		eventScripts.Unlock()
+		respondError(c, http.StatusConflict, ErrCodeConflict, "The event script is "+run.State+"; abort it first")
+		return
+	}
+	eventScripts.Unlock()
+
+	eventScriptsFileMutex.Lock()
+	defer eventScriptsFileMutex.Unlock()
+	scripts, err := loadEventScripts()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	kept := scripts[:0]
+	for _, script := range scripts {
+		if script.ID != id {
+			kept = append(kept, script)
+		}
+	}
+	if len(kept) == len(scripts) {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("Event script '%s' not found", id))
+		return
+	}
+	if err := saveJSON("event_scripts", map[string]interface{}{"scripts": kept}); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save event scripts: "+err.Error())
+		return
+	}
+	log.Printf("Event script '%s' deleted by %s", id, requestOperator(c))
+	respondSuccess(c, http.StatusOK, "Event script '"+id+"' deleted", nil)
+}
+
+// apiArmEventScriptHandler checks a script and holds it ready to start
+func apiArmEventScriptHandler(c *gin.Context) {
+	script, found, err := findEventScript(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("Event script '%s' not found", c.Param("id")))
+		return
+	}
+
+	eventScripts.Lock()
+	defer eventScripts.Unlock()
+	details, err := armEventScript(script, requestOperator(c))
+	if err != nil {
+		respondError(c, http.StatusConflict, ErrCodeConflict, err.Error())
+		return
+	}
+	if len(details) > 0 {
+		respondValidationError(c, "Event script can't be armed", details...)
+		return
+	}
+	respondSuccess(c, http.StatusOK, "Event script '"+script.ID+"' armed", gin.H{"run": eventScriptStatus()})
+}
+
+// apiStartEventScriptHandler starts a script now, arming it first unless it
+// already is
+func apiStartEventScriptHandler(c *gin.Context) {
+	id := c.Param("id")
+	operator := requestOperator(c)
+
+	eventScripts.Lock()
+	defer eventScripts.Unlock()
+	if run := eventScripts.run; run == nil || run.State != "armed" || run.Script != id {
+		script, found, err := findEventScript(id)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+			return
+		}
+		if !found {
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("Event script '%s' not found", id))
+			return
+		}
+		details, err := armEventScript(script, operator)
+		if err != nil {
+			respondError(c, http.StatusConflict, ErrCodeConflict, err.Error())
+			return
+		}
+		if len(details) > 0 {
+			respondValidationError(c, "Event script can't be started", details...)
+			return
+		}
+	}
+	if err := startEventScript(operator); err != nil {
+		respondError(c, http.StatusConflict, ErrCodeConflict, err.Error())
+		return
+	}
+	respondSuccess(c, http.StatusOK, "Event script '"+id+"' started", gin.H{"run": eventScriptStatus()})
+}
+
+// apiAbortEventScriptHandler disarms or stops the script if it's the current one
+func apiAbortEventScriptHandler(c *gin.Context) {
+	eventScripts.Lock()
+	defer eventScripts.Unlock()
+	if run := eventScripts.run; run == nil || run.Script != c.Param("id") {
+		respondError(c, http.StatusConflict, ErrCodeConflict, fmt.Sprintf("Event script '%s' is not armed or running", c.Param("id")))
+		return
+	}
+	if err := abortEventScript(requestOperator(c)); err != nil {
+		respondError(c, http.StatusConflict, ErrCodeConflict, err.Error())
+		return
+	}
+	respondSuccess(c, http.StatusOK, "Event script aborted", gin.H{"run": eventScriptStatus()})
+}
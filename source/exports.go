@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The safety committee keeps its own records of what was announced and who
+// changed the schedule and catalogs. These exports turn the announcement
+// history (logs/history/, see announcement_stats.go) and the audit trail
+// into CSV downloads for a date range. The audit trail is assembled from
+// what the annunciator already keeps: the revisions of cron.json and the
+// catalog files, the schedule changes submitted for approval and the audit
+// log (see audit_log.go).
+
+// collectAuditEntries gathers the audit trail between from (inclusive) and
+// until (exclusive), oldest first
+func collectAuditEntries(from, until time.Time) ([]auditEntry, error) {
+	inRange := func(t time.Time) bool {
+		return !t.Before(from) && t.Before(until)
+	}
+	var entries []auditEntry
+
+	revisionsMutex.Lock()
+	for _, file := range revisionTrackedFiles() {
+		revisions, err := loadRevisions(file)
+		if err != nil {
+			revisionsMutex.Unlock()
+			return nil, fmt.Errorf("failed to read the %s revisions: %v", file, err)
+		}
+		for _, revision := range revisions {
+			if inRange(revision.CreatedAt) {
+				entries = append(entries, auditEntry{
+					Time: revision.CreatedAt, Source: "revision", Action: "saved", User: revision.Author,
+					Subject: file, Reference: revision.ID, Detail: revision.Note,
+				})
+			}
+		}
+	}
+	revisionsMutex.Unlock()
+
+	scheduleChangesMutex.Lock()
+	changes, err := loadScheduleChangesLocked()
+	scheduleChangesMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		if inRange(change.SubmittedAt) {
+			entries = append(entries, auditEntry{
+				Time: change.SubmittedAt, Source: "schedule_change", Action: "submitted", User: change.SubmittedBy,
+				Subject: "cron", Reference: change.ID, Detail: change.Comment,
+			})
+		}
+		if change.ReviewedAt != nil && inRange(*change.ReviewedAt) {
+			entries = append(entries, auditEntry{
+				Time: *change.ReviewedAt, Source: "schedule_change", Action: change.Status, User: change.ReviewedBy,
+				Subject: "cron", Reference: change.ID, Detail: change.ReviewNote,
+			})
+		}
+	}
+
+	logged, err := readAuditLog(from, until)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the audit log: %v", err)
+	}
+	entries = append(entries, logged...)
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// csvTime formats an optional time for the exports
+func csvTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+// csvSafeCell stops a spreadsheet from running a cell as a formula. Notes,
+// usernames and errors come from users, so a cell starting with =, +, -, @,
+// a tab or a carriage return is prefixed with a quote.
+func csvSafeCell(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// writeCSVDownload sends rows as a CSV attachment named
+// tarr-<kind>-<from>_<to>.csv
+func writeCSVDownload(c *gin.Context, kind string, first, last time.Time, rows [][]string) {
+	filename := fmt.Sprintf("tarr-%s-%s_%s.csv", kind, first.Format("2006-01-02"), last.Format("2006-01-02"))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	for _, row := range rows {
+		for i, cell := range row {
+			row[i] = csvSafeCell(cell)
+		}
+	}
+	writer := csv.NewWriter(c.Writer)
+	if err := writer.WriteAll(rows); err != nil {
+		log.Printf("Warning: %s export cut short: %v", kind, err)
+	}
+}
+
+// Handlers
+
+// adminExportHistoryHandler downloads the announcement history between
+// ?from= and ?to= (default the last 30 days) as CSV
+func adminExportHistoryHandler(c *gin.Context) {
+	first, last, ok := dateRangeQuery(c, 30)
+	if !ok {
+		return
+	}
+	records, err := readAnnouncementHistory(first, last)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to read announcement history: "+err.Error())
+		return
+	}
+
+	rows := [][]string{{
+		"id", "type", "priority", "status", "created_at", "scheduled_at", "started_at", "completed_at",
+		"duration_seconds", "queue_wait_seconds", "promo_file", "requested_by", "simulated", "requeued", "error",
+	}}
+	for _, record := range records {
+		wait := ""
+		if duration, waited := record.queueWait(); waited {
+			wait = strconv.FormatFloat(roundTo(duration.Seconds(), 1), 'f', -1, 64)
+		}
+		rows = append(rows, []string{
+			record.ID,
+			string(record.Type),
+			strconv.Itoa(int(record.Priority)),
+			string(record.Status),
+			csvTime(&record.CreatedAt),
+			csvTime(&record.ScheduledAt),
+			csvTime(record.StartedAt),
+			csvTime(record.CompletedAt),
+			strconv.FormatFloat(roundTo(float64(record.DurationMs)/1000, 1), 'f', -1, 64),
+			wait,
+			record.File,
+			record.RequestedBy,
+			strconv.FormatBool(record.Simulated),
+			strconv.FormatBool(record.Requeued),
+			record.Error,
+		})
+	}
+	log.Printf("Announcement history %s to %s exported by %s (%d rows)", first.Format("2006-01-02"), last.Format("2006-01-02"), requestOperator(c), len(records))
+	writeCSVDownload(c, "history", first, last, rows)
+}
+
+// adminExportAuditHandler downloads the audit trail between ?from= and ?to=
+// (default the last 30 days) as CSV
+func adminExportAuditHandler(c *gin.Context) {
+	first, last, ok := dateRangeQuery(c, 30)
+	if !ok {
+		return
+	}
+	entries, err := collectAuditEntries(first, last.AddDate(0, 0, 1))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to read the audit trail: "+err.Error())
+		return
+	}
+
+	rows := [][]string{{"time", "source", "action", "user", "subject", "reference", "detail"}}
+	for _, entry := range entries {
+		rows = append(rows, []string{
+			csvTime(&entry.Time), entry.Source, entry.Action, entry.User, entry.Subject, entry.Reference, entry.Detail,
+		})
+	}
+	log.Printf("Audit trail %s to %s exported by %s (%d rows)", first.Format("2006-01-02"), last.Format("2006-01-02"), requestOperator(c), len(entries))
+	writeCSVDownload(c, "audit", first, last, rows)
+}
@@ -0,0 +1,589 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Fleet mode: the annunciator reports its status to a central fleet manager
+// and picks up commands queued for it in the reply. Commands are signed with
+// the fleet server's Ed25519 key, so a compromised network path or a spoofed
+// server cannot make a unit announce, update or restart.
+//
+// Protocol:
+//
+//	POST {server_url}/api/v1/devices/{device_id}/report   (Authorization: Bearer {device_token})
+//	     body: FleetReport
+//	<-   {"commands": [{"payload": "<base64 JSON FleetCommand>", "signature": "<base64 Ed25519 signature of the payload bytes>"}]}
+
+// Fleet command types
+const (
+	FleetCommandAnnounce = "announce"
+	FleetCommandUpdate   = "update"
+	FleetCommandRestart  = "restart"
+)
+
+// Commands without an expiry are refused once they are this old
+const fleetCommandMaxAge = time.Hour
+
+// Command IDs are remembered this long to refuse replays
+const fleetSeenCommandRetention = 7 * 24 * time.Hour
+
+// FleetSettings configures reporting to a central fleet manager
+type FleetSettings struct {
+	Enabled               bool     `json:"enabled"`
+	ServerURL             string   `json:"server_url"`
+	DeviceID              string   `json:"device_id"`         // Defaults to the hostname
+	DeviceToken           string   `json:"device_token"`      // Sent as a bearer token with every report
+	ServerPublicKey       string   `json:"server_public_key"` // Base64 Ed25519 public key that signs commands
+	ReportIntervalSeconds int      `json:"report_interval_seconds"`
+	AllowedCommands       []string `json:"allowed_commands"`
+	UploadCrashReports    bool     `json:"upload_crash_reports"` // Send crash reports to {server_url}/api/v1/devices/{device_id}/crashes
+}
+
+func getDefaultFleetSettings() FleetSettings {
+	return FleetSettings{
+		Enabled:               false,
+		ServerURL:             "https://fleet.example.org",
+		ReportIntervalSeconds: 60,
+		AllowedCommands:       []string{FleetCommandAnnounce, FleetCommandUpdate, FleetCommandRestart},
+	}
+}
+
+// FleetReport is the status sent to the fleet manager
+type FleetReport struct {
+	DeviceID         string               `json:"device_id"`
+	Version          string               `json:"version"`
+	Hostname         string               `json:"hostname"`
+	IP               string               `json:"ip"`
+	Platform         string               `json:"platform"`
+	Arch             string               `json:"arch"`
+	Uptime           string               `json:"uptime"`
+	Health           FleetHealth          `json:"health"`
+	LastAnnouncement *FleetAnnouncement   `json:"last_announcement,omitempty"`
+	CommandResults   []FleetCommandResult `json:"command_results"`
+	ReportedAt       time.Time            `json:"reported_at"`
+}
+
+// FleetHealth summarises whether the unit can make announcements
+type FleetHealth struct {
+	Status         string   `json:"status"` // ok or degraded
+	AudioAvailable bool     `json:"audio_available"`
+	AudioBackend   string   `json:"audio_backend"`
+	Volume         int      `json:"volume"`
+	QueueLength    int      `json:"queue_length"`
+	QueuePaused    bool     `json:"queue_paused"`
+	ScheduledJobs  int      `json:"scheduled_jobs"`
+	MemoryUsage    string   `json:"memory_usage"`
+	DiskFreeMB     uint64   `json:"disk_free_mb"`
+	DiskState      string   `json:"disk_state"` // ok, low or critical
+	CPUTempC       *float64 `json:"cpu_temp_c,omitempty"`
+	Throttled      []string `json:"throttled,omitempty"` // Raspberry Pi throttle flags active now
+}
+
+// FleetAnnouncement is the most recent announcement the unit finished
+type FleetAnnouncement struct {
+	ID          string             `json:"id"`
+	Type        AnnouncementType   `json:"type"`
+	Status      AnnouncementStatus `json:"status"`
+	CompletedAt *time.Time         `json:"completed_at,omitempty"`
+	Error       string             `json:"error,omitempty"`
+}
+
+// SignedFleetCommand is how commands arrive: the payload is only decoded once
+// its signature checks out
+type SignedFleetCommand struct {
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// FleetCommand is a signed instruction from the fleet manager
+type FleetCommand struct {
+	ID        string          `json:"id"`
+	DeviceID  string          `json:"device_id"` // This unit, or "*" for every unit
+	Type      string          `json:"type"`
+	Params    json.RawMessage `json:"params"`
+	IssuedAt  time.Time       `json:"issued_at"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+}
+
+// FleetCommandResult is sent back with the next report
+type FleetCommandResult struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type,omitempty"`
+	Status     string    `json:"status"` // ok, rejected or failed
+	Message    string    `json:"message"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// fleetState survives restarts so results of an update or restart still get
+// reported and commands can't be replayed
+type fleetState struct {
+	SeenCommands   map[string]time.Time `json:"seen_commands"`
+	PendingResults []FleetCommandResult `json:"pending_results"`
+	LastReportAt   *time.Time           `json:"last_report_at,omitempty"`
+	LastError      string               `json:"last_error,omitempty"`
+}
+
+var (
+	fleet      = fleetState{SeenCommands: make(map[string]time.Time)}
+	fleetMutex sync.Mutex
+)
+
+func fleetStatePath() string {
+	return filepath.Join(app.Config.JSONDir, "fleet_state.json")
+}
+
+func loadFleetState() {
+	data, err := os.ReadFile(fleetStatePath())
+	if err != nil {
+		return
+	}
+	fleetMutex.Lock()
+	defer fleetMutex.Unlock()
+	if err := json.Unmarshal(data, &fleet); err != nil {
+		log.Printf("Warning: ignoring unreadable fleet_state.json: %v", err)
+	}
+	if fleet.SeenCommands == nil {
+		fleet.SeenCommands = make(map[string]time.Time)
+	}
+}
+
+// saveFleetStateLocked writes the fleet state; the caller holds fleetMutex
+func saveFleetStateLocked() {
+	for id, seenAt := range fleet.SeenCommands {
+		if time.Since(seenAt) > fleetSeenCommandRetention {
+			delete(fleet.SeenCommands, id)
+		}
+	}
+	data, err := json.MarshalIndent(fleet, "", "  ")
+	if err == nil {
+		err = os.WriteFile(fleetStatePath(), data, 0600)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to save fleet state: %v", err)
+	}
+}
+
+// loadFleetSettings reads the fleet section of admin_config.json
+func loadFleetSettings() (FleetSettings, bool) {
+	adminConfig, err := loadAdminConfig(adminConfigPath())
+	if err != nil {
+		return FleetSettings{}, false
+	}
+	settings := adminConfig.Fleet
+	if settings.DeviceID == "" {
+		settings.DeviceID, _ = os.Hostname()
+	}
+	if settings.ReportIntervalSeconds <= 0 {
+		settings.ReportIntervalSeconds = getDefaultFleetSettings().ReportIntervalSeconds
+	}
+	return settings, settings.Enabled && settings.ServerURL != ""
+}
+
+// startFleetAgent reports to the fleet manager on its interval. Settings are
+// re-read every time, so enabling fleet mode doesn't need a restart.
+func startFleetAgent() {
+	loadFleetState()
+	go func() {
+		for {
+			interval := time.Duration(getDefaultFleetSettings().ReportIntervalSeconds) * time.Second
+			if settings, enabled := loadFleetSettings(); enabled {
+				interval = time.Duration(settings.ReportIntervalSeconds) * time.Second
+				if err := reportToFleet(settings); err != nil {
+					log.Printf("Fleet report failed: %v", err)
+				} else {
+					uploadCrashReports(settings)
+				}
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// buildFleetReport gathers the unit's current status
+func buildFleetReport(settings FleetSettings) FleetReport {
+	hostname, _ := os.Hostname()
+	report := FleetReport{
+		DeviceID:   settings.DeviceID,
+		Version:    appVersion,
+		Hostname:   hostname,
+		IP:         fleetLocalIP(settings.ServerURL),
+		Platform:   runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		Uptime:     getAppUptime(),
+		ReportedAt: time.Now(),
+		Health: FleetHealth{
+			Status:         "ok",
+			AudioAvailable: app.AudioEnabled,
+			AudioBackend:   audioBackendName(),
+			Volume:         int(currentVolume() * 100),
+			ScheduledJobs:  len(app.Scheduler.Entries()),
+			MemoryUsage:    getMemoryUsage(),
+		},
+	}
+	report.Health.DiskFreeMB, report.Health.DiskState = lowestFreeMB()
+	if host := latestHostMetrics(); host != nil {
+		report.Health.CPUTempC = host.CPUTempC
+		if host.Throttle != nil && len(host.Throttle.Now) > 0 {
+			report.Health.Throttled = host.Throttle.Now
+		}
+	}
+	if !app.AudioEnabled || report.Health.DiskState == storageCritical {
+		report.Health.Status = "degraded"
+	}
+
+	if announcementManager != nil {
+		status := announcementManager.GetQueueStatus()
+		report.Health.QueueLength, _ = status["queue_length"].(int)
+		report.Health.QueuePaused, _ = status["is_paused"].(bool)
+		if history := announcementManager.GetHistory(1); len(history) > 0 {
+			last := history[0]
+			report.LastAnnouncement = &FleetAnnouncement{
+				ID:          last.ID,
+				Type:        last.Type,
+				Status:      last.Status,
+				CompletedAt: last.CompletedAt,
+				Error:       last.Error,
+			}
+		}
+	}
+	return report
+}
+
+// fleetLocalIP is the address this unit uses to reach the fleet server
+func fleetLocalIP(serverURL string) string {
+	parsed, err := url.Parse(serverURL)
+	if err != nil || parsed.Hostname() == "" {
+		return ""
+	}
+	port := parsed.Port()
+	if port == "" {
+		port = "443"
+		if parsed.Scheme == "http" {
+			port = "80"
+		}
+	}
+	// UDP "connects" without sending anything but still picks the route
+	conn, err := net.Dial("udp", net.JoinHostPort(parsed.Hostname(), port))
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+// reportToFleet sends one report and runs any commands that come back
+func reportToFleet(settings FleetSettings) error {
+	report := buildFleetReport(settings)
+	fleetMutex.Lock()
+	report.CommandResults = append([]FleetCommandResult{}, fleet.PendingResults...)
+	fleetMutex.Unlock()
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	endpoint := strings.TrimRight(settings.ServerURL, "/") + "/api/v1/devices/" + url.PathEscape(settings.DeviceID) + "/report"
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tarr-annunciator/"+appVersion)
+	if settings.DeviceToken != "" {
+		req.Header.Set("Authorization", "Bearer "+settings.DeviceToken)
+	}
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err == nil && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		resp.Body.Close()
+		err = fmt.Errorf("fleet server returned HTTP %d", resp.StatusCode)
+	}
+	if err != nil {
+		fleetMutex.Lock()
+		fleet.LastError = err.Error()
+		fleetMutex.Unlock()
+		return err
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		Commands []SignedFleetCommand `json:"commands"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&reply); err != nil && err != io.EOF {
+		return fmt.Errorf("invalid reply from fleet server: %v", err)
+	}
+
+	// The results were delivered; only drop the ones we sent
+	now := time.Now()
+	fleetMutex.Lock()
+	fleet.PendingResults = fleet.PendingResults[len(report.CommandResults):]
+	fleet.LastReportAt = &now
+	fleet.LastError = ""
+	saveFleetStateLocked()
+	fleetMutex.Unlock()
+
+	for _, signed := range reply.Commands {
+		handleFleetCommand(settings, signed)
+	}
+	return nil
+}
+
+// verifyFleetCommand checks the signature and addressing of a command
+func verifyFleetCommand(settings FleetSettings, signed SignedFleetCommand) (*FleetCommand, error) {
+	publicKey, err := base64.StdEncoding.DecodeString(settings.ServerPublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("fleet.server_public_key is not a base64 Ed25519 public key")
+	}
+	payload, err := base64.StdEncoding.DecodeString(signed.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("payload is not base64")
+	}
+	signature, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil || !ed25519.Verify(publicKey, payload, signature) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	var command FleetCommand
+	if err := json.Unmarshal(payload, &command); err != nil {
+		return nil, fmt.Errorf("invalid command: %v", err)
+	}
+	if command.ID == "" {
+		return &command, fmt.Errorf("command has no id")
+	}
+	if command.DeviceID != settings.DeviceID && command.DeviceID != "*" {
+		return &command, fmt.Errorf("command is addressed to %q", command.DeviceID)
+	}
+	now := time.Now()
+	if command.ExpiresAt != nil {
+		if now.After(*command.ExpiresAt) {
+			return &command, fmt.Errorf("command expired at %s", command.ExpiresAt.Format(time.RFC3339))
+		}
+	} else if now.Sub(command.IssuedAt) > fleetCommandMaxAge {
+		return &command, fmt.Errorf("command issued at %s is too old", command.IssuedAt.Format(time.RFC3339))
+	}
+	allowed := false
+	for _, commandType := range settings.AllowedCommands {
+		if commandType == command.Type {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return &command, fmt.Errorf("command type %q is not in fleet.allowed_commands", command.Type)
+	}
+	return &command, nil
+}
+
+// handleFleetCommand verifies and runs one command, recording the result for
+// the next report
+func handleFleetCommand(settings FleetSettings, signed SignedFleetCommand) {
+	command, err := verifyFleetCommand(settings, signed)
+	if command == nil {
+		// Unsigned or unreadable: nothing trustworthy to report against
+		log.Printf("🚫 Fleet command rejected: %v", err)
+		return
+	}
+
+	fleetMutex.Lock()
+	_, seen := fleet.SeenCommands[command.ID]
+	if !seen {
+		fleet.SeenCommands[command.ID] = time.Now()
+		saveFleetStateLocked()
+	}
+	fleetMutex.Unlock()
+	if seen {
+		log.Printf("🚫 Fleet command %s ignored: already run", command.ID)
+		return
+	}
+
+	if err != nil {
+		log.Printf("🚫 Fleet command %s rejected: %v", command.ID, err)
+		recordFleetResult(command, "rejected", err.Error())
+		return
+	}
+
+	log.Printf("🛰️  Fleet command %s: %s", command.ID, command.Type)
+	switch command.Type {
+	case FleetCommandAnnounce:
+		message, err := runFleetAnnounce(command.Params)
+		if err != nil {
+			recordFleetResult(command, "failed", err.Error())
+		} else {
+			recordFleetResult(command, "ok", message)
+		}
+	case FleetCommandRestart:
+		recordFleetResult(command, "ok", "restarting")
+		go restartApplication()
+	case FleetCommandUpdate:
+		if err := runFleetUpdate(command.Params); err != nil {
+			recordFleetResult(command, "failed", err.Error())
+			notifyUpdateFailed("fleet command "+command.ID, err)
+			return
+		}
+		recordFleetResult(command, "ok", "updated to the new executable, restarting")
+		go restartApplication()
+	default:
+		recordFleetResult(command, "rejected", "unknown command type "+command.Type)
+	}
+}
+
+func recordFleetResult(command *FleetCommand, status, message string) {
+	fleetMutex.Lock()
+	defer fleetMutex.Unlock()
+	fleet.PendingResults = append(fleet.PendingResults, FleetCommandResult{
+		ID:         command.ID,
+		Type:       command.Type,
+		Status:     status,
+		Message:    message,
+		FinishedAt: time.Now(),
+	})
+	saveFleetStateLocked()
+}
+
+// runFleetAnnounce queues an announcement. Params are
+// {"type": "station", "parameters": {...}, "priority": "high", "delay": 0}
+// with the same parameters the REST API takes for that type.
+func runFleetAnnounce(raw json.RawMessage) (string, error) {
+	if announcementManager == nil {
+		return "", fmt.Errorf("announcement manager not initialized")
+	}
+	var params map[string]interface{}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return "", fmt.Errorf("invalid params: %v", err)
+	}
+
+	announcementType, _ := params["type"].(string)
+	switch AnnouncementType(announcementType) {
+	case TypeStation, TypeSafety, TypePromo, TypeEmergency, TypeServiceChange:
+	default:
+		return "", fmt.Errorf("unsupported announcement type %q", announcementType)
+	}
+	parameters, _ := params["parameters"].(map[string]interface{})
+	if parameters == nil {
+		parameters = map[string]interface{}{}
+	}
+
+	priority, scheduledAt, details := parseAnnouncementScheduling(params, "normal")
+	if len(details) > 0 {
+		return "", fmt.Errorf("%s %s", details[0].Field, details[0].Message)
+	}
+	if AnnouncementType(announcementType) == TypeEmergency {
+		priority, scheduledAt = PriorityEmergency, time.Now()
+	}
+
+	announcement, err := announcementManager.QueueAnnouncement(AnnouncementType(announcementType), priority, parameters, scheduledAt)
+	if err != nil {
+		return "", err
+	}
+	return "queued " + announcement.ID, nil
+}
+
+// runFleetUpdate downloads a new executable, checks its SHA-256 and swaps it
+// in place of the running one, keeping the old one as <name>.old. Params are
+// {"url": "https://...", "sha256": "<hex>"}.
+func runFleetUpdate(raw json.RawMessage) error {
+	var params struct {
+		URL    string `json:"url"`
+		SHA256 string `json:"sha256"`
+	}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return fmt.Errorf("invalid params: %v", err)
+	}
+	if params.URL == "" || len(params.SHA256) != sha256.Size*2 {
+		return fmt.Errorf("update needs a url and the sha256 of the executable")
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot find the running executable: %v", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+
+	client := &http.Client{Timeout: 10 * time.Minute}
+	resp, err := client.Get(params.URL)
+	if err != nil {
+		return fmt.Errorf("download failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed: HTTP %d", resp.StatusCode)
+	}
+
+	newPath := executable + ".new"
+	file, err := os.OpenFile(newPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return fmt.Errorf("cannot write %s: %v", newPath, err)
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(newPath)
+		return fmt.Errorf("download failed: %v", err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(sum, params.SHA256) {
+		os.Remove(newPath)
+		return fmt.Errorf("checksum mismatch: got %s", sum)
+	}
+
+	// Renaming works even while the old executable is running, on Windows too
+	oldPath := executable + ".old"
+	os.Remove(oldPath)
+	if err := os.Rename(executable, oldPath); err != nil {
+		os.Remove(newPath)
+		return fmt.Errorf("cannot move the current executable aside: %v", err)
+	}
+	if err := os.Rename(newPath, executable); err != nil {
+		os.Rename(oldPath, executable)
+		return fmt.Errorf("cannot install the new executable: %v", err)
+	}
+	log.Printf("🛰️  Installed new executable from %s (previous kept as %s)", params.URL, oldPath)
+	return nil
+}
+
+// apiFleetStatusHandler shows whether fleet mode is on and how reporting is going
+func apiFleetStatusHandler(c *gin.Context) {
+	settings, enabled := loadFleetSettings()
+	fleetMutex.Lock()
+	defer fleetMutex.Unlock()
+	respondOK(c, gin.H{
+		"enabled":                 enabled,
+		"server_url":              settings.ServerURL,
+		"device_id":               settings.DeviceID,
+		"report_interval_seconds": settings.ReportIntervalSeconds,
+		"allowed_commands":        settings.AllowedCommands,
+		"commands_verifiable":     settings.ServerPublicKey != "",
+		"last_report_at":          fleet.LastReportAt,
+		"last_error":              fleet.LastError,
+		"pending_results":         fleet.PendingResults,
+		"version":                 appVersion,
+	})
+}
@@ -0,0 +1,83 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// GPIO lines are driven through the kernel's GPIO character device
+// (/dev/gpiochipN), which works on every Raspberry Pi kernel without sysfs
+// GPIO or extra packages. The line is held for as long as the handle is open.
+
+// From linux/gpio.h (the v1 line handle ABI)
+const (
+	gpioHandleRequestOutput      = 1 << 1
+	gpioHandleRequestActiveLow   = 1 << 2
+	gpioGetLineHandleIoctl       = 0xc16cb403
+	gpioHandleSetLineValuesIoctl = 0xc040b409
+)
+
+type gpioHandleRequest struct {
+	LineOffsets   [64]uint32
+	Flags         uint32
+	DefaultValues [64]uint8
+	ConsumerLabel [32]byte
+	Lines         uint32
+	Fd            int32
+}
+
+type gpioHandleData struct {
+	Values [64]uint8
+}
+
+// gpioLine is an output line requested from a GPIO chip
+type gpioLine struct {
+	fd int
+}
+
+// openGPIOLine requests a line as an output, inactive to start with.
+// activeLow makes active mean a low level.
+func openGPIOLine(chip string, line int, activeLow bool) (*gpioLine, error) {
+	path := chip
+	if !strings.HasPrefix(path, "/") {
+		path = "/dev/" + chip
+	}
+	chipFile, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer chipFile.Close()
+
+	request := gpioHandleRequest{Flags: gpioHandleRequestOutput, Lines: 1}
+	if activeLow {
+		request.Flags |= gpioHandleRequestActiveLow
+	}
+	request.LineOffsets[0] = uint32(line)
+	copy(request.ConsumerLabel[:], "tarr-annunciator")
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, chipFile.Fd(), gpioGetLineHandleIoctl, uintptr(unsafe.Pointer(&request))); errno != 0 {
+		return nil, fmt.Errorf("cannot request line %d of %s: %v", line, chip, errno)
+	}
+	return &gpioLine{fd: int(request.Fd)}, nil
+}
+
+// set drives the line active or inactive
+func (l *gpioLine) set(active bool) error {
+	var data gpioHandleData
+	if active {
+		data.Values[0] = 1
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(l.fd), gpioHandleSetLineValuesIoctl, uintptr(unsafe.Pointer(&data))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// close releases the line
+func (l *gpioLine) close() error {
+	return syscall.Close(l.fd)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// gpioLine is an output line requested from a GPIO chip; only Linux has them
+type gpioLine struct{}
+
+func openGPIOLine(chip string, line int, activeLow bool) (*gpioLine, error) {
+	return nil, fmt.Errorf("GPIO is only supported on Linux")
+}
+
+func (l *gpioLine) set(active bool) error {
+	return fmt.Errorf("GPIO is only supported on Linux")
+}
+
+func (l *gpioLine) close() error {
+	return nil
+}
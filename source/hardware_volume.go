@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Scaling samples in software throws away resolution: at 30% volume the Pi's
+// analog output only uses the bottom few bits of its DAC. With hardware volume
+// on, the mixer of the output device is set to the volume (quiet hours
+// included) and the samples play at full scale. If the mixer can't be set the
+// volume is applied in software as before.
+
+// Hardware volume methods. "auto" picks one for the platform and device.
+const (
+	hardwareVolumeOff        = "off"
+	hardwareVolumeAuto       = "auto"
+	hardwareVolumeAmixer     = "amixer"     // ALSA mixer, Linux
+	hardwareVolumePactl      = "pactl"      // PulseAudio or PipeWire sink, Linux
+	hardwareVolumeWpctl      = "wpctl"      // PipeWire sink, Linux
+	hardwareVolumeOsascript  = "osascript"  // System output volume, macOS
+	hardwareVolumePowershell = "powershell" // AudioDeviceCmdlets, Windows
+)
+
+var hardwareVolumeMethods = []string{
+	hardwareVolumeOff, hardwareVolumeAuto, hardwareVolumeAmixer, hardwareVolumePactl,
+	hardwareVolumeWpctl, hardwareVolumeOsascript, hardwareVolumePowershell,
+}
+
+// amixer controls tried in order when none is configured for the device
+var amixerControls = []string{"Master", "PCM", "Headphone", "Speaker", "Digital"}
+
+// HardwareVolumeSettings is the hardware_volume section of settings.json
+type HardwareVolumeSettings struct {
+	Method   string            `json:"method"`             // One of hardwareVolumeMethods
+	Controls map[string]string `json:"controls,omitempty"` // amixer control by device ID, e.g. {"hw:1,0": "Speaker"}
+}
+
+// hardwareVolumeState remembers the last level set on each device, so the
+// mixer command only runs when the level changes
+var hardwareVolumeState struct {
+	mutex  sync.Mutex
+	levels map[string]int // Percent by device
+	failed map[string]bool
+}
+
+// validHardwareVolumeMethod reports whether method is one of hardwareVolumeMethods
+func validHardwareVolumeMethod(method string) bool {
+	for _, known := range hardwareVolumeMethods {
+		if method == known {
+			return true
+		}
+	}
+	return false
+}
+
+// isALSADevice reports whether a device ID names an ALSA card
+func isALSADevice(device string) bool {
+	return strings.HasPrefix(device, "hw:") || strings.HasPrefix(device, "plughw:")
+}
+
+// resolveHardwareVolumeMethod turns "auto" into the method for this platform
+// and device, "off" when there is none
+func resolveHardwareVolumeMethod(method, device string) string {
+	if method != hardwareVolumeAuto {
+		return method
+	}
+	available := func(command string) bool {
+		_, err := exec.LookPath(command)
+		return err == nil
+	}
+	switch runtime.GOOS {
+	case "linux":
+		switch {
+		case isALSADevice(device) && available("amixer"):
+			return hardwareVolumeAmixer
+		case available("wpctl"):
+			return hardwareVolumeWpctl
+		case available("pactl"):
+			return hardwareVolumePactl
+		case available("amixer"):
+			return hardwareVolumeAmixer
+		}
+	case "darwin":
+		return hardwareVolumeOsascript
+	case "windows":
+		return hardwareVolumePowershell
+	}
+	return hardwareVolumeOff
+}
+
+// amixerControl returns the mixer control for device: the configured one,
+// otherwise the first of amixerControls the card has
+func amixerControl(device, card string, controls map[string]string) (string, error) {
+	if control := controls[device]; control != "" {
+		return control, nil
+	}
+	args := []string{"scontrols"}
+	if card != "" {
+		args = append([]string{"-c", card}, args...)
+	}
+	output, err := exec.Command("amixer", args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("amixer scontrols failed: %v", err)
+	}
+	for _, control := range amixerControls {
+		if strings.Contains(string(output), "'"+control+"'") {
+			return control, nil
+		}
+	}
+	return "", fmt.Errorf("no volume control found; set one in hardware_volume.controls")
+}
+
+// setHardwareVolume sets device's mixer to percent using method
+func setHardwareVolume(method, device string, percent int, controls map[string]string) error {
+	var cmd *exec.Cmd
+	switch method {
+	case hardwareVolumeAmixer:
+		card := ""
+		if isALSADevice(device) {
+			card = extractCardNumber(device)
+		}
+		control, err := amixerControl(device, card, controls)
+		if err != nil {
+			return err
+		}
+		// -M uses the mapped (perceptual) scale, like the volume slider in alsamixer
+		args := []string{"-q", "-M", "sset", control, fmt.Sprintf("%d%%", percent)}
+		if card != "" {
+			args = append([]string{"-c", card}, args...)
+		}
+		cmd = exec.Command("amixer", args...)
+	case hardwareVolumePactl:
+		sink := device
+		if sink == "default" {
+			sink = "@DEFAULT_SINK@"
+		}
+		cmd = exec.Command("pactl", "set-sink-volume", sink, fmt.Sprintf("%d%%", percent))
+	case hardwareVolumeWpctl:
+		sink := device
+		if sink == "default" {
+			sink = "@DEFAULT_AUDIO_SINK@"
+		}
+		cmd = exec.Command("wpctl", "set-volume", sink, strconv.FormatFloat(float64(percent)/100, 'f', 2, 64))
+	case hardwareVolumeOsascript:
+		cmd = exec.Command("osascript", "-e", fmt.Sprintf("set volume output volume %d", percent))
+	case hardwareVolumePowershell:
+		cmd = exec.Command("powershell", "-Command", fmt.Sprintf(`Import-Module AudioDeviceCmdlets -Force; Set-AudioDevice -PlaybackVolume %d`, percent))
+	default:
+		return fmt.Errorf("unknown hardware volume method: %s", method)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %v %s", method, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// applyHardwareVolume sets the active device's mixer to level and reports
+// whether it did; when it reports false the caller scales the samples instead
+func applyHardwareVolume(level float64) bool {
+	settings := currentRuntimeSettings().HardwareVolume
+	device := activeAudioDevice()
+	method := resolveHardwareVolumeMethod(settings.Method, device)
+	if method == hardwareVolumeOff || method == "" {
+		return false
+	}
+	percent := int(math.Round(level * 100))
+
+	state := &hardwareVolumeState
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.levels == nil {
+		state.levels = make(map[string]int)
+		state.failed = make(map[string]bool)
+	}
+	key := method + " " + device
+	if current, ok := state.levels[key]; ok && current == percent {
+		return true
+	}
+	if err := setHardwareVolume(method, device, percent, settings.Controls); err != nil {
+		// Log once per device rather than on every announcement
+		if !state.failed[key] {
+			log.Printf("Warning: hardware volume unavailable on %s, using software volume: %v", device, err)
+			state.failed[key] = true
+		}
+		delete(state.levels, key)
+		return false
+	}
+	if state.failed[key] {
+		log.Printf("✓ Hardware volume working again on %s", device)
+		delete(state.failed, key)
+	}
+	state.levels[key] = percent
+	return true
+}
+
+// resetHardwareVolume forgets the levels set, so the next playback sets the
+// mixer again. Called when the settings change, since another program (or a
+// device being replaced) may have moved the mixer.
+func resetHardwareVolume() {
+	hardwareVolumeState.mutex.Lock()
+	hardwareVolumeState.levels = nil
+	hardwareVolumeState.failed = nil
+	hardwareVolumeState.mutex.Unlock()
+}
+
+// releaseHardwareVolume turns the mixer back up to full when hardware volume
+// is switched off, so the software volume isn't applied on top of the last
+// mixer level
+func releaseHardwareVolume(settings HardwareVolumeSettings) {
+	device := activeAudioDevice()
+	method := resolveHardwareVolumeMethod(settings.Method, device)
+	if method == hardwareVolumeOff {
+		return
+	}
+	if err := setHardwareVolume(method, device, 100, settings.Controls); err != nil {
+		log.Printf("Warning: failed to reset the %s mixer on %s to full volume: %v", method, device, err)
+	}
+}
+
+// hardwareVolumeStatus reports the method in use for the admin UI and API
+func hardwareVolumeStatus() map[string]interface{} {
+	settings := currentRuntimeSettings().HardwareVolume
+	device := activeAudioDevice()
+	method := resolveHardwareVolumeMethod(settings.Method, device)
+
+	hardwareVolumeState.mutex.Lock()
+	defer hardwareVolumeState.mutex.Unlock()
+	key := method + " " + device
+	status := map[string]interface{}{
+		"configured": settings.Method,
+		"method":     method,
+		"device":     device,
+		"failed":     hardwareVolumeState.failed[key],
+	}
+	if percent, ok := hardwareVolumeState.levels[key]; ok {
+		status["level_percent"] = percent
+	}
+	return status
+}
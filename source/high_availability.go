@@ -0,0 +1,528 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A station can run a hot standby: a second unit wired to the same speakers
+// that stays quiet while the primary answers. The standby checks the
+// primary's /api/status every check_interval_seconds and, while it answers,
+// mirrors its JSON configuration so the schedule and catalogs are current.
+// Announcements reaching the standby while it is passive, scheduled ones
+// included, are suppressed. When the primary misses failover_after_checks
+// checks in a row the standby takes over: it energizes its relay (e.g. the
+// amplifier input selector), runs claim_command (e.g. to connect the
+// Bluetooth speaker), reopens its audio device and plays from then on. Once
+// the primary has answered failback_after_checks checks in a row the standby
+// hands back. Takeover and handing back can also be forced through the API.
+//
+// A standby that loses the network while the primary keeps playing takes over
+// too, so give it a path to the primary as reliable as its path to the
+// speakers.
+
+// High availability roles
+const (
+	HARoleOff     = ""
+	HARolePrimary = "primary"
+	HARoleStandby = "standby"
+)
+
+// HARelay is a GPIO relay energized while the standby is active
+type HARelay struct {
+	Chip      string `json:"chip,omitempty"` // GPIO chip, gpiochip0 by default
+	Line      int    `json:"line"`
+	ActiveLow bool   `json:"active_low"`
+}
+
+// HASettings is the ha section of admin_config.json
+type HASettings struct {
+	Role                  string   `json:"role"`        // "", primary or standby
+	PrimaryURL            string   `json:"primary_url"` // Standby: the primary's address, e.g. http://10.0.0.5:8080
+	APIKey                string   `json:"api_key"`     // Standby: a key on the primary with the config permission
+	CheckIntervalSeconds  int      `json:"check_interval_seconds"`
+	FailoverAfterChecks   int      `json:"failover_after_checks"`   // Missed checks in a row before taking over
+	FailbackAfterChecks   int      `json:"failback_after_checks"`   // Answered checks in a row before handing back; 0 stays active until released
+	MirrorIntervalSeconds int      `json:"mirror_interval_seconds"` // 0 turns mirroring off
+	MirrorExclude         []string `json:"mirror_exclude"`          // Files this unit keeps its own copy of
+	ClaimCommand          string   `json:"claim_command"`           // Run when taking over
+	ReleaseCommand        string   `json:"release_command"`         // Run when handing back
+	Relay                 *HARelay `json:"relay,omitempty"`
+}
+
+func getDefaultHASettings() HASettings {
+	return HASettings{
+		Role:                  HARoleOff,
+		CheckIntervalSeconds:  5,
+		FailoverAfterChecks:   3,
+		FailbackAfterChecks:   12,
+		MirrorIntervalSeconds: 300,
+		// Volume, output device and log level belong to the unit
+		MirrorExclude: []string{"settings.json"},
+	}
+}
+
+// How long claim and release commands may run
+const haCommandTimeout = 30 * time.Second
+
+var haState struct {
+	sync.Mutex
+	settings      HASettings
+	active        bool
+	manual        bool // Taken over through the API; not handed back by itself
+	since         time.Time
+	reason        string
+	missed        int
+	answered      int
+	lastCheck     time.Time
+	lastError     string
+	lastMirror    time.Time
+	mirrorError   string
+	mirroredFiles []string
+	relay         *gpioLine
+	relayError    string
+}
+
+// loadHASettings reads the ha section of admin_config.json
+func loadHASettings() HASettings {
+	adminConfig, err := loadAdminConfig(adminConfigPath())
+	if err != nil {
+		return getDefaultHASettings()
+	}
+	settings := adminConfig.HA
+	defaults := getDefaultHASettings()
+	if settings.CheckIntervalSeconds <= 0 {
+		settings.CheckIntervalSeconds = defaults.CheckIntervalSeconds
+	}
+	if settings.FailoverAfterChecks <= 0 {
+		settings.FailoverAfterChecks = defaults.FailoverAfterChecks
+	}
+	if settings.MirrorExclude == nil {
+		settings.MirrorExclude = defaults.MirrorExclude
+	}
+	return settings
+}
+
+// haPassive reports whether this unit is a standby that isn't playing
+func haPassive() bool {
+	haState.Lock()
+	defer haState.Unlock()
+	return haState.settings.Role == HARoleStandby && !haState.active
+}
+
+// startHighAvailability starts watching the primary when this unit is a
+// standby
+func startHighAvailability() {
+	settings := loadHASettings()
+	haState.Lock()
+	haState.settings = settings
+	haState.Unlock()
+
+	switch settings.Role {
+	case HARoleOff:
+		return
+	case HARolePrimary:
+		log.Printf("✓ High availability: primary, serving configuration to the standby")
+		return
+	case HARoleStandby:
+	default:
+		log.Printf("Warning: unknown ha role %q, high availability is off", settings.Role)
+		return
+	}
+
+	if settings.PrimaryURL == "" {
+		log.Printf("Warning: ha role is standby but primary_url is not set, staying passive")
+	}
+	haState.Lock()
+	switchHARelayLocked(false)
+	haState.Unlock()
+	log.Printf("✓ High availability: standby for %s, passive", settings.PrimaryURL)
+
+	go func() {
+		for {
+			settings := loadHASettings()
+			haState.Lock()
+			haState.settings = settings
+			haState.Unlock()
+			if settings.Role == HARoleStandby && settings.PrimaryURL != "" {
+				checkPrimary(settings)
+			}
+			time.Sleep(time.Duration(settings.CheckIntervalSeconds) * time.Second)
+		}
+	}()
+}
+
+// checkPrimary runs one health check, taking over or handing back when the
+// counts say so, and mirrors the configuration when it is due
+func checkPrimary(settings HASettings) {
+	err := pingPrimary(settings)
+
+	haState.Lock()
+	haState.lastCheck = time.Now()
+	if err != nil {
+		haState.lastError = err.Error()
+		haState.missed++
+		haState.answered = 0
+		// Only on reaching the count, so a standby released during an
+		// outage stays passive until the primary has answered again
+		if !haState.active && haState.missed == settings.FailoverAfterChecks {
+			haState.Unlock()
+			log.Printf("❌ Primary %s missed %d checks: %v", settings.PrimaryURL, settings.FailoverAfterChecks, err)
+			haTakeOver(fmt.Sprintf("primary stopped responding: %v", err), false)
+			return
+		}
+		haState.Unlock()
+		return
+	}
+
+	haState.lastError = ""
+	haState.missed = 0
+	haState.answered++
+	active, manual, answered := haState.active, haState.manual, haState.answered
+	mirrorDue := settings.MirrorIntervalSeconds > 0 &&
+		time.Since(haState.lastMirror) >= time.Duration(settings.MirrorIntervalSeconds)*time.Second
+	haState.Unlock()
+
+	if active {
+		if !manual && settings.FailbackAfterChecks > 0 && answered >= settings.FailbackAfterChecks {
+			haHandBack(fmt.Sprintf("primary answered %d checks", settings.FailbackAfterChecks))
+		}
+		return
+	}
+	if mirrorDue {
+		mirrorPrimaryConfig(settings)
+	}
+}
+
+// primaryRequest sends a request to the primary with the standby's key
+func primaryRequest(settings HASettings, path string, timeout time.Duration) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(settings.PrimaryURL, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "tarr-annunciator/"+appVersion)
+	if settings.APIKey != "" {
+		req.Header.Set("X-API-Key", settings.APIKey)
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("HTTP %d from %s", resp.StatusCode, path)
+	}
+	return resp, nil
+}
+
+// pingPrimary checks that the primary is up and reports itself online
+func pingPrimary(settings HASettings) error {
+	timeout := time.Duration(settings.CheckIntervalSeconds) * time.Second
+	if timeout > 10*time.Second {
+		timeout = 10 * time.Second
+	}
+	resp, err := primaryRequest(settings, "/api/status", timeout)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var status struct {
+		Data struct {
+			Status string `json:"status"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&status); err != nil {
+		return fmt.Errorf("invalid status reply: %v", err)
+	}
+	if status.Data.Status != "online" {
+		return fmt.Errorf("primary reports status %q", status.Data.Status)
+	}
+	return nil
+}
+
+// mirrorPrimaryConfig fetches the primary's configuration and writes the
+// files that differ from ours
+func mirrorPrimaryConfig(settings HASettings) {
+	changed, err := fetchPrimaryConfig(settings)
+	var written []string
+	if err == nil && len(changed) > 0 {
+		written, _, err = writeConfigFiles(changed, "ha:primary", "Mirrored from the primary")
+	}
+
+	haState.Lock()
+	defer haState.Unlock()
+	haState.lastMirror = time.Now()
+	if err != nil {
+		if haState.mirrorError != err.Error() {
+			log.Printf("⚠️  Mirroring configuration from the primary failed: %v", err)
+		}
+		haState.mirrorError = err.Error()
+		return
+	}
+	haState.mirrorError = ""
+	if len(written) > 0 {
+		haState.mirroredFiles = written
+		log.Printf("🪞 Mirrored %s from the primary", strings.Join(written, ", "))
+	}
+}
+
+// fetchPrimaryConfig downloads the primary's configuration and returns the
+// files that differ from ours, less the excluded ones
+func fetchPrimaryConfig(settings HASettings) (map[string][]byte, error) {
+	resp, err := primaryRequest(settings, "/api/ha/config", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return nil, err
+	}
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration archive: %v", err)
+	}
+	contents, err := readConfigArchive(reader.File, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range settings.MirrorExclude {
+		delete(contents, name)
+	}
+	for name, data := range contents {
+		if current, err := os.ReadFile(filepath.Join(app.Config.JSONDir, name)); err == nil && bytes.Equal(current, data) {
+			delete(contents, name)
+		}
+	}
+	return contents, nil
+}
+
+// haTakeOver makes the standby active: the relay is energized, the claim
+// command runs and the audio device is reopened
+func haTakeOver(reason string, manual bool) {
+	haState.Lock()
+	if haState.active {
+		haState.manual = haState.manual || manual
+		haState.Unlock()
+		return
+	}
+	settings := haState.settings
+	haState.active = true
+	haState.manual = manual
+	haState.since = time.Now()
+	haState.reason = reason
+	haState.answered = 0
+	switchHARelayLocked(true)
+	haState.Unlock()
+
+	log.Printf("🟢 Standby taking over playback: %s", reason)
+	if err := runHACommand(settings.ClaimCommand, "claim"); err != nil {
+		log.Printf("⚠️  ha claim_command failed: %v", err)
+	}
+	if err := reinitAudioForDevice(currentAudioDevice()); err != nil {
+		log.Printf("⚠️  Reopening the audio device after taking over failed: %v", err)
+	}
+	notify(NotifyHAFailover, "", "critical", "Standby took over",
+		fmt.Sprintf("The standby annunciator took over playback from %s: %s", settings.PrimaryURL, reason))
+}
+
+// haHandBack makes the standby passive again
+func haHandBack(reason string) {
+	haState.Lock()
+	if !haState.active {
+		haState.Unlock()
+		return
+	}
+	settings := haState.settings
+	haState.active = false
+	haState.manual = false
+	haState.since = time.Now()
+	haState.reason = reason
+	switchHARelayLocked(false)
+	haState.Unlock()
+
+	log.Printf("⏸️  Standby handing playback back to the primary: %s", reason)
+	if err := runHACommand(settings.ReleaseCommand, "release"); err != nil {
+		log.Printf("⚠️  ha release_command failed: %v", err)
+	}
+	notify(NotifyHAFailover, "", "warning", "Standby handed back",
+		fmt.Sprintf("The standby annunciator handed playback back to %s: %s", settings.PrimaryURL, reason))
+}
+
+// switchHARelayLocked sets the standby's relay, if it has one. Caller must
+// hold haState.
+func switchHARelayLocked(on bool) {
+	relay := haState.settings.Relay
+	if relay == nil {
+		return
+	}
+	if haState.relay == nil {
+		chip := strings.TrimPrefix(relay.Chip, "/dev/")
+		if chip == "" {
+			chip = "gpiochip0"
+		}
+		line, err := openGPIOLine(chip, relay.Line, relay.ActiveLow)
+		if err != nil {
+			haState.relayError = err.Error()
+			log.Printf("⚠️  ha relay %s/%d unavailable: %v", chip, relay.Line, err)
+			return
+		}
+		haState.relay = line
+	}
+	if err := haState.relay.set(on); err != nil {
+		haState.relayError = err.Error()
+		log.Printf("⚠️  Failed to switch the ha relay: %v", err)
+		return
+	}
+	haState.relayError = ""
+}
+
+// runHACommand runs a claim or release command through the shell
+func runHACommand(command, stage string) error {
+	if command == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), haCommandTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Dir = app.Config.BaseDir
+	cmd.Env = append(os.Environ(), "TARR_HA_STAGE="+stage, "TARR_AUDIO_DEVICE="+currentAudioDevice())
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// suppressWhileStandby finishes everything queued while this unit is a
+// passive standby; the primary is announcing. Caller must hold the mutex.
+func (am *AnnouncementManager) suppressWhileStandby() {
+	if !haPassive() {
+		return
+	}
+	for am.queue.Len() > 0 {
+		announcement := heap.Pop(am.queue).(*Announcement)
+		now := time.Now()
+		announcement.Status = StatusSuppressed
+		announcement.CompletedAt = &now
+		announcement.Error = "standby: the primary is announcing"
+		log.Printf("⏸️  Suppressed announcement on the passive standby: ID=%s, Type=%s", announcement.ID, announcement.Type)
+		am.finishAnnouncement(announcement)
+	}
+}
+
+// haStatus describes the high availability state
+func haStatus() gin.H {
+	haState.Lock()
+	defer haState.Unlock()
+	settings := haState.settings
+	status := gin.H{"role": settings.Role}
+	if settings.Role != HARoleStandby {
+		return status
+	}
+	status["primary_url"] = settings.PrimaryURL
+	status["active"] = haState.active
+	status["manual"] = haState.manual
+	status["reason"] = haState.reason
+	status["missed_checks"] = haState.missed
+	status["primary_error"] = haState.lastError
+	status["mirror_error"] = haState.mirrorError
+	status["mirrored_files"] = haState.mirroredFiles
+	status["relay_error"] = haState.relayError
+	for key, t := range map[string]time.Time{"since": haState.since, "last_check": haState.lastCheck, "last_mirror": haState.lastMirror} {
+		if !t.IsZero() {
+			status[key] = t
+		}
+	}
+	return status
+}
+
+// Handlers
+
+// apiHAStatusHandler reports the role and, on a standby, whether it is
+// playing and how the primary is doing
+func apiHAStatusHandler(c *gin.Context) {
+	respondOK(c, haStatus())
+}
+
+// apiHAConfigHandler sends the primary's configuration to the standby as a
+// zip archive. admin_config.json is left out; each unit keeps its own users
+// and keys.
+func apiHAConfigHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	if loadHASettings().Role != HARolePrimary {
+		respondError(c, http.StatusConflict, ErrCodeConflict, "This unit is not an ha primary")
+		return
+	}
+	files, err := snapshottedFiles()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to list the configuration: "+err.Error())
+		return
+	}
+	var mirrored []string
+	for _, name := range files {
+		if name != "admin_config.json" {
+			mirrored = append(mirrored, name)
+		}
+	}
+
+	var archive bytes.Buffer
+	if err := writeConfigArchive(&archive, mirrored, "ha", time.Now()); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to archive the configuration: "+err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "application/zip", archive.Bytes())
+}
+
+// apiHATakeOverHandler makes a standby take over now. It stays active until
+// released.
+func apiHATakeOverHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	if loadHASettings().Role != HARoleStandby {
+		respondError(c, http.StatusConflict, ErrCodeConflict, "This unit is not an ha standby")
+		return
+	}
+	haTakeOver("taken over by "+requestOperator(c), true)
+	respondSuccess(c, http.StatusOK, "Standby is active", haStatus())
+}
+
+// apiHAReleaseHandler hands playback back to the primary
+func apiHAReleaseHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	if loadHASettings().Role != HARoleStandby {
+		respondError(c, http.StatusConflict, ErrCodeConflict, "This unit is not an ha standby")
+		return
+	}
+	haHandBack("released by " + requestOperator(c))
+	respondSuccess(c, http.StatusOK, "Standby is passive", haStatus())
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Host metrics show how the machine running the annunciator is coping: SoC
+// temperature, Raspberry Pi throttling, load, disk and network. A Pi in a hot
+// booth slows its CPU to cool down, and audio stutters when it does, so the
+// host monitor polls vcgencmd and alerts as soon as throttling or
+// under-voltage is reported, and when the temperature passes temp_warn_c.
+
+// Raspberry Pi throttle flags reported by vcgencmd get_throttled. The low
+// bits are the current state, the same bits shifted by 16 what has happened
+// since boot.
+var throttleFlags = []struct {
+	bit  uint
+	name string
+	text string
+}{
+	{0, "under_voltage", "under-voltage"},
+	{1, "frequency_capped", "ARM frequency capped"},
+	{2, "throttled", "throttled"},
+	{3, "soft_temp_limit", "soft temperature limit"},
+}
+
+// HostMonitorSettings is the host_monitor section of admin_config.json
+type HostMonitorSettings struct {
+	Enabled         bool    `json:"enabled"`
+	IntervalSeconds int     `json:"interval_seconds"`
+	TempWarnC       float64 `json:"temp_warn_c"` // Alert at or above this SoC temperature; 0 turns it off
+}
+
+func getDefaultHostMonitorSettings() HostMonitorSettings {
+	return HostMonitorSettings{
+		Enabled:         true,
+		IntervalSeconds: 30,
+		TempWarnC:       75,
+	}
+}
+
+// ThrottleStatus is the decoded vcgencmd get_throttled value
+type ThrottleStatus struct {
+	Raw       string   `json:"raw"`
+	Now       []string `json:"now"`        // Flags active now
+	SinceBoot []string `json:"since_boot"` // Flags seen since boot
+}
+
+// NetworkInterfaceStats are the counters of one interface
+type NetworkInterfaceStats struct {
+	Name      string `json:"name"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	RxErrors  uint64 `json:"rx_errors"`
+	TxErrors  uint64 `json:"tx_errors"`
+	RxDropped uint64 `json:"rx_dropped"`
+}
+
+// HostMetrics are the machine's current readings. Fields a platform can't
+// provide are left out.
+type HostMetrics struct {
+	RaspberryPi bool                    `json:"raspberry_pi"`
+	CPUCount    int                     `json:"cpu_count"`
+	LoadAverage []float64               `json:"load_average,omitempty"` // 1, 5 and 15 minutes
+	CPUTempC    *float64                `json:"cpu_temp_c,omitempty"`
+	ArmClockMHz int                     `json:"arm_clock_mhz,omitempty"`
+	Throttle    *ThrottleStatus         `json:"throttle,omitempty"`
+	Disk        []storageFilesystem     `json:"disk"`
+	Network     []NetworkInterfaceStats `json:"network,omitempty"`
+}
+
+// hostMonitorState remembers what the last poll found, so alerts go out when
+// a condition starts rather than on every poll
+var hostMonitorState struct {
+	mutex     sync.Mutex
+	throttled []string
+	hot       bool
+	latest    *HostMetrics
+}
+
+// loadHostMonitorSettings reads the host_monitor section of admin_config.json
+func loadHostMonitorSettings() HostMonitorSettings {
+	adminConfig, err := loadAdminConfig(adminConfigPath())
+	if err != nil {
+		return getDefaultHostMonitorSettings()
+	}
+	settings := adminConfig.HostMonitor
+	if settings.IntervalSeconds <= 0 {
+		settings.IntervalSeconds = getDefaultHostMonitorSettings().IntervalSeconds
+	}
+	return settings
+}
+
+// collectHostMetrics reads the current metrics
+func collectHostMetrics() *HostMetrics {
+	metrics := &HostMetrics{
+		RaspberryPi: isRaspberryPi(),
+		CPUCount:    runtime.NumCPU(),
+		LoadAverage: readLoadAverage(),
+		CPUTempC:    readCPUTemperature(),
+		Network:     readNetworkStats(),
+	}
+	if metrics.RaspberryPi {
+		metrics.Throttle = readThrottleStatus()
+		metrics.ArmClockMHz = readArmClock()
+	}
+	settings := loadStorageSettings()
+	for _, path := range storagePaths() {
+		metrics.Disk = append(metrics.Disk, measureFilesystem(path, settings))
+	}
+	return metrics
+}
+
+// readLoadAverage reads /proc/loadavg on Linux
+func readLoadAverage() []float64 {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return nil
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return nil
+	}
+	var load []float64
+	for _, field := range fields[:3] {
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil
+		}
+		load = append(load, value)
+	}
+	return load
+}
+
+// readCPUTemperature reads the SoC temperature from the kernel's thermal
+// zone, falling back to vcgencmd
+func readCPUTemperature() *float64 {
+	if data, err := os.ReadFile("/sys/class/thermal/thermal_zone0/temp"); err == nil {
+		if milli, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64); err == nil {
+			celsius := milli / 1000
+			return &celsius
+		}
+	}
+	// temp=48.3'C
+	if output, err := runVcgencmd("measure_temp"); err == nil {
+		value := strings.TrimSuffix(strings.TrimPrefix(output, "temp="), "'C")
+		if celsius, err := strconv.ParseFloat(value, 64); err == nil {
+			return &celsius
+		}
+	}
+	return nil
+}
+
+// readArmClock returns the current ARM clock, which drops when throttled
+func readArmClock() int {
+	// frequency(48)=1500398464
+	output, err := runVcgencmd("measure_clock", "arm")
+	if err != nil {
+		return 0
+	}
+	_, value, _ := strings.Cut(output, "=")
+	hz, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return int(hz / 1000000)
+}
+
+// readThrottleStatus decodes vcgencmd get_throttled
+func readThrottleStatus() *ThrottleStatus {
+	// throttled=0x50005
+	output, err := runVcgencmd("get_throttled")
+	if err != nil {
+		return nil
+	}
+	raw := strings.TrimPrefix(output, "throttled=")
+	value, err := strconv.ParseUint(strings.TrimPrefix(raw, "0x"), 16, 32)
+	if err != nil {
+		return nil
+	}
+	status := &ThrottleStatus{Raw: raw, Now: []string{}, SinceBoot: []string{}}
+	for _, flag := range throttleFlags {
+		if value&(1<<flag.bit) != 0 {
+			status.Now = append(status.Now, flag.name)
+		}
+		if value&(1<<(flag.bit+16)) != 0 {
+			status.SinceBoot = append(status.SinceBoot, flag.name)
+		}
+	}
+	return status
+}
+
+// runVcgencmd runs the Raspberry Pi firmware tool and returns its output
+func runVcgencmd(args ...string) (string, error) {
+	output, err := exec.Command("vcgencmd", args...).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// readNetworkStats reads the interface counters from /proc/net/dev on Linux,
+// leaving out loopback
+func readNetworkStats() []NetworkInterfaceStats {
+	file, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var stats []NetworkInterfaceStats
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, counters, ok := strings.Cut(scanner.Text(), ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "lo" {
+			continue
+		}
+		// rx: bytes packets errs drop fifo frame compressed multicast, then tx: bytes packets errs ...
+		fields := strings.Fields(counters)
+		if len(fields) < 11 {
+			continue
+		}
+		value := func(i int) uint64 {
+			n, _ := strconv.ParseUint(fields[i], 10, 64)
+			return n
+		}
+		stats = append(stats, NetworkInterfaceStats{
+			Name:      name,
+			RxBytes:   value(0),
+			RxErrors:  value(2),
+			RxDropped: value(3),
+			TxBytes:   value(8),
+			TxErrors:  value(10),
+		})
+	}
+	return stats
+}
+
+// describeThrottleFlags turns flag names into readable text
+func describeThrottleFlags(names []string) string {
+	var parts []string
+	for _, name := range names {
+		for _, flag := range throttleFlags {
+			if flag.name == name {
+				parts = append(parts, flag.text)
+			}
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// startHostMonitor polls the host metrics and alerts on throttling and high
+// temperature. Settings are re-read on every poll.
+func startHostMonitor() {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	go func() {
+		for {
+			settings := loadHostMonitorSettings()
+			if settings.Enabled {
+				checkHostMetrics(settings, collectHostMetrics())
+			}
+			time.Sleep(time.Duration(settings.IntervalSeconds) * time.Second)
+		}
+	}()
+}
+
+// checkHostMetrics records the latest metrics and alerts when throttling
+// starts or the temperature passes the warning threshold
+func checkHostMetrics(settings HostMonitorSettings, metrics *HostMetrics) {
+	var throttled []string
+	if metrics.Throttle != nil {
+		throttled = metrics.Throttle.Now
+	}
+	temperature := "unknown"
+	hot := false
+	if metrics.CPUTempC != nil {
+		temperature = fmt.Sprintf("%.1f°C", *metrics.CPUTempC)
+		hot = settings.TempWarnC > 0 && *metrics.CPUTempC >= settings.TempWarnC
+	}
+
+	hostMonitorState.mutex.Lock()
+	previous, wasHot := hostMonitorState.throttled, hostMonitorState.hot
+	hostMonitorState.throttled, hostMonitorState.hot = throttled, hot
+	hostMonitorState.latest = metrics
+	hostMonitorState.mutex.Unlock()
+
+	if current, before := strings.Join(throttled, ","), strings.Join(previous, ","); current != before {
+		if len(throttled) > 0 {
+			description := describeThrottleFlags(throttled)
+			log.Printf("⚠️  Raspberry Pi reports %s (SoC %s); audio may stutter", description, temperature)
+			notify(NotifyThrottling, current, "critical", "Raspberry Pi throttling",
+				fmt.Sprintf("The Pi reports %s at %s SoC temperature (%s). Playback may stutter until it cools down or the power supply is fixed.",
+					description, temperature, metrics.Throttle.Raw))
+		} else {
+			log.Printf("✓ Raspberry Pi no longer throttled (SoC %s)", temperature)
+		}
+	}
+	if hot && !wasHot {
+		log.Printf("⚠️  SoC temperature %s is at or above %.0f°C", temperature, settings.TempWarnC)
+		notify(NotifyThrottling, "temperature", "warning", "Annunciator running hot",
+			fmt.Sprintf("SoC temperature is %s, at or above the %.0f°C warning level. Check the booth's ventilation before the Pi starts throttling.", temperature, settings.TempWarnC))
+	} else if !hot && wasHot {
+		log.Printf("✓ SoC temperature back to %s", temperature)
+	}
+}
+
+// latestHostMetrics returns the last poll's metrics, or reads them now when
+// the monitor isn't running
+func latestHostMetrics() *HostMetrics {
+	hostMonitorState.mutex.Lock()
+	latest := hostMonitorState.latest
+	hostMonitorState.mutex.Unlock()
+	if latest != nil {
+		return latest
+	}
+	return collectHostMetrics()
+}
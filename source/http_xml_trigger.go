@@ -1,11 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -19,8 +19,7 @@ type HTTPXMLTrigger struct {
 	Config   HTTPXMLTriggerConfig `json:"config"`
 	
 	// Internal state
-	isRunning bool
-	stopChan  chan bool
+	loop      triggerLoop
 	lastFetch time.Time
 }
 
@@ -50,142 +49,144 @@ type HTTPXMLTriggerAction struct {
 	Parameters       map[string]string `json:"parameters,omitempty"`
 }
 
-// Global HTTP XML triggers
-var httpXMLTriggers []*HTTPXMLTrigger
+// newHTTPXMLTriggerFromDefinition builds an HTTP XML trigger from triggers.json
+func newHTTPXMLTriggerFromDefinition(definition TriggerDefinition) (Trigger, error) {
+	t := &HTTPXMLTrigger{
+		ID:      definition.ID,
+		Name:    definition.Name,
+		Type:    definition.Type,
+		Enabled: definition.Enabled,
+		Config:  HTTPXMLTriggerConfig{FetchInterval: 30, Timeout: 10},
+	}
+	if t.Name == "" {
+		t.Name = definition.ID
+	}
+	if len(definition.Settings) > 0 {
+		if err := t.Configure(definition.Settings); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
 
-// Initialize HTTP XML trigger system
-func initializeHTTPXMLTriggers() error {
-	// NOTE: systemConfig is not defined in this codebase
-	// This function is preserved but disabled to avoid compilation errors
-	log.Println("HTTP XML triggers disabled - systemConfig not available in this implementation")
+// Start begins polling the URL
+func (t *HTTPXMLTrigger) Start() error {
+	if t.Config.URL == "" {
+		return fmt.Errorf("no url configured")
+	}
+	t.Enabled = true
+	t.loop.start(t.Name, time.Duration(t.Config.FetchInterval)*time.Second, false, t.fetchAndCheck)
 	return nil
-	
-	/* Original code commented out to avoid compilation errors:
-	if systemConfig == nil || !systemConfig.TriggerConfig.Enabled {
-		log.Println("HTTP XML triggers disabled or not configured")
-		return nil
+}
+
+// Stop ends polling
+func (t *HTTPXMLTrigger) Stop() {
+	t.loop.stop()
+	t.Enabled = false
+}
+
+// Running reports whether the URL is being polled
+func (t *HTTPXMLTrigger) Running() bool {
+	return t.loop.running()
+}
+
+// Settings returns the settings stored in triggers.json
+func (t *HTTPXMLTrigger) Settings() interface{} {
+	return t.Config
+}
+
+// Configure replaces the URL, interval, monitors and actions, restarting the
+// poll if it was running
+func (t *HTTPXMLTrigger) Configure(raw json.RawMessage) error {
+	config := t.Config
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return err
 	}
-	
-	// Load HTTP XML triggers from configuration
-	for _, triggerConfig := range systemConfig.TriggerConfig.TriggerTypes {
-		if triggerConfig.Type == "http_xml" && triggerConfig.Enabled {
-			trigger := &HTTPXMLTrigger{
-				ID:      triggerConfig.ID,
-				Name:    triggerConfig.Name,
-				Type:    triggerConfig.Type,
-				Enabled: triggerConfig.Enabled,
-				stopChan: make(chan bool),
-			}
-			
-			// Parse config from Settings map
-			if configData, ok := triggerConfig.Settings["config"].(map[string]interface{}); ok {
-				trigger.Config = HTTPXMLTriggerConfig{
-					URL:           getStringValue(configData, "url"),
-					FetchInterval: getIntValue(configData, "fetch_interval"),
-					Timeout:       getIntValue(configData, "timeout"),
-				}
-			} else {
-				// Try direct access to settings
-				trigger.Config = HTTPXMLTriggerConfig{
-					URL:           getStringValue(triggerConfig.Settings, "url"),
-					FetchInterval: getIntValue(triggerConfig.Settings, "fetch_interval"),
-					Timeout:       getIntValue(triggerConfig.Settings, "timeout"),
-				}
-			}
-			
-			// Parse monitors and actions from the trigger settings
-			// For now, use defaults since the JSON structure may not match perfectly
-			// This can be configured properly through the admin interface later
-			trigger.Config.Monitors = []HTTPXMLMonitor{
-				{
-					ID:            "default_monitor",
-					XPath:         "//status/text()",
-					TriggerValues: []string{"alert", "emergency"},
-					Comparison:    "equals",
-				},
-			}
-			
-			trigger.Config.Actions = []HTTPXMLTriggerAction{
-				{
-					AnnouncementType: "safety",
-					Message:          "System alert detected from {trigger}",
-				},
-			}
-			
-			httpXMLTriggers = append(httpXMLTriggers, trigger)
-			
-			// Start the trigger
-			if trigger.Enabled {
-				go trigger.Start()
-				log.Printf("Started HTTP XML trigger: %s (%s)", trigger.Name, trigger.Config.URL)
-			}
+	if config.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	if config.FetchInterval < 5 {
+		return fmt.Errorf("fetch_interval must be at least 5 seconds")
+	}
+	if config.Timeout < 1 {
+		return fmt.Errorf("timeout must be at least 1 second")
+	}
+	for _, monitor := range config.Monitors {
+		switch monitor.Comparison {
+		case "equals", "contains", "not_equals":
+		default:
+			return fmt.Errorf("monitor '%s': comparison must be equals, contains or not_equals", monitor.ID)
 		}
 	}
 	
-	log.Printf("✓ HTTP XML trigger system initialized with %d triggers", len(httpXMLTriggers))
+	wasRunning := t.Running()
+	if wasRunning {
+		t.loop.stop()
+	}
+	t.Config = config
+	if wasRunning {
+		return t.Start()
+	}
 	return nil
-	*/
 }
 
-// Start the HTTP XML trigger monitoring
-func (t *HTTPXMLTrigger) Start() {
-	if t.isRunning {
-		return
+// Test fetches the URL once and reports each monitor's value and whether it
+// would fire. With a value, the actions are run as if a monitor had fired on it.
+func (t *HTTPXMLTrigger) Test(value string) (map[string]interface{}, error) {
+	if value != "" {
+		if len(t.Config.Monitors) == 0 {
+			return nil, fmt.Errorf("trigger has no monitors")
+		}
+		monitor := t.Config.Monitors[0]
+		fired := t.checkTriggerCondition(monitor, value)
+		if fired {
+			t.executeActions(monitor, value)
+		}
+		return map[string]interface{}{"monitor": monitor.ID, "value": value, "fired": fired}, nil
 	}
 	
-	t.isRunning = true
-	ticker := time.NewTicker(time.Duration(t.Config.FetchInterval) * time.Second)
-	defer ticker.Stop()
-	
-	log.Printf("HTTP XML trigger '%s' started with %d second interval", t.Name, t.Config.FetchInterval)
-	
-	for {
-		select {
-		case <-ticker.C:
-			t.fetchAndCheck()
-		case <-t.stopChan:
-			t.isRunning = false
-			log.Printf("HTTP XML trigger '%s' stopped", t.Name)
-			return
-		}
+	xmlData, err := t.fetchXML()
+	if err != nil {
+		return nil, err
 	}
-}
-
-// Stop the HTTP XML trigger
-func (t *HTTPXMLTrigger) Stop() {
-	if t.isRunning {
-		close(t.stopChan)
+	monitors := make([]map[string]interface{}, 0, len(t.Config.Monitors))
+	for _, monitor := range t.Config.Monitors {
+		current := t.extractValueFromXML(xmlData, monitor.XPath)
+		monitors = append(monitors, map[string]interface{}{
+			"id":          monitor.ID,
+			"value":       current,
+			"would_fire":  current != "" && t.checkTriggerCondition(monitor, current),
+		})
 	}
+	return map[string]interface{}{"monitors": monitors}, nil
 }
 
-// Fetch XML and check for trigger conditions
-func (t *HTTPXMLTrigger) fetchAndCheck() {
-	defer func() {
-		t.lastFetch = time.Now()
-	}()
-	
-	// Create HTTP client with timeout
+// fetchXML downloads the document
+func (t *HTTPXMLTrigger) fetchXML() ([]byte, error) {
 	client := &http.Client{
 		Timeout: time.Duration(t.Config.Timeout) * time.Second,
 	}
-	
-	// Fetch XML
 	resp, err := client.Get(t.Config.URL)
 	if err != nil {
-		log.Printf("HTTP XML trigger '%s' fetch error: %v", t.Name, err)
-		return
+		return nil, err
 	}
 	defer resp.Body.Close()
 	
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("HTTP XML trigger '%s' received status %d", t.Name, resp.StatusCode)
-		return
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
 	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Fetch XML and check for trigger conditions
+func (t *HTTPXMLTrigger) fetchAndCheck() {
+	defer func() {
+		t.lastFetch = time.Now()
+	}()
 	
-	// Read response body
-	xmlData, err := ioutil.ReadAll(resp.Body)
+	xmlData, err := t.fetchXML()
 	if err != nil {
-		log.Printf("HTTP XML trigger '%s' read error: %v", t.Name, err)
+		log.Printf("HTTP XML trigger '%s' fetch error: %v", t.Name, err)
 		return
 	}
 	
@@ -196,8 +197,12 @@ func (t *HTTPXMLTrigger) fetchAndCheck() {
 			continue
 		}
 		
-		// Store the current value
+		// Store the current value; a change is published to the trigger rules
+		previous := t.Config.Monitors[i].LastValue
 		t.Config.Monitors[i].LastValue = value
+		if value != previous {
+			publishTriggerEvent(TriggerEvent{Source: t.ID, Type: "http_xml", Field: monitor.ID, Value: value, Previous: previous})
+		}
 		
 		// Check if trigger condition is met
 		if t.checkTriggerCondition(monitor, value) {
@@ -305,8 +310,10 @@ func (t *HTTPXMLTrigger) executeActions(monitor HTTPXMLMonitor, triggerValue str
 			// Get priority based on announcement type
 			priority := AnnouncementPriority(getAnnouncementTypePriority(action.AnnouncementType))
 			
-			announcement, err := announcementManager.QueueAnnouncement(announcementType, priority, parameters, time.Now())
-			if err != nil {
+			announcement, err := queueTriggerAnnouncement(t.ID, announcementType, priority, parameters)
+			if isTriggerRateLimited(err) {
+				log.Printf("HTTP XML trigger announcement held back: %v", err)
+			} else if err != nil {
 				log.Printf("Failed to queue HTTP XML trigger announcement: %v", err)
 			} else {
 				log.Printf("Queued HTTP XML trigger announcement: %s (ID: %s)", message, announcement.ID)
@@ -333,79 +340,29 @@ func getAnnouncementTypePriority(announcementType string) int {
 	}
 }
 
-// Stop all HTTP XML triggers
-func stopHTTPXMLTriggers() {
-	for _, trigger := range httpXMLTriggers {
-		trigger.Stop()
-	}
-	httpXMLTriggers = nil
-}
-
-// Get HTTP XML trigger status for API
-func getHTTPXMLTriggerStatus() []map[string]interface{} {
-	status := make([]map[string]interface{}, 0)
-	
-	for _, trigger := range httpXMLTriggers {
-		triggerStatus := map[string]interface{}{
-			"id":             trigger.ID,
-			"name":           trigger.Name,
-			"enabled":        trigger.Enabled,
-			"running":        trigger.isRunning,
-			"url":            trigger.Config.URL,
-			"fetch_interval": trigger.Config.FetchInterval,
-			"last_fetch":     trigger.lastFetch.Format("2006-01-02 15:04:05"),
-			"monitors":       make([]map[string]interface{}, 0),
-		}
-		
-		for _, monitor := range trigger.Config.Monitors {
-			monitorStatus := map[string]interface{}{
-				"id":               monitor.ID,
-				"xpath":            monitor.XPath,
-				"last_value":       monitor.LastValue,
-				"triggered_count":  monitor.TriggeredCount,
-				"trigger_values":   monitor.TriggerValues,
-				"comparison":       monitor.Comparison,
-			}
-			triggerStatus["monitors"] = append(triggerStatus["monitors"].([]map[string]interface{}), monitorStatus)
-		}
-		
-		status = append(status, triggerStatus)
+// Status describes the trigger and its monitors for the API
+func (t *HTTPXMLTrigger) Status() map[string]interface{} {
+	triggerStatus := map[string]interface{}{
+		"id":             t.ID,
+		"name":           t.Name,
+		"enabled":        t.Enabled,
+		"running":        t.Running(),
+		"url":            t.Config.URL,
+		"fetch_interval": t.Config.FetchInterval,
+		"last_fetch":     t.lastFetch.Format("2006-01-02 15:04:05"),
+		"monitors":       make([]map[string]interface{}, 0),
 	}
 	
-	return status
-}
-
-// Helper functions to safely extract values from configuration maps
-func getStringValue(config map[string]interface{}, key string) string {
-	if val, ok := config[key]; ok {
-		if str, ok := val.(string); ok {
-			return str
+	for _, monitor := range t.Config.Monitors {
+		monitorStatus := map[string]interface{}{
+			"id":               monitor.ID,
+			"xpath":            monitor.XPath,
+			"last_value":       monitor.LastValue,
+			"triggered_count":  monitor.TriggeredCount,
+			"trigger_values":   monitor.TriggerValues,
+			"comparison":       monitor.Comparison,
 		}
+		triggerStatus["monitors"] = append(triggerStatus["monitors"].([]map[string]interface{}), monitorStatus)
 	}
-	return ""
+	return triggerStatus
 }
-
-func getIntValue(config map[string]interface{}, key string) int {
-	if val, ok := config[key]; ok {
-		switch v := val.(type) {
-		case int:
-			return v
-		case float64:
-			return int(v)
-		case string:
-			// Try to parse string as int
-			if i, err := strconv.Atoi(v); err == nil {
-				return i
-			}
-		}
-	}
-	// Return sensible defaults
-	switch key {
-	case "fetch_interval":
-		return 30
-	case "timeout":
-		return 10
-	default:
-		return 0
-	}
-}
\ No newline at end of file
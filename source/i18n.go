@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Locale describes one announcement language: where its audio segments live
+// and how its sentences are put together
+type Locale struct {
+	Name string `json:"name"`
+	// DirSuffix is appended to every segment directory, e.g. "_es" reads
+	// train_es/4.mp3 instead of train/4.mp3
+	DirSuffix string `json:"dir_suffix"`
+	// SegmentDirs overrides the directory for individual segment sets
+	SegmentDirs map[string]string `json:"segment_dirs"`
+	// Templates override the default segment order per announcement type
+	Templates map[string][]string `json:"templates"`
+	// Strings are translated UI and display text keyed by message ID
+	Strings map[string]string `json:"strings"`
+	// Captions are the words spoken by each segment, keyed by segment as in
+	// the templates, e.g. "service_change/attention" or "train/4"
+	Captions map[string]string `json:"captions"`
+}
+
+// LocaleSettings is loaded from json/locales.json
+type LocaleSettings struct {
+	DefaultLocale string            `json:"default_locale"`
+	Locales       map[string]Locale `json:"locales"`
+	// Templates list the audio segments for each announcement type.
+	// "{param}" is replaced with the announcement parameter, e.g.
+	// "train/{train_number}" plays mp3/train/<train_number>.mp3. A segment
+	// ending in "?" is skipped when its parameter is empty.
+	Templates map[string][]string `json:"templates"`
+	// TypeLanguages lists the locales sequenced for each type when the
+	// announcement or schedule entry does not choose its own
+	TypeLanguages map[string][]string `json:"type_languages"`
+}
+
+var (
+	localeSettings      *LocaleSettings
+	localeSettingsMutex sync.RWMutex
+)
+
+var templatePlaceholder = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// getDefaultLocaleSettings reproduces the original English-only sequences
+func getDefaultLocaleSettings() *LocaleSettings {
+	return &LocaleSettings{
+		DefaultLocale: "en",
+		Locales: map[string]Locale{
+			"en": {Name: "English"},
+		},
+		Templates: map[string][]string{
+			string(TypeStation): {"train/{train_number}", "direction/{direction}", "destination/{destination}", "track/{track_number}"},
+			string(TypePromo):   {"promo/{file}"},
+			// "Attention please. Train 4 will now depart from track 1 instead of track 2, due to track work."
+			string(TypeServiceChange): {"service_change/attention", "train/{train_number}", "service_change/will_now_depart_from", "track/{new_track}", "service_change/instead_of", "track/{old_track}", "reason/{reason}?"},
+			// "Attention please. Train 4 is delayed by 15 minutes, due to weather conditions."
+			string(TypeDelay):        {"service_change/attention", "train/{train_number}", "delay/{delay_minutes}", "reason/{reason}?"},
+			string(TypeCancellation): {"service_change/attention", "train/{train_number}", "service_change/has_been_cancelled", "reason/{reason}?"},
+			// "The 3:30 train to Lakeview departs in five minutes."
+			string(TypeCountdown): {"countdown/the", "clock/hour_{hour}", "clock/minute_{minute}", "countdown/train_to", "destination/{destination}", "countdown/departs_in", "number/{minutes}", "countdown/{unit}"},
+		},
+		TypeLanguages: map[string][]string{},
+	}
+}
+
+// loadLocaleSettings reads locales.json, falling back to defaults
+func loadLocaleSettings() error {
+	settings := getDefaultLocaleSettings()
+	data, err := os.ReadFile(filepath.Join(app.Config.JSONDir, "locales.json"))
+	if os.IsNotExist(err) {
+		setLocaleSettings(settings)
+		return nil
+	}
+	if err != nil {
+		setLocaleSettings(settings)
+		return fmt.Errorf("failed to read locales.json: %v", err)
+	}
+
+	if err := json.Unmarshal(data, settings); err != nil {
+		setLocaleSettings(getDefaultLocaleSettings())
+		return fmt.Errorf("failed to parse locales.json: %v", err)
+	}
+	if _, ok := settings.Locales[settings.DefaultLocale]; !ok {
+		setLocaleSettings(getDefaultLocaleSettings())
+		return fmt.Errorf("locales.json default_locale %q is not defined", settings.DefaultLocale)
+	}
+
+	setLocaleSettings(settings)
+	log.Printf("✓ Loaded %d announcement locales (default %s)", len(settings.Locales), settings.DefaultLocale)
+	return nil
+}
+
+func setLocaleSettings(settings *LocaleSettings) {
+	localeSettingsMutex.Lock()
+	localeSettings = settings
+	localeSettingsMutex.Unlock()
+}
+
+// getLocaleSettings returns the current locale settings (defaults if not loaded)
+func getLocaleSettings() *LocaleSettings {
+	localeSettingsMutex.RLock()
+	defer localeSettingsMutex.RUnlock()
+	if localeSettings == nil {
+		return getDefaultLocaleSettings()
+	}
+	return localeSettings
+}
+
+// templateFor returns the segment template of a type in a locale
+func (s *LocaleSettings) templateFor(announcementType AnnouncementType, locale string) ([]string, bool) {
+	if template, ok := s.Locales[locale].Templates[string(announcementType)]; ok {
+		return template, true
+	}
+	template, ok := s.Templates[string(announcementType)]
+	return template, ok
+}
+
+// segmentDir maps a segment set (e.g. "train") to its directory for a locale
+func (s *LocaleSettings) segmentDir(locale, set string) string {
+	definition := s.Locales[locale]
+	if dir, ok := definition.SegmentDirs[set]; ok {
+		return dir
+	}
+	return set + definition.DirSuffix
+}
+
+// languageList reads a languages value given as a list or a comma separated string
+func languageList(value interface{}) []string {
+	switch value := value.(type) {
+	case []string:
+		return value
+	case []interface{}:
+		return claimStrings(value)
+	case string:
+		return strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+	return nil
+}
+
+// announcementLanguages picks the locales to sequence: the announcement's own
+// "languages" parameter, then the type's configured list, then the default
+func announcementLanguages(announcementType AnnouncementType, parameters map[string]interface{}) []string {
+	if languages := languageList(parameters["languages"]); len(languages) > 0 {
+		return languages
+	}
+
+	settings := getLocaleSettings()
+	if configured := settings.TypeLanguages[string(announcementType)]; len(configured) > 0 {
+		return configured
+	}
+	return []string{settings.DefaultLocale}
+}
+
+// validateLanguages reports the first language that has no locale definition
+func validateLanguages(languages []string) error {
+	settings := getLocaleSettings()
+	for _, language := range languages {
+		if _, ok := settings.Locales[language]; !ok {
+			return fmt.Errorf("unknown language '%s'", language)
+		}
+	}
+	return nil
+}
+
+// templateSegment is one clip of a composed announcement, e.g. set "train"
+// and name "4" for train/{train_number}
+type templateSegment struct {
+	Language string
+	Set      string
+	Name     string
+	Path     string // Set and name as written in the template, "train/4"
+}
+
+// localizedSegments fills in an announcement's type template in each selected
+// language, back to back. It returns handled=false for types without a
+// template so the caller can use its own composition.
+func localizedSegments(announcementType AnnouncementType, parameters map[string]interface{}) ([]templateSegment, bool, error) {
+	settings := getLocaleSettings()
+	if _, ok := settings.templateFor(announcementType, settings.DefaultLocale); !ok {
+		return nil, false, nil
+	}
+
+	languages := announcementLanguages(announcementType, parameters)
+	if err := validateLanguages(languages); err != nil {
+		return nil, true, err
+	}
+
+	var segments []templateSegment
+	for _, language := range languages {
+		template, _ := settings.templateFor(announcementType, language)
+		for _, segment := range template {
+			optional := strings.HasSuffix(segment, "?")
+			segment = strings.TrimSuffix(segment, "?")
+			var missing, invalid string
+			path := templatePlaceholder.ReplaceAllStringFunc(segment, func(match string) string {
+				name := match[1 : len(match)-1]
+				value := fmt.Sprint(parameters[name])
+				if parameters[name] == nil || value == "" {
+					missing = name
+				} else if !validPathSegment(value) {
+					invalid = name
+				}
+				return value
+			})
+			if missing != "" && optional {
+				continue
+			}
+			if missing != "" {
+				return nil, true, fmt.Errorf("%s announcement requires '%s' parameter", announcementType, missing)
+			}
+			if invalid != "" {
+				return nil, true, fmt.Errorf("%s announcement parameter '%s' must be an ID of letters, digits, '_' or '-'", announcementType, invalid)
+			}
+
+			set, name := "", path
+			if slash := strings.Index(path, "/"); slash >= 0 {
+				set, name = path[:slash], path[slash+1:]
+			}
+			segments = append(segments, templateSegment{Language: language, Set: set, Name: name, Path: path})
+		}
+	}
+	return segments, true, nil
+}
+
+// localizedAudioSequence composes an announcement's audio from its type
+// template (see localizedSegments)
+func localizedAudioSequence(announcementType AnnouncementType, parameters map[string]interface{}) ([]string, bool, error) {
+	segments, handled, err := localizedSegments(announcementType, parameters)
+	if !handled || err != nil {
+		return nil, handled, err
+	}
+
+	settings := getLocaleSettings()
+	var audioFiles []string
+	for _, segment := range segments {
+		file, err := resolveAudioPath(filepath.Join(settings.segmentDir(segment.Language, segment.Set), segment.Name+".mp3"))
+		if err != nil {
+			return nil, true, err
+		}
+
+		// The default language keeps the old behaviour of failing at playback;
+		// other languages are checked now so a missing translation is reported
+		if segment.Language != settings.DefaultLocale && !fileExists(file) {
+			return nil, true, fmt.Errorf("no %s audio for %s (%s)", segment.Language, segment.Path, file)
+		}
+		audioFiles = append(audioFiles, file)
+	}
+	return audioFiles, true, nil
+}
+
+// translate returns a UI string in the locale, falling back to the default
+// locale and then to the given English text
+func translate(locale, key, fallback string) string {
+	settings := getLocaleSettings()
+	if text, ok := settings.Locales[locale].Strings[key]; ok && text != "" {
+		return text
+	}
+	if text, ok := settings.Locales[settings.DefaultLocale].Strings[key]; ok && text != "" {
+		return text
+	}
+	return fallback
+}
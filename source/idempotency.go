@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Default replay window when admin_config.json does not set one
+const defaultIdempotencyWindow = 60 * time.Minute
+
+// idempotencyEntry is the stored outcome of the first request with a key
+type idempotencyEntry struct {
+	requestHash string
+	status      int
+	contentType string
+	body        []byte
+	createdAt   time.Time
+	inFlight    bool
+}
+
+// IdempotencyStore remembers responses to announcement POSTs by
+// Idempotency-Key so client retries do not queue duplicates
+type IdempotencyStore struct {
+	mutex   sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+var idempotencyStore = &IdempotencyStore{
+	entries: make(map[string]*idempotencyEntry),
+}
+
+// expire drops entries older than the window. Caller must hold the mutex.
+func (s *IdempotencyStore) expire(window time.Duration) {
+	cutoff := time.Now().Add(-window)
+	for key, entry := range s.entries {
+		if !entry.inFlight && entry.createdAt.Before(cutoff) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// idempotencyWindow reads the replay window from admin_config.json
+func idempotencyWindow() time.Duration {
+	adminConfig, err := loadAdminConfig(filepath.Join(app.Config.JSONDir, "admin_config.json"))
+	if err != nil || adminConfig.API.IdempotencyWindowMinutes <= 0 {
+		return defaultIdempotencyWindow
+	}
+	return time.Duration(adminConfig.API.IdempotencyWindowMinutes) * time.Minute
+}
+
+// idempotencyScope identifies the caller so keys from different clients never collide
+func idempotencyScope(c *gin.Context) string {
+	if data, exists := c.Get("api_key_data"); exists {
+		if apiKeyData, ok := data.(*APIKey); ok {
+			return apiKeyData.ID
+		}
+	}
+	return "shared"
+}
+
+// capturingWriter records the response body so it can be replayed
+type capturingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *capturingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *capturingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotencyMiddleware replays the original response for a repeated
+// Idempotency-Key within the configured window
+func idempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			respondValidationError(c, "Invalid Idempotency-Key", FieldError{Field: "Idempotency-Key", Message: "must be at most 255 characters"})
+			return
+		}
+
+		// Hash the body so a reused key with a different payload is rejected
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Failed to read request body")
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(c.Request.Method+" "+c.Request.URL.Path+"\n"), body...))
+		requestHash := hex.EncodeToString(sum[:])
+
+		storeKey := idempotencyScope(c) + "|" + c.Request.URL.Path + "|" + key
+		window := idempotencyWindow()
+
+		idempotencyStore.mutex.Lock()
+		idempotencyStore.expire(window)
+		if entry, exists := idempotencyStore.entries[storeKey]; exists {
+			idempotencyStore.mutex.Unlock()
+
+			if entry.requestHash != requestHash {
+				respondValidationError(c, "Idempotency-Key was already used with a different request", FieldError{Field: "Idempotency-Key", Message: "reused with a different payload"})
+				return
+			}
+			if entry.inFlight {
+				respondError(c, http.StatusConflict, ErrCodeConflict, "A request with this Idempotency-Key is still being processed")
+				return
+			}
+
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(entry.status, entry.contentType, entry.body)
+			c.Abort()
+			return
+		}
+		idempotencyStore.entries[storeKey] = &idempotencyEntry{
+			requestHash: requestHash,
+			createdAt:   time.Now(),
+			inFlight:    true,
+		}
+		idempotencyStore.mutex.Unlock()
+
+		writer := &capturingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		// A handler that panics is forgotten like a server error, rather
+		// than leaving the key in flight for good; the panic carries on to
+		// crash recovery
+		finished := false
+		defer func() {
+			if !finished {
+				idempotencyStore.mutex.Lock()
+				delete(idempotencyStore.entries, storeKey)
+				idempotencyStore.mutex.Unlock()
+			}
+		}()
+		c.Next()
+		finished = true
+
+		idempotencyStore.mutex.Lock()
+		defer idempotencyStore.mutex.Unlock()
+
+		// Server errors are not remembered so the client can retry them
+		status := writer.Status()
+		if status >= http.StatusInternalServerError {
+			delete(idempotencyStore.entries, storeKey)
+			return
+		}
+		entry := idempotencyStore.entries[storeKey]
+		entry.status = status
+		entry.contentType = writer.Header().Get("Content-Type")
+		entry.body = writer.body.Bytes()
+		entry.createdAt = time.Now()
+		entry.inFlight = false
+	}
+}
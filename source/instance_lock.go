@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Only one copy runs against a configuration directory. The first takes an
+// exclusive lock on tarr-annunciator.lock there and holds it until it exits;
+// the operating system lets go of the lock however the process ends, so a
+// lock file left behind by a crash doesn't stop the next start. The file
+// records the holder for the error a second copy gives.
+//
+// The listen address is bound before anything else starts too, so a port
+// already taken stops startup with an error rather than after the audio
+// device, scheduler and triggers are up. A relaunched process retries both
+// while the process it replaces exits.
+
+const instanceLockFile = "tarr-annunciator.lock"
+
+// instanceHolder is what the lock file records
+type instanceHolder struct {
+	PID        int       `json:"pid"`
+	ListenAddr string    `json:"listen_addr"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+// instanceLock is held for the life of the process
+var instanceLock *os.File
+
+// acquireInstanceLock locks the configuration directory for this process
+func acquireInstanceLock(jsonDir, listenAddr string) error {
+	if err := os.MkdirAll(jsonDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", jsonDir, err)
+	}
+	path := filepath.Join(jsonDir, instanceLockFile)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	if err := retryWhileRelaunching(func() error { return lockFile(file) }); err != nil {
+		file.Close()
+		var holder instanceHolder
+		if data, readErr := os.ReadFile(path); readErr == nil && json.Unmarshal(data, &holder) == nil && holder.PID != 0 {
+			return fmt.Errorf("another copy is already running from %s (PID %d, listening on %s, started %s); stop it first, or give this copy its own -json-dir",
+				jsonDir, holder.PID, holder.ListenAddr, holder.StartedAt.Format("2006-01-02 15:04:05"))
+		}
+		return fmt.Errorf("another copy is already running from %s (%s is locked: %v)", jsonDir, path, err)
+	}
+
+	data, _ := json.Marshal(instanceHolder{PID: os.Getpid(), ListenAddr: listenAddr, StartedAt: time.Now()})
+	file.Truncate(0)
+	file.WriteAt(append(data, '\n'), 0)
+	instanceLock = file
+	return nil
+}
+
+// openListener binds the listen address, explaining a port already in use
+func openListener(addr string) (net.Listener, error) {
+	var listener net.Listener
+	err := retryWhileRelaunching(func() error {
+		var err error
+		listener, err = net.Listen("tcp", addr)
+		return err
+	})
+	if err != nil {
+		if isAddrInUse(err) {
+			return nil, fmt.Errorf("%s is already in use by another program; stop it or choose another address with -port or -listen (%v)", addr, err)
+		}
+		return nil, fmt.Errorf("cannot listen on %s: %v", addr, err)
+	}
+	// Started; a later relaunch sets it again
+	os.Unsetenv(relaunchEnv)
+	return listener, nil
+}
+
+// isAddrInUse reports whether a listen failed because the address is taken
+func isAddrInUse(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	return errno == syscall.EADDRINUSE || errno == 10048 // WSAEADDRINUSE on Windows
+}
+
+// retryWhileRelaunching runs attempt once, or in a relaunched process until
+// it succeeds or relaunchWait passes
+func retryWhileRelaunching(attempt func() error) error {
+	deadline := time.Now().Add(relaunchWait)
+	for {
+		err := attempt()
+		if err == nil || os.Getenv(relaunchEnv) == "" || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
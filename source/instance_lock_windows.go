@@ -0,0 +1,35 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var lockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+)
+
+// lockFile takes an exclusive lock on file without waiting for it. The
+// locked byte is far past the end of the file, so others can still read who
+// holds it.
+func lockFile(file *os.File) error {
+	overlapped := syscall.Overlapped{OffsetHigh: 1}
+	ok, _, callErr := lockFileEx.Call(
+		file.Fd(),
+		lockfileExclusiveLock|lockfileFailImmediately,
+		0,
+		1,
+		0,
+		uintptr(unsafe.Pointer(&overlapped)),
+	)
+	if ok == 0 {
+		return callErr
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+)
+
+// Every JSON file in the JSON directory is registered in jsonFiles under the
+// logical name used with loadJSON, saveJSON and jsonFilePath. The entry names
+// the file and, for files loadJSON decodes, the type they hold and the key a
+// wrapped list sits under. Adding a file is one entry here.
+
+// jsonFile describes a registered JSON file
+type jsonFile struct {
+	FileName   string
+	WrapperKey string // Top-level key holding the list ("" for a bare array)
+	// decode parses the file for loadJSON; nil for files whose callers read
+	// and decode them themselves
+	decode func(data []byte) (interface{}, error)
+}
+
+// listFile registers a file holding a list of T, either wrapped in an object
+// under wrapperKey or as a bare array
+func listFile[T any](fileName, wrapperKey string) jsonFile {
+	return jsonFile{
+		FileName:   fileName,
+		WrapperKey: wrapperKey,
+		decode: func(data []byte) (interface{}, error) {
+			items, err := decodeJSONList[T](data, wrapperKey)
+			return items, err
+		},
+	}
+}
+
+// objectFile registers a file holding a single T
+func objectFile[T any](fileName string) jsonFile {
+	return jsonFile{
+		FileName: fileName,
+		decode: func(data []byte) (interface{}, error) {
+			var value T
+			err := json.Unmarshal(data, &value)
+			return value, err
+		},
+	}
+}
+
+// plainFile registers a file that loadJSON doesn't decode
+func plainFile(fileName string) jsonFile {
+	return jsonFile{FileName: fileName}
+}
+
+var jsonFiles = map[string]jsonFile{
+	// Catalogs
+	"trains":                 listFile[Train]("trains_selected.json", "trains"),
+	"trains_available":       listFile[Train]("trains_available.json", "trains"),
+	"directions":             listFile[Direction]("directions.json", "directions"),
+	"destinations":           listFile[Destination]("destinations_selected.json", "destinations"),
+	"destinations_available": listFile[Destination]("destinations_available.json", "destinations"),
+	"tracks":                 listFile[Track]("tracks.json", "tracks"),
+	"promo":                  listFile[PromoAnnouncement]("promo.json", "promo"),
+	"safety":                 listFile[SafetyLanguage]("safety.json", "safety"),
+	"emergencies":            listFile[Emergency]("emergencies.json", "emergencies"),
+	"service_change_reasons": listFile[CatalogItem]("service_change_reasons.json", "reasons"),
+
+	// Schedule
+	"cron":              objectFile[CronData]("cron.json"),
+	"schedule_changes":  plainFile("schedule_changes.json"),
+	"schedule_profiles": plainFile("schedule_profiles.json"),
+	"event_scripts":     plainFile("event_scripts.json"),
+
+	// Operations and integrations
+	"departure_status":      plainFile("departure_status.json"),
+	"track_layout":          plainFile("track_layout.json"),
+	"triggers":              plainFile("triggers.json"),
+	"trigger_rules":         plainFile("trigger_rules.json"),
+	"weather":               plainFile("weather.json"),
+	"pronunciations":        plainFile("pronunciations.json"),
+	"announcement_switches": plainFile("announcement_switches.json"),
+	"maintenance":           plainFile("maintenance.json"),
+	"content_filter":        plainFile("content_filter.json"),
+	"schedule_holds":        plainFile("schedule_holds.json"),
+}
+
+// jsonFilePath maps a logical JSON name to its file in the JSON directory
+func jsonFilePath(name string) (string, bool) {
+	file, ok := jsonFiles[name]
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(app.Config.JSONDir, file.FileName), true
+}
+
+// decodeJSONList parses a list stored under wrapperKey, falling back to a
+// bare array. The wrapper may hold other settings beside the list.
+func decodeJSONList[T any](data []byte, wrapperKey string) ([]T, error) {
+	if wrapperKey != "" {
+		var wrapper map[string]json.RawMessage
+		if json.Unmarshal(data, &wrapper) == nil {
+			if raw, ok := wrapper[wrapperKey]; ok {
+				var items []T
+				err := json.Unmarshal(raw, &items)
+				return items, err
+			}
+		}
+	}
+	var items []T
+	err := json.Unmarshal(data, &items)
+	return items, err
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useTempJSONDir points the app's JSON directory at a fresh temporary one
+// for the duration of the test
+func useTempJSONDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	previous := app
+	app = &App{Config: &Config{JSONDir: dir}}
+	t.Cleanup(func() { app = previous })
+	return dir
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJSONFilesRegistry(t *testing.T) {
+	seen := make(map[string]string)
+	for name, file := range jsonFiles {
+		if !strings.HasSuffix(file.FileName, ".json") || file.FileName != filepath.Base(file.FileName) {
+			t.Errorf("%s: file name %q is not a plain .json file", name, file.FileName)
+		}
+		if other, ok := seen[file.FileName]; ok {
+			t.Errorf("%s and %s are both registered as %s", name, other, file.FileName)
+		}
+		seen[file.FileName] = name
+		if file.WrapperKey != "" && file.decode == nil {
+			t.Errorf("%s has a wrapper key but no decoder", name)
+		}
+	}
+
+	for _, def := range catalogDefinitions {
+		file, ok := jsonFiles[def.JSONName]
+		if !ok {
+			t.Errorf("catalog %s uses unregistered JSON name %s", def.Name, def.JSONName)
+			continue
+		}
+		if file.decode == nil {
+			t.Errorf("catalog %s has no decoder", def.Name)
+		}
+	}
+	if _, ok := jsonFiles["cron"]; !ok {
+		t.Error("cron is not registered")
+	}
+}
+
+func TestJSONFilePath(t *testing.T) {
+	dir := useTempJSONDir(t)
+
+	path, ok := jsonFilePath("emergencies")
+	if !ok || path != filepath.Join(dir, "emergencies.json") {
+		t.Errorf("jsonFilePath(emergencies) = %q, %v", path, ok)
+	}
+	if path, ok := jsonFilePath("no_such_file"); ok || path != "" {
+		t.Errorf("jsonFilePath(no_such_file) = %q, %v; want not found", path, ok)
+	}
+}
+
+func TestDecodeJSONList(t *testing.T) {
+	want := []Train{{ID: "1", Name: "One"}, {ID: "2", Name: "Two"}}
+	tests := []struct {
+		name       string
+		data       string
+		wrapperKey string
+		want       []Train
+		wantErr    bool
+	}{
+		{"wrapped", `{"trains": [{"id": "1", "name": "One"}, {"id": "2", "name": "Two"}]}`, "trains", want, false},
+		{"wrapped with other settings", `{"enabled": true, "trains": [{"id": "1", "name": "One"}, {"id": "2", "name": "Two"}]}`, "trains", want, false},
+		{"bare array with a wrapper key", `[{"id": "1", "name": "One"}, {"id": "2", "name": "Two"}]`, "trains", want, false},
+		{"bare array without a wrapper key", `[{"id": "1", "name": "One"}, {"id": "2", "name": "Two"}]`, "", want, false},
+		{"empty list", `{"trains": []}`, "trains", []Train{}, false},
+		{"wrapped without a wrapper key", `{"trains": []}`, "", nil, true},
+		{"wrong wrapper key", `{"other": []}`, "trains", nil, true},
+		{"invalid JSON", `[{"id": `, "trains", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeJSONList[Train]([]byte(tt.data), tt.wrapperKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadJSONEmergencyFormats(t *testing.T) {
+	want := []Emergency{{ID: "severe_weather", Name: "Severe Weather Warning", Description: "Take shelter", Category: "Weather"}}
+	item := `{"id": "severe_weather", "name": "Severe Weather Warning", "description": "Take shelter", "category": "Weather"}`
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"bare array", "[" + item + "]"},
+		{"wrapped", `{"emergencies": [` + item + `]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := useTempJSONDir(t)
+			writeTestFile(t, filepath.Join(dir, "emergencies.json"), tt.data)
+
+			got := loadJSON("emergencies", []Emergency{}).([]Emergency)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadJSONDefaults(t *testing.T) {
+	dir := useTempJSONDir(t)
+	fallback := []Train{{ID: "default"}}
+
+	if got := loadJSON("trains", fallback).([]Train); !reflect.DeepEqual(got, fallback) {
+		t.Errorf("missing file: got %+v, want the default", got)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "trains_selected.json"), `{"trains": [`)
+	if got := loadJSON("trains", fallback).([]Train); !reflect.DeepEqual(got, fallback) {
+		t.Errorf("invalid file: got %+v, want the default", got)
+	}
+
+	if got := loadJSON("no_such_file", fallback).([]Train); !reflect.DeepEqual(got, fallback) {
+		t.Errorf("unknown name: got %+v, want the default", got)
+	}
+
+	// Files registered without a decoder are read by their callers
+	writeTestFile(t, filepath.Join(dir, "weather.json"), `{}`)
+	if got := loadJSON("weather", "default"); got != "default" {
+		t.Errorf("plain file: got %v, want the default", got)
+	}
+}
+
+func TestSaveJSONRoundTrip(t *testing.T) {
+	dir := useTempJSONDir(t)
+
+	trains := []Train{{ID: "7", Name: "Seven"}}
+	if err := saveJSON("trains", trains); err != nil {
+		t.Fatalf("saveJSON: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "trains_selected.json")); err != nil {
+		t.Fatalf("file not written: %v", err)
+	}
+	if got := loadJSON("trains", []Train{}).([]Train); !reflect.DeepEqual(got, trains) {
+		t.Errorf("got %+v, want %+v", got, trains)
+	}
+
+	cron := CronData{Timezone: "America/Chicago"}
+	if err := saveJSON("cron", cron); err != nil {
+		t.Fatalf("saveJSON: %v", err)
+	}
+	if got := loadJSON("cron", CronData{}).(CronData); got.Timezone != cron.Timezone {
+		t.Errorf("cron timezone = %q, want %q", got.Timezone, cron.Timezone)
+	}
+
+	if err := saveJSON("no_such_file", trains); err == nil {
+		t.Error("saveJSON of an unknown name succeeded")
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The kiosk is a guest-facing page for an unattended screen in the station
+// building: the next train in large type, the departures after it, active
+// alerts, the announcement being played and a ticker of the board's safety
+// messages. It takes its title, colors, locale and safety messages from
+// board_settings.json. The server controls how the page stays current: the
+// page and its state are never cached, the event stream tells the browser
+// how soon to reconnect and sends a heartbeat so idle proxies keep it open,
+// and every state carries a version that changes with the settings or a
+// restart, so open kiosks reload themselves after an upgrade.
+
+// How often the kiosk stream sends the state unprompted and a heartbeat
+// comment, and how long the browser waits before reconnecting a dropped stream
+const (
+	kioskRefresh        = 30 * time.Second
+	kioskHeartbeat      = 15 * time.Second
+	kioskReconnectDelay = 5 * time.Second
+)
+
+// KioskAlert is a guest-safe notice shown above the departures
+type KioskAlert struct {
+	Kind     string `json:"kind"`     // lightning, cancellation, delay or emergency
+	Severity string `json:"severity"` // critical or warning
+	Title    string `json:"title"`
+}
+
+// kioskVersion fingerprints the settings and this run of the server, so a
+// kiosk reloads when either changes
+func kioskVersion(settings BoardSettings) string {
+	data, _ := json.Marshal(settings)
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d", data, appVersion, appStartTime.UnixNano())))
+	return hex.EncodeToString(sum[:8])
+}
+
+// kioskAlerts lists what guests should know about now: a lightning storm,
+// open cancellations and delays, and an emergency announcement being played
+func kioskAlerts(locale string, playing *Announcement) []KioskAlert {
+	alerts := make([]KioskAlert, 0)
+	if playing != nil && playing.Type == TypeEmergency {
+		alerts = append(alerts, KioskAlert{Kind: "emergency", Severity: "critical", Title: announcementTitle(*playing, locale)})
+	}
+
+	if lightningTrigger != nil && lightningTrigger.Enabled {
+		switch condition := strings.ToLower(lightningTrigger.LastCondition); condition {
+		case "redalert":
+			alerts = append(alerts, KioskAlert{Kind: "lightning", Severity: "critical", Title: lightningAlertText(locale, condition)})
+		case "warning":
+			alerts = append(alerts, KioskAlert{Kind: "lightning", Severity: "warning", Title: lightningAlertText(locale, condition)})
+		}
+	}
+
+	departureStatusesMutex.Lock()
+	statuses := departureStatusListLocked()
+	departureStatusesMutex.Unlock()
+	trains := catalogNames("trains")
+	for _, status := range statuses {
+		train := displayName(trains, status.TrainNumber)
+		switch status.Status {
+		case DepartureCancelled:
+			alerts = append(alerts, KioskAlert{Kind: "cancellation", Severity: "critical",
+				Title: fmt.Sprintf("%s %s", train, translate(locale, "title.cancelled", "cancelled"))})
+		case DepartureDelayed:
+			alerts = append(alerts, KioskAlert{Kind: "delay", Severity: "warning",
+				Title: fmt.Sprintf("%s %s %d %s", train, translate(locale, "title.delayed", "delayed"),
+					status.DelayMinutes, translate(locale, "title.minutes", "minutes"))})
+		}
+	}
+	return alerts
+}
+
+// kioskState is the payload pushed to the kiosk on every change
+func kioskState(settings BoardSettings) gin.H {
+	var playing *Announcement
+	var current *PublicAnnouncement
+	if announcementManager != nil {
+		playing, _ = announcementManager.NowPlaying()
+		if playing != nil {
+			view := publicAnnouncementView(*playing, settings.Locale)
+			current = &view
+		}
+	}
+
+	// The next train is the first one still running
+	departures := upcomingDepartures(settings.MaxDepartures + 1)
+	var next *Departure
+	for i := range departures {
+		if departures[i].Status != DepartureCancelled {
+			next = &departures[i]
+			departures = append(departures[:i:i], departures[i+1:]...)
+			break
+		}
+	}
+	if len(departures) > settings.MaxDepartures {
+		departures = departures[:settings.MaxDepartures]
+	}
+
+	return gin.H{
+		"next_departure":  next,
+		"departures":      departures,
+		"alerts":          kioskAlerts(settings.Locale, playing),
+		"now_playing":     current,
+		"visual_alert":    currentVisualAlert(),
+		"safety_messages": settings.SafetyMessages,
+		"server_time":     time.Now().Format(time.RFC3339),
+		"version":         kioskVersion(settings),
+	}
+}
+
+// kioskLabels returns the kiosk's fixed text in its locale
+func kioskLabels(locale string) map[string]string {
+	labels := boardLabels(locale)
+	labels["next_train"] = translate(locale, "kiosk.next_train", "Next train")
+	labels["later"] = translate(locale, "kiosk.later", "Later departures")
+	labels["departs_in"] = translate(locale, "title.departs_in", "departs in")
+	labels["minutes"] = translate(locale, "title.minutes", "minutes")
+	labels["now"] = translate(locale, "kiosk.now", "Now boarding")
+	labels["reconnecting"] = translate(locale, "kiosk.reconnecting", "Reconnecting...")
+	return labels
+}
+
+// noStore keeps browsers and proxies from caching a kiosk response
+func noStore(c *gin.Context) {
+	c.Header("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	c.Header("Pragma", "no-cache")
+	c.Header("Expires", "0")
+}
+
+// kioskHandler renders the full-screen guest page
+func kioskHandler(c *gin.Context) {
+	settings := loadBoardSettings()
+	settingsJSON, _ := json.Marshal(settings)
+	labelsJSON, _ := json.Marshal(kioskLabels(settings.Locale))
+	noStore(c)
+	// A stream quiet for two refreshes has stalled without closing, and the
+	// page reconnects it
+	c.HTML(http.StatusOK, "kiosk.html", gin.H{
+		"settings":      settings,
+		"settings_json": template.JS(settingsJSON),
+		"labels_json":   template.JS(labelsJSON),
+		"version":       kioskVersion(settings),
+		"reconnect_ms":  kioskReconnectDelay.Milliseconds(),
+		"stale_ms":      (2*kioskRefresh + kioskReconnectDelay).Milliseconds(),
+	})
+}
+
+// kioskEventsHandler streams kiosk state as server-sent events, on every
+// queue or departure change and every kioskRefresh, with a heartbeat between
+func kioskEventsHandler(c *gin.Context) {
+	events := queueEvents.subscribe()
+	defer queueEvents.unsubscribe(events)
+
+	noStore(c)
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	// Tell the browser how soon to reconnect if the stream drops
+	if _, err := fmt.Fprintf(c.Writer, "retry: %d\n\n", kioskReconnectDelay.Milliseconds()); err != nil {
+		return
+	}
+
+	send := func() bool {
+		data, err := json.Marshal(kioskState(loadBoardSettings()))
+		if err != nil {
+			return false
+		}
+		if _, err := fmt.Fprintf(c.Writer, "event: state\ndata: %s\n\n", data); err != nil {
+			return false
+		}
+		c.Writer.Flush()
+		return true
+	}
+
+	if !send() {
+		return
+	}
+
+	refresh := time.NewTicker(kioskRefresh)
+	defer refresh.Stop()
+	heartbeat := time.NewTicker(kioskHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-events:
+			if !send() {
+				return
+			}
+		case <-refresh.C:
+			if !send() {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// kioskStateHandler returns the same state as JSON for kiosks that poll
+func kioskStateHandler(c *gin.Context) {
+	noStore(c)
+	c.JSON(http.StatusOK, kioskState(loadBoardSettings()))
+}
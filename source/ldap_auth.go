@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPRoleMapping maps a directory group to an annunciator role
+type LDAPRoleMapping struct {
+	Group       string   `json:"group"` // Full group DN or just its CN
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+// LDAPSettings configures admin login against LDAP / Active Directory
+type LDAPSettings struct {
+	Enabled            bool              `json:"enabled"`
+	URL                string            `json:"url"`             // ldap://host:389 or ldaps://host:636
+	StartTLS           bool              `json:"start_tls"`       // Upgrade an ldap:// connection to TLS before binding
+	AllowPlaintext     bool              `json:"allow_plaintext"` // Allow binds over ldap:// without StartTLS
+	InsecureSkipVerify bool              `json:"insecure_skip_verify"`
+	BindDN             string            `json:"bind_dn"` // Service account used to look users up
+	BindPassword       string            `json:"bind_password"`
+	BaseDN             string            `json:"base_dn"`
+	UserFilter         string            `json:"user_filter"` // %s is replaced with the escaped username
+	GroupAttribute     string            `json:"group_attribute"`
+	RoleMappings       []LDAPRoleMapping `json:"role_mappings"` // First matching group wins
+	TimeoutSeconds     int               `json:"timeout_seconds"`
+}
+
+// LDAPUser is a directory account that authenticated and matched a role
+type LDAPUser struct {
+	Username    string
+	DN          string
+	Role        string
+	Permissions []string
+}
+
+var (
+	// errLDAPInvalidCredentials means the directory rejected the password
+	errLDAPInvalidCredentials = errors.New("invalid credentials")
+	// errLDAPUserNotFound means the filter matched no directory account
+	errLDAPUserNotFound = errors.New("user not found in directory")
+	// errLDAPNoRole means the user is in none of the mapped groups
+	errLDAPNoRole = errors.New("user is not in any mapped group")
+	// errLDAPPlaintext means the connection is unencrypted and plaintext binds aren't allowed
+	errLDAPPlaintext = errors.New("refusing to send passwords over ldap:// without TLS; use ldaps://, set start_tls, or set allow_plaintext")
+)
+
+func getDefaultLDAPSettings() LDAPSettings {
+	return LDAPSettings{
+		Enabled:        false,
+		URL:            "ldap://dc.example.local:389",
+		StartTLS:       true,
+		UserFilter:     "(&(objectClass=user)(sAMAccountName=%s))",
+		GroupAttribute: "memberOf",
+		RoleMappings:   []LDAPRoleMapping{},
+		TimeoutSeconds: 5,
+	}
+}
+
+// authenticateLDAP validates the username and password against the directory
+// and resolves the user's role from group membership
+func authenticateLDAP(settings LDAPSettings, username, password string) (*LDAPUser, error) {
+	// An empty password would be an unauthenticated bind, which most servers accept
+	if username == "" || password == "" {
+		return nil, errLDAPInvalidCredentials
+	}
+
+	conn, err := dialLDAP(settings)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if settings.BindDN != "" {
+		if err := conn.Bind(settings.BindDN, settings.BindPassword); err != nil {
+			return nil, fmt.Errorf("service account bind failed: %v", err)
+		}
+	}
+
+	groupAttribute := settings.GroupAttribute
+	if groupAttribute == "" {
+		groupAttribute = "memberOf"
+	}
+	userFilter := settings.UserFilter
+	if userFilter == "" {
+		userFilter = getDefaultLDAPSettings().UserFilter
+	}
+
+	// A size limit of 2 is enough to tell a unique match from an ambiguous one
+	filter := strings.ReplaceAll(userFilter, "%s", ldap.EscapeFilter(username))
+	request := ldap.NewSearchRequest(settings.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(ldapTimeout(settings)/time.Second), false, filter, []string{groupAttribute}, nil)
+	result, err := conn.Search(request)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("user search failed: %v", err)
+	}
+	if result == nil || len(result.Entries) == 0 {
+		return nil, errLDAPUserNotFound
+	}
+	if len(result.Entries) > 1 {
+		return nil, fmt.Errorf("user filter matched more than one entry")
+	}
+	entry := result.Entries[0]
+
+	// Re-bind as the user to check the password
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, errLDAPInvalidCredentials
+		}
+		return nil, err
+	}
+
+	mapping := matchLDAPRole(settings.RoleMappings, entry.GetEqualFoldAttributeValues(groupAttribute))
+	if mapping == nil {
+		return nil, errLDAPNoRole
+	}
+
+	return &LDAPUser{
+		Username:    username,
+		DN:          entry.DN,
+		Role:        mapping.Role,
+		Permissions: mapping.Permissions,
+	}, nil
+}
+
+// matchLDAPRole returns the first mapping whose group the user belongs to
+func matchLDAPRole(mappings []LDAPRoleMapping, groups []string) *LDAPRoleMapping {
+	for i, mapping := range mappings {
+		for _, group := range groups {
+			if strings.EqualFold(group, mapping.Group) || strings.EqualFold(ldapCommonName(group), mapping.Group) {
+				return &mappings[i]
+			}
+		}
+	}
+	return nil
+}
+
+// ldapCommonName returns the CN of a DN such as "CN=Ops,OU=Groups,DC=park,DC=local"
+func ldapCommonName(dn string) string {
+	first := strings.SplitN(dn, ",", 2)[0]
+	if parts := strings.SplitN(first, "=", 2); len(parts) == 2 && strings.EqualFold(strings.TrimSpace(parts[0]), "cn") {
+		return strings.TrimSpace(parts[1])
+	}
+	return ""
+}
+
+func ldapTimeout(settings LDAPSettings) time.Duration {
+	if settings.TimeoutSeconds <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(settings.TimeoutSeconds) * time.Second
+}
+
+// dialLDAP connects to the directory, upgrading ldap:// with StartTLS when
+// configured. A connection that would carry passwords in clear text is
+// refused unless allow_plaintext is set.
+func dialLDAP(settings LDAPSettings) (*ldap.Conn, error) {
+	parsed, err := url.Parse(settings.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL: %v", err)
+	}
+	switch parsed.Scheme {
+	case "ldaps":
+	case "ldap":
+		if !settings.StartTLS && !settings.AllowPlaintext {
+			return nil, errLDAPPlaintext
+		}
+	default:
+		return nil, fmt.Errorf("unsupported LDAP URL scheme %q", parsed.Scheme)
+	}
+
+	timeout := ldapTimeout(settings)
+	tlsConfig := &tls.Config{
+		ServerName:         parsed.Hostname(),
+		InsecureSkipVerify: settings.InsecureSkipVerify,
+	}
+	conn, err := ldap.DialURL(settings.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", parsed.Host, err)
+	}
+	conn.SetTimeout(timeout)
+
+	if parsed.Scheme == "ldap" && settings.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS with %s failed: %v", parsed.Host, err)
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// While the lightning sensor reports RedAlert, train operations announcements
+// are held back: station and promo announcements either wait in the queue
+// until AllClear or are dropped, and the RedAlert announcement can be repeated
+// as a reminder. The condition and its history are saved so a restart in the
+// middle of a storm picks up where it left off.
+
+// Storm policy actions
+const (
+	StormActionDefer    = "defer"    // keep in the queue and play after AllClear
+	StormActionSuppress = "suppress" // drop
+)
+
+// LightningStormPolicy configures what happens to other announcements during a RedAlert
+type LightningStormPolicy struct {
+	Enabled                 bool     `json:"enabled"`
+	HoldTypes               []string `json:"hold_types"` // announcement types held back, e.g. station and promo
+	Action                  string   `json:"action"`     // defer or suppress
+	ReminderIntervalMinutes int      `json:"reminder_interval_minutes"`
+}
+
+func getDefaultLightningStormPolicy() LightningStormPolicy {
+	return LightningStormPolicy{
+		Enabled:                 true,
+		HoldTypes:               []string{string(TypeStation), string(TypePromo)},
+		Action:                  StormActionDefer,
+		ReminderIntervalMinutes: 0,
+	}
+}
+
+// lightningState is what survives a restart
+type lightningState struct {
+	LastCondition     string                     `json:"last_condition"`
+	LastConditionTime time.Time                  `json:"last_condition_time"`
+	LastReminder      time.Time                  `json:"last_reminder,omitempty"`
+	History           []LightningConditionChange `json:"history"`
+}
+
+func lightningStatePath() string {
+	return filepath.Join(app.Config.JSONDir, "lightning_state.json")
+}
+
+// loadLightningState restores the last condition and history into the trigger
+func (t *LightningTrigger) loadLightningState() {
+	data, err := ioutil.ReadFile(lightningStatePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read lightning state: %v", err)
+		}
+		return
+	}
+	var state lightningState
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Printf("Warning: failed to parse lightning state: %v", err)
+		return
+	}
+
+	if len(state.History) > maxLightningHistory {
+		state.History = state.History[len(state.History)-maxLightningHistory:]
+	}
+	t.statsMutex.Lock()
+	t.history = state.History
+	t.lastReminder = state.LastReminder
+	t.statsMutex.Unlock()
+	if state.LastCondition != "" {
+		t.LastCondition = state.LastCondition
+		t.LastConditionTime = state.LastConditionTime
+		log.Printf("✓ Restored lightning condition '%s' (since %s) with %d recorded changes",
+			state.LastCondition, state.LastConditionTime.Format("2006-01-02 15:04:05"), len(state.History))
+	}
+}
+
+// saveLightningState writes the condition and history. Caller must hold statsMutex.
+func (t *LightningTrigger) saveLightningState(condition string, conditionTime time.Time) {
+	state := lightningState{
+		LastCondition:     condition,
+		LastConditionTime: conditionTime,
+		LastReminder:      t.lastReminder,
+		History:           t.history,
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(lightningStatePath(), data, 0644)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to save lightning state: %v", err)
+	}
+}
+
+// getLightningStormPolicy returns the policy from lightning.json with defaults filled in
+func getLightningStormPolicy() LightningStormPolicy {
+	if lightningConfig == nil || lightningConfig.StormPolicy == nil {
+		return getDefaultLightningStormPolicy()
+	}
+	policy := *lightningConfig.StormPolicy
+	if policy.Action == "" {
+		policy.Action = StormActionDefer
+	}
+	return policy
+}
+
+// redAlertActive reports whether the lightning sensor's last condition is RedAlert
+func redAlertActive() bool {
+	return lightningTrigger != nil && lightningTrigger.Enabled && strings.EqualFold(lightningTrigger.LastCondition, "redalert")
+}
+
+// stormPolicyAction returns the action the storm policy takes on an announcement
+// of this type right now, or "" if it may play
+func stormPolicyAction(announcementType AnnouncementType) string {
+	if !redAlertActive() {
+		return ""
+	}
+	policy := getLightningStormPolicy()
+	if !policy.Enabled {
+		return ""
+	}
+	for _, held := range policy.HoldTypes {
+		if strings.EqualFold(held, string(announcementType)) {
+			return policy.Action
+		}
+	}
+	return ""
+}
+
+// runLightningReminders repeats the RedAlert announcement at the configured
+// interval until the condition changes
+func (t *LightningTrigger) runLightningReminders() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		policy := getLightningStormPolicy()
+		if !policy.Enabled || policy.ReminderIntervalMinutes <= 0 || !redAlertActive() {
+			continue
+		}
+		interval := time.Duration(policy.ReminderIntervalMinutes) * time.Minute
+
+		t.statsMutex.Lock()
+		last := t.lastReminder
+		if last.Before(t.LastConditionTime) {
+			last = t.LastConditionTime
+		}
+		due := time.Since(last) >= interval
+		if due {
+			t.lastReminder = time.Now()
+			t.saveLightningState(t.LastCondition, t.LastConditionTime)
+		}
+		t.statsMutex.Unlock()
+
+		if due {
+			log.Printf("⚡ RedAlert still active after %s, repeating the announcement", time.Since(t.LastConditionTime).Round(time.Minute))
+			t.playLightningAnnouncement(t.LastCondition)
+		}
+	}
+}
+
+// describeStormHold explains why an announcement is being held, for logs and history
+func describeStormHold(action string) string {
+	if action == StormActionSuppress {
+		return "suppressed during lightning RedAlert"
+	}
+	return "held until lightning AllClear"
+}
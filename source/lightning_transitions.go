@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// How condition readings turn into condition changes and announcements is set
+// by the "state_machine" section of lightning.json. A reading that differs from
+// the current condition is matched against the transitions in order; the first
+// whose from and to match decides what happens. "*" matches any condition.
+// Without a state_machine section the built-in rules below apply: Unknown
+// readings are ignored and AllClear is only announced after RedAlert or Warning.
+
+// LightningTransition is one rule for a change from one condition to another
+type LightningTransition struct {
+	From     []string `json:"from"`     // current conditions this applies to, "*" for any
+	To       string   `json:"to"`       // reading this applies to, "*" for any
+	Announce string   `json:"announce"` // condition whose announcement plays, "*" for the new condition, "" for none
+	Ignore   bool     `json:"ignore"`   // discard the reading; the condition does not change
+
+	// Overrides of the state machine defaults for this transition
+	DebounceCount   *int `json:"debounce_count,omitempty"`
+	MinDwellSeconds *int `json:"min_dwell_seconds,omitempty"`
+}
+
+// LightningStateMachine configures when a reading becomes a condition change
+type LightningStateMachine struct {
+	// Consecutive fetches that must report the new condition before the change is accepted
+	DebounceCount int `json:"debounce_count"`
+	// How long the current condition must have lasted before it can change
+	MinDwellSeconds int                   `json:"min_dwell_seconds"`
+	Transitions     []LightningTransition `json:"transitions"`
+}
+
+func getDefaultLightningStateMachine() LightningStateMachine {
+	return LightningStateMachine{
+		DebounceCount:   1,
+		MinDwellSeconds: 0,
+		Transitions: []LightningTransition{
+			{From: []string{"*"}, To: "Unknown", Ignore: true},
+			{From: []string{"RedAlert", "Warning"}, To: "AllClear", Announce: "*"},
+			{From: []string{"*"}, To: "AllClear", Announce: ""},
+			{From: []string{"*"}, To: "*", Announce: "*"},
+		},
+	}
+}
+
+// getLightningStateMachine returns the state machine from lightning.json with defaults filled in
+func getLightningStateMachine() LightningStateMachine {
+	if lightningConfig == nil || lightningConfig.StateMachine == nil {
+		return getDefaultLightningStateMachine()
+	}
+	machine := *lightningConfig.StateMachine
+	if machine.DebounceCount < 1 {
+		machine.DebounceCount = 1
+	}
+	if machine.MinDwellSeconds < 0 {
+		machine.MinDwellSeconds = 0
+	}
+	if len(machine.Transitions) == 0 {
+		machine.Transitions = getDefaultLightningStateMachine().Transitions
+	}
+	return machine
+}
+
+func conditionMatches(pattern, condition string) bool {
+	return pattern == "*" || strings.EqualFold(pattern, condition)
+}
+
+// transitionFor returns the first transition matching a change; a change no
+// rule covers is accepted and announced
+func (m LightningStateMachine) transitionFor(from, to string) LightningTransition {
+	for _, transition := range m.Transitions {
+		if !conditionMatches(transition.To, to) {
+			continue
+		}
+		for _, pattern := range transition.From {
+			if conditionMatches(pattern, from) {
+				return transition
+			}
+		}
+	}
+	return LightningTransition{From: []string{"*"}, To: "*", Announce: "*"}
+}
+
+func (m LightningStateMachine) debounceFor(transition LightningTransition) int {
+	if transition.DebounceCount != nil && *transition.DebounceCount >= 1 {
+		return *transition.DebounceCount
+	}
+	return m.DebounceCount
+}
+
+func (m LightningStateMachine) dwellFor(transition LightningTransition) time.Duration {
+	if transition.MinDwellSeconds != nil && *transition.MinDwellSeconds >= 0 {
+		return time.Duration(*transition.MinDwellSeconds) * time.Second
+	}
+	return time.Duration(m.MinDwellSeconds) * time.Second
+}
+
+// announcementFor returns the condition whose announcement plays for a change to "to"
+func (transition LightningTransition) announcementFor(to string) string {
+	if transition.Announce == "*" {
+		return to
+	}
+	return transition.Announce
+}
+
+// applyReading runs one condition reading through the state machine
+func (t *LightningTrigger) applyReading(reading string, now time.Time) {
+	if strings.EqualFold(reading, t.LastCondition) {
+		// Back to the current condition: a pending change didn't hold
+		t.pendingCondition, t.pendingReadings = "", 0
+		return
+	}
+
+	machine := getLightningStateMachine()
+	transition := machine.transitionFor(t.LastCondition, reading)
+	if transition.Ignore {
+		log.Printf("Lightning status '%s' ignored by the state machine (current condition '%s')", reading, t.LastCondition)
+		return
+	}
+
+	if !strings.EqualFold(t.pendingCondition, reading) {
+		t.pendingCondition, t.pendingReadings = reading, 0
+	}
+	t.pendingReadings++
+	if needed := machine.debounceFor(transition); t.pendingReadings < needed {
+		log.Printf("Lightning condition '%s' seen %d of %d times needed to change from '%s'", reading, t.pendingReadings, needed, t.LastCondition)
+		return
+	}
+	if dwell := machine.dwellFor(transition); dwell > 0 && !t.LastConditionTime.IsZero() && now.Sub(t.LastConditionTime) < dwell {
+		log.Printf("Lightning condition '%s' held: '%s' must last %s (has lasted %s)", reading, t.LastCondition, dwell, now.Sub(t.LastConditionTime).Round(time.Second))
+		return
+	}
+
+	announce := transition.announcementFor(reading)
+	note := ""
+	if announce == "" {
+		note = fmt.Sprintf("no announcement for %s to %s", t.LastCondition, reading)
+	}
+	log.Printf("Lightning condition changed from '%s' to '%s'", t.LastCondition, reading)
+	t.recordConditionChange(t.LastCondition, reading, announce != "", note)
+	publishTriggerEvent(TriggerEvent{Source: t.ID, Type: "lightning", Field: "condition", Value: reading, Previous: t.LastCondition, Time: now})
+
+	t.LastCondition = reading
+	t.LastConditionTime = now
+	t.pendingCondition, t.pendingReadings = "", 0
+
+	if announce != "" {
+		t.playLightningAnnouncement(announce)
+	} else {
+		log.Printf("Lightning change to '%s' accepted without an announcement", reading)
+	}
+}
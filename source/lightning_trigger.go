@@ -10,8 +10,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
-	"unicode/utf16"
 )
 
 // LightningTrigger represents a lightning monitoring trigger
@@ -26,11 +26,44 @@ type LightningTrigger struct {
 	LastFetch         time.Time `json:"last_fetch"`
 	LastConditionTime time.Time `json:"last_condition_time"`
 	
-	// Internal state
-	isRunning bool
-	stopChan  chan bool
+	// Polling goroutine
+	loop triggerLoop
+	
+	// Recent condition changes and counters for the status endpoints
+	statsMutex sync.Mutex
+	history    []LightningConditionChange
+	stats      LightningStats
+	
+	// When the RedAlert reminder last played
+	lastReminder time.Time
+	
+	// A change waiting for enough consecutive readings
+	pendingCondition string
+	pendingReadings  int
+}
+
+// LightningConditionChange records one change in the reported condition
+type LightningConditionChange struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	ChangedAt time.Time `json:"changed_at"`
+	Announced bool      `json:"announced"`
+	Note      string    `json:"note,omitempty"`
 }
 
+// LightningStats counts fetches and changes since startup
+type LightningStats struct {
+	Fetches          int            `json:"fetches"`
+	FetchErrors      int            `json:"fetch_errors"`
+	ConditionChanges int            `json:"condition_changes"`
+	Announcements    int            `json:"announcements"`
+	ByCondition      map[string]int `json:"by_condition"` // changes into each condition
+	LastError        string         `json:"last_error,omitempty"`
+}
+
+// Condition changes kept for the status endpoints and in lightning_state.json
+const maxLightningHistory = 200
+
 // LightningAnnouncement represents a lightning announcement from the JSON config
 type LightningAnnouncement struct {
 	ID          string `json:"id"`
@@ -46,48 +79,99 @@ type LightningAnnouncement struct {
 // LightningConfig represents the lightning.json configuration
 type LightningConfig struct {
 	LightningAnnouncements []LightningAnnouncement `json:"lightning_announcements"`
+	StormPolicy            *LightningStormPolicy   `json:"storm_policy,omitempty"`
+	ConditionPaths         []string                `json:"condition_paths,omitempty"` // where the condition is in the feed, see lightning_xml.go
+	StateMachine           *LightningStateMachine  `json:"state_machine,omitempty"`
 }
 
 // Global lightning trigger instance
 var lightningTrigger *LightningTrigger
 var lightningConfig *LightningConfig
 
-// Initialize lightning trigger system
-func initializeLightningTrigger() error {
-	// Load lightning configuration
+// lightningTriggerSettings are the lightning trigger's settings in triggers.json
+type lightningTriggerSettings struct {
+	URL           string `json:"url"`
+	FetchInterval int    `json:"fetch_interval"` // seconds, at least 30
+	Timeout       int    `json:"timeout"`        // seconds, at least 5
+}
+
+// validate checks the settings the same way the lightning config endpoints do
+func (s lightningTriggerSettings) validate() []FieldError {
+	var details []FieldError
+	if s.URL == "" {
+		details = append(details, FieldError{Field: "url", Message: "is required"})
+	}
+	if s.FetchInterval < 30 {
+		details = append(details, FieldError{Field: "fetch_interval", Message: "must be at least 30 seconds"})
+	}
+	if s.Timeout < 5 {
+		details = append(details, FieldError{Field: "timeout", Message: "must be at least 5 seconds"})
+	}
+	return details
+}
+
+// newLightningTriggerFromDefinition builds the lightning trigger from
+// triggers.json. There is one lightning sensor, so only one may be configured.
+func newLightningTriggerFromDefinition(definition TriggerDefinition) (Trigger, error) {
+	if lightningTrigger != nil {
+		return nil, fmt.Errorf("only one lightning trigger is supported ('%s' is already configured)", lightningTrigger.ID)
+	}
 	if err := loadLightningConfig(); err != nil {
-		log.Printf("Warning: Failed to load lightning configuration: %v", err)
-		return err
+		return nil, err
 	}
 	
-	// Create lightning trigger with default settings
-	lightningTrigger = &LightningTrigger{
-		ID:            "lightning_monitor",
-		Name:          "Lightning Alert Monitor",
-		Enabled:       true,
+	settings := lightningTriggerSettings{
 		URL:           "https://broward.thormobile4.net/tp/FL0115.xml",
-		FetchInterval: 30, // 30 seconds default
-		Timeout:       30,  // 30 seconds timeout
-		LastCondition: "Reset",
-		stopChan:      make(chan bool),
+		FetchInterval: 30,
+		Timeout:       30,
+	}
+	if len(definition.Settings) > 0 {
+		if err := json.Unmarshal(definition.Settings, &settings); err != nil {
+			return nil, fmt.Errorf("invalid lightning settings: %v", err)
+		}
 	}
 	
-	// Start the lightning trigger if enabled
-	if lightningTrigger.Enabled {
-		go lightningTrigger.Start()
-		log.Printf("✓ Lightning trigger system initialized and started")
-		log.Printf("  - Monitoring URL: %s", lightningTrigger.URL)
-		log.Printf("  - Fetch interval: %d seconds", lightningTrigger.FetchInterval)
-	} else {
-		log.Printf("✓ Lightning trigger system initialized (disabled)")
+	name := definition.Name
+	if name == "" {
+		name = "Lightning Alert Monitor"
+	}
+	t := &LightningTrigger{
+		ID:            definition.ID,
+		Name:          name,
+		URL:           settings.URL,
+		FetchInterval: settings.FetchInterval,
+		Timeout:       settings.Timeout,
+		LastCondition: "Reset",
 	}
 	
+	// Pick up the condition from before a restart so a storm in progress stays in force
+	t.loadLightningState()
+	superviseGoroutine("lightning reminders", t.runLightningReminders)
+	
+	lightningTrigger = t
+	log.Printf("✓ Lightning trigger '%s' monitoring %s every %d seconds", t.ID, t.URL, t.FetchInterval)
+	return t, nil
+}
+
+// configureLightningTrigger applies feed settings, and optionally the enabled
+// state, through the trigger registry so they are kept in triggers.json
+func configureLightningTrigger(settings lightningTriggerSettings, enabled *bool) error {
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	if err := triggerRegistry.configure(lightningTrigger.ID, raw); err != nil {
+		return err
+	}
+	if enabled != nil {
+		return triggerRegistry.setEnabled(lightningTrigger.ID, *enabled)
+	}
 	return nil
 }
 
 // Load lightning configuration from JSON
 func loadLightningConfig() error {
-	configPath := filepath.Join("json", "lightning.json")
+	configPath := filepath.Join(app.Config.JSONDir, "lightning.json")
 	
 	// Check if file exists
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
@@ -110,68 +194,99 @@ func loadLightningConfig() error {
 	return nil
 }
 
-// Start the lightning trigger monitoring
-func (t *LightningTrigger) Start() {
-	if t.isRunning {
-		return
-	}
-	
-	t.isRunning = true
-	ticker := time.NewTicker(time.Duration(t.FetchInterval) * time.Second)
-	defer ticker.Stop()
-	
-	log.Printf("Lightning trigger '%s' started with %d second interval", t.Name, t.FetchInterval)
-	
-	// Do initial fetch
-	t.fetchAndCheck()
-	
-	for {
-		select {
-		case <-ticker.C:
-			t.fetchAndCheck()
-		case <-t.stopChan:
-			t.isRunning = false
-			log.Printf("Lightning trigger '%s' stopped", t.Name)
-			return
-		}
-	}
+// Start begins polling the sensor feed (the first fetch is immediate)
+func (t *LightningTrigger) Start() error {
+	t.Enabled = true
+	t.loop.start(t.Name, time.Duration(t.FetchInterval)*time.Second, true, t.fetchAndCheck)
+	return nil
 }
 
-// Stop the lightning trigger
+// Stop ends polling; the last condition is kept
 func (t *LightningTrigger) Stop() {
-	if t.isRunning {
-		close(t.stopChan)
+	t.loop.stop()
+	t.Enabled = false
+}
+
+// Running reports whether the feed is being polled
+func (t *LightningTrigger) Running() bool {
+	return t.loop.running()
+}
+
+// Settings returns the settings stored in triggers.json
+func (t *LightningTrigger) Settings() interface{} {
+	return lightningTriggerSettings{URL: t.URL, FetchInterval: t.FetchInterval, Timeout: t.Timeout}
+}
+
+// Configure applies new feed settings
+func (t *LightningTrigger) Configure(raw json.RawMessage) error {
+	settings := t.Settings().(lightningTriggerSettings)
+	if err := json.Unmarshal(raw, &settings); err != nil {
+		return err
+	}
+	if details := settings.validate(); len(details) > 0 {
+		return fmt.Errorf("%s %s", details[0].Field, details[0].Message)
 	}
+	return t.UpdateConfig(settings.URL, settings.FetchInterval, settings.Timeout)
 }
 
-// Fetch XML and check for lightning conditions
-func (t *LightningTrigger) fetchAndCheck() {
-	defer func() {
-		t.LastFetch = time.Now()
-	}()
+// Test announces a condition when one is given; otherwise it fetches the feed
+// once and reports the condition without acting on it
+func (t *LightningTrigger) Test(value string) (map[string]interface{}, error) {
+	if value != "" {
+		condition := normalizeLightningCondition(value)
+		switch condition {
+		case "RedAlert", "Warning", "AllClear":
+		default:
+			return nil, fmt.Errorf("condition must be RedAlert, Warning or AllClear")
+		}
+		t.TestCondition(condition)
+		return map[string]interface{}{"condition": condition, "announced": true}, nil
+	}
 	
-	// Create HTTP client with timeout
+	xmlData, err := t.fetchFeed()
+	if err != nil {
+		return nil, err
+	}
+	condition, err := extractLightningCondition(xmlData, lightningConditionPaths())
+	if err != nil {
+		return map[string]interface{}{"xml_preview": lightningXMLPreview(xmlData)}, err
+	}
+	return map[string]interface{}{
+		"condition":         condition,
+		"current_condition": t.LastCondition,
+		"announced":         false,
+	}, nil
+}
+
+// fetchFeed downloads the sensor feed
+func (t *LightningTrigger) fetchFeed() ([]byte, error) {
 	client := &http.Client{
 		Timeout: time.Duration(t.Timeout) * time.Second,
 	}
-	
-	// Fetch XML
 	resp, err := client.Get(t.URL)
 	if err != nil {
-		log.Printf("Lightning trigger fetch error: %v", err)
-		return
+		return nil, err
 	}
 	defer resp.Body.Close()
 	
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Lightning trigger received status %d", resp.StatusCode)
-		return
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
 	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Fetch XML and check for lightning conditions
+func (t *LightningTrigger) fetchAndCheck() {
+	fetchError := ""
+	defer func() {
+		t.LastFetch = time.Now()
+		t.recordFetch(fetchError)
+	}()
 	
-	// Read response body
-	xmlData, err := ioutil.ReadAll(resp.Body)
+	xmlData, err := t.fetchFeed()
 	if err != nil {
-		log.Printf("Lightning trigger read error: %v", err)
+		log.Printf("Lightning trigger fetch error: %v", err)
+		fetchError = err.Error()
 		return
 	}
 	
@@ -181,60 +296,70 @@ func (t *LightningTrigger) fetchAndCheck() {
 		// Continue processing even if file save fails
 	}
 	
-	// Convert XML from UTF-16 to UTF-8 if needed
-	xmlString, err := t.convertXMLEncoding(xmlData)
+	// Parse the condition out of the feed
+	lightningAlert, err := extractLightningCondition(xmlData, lightningConditionPaths())
 	if err != nil {
-		log.Printf("Lightning trigger encoding conversion error: %v", err)
+		log.Printf("Lightning trigger could not read the condition: %v", err)
+		log.Printf("Lightning XML preview: %s", lightningXMLPreview(xmlData))
+		fetchError = err.Error()
 		return
 	}
 	
-	// Extract lightning alert value
-	lightningAlert := t.extractLightningAlertFromString(xmlString)
-	if lightningAlert == "" {
-		log.Printf("No lightningalert tag found in XML")
-		return
+	log.Printf("Lightning alert status: %s", lightningAlert)
+	
+	// The state machine decides whether this is a change and what to announce
+	t.applyReading(lightningAlert, time.Now())
+}
+
+// recordFetch counts one fetch; errMessage is empty when it succeeded
+func (t *LightningTrigger) recordFetch(errMessage string) {
+	t.statsMutex.Lock()
+	defer t.statsMutex.Unlock()
+	
+	t.stats.Fetches++
+	if errMessage != "" {
+		t.stats.FetchErrors++
+		t.stats.LastError = errMessage
+	} else {
+		t.stats.LastError = ""
 	}
+}
+
+// recordConditionChange adds a change to the recent history
+func (t *LightningTrigger) recordConditionChange(from, to string, announced bool, note string) {
+	t.statsMutex.Lock()
+	defer t.statsMutex.Unlock()
 	
-	log.Printf("Lightning alert status: %s", lightningAlert)
+	t.history = append(t.history, LightningConditionChange{
+		From:      from,
+		To:        to,
+		ChangedAt: time.Now(),
+		Announced: announced,
+		Note:      note,
+	})
+	if len(t.history) > maxLightningHistory {
+		t.history = t.history[len(t.history)-maxLightningHistory:]
+	}
 	
-	// Check if condition has changed
-	if lightningAlert != t.LastCondition {
-		log.Printf("Lightning condition changed from '%s' to '%s'", t.LastCondition, lightningAlert)
-		
-		// Handle different lightning conditions
-		if strings.ToLower(lightningAlert) == "unknown" {
-			log.Printf("Lightning status 'Unknown' - treating as XML error, ignoring condition change")
-			// Don't update LastCondition for Unknown - treat as XML parsing error
-			return
-		}
-		
-		// Check if this is an AllClear condition
-		if strings.ToLower(lightningAlert) == "allclear" {
-			// Only play AllClear if previous condition was RedAlert or Warning
-			prevCondition := strings.ToLower(t.LastCondition)
-			if prevCondition != "redalert" && prevCondition != "warning" {
-				log.Printf("AllClear condition ignored - previous condition was '%s' (not RedAlert or Warning)", t.LastCondition)
-				// Update the condition but don't play announcement
-				t.LastCondition = lightningAlert
-				t.LastConditionTime = time.Now()
-				return
-			}
-			log.Printf("AllClear condition accepted - previous condition was '%s'", t.LastCondition)
-		}
-		
-		// Update condition state for valid (non-Unknown) conditions
-		t.LastCondition = lightningAlert
-		t.LastConditionTime = time.Now()
-		
-		// Play appropriate announcement for valid conditions
-		t.playLightningAnnouncement(lightningAlert)
+	t.stats.ConditionChanges++
+	if announced {
+		t.stats.Announcements++
+	}
+	if t.stats.ByCondition == nil {
+		t.stats.ByCondition = make(map[string]int)
 	}
+	t.stats.ByCondition[to]++
+	
+	t.saveLightningState(to, time.Now())
 }
 
 // Save XML file locally
 func (t *LightningTrigger) saveXMLFile(xmlData []byte) error {
 	// Create xml directory if it doesn't exist
 	xmlDir := "xml"
+	if err := storageWritable(xmlDir, "saving lightning XML"); err != nil {
+		return err
+	}
 	if err := os.MkdirAll(xmlDir, 0755); err != nil {
 		return fmt.Errorf("failed to create xml directory: %v", err)
 	}
@@ -280,140 +405,6 @@ func (t *LightningTrigger) generateFileName() (string, error) {
 	return fileName, nil
 }
 
-// Convert XML encoding from UTF-16 to UTF-8 if needed
-func (t *LightningTrigger) convertXMLEncoding(xmlData []byte) (string, error) {
-	// Check if the data starts with a UTF-16 BOM
-	if len(xmlData) >= 2 {
-		// UTF-16 LE BOM
-		if xmlData[0] == 0xFF && xmlData[1] == 0xFE {
-			return t.decodeUTF16LE(xmlData[2:])
-		}
-		// UTF-16 BE BOM
-		if xmlData[0] == 0xFE && xmlData[1] == 0xFF {
-			return t.decodeUTF16BE(xmlData[2:])
-		}
-	}
-	
-	// Check if it looks like UTF-16 by checking for null bytes in even positions
-	xmlStr := string(xmlData)
-	if len(xmlData) > 20 && strings.Contains(xmlStr[:100], "\x00") {
-		// Looks like UTF-16, try to decode as UTF-16 LE
-		decoded, err := t.decodeUTF16LE(xmlData)
-		if err == nil && strings.Contains(decoded, "<?xml") {
-			return decoded, nil
-		}
-	}
-	
-	// Already UTF-8 or ASCII
-	return string(xmlData), nil
-}
-
-// Decode UTF-16 Little Endian
-func (t *LightningTrigger) decodeUTF16LE(data []byte) (string, error) {
-	if len(data)%2 != 0 {
-		return "", fmt.Errorf("odd length data for UTF-16")
-	}
-	
-	u16s := make([]uint16, len(data)/2)
-	for i := 0; i < len(u16s); i++ {
-		u16s[i] = uint16(data[i*2]) | uint16(data[i*2+1])<<8
-	}
-	
-	runes := utf16.Decode(u16s)
-	return string(runes), nil
-}
-
-// Decode UTF-16 Big Endian
-func (t *LightningTrigger) decodeUTF16BE(data []byte) (string, error) {
-	if len(data)%2 != 0 {
-		return "", fmt.Errorf("odd length data for UTF-16")
-	}
-	
-	u16s := make([]uint16, len(data)/2)
-	for i := 0; i < len(u16s); i++ {
-		u16s[i] = uint16(data[i*2])<<8 | uint16(data[i*2+1])
-	}
-	
-	runes := utf16.Decode(u16s)
-	return string(runes), nil
-}
-
-// Extract lightningalert value from XML string
-func (t *LightningTrigger) extractLightningAlertFromString(xmlStr string) string {
-	// Debug: Log first 1000 characters of XML to see what we're parsing
-	xmlPreview := xmlStr
-	if len(xmlStr) > 1000 {
-		xmlPreview = xmlStr[:1000] + "..."
-	}
-	log.Printf("Lightning XML preview (converted): %s", xmlPreview)
-	
-	// Look for <lightningalert>VALUE</lightningalert> (case sensitive)
-	startTag := "<lightningalert>"
-	endTag := "</lightningalert>"
-	
-	startIndex := strings.Index(xmlStr, startTag)
-	if startIndex == -1 {
-		// Try case-insensitive search for debugging
-		lowerXML := strings.ToLower(xmlStr)
-		if strings.Contains(lowerXML, "<lightningalert>") {
-			log.Printf("Lightning: Found lightningalert tag in different case")
-		} else {
-			log.Printf("Lightning: No lightningalert tag found in XML")
-		}
-		return ""
-	}
-	
-	startIndex += len(startTag)
-	endIndex := strings.Index(xmlStr[startIndex:], endTag)
-	if endIndex == -1 {
-		log.Printf("Lightning: Found opening tag but no closing tag")
-		return ""
-	}
-	
-	value := strings.TrimSpace(xmlStr[startIndex : startIndex+endIndex])
-	log.Printf("Lightning: Successfully extracted value: '%s'", value)
-	return value
-}
-
-// Extract lightningalert value from XML (deprecated - use extractLightningAlertFromString)
-func (t *LightningTrigger) extractLightningAlert(xmlData []byte) string {
-	xmlStr := string(xmlData)
-	
-	// Debug: Log first 1000 characters of XML to see what we're parsing
-	xmlPreview := xmlStr
-	if len(xmlStr) > 1000 {
-		xmlPreview = xmlStr[:1000] + "..."
-	}
-	log.Printf("Lightning XML preview: %s", xmlPreview)
-	
-	// Look for <lightningalert>VALUE</lightningalert> (case sensitive)
-	startTag := "<lightningalert>"
-	endTag := "</lightningalert>"
-	
-	startIndex := strings.Index(xmlStr, startTag)
-	if startIndex == -1 {
-		// Try case-insensitive search for debugging
-		lowerXML := strings.ToLower(xmlStr)
-		if strings.Contains(lowerXML, "<lightningalert>") {
-			log.Printf("Lightning: Found lightningalert tag in different case")
-		} else {
-			log.Printf("Lightning: No lightningalert tag found in XML")
-		}
-		return ""
-	}
-	
-	startIndex += len(startTag)
-	endIndex := strings.Index(xmlStr[startIndex:], endTag)
-	if endIndex == -1 {
-		log.Printf("Lightning: Found opening tag but no closing tag")
-		return ""
-	}
-	
-	value := strings.TrimSpace(xmlStr[startIndex : startIndex+endIndex])
-	log.Printf("Lightning: Successfully extracted value: '%s'", value)
-	return value
-}
-
 // Play lightning announcement based on condition
 func (t *LightningTrigger) playLightningAnnouncement(condition string) {
 	if lightningConfig == nil {
@@ -510,8 +501,10 @@ func (t *LightningTrigger) playLightningAnnouncement(condition string) {
 		// Lightning alerts always get the highest priority (10)
 		priority := AnnouncementPriority(10)
 		
-		announcement, err := announcementManager.QueueAnnouncement(announcementType, priority, parameters, time.Now())
-		if err != nil {
+		announcement, err := queueTriggerAnnouncement(t.ID, announcementType, priority, parameters)
+		if isTriggerRateLimited(err) {
+			log.Printf("Lightning announcement held back: %v", err)
+		} else if err != nil {
 			log.Printf("Failed to queue lightning announcement: %v", err)
 		} else {
 			log.Printf("Queued HIGHEST PRIORITY lightning announcement: %s (ID: %s)", selectedAnnouncement.Name, announcement.ID)
@@ -533,13 +526,11 @@ func (t *LightningTrigger) TestCondition(condition string) {
 
 // Update lightning trigger configuration
 func (t *LightningTrigger) UpdateConfig(url string, fetchInterval int, timeout int) error {
-	wasRunning := t.isRunning
+	wasRunning := t.Running()
 	
-	// Stop if running
+	// Stop if running; waits for an in-progress fetch
 	if wasRunning {
 		t.Stop()
-		// Wait a moment for the goroutine to stop
-		time.Sleep(100 * time.Millisecond)
 	}
 	
 	// Update configuration
@@ -549,8 +540,7 @@ func (t *LightningTrigger) UpdateConfig(url string, fetchInterval int, timeout i
 	
 	// Restart if it was running
 	if wasRunning {
-		t.stopChan = make(chan bool) // Create new channel
-		go t.Start()
+		t.Start()
 	}
 	
 	log.Printf("Lightning trigger configuration updated - URL: %s, Interval: %ds", url, fetchInterval)
@@ -565,24 +555,72 @@ func getLightningTriggerStatus() map[string]interface{} {
 			"error":   "Lightning trigger not initialized",
 		}
 	}
-	
+	return lightningTrigger.Status()
+}
+
+// Status describes the trigger for the API
+func (t *LightningTrigger) Status() map[string]interface{} {
 	return map[string]interface{}{
-		"id":                    lightningTrigger.ID,
-		"name":                  lightningTrigger.Name,
-		"enabled":               lightningTrigger.Enabled,
-		"running":               lightningTrigger.isRunning,
-		"url":                   lightningTrigger.URL,
-		"fetch_interval":        lightningTrigger.FetchInterval,
-		"timeout":               lightningTrigger.Timeout,
-		"last_fetch":            lightningTrigger.LastFetch.Format("2006-01-02 15:04:05"),
-		"last_condition":        lightningTrigger.LastCondition,
-		"last_condition_time":   lightningTrigger.LastConditionTime.Format("2006-01-02 15:04:05"),
+		"id":                    t.ID,
+		"name":                  t.Name,
+		"enabled":               t.Enabled,
+		"running":               t.Running(),
+		"url":                   t.URL,
+		"fetch_interval":        t.FetchInterval,
+		"timeout":               t.Timeout,
+		"last_fetch":            t.LastFetch.Format("2006-01-02 15:04:05"),
+		"last_condition":        t.LastCondition,
+		"last_condition_time":   t.LastConditionTime.Format("2006-01-02 15:04:05"),
 	}
 }
 
-// Stop lightning trigger system
-func stopLightningTrigger() {
-	if lightningTrigger != nil {
-		lightningTrigger.Stop()
+// getLightningStormStatus returns the current condition with recent changes
+// (newest first) and counters, for the ops dashboard
+func getLightningStormStatus() map[string]interface{} {
+	if lightningTrigger == nil {
+		return map[string]interface{}{
+			"enabled": false,
+			"error":   "Lightning trigger not initialized",
+		}
 	}
-}
\ No newline at end of file
+	
+	t := lightningTrigger
+	t.statsMutex.Lock()
+	history := make([]LightningConditionChange, 0, len(t.history))
+	for i := len(t.history) - 1; i >= 0; i-- {
+		history = append(history, t.history[i])
+	}
+	stats := t.stats
+	stats.ByCondition = make(map[string]int)
+	for condition, count := range t.stats.ByCondition {
+		stats.ByCondition[condition] = count
+	}
+	t.statsMutex.Unlock()
+	
+	condition := strings.ToLower(t.LastCondition)
+	status := map[string]interface{}{
+		"enabled":         t.Enabled,
+		"running":         t.Running(),
+		"condition":       t.LastCondition,
+		"storm_active":    condition == "redalert" || condition == "warning",
+		"last_fetch":      nil,
+		"condition_since": nil,
+		"history":         history,
+		"counts":          stats,
+		"storm_policy":    getLightningStormPolicy(),
+		"pending":         nil,
+	}
+	if t.pendingCondition != "" {
+		status["pending"] = map[string]interface{}{
+			"condition": t.pendingCondition,
+			"readings":  t.pendingReadings,
+		}
+	}
+	if !t.LastFetch.IsZero() {
+		status["last_fetch"] = t.LastFetch
+	}
+	if !t.LastConditionTime.IsZero() {
+		status["condition_since"] = t.LastConditionTime
+	}
+	return status
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// The Thor Guard feed is read with encoding/xml rather than by searching for
+// tags, so whitespace, attributes, namespaces, tag case and the UTF-16 the
+// vendor serves do not matter. Where the condition lives is configured as
+// element paths in lightning.json ("condition_paths"):
+//
+//	lightningalert            a <lightningalert> element anywhere
+//	tp/status/lightningalert  that element under <tp><status>, at any depth
+//	/tp/lightningalert        anchored at the document root
+//	status@alert              the alert attribute of a <status> element
+//
+// Paths are tried in order; the first one found with a value wins.
+
+var defaultLightningConditionPaths = []string{"lightningalert"}
+
+// lightningConditionPaths returns the configured element paths
+func lightningConditionPaths() []string {
+	if lightningConfig != nil && len(lightningConfig.ConditionPaths) > 0 {
+		return lightningConfig.ConditionPaths
+	}
+	return defaultLightningConditionPaths
+}
+
+// lightningXMLToUTF8 converts a UTF-16 document (with or without a byte order
+// mark) to UTF-8 and strips a UTF-8 byte order mark. Anything else is returned unchanged.
+func lightningXMLToUTF8(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return data[3:]
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return decodeUTF16(data[2:], false)
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return decodeUTF16(data[2:], true)
+	case bytes.HasPrefix(data, []byte{'<', 0}):
+		return decodeUTF16(data, false)
+	case bytes.HasPrefix(data, []byte{0, '<'}):
+		return decodeUTF16(data, true)
+	}
+	return data
+}
+
+func decodeUTF16(data []byte, bigEndian bool) []byte {
+	units := make([]uint16, len(data)/2) // a trailing odd byte is dropped
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(data[i*2])<<8 | uint16(data[i*2+1])
+		} else {
+			units[i] = uint16(data[i*2]) | uint16(data[i*2+1])<<8
+		}
+	}
+	return []byte(string(utf16.Decode(units)))
+}
+
+// lightningCharsetReader lets the decoder accept the encodings feeds declare.
+// UTF-16 has already been converted by lightningXMLToUTF8, whatever the
+// declaration still says.
+func lightningCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-16", "utf-16le", "utf-16be", "unicode", "utf-8", "utf8":
+		return input, nil
+	case "iso-8859-1", "latin1", "latin-1", "windows-1252", "cp1252", "us-ascii", "ascii":
+		return latin1Reader{bufio.NewReader(input)}, nil
+	}
+	return nil, fmt.Errorf("unsupported XML encoding %q", charset)
+}
+
+// latin1Reader converts single-byte Latin-1 text to UTF-8
+type latin1Reader struct {
+	input *bufio.Reader
+}
+
+func (r latin1Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n+utf8.UTFMax <= len(p) {
+		b, err := r.input.ReadByte()
+		if err != nil {
+			if n > 0 && err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		n += utf8.EncodeRune(p[n:], rune(b))
+	}
+	return n, nil
+}
+
+// lightningPath is one parsed condition path
+type lightningPath struct {
+	elements  []string // lower-case local names
+	attribute string   // lower-case attribute name, or "" for the element text
+	anchored  bool     // must start at the document root
+}
+
+func parseLightningPath(path string) (lightningPath, error) {
+	parsed := lightningPath{}
+	path = strings.TrimSpace(path)
+	if strings.HasPrefix(path, "/") {
+		parsed.anchored = true
+		path = path[1:]
+	}
+	if at := strings.Index(path, "@"); at >= 0 {
+		parsed.attribute = strings.ToLower(path[at+1:])
+		path = path[:at]
+		if parsed.attribute == "" {
+			return parsed, fmt.Errorf("condition path %q has an empty attribute name", path)
+		}
+	}
+	for _, element := range strings.Split(path, "/") {
+		if element == "" {
+			return parsed, fmt.Errorf("condition path %q has an empty element name", path)
+		}
+		parsed.elements = append(parsed.elements, strings.ToLower(element))
+	}
+	return parsed, nil
+}
+
+// matches reports whether the path ends at the innermost element of stack
+func (p lightningPath) matches(stack []string) bool {
+	if len(stack) < len(p.elements) || (p.anchored && len(stack) != len(p.elements)) {
+		return false
+	}
+	offset := len(stack) - len(p.elements)
+	for i, element := range p.elements {
+		if stack[offset+i] != element {
+			return false
+		}
+	}
+	return true
+}
+
+// extractLightningCondition returns the lightning condition from a feed
+// document, normalised to RedAlert, Warning, AllClear or Unknown where it is
+// one of those. It needs no trigger or network, so it can be fed saved feeds.
+func extractLightningCondition(data []byte, paths []string) (string, error) {
+	parsedPaths := make([]lightningPath, 0, len(paths))
+	for _, path := range paths {
+		parsed, err := parseLightningPath(path)
+		if err != nil {
+			return "", err
+		}
+		parsedPaths = append(parsedPaths, parsed)
+	}
+	if len(parsedPaths) == 0 {
+		return "", fmt.Errorf("no condition paths configured")
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(lightningXMLToUTF8(data)))
+	decoder.CharsetReader = lightningCharsetReader
+	decoder.Strict = false
+
+	values := make([]string, len(parsedPaths))
+	found := make([]bool, len(parsedPaths))
+	capturing := make(map[int]*strings.Builder) // path index -> text of the matched element
+	captureDepth := make(map[int]int)
+	var stack []string
+	var parseErr error
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			parseErr = err
+			break
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, strings.ToLower(token.Name.Local))
+			for i, path := range parsedPaths {
+				if found[i] || capturing[i] != nil || !path.matches(stack) {
+					continue
+				}
+				if path.attribute == "" {
+					capturing[i] = &strings.Builder{}
+					captureDepth[i] = len(stack)
+					continue
+				}
+				for _, attr := range token.Attr {
+					if strings.ToLower(attr.Name.Local) == path.attribute && strings.TrimSpace(attr.Value) != "" {
+						values[i] = strings.TrimSpace(attr.Value)
+						found[i] = true
+						break
+					}
+				}
+			}
+		case xml.CharData:
+			for _, text := range capturing {
+				text.Write(token)
+			}
+		case xml.EndElement:
+			for i, text := range capturing {
+				if captureDepth[i] != len(stack) {
+					continue
+				}
+				if value := strings.TrimSpace(text.String()); value != "" {
+					values[i] = value
+					found[i] = true
+				}
+				delete(capturing, i)
+			}
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	for i := range parsedPaths {
+		if found[i] {
+			return normalizeLightningCondition(values[i]), nil
+		}
+	}
+	if parseErr != nil {
+		return "", fmt.Errorf("invalid XML: %v", parseErr)
+	}
+	return "", fmt.Errorf("no condition found at %s", strings.Join(paths, ", "))
+}
+
+// normalizeLightningCondition maps spellings such as "Red Alert", "RED_ALERT"
+// or "all-clear" to the condition names the trigger uses
+func normalizeLightningCondition(value string) string {
+	key := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '_' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(value))
+	switch key {
+	case "redalert":
+		return "RedAlert"
+	case "warning":
+		return "Warning"
+	case "allclear":
+		return "AllClear"
+	case "unknown":
+		return "Unknown"
+	}
+	return value
+}
+
+// lightningXMLPreview is the start of a feed as text, for logs and the test fetch
+func lightningXMLPreview(data []byte) string {
+	text := string(lightningXMLToUTF8(data))
+	if len(text) > 1000 {
+		text = text[:1000] + "..."
+	}
+	return text
+}
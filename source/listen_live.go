@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gopxl/beep"
+)
+
+// Live listen stream settings
+const (
+	liveStreamChunkInterval = 100 * time.Millisecond // How often audio is pushed to listeners
+	liveStreamMaxListeners  = 5                      // Default for settings.json; protects the Pi from too many concurrent streams
+	liveStreamMaxBuffered   = 2 * time.Second        // Default for settings.json; drop audio older than this if listeners fall behind
+	liveStreamBitsPerSample = 16
+	liveStreamChannels      = 1
+)
+
+// LiveStreamHub fans out a mono PCM copy of everything the annunciator plays
+// to connected /listen clients. Silence is generated between announcements
+// so browsers keep the connection open.
+type LiveStreamHub struct {
+	mutex      sync.Mutex
+	listeners  map[chan []byte]bool
+	pending    []float64
+	sampleRate beep.SampleRate
+	running    bool
+	stopChan   chan bool
+}
+
+// Global live stream hub instance
+var liveStreamHub = &LiveStreamHub{
+	listeners: make(map[chan []byte]bool),
+}
+
+// Subscribe registers a new listener and starts the hub if needed
+func (h *LiveStreamHub) Subscribe(sampleRate beep.SampleRate) (chan []byte, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if maxListeners, _ := liveStreamLimits(); len(h.listeners) >= maxListeners {
+		return nil, false
+	}
+
+	ch := make(chan []byte, 32)
+	h.listeners[ch] = true
+	h.sampleRate = sampleRate
+
+	if !h.running {
+		h.running = true
+		h.stopChan = make(chan bool)
+		go h.run(h.stopChan)
+	}
+
+	log.Printf("Live listen client connected (%d active)", len(h.listeners))
+	return ch, true
+}
+
+// Unsubscribe removes a listener and stops the hub when nobody is listening
+func (h *LiveStreamHub) Unsubscribe(ch chan []byte) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.listeners[ch]; !ok {
+		return
+	}
+	delete(h.listeners, ch)
+	close(ch)
+
+	if len(h.listeners) == 0 && h.running {
+		h.running = false
+		close(h.stopChan)
+		h.pending = nil
+	}
+
+	log.Printf("Live listen client disconnected (%d active)", len(h.listeners))
+}
+
+// HasListeners reports whether any client is connected
+func (h *LiveStreamHub) HasListeners() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.listeners) > 0
+}
+
+// ListenerCount returns the number of connected clients
+func (h *LiveStreamHub) ListenerCount() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.listeners)
+}
+
+// write appends the speaker's mixed output (downmixed to mono) to the
+// pending buffer
+func (h *LiveStreamHub) write(samples [][2]float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.running {
+		return
+	}
+
+	for _, s := range samples {
+		h.pending = append(h.pending, (s[0]+s[1])/2)
+	}
+
+	// Never let a stalled hub grow without bound
+	_, maxBuffered := liveStreamLimits()
+	maxPending := h.sampleRate.N(maxBuffered)
+	if len(h.pending) > maxPending {
+		h.pending = h.pending[len(h.pending)-maxPending:]
+	}
+}
+
+// run pushes a chunk to every listener on each tick
+func (h *LiveStreamHub) run(stopChan chan bool) {
+	ticker := time.NewTicker(liveStreamChunkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			h.broadcast()
+		}
+	}
+}
+
+// broadcast encodes pending audio (or silence) and sends it to all listeners
+func (h *LiveStreamHub) broadcast() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	samples := h.pending
+	h.pending = nil
+	if len(samples) == 0 {
+		samples = make([]float64, h.sampleRate.N(liveStreamChunkInterval))
+	}
+
+	chunk := make([]byte, len(samples)*2)
+	for i, v := range samples {
+		if v > 1 {
+			v = 1
+		} else if v < -1 {
+			v = -1
+		}
+		binary.LittleEndian.PutUint16(chunk[i*2:], uint16(int16(v*32767)))
+	}
+
+	for ch := range h.listeners {
+		select {
+		case ch <- chunk:
+		default:
+			// Slow listener - drop this chunk rather than blocking playback
+		}
+	}
+}
+
+// liveTapStreamer copies everything streamed through it to the live hub. The
+// audio backend wraps its mixer in one, so concurrent streams reach listeners
+// mixed the way the speaker plays them.
+type liveTapStreamer struct {
+	streamer beep.Streamer
+}
+
+func (t *liveTapStreamer) Stream(samples [][2]float64) (int, bool) {
+	n, ok := t.streamer.Stream(samples)
+	if n > 0 && liveStreamHub.HasListeners() {
+		liveStreamHub.write(samples[:n])
+	}
+	return n, ok
+}
+
+func (t *liveTapStreamer) Err() error {
+	return t.streamer.Err()
+}
+
+// withLiveTap wraps the backend's mixer so its output is mirrored to /listen
+// clients
+func withLiveTap(streamer beep.Streamer) beep.Streamer {
+	return &liveTapStreamer{streamer: streamer}
+}
+
+// wavStreamHeader builds a WAV header with an open-ended data length
+func wavStreamHeader(sampleRate beep.SampleRate) []byte {
+	blockAlign := liveStreamChannels * liveStreamBitsPerSample / 8
+	header := make([]byte, 44)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], 0xFFFFFFFF)
+	copy(header[8:], "WAVE")
+	copy(header[12:], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(header[22:], liveStreamChannels)
+	binary.LittleEndian.PutUint32(header[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(header[28:], uint32(int(sampleRate)*blockAlign))
+	binary.LittleEndian.PutUint16(header[32:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(header[34:], liveStreamBitsPerSample)
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], 0xFFFFFFFF)
+	return header
+}
+
+// listenLiveHandler streams whatever the annunciator plays as a WAV stream
+func listenLiveHandler(c *gin.Context) {
+	if audioBackend == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Audio system not available"})
+		return
+	}
+
+	sampleRate := audioBackend.SampleRate()
+	ch, ok := liveStreamHub.Subscribe(sampleRate)
+	if !ok {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too many live listeners, try again later"})
+		return
+	}
+	defer liveStreamHub.Unsubscribe(ch)
+
+	c.Header("Content-Type", "audio/wav")
+	c.Header("Cache-Control", "no-cache, no-store")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if _, err := c.Writer.Write(wavStreamHeader(sampleRate)); err != nil {
+		return
+	}
+	c.Writer.Flush()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case chunk, open := <-ch:
+			if !open {
+				return
+			}
+			if _, err := c.Writer.Write(chunk); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// listenStatusHandler reports how many clients are monitoring the output
+func listenStatusHandler(c *gin.Context) {
+	maxListeners, _ := liveStreamLimits()
+	c.JSON(http.StatusOK, gin.H{
+		"listeners":     liveStreamHub.ListenerCount(),
+		"max_listeners": maxListeners,
+		"format":        "audio/wav",
+		"channels":      liveStreamChannels,
+		"sample_rate":   int(sampleRateOrDefault()),
+	})
+}
+
+// sampleRateOrDefault returns the backend output rate, or 44.1kHz without a backend
+func sampleRateOrDefault() beep.SampleRate {
+	if audioBackend == nil {
+		return beep.SampleRate(44100)
+	}
+	return audioBackend.SampleRate()
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gopxl/beep"
+)
+
+// A live page streams an operator's microphone to the speakers. The admin UI
+// (or any client) starts a page, then POSTs raw 16-bit little-endian mono PCM
+// to it in small chunks as it is captured, and stops it when done. The page
+// plays at critical priority: whatever is playing below emergency priority is
+// cut off, the queue is paused for the length of the page, and it resumes
+// afterwards unless it was already paused. Pages appear in the history as
+// "live_page" announcements and are mirrored to /listen.
+
+// Live page limits
+const (
+	livePageDefaultRate = 16000
+	livePageMinRate     = 8000
+	livePageMaxRate     = 48000
+	livePageMaxBuffered = time.Second      // Older audio is dropped so the page stays live
+	livePageIdleTimeout = 10 * time.Second // A page with no audio for this long is ended
+	livePageMaxDuration = 10 * time.Minute
+	livePageMaxChunk    = 1 << 20 // Bytes accepted per audio request
+)
+
+// livePage is one operator's page in progress
+type livePage struct {
+	announcement *Announcement
+	sampleRate   beep.SampleRate
+	wasPaused    bool // Queue state to restore afterwards
+
+	mutex     sync.Mutex
+	buffer    []float64
+	stopping  bool
+	lastAudio time.Time
+	received  int // Samples received
+	done      chan struct{}
+}
+
+var (
+	currentLivePage *livePage
+	livePageMutex   sync.Mutex
+)
+
+// livePageStreamer plays the page's buffer, filling gaps with silence until
+// the page is stopped or goes idle
+type livePageStreamer struct {
+	page *livePage
+}
+
+func (s *livePageStreamer) Stream(samples [][2]float64) (int, bool) {
+	page := s.page
+	page.mutex.Lock()
+	defer page.mutex.Unlock()
+
+	if len(page.buffer) == 0 {
+		if page.stopping || time.Since(page.lastAudio) > livePageIdleTimeout || time.Since(*page.announcement.StartedAt) > livePageMaxDuration {
+			page.stopping = true
+			return 0, false
+		}
+	}
+	for i := range samples {
+		value := 0.0 // Silence while waiting for the next chunk
+		if len(page.buffer) > 0 {
+			value = page.buffer[0]
+			page.buffer = page.buffer[1:]
+		}
+		samples[i][0], samples[i][1] = value, value
+	}
+	return len(samples), true
+}
+
+func (s *livePageStreamer) Err() error {
+	return nil
+}
+
+// write appends a chunk of 16-bit PCM, dropping the oldest audio if the
+// speakers have fallen behind
+func (p *livePage) write(pcm []byte) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for i := 0; i+1 < len(pcm); i += 2 {
+		p.buffer = append(p.buffer, float64(int16(binary.LittleEndian.Uint16(pcm[i:])))/32768)
+	}
+	p.received += len(pcm) / 2
+	p.lastAudio = time.Now()
+
+	if maxBuffered := p.sampleRate.N(livePageMaxBuffered); len(p.buffer) > maxBuffered {
+		p.buffer = p.buffer[len(p.buffer)-maxBuffered:]
+	}
+}
+
+// stop ends the page once the buffered audio has played
+func (p *livePage) stop() {
+	p.mutex.Lock()
+	p.stopping = true
+	p.mutex.Unlock()
+}
+
+// beginLivePage interrupts the playing announcement, pauses the queue and
+// puts the page in the playing slot
+func (am *AnnouncementManager) beginLivePage(operator string) (*Announcement, bool, error) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	if am.playing != nil && am.playing.Priority > PriorityCritical {
+		return nil, false, fmt.Errorf("an emergency announcement is playing")
+	}
+	now := time.Now()
+	if am.playing != nil {
+		log.Printf("Live page interrupting announcement: %s", am.playing.ID)
+		select {
+		case am.cancelChan <- true:
+		default:
+		}
+		am.playing.Status = StatusCancelled
+		am.playing.CompletedAt = &now
+		am.playing.Error = "interrupted by a live page"
+		am.finishAnnouncement(am.playing)
+		am.playing = nil
+	}
+
+	wasPaused := am.isPaused
+	am.isPaused = true
+	page := &Announcement{
+		ID:          am.generateID(),
+		Type:        TypeLivePage,
+		Priority:    PriorityCritical,
+		Status:      StatusPlaying,
+		CreatedAt:   now,
+		ScheduledAt: now,
+		StartedAt:   &now,
+		Parameters:  map[string]interface{}{"operator": operator},
+	}
+	am.playing = page
+	queueEvents.publish()
+	return page, wasPaused, nil
+}
+
+// endLivePage records the finished page and resumes the queue if the page
+// paused it
+func (am *AnnouncementManager) endLivePage(page *Announcement, wasPaused bool, err error) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	if !wasPaused {
+		am.isPaused = false
+	}
+	if am.playing == page {
+		am.playing = nil
+	}
+	// StopCurrent already recorded the page as cancelled
+	if page.Status == StatusCancelled {
+		return
+	}
+	now := time.Now()
+	page.CompletedAt = &now
+	page.Duration = now.Sub(*page.StartedAt)
+	if err != nil {
+		page.Status = StatusFailed
+		page.Error = err.Error()
+	} else {
+		page.Status = StatusCompleted
+	}
+	am.finishAnnouncement(page)
+}
+
+// startLivePage opens a page and starts playing it
+func startLivePage(operator string, sampleRate int) (*livePage, error) {
+	if !app.AudioEnabled || audioBackend == nil {
+		return nil, fmt.Errorf("audio system not available")
+	}
+	if maintenanceActive() {
+		return nil, fmt.Errorf("maintenance mode is on, so nothing can be played")
+	}
+
+	livePageMutex.Lock()
+	defer livePageMutex.Unlock()
+	if currentLivePage != nil {
+		return nil, fmt.Errorf("a live page is already in progress")
+	}
+
+	announcement, wasPaused, err := announcementManager.beginLivePage(operator)
+	if err != nil {
+		return nil, err
+	}
+	page := &livePage{
+		announcement: announcement,
+		sampleRate:   beep.SampleRate(sampleRate),
+		wasPaused:    wasPaused,
+		lastAudio:    time.Now(),
+		done:         make(chan struct{}),
+	}
+	currentLivePage = page
+	log.Printf("🎙️  Live page %s started by %s (%d Hz)", announcement.ID, operator, sampleRate)
+
+	go page.play()
+	return page, nil
+}
+
+// play streams the page to the speakers until it ends
+func (p *livePage) play() {
+	globalAudioMutex.Lock()
+	// Drop the cancellation sent to the announcement the page interrupted
+	select {
+	case <-announcementManager.cancelChan:
+	default:
+	}
+	output := audioBackend.SampleRate()
+	streamer := beep.Resample(4, p.sampleRate, output, &livePageStreamer{page: p})
+	err := audioBackend.Play(streamer, announcementManager.cancelChan)
+	globalAudioMutex.Unlock()
+
+	p.stop()
+	announcementManager.endLivePage(p.announcement, p.wasPaused, err)
+
+	livePageMutex.Lock()
+	if currentLivePage == p {
+		currentLivePage = nil
+	}
+	livePageMutex.Unlock()
+	close(p.done)
+
+	p.mutex.Lock()
+	received := time.Duration(p.received) * time.Second / time.Duration(p.sampleRate)
+	p.mutex.Unlock()
+	if err != nil {
+		log.Printf("🎙️  Live page %s ended: %v (%s of audio)", p.announcement.ID, err, received.Round(time.Second))
+	} else {
+		log.Printf("🎙️  Live page %s ended (%s of audio)", p.announcement.ID, received.Round(time.Second))
+	}
+}
+
+// findLivePage returns the page in progress if it has the given ID
+func findLivePage(id string) *livePage {
+	livePageMutex.Lock()
+	defer livePageMutex.Unlock()
+	if currentLivePage == nil || currentLivePage.announcement.ID != id {
+		return nil
+	}
+	return currentLivePage
+}
+
+// Handlers
+
+// livePageStatusHandler reports the page in progress, if any
+func livePageStatusHandler(c *gin.Context) {
+	livePageMutex.Lock()
+	page := currentLivePage
+	livePageMutex.Unlock()
+	if page == nil {
+		respondOK(c, gin.H{"active": false})
+		return
+	}
+	respondOK(c, gin.H{
+		"active":      true,
+		"id":          page.announcement.ID,
+		"operator":    page.announcement.Parameters["operator"],
+		"started_at":  page.announcement.StartedAt,
+		"sample_rate": int(page.sampleRate),
+	})
+}
+
+// livePageStartHandler starts a page. The optional sample_rate is the rate of
+// the PCM that will be sent (default 16000).
+func livePageStartHandler(c *gin.Context) {
+	if !requireAnnouncementManager(c) {
+		return
+	}
+	data, ok := bindRequestData(c, "sample_rate")
+	if !ok {
+		return
+	}
+	sampleRate, err := intField(data, "sample_rate", livePageDefaultRate)
+	if err != nil || sampleRate < livePageMinRate || sampleRate > livePageMaxRate {
+		respondValidationError(c, "Invalid live page request", FieldError{
+			Field:   "sample_rate",
+			Message: fmt.Sprintf("must be between %d and %d", livePageMinRate, livePageMaxRate),
+		})
+		return
+	}
+
+	page, err := startLivePage(requestOperator(c), sampleRate)
+	if err != nil {
+		respondError(c, http.StatusConflict, ErrCodeConflict, err.Error())
+		return
+	}
+	respondSuccess(c, http.StatusOK, "Live page started", gin.H{
+		"id":          page.announcement.ID,
+		"sample_rate": sampleRate,
+		"format":      "s16le",
+		"channels":    1,
+	})
+}
+
+// livePageAudioHandler plays a chunk of raw PCM. The body is read as it
+// arrives, so a client may also stream one long chunked request.
+func livePageAudioHandler(c *gin.Context) {
+	page := findLivePage(c.Param("id"))
+	if page == nil {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Live page not found or already ended")
+		return
+	}
+
+	buffer := make([]byte, 4096)
+	body := io.LimitReader(c.Request.Body, livePageMaxChunk)
+	var carry []byte
+	for {
+		n, err := body.Read(buffer)
+		if n > 0 {
+			chunk := append(carry, buffer[:n]...)
+			even := len(chunk) &^ 1
+			page.write(chunk[:even])
+			carry = append([]byte(nil), chunk[even:]...)
+		}
+		if err != nil {
+			break
+		}
+		select {
+		case <-page.done:
+			respondError(c, http.StatusGone, ErrCodeNotFound, "Live page ended")
+			return
+		default:
+		}
+	}
+
+	page.mutex.Lock()
+	buffered := time.Duration(len(page.buffer)) * time.Second / time.Duration(page.sampleRate)
+	page.mutex.Unlock()
+	respondOK(c, gin.H{"buffered_ms": buffered.Milliseconds()})
+}
+
+// livePageStopHandler ends a page after its buffered audio has played
+func livePageStopHandler(c *gin.Context) {
+	page := findLivePage(c.Param("id"))
+	if page == nil {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Live page not found or already ended")
+		return
+	}
+	page.stop()
+
+	// Wait briefly so the response reflects the resumed queue
+	select {
+	case <-page.done:
+	case <-time.After(livePageMaxBuffered + time.Second):
+	}
+	respondSuccess(c, http.StatusOK, "Live page stopped", gin.H{"id": page.announcement.ID})
+}
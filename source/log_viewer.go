@@ -0,0 +1,425 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The log viewer reads the .log files in log_dir from the admin page and the
+// API, so nobody needs a shell on the Pi to see what happened. A tail reads
+// back from the end of a file and parses each line into its time, level and
+// subsystem. Levels are guessed from the wording as the log shipper does;
+// subsystems from the keywords and emoji the code base logs with. A line
+// without a timestamp continues the entry above and takes its level and
+// subsystem.
+//
+// A tail returns the offset it read up to. Passing that back as ?after=
+// returns only the lines written since, which is how the admin page follows
+// the current log.
+
+const (
+	logTailDefaultLines = 200
+	logTailMaxLines     = 2000
+	logTailMaxScan      = 4 << 20 // Bytes read back from the end of a file
+	logTimeLayout       = "2006/01/02 15:04:05"
+)
+
+// logLevelRank orders levels for the minimum level filter
+var logLevelRank = map[string]int{"debug": 0, "info": 1, "warning": 2, "error": 3}
+
+// logSubsystems maps keywords to subsystems; the first match names a line
+var logSubsystems = []struct {
+	Name     string
+	Keywords []string
+}{
+	{"commissioning", []string{"commissioning", "🧰"}},
+	{"lightning", []string{"lightning", "⚡"}},
+	{"clock", []string{"clock", "ntp"}},
+	{"scheduler", []string{"schedul", "cron", "🕐", "🗓️"}},
+	{"triggers", []string{"trigger", "🚦"}},
+	{"fleet", []string{"fleet", "🛰️"}},
+	{"standby", []string{"standby", "failover", "replicat", "⏸️"}},
+	{"auth", []string{"login", "api key", "ldap", "session", "🔐"}},
+	{"scripts", []string{"event script", "🎬"}},
+	{"notifications", []string{"notif", "webhook", "email"}},
+	{"audio", []string{"audio", "playback", "announcement", "amplifier", "volume", "speaker", "live page", "🔊", "🔈", "🔇", "🎙️"}},
+	{"config", []string{"config", "snapshot", "backup"}},
+}
+
+// LogFileInfo describes a file in the log directory
+type LogFileInfo struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Current  bool      `json:"current"` // Being written by this process
+}
+
+// LogLine is one parsed line of a log file
+type LogLine struct {
+	Time      *time.Time `json:"time,omitempty"`
+	Level     string     `json:"level"`
+	Subsystem string     `json:"subsystem"`
+	Message   string     `json:"message"`
+}
+
+// LogFilter picks the lines a tail returns
+type LogFilter struct {
+	MinLevel  string // Lowest level returned; empty for all
+	Subsystem string
+	Text      string // Case-insensitive substring of the message
+}
+
+// LogTail is the end of a log file, or the lines added after an offset
+type LogTail struct {
+	File      string    `json:"file"`
+	Lines     []LogLine `json:"lines"`
+	Offset    int64     `json:"offset"`    // Pass back as ?after= for the lines written since
+	Truncated bool      `json:"truncated"` // Earlier lines were not read
+}
+
+// currentLogName is the file this process logs to, or ""
+func currentLogName() string {
+	if logFile == nil {
+		return ""
+	}
+	return filepath.Base(logFile.Name())
+}
+
+// listLogFiles returns the .log files in the log directory, newest first
+func listLogFiles() ([]LogFileInfo, error) {
+	entries, err := os.ReadDir(app.Config.LogDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", app.Config.LogDir, err)
+	}
+	current := currentLogName()
+	files := []LogFileInfo{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".log" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, LogFileInfo{Name: entry.Name(), Size: info.Size(), Modified: info.ModTime(), Current: entry.Name() == current})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Modified.After(files[j].Modified) })
+	return files, nil
+}
+
+// logFilePath resolves a file name from a request to a log in the log
+// directory; an empty name is the current log
+func logFilePath(name string) (string, error) {
+	if name == "" {
+		if name = currentLogName(); name == "" {
+			return "", fmt.Errorf("not logging to a file")
+		}
+	}
+	if name != filepath.Base(name) || filepath.Ext(name) != ".log" {
+		return "", fmt.Errorf("invalid log file name %q", name)
+	}
+	path := filepath.Join(app.Config.LogDir, name)
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return "", fmt.Errorf("log file %s not found", name)
+	}
+	return path, nil
+}
+
+// logSubsystem names the subsystem a message came from
+func logSubsystem(message string) string {
+	lower := strings.ToLower(message)
+	for _, subsystem := range logSubsystems {
+		for _, keyword := range subsystem.Keywords {
+			if strings.Contains(lower, keyword) {
+				return subsystem.Name
+			}
+		}
+	}
+	return "system"
+}
+
+// parseLogLine splits off the timestamp and works out the level and
+// subsystem; previous is the line above, for continuation lines
+func parseLogLine(text string, previous *LogLine) LogLine {
+	if len(text) > 20 && text[4] == '/' && text[19] == ' ' {
+		if t, err := time.ParseInLocation(logTimeLayout, text[:19], time.Local); err == nil {
+			message := stripLogTimestamp(text)
+			return LogLine{Time: &t, Level: logLevel(message), Subsystem: logSubsystem(message), Message: message}
+		}
+	}
+	if previous != nil {
+		return LogLine{Time: previous.Time, Level: previous.Level, Subsystem: previous.Subsystem, Message: text}
+	}
+	return LogLine{Level: logLevel(text), Subsystem: logSubsystem(text), Message: text}
+}
+
+// matches reports whether a line passes the filter
+func (f LogFilter) matches(line LogLine) bool {
+	if f.MinLevel != "" && logLevelRank[line.Level] < logLevelRank[f.MinLevel] {
+		return false
+	}
+	if f.Subsystem != "" && line.Subsystem != f.Subsystem {
+		return false
+	}
+	return f.Text == "" || strings.Contains(strings.ToLower(line.Message), strings.ToLower(f.Text))
+}
+
+// tailLog returns the last lines of a log that pass the filter. With after
+// at or above zero only lines starting at that offset are read.
+func tailLog(name string, lines int, after int64, filter LogFilter) (*LogTail, error) {
+	path, err := logFilePath(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", filepath.Base(path), err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", filepath.Base(path), err)
+	}
+	size := info.Size()
+
+	start := int64(0)
+	if after >= 0 && after <= size {
+		start = after
+	}
+	tail := &LogTail{File: filepath.Base(path)}
+	if size-start > logTailMaxScan {
+		start = size - logTailMaxScan
+	}
+	tail.Truncated = start > 0 && (after < 0 || start > after)
+
+	data := make([]byte, size-start)
+	if _, err := file.ReadAt(data, start); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read %s: %v", tail.File, err)
+	}
+	// Start on a whole line, and leave a line still being written for the
+	// next read
+	if tail.Truncated {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data, start = data[i+1:], start+int64(i+1)
+		}
+	}
+	end := bytes.LastIndexByte(data, '\n') + 1
+	data = data[:end]
+	tail.Offset = start + int64(end)
+
+	var previous *LogLine
+	matched := []LogLine{}
+	for _, text := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if text == "" {
+			continue
+		}
+		line := parseLogLine(strings.TrimRight(text, "\r"), previous)
+		previous = &line
+		if filter.matches(line) {
+			matched = append(matched, line)
+		}
+	}
+	if len(matched) > lines {
+		matched = matched[len(matched)-lines:]
+	}
+	tail.Lines = matched
+	return tail, nil
+}
+
+// writeLogArchive zips the log files modified since the given time to out
+func writeLogArchive(out io.Writer, since time.Time) error {
+	files, err := listLogFiles()
+	if err != nil {
+		return err
+	}
+	writer := zip.NewWriter(out)
+	for _, logInfo := range files {
+		if logInfo.Modified.Before(since) {
+			continue
+		}
+		err := func() error {
+			file, err := os.Open(filepath.Join(app.Config.LogDir, logInfo.Name))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			entry, err := writer.CreateHeader(&zip.FileHeader{Name: logInfo.Name, Method: zip.Deflate, Modified: logInfo.Modified})
+			if err != nil {
+				return err
+			}
+			// The current log keeps growing; take what was there when listed
+			_, err = io.Copy(entry, io.LimitReader(file, logInfo.Size))
+			return err
+		}()
+		if err != nil {
+			writer.Close()
+			return fmt.Errorf("failed to add %s: %v", logInfo.Name, err)
+		}
+	}
+	return writer.Close()
+}
+
+// logTailQuery reads ?file=, ?lines=, ?after=, ?level=, ?subsystem= and ?q=
+func logTailQuery(c *gin.Context) (name string, lines int, after int64, filter LogFilter, err error) {
+	lines, after = logTailDefaultLines, -1
+	if value := c.Query("lines"); value != "" {
+		if lines, err = strconv.Atoi(value); err != nil || lines <= 0 {
+			return "", 0, 0, filter, fmt.Errorf("lines must be a positive number")
+		}
+		if lines > logTailMaxLines {
+			lines = logTailMaxLines
+		}
+	}
+	if value := c.Query("after"); value != "" {
+		if after, err = strconv.ParseInt(value, 10, 64); err != nil || after < 0 {
+			return "", 0, 0, filter, fmt.Errorf("after must be an offset from an earlier tail")
+		}
+	}
+	filter = LogFilter{MinLevel: strings.ToLower(c.Query("level")), Subsystem: strings.ToLower(c.Query("subsystem")), Text: c.Query("q")}
+	if _, ok := logLevelRank[filter.MinLevel]; filter.MinLevel != "" && !ok {
+		return "", 0, 0, filter, fmt.Errorf("level must be debug, info, warning or error")
+	}
+	return c.Query("file"), lines, after, filter, nil
+}
+
+// logArchiveSince reads ?days= (default every file)
+func logArchiveSince(c *gin.Context) (time.Time, error) {
+	value := c.Query("days")
+	if value == "" {
+		return time.Time{}, nil
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days <= 0 {
+		return time.Time{}, fmt.Errorf("days must be a positive number")
+	}
+	return time.Now().AddDate(0, 0, -days), nil
+}
+
+// sendLogArchive streams the zip of log files as a download
+func sendLogArchive(c *gin.Context, since time.Time) {
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "tarr-logs-"+time.Now().Format("2006-01-02_15-04-05")+".zip"))
+	c.Status(http.StatusOK)
+	if err := writeLogArchive(c.Writer, since); err != nil {
+		log.Printf("Warning: log archive cut short: %v", err)
+	}
+}
+
+// logSubsystemNames lists the subsystems a tail can be filtered by
+func logSubsystemNames() []string {
+	names := []string{}
+	for _, subsystem := range logSubsystems {
+		names = append(names, subsystem.Name)
+	}
+	return append(names, "system")
+}
+
+// Handlers
+
+func listLogsHandler(c *gin.Context) {
+	files, err := listLogFiles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "files": files, "current": currentLogName(), "subsystems": logSubsystemNames()})
+}
+
+func tailLogHandler(c *gin.Context) {
+	name, lines, after, filter, err := logTailQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	tail, err := tailLog(name, lines, after, filter)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "file": tail.File, "lines": tail.Lines, "offset": tail.Offset, "truncated": tail.Truncated})
+}
+
+func downloadLogHandler(c *gin.Context) {
+	path, err := logFilePath(c.Query("file"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.FileAttachment(path, filepath.Base(path))
+}
+
+func downloadLogArchiveHandler(c *gin.Context) {
+	since, err := logArchiveSince(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	sendLogArchive(c, since)
+}
+
+// API handlers
+
+func apiListLogsHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	files, err := listLogFiles()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	respondOK(c, gin.H{"files": files, "current": currentLogName(), "subsystems": logSubsystemNames()})
+}
+
+func apiTailLogHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	name, lines, after, filter, err := logTailQuery(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+	tail, err := tailLog(name, lines, after, filter)
+	if err != nil {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	}
+	respondOK(c, tail)
+}
+
+func apiDownloadLogHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	path, err := logFilePath(c.Query("file"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	}
+	c.FileAttachment(path, filepath.Base(path))
+}
+
+func apiDownloadLogArchiveHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	since, err := logArchiveSince(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+	sendLogArchive(c, since)
+}
@@ -3,9 +3,11 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"html/template"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,28 +17,26 @@ import (
 	"strings"
 	"syscall"
 	"time"
-	"unicode/utf16"
 
-	"github.com/faiface/beep"
-	"github.com/faiface/beep/speaker"
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	"github.com/robfig/cron/v3"
 )
 
 type Config struct {
-	AdminUsername       string
-	AdminPassword       string
-	APIKey              string
-	APIEnabled          bool
-	BaseDir             string
-	JSONDir             string
-	MP3Dir              string
-	LogDir              string
-	CurrentVolume       float64
-	SelectedAudioDevice string
-	SessionSecret       string
+	AdminUsername string
+	AdminPassword string
+	APIKey        string
+	APIEnabled    bool
+	BaseDir       string
+	JSONDir       string
+	MP3Dir        string
+	AudioLibrary  []string // Read-only audio roots searched after MP3Dir
+	LogDir        string
+	StaticDir     string
+	TemplatesDir  string
+	ListenAddr    string
+	SessionSecret string
 }
 
 type AdminUser struct {
@@ -48,20 +48,24 @@ type AdminUser struct {
 	CreatedAt   string   `json:"created_at"`
 	LastLogin   string   `json:"last_login"`
 	Permissions []string `json:"permissions"`
+	// Announcements the user may queue by hand in any hour; 0 means no limit
+	MaxAnnouncementsPerHour int `json:"max_announcements_per_hour,omitempty"`
 }
 
 type APIKey struct {
-	ID          string   `json:"id"`
-	Name        string   `json:"name"`
-	Key         string   `json:"key"`
-	Enabled     bool     `json:"enabled"`
-	Permanent   bool     `json:"permanent"`
-	ExpiresAt   string   `json:"expires_at"`
-	CreatedAt   string   `json:"created_at"`
-	CreatedBy   string   `json:"created_by"`
-	LastUsed    string   `json:"last_used"`
-	Permissions []string `json:"permissions"`
-	RateLimit   struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Key          string   `json:"key"`
+	Enabled      bool     `json:"enabled"`
+	Permanent    bool     `json:"permanent"`
+	ExpiresAt    string   `json:"expires_at"`
+	CreatedAt    string   `json:"created_at"`
+	CreatedBy    string   `json:"created_by"`
+	LastUsed     string   `json:"last_used"`
+	Permissions  []string `json:"permissions"`
+	AllowedCIDRs []string `json:"allowed_cidrs,omitempty"` // Empty allows any address
+	MaxQueued    int      `json:"max_queued,omitempty"`    // Announcements queued or playing at once; 0 means no limit
+	RateLimit    struct {
 		RequestsPerHour int  `json:"requests_per_hour"`
 		Enabled         bool `json:"enabled"`
 	} `json:"rate_limit"`
@@ -71,10 +75,12 @@ type AdminConfig struct {
 	AdminUsers []AdminUser `json:"admin_users"`
 	APIKeys    []APIKey    `json:"api_keys"`
 	Security   struct {
-		SessionTimeoutMinutes  int    `json:"session_timeout_minutes"`
-		RequireAdminLogin      bool   `json:"require_admin_login"`
-		ShowDefaultCredentials bool   `json:"show_default_credentials"`
-		SessionSecret          string `json:"session_secret"`
+		SessionTimeoutMinutes  int      `json:"session_timeout_minutes"`
+		RequireAdminLogin      bool     `json:"require_admin_login"`
+		ShowDefaultCredentials bool     `json:"show_default_credentials"`
+		SessionSecret          string   `json:"session_secret"`
+		PreviousSessionSecrets []string `json:"previous_session_secrets,omitempty"` // Still accepted after a rotation
+		RequireScheduleApproval bool    `json:"require_schedule_approval"` // Operators' schedule edits wait for an admin
 		PasswordPolicy         struct {
 			MinLength           int  `json:"min_length"`
 			RequireSpecialChars bool `json:"require_special_chars"`
@@ -86,6 +92,25 @@ type AdminConfig struct {
 			Enabled                bool `json:"enabled"`
 		} `json:"failed_login_attempts"`
 	} `json:"security"`
+	API struct {
+		IdempotencyWindowMinutes int          `json:"idempotency_window_minutes"`
+		WebhookSecret            string       `json:"webhook_secret"`
+		TrustedProxies           []string     `json:"trusted_proxies"`
+		TokenSecret              string       `json:"token_secret"`
+		TokenTTLMinutes          int          `json:"token_ttl_minutes"`
+		CORS                     CORSSettings `json:"cors"`
+	} `json:"api"`
+	LDAP       LDAPSettings  `json:"ldap"`
+	OIDC       OIDCSettings  `json:"oidc"`
+	Fleet      FleetSettings `json:"fleet"`
+	Notifications NotificationSettings `json:"notifications"`
+	AssetSync  AssetSyncSettings `json:"asset_sync"`
+	Storage    StorageSettings   `json:"storage"`
+	HostMonitor HostMonitorSettings `json:"host_monitor"`
+	Snapshots  *ConfigSnapshotSettings `json:"snapshots,omitempty"` // nil: defaults
+	Clock      *ClockCheckSettings `json:"clock,omitempty"` // nil: defaults
+	HA         HASettings        `json:"ha"`
+	Replication ReplicationSettings `json:"replication"`
 	Metadata struct {
 		CreatedAt     string `json:"created_at"`
 		LastModified  string `json:"last_modified"`
@@ -122,6 +147,7 @@ type PromoAnnouncement struct {
 type SafetyLanguage struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
+	File string `json:"file,omitempty"` // Audio file in mp3/safety (default: safety_<id>.mp3)
 }
 
 type Emergency struct {
@@ -132,24 +158,33 @@ type Emergency struct {
 }
 
 type CronData struct {
-	StationAnnouncements []StationCronJob `json:"station_announcements"`
-	PromoAnnouncements   []PromoCronJob   `json:"promo_announcements"`
-	SafetyAnnouncements  []SafetyCronJob  `json:"safety_announcements"`
+	StationAnnouncements []StationCronJob  `json:"station_announcements"`
+	PromoAnnouncements   []PromoCronJob    `json:"promo_announcements"`
+	SafetyAnnouncements  []SafetyCronJob   `json:"safety_announcements"`
+	WeatherAnnouncements []WeatherCronJob  `json:"weather_announcements,omitempty"`
+	ClockAnnouncements   []ClockCronJob    `json:"clock_announcements,omitempty"`
+	Countdown            CountdownSettings `json:"countdown"`
+	Collisions           CollisionSettings `json:"collisions"`
+	Timezone             string            `json:"timezone,omitempty"` // IANA zone the cron expressions run in; default: the system's
 }
 
 type StationCronJob struct {
-	Enabled      bool   `json:"enabled"`
-	Cron         string `json:"cron"`
-	TrainNumber  string `json:"train_number"`
-	Direction    string `json:"direction"`
-	Destination  string `json:"destination"`
-	TrackNumber  string `json:"track_number"`
+	Enabled     bool     `json:"enabled"`
+	Cron        string   `json:"cron"`
+	TrainNumber string   `json:"train_number"`
+	Direction   string   `json:"direction"`
+	Destination string   `json:"destination"`
+	TrackNumber string   `json:"track_number"`
+	Languages   []string `json:"languages,omitempty"` // e.g. ["en", "es"] plays the announcement in both, back to back
+	Profiles    []string `json:"profiles,omitempty"`  // Schedule profiles it runs in; empty for all
 }
 
 type PromoCronJob struct {
-	Enabled bool   `json:"enabled"`
-	Cron    string `json:"cron"`
-	File    string `json:"file"`
+	Enabled   bool     `json:"enabled"`
+	Cron      string   `json:"cron"`
+	File      string   `json:"file"`
+	Languages []string `json:"languages,omitempty"` // Locales to play back to back (default: locales.json type_languages)
+	Profiles  []string `json:"profiles,omitempty"`  // Schedule profiles it runs in; empty for all
 }
 
 type SafetyCronJob struct {
@@ -158,6 +193,29 @@ type SafetyCronJob struct {
 	Language  string   `json:"language"`           // Legacy single language support
 	Languages []string `json:"languages,omitempty"` // New multi-language support
 	Delay     int      `json:"delay,omitempty"`     // Optional delay between languages in seconds (default: 2)
+	Profiles  []string `json:"profiles,omitempty"`  // Schedule profiles it runs in; empty for all
+}
+
+type WeatherCronJob struct {
+	Enabled  bool     `json:"enabled"`
+	Cron     string   `json:"cron"`
+	Template string   `json:"template,omitempty"` // Overrides the template in weather.json
+	Priority string   `json:"priority,omitempty"` // Default: normal
+	Zone     string   `json:"zone,omitempty"`
+	Profiles []string `json:"profiles,omitempty"` // Schedule profiles it runs in; empty for all
+}
+
+type ClockCronJob struct {
+	Enabled  bool     `json:"enabled"`
+	Mode     string   `json:"mode"`               // "chime" (default) or "time"
+	Source   string   `json:"source,omitempty"`   // Spoken time from "clips" (default) or "tts"
+	Hours    string   `json:"hours,omitempty"`    // e.g. "7-22", "22-2" or "8,12,17"; empty for every hour
+	Days     []string `json:"days,omitempty"`     // e.g. ["sat", "sun"]; empty for every day
+	Strike   bool     `json:"strike,omitempty"`   // Chime once per hour (1-12) instead of once
+	Chime    string   `json:"chime,omitempty"`    // Relative to the mp3 directory (default clock/chime.mp3)
+	Priority string   `json:"priority,omitempty"` // Default: low
+	Zone     string   `json:"zone,omitempty"`
+	Profiles []string `json:"profiles,omitempty"` // Schedule profiles it runs in; empty for all
 }
 
 type App struct {
@@ -169,79 +227,240 @@ type App struct {
 
 var app *App
 
+// appVersion is reported to the fleet manager; release builds set it with
+// -ldflags "-X main.appVersion=<version>"
+var appVersion = "2.1"
+
 func main() {
+	// Started by the Windows service manager, the service runs the
+	// annunciator and stops it when asked
+	if runAsService(runAnnunciator) {
+		return
+	}
+	runAnnunciator()
+}
+
+// runAnnunciator starts everything and serves until the process exits
+func runAnnunciator() {
 	fmt.Println("Starting TARR Annunciator...")
 	
-	// Initialize paths first
-	baseDir, _ := os.Getwd()
-	jsonDir := filepath.Join(baseDir, "json")
-	mp3Dir := filepath.Join(baseDir, "static", "mp3")
-	logDir := filepath.Join(baseDir, "logs")
+	// Initialize paths first: defaults < tarr.json < TARR_* environment < flags
+	startup, err := loadStartupConfig(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration error: %v\n", err)
+		os.Exit(2)
+	}
+	jsonDir := startup.JSONDir
 	
 	// Initialize logging system
-	if err := initializeLogging(logDir); err != nil {
-		log.Printf("Warning: Failed to initialize file logging: %v", err)
+	if err := initializeLogging(startup.LogDir); err != nil {
+		log.Printf("Warning: Failed to initialize file logging, logging to stdout only: %v", err)
+	}
+	if startup.LogSink != "" {
+		if err := startLogShipping(startup.LogSink, startup.LogSite, startup.LogInstance); err != nil {
+			log.Printf("Warning: Log shipping disabled: %v", err)
+		}
+	}
+	if startup.Source != "" {
+		log.Printf("✓ Startup config loaded from %s", startup.Source)
 	}
 
-	// Load admin configuration
-	adminConfig, err := loadAdminConfig(filepath.Join(jsonDir, "admin_config.json"))
+	// One copy per configuration directory, and the port must be free, before
+	// anything takes the audio device
+	if err := acquireInstanceLock(jsonDir, startup.ListenAddr); err != nil {
+		log.Fatalf("❌ Not starting: %v", err)
+	}
+	listener, err := openListener(startup.ListenAddr)
 	if err != nil {
+		log.Fatalf("❌ Not starting: %v", err)
+	}
+
+	// Fill an empty config volume from the defaults shipped in the image
+	if err := seedConfigDir(jsonDir, startup.DefaultJSONDir); err != nil {
+		log.Printf("Warning: Failed to seed config directory: %v", err)
+	}
+	// and whatever is still missing from the defaults built into the binary
+	if err := seedEmbeddedJSON(jsonDir); err != nil {
+		log.Printf("Warning: Failed to seed built-in defaults: %v", err)
+	}
+
+	// Load admin configuration. Without one the first-run setup wizard creates
+	// it, so no default credentials are ever accepted.
+	adminConfigFile := filepath.Join(jsonDir, "admin_config.json")
+	firstRun := !fileExists(adminConfigFile)
+	var adminConfig *AdminConfig
+	if firstRun {
+		adminConfig = newSetupAdminConfig()
+	} else if adminConfig, err = loadAdminConfig(adminConfigFile); err != nil {
 		log.Printf("Warning: Could not load admin config, using defaults: %v", err)
 		adminConfig = getDefaultAdminConfig()
+	} else {
+		ensureGeneratedSecrets(adminConfigFile, adminConfig)
 	}
+	warnDefaultSecrets(adminConfig)
 
 	// Get first admin user for backward compatibility
 	firstAdmin := getFirstAdminUser(adminConfig)
 	firstAPIKey := getFirstAPIKey(adminConfig)
+	if firstRun {
+		firstAdmin, firstAPIKey = AdminUser{}, APIKey{}
+	}
 
 	app = &App{
 		Config: &Config{
-			AdminUsername:       firstAdmin.Username,
-			AdminPassword:       firstAdmin.Password,
-			APIKey:              firstAPIKey.Key,
-			APIEnabled:          len(adminConfig.APIKeys) > 0 && firstAPIKey.Enabled,
-			CurrentVolume:       0.7,
-			SelectedAudioDevice: "default",
-			SessionSecret:       adminConfig.Security.SessionSecret,
-			BaseDir:             baseDir,
-			JSONDir:             jsonDir,
-			MP3Dir:              mp3Dir,
-			LogDir:              logDir,
+			AdminUsername: firstAdmin.Username,
+			AdminPassword: firstAdmin.Password,
+			APIKey:        firstAPIKey.Key,
+			APIEnabled:    len(adminConfig.APIKeys) > 0 && firstAPIKey.Enabled,
+			SessionSecret: adminConfig.Security.SessionSecret,
+			BaseDir:       startup.BaseDir,
+			JSONDir:       startup.JSONDir,
+			MP3Dir:        startup.MP3Dir,
+			AudioLibrary:  filepath.SplitList(startup.AudioLibrary),
+			LogDir:        startup.LogDir,
+			StaticDir:     startup.StaticDir,
+			TemplatesDir:  startup.TemplatesDir,
+			ListenAddr:    startup.ListenAddr,
 		},
 		Scheduler:    cron.New(),
 		AudioEnabled: true,
 	}
+	setCurrentVolume(startup.Volume)
+	setCurrentAudioDevice(startup.AudioDevice)
+
+	// Load audio playback settings
+	if err := loadAudioSettings(); err != nil {
+		log.Printf("Warning: %v, using defaults", err)
+	}
+
+	// Apply retention to logs, XML, the audio archive and backups, and
+	// watch free space
+	startStorageMonitor()
+
+	// Watch for Raspberry Pi throttling and overheating
+	startHostMonitor()
+
+	// Snapshot the JSON configuration every night
+	startConfigSnapshots()
+
+	// Load runtime settings (volume, device, quiet hours, log level...)
+	if err := loadRuntimeSettings(); err != nil {
+		log.Printf("Warning: %v, using defaults", err)
+	}
+	installLogLevelFilter()
+
+	// Load announcement locales
+	if err := loadLocaleSettings(); err != nil {
+		log.Printf("Warning: %v, using defaults", err)
+	}
+
+	// A read-only root is fine as long as the config directory is a writable volume
+	if !dirWritable(jsonDir) {
+		log.Printf("⚠️  %s is not writable: changes made in the admin interface or API will not be saved", jsonDir)
+	}
+
+	// Audio libraries are often network mounts, so a missing one is only reported
+	for _, dir := range app.Config.AudioLibrary {
+		if dirExists(dir) {
+			log.Printf("✓ Audio library: %s", dir)
+		} else {
+			log.Printf("⚠️  Audio library %s is not available; its audio will be reported missing", dir)
+		}
+	}
+
+	// Point the audio libraries at sound server sockets mounted into a container
+	if runningInContainer() {
+		containerAudio = configureContainerAudio()
+	}
 
 	// Initialize audio
 	if err := initAudio(); err != nil {
 		log.Printf("Audio initialization failed: %v", err)
 		app.AudioEnabled = false
+		notifyAudioBackendLost(currentAudioDevice(), err)
 	} else {
 		log.Println("✓ Audio system initialized successfully")
+		go preloadAudioCache()
 	}
+	startAudioRecovery()
 
 	// Initialize announcement queue system
 	InitializeAnnouncementManager()
 	log.Println("✓ Announcement queue system initialized")
 
-	// Initialize lightning trigger system
-	if err := initializeLightningTrigger(); err != nil {
-		log.Printf("Warning: Lightning trigger initialization failed: %v", err)
+	// Announcement types switched off by an operator stay off after a restart
+	if err := loadAnnouncementSwitches(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	startAnnouncementSwitchMonitor()
+
+	// Paused schedule sections and skipped entries stay held after a restart
+	if err := loadScheduleHolds(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	startScheduleHoldMonitor()
+
+	// A bench unit left in maintenance mode stays quiet after a restart
+	if err := loadMaintenanceMode(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	// Platforms, tracks and zones
+	if err := loadTrackLayout(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	syncZoneAmplifiers()
+
+	// Resume re-announcing open delays and cancellations
+	if err := loadDepartureStatuses(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	startDepartureStatusMonitor()
+	startCountdownMonitor()
+
+	// Uploaded one-off recordings do not outlive the queue
+	clearAdhocAudio()
+
+	// Rules turning trigger events into announcements
+	if err := loadTriggerRules(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	// Lightning and other triggers from triggers.json
+	if err := loadTriggers(); err != nil {
+		log.Printf("Warning: Trigger initialization failed: %v", err)
 	}
 
 	// Setup router
 	setupRouter(adminConfig)
+	if firstRun {
+		beginSetup()
+	}
 
-	// Start scheduler
-	app.Scheduler.Start()
-	defer app.Scheduler.Stop()
+	// Load the schedule; the scheduler starts once the clock is plausible
 	updateScheduler()
+	startClockCheck()
+	defer app.Scheduler.Stop()
+	startScheduleProfileMonitor()
+
+	// Report to the fleet manager when fleet mode is enabled
+	startFleetAgent()
+
+	// Watch the primary when this unit is its hot standby
+	startHighAvailability()
+
+	// Pull catalogs, schedules and audio from the primary on a timer
+	startReplication()
+
+	// Pull audio from the central source when scheduled sync is enabled
+	startAssetSync()
 
 	// Start server
 	log.Println("Starting TARR Annunciator Go Server...")
 	log.Printf("Audio system: %s", audioStatus())
-	log.Println("Access the application at: http://localhost:8080")
-	log.Println("Admin interface at: http://localhost:8080/admin")
+	log.Printf("Listening on %s", app.Config.ListenAddr)
+	log.Printf("Access the application at: http://%s", localURLHost(app.Config.ListenAddr))
+	log.Printf("Admin interface at: http://%s/admin", localURLHost(app.Config.ListenAddr))
 
 	// Setup graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -250,29 +469,36 @@ func main() {
 	go func() {
 		<-sigChan
 		log.Println("Received shutdown signal, cleaning up...")
-		
-		// Stop scheduler
-		if app.Scheduler != nil {
-			app.Scheduler.Stop()
-			log.Println("Scheduler stopped")
-		}
-		
-		// Stop lightning trigger
-		stopLightningTrigger()
-		log.Println("Lightning trigger stopped")
-		
-		// Close logging
-		closeLogging()
-		
+		shutdownAnnunciator()
 		os.Exit(0)
 	}()
 
-	app.Router.Run(":8080")
+	if err := app.Router.RunListener(listener); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
 }
 
-func initAudio() error {
-	sr := beep.SampleRate(44100)
-	return speaker.Init(sr, sr.N(time.Second/10))
+// shutdownAnnunciator stops the scheduler and triggers and releases the
+// audio device, amplifiers and alert strobe before the process exits
+func shutdownAnnunciator() {
+	// Stop scheduler
+	if app != nil && app.Scheduler != nil {
+		app.Scheduler.Stop()
+		log.Println("Scheduler stopped")
+	}
+
+	// Stop lightning and other triggers
+	stopAllTriggers()
+	log.Println("Triggers stopped")
+
+	// Release the audio device and switch the zone amplifiers and alert strobe off
+	closeAudio()
+	releaseZoneAmplifiers()
+	clearVisualAlert()
+
+	// Close logging
+	closeLogging()
+	stopLogShipping()
 }
 
 func audioStatus() string {
@@ -286,37 +512,53 @@ func setupRouter(adminConfig *AdminConfig) {
 	// Set Gin to release mode
 	gin.SetMode(gin.ReleaseMode)
 
-	app.Router = gin.Default()
+	// Panics in handlers are caught by our own recovery, which writes a
+	// crash report
+	app.Router = gin.New()
+	app.Router.Use(gin.Logger(), crashRecoveryMiddleware())
 
-	// Session store - use session secret from admin config
-	sessionSecret := adminConfig.Security.SessionSecret
-	if sessionSecret == "" {
-		sessionSecret = "2932d8c03fb85143293c803ff3f7f1c27923787e520ce335"
+	// Only believe X-Forwarded-For from configured proxies, otherwise API key
+	// IP allowlists could be bypassed with a forged header
+	if err := app.Router.SetTrustedProxies(adminConfig.API.TrustedProxies); err != nil {
+		log.Printf("⚠️  Invalid api.trusted_proxies: %v", err)
+		app.Router.SetTrustedProxies(nil)
 	}
-	store := cookie.NewStore([]byte(sessionSecret))
-	app.Router.Use(sessions.Sessions("session", store))
 
-	// Add template functions
-	app.Router.SetFuncMap(map[string]interface{}{
+	// Session store - signed with the session secret from admin config, and
+	// swapped in place when the secret is rotated
+	installSessionStore(adminConfig)
+	app.Router.Use(sessionMiddleware())
+	app.Router.Use(requestIDMiddleware())
+	app.Router.Use(corsMiddleware())
+	app.Router.Use(requireSetupComplete())
+
+	// Load HTML templates: the built-in ones, overridden by any on disk
+	templates, err := loadHTMLTemplates(app.Config.TemplatesDir, template.FuncMap{
 		"mul": func(a, b float64) float64 {
 			return a * b
 		},
 	})
-	
-	// Load HTML templates
-	app.Router.LoadHTMLGlob("templates/*")
-	app.Router.Static("/static", "./static")
+	if err != nil {
+		log.Fatalf("Failed to load HTML templates: %v", err)
+	}
+	app.Router.SetHTMLTemplate(templates)
+	app.Router.Static("/static", app.Config.StaticDir)
 
 	// Routes
 	setupWebRoutes()
 	setupAPIRoutes()
+	app.Router.NoRoute(apiNotFoundHandler)
 }
 
 func setupWebRoutes() {
+	// First-run setup wizard
+	app.Router.GET("/setup", setupPageHandler)
+	app.Router.POST("/setup", setupRateLimiter.middleware(), setupPostHandler)
+
 	app.Router.GET("/", indexHandler)
-	app.Router.POST("/play_announcement", playAnnouncementHandler)
-	app.Router.POST("/play_promo", playPromoHandler)
-	app.Router.POST("/play_safety_announcement", playSafetyHandler)
+	app.Router.POST("/play_announcement", announcementQuotaMiddleware(), playAnnouncementHandler)
+	app.Router.POST("/play_promo", announcementQuotaMiddleware(), playPromoHandler)
+	app.Router.POST("/play_safety_announcement", announcementQuotaMiddleware(), playSafetyHandler)
 	app.Router.GET("/scheduler_status", schedulerStatusHandler)
 	app.Router.GET("/audio_status", audioStatusHandler)
 
@@ -324,9 +566,20 @@ func setupWebRoutes() {
 	app.Router.GET("/admin/login", adminLoginGetHandler)
 	app.Router.POST("/admin/login", adminLoginPostHandler)
 	app.Router.GET("/admin/logout", adminLogoutHandler)
+	app.Router.GET("/admin/login/oidc", oidcLoginHandler)
+	app.Router.GET("/admin/login/oidc/callback", oidcCallbackHandler)
 	app.Router.GET("/admin", requireAuth(), adminHandler)
 	app.Router.POST("/admin", requireAuth(), adminPostHandler)
 
+	// Revision history of the schedule and catalogs
+	app.Router.GET("/admin/history", requireAuth(), adminHistoryHandler)
+	app.Router.GET("/admin/history/:file/:id", requireAuth(), adminRevisionHandler)
+	app.Router.POST("/admin/history/:file/:id/rollback", requireAuth(), adminRollbackHandler)
+
+	// CSV downloads of the announcement history and audit trail
+	app.Router.GET("/admin/export/history", requireAuth(), adminExportHistoryHandler)
+	app.Router.GET("/admin/export/audit", requireAuth(), adminExportAuditHandler)
+
 	// Audio control routes (admin only)
 	app.Router.GET("/audio/devices", requireAuth(), getAudioDevicesHandler)
 	app.Router.POST("/audio/devices", requireAuth(), setAudioDeviceHandler)
@@ -354,10 +607,13 @@ func setupWebRoutes() {
 	// System Control Routes (Authenticated)
 	app.Router.GET("/admin/system/info", requireAuth(), getSystemInfoHandler)
 	app.Router.POST("/admin/system/restart", requireAuth(), restartApplicationHandler)
+	app.Router.GET("/admin/system/restart/status", requireAuth(), restartStatusHandler)
+	app.Router.DELETE("/admin/system/restart", requireAuth(), cancelRestartHandler)
 	app.Router.POST("/admin/system/shutdown", requireAuth(), shutdownApplicationHandler)
 	
 	// Audio Management Routes (Authenticated)
 	app.Router.POST("/admin/audio/redetect", requireAuth(), redetectAudioDevicesHandler)
+	app.Router.POST("/admin/audio/reinit", requireAuth(), reinitAudioHandler)
 	app.Router.POST("/admin/audio/system-override", requireAuth(), audioSystemOverrideHandler)
 	app.Router.GET("/admin/system/platform-info", requireAuth(), getPlatformInfoHandler)
 	
@@ -369,34 +625,225 @@ func setupWebRoutes() {
 	app.Router.POST("/admin/bluetooth/pair", requireAuth(), pairBluetoothDeviceHandler)
 	app.Router.POST("/admin/bluetooth/unpair", requireAuth(), unpairBluetoothDeviceHandler)
 	
-	// Queue management routes (admin only) - session authenticated versions
-	app.Router.GET("/api/queue/status", requireAuth(), apiGetQueueStatusHandler)
-	app.Router.GET("/api/queue/history", requireAuth(), apiGetQueueHistoryHandler)
+	// Queue management routes - status and history also accept an API key (used by tarrctl)
+	app.Router.GET("/api/queue/status", requireAuthOrAPIKey(), apiGetQueueStatusHandler)
+	app.Router.GET("/api/queue/history", requireAuthOrAPIKey(), apiGetQueueHistoryHandler)
 	app.Router.POST("/api/queue/cancel", requireAuth(), apiCancelAnnouncementHandler)
 	
 	// Lightning trigger management routes (admin only)
 	app.Router.GET("/admin/lightning/status", requireAuth(), getLightningTriggerStatusHandler)
+	app.Router.GET("/admin/triggers/lightning/status", requireAuth(), adminLightningStormStatusHandler)
 	app.Router.POST("/admin/lightning/config", requireAuth(), updateLightningTriggerConfigHandler)
 	app.Router.POST("/admin/lightning/test", requireAuth(), testLightningFetchHandler)
 	app.Router.POST("/admin/lightning/test-condition/:condition", requireAuth(), testLightningConditionHandler)
+
+	// Speaker health test routes (admin only)
+	app.Router.GET("/admin/speaker-health", requireAuth(), getSpeakerHealthHandler)
+	app.Router.POST("/admin/speaker-health/run", requireAuth(), runSpeakerHealthTestHandler)
+
+	// Commissioning runs over every catalog clip (admin only)
+	app.Router.GET("/admin/commissioning", requireAuth(), getCommissioningHandler)
+	app.Router.POST("/admin/commissioning/start", requireAuth(), startCommissioningHandler)
+	app.Router.POST("/admin/commissioning/cancel", requireAuth(), cancelCommissioningHandler)
+	app.Router.GET("/admin/commissioning/punch-list", requireAuth(), punchListHandler)
+
+	// Log viewer: list, tail and download the log files (admin only)
+	app.Router.GET("/admin/logs", requireAuth(), listLogsHandler)
+	app.Router.GET("/admin/logs/tail", requireAuth(), tailLogHandler)
+	app.Router.GET("/admin/logs/download", requireAuth(), downloadLogHandler)
+	app.Router.GET("/admin/logs/archive", requireAuth(), downloadLogArchiveHandler)
+
+	// Crash reports from recovered panics (admin only)
+	app.Router.GET("/admin/crashes", requireAuth(), listCrashReportsHandler)
+	app.Router.GET("/admin/crashes/:id", requireAuth(), getCrashReportHandler)
+
+	// Go profiles, while profiling is on in the runtime settings (admin
+	// session or an API key with the config permission)
+	debugGroup := app.Router.Group("/debug", requireAuthOrAPIKey(), requireProfiling())
+	debugGroup.GET("/pprof/*profile", pprofHandler)
+	debugGroup.POST("/pprof/*profile", pprofHandler) // symbol lookups
+	debugGroup.GET("/memstats", memStatsHandler)
+
+	// Platform display board (public, same data as /api/public/now-playing)
+	app.Router.GET("/board", boardHandler)
+	app.Router.GET("/board/events", boardEventsHandler)
+	app.Router.GET("/board/state", publicRateLimiter.middleware(), boardStateHandler)
+
+	// Visual alerts for displays other than the board and kiosk (public)
+	app.Router.GET("/alerts/events", visualAlertEventsHandler)
+
+	// Guest kiosk page (public, never cached)
+	app.Router.GET("/kiosk", kioskHandler)
+	app.Router.GET("/kiosk/events", kioskEventsHandler)
+	app.Router.GET("/kiosk/state", publicRateLimiter.middleware(), kioskStateHandler)
+
+	// Live listen stream (admin session or API key)
+	app.Router.GET("/listen", requireAuthOrAPIKey(), listenLiveHandler)
+	app.Router.GET("/listen/status", requireAuthOrAPIKey(), listenStatusHandler)
+
+	// Live microphone paging (admin session or API key)
+	app.Router.GET("/live-page", requireAuthOrAPIKey(), livePageStatusHandler)
+	app.Router.POST("/live-page/start", requireAuthOrAPIKey(), livePageStartHandler)
+	app.Router.POST("/live-page/:id/audio", requireAuthOrAPIKey(), livePageAudioHandler)
+	app.Router.POST("/live-page/:id/stop", requireAuthOrAPIKey(), livePageStopHandler)
 }
 
 func setupAPIRoutes() {
-	api := app.Router.Group("/api")
+	// Versioned API
+	v1 := app.Router.Group("/api/v1")
+	registerAPIRoutes(v1)
+
+	// Queue management with API key auth (the unversioned paths are session authenticated)
+	v1Auth := v1.Group("", requireAPIKey())
+	{
+		v1Auth.GET("/queue/status", apiGetQueueStatusHandler)
+		v1Auth.GET("/queue/history", apiGetQueueHistoryHandler)
+		v1Auth.POST("/queue/cancel", apiCancelAnnouncementHandler)
+	}
 
+	// Legacy unversioned paths are aliases of v1
+	registerAPIRoutes(app.Router.Group("/api"))
+}
+
+// registerAPIRoutes registers the API endpoints on a route group so the same
+// handlers serve both /api/v1 and the legacy /api paths
+func registerAPIRoutes(api *gin.RouterGroup) {
 	// Public endpoints
 	api.GET("/status", apiStatusHandler)
 	api.GET("/platform", apiPlatformInfoHandler)
 	api.GET("/docs", apiDocsHandler)
 
+	// First-run setup, only usable while admin_config.json does not exist
+	api.GET("/setup", apiSetupStatusHandler)
+	api.POST("/setup", setupRateLimiter.middleware(), apiSetupHandler)
+
+	// Guest-facing display data: no authentication, rate limited per client
+	api.GET("/public/now-playing", publicRateLimiter.middleware(), apiPublicNowPlayingHandler)
+	api.GET("/public/visual-alert", publicRateLimiter.middleware(), apiVisualAlertHandler)
+
 	// Authenticated endpoints
 	authAPI := api.Group("", requireAPIKey())
 	{
-		authAPI.POST("/announce/station", apiStationAnnouncementHandler)
-		authAPI.POST("/announce/safety", apiSafetyAnnouncementHandler)
-		authAPI.POST("/announce/promo", apiPromoAnnouncementHandler)
-		authAPI.POST("/announce/emergency", apiEmergencyAnnouncementHandler)
+		authAPI.POST("/token", apiIssueTokenHandler)
+
+		// Secrets: default detection and rotation (keys need the config permission)
+		authAPI.GET("/secrets/status", apiSecretsStatusHandler)
+		authAPI.POST("/secrets/session/rotate", apiRotateSessionSecretHandler)
+		authAPI.DELETE("/secrets/session/previous", apiRetireSessionSecretsHandler)
+		authAPI.POST("/secrets/token/rotate", apiRotateTokenSecretHandler)
+		authAPI.POST("/secrets/webhook/rotate", apiRotateWebhookSecretHandler)
+		authAPI.POST("/secrets/api-keys/:id/rotate", apiRotateAPIKeyHandler)
+
+		// Fleet manager reporting
+		authAPI.GET("/fleet/status", apiFleetStatusHandler)
+
+		// Notifications (keys need the config permission)
+		authAPI.GET("/notifications", apiNotificationStatusHandler)
+		authAPI.POST("/notifications/test", apiNotificationTestHandler)
+
+		// Audio asset sync
+		authAPI.GET("/assets/sync", apiAssetSyncStatusHandler)
+		authAPI.POST("/assets/sync", apiRunAssetSyncHandler)
+
+		// Disk space and retention
+		authAPI.GET("/storage", apiStorageStatusHandler)
+		authAPI.POST("/storage/cleanup", apiStorageCleanupHandler)
+
+		// Pre/post-announcement hooks
+		authAPI.GET("/hooks", apiHooksStatusHandler)
+		authAPI.POST("/hooks/test", apiTestHookHandler)
+
+		// Zone amplifier relays
+		authAPI.GET("/amplifiers", apiAmplifierStatusHandler)
+		authAPI.POST("/amplifiers/:zone", apiSwitchAmplifierHandler)
+
+		// Hot standby: status, configuration for the standby, forced takeover
+		authAPI.GET("/ha/status", apiHAStatusHandler)
+		authAPI.GET("/ha/config", apiHAConfigHandler)
+		authAPI.POST("/ha/takeover", apiHATakeOverHandler)
+		authAPI.POST("/ha/release", apiHAReleaseHandler)
+
+		// Replication from a primary to secondary units (keys need the config permission)
+		authAPI.GET("/replication", apiReplicationStatusHandler)
+		authAPI.GET("/replication/bundle", apiReplicationBundleHandler)
+		authAPI.GET("/replication/audio/*path", apiReplicationAudioHandler)
+		authAPI.POST("/replication/apply", apiReplicationApplyHandler)
+		authAPI.POST("/replication/pull", apiReplicationPullHandler)
+		authAPI.POST("/replication/push", apiReplicationPushHandler)
+
+		// Nightly configuration snapshots and restoring them
+		authAPI.GET("/snapshots", apiListConfigSnapshotsHandler)
+		authAPI.POST("/snapshots", apiTakeConfigSnapshotHandler)
+		authAPI.GET("/snapshots/:id/download", apiDownloadConfigSnapshotHandler)
+		authAPI.POST("/snapshots/:id/restore", apiRestoreConfigSnapshotHandler)
+		authAPI.POST("/snapshots/import", apiImportConfigSnapshotHandler)
+
+		// Chunked, resumable uploads of large recordings and snapshot archives
+		authAPI.POST("/uploads", apiCreateUploadHandler)
+		authAPI.GET("/uploads/:id", apiGetUploadHandler)
+		authAPI.HEAD("/uploads/:id", apiGetUploadHandler)
+		authAPI.PUT("/uploads/:id", limitRequestBody(maxUploadChunkBytes), apiPutUploadChunkHandler)
+		authAPI.DELETE("/uploads/:id", apiDeleteUploadHandler)
+
+		// Statistics from the announcement history
+		authAPI.GET("/statistics", apiAnnouncementStatisticsHandler)
+
+		// Maintenance mode: run the queue without playing anything
+		authAPI.GET("/maintenance", apiGetMaintenanceHandler)
+		authAPI.PUT("/maintenance", apiSetMaintenanceHandler)
+
+		// Switching whole announcement types off, e.g. promos during a charter
+		authAPI.GET("/announcement-switches", apiListAnnouncementSwitchesHandler)
+		authAPI.PUT("/announcement-switches/:type", apiSetAnnouncementSwitchHandler)
+
+		// Visual alerts and strobe
+		authAPI.GET("/visual-alerts", apiVisualAlertStatusHandler)
+		authAPI.POST("/visual-alerts/test", apiTestVisualAlertHandler)
+
+		// Event scripts
+		authAPI.GET("/event-scripts", apiListEventScriptsHandler)
+		authAPI.GET("/event-scripts/:id", apiGetEventScriptHandler)
+		authAPI.PUT("/event-scripts/:id", apiPutEventScriptHandler)
+		authAPI.DELETE("/event-scripts/:id", apiDeleteEventScriptHandler)
+		authAPI.POST("/event-scripts/:id/arm", apiArmEventScriptHandler)
+		authAPI.POST("/event-scripts/:id/start", apiStartEventScriptHandler)
+		authAPI.POST("/event-scripts/:id/abort", apiAbortEventScriptHandler)
+
+		// Previews render the audio without queuing anything
+		authAPI.GET("/announce/preview", apiAnnouncementPreviewHandler)
+		authAPI.POST("/announce/preview", apiAnnouncementPreviewHandler)
+
+		// Announcement POSTs honour Idempotency-Key so client retries don't duplicate
+		// Quotas run after the idempotency check so replayed retries aren't counted twice
+		announce := authAPI.Group("/announce", idempotencyMiddleware(), announcementQuotaMiddleware())
+		announce.POST("/station", apiStationAnnouncementHandler)
+		announce.POST("/safety", apiSafetyAnnouncementHandler)
+		announce.POST("/promo", apiPromoAnnouncementHandler)
+		announce.POST("/emergency", apiEmergencyAnnouncementHandler)
+		announce.POST("/service-change", apiServiceChangeAnnouncementHandler)
+		announce.POST("/weather", apiAnnounceWeatherHandler)
+		// Uploads are size-limited before the idempotency check reads the body
+		authAPI.POST("/announce/adhoc", limitRequestBody(maxAdhocUploadBytes), idempotencyMiddleware(), announcementQuotaMiddleware(), apiAdhocAnnouncementHandler)
+		authAPI.GET("/weather", apiGetWeatherHandler)
+		authAPI.PUT("/weather", apiUpdateWeatherSettingsHandler)
+		authAPI.POST("/weather/preview", apiWeatherPreviewHandler)
+		authAPI.GET("/pronunciations", apiGetPronunciationsHandler)
+		authAPI.PUT("/pronunciations", apiPutPronunciationsHandler)
+		authAPI.GET("/content-filter", apiGetContentFilterHandler)
+		authAPI.PUT("/content-filter", apiPutContentFilterHandler)
+		authAPI.POST("/content-filter/test", apiTestContentFilterHandler)
+		authAPI.GET("/track-layout", apiGetTrackLayoutHandler)
+		authAPI.PUT("/track-layout", apiPutTrackLayoutHandler)
+		authAPI.GET("/track-layout/routing", apiTrackRoutingHandler)
+		authAPI.PUT("/track-layout/zones/:id", apiPutZoneHandler)
+		authAPI.PUT("/track-layout/tracks/:id/zones", apiPutTrackZonesHandler)
+		authAPI.GET("/departures/status", apiListDepartureStatusHandler)
+		authAPI.POST("/departures/:train_number/delay", apiDelayDepartureHandler)
+		authAPI.POST("/departures/:train_number/cancel", apiCancelDepartureHandler)
+		authAPI.DELETE("/departures/:train_number/status", apiResolveDepartureHandler)
 		authAPI.POST("/lightning/test/:condition", apiTestLightningConditionHandler)
+		authAPI.GET("/announcements/:id", apiGetAnnouncementHandler)
+		authAPI.GET("/announcements/:id/audio", apiGetAnnouncementAudioHandler)
 		authAPI.POST("/announcements/pause", apiPauseAnnouncementsHandler)
 		authAPI.POST("/announcements/resume", apiResumeAnnouncementsHandler)
 		authAPI.POST("/announcements/stop-current", apiStopCurrentAnnouncementHandler)
@@ -404,11 +851,68 @@ func setupAPIRoutes() {
 		authAPI.POST("/audio/volume", apiSetVolumeHandler)
 		authAPI.GET("/audio/devices", apiGetAudioDevicesHandler)
 		authAPI.POST("/audio/devices", apiSetAudioDeviceHandler)
+		authAPI.GET("/audio/cache", apiGetAudioCacheHandler)
+		authAPI.POST("/audio/cache/clear", apiClearAudioCacheHandler)
+		authAPI.GET("/audio/speaker-health", apiGetSpeakerHealthHandler)
+		authAPI.POST("/audio/speaker-health/run", apiRunSpeakerHealthTestHandler)
+		authAPI.GET("/commissioning", apiGetCommissioningHandler)
+		authAPI.POST("/commissioning", apiStartCommissioningHandler)
+		authAPI.DELETE("/commissioning", apiCancelCommissioningHandler)
+		authAPI.GET("/commissioning/punch-list", apiPunchListHandler)
+		authAPI.GET("/logs", apiListLogsHandler)
+		authAPI.GET("/logs/tail", apiTailLogHandler)
+		authAPI.GET("/logs/download", apiDownloadLogHandler)
+		authAPI.GET("/logs/archive", apiDownloadLogArchiveHandler)
+		authAPI.GET("/crashes", apiListCrashReportsHandler)
+		authAPI.GET("/crashes/:id", apiGetCrashReportHandler)
 		authAPI.GET("/config", apiGetConfigHandler)
+		authAPI.GET("/settings", apiGetSettingsHandler)
+		authAPI.PATCH("/settings", apiPatchSettingsHandler)
 		authAPI.GET("/schedule", apiGetScheduleHandler)
 		authAPI.POST("/schedule", apiPostScheduleHandler)
+		authAPI.GET("/schedule/changes", apiListScheduleChangesHandler)
+		authAPI.GET("/schedule/changes/:id", apiGetScheduleChangeHandler)
+		authAPI.POST("/schedule/changes/:id/approve", apiApproveScheduleChangeHandler)
+		authAPI.POST("/schedule/changes/:id/reject", apiRejectScheduleChangeHandler)
+		authAPI.GET("/schedule/profiles", apiGetScheduleProfilesHandler)
+		authAPI.PUT("/schedule/profiles", apiPutScheduleProfilesHandler)
+		authAPI.POST("/schedule/profiles/activate", apiActivateScheduleProfileHandler)
+		authAPI.GET("/schedule/holds", apiGetScheduleHoldsHandler)
+		authAPI.PUT("/schedule/pause/:section", apiPauseScheduleHandler)
+		authAPI.DELETE("/schedule/pause/:section", apiResumeScheduleHandler)
+		authAPI.POST("/schedule/skip", apiSkipScheduleEntryHandler)
+		authAPI.DELETE("/schedule/skip", apiUnskipScheduleEntryHandler)
+		authAPI.GET("/schedule/preview", apiSchedulePreviewHandler)
+		authAPI.GET("/schedule/collisions", apiGetScheduleCollisionsHandler)
 		authAPI.GET("/lightning/status", apiGetLightningStatusHandler)
+		authAPI.GET("/triggers/lightning/status", apiLightningStormStatusHandler)
 		authAPI.POST("/lightning/config", apiUpdateLightningConfigHandler)
+
+		// All trigger types through one interface
+		authAPI.GET("/triggers", apiListTriggersHandler)
+		authAPI.GET("/triggers/:id", apiGetTriggerHandler)
+		authAPI.POST("/triggers/:id/enable", apiEnableTriggerHandler)
+		authAPI.POST("/triggers/:id/disable", apiDisableTriggerHandler)
+		authAPI.PUT("/triggers/:id/config", apiConfigureTriggerHandler)
+		authAPI.POST("/triggers/:id/test", apiTestTriggerHandler)
+		authAPI.PUT("/triggers/:id/cooldown", apiSetTriggerCooldownHandler)
+		authAPI.GET("/trigger-rules", apiListTriggerRulesHandler)
+		authAPI.POST("/trigger-rules/evaluate", apiEvaluateTriggerRulesHandler)
+		authAPI.GET("/trigger-rules/:id", apiGetTriggerRuleHandler)
+		authAPI.PUT("/trigger-rules/:id", apiPutTriggerRuleHandler)
+		authAPI.DELETE("/trigger-rules/:id", apiDeleteTriggerRuleHandler)
+
+		// Catalog CRUD (trains, destinations, tracks, promos, safety, emergencies)
+		registerCatalogRoutes(authAPI)
+
+		// Safety languages, their recordings and the default rotation
+		authAPI.GET("/safety/languages", apiListSafetyLanguagesHandler)
+		authAPI.POST("/safety/languages", limitRequestBody(maxAdhocUploadBytes), apiCreateSafetyLanguageHandler)
+		authAPI.PUT("/safety/languages/:id", limitRequestBody(maxAdhocUploadBytes), apiUpdateSafetyLanguageHandler)
+		authAPI.DELETE("/safety/languages/:id", apiDeleteSafetyLanguageHandler)
+		authAPI.PUT("/safety/languages/:id/audio", limitRequestBody(maxAdhocUploadBytes), apiUploadSafetyAudioHandler)
+		authAPI.GET("/safety/rotation", apiGetSafetyRotationHandler)
+		authAPI.PUT("/safety/rotation", apiPutSafetyRotationHandler)
 	}
 }
 
@@ -429,8 +933,15 @@ func requireAuth() gin.HandlerFunc {
 func requireAPIKey() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if !app.Config.APIEnabled {
-			c.JSON(503, gin.H{"error": "API is disabled"})
-			c.Abort()
+			respondError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "API is disabled")
+			return
+		}
+
+		// Short-lived tokens from /api/token take the place of the key
+		if token := bearerToken(c); token != "" {
+			if authenticateBearerToken(c, token) {
+				c.Next()
+			}
 			return
 		}
 
@@ -444,8 +955,7 @@ func requireAPIKey() gin.HandlerFunc {
 		}
 
 		if apiKey == "" {
-			c.JSON(401, gin.H{"error": "API key required. Use X-API-Key header or api_key parameter."})
-			c.Abort()
+			respondError(c, http.StatusUnauthorized, ErrCodeUnauthorized, "API key required. Use X-API-Key header, api_key parameter or an Authorization: Bearer token.")
 			return
 		}
 
@@ -455,16 +965,19 @@ func requireAPIKey() gin.HandlerFunc {
 		if err != nil {
 			// Fall back to single API key check
 			if apiKey != app.Config.APIKey {
-				c.JSON(401, gin.H{"error": "Invalid API key"})
-				c.Abort()
+				respondError(c, http.StatusUnauthorized, ErrCodeUnauthorized, "Invalid API key")
 				return
 			}
 		} else {
 			// Check against multi-API key system
 			apiKeyData := findAPIKeyByKey(adminConfig, apiKey)
 			if apiKeyData == nil {
-				c.JSON(401, gin.H{"error": "Invalid API key"})
-				c.Abort()
+				respondError(c, http.StatusUnauthorized, ErrCodeUnauthorized, "Invalid API key")
+				return
+			}
+			if !apiKeyAllowsIP(apiKeyData, c.ClientIP()) {
+				log.Printf("🔐 API key %s rejected from %s (not in allowed_cidrs)", apiKeyData.ID, c.ClientIP())
+				respondError(c, http.StatusForbidden, ErrCodeForbidden, "API key is not allowed from this address")
 				return
 			}
 			
@@ -480,15 +993,34 @@ func requireAPIKey() gin.HandlerFunc {
 	}
 }
 
+// requireAuthOrAPIKey accepts either a logged-in admin session or a valid API key
+func requireAuthOrAPIKey() gin.HandlerFunc {
+	apiKeyAuth := requireAPIKey()
+	return func(c *gin.Context) {
+		session := sessions.Default(c)
+		if loggedIn, ok := session.Get("admin_logged_in").(bool); ok && loggedIn {
+			c.Next()
+			return
+		}
+		apiKeyAuth(c)
+	}
+}
+
 // Handlers
 func indexHandler(c *gin.Context) {
 	trains := loadJSON("trains", []Train{}).([]Train)
 	directions := loadJSON("directions", []Direction{}).([]Direction)
 	destinations := loadJSON("destinations", []Destination{}).([]Destination)
-	tracks := loadJSON("tracks", []Track{}).([]Track)
+	tracks := trackChoices()
 	promoAnnouncements := loadJSON("promo", []PromoAnnouncement{}).([]PromoAnnouncement)
 	safetyLanguages := loadJSON("safety", []SafetyLanguage{}).([]SafetyLanguage)
 
+	// The queue controls call the API; only hand the key to logged-in admins
+	apiKey := ""
+	if loggedIn, ok := sessions.Default(c).Get("admin_logged_in").(bool); ok && loggedIn {
+		apiKey = app.Config.APIKey
+	}
+
 	c.HTML(http.StatusOK, "index.html", gin.H{
 		"trains":               trains,
 		"directions":           directions,
@@ -496,6 +1028,7 @@ func indexHandler(c *gin.Context) {
 		"tracks":               tracks,
 		"promo_announcements":  promoAnnouncements,
 		"safety_languages":     safetyLanguages,
+		"api_key":              apiKey,
 	})
 }
 
@@ -567,37 +1100,74 @@ func playSafetyHandler(c *gin.Context) {
 
 func schedulerStatusHandler(c *gin.Context) {
 	jobs := make([]gin.H, 0)
-	for _, entry := range app.Scheduler.Entries() {
-		jobs = append(jobs, gin.H{
-			"next_run": entry.Next.Format("2006-01-02 15:04:05"),
-		})
+	for _, job := range scheduledJobList() {
+		view := gin.H{
+			"id":          job.ID,
+			"name":        job.Name,
+			"type":        job.Type,
+			"entry":       job.Entry,
+			"spec":        job.Spec,
+			"next_run":    "",
+			"last_run":    job.LastRun,
+			"last_result": job.LastResult,
+		}
+		if job.NextRun != nil {
+			view["next_run"] = job.NextRun.Format("2006-01-02 15:04:05")
+		}
+		if job.LastError != "" {
+			view["last_error"] = job.LastError
+		}
+		jobs = append(jobs, view)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"scheduler_running": true,
+		"scheduler_running": schedulerRunning(),
+		"clock":             currentClockStatus(),
 		"jobs":              jobs,
+		"timezone":          scheduleLocation(loadJSON("cron", CronData{}).(CronData).Timezone).String(),
 		"audio_available":   app.AudioEnabled,
+		"schedule_profile":  scheduleProfileStatus(loadScheduleProfiles()),
+		"schedule_holds":    currentScheduleHolds(),
 	})
 }
 
 func audioStatusHandler(c *gin.Context) {
-	chimePath := filepath.Join(app.Config.MP3Dir, "chime.mp3")
+	chimePath, _ := resolveAudioPath("chime.mp3")
 	chimeExists := fileExists(chimePath)
 	mp3DirExists := dirExists(app.Config.MP3Dir)
+	libraries := make([]gin.H, 0, len(app.Config.AudioLibrary))
+	for _, dir := range app.Config.AudioLibrary {
+		libraries = append(libraries, gin.H{"path": dir, "available": dirExists(dir)})
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"audio_available":        app.AudioEnabled,
-		"audio_backend":          "beep",
-		"current_volume":         app.Config.CurrentVolume,
-		"volume_percent":         int(app.Config.CurrentVolume * 100),
+		"audio_backend":          audioBackendName(),
+		"audio_recovery":         currentAudioRecoveryStatus(),
+		"current_volume":         currentVolume(),
+		"volume_percent":         int(currentVolume() * 100),
 		"chime_exists":          chimeExists,
 		"mp3_directory_exists":  mp3DirExists,
+		"audio_libraries":       libraries,
 	})
 }
 
 // Admin handlers
 func adminLoginGetHandler(c *gin.Context) {
-	c.HTML(http.StatusOK, "admin_login.html", nil)
+	c.HTML(http.StatusOK, "admin_login.html", adminLoginPageData(""))
+}
+
+// adminLoginPageData builds the login template data, including the SSO button
+func adminLoginPageData(errorMessage string) gin.H {
+	data := gin.H{}
+	if errorMessage != "" {
+		data["error"] = errorMessage
+	}
+	if settings, enabled := loadOIDCSettings(); enabled {
+		data["oidc_enabled"] = true
+		data["oidc_label"] = settings.ButtonLabel
+	}
+	return data
 }
 
 func adminLoginPostHandler(c *gin.Context) {
@@ -611,6 +1181,7 @@ func adminLoginPostHandler(c *gin.Context) {
 		// Fall back to single user check if config load fails
 		if username == app.Config.AdminUsername && password == app.Config.AdminPassword {
 			session := sessions.Default(c)
+			session.Clear() // Drop any role left by a directory or SSO login
 			session.Set("admin_logged_in", true)
 			session.Set("admin_user_id", "admin-001")
 			session.Save()
@@ -618,6 +1189,29 @@ func adminLoginPostHandler(c *gin.Context) {
 			return
 		}
 	} else {
+		// Try the directory first; local accounts remain the fallback when it
+		// is unreachable or does not know the user
+		if adminConfig.LDAP.Enabled {
+			ldapUser, err := authenticateLDAP(adminConfig.LDAP, username, password)
+			if err == nil {
+				log.Printf("🔐 LDAP login for %s (role %s)", ldapUser.Username, ldapUser.Role)
+				session := sessions.Default(c)
+				session.Clear()
+				session.Set("admin_logged_in", true)
+				session.Set("admin_user_id", "ldap:"+ldapUser.Username)
+				session.Set("admin_role", ldapUser.Role)
+				session.Save()
+				c.Redirect(http.StatusFound, "/admin")
+				return
+			}
+			log.Printf("🔐 LDAP login failed for %s: %v", username, err)
+			if err == errLDAPInvalidCredentials || err == errLDAPNoRole {
+				notifyFailedLogin(username, c.ClientIP())
+				c.HTML(http.StatusOK, "admin_login.html", adminLoginPageData("Invalid username or password!"))
+				return
+			}
+		}
+
 		// Check against multi-user system
 		user := findUserByUsername(adminConfig, username)
 		if user != nil && user.Password == password {
@@ -626,6 +1220,7 @@ func adminLoginPostHandler(c *gin.Context) {
 			saveAdminConfig(configPath, adminConfig)
 			
 			session := sessions.Default(c)
+			session.Clear() // Drop any role left by a directory or SSO login
 			session.Set("admin_logged_in", true)
 			session.Set("admin_user_id", user.ID)
 			session.Save()
@@ -634,14 +1229,14 @@ func adminLoginPostHandler(c *gin.Context) {
 		}
 	}
 
-	c.HTML(http.StatusOK, "admin_login.html", gin.H{
-		"error": "Invalid username or password!",
-	})
+	notifyFailedLogin(username, c.ClientIP())
+	c.HTML(http.StatusOK, "admin_login.html", adminLoginPageData("Invalid username or password!"))
 }
 
 func adminLogoutHandler(c *gin.Context) {
 	session := sessions.Default(c)
-	session.Delete("admin_logged_in")
+	// Clear the user and role too, so the next login on this browser starts fresh
+	session.Clear()
 	session.Save()
 	c.Redirect(http.StatusFound, "/")
 }
@@ -655,7 +1250,7 @@ func adminHandler(c *gin.Context) {
 	directions := loadJSON("directions", []Direction{}).([]Direction)
 	destinations := loadJSON("destinations", []Destination{}).([]Destination)
 	destinationsAvailable := loadJSON("destinations_available", []Destination{}).([]Destination)
-	tracks := loadJSON("tracks", []Track{}).([]Track)
+	tracks := trackChoices()
 	promoAnnouncements := loadJSON("promo", []PromoAnnouncement{}).([]PromoAnnouncement)
 	safetyLanguages := loadJSON("safety", []SafetyLanguage{}).([]SafetyLanguage)
 	// DEBUG: Check before loading emergencies
@@ -681,9 +1276,10 @@ func adminHandler(c *gin.Context) {
 		"promo_announcements":  promoAnnouncements,
 		"safety_languages":     safetyLanguages,
 		"emergencies":          emergencies,
-		"current_volume":       app.Config.CurrentVolume,
+		"current_volume":       currentVolume(),
 		"audio_devices":        audioDevices,
-		"selected_audio_device": app.Config.SelectedAudioDevice,
+		"selected_audio_device": currentAudioDevice(),
+		"api_key":               app.Config.APIKey,
 	})
 }
 
@@ -701,8 +1297,27 @@ func adminPostHandler(c *gin.Context) {
 		})
 		return
 	}
+	offsets, collisions := offsetScheduleCollisions(&cronData)
+	collisionNotice := ""
+	if len(collisions) > 0 || len(offsets) > 0 {
+		collisionNotice = fmt.Sprintf("&collisions=%d&offsets=%d", len(collisions), len(offsets))
+	}
 
-	if err := saveJSON("cron", cronData); err != nil {
+	// Operators' edits wait for an admin to approve them when that is required
+	if scheduleNeedsApproval(c) {
+		if _, err := submitScheduleChange(cronData, sessionUserID(c), c.PostForm("comment")); err != nil {
+			cronDataJSON, _ := json.MarshalIndent(cronData, "", "    ")
+			c.HTML(http.StatusInternalServerError, "admin.html", gin.H{
+				"error": fmt.Sprintf("Error submitting schedule change: %v", err),
+				"cron_data": string(cronDataJSON),
+			})
+			return
+		}
+		c.Redirect(http.StatusFound, "/admin?schedule=pending"+collisionNotice)
+		return
+	}
+
+	if err := saveJSONBy("cron", cronData, requestOperator(c), c.PostForm("comment")); err != nil {
 		cronDataJSON, _ := json.MarshalIndent(cronData, "", "    ")
 		
 		c.HTML(http.StatusInternalServerError, "admin.html", gin.H{
@@ -713,6 +1328,10 @@ func adminPostHandler(c *gin.Context) {
 	}
 
 	updateScheduler()
+	if collisionNotice != "" {
+		c.Redirect(http.StatusFound, "/admin?schedule=saved"+collisionNotice)
+		return
+	}
 	c.Redirect(http.StatusFound, "/admin")
 }
 
@@ -721,7 +1340,7 @@ func getAudioDevicesHandler(c *gin.Context) {
 	devices := getAudioDevices()
 	c.JSON(http.StatusOK, gin.H{
 		"devices": devices,
-		"current_device": app.Config.SelectedAudioDevice,
+		"current_device": currentAudioDevice(),
 	})
 }
 
@@ -755,7 +1374,14 @@ func setAudioDeviceHandler(c *gin.Context) {
 		return
 	}
 
-	app.Config.SelectedAudioDevice = deviceID
+	// Re-open the output so the new device takes effect
+	if err := reinitAudioForDevice(deviceID); err != nil {
+		log.Printf("Warning: audio backend re-init after device change failed: %v", err)
+	}
+
+	setCurrentAudioDevice(deviceID)
+	clearAudioFallback()
+	persistAudioDevice(deviceID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -778,16 +1404,17 @@ func setVolumeHandler(c *gin.Context) {
 		volume = 1.0
 	}
 
-	app.Config.CurrentVolume = volume
+	setCurrentVolume(volume)
+	persistVolume(volume)
 	c.JSON(http.StatusOK, gin.H{
 		"success":        true,
-		"volume":         app.Config.CurrentVolume,
-		"volume_percent": int(app.Config.CurrentVolume * 100),
+		"volume":         currentVolume(),
+		"volume_percent": int(currentVolume() * 100),
 	})
 }
 
 func testAudioHandler(c *gin.Context) {
-	chimePath := filepath.Join(app.Config.MP3Dir, "chime.mp3")
+	chimePath, _ := resolveAudioPath("chime.mp3")
 	if !fileExists(chimePath) {
 		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Test audio file not found"})
 		return
@@ -847,7 +1474,7 @@ func getDefaultAdminConfig() *AdminConfig {
 	defaultAPIKey := APIKey{
 		ID:          "api-001",
 		Name:        "Default API Key",
-		Key:         "tarr-api-2025", 
+		Key:         generateAPIKey(),
 		Enabled:     true,
 		Permanent:   false,
 		ExpiresAt:   "",
@@ -864,13 +1491,54 @@ func getDefaultAdminConfig() *AdminConfig {
 	config.Security.SessionTimeoutMinutes = 60
 	config.Security.RequireAdminLogin = true
 	config.Security.ShowDefaultCredentials = false
-	config.Security.SessionSecret = "tarr-session-secret-change-this"
+	config.Security.SessionSecret = randomSecret()
 	config.Security.PasswordPolicy.MinLength = 8
 	config.Security.PasswordPolicy.RequireSpecialChars = true
 	config.Security.PasswordPolicy.RequireNumbers = true
 	config.Security.FailedLoginAttempts.MaxAttempts = 5
 	config.Security.FailedLoginAttempts.LockoutDurationMinutes = 15
 	config.Security.FailedLoginAttempts.Enabled = true
+
+	// API settings
+	config.API.IdempotencyWindowMinutes = 60
+	config.API.WebhookSecret = randomSecret()
+	config.API.TrustedProxies = []string{}
+	config.API.TokenSecret = randomSecret()
+	config.API.TokenTTLMinutes = 15
+	config.API.CORS = getDefaultCORSSettings()
+
+	// Directory login
+	config.LDAP = getDefaultLDAPSettings()
+	config.OIDC = getDefaultOIDCSettings()
+
+	// Fleet manager reporting
+	config.Fleet = getDefaultFleetSettings()
+
+	// Alerts for critical events
+	config.Notifications = getDefaultNotificationSettings()
+
+	// Audio asset sync from a central source
+	config.AssetSync = getDefaultAssetSyncSettings()
+
+	// Free space thresholds and retention
+	config.Storage = getDefaultStorageSettings()
+
+	// Temperature and throttle alerts
+	config.HostMonitor = getDefaultHostMonitorSettings()
+
+	// Nightly snapshots of the JSON configuration
+	snapshots := getDefaultConfigSnapshotSettings()
+	config.Snapshots = &snapshots
+
+	// Hold the scheduler until the clock is plausible
+	clock := getDefaultClockCheckSettings()
+	config.Clock = &clock
+
+	// Hot standby (off until a role is set)
+	config.HA = getDefaultHASettings()
+
+	// Catalog, schedule and audio replication between station units
+	config.Replication = getDefaultReplicationSettings()
 	
 	// Metadata
 	config.Metadata.CreatedAt = time.Now().Format(time.RFC3339)
@@ -898,11 +1566,8 @@ func getFirstAPIKey(config *AdminConfig) APIKey {
 	if len(config.APIKeys) > 0 {
 		return config.APIKeys[0]
 	}
-	// Return default if no API keys
-	return APIKey{
-		Key:     "tarr-api-2025",
-		Enabled: true,
-	}
+	// No API keys means the API is disabled
+	return APIKey{}
 }
 
 func findUserByUsername(config *AdminConfig, username string) *AdminUser {
@@ -932,6 +1597,38 @@ func hasPermission(user *AdminUser, permission string) bool {
 	return false
 }
 
+// apiKeyAllowsIP checks the client address against the key's allowed_cidrs.
+// Entries may be CIDR ranges or single addresses.
+func apiKeyAllowsIP(apiKey *APIKey, clientIP string) bool {
+	if len(apiKey.AllowedCIDRs) == 0 {
+		return true
+	}
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	for _, entry := range apiKey.AllowedCIDRs {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAllowedCIDRs rejects allowlist entries that are neither a CIDR nor an IP
+func validateAllowedCIDRs(entries []string) error {
+	for _, entry := range entries {
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			return fmt.Errorf("invalid address or CIDR range: %s", entry)
+		}
+	}
+	return nil
+}
+
 func hasAPIPermission(apiKey *APIKey, permission string) bool {
 	for _, perm := range apiKey.Permissions {
 		if perm == permission {
@@ -961,6 +1658,7 @@ func getCredentialsHandler(c *gin.Context) {
 			"created_at":  user.CreatedAt,
 			"last_login":  user.LastLogin,
 			"permissions": user.Permissions,
+			"max_announcements_per_hour": user.MaxAnnouncementsPerHour,
 		}
 	}
 
@@ -979,6 +1677,7 @@ func getCredentialsHandler(c *gin.Context) {
 			"last_used":  key.LastUsed,
 			"permissions": key.Permissions,
 			"rate_limit": key.RateLimit,
+			"max_queued": key.MaxQueued,
 		}
 	}
 
@@ -1064,6 +1763,10 @@ func createUserHandler(c *gin.Context) {
 	if newUser.Permissions == nil {
 		newUser.Permissions = []string{"announcements"}
 	}
+	if newUser.MaxAnnouncementsPerHour < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_announcements_per_hour cannot be negative"})
+		return
+	}
 	newUser.CreatedAt = time.Now().Format(time.RFC3339)
 	newUser.Enabled = true
 
@@ -1133,6 +1836,11 @@ func updateUserHandler(c *gin.Context) {
 	if updateData.Permissions != nil {
 		user.Permissions = updateData.Permissions
 	}
+	if updateData.MaxAnnouncementsPerHour < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_announcements_per_hour cannot be negative"})
+		return
+	}
+	user.MaxAnnouncementsPerHour = updateData.MaxAnnouncementsPerHour
 	user.Enabled = updateData.Enabled
 
 	// Save config
@@ -1206,11 +1914,21 @@ func createAPIKeyHandler(c *gin.Context) {
 		return
 	}
 
+	if err := validateAllowedCIDRs(newAPIKey.AllowedCIDRs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Generate unique ID if not provided
 	if newAPIKey.ID == "" {
 		newAPIKey.ID = fmt.Sprintf("api-%03d", len(adminConfig.APIKeys)+1)
 	}
 
+	// Generate the key if none was given
+	if newAPIKey.Key == "" {
+		newAPIKey.Key = generateAPIKey()
+	}
+
 	// Check if key already exists
 	for _, key := range adminConfig.APIKeys {
 		if key.Key == newAPIKey.Key {
@@ -1236,6 +1954,11 @@ func createAPIKeyHandler(c *gin.Context) {
 		newAPIKey.CreatedBy = createdBy.(string)
 	}
 
+	if newAPIKey.MaxQueued < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_queued cannot be negative"})
+		return
+	}
+
 	// Set rate limit defaults
 	if newAPIKey.RateLimit.RequestsPerHour == 0 {
 		newAPIKey.RateLimit.RequestsPerHour = 1000
@@ -1307,6 +2030,18 @@ func updateAPIKeyHandler(c *gin.Context) {
 	if updateData.ExpiresAt != "" {
 		key.ExpiresAt = updateData.ExpiresAt
 	}
+	if updateData.AllowedCIDRs != nil {
+		if err := validateAllowedCIDRs(updateData.AllowedCIDRs); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		key.AllowedCIDRs = updateData.AllowedCIDRs
+	}
+	if updateData.MaxQueued < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_queued cannot be negative"})
+		return
+	}
+	key.MaxQueued = updateData.MaxQueued
 	key.Enabled = updateData.Enabled
 	key.Permanent = updateData.Permanent
 
@@ -1378,7 +2113,8 @@ var (
 	logWriter io.Writer
 )
 
-// initializeLogging sets up file logging with automatic rotation and cleanup
+// initializeLogging sets up file logging; old log files are removed by the
+// storage monitor
 func initializeLogging(logDir string) error {
 	// Create logs directory if it doesn't exist
 	if err := os.MkdirAll(logDir, 0755); err != nil {
@@ -1410,73 +2146,6 @@ func initializeLogging(logDir string) error {
 	log.Printf("Timestamp: %s", time.Now().Format("2006-01-02 15:04:05"))
 	log.Printf("=====================================")
 	
-	// Start log cleanup routine
-	go func() {
-		if err := cleanupOldLogs(logDir); err != nil {
-			log.Printf("Warning: Failed to cleanup old logs: %v", err)
-		}
-		
-		// Setup periodic cleanup (every 24 hours)
-		ticker := time.NewTicker(24 * time.Hour)
-		defer ticker.Stop()
-		
-		for range ticker.C {
-			if err := cleanupOldLogs(logDir); err != nil {
-				log.Printf("Warning: Failed to cleanup old logs: %v", err)
-			}
-		}
-	}()
-	
-	return nil
-}
-
-// cleanupOldLogs removes log files older than 30 days
-func cleanupOldLogs(logDir string) error {
-	log.Printf("Starting log cleanup routine...")
-	
-	// Read directory contents
-	files, err := os.ReadDir(logDir)
-	if err != nil {
-		return fmt.Errorf("failed to read logs directory: %v", err)
-	}
-	
-	cutoffTime := time.Now().AddDate(0, 0, -30) // 30 days ago
-	deletedCount := 0
-	totalSize := int64(0)
-	
-	for _, file := range files {
-		// Only process .log files
-		if !strings.HasSuffix(file.Name(), ".log") {
-			continue
-		}
-		
-		// Get file info
-		info, err := file.Info()
-		if err != nil {
-			log.Printf("Warning: Could not get info for log file %s: %v", file.Name(), err)
-			continue
-		}
-		
-		totalSize += info.Size()
-		
-		// Check if file is older than 30 days
-		if info.ModTime().Before(cutoffTime) {
-			filePath := filepath.Join(logDir, file.Name())
-			if err := os.Remove(filePath); err != nil {
-				log.Printf("Warning: Could not delete old log file %s: %v", file.Name(), err)
-			} else {
-				log.Printf("Deleted old log file: %s (%.2f MB, %s old)", 
-					file.Name(), 
-					float64(info.Size())/1024/1024,
-					time.Since(info.ModTime()).Round(24*time.Hour))
-				deletedCount++
-			}
-		}
-	}
-	
-	log.Printf("Log cleanup completed: %d files deleted, total log size: %.2f MB", 
-		deletedCount, float64(totalSize)/1024/1024)
-	
 	return nil
 }
 
@@ -1530,39 +2199,85 @@ func updateLightningTriggerConfigHandler(c *gin.Context) {
 		return
 	}
 	
-	// Update lightning trigger configuration
-	if lightningTrigger != nil {
-		if err := lightningTrigger.UpdateConfig(config.URL, config.FetchInterval, config.Timeout); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"status": "error",
-				"error":  "Failed to update lightning trigger configuration: " + err.Error(),
-			})
-			return
-		}
-		
-		// Update enabled state
-		lightningTrigger.Enabled = config.Enabled
-		
-		c.JSON(http.StatusOK, gin.H{
-			"status":  "success",
-			"message": "Lightning trigger configuration updated successfully",
-		})
-	} else {
+	// Update lightning trigger configuration; saved to triggers.json
+	if lightningTrigger == nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status": "error",
 			"error":  "Lightning trigger system not initialized",
 		})
+		return
 	}
+	settings := lightningTriggerSettings{URL: config.URL, FetchInterval: config.FetchInterval, Timeout: config.Timeout}
+	if err := configureLightningTrigger(settings, &config.Enabled); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "Failed to update lightning trigger configuration: " + err.Error(),
+		})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Lightning trigger configuration updated successfully",
+	})
 }
 
 // API handlers for lightning trigger
 func apiGetLightningStatusHandler(c *gin.Context) {
-	status := getLightningTriggerStatus()
-	c.JSON(http.StatusOK, status)
+	respondOK(c, getLightningTriggerStatus())
+}
+
+// Storm status: current condition, recent changes and counts
+func apiLightningStormStatusHandler(c *gin.Context) {
+	respondOK(c, getLightningStormStatus())
+}
+
+func adminLightningStormStatusHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   getLightningStormStatus(),
+	})
 }
 
 func apiUpdateLightningConfigHandler(c *gin.Context) {
-	updateLightningTriggerConfigHandler(c)
+	var config struct {
+		URL           string `json:"url"`
+		FetchInterval int    `json:"fetch_interval"`
+		Timeout       int    `json:"timeout"`
+	}
+
+	if err := c.ShouldBindJSON(&config); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+
+	var details []FieldError
+	if config.URL == "" {
+		details = append(details, FieldError{Field: "url", Message: "is required"})
+	}
+	if config.FetchInterval < 30 {
+		details = append(details, FieldError{Field: "fetch_interval", Message: "must be at least 30 seconds"})
+	}
+	if config.Timeout < 5 {
+		details = append(details, FieldError{Field: "timeout", Message: "must be at least 5 seconds"})
+	}
+	if len(details) > 0 {
+		respondValidationError(c, "Invalid lightning configuration", details...)
+		return
+	}
+
+	if lightningTrigger == nil {
+		respondError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "Lightning trigger system not initialized")
+		return
+	}
+
+	settings := lightningTriggerSettings{URL: config.URL, FetchInterval: config.FetchInterval, Timeout: config.Timeout}
+	if err := configureLightningTrigger(settings, nil); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to update lightning trigger configuration: "+err.Error())
+		return
+	}
+
+	respondSuccess(c, http.StatusOK, "Lightning trigger configuration updated successfully", getLightningTriggerStatus())
 }
 
 // Test lightning XML fetch handler
@@ -1626,45 +2341,13 @@ func testLightningFetchHandler(c *gin.Context) {
 		return
 	}
 	
-	// Convert XML from UTF-16 to UTF-8 if needed
-	xmlStr, err := convertXMLEncodingTest(xmlData)
+	// Parse the condition the same way the trigger does
+	xmlPreview := lightningXMLPreview(xmlData)
+	lightningAlert, err := extractLightningCondition(xmlData, lightningConditionPaths())
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
-			"status":  "error",
-			"message": "Failed to convert XML encoding: " + err.Error(),
-		})
-		return
-	}
-	
-	// Debug: Log XML preview for debugging
-	xmlPreview := xmlStr
-	if len(xmlStr) > 1000 {
-		xmlPreview = xmlStr[:1000] + "..."
-	}
-	log.Printf("Test Lightning XML preview (converted): %s", xmlPreview)
-	
-	// Check for lightningalert tag
-	startTag := "<lightningalert>"
-	endTag := "</lightningalert>"
-	
-	startIndex := strings.Index(xmlStr, startTag)
-	var lightningAlert string
-	
-	if startIndex != -1 {
-		startIndex += len(startTag)
-		endIndex := strings.Index(xmlStr[startIndex:], endTag)
-		if endIndex != -1 {
-			lightningAlert = strings.TrimSpace(xmlStr[startIndex : startIndex+endIndex])
-			log.Printf("Test Lightning: Successfully found value: '%s'", lightningAlert)
-		}
+		log.Printf("Test Lightning: %v", err)
 	} else {
-		// Check for case-insensitive version
-		lowerXML := strings.ToLower(xmlStr)
-		if strings.Contains(lowerXML, "<lightningalert>") {
-			log.Printf("Test Lightning: Found lightningalert tag in different case")
-		} else {
-			log.Printf("Test Lightning: No lightningalert tag found")
-		}
+		log.Printf("Test Lightning: Successfully found value: '%s'", lightningAlert)
 	}
 	
 	if lightningAlert != "" {
@@ -1678,7 +2361,7 @@ func testLightningFetchHandler(c *gin.Context) {
 	} else {
 		c.JSON(http.StatusOK, gin.H{
 			"status":          "warning",
-			"message":         "Test completed, but no lightningalert tag found in XML.",
+			"message":         "Test completed, but no lightning condition found in XML: " + err.Error(),
 			"xml_size":        len(xmlData),
 			"response_status": resp.Status,
 			"xml_preview":     xmlPreview,
@@ -1686,64 +2369,6 @@ func testLightningFetchHandler(c *gin.Context) {
 	}
 }
 
-// Convert XML encoding from UTF-16 to UTF-8 if needed (for test handler)
-func convertXMLEncodingTest(xmlData []byte) (string, error) {
-	// Check if the data starts with a UTF-16 BOM
-	if len(xmlData) >= 2 {
-		// UTF-16 LE BOM
-		if xmlData[0] == 0xFF && xmlData[1] == 0xFE {
-			return decodeUTF16LETest(xmlData[2:])
-		}
-		// UTF-16 BE BOM
-		if xmlData[0] == 0xFE && xmlData[1] == 0xFF {
-			return decodeUTF16BETest(xmlData[2:])
-		}
-	}
-	
-	// Check if it looks like UTF-16 by checking for null bytes in even positions
-	xmlStr := string(xmlData)
-	if len(xmlData) > 20 && strings.Contains(xmlStr[:100], "\x00") {
-		// Looks like UTF-16, try to decode as UTF-16 LE
-		decoded, err := decodeUTF16LETest(xmlData)
-		if err == nil && strings.Contains(decoded, "<?xml") {
-			return decoded, nil
-		}
-	}
-	
-	// Already UTF-8 or ASCII
-	return string(xmlData), nil
-}
-
-// Decode UTF-16 Little Endian (for test handler)
-func decodeUTF16LETest(data []byte) (string, error) {
-	if len(data)%2 != 0 {
-		return "", fmt.Errorf("odd length data for UTF-16")
-	}
-	
-	u16s := make([]uint16, len(data)/2)
-	for i := 0; i < len(u16s); i++ {
-		u16s[i] = uint16(data[i*2]) | uint16(data[i*2+1])<<8
-	}
-	
-	runes := utf16.Decode(u16s)
-	return string(runes), nil
-}
-
-// Decode UTF-16 Big Endian (for test handler)
-func decodeUTF16BETest(data []byte) (string, error) {
-	if len(data)%2 != 0 {
-		return "", fmt.Errorf("odd length data for UTF-16")
-	}
-	
-	u16s := make([]uint16, len(data)/2)
-	for i := 0; i < len(u16s); i++ {
-		u16s[i] = uint16(data[i*2])<<8 | uint16(data[i*2+1])
-	}
-	
-	runes := utf16.Decode(u16s)
-	return string(runes), nil
-}
-
 // Test lightning condition for debugging
 // API Test lightning condition handler  
 func apiTestLightningConditionHandler(c *gin.Context) {
@@ -1761,26 +2386,23 @@ func apiTestLightningConditionHandler(c *gin.Context) {
 	}
 	
 	if !valid {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid condition. Valid options: RedAlert, AllClear, Warning, Unknown",
+		respondValidationError(c, "Invalid condition", FieldError{
+			Field:   "condition",
+			Message: "must be one of RedAlert, AllClear, Warning, Unknown",
 		})
 		return
 	}
 	
-	if lightningTrigger != nil {
-		log.Printf("API: Manual %s test triggered", condition)
-		// Call the test function
-		lightningTrigger.TestCondition(condition)
-		c.JSON(http.StatusOK, gin.H{
-			"success": true,
-			"message": fmt.Sprintf("%s test triggered", condition),
-			"condition": condition,
-		})
-	} else {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Lightning trigger not available",
-		})
+	if lightningTrigger == nil {
+		respondError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "Lightning trigger not available")
+		return
 	}
+
+	log.Printf("API: Manual %s test triggered", condition)
+	lightningTrigger.TestCondition(condition)
+	respondSuccess(c, http.StatusAccepted, fmt.Sprintf("%s test triggered", condition), gin.H{
+		"condition": condition,
+	})
 }
 
 func testLightningConditionHandler(c *gin.Context) {
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Maintenance mode lets technicians run the schedule on the bench without
+// sound. The queue carries on as usual: schedules and triggers fire, and
+// announcements are queued, ordered, spaced and finished. Nothing is played,
+// though. Each announcement is logged with what would have been heard and
+// takes its expected length before the next one starts. Hooks, amplifiers,
+// the audio archive and visual alerts are skipped as well, and live pages are
+// refused. The system and queue status report the mode. It is kept in
+// maintenance.json so a bench unit stays quiet after a restart.
+
+// MaintenanceMode is the content of maintenance.json
+type MaintenanceMode struct {
+	Enabled bool       `json:"enabled"`
+	Reason  string     `json:"reason,omitempty"`
+	SetBy   string     `json:"set_by,omitempty"`
+	SetAt   *time.Time `json:"set_at,omitempty"`
+}
+
+var maintenance = struct {
+	sync.Mutex
+	mode      MaintenanceMode
+	simulated int // Announcements simulated since the mode was switched on
+}{}
+
+// loadMaintenanceMode restores the mode after a restart
+func loadMaintenanceMode() error {
+	filePath, _ := jsonFilePath("maintenance")
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read maintenance.json: %v", err)
+	}
+	var mode MaintenanceMode
+	if err := json.Unmarshal(data, &mode); err != nil {
+		return fmt.Errorf("failed to parse maintenance.json: %v", err)
+	}
+
+	maintenance.Lock()
+	maintenance.mode = mode
+	maintenance.Unlock()
+	if mode.Enabled {
+		log.Printf("🔧 Maintenance mode is on: announcements are logged, not played")
+	}
+	return nil
+}
+
+// maintenanceActive reports whether output is silenced
+func maintenanceActive() bool {
+	maintenance.Lock()
+	defer maintenance.Unlock()
+	return maintenance.mode.Enabled
+}
+
+// maintenanceStatus is the mode as reported in the system and queue status
+func maintenanceStatus() gin.H {
+	maintenance.Lock()
+	defer maintenance.Unlock()
+	status := gin.H{"enabled": maintenance.mode.Enabled}
+	if maintenance.mode.Enabled {
+		status["reason"] = maintenance.mode.Reason
+		status["set_by"] = maintenance.mode.SetBy
+		status["set_at"] = maintenance.mode.SetAt
+		status["simulated"] = maintenance.simulated
+	}
+	return status
+}
+
+// simulateAnnouncement stands in for playing an announcement: it logs what
+// would have been heard and waits as long as the audio would have taken,
+// unless the announcement is stopped first
+func (am *AnnouncementManager) simulateAnnouncement(announcement *Announcement) error {
+	files := make([]string, len(announcement.AudioFiles))
+	for i, file := range announcement.AudioFiles {
+		files[i] = audioDisplayPath(file)
+	}
+	log.Printf("🔧 Maintenance mode, not played: ID=%s, Type=%s, Zones=%v, Length=%s, Caption=%q, Files=%s",
+		announcement.ID, announcement.Type, announcementZones(announcement.Parameters),
+		announcement.EstimatedDuration.Round(time.Second), announcement.Caption, strings.Join(files, ", "))
+
+	maintenance.Lock()
+	maintenance.simulated++
+	maintenance.Unlock()
+
+	if announcement.EstimatedDuration <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(announcement.EstimatedDuration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-am.cancelChan:
+		return errPlaybackCancelled
+	}
+}
+
+// Handlers
+
+// apiGetMaintenanceHandler reports whether maintenance mode is on
+func apiGetMaintenanceHandler(c *gin.Context) {
+	respondOK(c, maintenanceStatus())
+}
+
+// apiSetMaintenanceHandler switches maintenance mode on or off. Switching it
+// off while an announcement is being simulated lets that one finish silently.
+func apiSetMaintenanceHandler(c *gin.Context) {
+	data, ok := bindRequestData(c, "enabled", "reason")
+	if !ok {
+		return
+	}
+	enabled, ok := data["enabled"].(bool)
+	if !ok {
+		respondValidationError(c, "Invalid maintenance mode", FieldError{Field: "enabled", Message: "is required (true or false)"})
+		return
+	}
+	reason, _ := data["reason"].(string)
+
+	mode := MaintenanceMode{Enabled: enabled}
+	if enabled {
+		now := time.Now()
+		mode.Reason = strings.TrimSpace(reason)
+		mode.SetBy = requestOperator(c)
+		mode.SetAt = &now
+	}
+
+	maintenance.Lock()
+	wasEnabled := maintenance.mode.Enabled
+	if err := saveJSON("maintenance", mode); err != nil {
+		maintenance.Unlock()
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save maintenance mode: "+err.Error())
+		return
+	}
+	maintenance.mode = mode
+	if enabled && !wasEnabled {
+		maintenance.simulated = 0
+	}
+	simulated := maintenance.simulated
+	maintenance.Unlock()
+
+	message := "Maintenance mode switched off; announcements play again"
+	if enabled {
+		message = "Maintenance mode switched on; announcements are logged, not played"
+		if !wasEnabled {
+			log.Printf("🔧 Maintenance mode switched on by %s", requestOperator(c))
+		}
+	} else if wasEnabled {
+		log.Printf("🔧 Maintenance mode switched off by %s after %d simulated announcements", requestOperator(c), simulated)
+	}
+	queueEvents.publish()
+	respondSuccess(c, http.StatusOK, message, maintenanceStatus())
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The Modbus trigger polls registers on a Modbus/TCP device (water level and
+// gate sensors, PLCs) and publishes changes to the trigger rules. A register
+// with thresholds publishes the name of the band its value falls in, e.g.
+// "high"; a register without thresholds publishes its value whenever it
+// changes.
+
+const modbusDefaultPort = 502
+
+// ModbusTriggerConfig is the Modbus trigger's settings in triggers.json
+type ModbusTriggerConfig struct {
+	Host          string           `json:"host"`
+	Port          int              `json:"port"`
+	UnitID        int              `json:"unit_id"`
+	FetchInterval int              `json:"fetch_interval"` // seconds
+	Timeout       int              `json:"timeout"`        // seconds
+	Registers     []ModbusRegister `json:"registers"`
+}
+
+// ModbusRegister is one value read from the device
+type ModbusRegister struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name,omitempty"`
+	Function   string            `json:"function"`        // holding, input, coil or discrete
+	Address    int               `json:"address"`         // zero-based
+	DataType   string            `json:"type,omitempty"`  // uint16 (default), int16, uint32, int32, float32; ignored for coils and discrete inputs
+	Scale      float64           `json:"scale,omitempty"` // multiplier applied to the raw value, default 1
+	Offset     float64           `json:"offset,omitempty"`
+	Thresholds []ModbusThreshold `json:"thresholds,omitempty"`
+}
+
+// ModbusThreshold names a band of values. The first threshold whose bounds
+// contain the value wins; a value in no band is "normal".
+type ModbusThreshold struct {
+	State string   `json:"state"`
+	Above *float64 `json:"above,omitempty"` // value > above
+	Below *float64 `json:"below,omitempty"` // value < below
+}
+
+// modbusFunctionCodes maps register functions onto Modbus read function codes
+var modbusFunctionCodes = map[string]byte{
+	"coil":     0x01,
+	"discrete": 0x02,
+	"holding":  0x03,
+	"input":    0x04,
+}
+
+// modbusRegisterWords is the number of 16-bit registers each data type spans
+var modbusRegisterWords = map[string]int{
+	"": 1, "uint16": 1, "int16": 1, "uint32": 2, "int32": 2, "float32": 2,
+}
+
+// modbusReading is the last value read from a register
+type modbusReading struct {
+	Value     float64   `json:"value"`
+	State     string    `json:"state"`
+	ReadAt    time.Time `json:"read_at"`
+	Error     string    `json:"error,omitempty"`
+	published string
+}
+
+// ModbusTrigger polls a Modbus/TCP device
+type ModbusTrigger struct {
+	ID      string
+	Name    string
+	Enabled bool
+	Config  ModbusTriggerConfig
+
+	loop      triggerLoop
+	mutex     sync.Mutex // guards the readings and last fetch
+	ioMutex   sync.Mutex // one request at a time, so polls and tests don't interleave
+	readings  map[string]*modbusReading
+	lastFetch time.Time
+	lastError string
+	nextTxn   uint16
+}
+
+// newModbusTriggerFromDefinition builds a Modbus trigger from triggers.json
+func newModbusTriggerFromDefinition(definition TriggerDefinition) (Trigger, error) {
+	t := &ModbusTrigger{
+		ID:       definition.ID,
+		Name:     definition.Name,
+		Enabled:  definition.Enabled,
+		Config:   ModbusTriggerConfig{Port: modbusDefaultPort, UnitID: 1, FetchInterval: 10, Timeout: 5},
+		readings: map[string]*modbusReading{},
+	}
+	if t.Name == "" {
+		t.Name = definition.ID
+	}
+	if len(definition.Settings) > 0 {
+		if err := t.Configure(definition.Settings); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// validate checks the device address, intervals and register map
+func (c ModbusTriggerConfig) validate() error {
+	if strings.TrimSpace(c.Host) == "" {
+		return fmt.Errorf("host is required")
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("port must be 1-65535")
+	}
+	if c.UnitID < 0 || c.UnitID > 255 {
+		return fmt.Errorf("unit_id must be 0-255")
+	}
+	if c.FetchInterval < 1 {
+		return fmt.Errorf("fetch_interval must be at least 1 second")
+	}
+	if c.Timeout < 1 {
+		return fmt.Errorf("timeout must be at least 1 second")
+	}
+	if len(c.Registers) == 0 {
+		return fmt.Errorf("at least one register is required")
+	}
+	seen := map[string]bool{}
+	for _, register := range c.Registers {
+		if !catalogIDPattern.MatchString(register.ID) || seen[register.ID] {
+			return fmt.Errorf("register id '%s' is missing, malformed or duplicated", register.ID)
+		}
+		seen[register.ID] = true
+		if _, ok := modbusFunctionCodes[register.Function]; !ok {
+			return fmt.Errorf("register '%s': function must be holding, input, coil or discrete", register.ID)
+		}
+		if register.Address < 0 || register.Address > 65535 {
+			return fmt.Errorf("register '%s': address must be 0-65535", register.ID)
+		}
+		isBit := register.Function == "coil" || register.Function == "discrete"
+		if _, ok := modbusRegisterWords[register.DataType]; !ok && !isBit {
+			return fmt.Errorf("register '%s': type must be uint16, int16, uint32, int32 or float32", register.ID)
+		}
+		for _, threshold := range register.Thresholds {
+			if threshold.State == "" || (threshold.Above == nil && threshold.Below == nil) {
+				return fmt.Errorf("register '%s': each threshold needs a state and above and/or below", register.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// Start begins polling the device (the first poll is immediate)
+func (t *ModbusTrigger) Start() error {
+	t.Enabled = true
+	t.loop.start(t.Name, time.Duration(t.Config.FetchInterval)*time.Second, true, t.poll)
+	return nil
+}
+
+// Stop ends polling
+func (t *ModbusTrigger) Stop() {
+	t.loop.stop()
+	t.Enabled = false
+}
+
+// Running reports whether the device is being polled
+func (t *ModbusTrigger) Running() bool {
+	return t.loop.running()
+}
+
+// Settings returns the settings stored in triggers.json
+func (t *ModbusTrigger) Settings() interface{} {
+	return t.Config
+}
+
+// Configure replaces the device settings and register map, restarting the
+// poll if it was running
+func (t *ModbusTrigger) Configure(raw json.RawMessage) error {
+	config := t.Config
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return err
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	wasRunning := t.Running()
+	if wasRunning {
+		t.loop.stop()
+	}
+	t.mutex.Lock()
+	t.Config = config
+	t.readings = map[string]*modbusReading{}
+	t.mutex.Unlock()
+	if wasRunning {
+		return t.Start()
+	}
+	return nil
+}
+
+// Status describes the device and the last reading of each register
+func (t *ModbusTrigger) Status() map[string]interface{} {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	readings := make(map[string]modbusReading, len(t.readings))
+	for id, reading := range t.readings {
+		readings[id] = *reading
+	}
+	return map[string]interface{}{
+		"id":             t.ID,
+		"name":           t.Name,
+		"enabled":        t.Enabled,
+		"running":        t.Running(),
+		"host":           t.Config.Host,
+		"port":           t.Config.Port,
+		"unit_id":        t.Config.UnitID,
+		"fetch_interval": t.Config.FetchInterval,
+		"last_fetch":     t.lastFetch.Format("2006-01-02 15:04:05"),
+		"last_error":     t.lastError,
+		"registers":      readings,
+	}
+}
+
+// Test reads every register once and reports the values and states without
+// publishing them. A value of "register=state" publishes that state to the
+// trigger rules as if it had been read.
+func (t *ModbusTrigger) Test(value string) (map[string]interface{}, error) {
+	if value != "" {
+		id, state, ok := strings.Cut(value, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("value must be register=state")
+		}
+		fired := publishTriggerEvent(TriggerEvent{Source: t.ID, Type: "modbus", Field: id, Value: state})
+		return map[string]interface{}{"register": id, "state": state, "rules_fired": fired}, nil
+	}
+
+	values, err := t.readAll()
+	if err != nil {
+		return nil, err
+	}
+	registers := make(map[string]interface{}, len(values))
+	for _, register := range t.Config.Registers {
+		reading := values[register.ID]
+		registers[register.ID] = map[string]interface{}{
+			"value": reading.Value,
+			"state": reading.State,
+			"error": reading.Error,
+		}
+	}
+	return map[string]interface{}{"registers": registers}, nil
+}
+
+// poll reads the registers and publishes each change
+func (t *ModbusTrigger) poll() {
+	values, err := t.readAll()
+
+	t.mutex.Lock()
+	t.lastFetch = time.Now()
+	t.lastError = ""
+	if err != nil {
+		t.lastError = err.Error()
+		t.mutex.Unlock()
+		log.Printf("Modbus trigger '%s' poll failed: %v", t.Name, err)
+		return
+	}
+	var events []TriggerEvent
+	for _, register := range t.Config.Registers {
+		reading := values[register.ID]
+		previous := t.readings[register.ID]
+		if previous != nil && reading.Error != "" {
+			// Keep the last good value and state; the error is shown in the status
+			previous.Error = reading.Error
+			continue
+		}
+		t.readings[register.ID] = reading
+		if reading.Error != "" {
+			continue
+		}
+		published := ""
+		if previous != nil {
+			published = previous.published
+		}
+		reading.published = published
+		if reading.State != published {
+			reading.published = reading.State
+			events = append(events, TriggerEvent{Source: t.ID, Type: "modbus", Field: register.ID, Value: reading.State, Previous: published})
+		}
+	}
+	t.mutex.Unlock()
+
+	// Published outside the mutex so a slow rule doesn't block the status page
+	for _, event := range events {
+		log.Printf("Modbus trigger '%s' register '%s' is now %s", t.Name, event.Field, event.Value)
+		publishTriggerEvent(event)
+	}
+}
+
+// readAll reads every register over one connection. A failure of one
+// register is recorded against it; failing to connect fails the whole poll.
+func (t *ModbusTrigger) readAll() (map[string]*modbusReading, error) {
+	t.ioMutex.Lock()
+	defer t.ioMutex.Unlock()
+	timeout := time.Duration(t.Config.Timeout) * time.Second
+	address := net.JoinHostPort(t.Config.Host, strconv.Itoa(t.Config.Port))
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	values := make(map[string]*modbusReading, len(t.Config.Registers))
+	for _, register := range t.Config.Registers {
+		reading := &modbusReading{ReadAt: time.Now()}
+		conn.SetDeadline(time.Now().Add(timeout))
+		value, err := t.readRegister(conn, register)
+		if err != nil {
+			reading.Error = err.Error()
+			if _, isNetErr := err.(net.Error); isNetErr || err == io.EOF {
+				// The connection is unusable; fail the remaining registers too
+				for _, rest := range t.Config.Registers {
+					if values[rest.ID] == nil {
+						values[rest.ID] = &modbusReading{ReadAt: reading.ReadAt, Error: err.Error()}
+					}
+				}
+				return values, nil
+			}
+		} else {
+			reading.Value = value
+			reading.State = register.stateFor(value)
+		}
+		values[register.ID] = reading
+	}
+	return values, nil
+}
+
+// readRegister sends one read request and decodes the register's value
+func (t *ModbusTrigger) readRegister(conn net.Conn, register ModbusRegister) (float64, error) {
+	function := modbusFunctionCodes[register.Function]
+	quantity := 1
+	if function == 0x03 || function == 0x04 {
+		quantity = modbusRegisterWords[register.DataType]
+	}
+
+	t.nextTxn++
+	request := make([]byte, 12)
+	binary.BigEndian.PutUint16(request[0:], t.nextTxn)
+	binary.BigEndian.PutUint16(request[2:], 0) // protocol: Modbus
+	binary.BigEndian.PutUint16(request[4:], 6) // unit ID + PDU
+	request[6] = byte(t.Config.UnitID)
+	request[7] = function
+	binary.BigEndian.PutUint16(request[8:], uint16(register.Address))
+	binary.BigEndian.PutUint16(request[10:], uint16(quantity))
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, err
+	}
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	if binary.BigEndian.Uint16(header[0:]) != t.nextTxn || length < 2 || length > 254 {
+		return 0, fmt.Errorf("malformed Modbus response")
+	}
+	pdu := make([]byte, length-1)
+	if _, err := io.ReadFull(conn, pdu); err != nil {
+		return 0, err
+	}
+	if pdu[0] == function|0x80 {
+		return 0, fmt.Errorf("Modbus exception %d", pdu[1])
+	}
+	if pdu[0] != function || len(pdu) < 2 || int(pdu[1]) != len(pdu)-2 {
+		return 0, fmt.Errorf("malformed Modbus response")
+	}
+	return register.decode(function, pdu[2:])
+}
+
+// decode converts the response data into the register's scaled value
+func (r ModbusRegister) decode(function byte, data []byte) (float64, error) {
+	if function == 0x01 || function == 0x02 {
+		if len(data) < 1 {
+			return 0, fmt.Errorf("short Modbus response")
+		}
+		return float64(data[0] & 1), nil
+	}
+	if len(data) < 2*modbusRegisterWords[r.DataType] {
+		return 0, fmt.Errorf("short Modbus response")
+	}
+
+	var raw float64
+	switch r.DataType {
+	case "", "uint16":
+		raw = float64(binary.BigEndian.Uint16(data))
+	case "int16":
+		raw = float64(int16(binary.BigEndian.Uint16(data)))
+	case "uint32":
+		raw = float64(binary.BigEndian.Uint32(data))
+	case "int32":
+		raw = float64(int32(binary.BigEndian.Uint32(data)))
+	case "float32":
+		raw = float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	}
+	scale := r.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return raw*scale + r.Offset, nil
+}
+
+// stateFor names the band a value falls in. Without thresholds the value
+// itself is the state, and a coil or discrete input is "on" or "off".
+func (r ModbusRegister) stateFor(value float64) string {
+	if len(r.Thresholds) == 0 {
+		if r.Function == "coil" || r.Function == "discrete" {
+			if value != 0 {
+				return "on"
+			}
+			return "off"
+		}
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	for _, threshold := range r.Thresholds {
+		if threshold.Above != nil && !(value > *threshold.Above) {
+			continue
+		}
+		if threshold.Below != nil && !(value < *threshold.Below) {
+			continue
+		}
+		return threshold.State
+	}
+	return "normal"
+}
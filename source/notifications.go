@@ -0,0 +1,607 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Notifications alert people away from the annunciator when something needs
+// attention: an emergency or lightning announcement going out, playback that
+// keeps failing, the audio backend disappearing or switching devices, failed
+// admin logins, failed updates, failed audio syncs, low disk space, a hot or
+// throttled Pi, failed announcement hooks, amplifier relays, speaker tests or
+// nightly configuration snapshots, a standby taking over, a wrong clock and mapped SNMP traps. Each event type is routed to its own list of channels.
+
+// Notification event types
+const (
+	NotifyEmergencyAnnouncement = "emergency_announcement"
+	NotifyLightningAnnouncement = "lightning_announcement"
+	NotifyPlaybackFailures      = "playback_failures"
+	NotifyAudioBackendLost      = "audio_backend_lost"
+	NotifyAudioDeviceChanged    = "audio_device_changed"
+	NotifyLoginFailed           = "login_failed"
+	NotifyUpdateFailed          = "update_failed"
+	NotifySNMPTrap              = "snmp_trap"
+	NotifyAssetSyncFailed       = "asset_sync_failed"
+	NotifyDiskSpaceLow          = "disk_space_low"
+	NotifyThrottling            = "throttling"
+	NotifyHookFailed            = "hook_failed"
+	NotifyAmplifierFailed       = "amplifier_failed"
+	NotifySpeakerTestFailed     = "speaker_test_failed"
+	NotifyConfigSnapshotFailed  = "config_snapshot_failed"
+	NotifyHAFailover            = "ha_failover"
+	NotifyClockWrong            = "clock_wrong"
+	NotifyCrash                 = "crash"
+	NotifyTest                  = "test"
+)
+
+var notificationEvents = []string{
+	NotifyEmergencyAnnouncement,
+	NotifyLightningAnnouncement,
+	NotifyPlaybackFailures,
+	NotifyAudioBackendLost,
+	NotifyAudioDeviceChanged,
+	NotifyLoginFailed,
+	NotifyUpdateFailed,
+	NotifySNMPTrap,
+	NotifyAssetSyncFailed,
+	NotifyDiskSpaceLow,
+	NotifyThrottling,
+	NotifyHookFailed,
+	NotifyAmplifierFailed,
+	NotifySpeakerTestFailed,
+	NotifyConfigSnapshotFailed,
+	NotifyHAFailover,
+	NotifyClockWrong,
+	NotifyCrash,
+}
+
+// NotificationChannelConfig is one configured destination. Only the fields for
+// its type are used.
+type NotificationChannelConfig struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"` // email, slack or pushover
+	Enabled bool   `json:"enabled"`
+
+	// email
+	SMTPHost string   `json:"smtp_host,omitempty"`
+	SMTPPort int      `json:"smtp_port,omitempty"` // 465 uses implicit TLS, anything else STARTTLS when offered
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+
+	// slack
+	WebhookURL string `json:"webhook_url,omitempty"`
+
+	// pushover
+	AppToken string `json:"app_token,omitempty"`
+	UserKey  string `json:"user_key,omitempty"`
+}
+
+// NotificationSettings configures channels and which events go to which of them
+type NotificationSettings struct {
+	Enabled  bool                        `json:"enabled"`
+	Channels []NotificationChannelConfig `json:"channels"`
+	// Routes maps an event type to channel names; "default" is used for
+	// event types without their own route
+	Routes                   map[string][]string `json:"routes"`
+	PlaybackFailureThreshold int                 `json:"playback_failure_threshold"` // consecutive failures before alerting
+	CooldownSeconds          int                 `json:"cooldown_seconds"`           // repeats of the same alert are held back this long
+}
+
+func getDefaultNotificationSettings() NotificationSettings {
+	return NotificationSettings{
+		Enabled:  false,
+		Channels: []NotificationChannelConfig{},
+		Routes: map[string][]string{
+			"default":                   {},
+			NotifyEmergencyAnnouncement: {},
+			NotifyLightningAnnouncement: {},
+		},
+		PlaybackFailureThreshold: 3,
+		CooldownSeconds:          300,
+	}
+}
+
+// Notification is one alert as handed to a channel
+type Notification struct {
+	Event    string    `json:"event"`
+	Severity string    `json:"severity"` // critical or warning
+	Title    string    `json:"title"`
+	Message  string    `json:"message"`
+	Host     string    `json:"host"`
+	Time     time.Time `json:"time"`
+}
+
+// notificationChannel delivers notifications to one destination
+type notificationChannel interface {
+	Send(notification Notification) error
+}
+
+// notificationChannelTypes builds a channel from its config. New channel types
+// only need an entry here.
+var notificationChannelTypes = map[string]func(NotificationChannelConfig) (notificationChannel, error){
+	"email":    newEmailChannel,
+	"slack":    newSlackChannel,
+	"pushover": newPushoverChannel,
+}
+
+// NotificationDelivery records the outcome of sending to one channel
+type NotificationDelivery struct {
+	Event   string    `json:"event"`
+	Title   string    `json:"title"`
+	Channel string    `json:"channel"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+const maxNotificationDeliveries = 50
+
+var notifier = struct {
+	mutex      sync.Mutex
+	lastSent   map[string]time.Time // event + subject -> last alert, for the cooldown
+	deliveries []NotificationDelivery
+
+	// consecutive announcements that failed to play
+	playbackFailures int
+}{lastSent: make(map[string]time.Time)}
+
+var notificationClient = &http.Client{Timeout: 15 * time.Second}
+
+// loadNotificationSettings reads the notification section of admin_config.json
+func loadNotificationSettings() (NotificationSettings, error) {
+	adminConfig, err := loadAdminConfig(adminConfigPath())
+	if err != nil {
+		return NotificationSettings{}, err
+	}
+	settings := adminConfig.Notifications
+	defaults := getDefaultNotificationSettings()
+	if settings.PlaybackFailureThreshold <= 0 {
+		settings.PlaybackFailureThreshold = defaults.PlaybackFailureThreshold
+	}
+	if settings.CooldownSeconds < 0 {
+		settings.CooldownSeconds = 0
+	}
+	return settings, nil
+}
+
+// channelsFor returns the enabled channels routed for an event type
+func (settings NotificationSettings) channelsFor(event string) []NotificationChannelConfig {
+	names, ok := settings.Routes[event]
+	if !ok {
+		names = settings.Routes["default"]
+	}
+	var channels []NotificationChannelConfig
+	for _, name := range names {
+		for _, channel := range settings.Channels {
+			if channel.Name == name && channel.Enabled {
+				channels = append(channels, channel)
+			}
+		}
+	}
+	return channels
+}
+
+// notify sends an alert in the background. Alerts with the same event and
+// subject are sent at most once per cooldown; pass a unique subject (such as
+// an announcement ID) for alerts that must never be held back.
+func notify(event, subject, severity, title, message string) {
+	settings, err := loadNotificationSettings()
+	if err != nil || !settings.Enabled {
+		return
+	}
+	channels := settings.channelsFor(event)
+	if len(channels) == 0 {
+		return
+	}
+
+	key := event + "|" + subject
+	notifier.mutex.Lock()
+	if last, ok := notifier.lastSent[key]; ok && time.Since(last) < time.Duration(settings.CooldownSeconds)*time.Second {
+		notifier.mutex.Unlock()
+		return
+	}
+	notifier.lastSent[key] = time.Now()
+	for k, last := range notifier.lastSent {
+		if time.Since(last) > 24*time.Hour {
+			delete(notifier.lastSent, k)
+		}
+	}
+	notifier.mutex.Unlock()
+
+	host, _ := os.Hostname()
+	notification := Notification{
+		Event:    event,
+		Severity: severity,
+		Title:    title,
+		Message:  message,
+		Host:     host,
+		Time:     time.Now(),
+	}
+	go func() {
+		for _, channel := range channels {
+			sendNotification(channel, notification)
+		}
+	}()
+}
+
+// sendNotification delivers to one channel and records the outcome
+func sendNotification(config NotificationChannelConfig, notification Notification) error {
+	delivery := NotificationDelivery{
+		Event:   notification.Event,
+		Title:   notification.Title,
+		Channel: config.Name,
+		SentAt:  time.Now(),
+	}
+
+	var err error
+	build, ok := notificationChannelTypes[config.Type]
+	if !ok {
+		err = fmt.Errorf("unknown channel type '%s'", config.Type)
+	} else {
+		var channel notificationChannel
+		if channel, err = build(config); err == nil {
+			err = channel.Send(notification)
+		}
+	}
+
+	if err != nil {
+		delivery.Error = err.Error()
+		log.Printf("⚠️  Notification '%s' to %s failed: %v", notification.Title, config.Name, err)
+	} else {
+		delivery.Success = true
+		log.Printf("📣 Notification '%s' sent to %s", notification.Title, config.Name)
+	}
+
+	notifier.mutex.Lock()
+	notifier.deliveries = append(notifier.deliveries, delivery)
+	if len(notifier.deliveries) > maxNotificationDeliveries {
+		notifier.deliveries = notifier.deliveries[len(notifier.deliveries)-maxNotificationDeliveries:]
+	}
+	notifier.mutex.Unlock()
+	return err
+}
+
+// Event hooks
+
+// notifyAnnouncementQueued alerts on emergency and lightning announcements
+func notifyAnnouncementQueued(announcement *Announcement) {
+	event := ""
+	switch announcement.Type {
+	case TypeEmergency:
+		event = NotifyEmergencyAnnouncement
+	case TypeLightning:
+		event = NotifyLightningAnnouncement
+	default:
+		return
+	}
+	detail := ""
+	for _, name := range []string{"file", "condition"} {
+		if value, ok := announcement.Parameters[name].(string); ok && value != "" {
+			detail = value
+			break
+		}
+	}
+	message := fmt.Sprintf("Announcement %s (%s, priority %d) was queued", announcement.ID, announcement.Type, announcement.Priority)
+	if detail != "" {
+		message += ": " + detail
+	}
+	title := strings.ToUpper(string(announcement.Type[:1])) + string(announcement.Type[1:]) + " announcement"
+	notify(event, announcement.ID, "critical", title, message)
+}
+
+// notePlaybackResult counts consecutive playback failures and alerts once the
+// configured threshold is reached. A successful announcement resets the count.
+func notePlaybackResult(announcement *Announcement, err error) {
+	notifier.mutex.Lock()
+	if err == nil {
+		notifier.playbackFailures = 0
+		notifier.mutex.Unlock()
+		return
+	}
+	notifier.playbackFailures++
+	failures := notifier.playbackFailures
+	notifier.mutex.Unlock()
+
+	settings, loadErr := loadNotificationSettings()
+	if loadErr != nil || failures != settings.PlaybackFailureThreshold {
+		return
+	}
+	notify(NotifyPlaybackFailures, "", "critical", "Announcements are failing",
+		fmt.Sprintf("%d announcements in a row failed to play. Last failure (ID %s, type %s): %v", failures, announcement.ID, announcement.Type, err))
+}
+
+// notifyAudioBackendLost alerts when the audio output cannot be opened
+func notifyAudioBackendLost(deviceID string, err error) {
+	if deviceID == "" {
+		deviceID = "default"
+	}
+	notify(NotifyAudioBackendLost, deviceID, "critical", "Audio output unavailable",
+		fmt.Sprintf("The %s audio backend could not open device '%s': %v. Announcements will not be heard.", audioBackendName(), deviceID, err))
+}
+
+// notifyAudioDeviceChanged alerts when audio moves to another output device
+// because the one in use was disconnected or has come back
+func notifyAudioDeviceChanged(from, to string) {
+	reason := fmt.Sprintf("Audio device '%s' is not connected, so announcements now play on '%s' from preferred_devices.", from, to)
+	if to == selectedAudioDevice() {
+		reason = fmt.Sprintf("The selected audio device '%s' is connected again, so announcements play on it instead of '%s'.", to, from)
+	}
+	notify(NotifyAudioDeviceChanged, to, "warning", "Audio output switched", reason)
+}
+
+// notifyFailedLogin alerts on a failed admin login; repeats from the same
+// address are held back by the cooldown
+func notifyFailedLogin(username, clientIP string) {
+	notify(NotifyLoginFailed, clientIP, "warning", "Failed admin login",
+		fmt.Sprintf("Failed admin login for user '%s' from %s", username, clientIP))
+}
+
+// notifyUpdateFailed alerts when an update could not be installed
+func notifyUpdateFailed(source string, err error) {
+	notify(NotifyUpdateFailed, "", "critical", "Update failed",
+		fmt.Sprintf("Update requested by %s failed, still running version %s: %v", source, appVersion, err))
+}
+
+// notifyAssetSyncFailed alerts when audio could not be synced from the
+// central source
+func notifyAssetSyncFailed(source, reason string) {
+	notify(NotifyAssetSyncFailed, source, "warning", "Audio sync failed",
+		fmt.Sprintf("Syncing audio from %s failed: %s", source, reason))
+}
+
+// Email
+
+type emailChannel struct {
+	config NotificationChannelConfig
+}
+
+func newEmailChannel(config NotificationChannelConfig) (notificationChannel, error) {
+	if config.SMTPHost == "" || config.From == "" || len(config.To) == 0 {
+		return nil, fmt.Errorf("email channel needs smtp_host, from and to")
+	}
+	if config.SMTPPort == 0 {
+		config.SMTPPort = 587
+	}
+	return &emailChannel{config: config}, nil
+}
+
+func (e *emailChannel) Send(notification Notification) error {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\n", e.config.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(e.config.To, ", "))
+	fmt.Fprintf(&body, "Subject: [TARR %s] %s\r\n", strings.ToUpper(notification.Severity), notification.Title)
+	fmt.Fprintf(&body, "Date: %s\r\n", notification.Time.Format(time.RFC1123Z))
+	body.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprintf(&body, "%s\r\n\r\nHost: %s\r\nEvent: %s\r\nTime: %s\r\n",
+		notification.Message, notification.Host, notification.Event, notification.Time.Format(time.RFC3339))
+
+	address := net.JoinHostPort(e.config.SMTPHost, strconv.Itoa(e.config.SMTPPort))
+	var auth smtp.Auth
+	if e.config.Username != "" {
+		auth = smtp.PlainAuth("", e.config.Username, e.config.Password, e.config.SMTPHost)
+	}
+	if e.config.SMTPPort != 465 {
+		// SendMail upgrades to STARTTLS when the server offers it
+		return smtp.SendMail(address, auth, e.config.From, e.config.To, body.Bytes())
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 15 * time.Second}, "tcp", address, &tls.Config{ServerName: e.config.SMTPHost})
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, e.config.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(e.config.From); err != nil {
+		return err
+	}
+	for _, to := range e.config.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(body.Bytes()); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Slack
+
+type slackChannel struct {
+	webhookURL string
+}
+
+func newSlackChannel(config NotificationChannelConfig) (notificationChannel, error) {
+	if config.WebhookURL == "" {
+		return nil, fmt.Errorf("slack channel needs webhook_url")
+	}
+	return &slackChannel{webhookURL: config.WebhookURL}, nil
+}
+
+func (s *slackChannel) Send(notification Notification) error {
+	icon := ":warning:"
+	if notification.Severity == "critical" {
+		icon = ":rotating_light:"
+	}
+	payload, err := json.Marshal(map[string]string{
+		"text": fmt.Sprintf("%s *%s* (%s)\n%s", icon, notification.Title, notification.Host, notification.Message),
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := notificationClient.Post(s.webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("slack returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Pushover
+
+var pushoverAPIURL = "https://api.pushover.net/1/messages.json"
+
+type pushoverChannel struct {
+	appToken string
+	userKey  string
+}
+
+func newPushoverChannel(config NotificationChannelConfig) (notificationChannel, error) {
+	if config.AppToken == "" || config.UserKey == "" {
+		return nil, fmt.Errorf("pushover channel needs app_token and user_key")
+	}
+	return &pushoverChannel{appToken: config.AppToken, userKey: config.UserKey}, nil
+}
+
+func (p *pushoverChannel) Send(notification Notification) error {
+	// High priority bypasses the recipient's quiet hours
+	priority := "0"
+	if notification.Severity == "critical" {
+		priority = "1"
+	}
+	resp, err := notificationClient.PostForm(pushoverAPIURL, url.Values{
+		"token":     {p.appToken},
+		"user":      {p.userKey},
+		"title":     {notification.Title},
+		"message":   {fmt.Sprintf("%s (%s)", notification.Message, notification.Host)},
+		"priority":  {priority},
+		"timestamp": {strconv.FormatInt(notification.Time.Unix(), 10)},
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("pushover returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Handlers
+
+// apiNotificationStatusHandler shows the channels, routes and recent deliveries, never credentials
+func apiNotificationStatusHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	settings, err := loadNotificationSettings()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to load admin config")
+		return
+	}
+
+	channels := make([]gin.H, 0, len(settings.Channels))
+	for _, channel := range settings.Channels {
+		channels = append(channels, gin.H{
+			"name":    channel.Name,
+			"type":    channel.Type,
+			"enabled": channel.Enabled,
+		})
+	}
+	routes := make(map[string][]string)
+	for _, event := range notificationEvents {
+		names := []string{}
+		for _, channel := range settings.channelsFor(event) {
+			names = append(names, channel.Name)
+		}
+		routes[event] = names
+	}
+
+	notifier.mutex.Lock()
+	deliveries := append([]NotificationDelivery{}, notifier.deliveries...)
+	notifier.mutex.Unlock()
+
+	respondOK(c, gin.H{
+		"enabled":                    settings.Enabled,
+		"channels":                   channels,
+		"routes":                     routes,
+		"playback_failure_threshold": settings.PlaybackFailureThreshold,
+		"cooldown_seconds":           settings.CooldownSeconds,
+		"recent_deliveries":          deliveries,
+	})
+}
+
+// apiNotificationTestHandler sends a test notification to one channel,
+// whether or not notifications are enabled, and reports the result
+func apiNotificationTestHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	data, ok := bindRequestData(c, "channel")
+	if !ok {
+		return
+	}
+	name, _ := data["channel"].(string)
+	if name == "" {
+		respondValidationError(c, "Invalid test notification", FieldError{Field: "channel", Message: "is required"})
+		return
+	}
+
+	settings, err := loadNotificationSettings()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to load admin config")
+		return
+	}
+	var channel *NotificationChannelConfig
+	for i := range settings.Channels {
+		if settings.Channels[i].Name == name {
+			channel = &settings.Channels[i]
+			break
+		}
+	}
+	if channel == nil {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Notification channel '"+name+"' not found")
+		return
+	}
+
+	host, _ := os.Hostname()
+	err = sendNotification(*channel, Notification{
+		Event:    NotifyTest,
+		Severity: "warning",
+		Title:    "Test notification",
+		Message:  "This is a test notification from the TARR Annunciator.",
+		Host:     host,
+		Time:     time.Now(),
+	})
+	if err != nil {
+		respondError(c, http.StatusBadGateway, ErrCodeUnavailable, "Test notification failed: "+err.Error())
+		return
+	}
+	respondSuccess(c, http.StatusOK, "Test notification sent to "+name, nil)
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// OIDCRoleMapping maps a value of the role claim to an annunciator role
+type OIDCRoleMapping struct {
+	Value       string   `json:"value"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+// OIDCSettings configures single sign-on through an OpenID Connect provider
+type OIDCSettings struct {
+	Enabled      bool              `json:"enabled"`
+	ButtonLabel  string            `json:"button_label"`
+	Issuer       string            `json:"issuer"` // e.g. https://auth.park.org/application/o/annunciator/
+	ClientID     string            `json:"client_id"`
+	ClientSecret string            `json:"client_secret"`
+	RedirectURL  string            `json:"redirect_url"` // https://<host>/admin/login/oidc/callback
+	Scopes       []string          `json:"scopes"`
+	RoleClaim    string            `json:"role_claim"` // Dotted path, e.g. "groups" or "realm_access.roles"
+	RoleMappings []OIDCRoleMapping `json:"role_mappings"`
+}
+
+func getDefaultOIDCSettings() OIDCSettings {
+	return OIDCSettings{
+		Enabled:      false,
+		ButtonLabel:  "Sign in with SSO",
+		Scopes:       []string{"openid", "profile", "email"},
+		RoleClaim:    "groups",
+		RoleMappings: []OIDCRoleMapping{},
+	}
+}
+
+// oidcProviderMetadata is the part of the discovery document we use
+type oidcProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// oidcProviderCache keeps discovery and signing keys between logins
+type oidcProviderCache struct {
+	mutex     sync.Mutex
+	issuer    string
+	metadata  *oidcProviderMetadata
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+var oidcProvider = &oidcProviderCache{}
+
+const oidcCacheLifetime = time.Hour
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// loadOIDCSettings reads the OIDC section of admin_config.json
+func loadOIDCSettings() (OIDCSettings, bool) {
+	adminConfig, err := loadAdminConfig(filepath.Join(app.Config.JSONDir, "admin_config.json"))
+	if err != nil || !adminConfig.OIDC.Enabled {
+		return OIDCSettings{}, false
+	}
+	return adminConfig.OIDC, true
+}
+
+// provider returns cached discovery metadata and keys, refreshing them when
+// stale or when a token is signed with an unknown key
+func (p *oidcProviderCache) provider(settings OIDCSettings, forceKeys bool) (*oidcProviderMetadata, map[string]crypto.PublicKey, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	issuer := strings.TrimSuffix(settings.Issuer, "/")
+	stale := time.Since(p.fetchedAt) > oidcCacheLifetime
+	if p.metadata == nil || p.issuer != issuer || stale {
+		var metadata oidcProviderMetadata
+		if err := fetchJSON(issuer+"/.well-known/openid-configuration", &metadata); err != nil {
+			return nil, nil, fmt.Errorf("discovery failed: %v", err)
+		}
+		if strings.TrimSuffix(metadata.Issuer, "/") != issuer {
+			return nil, nil, fmt.Errorf("discovery issuer %q does not match %q", metadata.Issuer, settings.Issuer)
+		}
+		p.metadata = &metadata
+		p.issuer = issuer
+		p.keys = nil
+		p.fetchedAt = time.Now()
+	}
+
+	if p.keys == nil || forceKeys {
+		keys, err := fetchJWKS(p.metadata.JWKSURI)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load signing keys: %v", err)
+		}
+		p.keys = keys
+	}
+	return p.metadata, p.keys, nil
+}
+
+func fetchJSON(target string, out interface{}) error {
+	resp, err := oidcHTTPClient.Get(target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: HTTP %d", target, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+// fetchJWKS loads the provider's RSA and EC signing keys by key ID
+func fetchJWKS(jwksURI string) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := fetchJSON(jwksURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, key := range set.Keys {
+		switch key.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(key.N)
+			e, errE := base64.RawURLEncoding.DecodeString(key.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			if key.Crv != "P-256" {
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(key.X)
+			y, errY := base64.RawURLEncoding.DecodeString(key.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[key.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no usable keys in %s", jwksURI)
+	}
+	return keys, nil
+}
+
+// splitJWT decodes a compact JWT into its header, claims, signed input and signature
+func splitJWT(token string) (header, claims map[string]interface{}, signingInput string, signature []byte, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, "", nil, fmt.Errorf("malformed token")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, "", nil, fmt.Errorf("malformed token header")
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, "", nil, fmt.Errorf("malformed token claims")
+	}
+	signature, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, "", nil, fmt.Errorf("malformed token signature")
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, nil, "", nil, fmt.Errorf("malformed token header")
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(claimsJSON)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, nil, "", nil, fmt.Errorf("malformed token claims")
+	}
+	return header, claims, parts[0] + "." + parts[1], signature, nil
+}
+
+// verifyIDToken checks the signature and standard claims of an ID token
+func verifyIDToken(settings OIDCSettings, rawToken, nonce string) (map[string]interface{}, error) {
+	header, claims, signingInput, signature, err := splitJWT(rawToken)
+	if err != nil {
+		return nil, err
+	}
+
+	kid, _ := header["kid"].(string)
+	metadata, keys, err := oidcProvider.provider(settings, false)
+	if err != nil {
+		return nil, err
+	}
+	key, found := keys[kid]
+	if !found {
+		// The provider may have rotated its keys since we cached them
+		if _, keys, err = oidcProvider.provider(settings, true); err != nil {
+			return nil, err
+		}
+		if key, found = keys[kid]; !found {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+	}
+
+	digest := sha256.Sum256([]byte(signingInput))
+	switch alg, _ := header["alg"].(string); alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature) != nil {
+			return nil, fmt.Errorf("invalid token signature")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return nil, fmt.Errorf("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return nil, fmt.Errorf("invalid token signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+
+	if issuer, _ := claims["iss"].(string); strings.TrimSuffix(issuer, "/") != strings.TrimSuffix(metadata.Issuer, "/") {
+		return nil, fmt.Errorf("token issuer %q does not match", issuer)
+	}
+	if !claimContains(claims["aud"], settings.ClientID) {
+		return nil, fmt.Errorf("token audience does not include %s", settings.ClientID)
+	}
+	if exp, ok := claims["exp"].(json.Number); !ok {
+		return nil, fmt.Errorf("token has no expiry")
+	} else if seconds, err := exp.Int64(); err != nil || time.Now().After(time.Unix(seconds, 0).Add(time.Minute)) {
+		return nil, fmt.Errorf("token has expired")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, fmt.Errorf("token nonce does not match")
+	}
+	return claims, nil
+}
+
+// claimContains reports whether a string or string-array claim includes value
+func claimContains(claim interface{}, value string) bool {
+	for _, item := range claimStrings(claim) {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func claimStrings(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var out []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// lookupClaim follows a dotted path such as "realm_access.roles"
+func lookupClaim(claims map[string]interface{}, path string) interface{} {
+	var current interface{} = claims
+	for _, part := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[part]
+	}
+	return current
+}
+
+// matchOIDCRole returns the first mapping whose value appears in the role claim
+func matchOIDCRole(settings OIDCSettings, claims map[string]interface{}) *OIDCRoleMapping {
+	values := claimStrings(lookupClaim(claims, settings.RoleClaim))
+	for i, mapping := range settings.RoleMappings {
+		for _, value := range values {
+			if value == mapping.Value {
+				return &settings.RoleMappings[i]
+			}
+		}
+	}
+	return nil
+}
+
+func randomToken() string {
+	buf := make([]byte, 24)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Handlers
+
+// oidcLoginHandler redirects the browser to the provider's login page
+func oidcLoginHandler(c *gin.Context) {
+	settings, enabled := loadOIDCSettings()
+	if !enabled {
+		c.Redirect(http.StatusFound, "/admin/login")
+		return
+	}
+
+	metadata, _, err := oidcProvider.provider(settings, false)
+	if err != nil {
+		log.Printf("🔐 OIDC login unavailable: %v", err)
+		c.HTML(http.StatusOK, "admin_login.html", adminLoginPageData("Single sign-on is currently unavailable"))
+		return
+	}
+
+	state := randomToken()
+	nonce := randomToken()
+	verifier := randomToken()
+	challenge := sha256.Sum256([]byte(verifier))
+
+	session := sessions.Default(c)
+	session.Set("oidc_state", state)
+	session.Set("oidc_nonce", nonce)
+	session.Set("oidc_verifier", verifier)
+	session.Save()
+
+	scopes := settings.Scopes
+	if len(scopes) == 0 {
+		scopes = getDefaultOIDCSettings().Scopes
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {settings.ClientID},
+		"redirect_uri":          {settings.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	c.Redirect(http.StatusFound, metadata.AuthorizationEndpoint+separator+query.Encode())
+}
+
+// oidcCallbackHandler exchanges the authorization code and logs the user in
+func oidcCallbackHandler(c *gin.Context) {
+	settings, enabled := loadOIDCSettings()
+	if !enabled {
+		c.Redirect(http.StatusFound, "/admin/login")
+		return
+	}
+
+	session := sessions.Default(c)
+	state, _ := session.Get("oidc_state").(string)
+	nonce, _ := session.Get("oidc_nonce").(string)
+	verifier, _ := session.Get("oidc_verifier").(string)
+	session.Delete("oidc_state")
+	session.Delete("oidc_nonce")
+	session.Delete("oidc_verifier")
+	session.Save()
+
+	fail := func(reason string, err error) {
+		log.Printf("🔐 OIDC login failed: %s: %v", reason, err)
+		c.HTML(http.StatusOK, "admin_login.html", adminLoginPageData("Single sign-on failed: "+reason))
+	}
+
+	if providerError := c.Query("error"); providerError != "" {
+		fail("provider returned "+providerError, fmt.Errorf("%s", c.Query("error_description")))
+		return
+	}
+	if state == "" || c.Query("state") != state {
+		fail("login session expired, please try again", fmt.Errorf("state mismatch"))
+		return
+	}
+
+	metadata, _, err := oidcProvider.provider(settings, false)
+	if err != nil {
+		fail("provider unavailable", err)
+		return
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {c.Query("code")},
+		"redirect_uri":  {settings.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		fail("invalid token endpoint", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(settings.ClientID), url.QueryEscape(settings.ClientSecret))
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		fail("token exchange failed", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var tokenResponse struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokenResponse)
+	if resp.StatusCode != http.StatusOK || tokenResponse.IDToken == "" {
+		fail("token exchange failed", fmt.Errorf("HTTP %d %s", resp.StatusCode, tokenResponse.Error))
+		return
+	}
+
+	claims, err := verifyIDToken(settings, tokenResponse.IDToken, nonce)
+	if err != nil {
+		fail("invalid ID token", err)
+		return
+	}
+
+	username, _ := claims["preferred_username"].(string)
+	if username == "" {
+		username, _ = claims["email"].(string)
+	}
+	if username == "" {
+		username, _ = claims["sub"].(string)
+	}
+
+	mapping := matchOIDCRole(settings, claims)
+	if mapping == nil {
+		fail("your account has no annunciator role", fmt.Errorf("%s has no mapped %s value", username, settings.RoleClaim))
+		return
+	}
+
+	log.Printf("🔐 OIDC login for %s (role %s)", username, mapping.Role)
+	session.Clear()
+	session.Set("admin_logged_in", true)
+	session.Set("admin_user_id", "oidc:"+username)
+	session.Set("admin_role", mapping.Role)
+	session.Save()
+	c.Redirect(http.StatusFound, "/admin")
+}
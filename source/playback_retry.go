@@ -0,0 +1,127 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Some audio errors clear up by themselves: ALSA reports the device busy
+// while another process lets go of it, the output is briefly closed while
+// audio recovery reopens it, or a clip on a network library can't be read
+// for a moment. Rather than failing the announcement, opening a clip,
+// switching to a zone device and playing are each tried again a few times,
+// waiting twice as long before every try. If playback still fails, an
+// announcement of high enough priority is queued once more, a little later,
+// before it is marked failed. Errors that won't go away by waiting (missing
+// or undecodable files, cancellation) fail at once.
+
+// PlaybackRetrySettings is the retry section of audio_settings.json
+type PlaybackRetrySettings struct {
+	Attempts         int `json:"attempts"`           // Tries of each step, the first included; 1 disables retrying
+	InitialBackoffMs int `json:"initial_backoff_ms"` // Wait before the second try, doubled for each after it
+	MaxBackoffMs     int `json:"max_backoff_ms"`     // Longest wait between tries
+	// RequeuePriority is the lowest priority requeued once when playback
+	// still fails; 0 never requeues
+	RequeuePriority AnnouncementPriority `json:"requeue_priority"`
+	RequeueDelayMs  int                  `json:"requeue_delay_ms"` // How long a requeued announcement waits
+}
+
+func getDefaultPlaybackRetrySettings() PlaybackRetrySettings {
+	return PlaybackRetrySettings{
+		Attempts:         3,
+		InitialBackoffMs: 250,
+		MaxBackoffMs:     2000,
+		RequeuePriority:  PriorityHigh,
+		RequeueDelayMs:   3000,
+	}
+}
+
+// Error text of transient failures from ALSA, the audio backend and the
+// filesystem, where the underlying errno has been formatted away
+var transientAudioErrorText = []string{
+	"device or resource busy",
+	"resource temporarily unavailable",
+	"interrupted system call",
+	"audio backend not initialized",
+	"i/o timeout",
+}
+
+// transientAudioError reports whether err may go away when tried again
+func transientAudioError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	for _, text := range transientAudioErrorText {
+		if strings.Contains(message, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryTransientAudio runs try until it succeeds, fails for good or runs out
+// of attempts, backing off between tries. A signal on cancelChan (nil for
+// none) during a wait ends it as cancelled playback.
+func retryTransientAudio(what string, cancelChan chan bool, try func() error) error {
+	settings := getAudioSettings().Retry
+	backoff := time.Duration(settings.InitialBackoffMs) * time.Millisecond
+	maxBackoff := time.Duration(settings.MaxBackoffMs) * time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		err := try()
+		if err == nil || errors.Is(err, errPlaybackCancelled) {
+			return err
+		}
+		if !transientAudioError(err) || attempt >= settings.Attempts {
+			if attempt > 1 {
+				err = fmt.Errorf("%w (after %d attempts)", err, attempt)
+			}
+			return err
+		}
+
+		log.Printf("⚠️  %s failed (%v), trying again in %s (attempt %d of %d)", what, err, backoff, attempt+1, settings.Attempts)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-cancelChan:
+			timer.Stop()
+			return errPlaybackCancelled
+		}
+		if backoff *= 2; maxBackoff > 0 && backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// requeueAfterFailure puts an announcement whose playback failed with a
+// transient error back in the queue, once, if its priority allows. Caller
+// must hold the mutex.
+func (am *AnnouncementManager) requeueAfterFailure(announcement *Announcement, err error) bool {
+	settings := getAudioSettings().Retry
+	if announcement.Requeued || settings.RequeuePriority <= 0 || announcement.Priority < settings.RequeuePriority || !transientAudioError(err) {
+		return false
+	}
+
+	now := time.Now()
+	announcement.Requeued = true
+	announcement.Status = StatusQueued
+	announcement.StartedAt = nil
+	announcement.ScheduledAt = now.Add(time.Duration(settings.RequeueDelayMs) * time.Millisecond)
+	am.lastFinished = now
+	heap.Push(am.queue, announcement)
+	if am.playing == announcement {
+		am.playing = nil
+	}
+	log.Printf("🔁 Requeued announcement after a transient audio error: ID=%s, Error=%v", announcement.ID, err)
+	queueEvents.publish()
+	return true
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopxl/beep"
+	"github.com/gopxl/beep/mp3"
+	"github.com/gopxl/beep/wav"
+)
+
+// If the audio output wedges (beep's speaker stops pulling samples, or the
+// device blocks a write) an announcement never finishes and the queue waits
+// behind it forever. The playback watchdog gives each announcement a time
+// limit: its expected length (clips, gaps and lead-in tone, once per zone
+// device) plus a margin. An announcement still playing after that is stopped
+// and marked failed, so the queue moves on, and the audio backend is
+// reinitialized. If the stuck playback still holds the audio output after
+// the grace period nothing else can play, and since beep can only open its
+// output once per process the annunciator restarts, as audio recovery does.
+// It also restarts when playback gets stuck again soon after a
+// reinitialization. The queue holds the next announcement until the output
+// has been reset.
+
+const (
+	// How long the stuck playback has to let go of the audio output after
+	// being cancelled
+	watchdogGracePeriod = 5 * time.Second
+	// A second stuck announcement this soon after a reinitialization means
+	// reopening the output didn't help, so the annunciator restarts
+	watchdogEscalateWindow = 10 * time.Minute
+)
+
+// PlaybackWatchdogSettings is the watchdog section of audio_settings.json
+type PlaybackWatchdogSettings struct {
+	Enabled       bool `json:"enabled"`
+	MarginSeconds int  `json:"margin_seconds"` // Added to the expected length
+	MarginPercent int  `json:"margin_percent"` // Also added, as a share of the expected length
+	MinSeconds    int  `json:"min_seconds"`    // Shortest limit, for announcements with unknown length
+}
+
+func getDefaultPlaybackWatchdogSettings() PlaybackWatchdogSettings {
+	return PlaybackWatchdogSettings{
+		Enabled:       true,
+		MarginSeconds: 15,
+		MarginPercent: 25,
+		MinSeconds:    30,
+	}
+}
+
+// watchdogStatus reports recoveries for the queue status
+type watchdogStatus struct {
+	Recoveries   int        `json:"recoveries"`
+	LastRecovery *time.Time `json:"last_recovery,omitempty"`
+	LastID       string     `json:"last_announcement_id,omitempty"`
+	LastAction   string     `json:"last_action,omitempty"` // reinitialized, failed or restarting
+}
+
+var playbackWatchdog struct {
+	mutex  sync.Mutex
+	status watchdogStatus
+}
+
+// clipDurations caches the length of audio files, keyed by path and checked
+// against the file's size and modification time
+var clipDurations = struct {
+	mutex   sync.Mutex
+	entries map[string]clipDuration
+}{entries: make(map[string]clipDuration)}
+
+type clipDuration struct {
+	length  time.Duration
+	size    int64
+	modTime time.Time
+}
+
+// audioFileDuration returns how long a clip plays
+func audioFileDuration(path string) (time.Duration, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	clipDurations.mutex.Lock()
+	cached, ok := clipDurations.entries[path]
+	clipDurations.mutex.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.length, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	var streamer beep.StreamSeekCloser
+	var format beep.Format
+	if strings.EqualFold(filepath.Ext(path), ".wav") {
+		streamer, format, err = wav.Decode(file)
+	} else {
+		streamer, format, err = mp3.Decode(file)
+	}
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+	length := format.SampleRate.D(streamer.Len())
+	streamer.Close()
+
+	clipDurations.mutex.Lock()
+	clipDurations.entries[path] = clipDuration{length: length, size: info.Size(), modTime: info.ModTime()}
+	clipDurations.mutex.Unlock()
+	return length, nil
+}
+
+// expectedPlaybackDuration estimates how long an announcement takes to play.
+// Silence trimming and crossfades only make it shorter.
+func expectedPlaybackDuration(announcement *Announcement) time.Duration {
+	sequence := sequenceSettingsFor(announcement.Type)
+	leadIn := leadInSettingsFor(announcement.Type, announcement.Parameters)
+
+	var total time.Duration
+	switch leadIn.ToneMode {
+	case "single":
+		total += time.Duration(leadIn.ToneMs) * time.Millisecond
+	case "dual":
+		total += 2 * time.Duration(leadIn.ToneMs) * time.Millisecond
+	}
+	clips := 0
+	for _, file := range announcement.AudioFiles {
+		length, err := audioFileDuration(file)
+		if err != nil {
+			continue
+		}
+		total += length
+		clips++
+	}
+	if clips > 1 {
+		total += time.Duration(clips-1) * time.Duration(sequence.GapMs) * time.Millisecond
+	}
+	if devices := len(zoneOutputDevices(announcement.Parameters)); devices > 1 {
+		total *= time.Duration(devices)
+	}
+	return total
+}
+
+// playbackLimit is how long an announcement may play before the watchdog
+// steps in
+func playbackLimit(settings PlaybackWatchdogSettings, expected time.Duration) time.Duration {
+	limit := expected + expected*time.Duration(settings.MarginPercent)/100 + time.Duration(settings.MarginSeconds)*time.Second
+	if minimum := time.Duration(settings.MinSeconds) * time.Second; limit < minimum {
+		limit = minimum
+	}
+	return limit
+}
+
+// watchPlayback starts the watchdog for an announcement about to play. The
+// returned function stops it and must be called when playback returns.
+func (am *AnnouncementManager) watchPlayback(announcement *Announcement) func() {
+	settings := getAudioSettings().Watchdog
+	if !settings.Enabled {
+		return func() {}
+	}
+	expected := announcement.EstimatedDuration
+	if expected == 0 {
+		expected = expectedPlaybackDuration(announcement)
+	}
+	limit := playbackLimit(settings, expected)
+	timer := time.AfterFunc(limit, func() {
+		am.recoverStuckPlayback(announcement, expected, limit)
+	})
+	return func() { timer.Stop() }
+}
+
+// recoverStuckPlayback fails an announcement that outlived its limit, frees
+// the queue and reinitializes the audio backend
+func (am *AnnouncementManager) recoverStuckPlayback(announcement *Announcement, expected, limit time.Duration) {
+	am.mutex.Lock()
+	if am.playing != announcement {
+		am.mutex.Unlock()
+		return
+	}
+	log.Printf("⏱️  Playback watchdog: announcement %s still playing after %s (expected %s), stopping it",
+		announcement.ID, limit.Round(time.Second), expected.Round(time.Second))
+
+	select {
+	case am.cancelChan <- true:
+	default:
+	}
+
+	err := fmt.Errorf("playback watchdog: still playing after %s (expected %s); audio output reset",
+		limit.Round(time.Second), expected.Round(time.Second))
+	now := time.Now()
+	announcement.Status = StatusFailed
+	announcement.Error = err.Error()
+	announcement.CompletedAt = &now
+	if announcement.StartedAt != nil {
+		announcement.Duration = now.Sub(*announcement.StartedAt)
+	}
+	snapshot := *announcement
+	am.finishAnnouncement(announcement)
+	am.playing = nil
+	am.lastFinished = now
+	am.recovering = true
+	am.mutex.Unlock()
+
+	goRecovered("playback result", func() { notePlaybackResult(&snapshot, err) })
+
+	playbackWatchdog.mutex.Lock()
+	last := playbackWatchdog.status
+	playbackWatchdog.mutex.Unlock()
+	var action string
+	if last.LastAction == "reinitialized" && last.LastRecovery != nil && now.Sub(*last.LastRecovery) < watchdogEscalateWindow {
+		log.Printf("❌ Playback watchdog: playback stuck again %s after the audio output was reset, restarting to recover",
+			now.Sub(*last.LastRecovery).Round(time.Second))
+		action = "restarting"
+	} else {
+		action = resetStuckAudioOutput()
+	}
+
+	am.mutex.Lock()
+	am.recovering = false
+	am.mutex.Unlock()
+
+	playbackWatchdog.mutex.Lock()
+	playbackWatchdog.status.Recoveries++
+	playbackWatchdog.status.LastRecovery = &now
+	playbackWatchdog.status.LastID = announcement.ID
+	playbackWatchdog.status.LastAction = action
+	playbackWatchdog.mutex.Unlock()
+
+	if action == "restarting" {
+		restartApplication()
+	}
+}
+
+// resetStuckAudioOutput waits for the cancelled playback to release the
+// audio output and reopens it. It returns "reinitialized", "failed", or
+// "restarting" when the output is still held and only a restart can free it.
+func resetStuckAudioOutput() string {
+	deadline := time.Now().Add(watchdogGracePeriod)
+	for !globalAudioMutex.TryLock() {
+		if time.Now().After(deadline) {
+			log.Printf("❌ Playback watchdog: the audio output is still held by the stuck playback, restarting to recover")
+			notifyAudioBackendLost(activeAudioDevice(), fmt.Errorf("playback is stuck in the audio output; restarting"))
+			return "restarting"
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	globalAudioMutex.Unlock()
+
+	audioCheckMutex.Lock()
+	restart, err := openAudioOutput(activeAudioDevice(), false)
+	audioCheckMutex.Unlock()
+	switch {
+	case restart:
+		return "restarting"
+	case err != nil:
+		log.Printf("❌ Playback watchdog: audio backend reinitialization failed: %v", err)
+		return "failed"
+	}
+	log.Printf("✓ Playback watchdog: audio backend reinitialized, queue continuing")
+	return "reinitialized"
+}
+
+// currentWatchdogStatus returns the recoveries made so far
+func currentWatchdogStatus() watchdogStatus {
+	playbackWatchdog.mutex.Lock()
+	defer playbackWatchdog.mutex.Unlock()
+	return playbackWatchdog.status
+}
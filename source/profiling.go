@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Go's pprof profiles are served under /debug/pprof so memory and goroutine
+// growth on a unit that has been up for days can be looked at in place. It
+// is off by default and switched with profiling in the runtime settings,
+// which takes effect without a restart. While it is on, the block and mutex
+// profiles are sampled too; that costs a little on every contended lock, so
+// sampling stops when it is turned off.
+//
+// The profiles need an admin session or an API key with the config
+// permission, since a goroutine dump shows what every request is doing. With
+// a key, go tool pprof reads them directly:
+//
+//	go tool pprof "http://annunciator:8080/debug/pprof/heap?api_key=KEY"
+
+const (
+	profilingBlockRate     = 10000 // Sample a blocking event per this many nanoseconds blocked
+	profilingMutexFraction = 100   // Sample one in this many mutex contentions
+)
+
+var profilingOn atomic.Bool
+
+// applyProfiling turns profiling on or off
+func applyProfiling(enabled bool) {
+	if profilingOn.Swap(enabled) == enabled {
+		return
+	}
+	if enabled {
+		runtime.SetBlockProfileRate(profilingBlockRate)
+		runtime.SetMutexProfileFraction(profilingMutexFraction)
+		log.Printf("🔬 Profiling on: /debug/pprof is served")
+	} else {
+		runtime.SetBlockProfileRate(0)
+		runtime.SetMutexProfileFraction(0)
+		log.Printf("🔬 Profiling off")
+	}
+}
+
+// requireProfiling hides the debug routes while profiling is off, and
+// refuses API keys without the config permission. It runs after
+// requireAuthOrAPIKey.
+func requireProfiling() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !profilingOn.Load() {
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, `Profiling is off; turn it on with PATCH /api/settings {"profiling": true}`)
+			return
+		}
+		if !requireConfigPermission(c) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// pprofHandler serves the pprof index and profiles under /debug/pprof/
+func pprofHandler(c *gin.Context) {
+	switch strings.TrimPrefix(c.Param("profile"), "/") {
+	case "cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "profile":
+		pprof.Profile(c.Writer, c.Request)
+	case "symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "trace":
+		pprof.Trace(c.Writer, c.Request)
+	default:
+		// The index, and the named profiles: heap, allocs, goroutine,
+		// block, mutex and threadcreate
+		pprof.Index(c.Writer, c.Request)
+	}
+}
+
+// memStatsHandler reports the runtime's memory figures, for watching growth
+// between profiles without go tool pprof
+func memStatsHandler(c *gin.Context) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	respondOK(c, gin.H{
+		"uptime":          getAppUptime(),
+		"goroutines":      runtime.NumGoroutine(),
+		"heap_alloc":      stats.HeapAlloc,
+		"heap_inuse":      stats.HeapInuse,
+		"heap_idle":       stats.HeapIdle,
+		"heap_released":   stats.HeapReleased,
+		"heap_objects":    stats.HeapObjects,
+		"stack_inuse":     stats.StackInuse,
+		"sys":             stats.Sys,
+		"total_alloc":     stats.TotalAlloc,
+		"num_gc":          stats.NumGC,
+		"gc_cpu_fraction": stats.GCCPUFraction,
+	})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// json/pronunciations.json maps catalog IDs to the name shown for them and
+// how the TTS engine should say them, e.g. destination "wachee" is shown as
+// "Weeki Wachee" and spoken as "Wee-kee Wah-chee". Display names replace the
+// catalog name on the board, the public status API and announcement titles.
+// Spellings are substituted into any text spoken by TTS (weather reports,
+// the spoken clock) wherever the display name, or the ID for entries without
+// one, appears as a whole word.
+
+// PronunciationEntry is one dictionary entry
+type PronunciationEntry struct {
+	ID      string `json:"id"`
+	Catalog string `json:"catalog,omitempty"` // Catalog URL name; empty applies to the ID in every catalog
+	Display string `json:"display,omitempty"` // Shown instead of the catalog name
+	Say     string `json:"say,omitempty"`     // Phonetic spelling for TTS
+}
+
+// PronunciationDictionary is the content of pronunciations.json
+type PronunciationDictionary struct {
+	Entries []PronunciationEntry `json:"entries"`
+}
+
+// loadPronunciations reads pronunciations.json; a missing file is an empty
+// dictionary
+func loadPronunciations() (PronunciationDictionary, error) {
+	dictionary := PronunciationDictionary{Entries: []PronunciationEntry{}}
+	path, _ := jsonFilePath("pronunciations")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return dictionary, nil
+	}
+	if err != nil {
+		return dictionary, fmt.Errorf("failed to read pronunciations.json: %v", err)
+	}
+	if err := json.Unmarshal(data, &dictionary); err != nil {
+		return PronunciationDictionary{Entries: []PronunciationEntry{}}, fmt.Errorf("failed to parse pronunciations.json: %v", err)
+	}
+	return dictionary, nil
+}
+
+// currentPronunciations returns the dictionary, logging rather than failing
+// when it can't be read
+func currentPronunciations() PronunciationDictionary {
+	dictionary, err := loadPronunciations()
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	return dictionary
+}
+
+// validate checks every entry, returning the problems found
+func (d PronunciationDictionary) validate() []FieldError {
+	var details []FieldError
+	seen := make(map[string]bool)
+	for i, entry := range d.Entries {
+		field := fmt.Sprintf("entries[%d]", i)
+		if !catalogIDPattern.MatchString(entry.ID) {
+			details = append(details, FieldError{Field: field + ".id", Message: "must be 1-64 letters, digits, '_' or '-'"})
+		}
+		if entry.Catalog != "" {
+			if _, ok := findCatalogDefinition(entry.Catalog); !ok {
+				details = append(details, FieldError{Field: field + ".catalog", Message: "unknown catalog: " + entry.Catalog})
+			}
+		}
+		if strings.TrimSpace(entry.Display) == "" && strings.TrimSpace(entry.Say) == "" {
+			details = append(details, FieldError{Field: field, Message: "needs a display name, a spelling to say, or both"})
+		}
+		key := entry.Catalog + "/" + entry.ID
+		if seen[key] {
+			details = append(details, FieldError{Field: field + ".id", Message: "is already in the dictionary for this catalog"})
+		}
+		seen[key] = true
+	}
+	return details
+}
+
+// lookup returns the entry for an ID in a catalog; an entry for the catalog
+// wins over one for every catalog
+func (d PronunciationDictionary) lookup(catalog, id string) (PronunciationEntry, bool) {
+	var general *PronunciationEntry
+	for i, entry := range d.Entries {
+		if entry.ID != id {
+			continue
+		}
+		if entry.Catalog == catalog {
+			return entry, true
+		}
+		if entry.Catalog == "" {
+			general = &d.Entries[i]
+		}
+	}
+	if general != nil {
+		return *general, true
+	}
+	return PronunciationEntry{}, false
+}
+
+// applyDisplayNames replaces the catalog names of the IDs in names that have
+// a display name in the dictionary
+func (d PronunciationDictionary) applyDisplayNames(catalog string, names map[string]string) {
+	for id := range names {
+		if entry, ok := d.lookup(catalog, id); ok && entry.Display != "" {
+			names[id] = entry.Display
+		}
+	}
+}
+
+// speakable rewrites text for the TTS engine, replacing the display name (or
+// the ID, for entries without one) of each entry with its spelling. Longer
+// words go first so "Weeki Wachee" isn't half-replaced by an entry for
+// "Wachee".
+func (d PronunciationDictionary) speakable(text string) string {
+	type replacement struct{ word, say string }
+	var replacements []replacement
+	for _, entry := range d.Entries {
+		if entry.Say == "" {
+			continue
+		}
+		word := entry.Display
+		if word == "" {
+			word = entry.ID
+		}
+		replacements = append(replacements, replacement{word, entry.Say})
+	}
+	sort.SliceStable(replacements, func(i, j int) bool {
+		return len(replacements[i].word) > len(replacements[j].word)
+	})
+	for _, r := range replacements {
+		pattern, err := regexp.Compile(`(?i)\b` + regexp.QuoteMeta(r.word) + `\b`)
+		if err != nil {
+			continue
+		}
+		text = pattern.ReplaceAllLiteralString(text, r.say)
+	}
+	return text
+}
+
+// Handlers
+
+// apiGetPronunciationsHandler returns the dictionary
+func apiGetPronunciationsHandler(c *gin.Context) {
+	dictionary, err := loadPronunciations()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	respondOK(c, dictionary)
+}
+
+// apiPutPronunciationsHandler replaces the dictionary
+func apiPutPronunciationsHandler(c *gin.Context) {
+	var dictionary PronunciationDictionary
+	if err := c.ShouldBindJSON(&dictionary); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	if dictionary.Entries == nil {
+		dictionary.Entries = []PronunciationEntry{}
+	}
+	for i := range dictionary.Entries {
+		entry := &dictionary.Entries[i]
+		entry.Display = strings.TrimSpace(entry.Display)
+		entry.Say = strings.TrimSpace(entry.Say)
+	}
+	if details := dictionary.validate(); len(details) > 0 {
+		respondValidationError(c, "Invalid pronunciation dictionary", details...)
+		return
+	}
+	if err := saveJSONBy("pronunciations", dictionary, requestOperator(c), ""); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save pronunciations: "+err.Error())
+		return
+	}
+	log.Printf("Pronunciation dictionary updated (%d entries)", len(dictionary.Entries))
+	respondSuccess(c, http.StatusOK, "Pronunciation dictionary updated", dictionary)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+)
+
+// Departure is one upcoming scheduled station announcement
+type Departure struct {
+	TrainNumber string    `json:"train_number"`
+	Train       string    `json:"train"`
+	Direction   string    `json:"direction"`
+	Destination string    `json:"destination"`
+	Track       string    `json:"track"`
+	DepartsAt   time.Time `json:"departs_at"`
+	// Status is on_time, delayed or cancelled (set through /api/departures)
+	Status       string     `json:"status"`
+	DelayMinutes int        `json:"delay_minutes,omitempty"`
+	ExpectedAt   *time.Time `json:"expected_at,omitempty"`
+
+	// Catalog IDs and languages of the schedule entry, for countdown announcements
+	destinationID string
+	trackNumber   string
+	languages     []string
+}
+
+// PublicAnnouncement is the guest-safe view of an announcement
+type PublicAnnouncement struct {
+	Type        AnnouncementType   `json:"type"`
+	Status      AnnouncementStatus `json:"status"`
+	Title       string             `json:"title"`
+	Caption     string             `json:"caption,omitempty"` // The words spoken
+	ScheduledAt time.Time          `json:"scheduled_at"`
+	StartedAt   *time.Time         `json:"started_at,omitempty"`
+}
+
+// Departures further out than this are left off the board
+const departureHorizon = 24 * time.Hour
+
+// catalogNames maps IDs to display names for one catalog, taking names from
+// the pronunciation dictionary over the catalog's own
+func catalogNames(catalog string) map[string]string {
+	names := make(map[string]string)
+	def, found := findCatalogDefinition(catalog)
+	if !found {
+		return names
+	}
+	items, _ := loadCatalog(def)
+	for _, item := range items {
+		names[item.ID] = item.Name
+	}
+	currentPronunciations().applyDisplayNames(catalog, names)
+	return names
+}
+
+// displayName returns the catalog name for an ID, or the ID itself
+func displayName(names map[string]string, id string) string {
+	if name, ok := names[id]; ok && name != "" {
+		return name
+	}
+	return id
+}
+
+// upcomingDepartures lists the next enabled station announcements from the
+// schedule, soonest first
+func upcomingDepartures(limit int) []Departure {
+	cronData := loadJSON("cron", CronData{}).(CronData)
+	profiles := loadScheduleProfiles()
+	trains := catalogNames("trains")
+	directions := catalogNames("directions")
+	destinations := catalogNames("destinations")
+	tracks := catalogNames("tracks")
+
+	now := time.Now()
+	departures := make([]Departure, 0)
+	for _, item := range cronData.StationAnnouncements {
+		if !item.Enabled {
+			continue
+		}
+		schedule, err := cron.ParseStandard(zonedCronSpec(cronData.Timezone, item.Cron))
+		if err != nil {
+			continue
+		}
+		// Frequent services get several rows within the horizon
+		for next := schedule.Next(now); !next.IsZero() && next.Sub(now) <= departureHorizon && len(departures) < 500; next = schedule.Next(next) {
+			// Departures outside the schedule profile active then don't run
+			if !profiles.allows(item.Profiles, next) {
+				continue
+			}
+			departures = append(departures, Departure{
+				TrainNumber: item.TrainNumber,
+				Train:       displayName(trains, item.TrainNumber),
+				Direction:   displayName(directions, item.Direction),
+				Destination: displayName(destinations, item.Destination),
+				Track:       displayName(tracks, item.TrackNumber),
+				DepartsAt:   next,
+
+				destinationID: item.Destination,
+				trackNumber:   item.TrackNumber,
+				languages:     item.Languages,
+			})
+		}
+	}
+
+	sort.Slice(departures, func(i, j int) bool {
+		return departures[i].DepartsAt.Before(departures[j].DepartsAt)
+	})
+
+	// An open delay or cancellation applies to the train's next departure
+	statuses := currentDepartureStatuses()
+	for i := range departures {
+		departures[i].Status = DepartureOnTime
+		status, ok := statuses[departures[i].TrainNumber]
+		if !ok {
+			continue
+		}
+		delete(statuses, departures[i].TrainNumber)
+		departures[i].Status = status.Status
+		if status.Status == DepartureDelayed {
+			expected := departures[i].DepartsAt.Add(time.Duration(status.DelayMinutes) * time.Minute)
+			departures[i].DelayMinutes = status.DelayMinutes
+			departures[i].ExpectedAt = &expected
+		}
+	}
+	if limit > 0 && len(departures) > limit {
+		departures = departures[:limit]
+	}
+	return departures
+}
+
+// announcementTitle describes an announcement in words suitable for guests,
+// using the locale's strings from locales.json
+func announcementTitle(announcement Announcement, locale string) string {
+	param := func(key string) string {
+		value, _ := announcement.Parameters[key].(string)
+		return value
+	}
+
+	switch announcement.Type {
+	case TypeStation:
+		return fmt.Sprintf("%s %s %s %s - %s %s",
+			displayName(catalogNames("trains"), param("train_number")),
+			displayName(catalogNames("directions"), param("direction")),
+			translate(locale, "title.to", "to"),
+			displayName(catalogNames("destinations"), param("destination")),
+			translate(locale, "board.track", "Track"),
+			displayName(catalogNames("tracks"), param("track_number")))
+	case TypeServiceChange:
+		return fmt.Sprintf("%s %s %s",
+			displayName(catalogNames("trains"), param("train_number")),
+			translate(locale, "title.now_departs_from", "now departs from"),
+			displayName(catalogNames("tracks"), param("new_track")))
+	case TypeDelay:
+		return fmt.Sprintf("%s %s %s %s",
+			displayName(catalogNames("trains"), param("train_number")),
+			translate(locale, "title.delayed", "delayed"),
+			param("delay_minutes"),
+			translate(locale, "title.minutes", "minutes"))
+	case TypeCountdown:
+		return fmt.Sprintf("%s %s %s - %s %s %s",
+			displayName(catalogNames("trains"), param("train_number")),
+			translate(locale, "title.to", "to"),
+			displayName(catalogNames("destinations"), param("destination")),
+			translate(locale, "title.departs_in", "departs in"),
+			param("minutes"),
+			translate(locale, "title.minutes", "minutes"))
+	case TypeCancellation:
+		return fmt.Sprintf("%s %s",
+			displayName(catalogNames("trains"), param("train_number")),
+			translate(locale, "title.cancelled", "cancelled"))
+	case TypeSafety:
+		return translate(locale, "title.safety", "Safety announcement")
+	case TypePromo:
+		return translate(locale, "title.promo", "Park information")
+	case TypeEmergency:
+		if name := displayName(catalogNames("emergencies"), param("file")); name != "" {
+			return name
+		}
+		return translate(locale, "title.emergency", "Emergency announcement")
+	case TypeLightning:
+		return translate(locale, "title.lightning", "Weather alert")
+	case TypeWeather:
+		return translate(locale, "title.weather", "Weather report")
+	case TypeClock:
+		return translate(locale, "title.clock", "Time")
+	case TypeLivePage:
+		return translate(locale, "title.live_page", "Live announcement")
+	default:
+		return translate(locale, "title.announcement", "Announcement")
+	}
+}
+
+func publicAnnouncementView(announcement Announcement, locale string) PublicAnnouncement {
+	return PublicAnnouncement{
+		Type:        announcement.Type,
+		Status:      announcement.Status,
+		Title:       announcementTitle(announcement, locale),
+		Caption:     announcement.Caption,
+		ScheduledAt: announcement.ScheduledAt,
+		StartedAt:   announcement.StartedAt,
+	}
+}
+
+// ipRateLimiter allows a fixed number of requests per client IP per window
+type ipRateLimiter struct {
+	mutex       sync.Mutex
+	limit       int
+	window      time.Duration
+	counts      map[string]int
+	windowStart time.Time
+}
+
+func newIPRateLimiter(limit int, window time.Duration) *ipRateLimiter {
+	return &ipRateLimiter{limit: limit, window: window, counts: make(map[string]int), windowStart: time.Now()}
+}
+
+// allow counts a request and returns the seconds to wait when over the limit
+func (l *ipRateLimiter) allow(ip string) (bool, int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if elapsed := time.Since(l.windowStart); elapsed >= l.window {
+		l.counts = make(map[string]int)
+		l.windowStart = time.Now()
+	}
+	if l.counts[ip] >= l.limit {
+		retryAfter := int((l.window - time.Since(l.windowStart)).Seconds()) + 1
+		return false, retryAfter
+	}
+	l.counts[ip]++
+	return true, 0
+}
+
+func (l *ipRateLimiter) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ok, retryAfter := l.allow(c.ClientIP()); !ok {
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			respondError(c, http.StatusTooManyRequests, ErrCodeRateLimited, "Too many requests, please slow down")
+			return
+		}
+		c.Next()
+	}
+}
+
+// Guest displays poll every few seconds, so this leaves plenty of headroom
+var publicRateLimiter = newIPRateLimiter(60, time.Minute)
+
+// apiPublicNowPlayingHandler returns the current and next announcement and the
+// departure board. It needs no authentication, so it exposes no config.
+// ?locale= picks the language of the titles.
+func apiPublicNowPlayingHandler(c *gin.Context) {
+	locale := c.DefaultQuery("locale", getLocaleSettings().DefaultLocale)
+	var current *PublicAnnouncement
+	next := make([]PublicAnnouncement, 0)
+	if announcementManager != nil {
+		playing, upcoming := announcementManager.NowPlaying()
+		if playing != nil {
+			view := publicAnnouncementView(*playing, locale)
+			current = &view
+		}
+		for _, announcement := range upcoming {
+			if len(next) == 3 {
+				break
+			}
+			next = append(next, publicAnnouncementView(announcement, locale))
+		}
+	}
+
+	c.Header("Cache-Control", "public, max-age=5")
+	respondOK(c, gin.H{
+		"now_playing": current,
+		"up_next":     next,
+		"departures":  upcomingDepartures(10),
+		"server_time": time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// Each announcement's length is estimated when it is queued, from the decoded
+// length of its clips (cached per file, see playback_watchdog.go). The queue
+// status uses the estimates to give every queued announcement a start time
+// and to say when the backlog will clear, so an operator can tell whether an
+// emergency will be over before the next departure. The estimates assume the
+// queue plays in its current order, with spacing_ms between announcements;
+// anything queued later at a higher priority pushes them back.
+
+// queueEstimate is the backlog summary in the queue status
+type queueEstimate struct {
+	BacklogSeconds float64    `json:"backlog_seconds"`     // Left of the current announcement plus every queued one
+	ClearsAt       *time.Time `json:"clears_at,omitempty"` // When the last queued announcement should finish
+	Paused         bool       `json:"paused"`              // No start times while the queue is paused
+}
+
+// remainingPlayback is how much of the playing announcement is left by its
+// estimate
+func remainingPlayback(playing *Announcement, now time.Time) time.Duration {
+	if playing == nil || playing.StartedAt == nil {
+		return 0
+	}
+	remaining := playing.EstimatedDuration - now.Sub(*playing.StartedAt)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// estimateQueue sorts copies of the queued announcements into play order and
+// sets each one's ETA. Caller must hold the mutex.
+func (am *AnnouncementManager) estimateQueue(now time.Time) ([]Announcement, queueEstimate) {
+	ordered := make(AnnouncementQueue, len(*am.queue))
+	copy(ordered, *am.queue)
+	sort.Slice(ordered, func(i, j int) bool { return ordered.Less(i, j) })
+
+	remaining := remainingPlayback(am.playing, now)
+	estimate := queueEstimate{Paused: am.isPaused}
+	backlog := remaining
+	cursor := now.Add(remaining)
+	spacing := announcementSpacing()
+	// When the previous announcement ended, or will end, for spacing_ms
+	previousEnd := am.lastFinished
+	if am.playing != nil {
+		previousEnd = cursor
+	}
+
+	items := make([]Announcement, 0, len(ordered))
+	for _, queued := range ordered {
+		item := *queued
+		backlog += item.EstimatedDuration
+		if !am.isPaused {
+			start := cursor
+			if spacing > 0 && !previousEnd.IsZero() && spacesOut(&item) && previousEnd.Add(spacing).After(start) {
+				start = previousEnd.Add(spacing)
+			}
+			if item.ScheduledAt.After(start) {
+				start = item.ScheduledAt
+			}
+			eta := start
+			item.ETA = &eta
+			cursor = start.Add(item.EstimatedDuration)
+			previousEnd = cursor
+		}
+		items = append(items, item)
+	}
+
+	estimate.BacklogSeconds = backlog.Round(100 * time.Millisecond).Seconds()
+	if !am.isPaused && (am.playing != nil || len(items) > 0) {
+		clears := cursor
+		estimate.ClearsAt = &clears
+	}
+	return items, estimate
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// Activity quotas rein in one operator or integration without revoking its
+// access. An admin user's max_announcements_per_hour caps the announcements
+// they queue by hand in any rolling hour, and an API key's max_queued caps how
+// many of its announcements may be queued or playing at once. Zero means no
+// limit. Emergency announcements are never held back by a quota. Hourly
+// counts are kept in memory and reset on restart.
+//
+// The hourly quota applies to the announce API and to the play buttons of the
+// web pages alike. A request takes its slot before the announcement is
+// queued, so requests sent together can't all pass the check, and gives it
+// back if queuing fails.
+
+// userAnnouncementTimes holds when each admin user's recent announcements
+// were queued, oldest first
+var (
+	userAnnouncementTimes = map[string][]time.Time{}
+	userAnnouncementMutex sync.Mutex
+)
+
+// announcementRequester names who is asking for an announcement: the logged
+// in admin user if there is one, otherwise the API key
+func announcementRequester(c *gin.Context) string {
+	if userID, ok := sessions.Default(c).Get("admin_user_id").(string); ok && userID != "" {
+		return "user:" + userID
+	}
+	if value, ok := c.Get("api_key_data"); ok {
+		if apiKeyData, ok := value.(*APIKey); ok {
+			return "key:" + apiKeyData.ID
+		}
+	}
+	return ""
+}
+
+// recentUserAnnouncements drops entries older than an hour and returns how
+// many are left and when the oldest of them leaves the window. Caller must
+// hold userAnnouncementMutex.
+func recentUserAnnouncements(userID string, now time.Time) (int, time.Time) {
+	times := userAnnouncementTimes[userID]
+	for len(times) > 0 && now.Sub(times[0]) >= time.Hour {
+		times = times[1:]
+	}
+	if len(times) == 0 {
+		delete(userAnnouncementTimes, userID)
+		return 0, now
+	}
+	userAnnouncementTimes[userID] = times
+	return len(times), times[0].Add(time.Hour)
+}
+
+// releaseUserAnnouncement gives back a slot taken at reservedAt
+func releaseUserAnnouncement(userID string, reservedAt time.Time) {
+	userAnnouncementMutex.Lock()
+	defer userAnnouncementMutex.Unlock()
+	times := userAnnouncementTimes[userID]
+	for i := len(times) - 1; i >= 0; i-- {
+		if times[i].Equal(reservedAt) {
+			userAnnouncementTimes[userID] = append(times[:i:i], times[i+1:]...)
+			return
+		}
+	}
+}
+
+// findAdminUser looks up an admin user by ID
+func findAdminUser(userID string) *AdminUser {
+	adminConfig, err := loadAdminConfig(adminConfigPath())
+	if err != nil {
+		return nil
+	}
+	for i, user := range adminConfig.AdminUsers {
+		if user.ID == userID {
+			return &adminConfig.AdminUsers[i]
+		}
+	}
+	return nil
+}
+
+// announcementQuotaMiddleware refuses an announcement over the caller's quota
+// with 429 and records who asked for the ones it lets through
+func announcementQuotaMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requester := announcementRequester(c)
+		c.Set("announcement_requester", requester)
+		if requester == "" || strings.HasSuffix(c.FullPath(), "/announce/emergency") {
+			c.Next()
+			return
+		}
+
+		if userID, ok := sessions.Default(c).Get("admin_user_id").(string); ok && userID != "" {
+			user := findAdminUser(userID)
+			if user == nil || user.MaxAnnouncementsPerHour <= 0 {
+				c.Next()
+				return
+			}
+			userAnnouncementMutex.Lock()
+			now := time.Now()
+			count, resetAt := recentUserAnnouncements(userID, now)
+			if count < user.MaxAnnouncementsPerHour {
+				userAnnouncementTimes[userID] = append(userAnnouncementTimes[userID], now)
+			}
+			userAnnouncementMutex.Unlock()
+			if count >= user.MaxAnnouncementsPerHour {
+				log.Printf("🚦 Announcement from %s refused: %d in the last hour (limit %d)", user.Username, count, user.MaxAnnouncementsPerHour)
+				c.Header("Retry-After", strconv.Itoa(int(time.Until(resetAt).Seconds())+1))
+				respondError(c, http.StatusTooManyRequests, ErrCodeRateLimited,
+					fmt.Sprintf("You have queued %d announcements in the last hour, the most allowed for your account", count))
+				return
+			}
+
+			// The slot is given back if the announcement wasn't queued, or
+			// the handler panicked
+			queued := false
+			defer func() {
+				if !queued {
+					releaseUserAnnouncement(userID, now)
+				}
+			}()
+			c.Next()
+			queued = c.Writer.Status() < http.StatusBadRequest
+			return
+		}
+
+		value, _ := c.Get("api_key_data")
+		if apiKeyData, ok := value.(*APIKey); ok && apiKeyData.MaxQueued > 0 && announcementManager != nil {
+			if active := announcementManager.CountActiveBy(requester); active >= apiKeyData.MaxQueued {
+				log.Printf("🚦 Announcement from API key %s refused: %d already queued (limit %d)", apiKeyData.Name, active, apiKeyData.MaxQueued)
+				respondError(c, http.StatusTooManyRequests, ErrCodeRateLimited,
+					fmt.Sprintf("This API key already has %d announcements queued, the most allowed at once", active))
+				return
+			}
+		}
+		c.Next()
+	}
+}
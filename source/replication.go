@@ -0,0 +1,652 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Replication keeps every unit at a station announcing from the same data.
+// A designated primary serves a bundle of its catalogs, schedule, schedule
+// profiles and event scripts along with a manifest of the audio in its mp3
+// directory. A secondary applies a bundle by writing the files that differ
+// and downloading the audio it lacks from the primary. The secondary pulls
+// on request or every pull_interval_minutes, or the primary pushes the bundle
+// to its listed secondaries. Either way a dry run returns the differences
+// without changing anything. Both directions use API keys with the config
+// permission: the primary holds a key for each secondary, and each secondary
+// holds one for the primary. A secondary only accepts pushes once it names
+// its primary, which is also where it fetches pushed audio from.
+
+// ReplicationPeer is another unit and the key used with its API
+type ReplicationPeer struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`     // e.g. http://10.0.0.6:8080
+	APIKey string `json:"api_key"` // A key on that unit with the config permission
+}
+
+// ReplicationSettings is the replication section of admin_config.json
+type ReplicationSettings struct {
+	Secondaries         []ReplicationPeer `json:"secondaries"`           // On the primary: units it pushes to
+	Primary             *ReplicationPeer  `json:"primary,omitempty"`     // On a secondary: the unit it replicates from
+	PullIntervalMinutes int               `json:"pull_interval_minutes"` // 0 pulls only on request
+	Audio               bool              `json:"audio"`                 // Replicate the audio in the mp3 directory too
+}
+
+func getDefaultReplicationSettings() ReplicationSettings {
+	return ReplicationSettings{
+		Secondaries: []ReplicationPeer{},
+		Audio:       true,
+	}
+}
+
+// ReplicationBundle is what the primary sends
+type ReplicationBundle struct {
+	Source    string                     `json:"source"` // The primary's hostname
+	CreatedAt time.Time                  `json:"created_at"`
+	Files     map[string]json.RawMessage `json:"files"` // By file name
+	Audio     []ReplicationAudioFile     `json:"audio"` // Empty when audio isn't replicated
+}
+
+// ReplicationAudioFile is one entry of the audio manifest, in the format of
+// the asset sync HTTP manifest
+type ReplicationAudioFile struct {
+	Path   string `json:"path"` // Slash-separated, relative to the mp3 directory
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// ReplicationFileChange is a file the bundle changes
+type ReplicationFileChange struct {
+	File    string     `json:"file"`
+	Action  string     `json:"action"` // added or changed
+	Changes []JSONDiff `json:"changes,omitempty"`
+}
+
+// ReplicationReport describes applying one bundle
+type ReplicationReport struct {
+	Peer           string                  `json:"peer"`
+	DryRun         bool                    `json:"dry_run"`
+	StartedAt      time.Time               `json:"started_at"`
+	FinishedAt     time.Time               `json:"finished_at"`
+	Files          []ReplicationFileChange `json:"files"`
+	Unchanged      int                     `json:"unchanged"`
+	RestartNeeded  []string                `json:"restart_needed"`
+	AudioAdded     []string                `json:"audio_added"`
+	AudioUpdated   []string                `json:"audio_updated"`
+	AudioUnchanged int                     `json:"audio_unchanged"`
+	AudioFailed    []assetSyncFailure      `json:"audio_failed"`
+	Error          string                  `json:"error,omitempty"`
+}
+
+var (
+	replicationMutex sync.Mutex // One apply at a time
+	replicationState struct {
+		sync.Mutex
+		lastPull *ReplicationReport
+		lastPush []*ReplicationReport
+	}
+)
+
+// The audio manifest hashes each file once; entries are reused while the
+// file keeps its size and time
+var audioManifestCache = struct {
+	sync.Mutex
+	entries map[string]audioManifestEntry
+}{entries: make(map[string]audioManifestEntry)}
+
+type audioManifestEntry struct {
+	size    int64
+	modTime time.Time
+	sha256  string
+}
+
+var replicationClient = &http.Client{Timeout: 5 * time.Minute}
+
+// loadReplicationSettings reads the replication section of admin_config.json
+func loadReplicationSettings() ReplicationSettings {
+	adminConfig, err := loadAdminConfig(adminConfigPath())
+	if err != nil {
+		return getDefaultReplicationSettings()
+	}
+	return adminConfig.Replication
+}
+
+// replicatedFiles lists the JSON files a bundle carries: the catalogs, the
+// schedule, schedule profiles and event scripts
+func replicatedFiles() []string {
+	names := append(revisionTrackedFiles(), "schedule_profiles", "event_scripts")
+	files := make([]string, 0, len(names))
+	for _, name := range names {
+		files = append(files, jsonFiles[name].FileName)
+	}
+	return files
+}
+
+// buildReplicationBundle collects this unit's files and audio manifest
+func buildReplicationBundle(audio bool) (*ReplicationBundle, error) {
+	hostname, _ := os.Hostname()
+	bundle := &ReplicationBundle{
+		Source:    hostname,
+		CreatedAt: time.Now(),
+		Files:     make(map[string]json.RawMessage),
+		Audio:     []ReplicationAudioFile{},
+	}
+	for _, name := range replicatedFiles() {
+		data, err := os.ReadFile(filepath.Join(app.Config.JSONDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("%s is not valid JSON", name)
+		}
+		bundle.Files[name] = data
+	}
+	if audio {
+		manifest, err := audioManifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list audio: %v", err)
+		}
+		bundle.Audio = manifest
+	}
+	return bundle, nil
+}
+
+// audioManifest lists the audio in the mp3 directory with its hashes
+func audioManifest() ([]ReplicationAudioFile, error) {
+	audioManifestCache.Lock()
+	defer audioManifestCache.Unlock()
+
+	manifest := []ReplicationAudioFile{}
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(app.Config.MP3Dir, func(file string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !assetSyncExtensions[strings.ToLower(filepath.Ext(file))] {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		relative, _ := filepath.Rel(app.Config.MP3Dir, file)
+		relative = filepath.ToSlash(relative)
+		seen[relative] = true
+
+		cached, ok := audioManifestCache.entries[relative]
+		if !ok || cached.size != info.Size() || !cached.modTime.Equal(info.ModTime()) {
+			sum, err := fileSHA256(file)
+			if err != nil {
+				return err
+			}
+			cached = audioManifestEntry{size: info.Size(), modTime: info.ModTime(), sha256: sum}
+			audioManifestCache.entries[relative] = cached
+		}
+		manifest = append(manifest, ReplicationAudioFile{Path: relative, SHA256: cached.sha256, Size: cached.size})
+		return nil
+	})
+	for relative := range audioManifestCache.entries {
+		if !seen[relative] {
+			delete(audioManifestCache.entries, relative)
+		}
+	}
+	return manifest, err
+}
+
+func newReplicationReport(peer string, dryRun bool) *ReplicationReport {
+	return &ReplicationReport{
+		Peer:          peer,
+		DryRun:        dryRun,
+		StartedAt:     time.Now(),
+		Files:         []ReplicationFileChange{},
+		RestartNeeded: []string{},
+		AudioAdded:    []string{},
+		AudioUpdated:  []string{},
+		AudioFailed:   []assetSyncFailure{},
+	}
+}
+
+// applyReplicationBundle writes the files of a bundle that differ from ours
+// and, when fetching from a primary, downloads the audio that differs. Files
+// this unit doesn't replicate are ignored.
+func applyReplicationBundle(bundle *ReplicationBundle, audioFrom *ReplicationPeer, dryRun bool) *ReplicationReport {
+	replicationMutex.Lock()
+	defer replicationMutex.Unlock()
+
+	report := newReplicationReport(bundle.Source, dryRun)
+	defer func() { report.FinishedAt = time.Now() }()
+
+	changed := make(map[string][]byte)
+	for _, name := range replicatedFiles() {
+		incoming, ok := bundle.Files[name]
+		if !ok {
+			continue
+		}
+		var after interface{}
+		if err := json.Unmarshal(incoming, &after); err != nil {
+			report.Error = fmt.Sprintf("%s in the bundle is not valid JSON: %v", name, err)
+			return report
+		}
+		current, err := os.ReadFile(filepath.Join(app.Config.JSONDir, name))
+		if os.IsNotExist(err) {
+			report.Files = append(report.Files, ReplicationFileChange{File: name, Action: "added"})
+			changed[name] = incoming
+			continue
+		}
+		if err != nil {
+			report.Error = fmt.Sprintf("failed to read %s: %v", name, err)
+			return report
+		}
+		var before interface{}
+		json.Unmarshal(current, &before)
+		diffs := diffJSON(before, after)
+		if len(diffs) == 0 {
+			report.Unchanged++
+			continue
+		}
+		report.Files = append(report.Files, ReplicationFileChange{File: name, Action: "changed", Changes: diffs})
+		changed[name] = incoming
+	}
+	sort.Slice(report.Files, func(i, j int) bool {
+		return report.Files[i].File < report.Files[j].File
+	})
+
+	if !dryRun && len(changed) > 0 {
+		configSnapshotMutex.Lock()
+		_, err := takeConfigSnapshot(snapshotBeforeReplication)
+		configSnapshotMutex.Unlock()
+		if err != nil {
+			report.Error = "failed to snapshot the configuration first: " + err.Error()
+			return report
+		}
+		_, restart, err := writeConfigFiles(changed, "replication:"+bundle.Source, "Replicated from "+bundle.Source)
+		report.RestartNeeded = restart
+		if err != nil {
+			report.Error = err.Error()
+			return report
+		}
+	}
+
+	if err := replicateAudio(bundle.Audio, audioFrom, dryRun, report); err != nil {
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// replicateAudio compares the audio manifest with the mp3 directory and
+// downloads what differs from the primary
+func replicateAudio(manifest []ReplicationAudioFile, from *ReplicationPeer, dryRun bool, report *ReplicationReport) error {
+	if len(manifest) == 0 {
+		return nil
+	}
+	if !dryRun {
+		if from == nil || from.URL == "" {
+			return fmt.Errorf("audio differs but no replication.primary is configured to fetch it from")
+		}
+		if err := storageWritable(app.Config.MP3Dir, "replication"); err != nil {
+			return err
+		}
+	}
+	source := &replicationAssetSource{peer: from}
+	for _, file := range manifest {
+		if !assetSyncExtensions[strings.ToLower(path.Ext(file.Path))] {
+			continue
+		}
+		local, err := mp3Path(filepath.FromSlash(file.Path))
+		if err != nil {
+			report.AudioFailed = append(report.AudioFailed, assetSyncFailure{Path: file.Path, Error: err.Error()})
+			continue
+		}
+		asset := remoteAsset{Path: file.Path, Size: file.Size, Hash: file.SHA256, HashType: "sha256"}
+		exists := fileExists(local)
+		if exists && localAssetMatches(local, asset) {
+			report.AudioUnchanged++
+			continue
+		}
+		if !dryRun {
+			if err := downloadAsset(source, asset, local); err != nil {
+				report.AudioFailed = append(report.AudioFailed, assetSyncFailure{Path: file.Path, Error: err.Error()})
+				continue
+			}
+		}
+		if exists {
+			report.AudioUpdated = append(report.AudioUpdated, file.Path)
+		} else {
+			report.AudioAdded = append(report.AudioAdded, file.Path)
+		}
+	}
+	return nil
+}
+
+// replicationAssetSource fetches audio from the primary's replication API
+type replicationAssetSource struct {
+	peer *ReplicationPeer
+}
+
+func (s *replicationAssetSource) list() ([]remoteAsset, error) {
+	return nil, fmt.Errorf("the audio list comes with the bundle")
+}
+
+func (s *replicationAssetSource) open(asset remoteAsset) (io.ReadCloser, error) {
+	resp, err := peerRequest(s.peer, http.MethodGet, "/api/replication/audio/"+(&url.URL{Path: asset.Path}).EscapedPath(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// peerRequest calls another unit's API with its key. Any response other than
+// 2xx is an error.
+func peerRequest(peer *ReplicationPeer, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, strings.TrimRight(peer.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "tarr-annunciator/"+appVersion)
+	req.Header.Set("X-API-Key", peer.APIKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := replicationClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		var reply struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&reply)
+		if reply.Error != "" {
+			return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, reply.Error)
+		}
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// pullReplication fetches the primary's bundle and applies it
+func pullReplication(settings ReplicationSettings, dryRun bool) *ReplicationReport {
+	peer := settings.Primary
+	report := newReplicationReport(peer.Name, dryRun)
+	query := "?audio=" + fmt.Sprint(settings.Audio)
+	resp, err := peerRequest(peer, http.MethodGet, "/api/replication/bundle"+query, nil)
+	if err == nil {
+		defer resp.Body.Close()
+		var reply struct {
+			Data ReplicationBundle `json:"data"`
+		}
+		if err = json.NewDecoder(io.LimitReader(resp.Body, 64<<20)).Decode(&reply); err != nil {
+			err = fmt.Errorf("invalid bundle: %v", err)
+		} else {
+			report = applyReplicationBundle(&reply.Data, peer, dryRun)
+			report.Peer = peer.Name
+		}
+	}
+	if err != nil {
+		report.Error = err.Error()
+		report.FinishedAt = time.Now()
+	}
+
+	logReplicationReport("Pull from "+peer.Name, report)
+	replicationState.Lock()
+	replicationState.lastPull = report
+	replicationState.Unlock()
+	return report
+}
+
+// pushReplication sends this unit's bundle to each secondary, or only to the
+// one named
+func pushReplication(settings ReplicationSettings, only string, dryRun bool) ([]*ReplicationReport, error) {
+	bundle, err := buildReplicationBundle(settings.Audio)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []*ReplicationReport
+	for i := range settings.Secondaries {
+		peer := &settings.Secondaries[i]
+		if only != "" && peer.Name != only {
+			continue
+		}
+		report := newReplicationReport(peer.Name, dryRun)
+		resp, err := peerRequest(peer, http.MethodPost, "/api/replication/apply?dry_run="+fmt.Sprint(dryRun), body)
+		if err == nil {
+			var reply struct {
+				Data ReplicationReport `json:"data"`
+			}
+			err = json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(&reply)
+			resp.Body.Close()
+			if err == nil {
+				report = &reply.Data
+				report.Peer = peer.Name
+			}
+		}
+		if err != nil {
+			report.Error = err.Error()
+			report.FinishedAt = time.Now()
+		}
+		logReplicationReport("Push to "+peer.Name, report)
+		reports = append(reports, report)
+	}
+	if only != "" && len(reports) == 0 {
+		return nil, fmt.Errorf("no secondary named %s", only)
+	}
+
+	replicationState.Lock()
+	replicationState.lastPush = reports
+	replicationState.Unlock()
+	return reports, nil
+}
+
+// logReplicationReport logs the outcome of a pull or push
+func logReplicationReport(what string, report *ReplicationReport) {
+	switch {
+	case report.Error != "":
+		log.Printf("❌ Replication: %s failed: %s", what, report.Error)
+	case report.DryRun:
+		log.Printf("Replication: %s (dry run): %d files and %d audio files would change",
+			what, len(report.Files), len(report.AudioAdded)+len(report.AudioUpdated))
+	case len(report.Files) > 0 || len(report.AudioAdded) > 0 || len(report.AudioUpdated) > 0 || len(report.AudioFailed) > 0:
+		log.Printf("🔄 Replication: %s: %d files changed, %d audio files added, %d updated, %d failed",
+			what, len(report.Files), len(report.AudioAdded), len(report.AudioUpdated), len(report.AudioFailed))
+	}
+}
+
+// startReplication pulls from the primary every pull_interval_minutes
+func startReplication() {
+	go func() {
+		for {
+			settings := loadReplicationSettings()
+			if settings.PullIntervalMinutes <= 0 || settings.Primary == nil {
+				time.Sleep(time.Minute)
+				continue
+			}
+			pullReplication(settings, false)
+			time.Sleep(time.Duration(settings.PullIntervalMinutes) * time.Minute)
+		}
+	}()
+}
+
+// Handlers
+
+// replicationPeerView leaves out the key
+func replicationPeerView(peer ReplicationPeer) gin.H {
+	return gin.H{"name": peer.Name, "url": peer.URL, "api_key_set": peer.APIKey != ""}
+}
+
+// apiReplicationStatusHandler returns the settings and the last pull and push
+func apiReplicationStatusHandler(c *gin.Context) {
+	settings := loadReplicationSettings()
+	secondaries := []gin.H{}
+	for _, peer := range settings.Secondaries {
+		secondaries = append(secondaries, replicationPeerView(peer))
+	}
+	var primary gin.H
+	if settings.Primary != nil {
+		primary = replicationPeerView(*settings.Primary)
+	}
+
+	replicationState.Lock()
+	lastPull, lastPush := replicationState.lastPull, replicationState.lastPush
+	replicationState.Unlock()
+	respondOK(c, gin.H{
+		"settings": gin.H{
+			"primary":               primary,
+			"secondaries":           secondaries,
+			"pull_interval_minutes": settings.PullIntervalMinutes,
+			"audio":                 settings.Audio,
+		},
+		"last_pull": lastPull,
+		"last_push": lastPush,
+	})
+}
+
+// apiReplicationBundleHandler returns this unit's bundle. ?audio=false leaves
+// out the audio manifest.
+func apiReplicationBundleHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	bundle, err := buildReplicationBundle(c.Query("audio") != "false")
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to build the bundle: "+err.Error())
+		return
+	}
+	respondOK(c, bundle)
+}
+
+// apiReplicationAudioHandler sends one audio file from the mp3 directory
+func apiReplicationAudioHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	relative := strings.TrimPrefix(c.Param("path"), "/")
+	local, err := mp3Path(filepath.FromSlash(relative))
+	if err != nil || !assetSyncExtensions[strings.ToLower(filepath.Ext(local))] {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid audio path")
+		return
+	}
+	if !fileExists(local) {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Audio file not found")
+		return
+	}
+	c.File(local)
+}
+
+// apiReplicationApplyHandler applies a bundle pushed by the primary.
+// ?dry_run=true returns the differences without changing anything.
+func apiReplicationApplyHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	settings := loadReplicationSettings()
+	if settings.Primary == nil {
+		respondError(c, http.StatusConflict, ErrCodeConflict, "This unit has no replication.primary and doesn't accept pushes")
+		return
+	}
+	var bundle ReplicationBundle
+	if err := c.ShouldBindJSON(&bundle); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid bundle: "+err.Error())
+		return
+	}
+	if !settings.Audio {
+		bundle.Audio = nil
+	}
+
+	report := applyReplicationBundle(&bundle, settings.Primary, c.Query("dry_run") == "true")
+	logReplicationReport("Push from "+bundle.Source, report)
+	replicationState.Lock()
+	replicationState.lastPull = report
+	replicationState.Unlock()
+	if report.Error != "" {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, report.Error)
+		return
+	}
+	respondOK(c, report)
+}
+
+// apiReplicationPullHandler pulls from the primary now. "dry_run": true
+// returns the differences without changing anything.
+func apiReplicationPullHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	data, ok := bindRequestData(c, "dry_run")
+	if !ok {
+		return
+	}
+	dryRun := data["dry_run"] == true || data["dry_run"] == "true"
+	settings := loadReplicationSettings()
+	if settings.Primary == nil {
+		respondError(c, http.StatusConflict, ErrCodeConflict, "No replication.primary is configured in admin_config.json")
+		return
+	}
+
+	log.Printf("Replication pull requested by %s (dry run: %v)", requestOperator(c), dryRun)
+	report := pullReplication(settings, dryRun)
+	if report.Error != "" {
+		respondError(c, http.StatusBadGateway, ErrCodeUnavailable, report.Error)
+		return
+	}
+	respondSuccess(c, http.StatusOK, "Replication pull finished", gin.H{"report": report})
+}
+
+// apiReplicationPushHandler pushes to the secondaries now, or only to
+// "secondary". "dry_run": true returns each secondary's differences without
+// changing anything.
+func apiReplicationPushHandler(c *gin.Context) {
+	if !requireConfigPermission(c) {
+		return
+	}
+	data, ok := bindRequestData(c, "dry_run", "secondary")
+	if !ok {
+		return
+	}
+	dryRun := data["dry_run"] == true || data["dry_run"] == "true"
+	only, _ := data["secondary"].(string)
+	settings := loadReplicationSettings()
+	if len(settings.Secondaries) == 0 {
+		respondError(c, http.StatusConflict, ErrCodeConflict, "No replication.secondaries are configured in admin_config.json")
+		return
+	}
+
+	log.Printf("Replication push requested by %s (dry run: %v)", requestOperator(c), dryRun)
+	reports, err := pushReplication(settings, only, dryRun)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Push failed: "+err.Error())
+		return
+	}
+	failed := 0
+	for _, report := range reports {
+		if report.Error != "" {
+			failed++
+		}
+	}
+	message := fmt.Sprintf("Pushed to %d secondaries", len(reports)-failed)
+	if failed > 0 {
+		message += fmt.Sprintf(", %d failed", failed)
+	}
+	respondSuccess(c, http.StatusOK, message, gin.H{"reports": reports})
+}
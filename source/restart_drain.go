@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A plain restart cuts off whatever is playing. A draining restart first
+// stops taking announcements other than emergencies and lightning alerts,
+// waits for the queue to play out, then restarts. If the queue hasn't
+// emptied by the deadline the restart goes ahead anyway, or is called off
+// when on_timeout is "cancel". Announcements queued for after the deadline
+// aren't waited for; like anything left in the queue, a restart loses them.
+
+const (
+	defaultDrainTimeout = 5 * time.Minute
+	maxDrainTimeout     = time.Hour
+	drainCheckInterval  = time.Second
+)
+
+// Restart drain states
+const (
+	DrainDraining   = "draining"
+	DrainRestarting = "restarting"
+	DrainCancelled  = "cancelled"
+)
+
+// RestartDrain is a restart waiting for the queue
+type RestartDrain struct {
+	State       string     `json:"state"`
+	RequestedBy string     `json:"requested_by"`
+	StartedAt   time.Time  `json:"started_at"`
+	Deadline    time.Time  `json:"deadline"`
+	OnTimeout   string     `json:"on_timeout"`        // restart or cancel
+	Refused     int        `json:"refused"`           // Announcements turned away while draining
+	Outcome     string     `json:"outcome,omitempty"` // Why it restarted or was called off
+	EndedAt     *time.Time `json:"ended_at,omitempty"`
+
+	cancel chan struct{}
+}
+
+var (
+	// The draining restart in progress, or the last one
+	restartDrain      *RestartDrain
+	restartDrainMutex sync.Mutex
+)
+
+// restartDrainError refuses an announcement while a restart drains the queue
+type restartDrainError struct{}
+
+func (e *restartDrainError) Error() string {
+	return "the annunciator is about to restart and only takes emergency announcements"
+}
+
+// checkRestartDrain refuses announcements other than emergencies and
+// lightning alerts once a draining restart has begun
+func checkRestartDrain(announcementType AnnouncementType, priority AnnouncementPriority) error {
+	if announcementType == TypeEmergency || announcementType == TypeLightning || priority >= PriorityEmergency {
+		return nil
+	}
+	restartDrainMutex.Lock()
+	defer restartDrainMutex.Unlock()
+	if restartDrain == nil || restartDrain.State == DrainCancelled {
+		return nil
+	}
+	restartDrain.Refused++
+	return &restartDrainError{}
+}
+
+// drainProgress returns the ID of the announcement playing, how many queued
+// announcements are due before the deadline and how many are due after it
+func (am *AnnouncementManager) drainProgress(deadline time.Time) (string, int, int) {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+	playing := ""
+	if am.playing != nil {
+		playing = am.playing.ID
+	}
+	waiting, later := 0, 0
+	for _, announcement := range *am.queue {
+		if announcement.ScheduledAt.Before(deadline) {
+			waiting++
+		} else {
+			later++
+		}
+	}
+	return playing, waiting, later
+}
+
+// startRestartDrain begins a draining restart
+func startRestartDrain(requestedBy string, timeout time.Duration, onTimeout string) (RestartDrain, error) {
+	restartDrainMutex.Lock()
+	defer restartDrainMutex.Unlock()
+	if restartDrain != nil && restartDrain.State != DrainCancelled {
+		return *restartDrain, fmt.Errorf("a restart is already %s", restartDrain.State)
+	}
+	now := time.Now()
+	restartDrain = &RestartDrain{
+		State:       DrainDraining,
+		RequestedBy: requestedBy,
+		StartedAt:   now,
+		Deadline:    now.Add(timeout),
+		OnTimeout:   onTimeout,
+		cancel:      make(chan struct{}),
+	}
+	log.Printf("🔁 Restart requested by %s: draining the queue for up to %s", requestedBy, timeout)
+	go runRestartDrain(restartDrain)
+	return *restartDrain, nil
+}
+
+// runRestartDrain waits for the queue to empty, then restarts
+func runRestartDrain(drain *RestartDrain) {
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+	for {
+		playing, waiting, later := "", 0, 0
+		if announcementManager != nil {
+			playing, waiting, later = announcementManager.drainProgress(drain.Deadline)
+		}
+		switch {
+		case playing == "" && waiting == 0:
+			outcome := "The queue is idle"
+			if later > 0 {
+				outcome += fmt.Sprintf("; %d announcements queued for later are dropped", later)
+			}
+			endRestartDrain(drain, DrainRestarting, outcome)
+			return
+		case !time.Now().Before(drain.Deadline):
+			left := waiting
+			if playing != "" {
+				left++
+			}
+			if drain.OnTimeout == "cancel" {
+				endRestartDrain(drain, DrainCancelled, fmt.Sprintf("Timed out with %d announcements still to play", left))
+				return
+			}
+			endRestartDrain(drain, DrainRestarting, fmt.Sprintf("Timed out; %d announcements are cut off", left+later))
+			return
+		}
+		select {
+		case <-drain.cancel:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// endRestartDrain records how a drain ended and restarts when it should
+func endRestartDrain(drain *RestartDrain, state, outcome string) {
+	restartDrainMutex.Lock()
+	if drain.State != DrainDraining {
+		// Called off in the meantime
+		restartDrainMutex.Unlock()
+		return
+	}
+	now := time.Now()
+	drain.State, drain.Outcome, drain.EndedAt = state, outcome, &now
+	restartDrainMutex.Unlock()
+
+	if state == DrainRestarting {
+		log.Printf("🔁 %s, restarting", outcome)
+		go restartApplication()
+	} else {
+		log.Printf("🔁 Restart called off: %s", outcome)
+	}
+}
+
+// cancelRestartDrain calls off a draining restart before it restarts
+func cancelRestartDrain(operator string) error {
+	restartDrainMutex.Lock()
+	defer restartDrainMutex.Unlock()
+	if restartDrain == nil || restartDrain.State == DrainCancelled {
+		return fmt.Errorf("no restart is waiting")
+	}
+	if restartDrain.State == DrainRestarting {
+		return fmt.Errorf("the restart has already begun")
+	}
+	now := time.Now()
+	restartDrain.State, restartDrain.Outcome, restartDrain.EndedAt = DrainCancelled, "Called off by "+operator, &now
+	close(restartDrain.cancel)
+	log.Printf("🔁 Restart called off by %s", operator)
+	return nil
+}
+
+// currentRestartDrain returns the draining restart in progress or last
+// called off, or nil
+func currentRestartDrain() *RestartDrain {
+	restartDrainMutex.Lock()
+	defer restartDrainMutex.Unlock()
+	if restartDrain == nil {
+		return nil
+	}
+	drain := *restartDrain
+	return &drain
+}
+
+// Handlers
+
+// restartStatusHandler reports the progress of a draining restart
+func restartStatusHandler(c *gin.Context) {
+	drain := currentRestartDrain()
+	if drain == nil {
+		c.JSON(http.StatusOK, gin.H{"success": true, "drain": nil})
+		return
+	}
+	status := gin.H{"success": true, "drain": drain}
+	if drain.State == DrainDraining && announcementManager != nil {
+		playing, waiting, later := announcementManager.drainProgress(drain.Deadline)
+		status["playing"] = playing
+		status["waiting"] = waiting
+		status["queued_after_deadline"] = later
+		status["seconds_left"] = int(time.Until(drain.Deadline).Seconds())
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// cancelRestartHandler calls off a draining restart
+func cancelRestartHandler(c *gin.Context) {
+	if err := cancelRestartDrain(requestOperator(c)); err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Restart called off",
+		"drain":   currentRestartDrain(),
+	})
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Every save of cron.json or a catalog file is kept as a revision in
+// json/history/<file>/, with who made it, so a bad bulk edit can be undone.
+// The first save of a file also keeps what was on disk before it. Revisions
+// are listed with their differences from the one before under /admin/history,
+// and any of them can be rolled back to, which is itself saved as a new
+// revision.
+
+// Revisions kept per file; the oldest are deleted first
+const maxRevisionsPerFile = 100
+
+// Revision is one saved version of a tracked JSON file
+type Revision struct {
+	ID        string          `json:"id"`
+	File      string          `json:"file"` // loadJSON/saveJSON name, e.g. "cron"
+	Author    string          `json:"author"`
+	Note      string          `json:"note,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	Content   json.RawMessage `json:"content"`
+}
+
+var revisionsMutex sync.Mutex
+
+// revisionTracked reports whether saves of a JSON file are kept in the history
+func revisionTracked(name string) bool {
+	if name == "cron" {
+		return true
+	}
+	for _, def := range catalogDefinitions {
+		if def.JSONName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// revisionTrackedFiles lists the files whose saves are kept in the history
+func revisionTrackedFiles() []string {
+	tracked := []string{"cron"}
+	for _, def := range catalogDefinitions {
+		tracked = append(tracked, def.JSONName)
+	}
+	return tracked
+}
+
+// revisionDir is where a file's revisions are kept
+func revisionDir(name string) string {
+	return filepath.Join(app.Config.JSONDir, "history", name)
+}
+
+// loadRevisions reads a file's revisions, oldest first. Caller must hold
+// revisionsMutex.
+func loadRevisions(name string) ([]Revision, error) {
+	entries, err := os.ReadDir(revisionDir(name))
+	if os.IsNotExist(err) {
+		return []Revision{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	revisions := make([]Revision, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(revisionDir(name), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var revision Revision
+		if err := json.Unmarshal(data, &revision); err != nil {
+			log.Printf("Warning: skipping unreadable revision %s/%s: %v", name, entry.Name(), err)
+			continue
+		}
+		revisions = append(revisions, revision)
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].ID < revisions[j].ID
+	})
+	return revisions, nil
+}
+
+// writeRevision stores a revision. Caller must hold revisionsMutex.
+func writeRevision(revision Revision) error {
+	if err := os.MkdirAll(revisionDir(revision.File), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(revision, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(revisionDir(revision.File), revision.ID+".json"), data, 0644)
+}
+
+// newRevisionID sorts in the order revisions were made
+func newRevisionID() string {
+	return fmt.Sprintf("rev_%d", time.Now().UnixNano())
+}
+
+// recordRevision keeps a copy of content about to be written to a tracked
+// file. The first time, the file's current contents are kept too so the
+// first edit can be undone. Saves that change nothing are not recorded.
+func recordRevision(name, filePath string, content []byte, author, note string) error {
+	revisionsMutex.Lock()
+	defer revisionsMutex.Unlock()
+
+	revisions, err := loadRevisions(name)
+	if err != nil {
+		return err
+	}
+	if len(revisions) == 0 {
+		if existing, err := os.ReadFile(filePath); err == nil && json.Valid(existing) {
+			baseline := Revision{ID: newRevisionID(), File: name, Author: "system", Note: "Contents before the first recorded change", CreatedAt: time.Now(), Content: existing}
+			if err := writeRevision(baseline); err != nil {
+				return err
+			}
+			revisions = append(revisions, baseline)
+		}
+	}
+	if len(revisions) > 0 && len(diffJSON(revisions[len(revisions)-1].Content, json.RawMessage(content))) == 0 {
+		return nil
+	}
+
+	revision := Revision{ID: newRevisionID(), File: name, Author: author, Note: note, CreatedAt: time.Now(), Content: content}
+	if err := writeRevision(revision); err != nil {
+		return err
+	}
+	revisions = append(revisions, revision)
+
+	for len(revisions) > maxRevisionsPerFile {
+		os.Remove(filepath.Join(revisionDir(name), revisions[0].ID+".json"))
+		revisions = revisions[1:]
+	}
+	return nil
+}
+
+// JSONDiff is one difference between two versions of a JSON file. Path is a
+// JSON path such as "station_announcements[2].cron".
+type JSONDiff struct {
+	Path   string      `json:"path"`
+	Change string      `json:"change"` // added, removed or changed
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// diffJSON lists the differences between two values as they would be saved
+func diffJSON(before, after interface{}) []JSONDiff {
+	var beforeValue, afterValue interface{}
+	beforeJSON, _ := json.Marshal(before)
+	afterJSON, _ := json.Marshal(after)
+	json.Unmarshal(beforeJSON, &beforeValue)
+	json.Unmarshal(afterJSON, &afterValue)
+
+	diffs := []JSONDiff{}
+	diffJSONValues("", beforeValue, afterValue, &diffs)
+	return diffs
+}
+
+// diffJSONValues compares decoded JSON, descending into objects and arrays
+// so a changed field is reported on its own rather than as a whole entry
+func diffJSONValues(path string, before, after interface{}, diffs *[]JSONDiff) {
+	beforeObject, beforeIsObject := before.(map[string]interface{})
+	afterObject, afterIsObject := after.(map[string]interface{})
+	if beforeIsObject && afterIsObject {
+		keys := make([]string, 0, len(beforeObject)+len(afterObject))
+		for key := range beforeObject {
+			keys = append(keys, key)
+		}
+		for key := range afterObject {
+			if _, ok := beforeObject[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			diffJSONValues(keyPath, beforeObject[key], afterObject[key], diffs)
+		}
+		return
+	}
+
+	beforeArray, beforeIsArray := before.([]interface{})
+	afterArray, afterIsArray := after.([]interface{})
+	if beforeIsArray && afterIsArray {
+		for i := 0; i < len(beforeArray) || i < len(afterArray); i++ {
+			var beforeItem, afterItem interface{}
+			if i < len(beforeArray) {
+				beforeItem = beforeArray[i]
+			}
+			if i < len(afterArray) {
+				afterItem = afterArray[i]
+			}
+			diffJSONValues(fmt.Sprintf("%s[%d]", path, i), beforeItem, afterItem, diffs)
+		}
+		return
+	}
+
+	switch {
+	case reflect.DeepEqual(before, after), isEmptyJSON(before) && isEmptyJSON(after):
+	case before == nil:
+		*diffs = append(*diffs, JSONDiff{Path: path, Change: "added", After: after})
+	case after == nil:
+		*diffs = append(*diffs, JSONDiff{Path: path, Change: "removed", Before: before})
+	default:
+		*diffs = append(*diffs, JSONDiff{Path: path, Change: "changed", Before: before, After: after})
+	}
+}
+
+// isEmptyJSON treats null, [] and {} alike so an omitted list doesn't show up
+// as a change
+func isEmptyJSON(value interface{}) bool {
+	switch typed := value.(type) {
+	case nil:
+		return true
+	case []interface{}:
+		return len(typed) == 0
+	case map[string]interface{}:
+		return len(typed) == 0
+	}
+	return false
+}
+
+// requestOperator names who made a request: the admin user or the API key
+func requestOperator(c *gin.Context) string {
+	if userID := sessionUserID(c); userID != "" {
+		return userID
+	}
+	if keyData, ok := c.Get("api_key_data"); ok {
+		if key, ok := keyData.(*APIKey); ok {
+			return "api:" + key.Name
+		}
+	}
+	return "api"
+}
+
+// revisionSummary describes a revision without its content
+func revisionSummary(revision Revision, previous *Revision) gin.H {
+	summary := gin.H{
+		"id":         revision.ID,
+		"file":       revision.File,
+		"author":     revision.Author,
+		"created_at": revision.CreatedAt,
+	}
+	if revision.Note != "" {
+		summary["note"] = revision.Note
+	}
+	if previous != nil {
+		summary["changes"] = len(diffJSON(previous.Content, revision.Content))
+	}
+	return summary
+}
+
+// Handlers
+
+// adminHistoryHandler lists the tracked files, or with ?file= that file's
+// revisions newest first
+func adminHistoryHandler(c *gin.Context) {
+	revisionsMutex.Lock()
+	defer revisionsMutex.Unlock()
+
+	name := c.Query("file")
+	if name == "" {
+		files := []gin.H{}
+		for _, file := range revisionTrackedFiles() {
+			revisions, err := loadRevisions(file)
+			entry := gin.H{"file": file, "revisions": len(revisions)}
+			if err != nil {
+				entry["error"] = err.Error()
+			} else if len(revisions) > 0 {
+				latest := revisions[len(revisions)-1]
+				entry["latest"] = revisionSummary(latest, nil)
+			}
+			files = append(files, entry)
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true, "files": files})
+		return
+	}
+
+	if !revisionTracked(name) {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "No history is kept for " + name})
+		return
+	}
+	revisions, err := loadRevisions(name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	list := make([]gin.H, 0, len(revisions))
+	for i := len(revisions) - 1; i >= 0; i-- {
+		var previous *Revision
+		if i > 0 {
+			previous = &revisions[i-1]
+		}
+		list = append(list, revisionSummary(revisions[i], previous))
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "file": name, "revisions": list})
+}
+
+// findRevision looks up a revision and the one before it. Caller must hold
+// revisionsMutex.
+func findRevision(name, id string) (*Revision, *Revision, error) {
+	revisions, err := loadRevisions(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range revisions {
+		if revisions[i].ID == id {
+			if i > 0 {
+				return &revisions[i], &revisions[i-1], nil
+			}
+			return &revisions[i], nil, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// adminRevisionHandler returns a revision's content, its differences from the
+// revision before it, and what rolling back to it would change now
+func adminRevisionHandler(c *gin.Context) {
+	name := c.Param("file")
+	if !revisionTracked(name) {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "No history is kept for " + name})
+		return
+	}
+	revisionsMutex.Lock()
+	revision, previous, err := findRevision(name, c.Param("id"))
+	revisionsMutex.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	if revision == nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Revision not found"})
+		return
+	}
+
+	var before json.RawMessage
+	if previous != nil {
+		before = previous.Content
+	}
+	var current json.RawMessage
+	if filePath, ok := jsonFilePath(name); ok {
+		current, _ = os.ReadFile(filePath)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":          true,
+		"revision":         revision,
+		"diff":             diffJSON(before, revision.Content),
+		"rollback_changes": diffJSON(current, revision.Content),
+	})
+}
+
+// adminRollbackHandler restores a file to a revision. Rolling the schedule
+// back is an edit like any other, so an operator's rollback waits for
+// approval when that is required.
+func adminRollbackHandler(c *gin.Context) {
+	name := c.Param("file")
+	if !revisionTracked(name) {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "No history is kept for " + name})
+		return
+	}
+	revisionsMutex.Lock()
+	revision, _, err := findRevision(name, c.Param("id"))
+	revisionsMutex.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	if revision == nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Revision not found"})
+		return
+	}
+	note := fmt.Sprintf("Rolled back to %s", revision.ID)
+
+	if name == "cron" {
+		var cronData CronData
+		if err := json.Unmarshal(revision.Content, &cronData); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Revision is not a valid schedule: " + err.Error()})
+			return
+		}
+		if scheduleNeedsApproval(c) {
+			change, err := submitScheduleChange(cronData, sessionUserID(c), note)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to submit schedule change: " + err.Error()})
+				return
+			}
+			c.JSON(http.StatusAccepted, gin.H{"success": true, "message": "Rollback submitted for approval", "change_id": change.ID})
+			return
+		}
+	}
+
+	if err := saveJSONBy(name, revision.Content, requestOperator(c), note); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to restore revision: " + err.Error()})
+		return
+	}
+	if name == "cron" {
+		updateScheduler()
+	}
+	log.Printf("↩️  %s rolled back to %s by %s", name, revision.ID, requestOperator(c))
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Restored " + name + " to " + revision.ID})
+}
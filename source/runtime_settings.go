@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// json/settings.json holds the values operators tune while the annunciator is
+// running. GET /api/settings returns them and PATCH /api/settings changes any
+// of them, taking effect straight away. Until the file is first saved the
+// values come from the startup config (volume, audio device) and
+// audio_settings.json (gap).
+
+// RuntimeSettings is the content of settings.json
+type RuntimeSettings struct {
+	Volume      float64            `json:"volume"`       // 0.0 to 1.0
+	AudioDevice string             `json:"audio_device"` // Output device ID, "default" for the system default
+	QuietHours  QuietHoursSettings `json:"quiet_hours"`
+	GapMs       int                `json:"gap_ms"`     // Silence between clips; per-type templates in audio_settings.json still override it
+	SpacingMs   int                `json:"spacing_ms"` // Least silence between one announcement and the next; emergencies don't wait
+	Stream      StreamSettings     `json:"stream"`     // Listen Live
+	LogLevel    string             `json:"log_level"`
+	Profiling   bool               `json:"profiling"` // Serve /debug/pprof
+
+	HardwareVolume HardwareVolumeSettings `json:"hardware_volume"` // Set the device mixer instead of scaling samples
+}
+
+// QuietHoursSettings caps the volume overnight. Emergency announcements always
+// play at the full volume.
+type QuietHoursSettings struct {
+	Enabled bool    `json:"enabled"`
+	Start   string  `json:"start"`  // HH:MM, local time
+	End     string  `json:"end"`    // HH:MM; before Start means the quiet hours run past midnight
+	Volume  float64 `json:"volume"` // Most the volume can be while quiet, 0.0 to 1.0
+}
+
+// StreamSettings limits the Listen Live stream
+type StreamSettings struct {
+	MaxListeners  int `json:"max_listeners"`
+	MaxBufferedMs int `json:"max_buffered_ms"` // Audio older than this is dropped if listeners fall behind
+}
+
+// Log levels, least severe first
+var logLevels = []string{"debug", "info", "warning", "error"}
+
+var (
+	runtimeSettings      RuntimeSettings
+	runtimeSettingsMutex sync.RWMutex
+
+	minLogLevel atomic.Int32 // Index into logLevels, read on every log line
+)
+
+// The volume and output device in use are changed by handlers, event scripts
+// and the setup page while playback, the scheduler and the zone routing read
+// them, so they are kept behind a lock rather than in app.Config
+var outputState struct {
+	sync.RWMutex
+	volume float64
+	device string // "" for the system default
+}
+
+// defaultRuntimeSettings returns the settings used before settings.json is saved
+func defaultRuntimeSettings() RuntimeSettings {
+	return RuntimeSettings{
+		Volume:      currentVolume(),
+		AudioDevice: selectedAudioDevice(),
+		QuietHours:  QuietHoursSettings{Start: "22:00", End: "07:00", Volume: 0.3},
+		GapMs:       getAudioSettings().Sequence.GapMs,
+		Stream: StreamSettings{
+			MaxListeners:  liveStreamMaxListeners,
+			MaxBufferedMs: int(liveStreamMaxBuffered / time.Millisecond),
+		},
+		LogLevel:       "debug",
+		HardwareVolume: HardwareVolumeSettings{Method: hardwareVolumeOff},
+	}
+}
+
+func runtimeSettingsPath() string {
+	return filepath.Join(app.Config.JSONDir, "settings.json")
+}
+
+// loadRuntimeSettings reads settings.json and applies it. Missing keys keep
+// their defaults.
+func loadRuntimeSettings() error {
+	settings := defaultRuntimeSettings()
+	data, err := os.ReadFile(runtimeSettingsPath())
+	if err != nil && !os.IsNotExist(err) {
+		setRuntimeSettings(settings)
+		return fmt.Errorf("failed to read settings.json: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &settings); err != nil {
+			setRuntimeSettings(defaultRuntimeSettings())
+			return fmt.Errorf("failed to parse settings.json: %v", err)
+		}
+		if details := settings.validate(); len(details) > 0 {
+			setRuntimeSettings(defaultRuntimeSettings())
+			return fmt.Errorf("invalid settings.json: %s %s", details[0].Field, details[0].Message)
+		}
+		log.Printf("✓ Loaded runtime settings")
+	}
+
+	// Audio is opened later on the configured device, so nothing is re-initialized here
+	setCurrentVolume(settings.Volume)
+	setCurrentAudioDevice(settings.AudioDevice)
+	minLogLevel.Store(int32(logLevelIndex(settings.LogLevel)))
+	applyProfiling(settings.Profiling)
+	setRuntimeSettings(settings)
+	return nil
+}
+
+func setRuntimeSettings(settings RuntimeSettings) {
+	runtimeSettingsMutex.Lock()
+	runtimeSettings = settings
+	runtimeSettingsMutex.Unlock()
+}
+
+// currentRuntimeSettings returns a copy of the settings
+func currentRuntimeSettings() RuntimeSettings {
+	runtimeSettingsMutex.RLock()
+	defer runtimeSettingsMutex.RUnlock()
+	return runtimeSettings
+}
+
+// updateRuntimeSettings changes and saves the settings without applying them
+func updateRuntimeSettings(update func(settings *RuntimeSettings)) error {
+	runtimeSettingsMutex.Lock()
+	defer runtimeSettingsMutex.Unlock()
+	settings := runtimeSettings
+	update(&settings)
+	if err := saveRuntimeSettings(settings); err != nil {
+		return err
+	}
+	runtimeSettings = settings
+	return nil
+}
+
+// persistVolume saves a volume set through the older volume endpoints
+func persistVolume(volume float64) {
+	if err := updateRuntimeSettings(func(settings *RuntimeSettings) { settings.Volume = volume }); err != nil {
+		log.Printf("Warning: volume changed but not saved: %v", err)
+	}
+}
+
+// persistAudioDevice saves a device selected through the older device endpoints
+func persistAudioDevice(device string) {
+	if err := updateRuntimeSettings(func(settings *RuntimeSettings) { settings.AudioDevice = device }); err != nil {
+		log.Printf("Warning: audio device changed but not saved: %v", err)
+	}
+}
+
+// saveRuntimeSettings writes settings.json
+func saveRuntimeSettings(settings RuntimeSettings) error {
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal settings: %v", err)
+	}
+	if err := os.WriteFile(runtimeSettingsPath(), data, 0644); err != nil {
+		return fmt.Errorf("failed to write settings.json: %v", err)
+	}
+	return nil
+}
+
+// parseClockTime parses HH:MM into minutes past midnight
+func parseClockTime(value string) (int, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("must be a time as HH:MM")
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// validate checks every value, returning the problems found
+func (s RuntimeSettings) validate() []FieldError {
+	var details []FieldError
+	if s.Volume < 0 || s.Volume > 1 {
+		details = append(details, FieldError{Field: "volume", Message: "must be between 0.0 and 1.0"})
+	}
+	if strings.TrimSpace(s.AudioDevice) == "" {
+		details = append(details, FieldError{Field: "audio_device", Message: "is required (use \"default\" for the system default)"})
+	}
+	if _, err := parseClockTime(s.QuietHours.Start); err != nil {
+		details = append(details, FieldError{Field: "quiet_hours.start", Message: err.Error()})
+	}
+	if _, err := parseClockTime(s.QuietHours.End); err != nil {
+		details = append(details, FieldError{Field: "quiet_hours.end", Message: err.Error()})
+	}
+	if s.QuietHours.Volume < 0 || s.QuietHours.Volume > 1 {
+		details = append(details, FieldError{Field: "quiet_hours.volume", Message: "must be between 0.0 and 1.0"})
+	}
+	if s.GapMs < 0 || s.GapMs > 5000 {
+		details = append(details, FieldError{Field: "gap_ms", Message: "must be between 0 and 5000"})
+	}
+	if s.SpacingMs < 0 || s.SpacingMs > 600000 {
+		details = append(details, FieldError{Field: "spacing_ms", Message: "must be between 0 and 600000 (10 minutes)"})
+	}
+	if s.Stream.MaxListeners < 1 || s.Stream.MaxListeners > 50 {
+		details = append(details, FieldError{Field: "stream.max_listeners", Message: "must be between 1 and 50"})
+	}
+	if s.Stream.MaxBufferedMs < 100 || s.Stream.MaxBufferedMs > 10000 {
+		details = append(details, FieldError{Field: "stream.max_buffered_ms", Message: "must be between 100 and 10000"})
+	}
+	if !validHardwareVolumeMethod(s.HardwareVolume.Method) {
+		details = append(details, FieldError{Field: "hardware_volume.method", Message: "must be one of " + strings.Join(hardwareVolumeMethods, ", ")})
+	}
+	if logLevelIndex(s.LogLevel) < 0 {
+		details = append(details, FieldError{Field: "log_level", Message: "must be one of " + strings.Join(logLevels, ", ")})
+	}
+	return details
+}
+
+// applyRuntimeSettings puts changed settings into effect. Quiet hours, the
+// gap, the stream limits and hardware volume are read where they are used,
+// so only the volume, device, log level and profiling need applying.
+func applyRuntimeSettings(old, settings RuntimeSettings) {
+	setCurrentVolume(settings.Volume)
+	minLogLevel.Store(int32(logLevelIndex(settings.LogLevel)))
+	applyProfiling(settings.Profiling)
+	if old.HardwareVolume.Method != hardwareVolumeOff && settings.HardwareVolume.Method == hardwareVolumeOff {
+		releaseHardwareVolume(old.HardwareVolume)
+	}
+	resetHardwareVolume()
+
+	if settings.AudioDevice != old.AudioDevice {
+		if err := setAudioDevice(settings.AudioDevice); err != nil {
+			log.Printf("Warning: failed to set audio device %s: %v", settings.AudioDevice, err)
+		} else if err := reinitAudioForDevice(settings.AudioDevice); err != nil {
+			log.Printf("Warning: audio backend re-init after device change failed: %v", err)
+		}
+		setCurrentAudioDevice(settings.AudioDevice)
+		clearAudioFallback()
+	}
+}
+
+// Accessors
+
+// currentVolume returns the volume set by the operator, 0.0 to 1.0, before
+// quiet hours
+func currentVolume() float64 {
+	outputState.RLock()
+	defer outputState.RUnlock()
+	return outputState.volume
+}
+
+// setCurrentVolume changes the volume in use; persistVolume saves it
+func setCurrentVolume(volume float64) {
+	outputState.Lock()
+	outputState.volume = volume
+	outputState.Unlock()
+}
+
+// currentAudioDevice returns the selected output device ID as configured,
+// "" or "default" for the system default
+func currentAudioDevice() string {
+	outputState.RLock()
+	defer outputState.RUnlock()
+	return outputState.device
+}
+
+// setCurrentAudioDevice records the selected output device. It doesn't switch
+// the output; callers do that first.
+func setCurrentAudioDevice(deviceID string) {
+	outputState.Lock()
+	outputState.device = deviceID
+	outputState.Unlock()
+}
+
+// active reports whether the quiet hours cover t
+func (q QuietHoursSettings) active(t time.Time) bool {
+	if !q.Enabled {
+		return false
+	}
+	start, err := parseClockTime(q.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClockTime(q.End)
+	if err != nil {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	if start <= end {
+		return now >= start && now < end
+	}
+	return now >= start || now < end
+}
+
+// emergencyPlaying reports whether the announcement playing is an emergency
+func emergencyPlaying() bool {
+	if announcementManager == nil {
+		return false
+	}
+	announcementManager.mutex.RLock()
+	defer announcementManager.mutex.RUnlock()
+	return announcementManager.playing != nil && announcementManager.playing.Priority >= PriorityEmergency
+}
+
+// playbackVolume returns the volume to play at now, capped during quiet hours
+// unless an emergency is playing
+func playbackVolume() float64 {
+	volume := currentVolume()
+	quiet := currentRuntimeSettings().QuietHours
+	if volume > quiet.Volume && quiet.active(time.Now()) && !emergencyPlaying() {
+		return quiet.Volume
+	}
+	return volume
+}
+
+// liveStreamLimits returns the Listen Live listener and buffer limits
+func liveStreamLimits() (int, time.Duration) {
+	stream := currentRuntimeSettings().Stream
+	return stream.MaxListeners, time.Duration(stream.MaxBufferedMs) * time.Millisecond
+}
+
+// logLevelIndex returns a level's position in logLevels, or -1 if unknown
+func logLevelIndex(level string) int {
+	for i, known := range logLevels {
+		if known == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// logLevelFilter drops log lines below the configured level before they reach
+// the console, the log file or a log sink
+type logLevelFilter struct {
+	next io.Writer
+}
+
+func (f logLevelFilter) Write(p []byte) (int, error) {
+	message := stripLogTimestamp(strings.TrimRight(string(p), "\n"))
+	if int32(logLevelIndex(logLevel(message))) < minLogLevel.Load() {
+		return len(p), nil
+	}
+	return f.next.Write(p)
+}
+
+// installLogLevelFilter puts the level filter in front of the log output.
+// Called once the log file and sink are set up.
+func installLogLevelFilter() {
+	output := io.Writer(os.Stdout)
+	if logWriter != nil {
+		output = logWriter
+	}
+	log.SetOutput(logLevelFilter{next: output})
+}
+
+// Handlers
+
+// apiGetSettingsHandler returns the runtime settings
+func apiGetSettingsHandler(c *gin.Context) {
+	respondOK(c, gin.H{"settings": currentRuntimeSettings()})
+}
+
+// apiPatchSettingsHandler changes the settings named in the JSON body, leaving
+// the rest as they are, and applies them
+func apiPatchSettingsHandler(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Failed to read request body")
+		return
+	}
+
+	runtimeSettingsMutex.Lock()
+	defer runtimeSettingsMutex.Unlock()
+	old := runtimeSettings
+	settings := old
+	// Decoding merges into maps, which must not be the live settings' maps
+	settings.HardwareVolume.Controls = make(map[string]string)
+	for device, control := range old.HardwareVolume.Controls {
+		settings.HardwareVolume.Controls[device] = control
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&settings); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid settings JSON: "+err.Error())
+		return
+	}
+
+	details := settings.validate()
+	if settings.AudioDevice != old.AudioDevice && settings.AudioDevice != "default" {
+		found := false
+		for _, device := range getAudioDevices() {
+			if device.ID == settings.AudioDevice {
+				found = true
+				break
+			}
+		}
+		if !found {
+			details = append(details, FieldError{Field: "audio_device", Message: "is not a connected device (see /api/audio/devices)"})
+		}
+	}
+	if len(details) > 0 {
+		respondValidationError(c, "Invalid settings", details...)
+		return
+	}
+
+	if err := saveRuntimeSettings(settings); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	applyRuntimeSettings(old, settings)
+	runtimeSettings = settings
+	log.Printf("⚙️  Runtime settings updated by %s", requestOperator(c))
+
+	respondSuccess(c, http.StatusOK, "Settings updated", gin.H{"settings": settings})
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Safety languages are managed through the API rather than by editing
+// safety.json and naming files safety_<id>.mp3 by hand. Each language's
+// recording can be uploaded with it; the upload is checked, stored in
+// mp3/safety and recorded as the language's file. The rotation is the order
+// the languages play in, with a pause between them, when a schedule entry
+// doesn't list its own. It is kept in safety.json beside the languages:
+//
+//	{"safety": [...], "rotation": {"languages": ["english", "spanish"], "delay_seconds": 2}}
+//
+// Without a rotation every language plays, in catalog order.
+
+// Pause between languages when neither the rotation nor the entry sets one
+const defaultSafetyDelaySeconds = 2
+
+// SafetyRotation is the default order of safety languages
+type SafetyRotation struct {
+	Languages    []string `json:"languages"`
+	DelaySeconds int      `json:"delay_seconds"`
+}
+
+// loadSafetyRotation reads the rotation from safety.json
+func loadSafetyRotation() SafetyRotation {
+	rotation := SafetyRotation{Languages: []string{}, DelaySeconds: defaultSafetyDelaySeconds}
+	filePath, _ := jsonFilePath("safety")
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return rotation
+	}
+	var wrapper struct {
+		Rotation *SafetyRotation `json:"rotation"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err != nil || wrapper.Rotation == nil {
+		return rotation
+	}
+	if wrapper.Rotation.Languages != nil {
+		rotation.Languages = wrapper.Rotation.Languages
+	}
+	rotation.DelaySeconds = wrapper.Rotation.DelaySeconds
+	return rotation
+}
+
+// saveSafetyRotation writes the rotation into safety.json, keeping the
+// languages. Caller must hold catalogMutex.
+func saveSafetyRotation(rotation SafetyRotation, author string) error {
+	wrapper := make(map[string]interface{})
+	filePath, _ := jsonFilePath("safety")
+	if data, err := os.ReadFile(filePath); err == nil {
+		var existing map[string]json.RawMessage
+		if err := json.Unmarshal(data, &existing); err != nil {
+			return fmt.Errorf("failed to parse safety.json: %v", err)
+		}
+		for key, value := range existing {
+			wrapper[key] = value
+		}
+	}
+	if _, ok := wrapper["safety"]; !ok {
+		wrapper["safety"] = []CatalogItem{}
+	}
+	wrapper["rotation"] = rotation
+	return saveJSONBy("safety", wrapper, author, "safety rotation")
+}
+
+// safetyRotationLanguages is the rotation's languages that still exist, or
+// every language when no rotation is set
+func safetyRotationLanguages(rotation SafetyRotation) []string {
+	def, _ := findCatalogDefinition("safety")
+	items, _ := loadCatalog(def)
+	known := make(map[string]bool, len(items))
+	all := make([]string, 0, len(items))
+	for _, item := range items {
+		known[item.ID] = true
+		all = append(all, item.ID)
+	}
+	if len(rotation.Languages) == 0 {
+		return all
+	}
+	languages := make([]string, 0, len(rotation.Languages))
+	for _, language := range rotation.Languages {
+		if known[language] {
+			languages = append(languages, language)
+		}
+	}
+	return languages
+}
+
+// safetyLanguageSchedules lists the schedule entries that name a language
+func safetyLanguageSchedules(id string) []string {
+	cronData := loadJSON("cron", CronData{}).(CronData)
+	var entries []string
+	for i, item := range cronData.SafetyAnnouncements {
+		if item.Language == id || containsString(item.Languages, id) {
+			entries = append(entries, fmt.Sprintf("safety_announcements[%d]", i))
+		}
+	}
+	return entries
+}
+
+// bindSafetyLanguage reads a language from a JSON body or a multipart form,
+// which may carry its recording in "audio". It writes the error response
+// itself and returns false on a malformed request.
+func bindSafetyLanguage(c *gin.Context) (CatalogItem, *multipart.FileHeader, bool) {
+	var item CatalogItem
+	if !strings.HasPrefix(c.ContentType(), "multipart/") {
+		if err := c.ShouldBindJSON(&item); err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+			return item, nil, false
+		}
+		return item, nil, true
+	}
+
+	item.ID = c.PostForm("id")
+	item.Name = c.PostForm("name")
+	item.Caption = c.PostForm("caption")
+	upload, err := c.FormFile("audio")
+	if err == http.ErrMissingFile {
+		return item, nil, true
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			respondError(c, http.StatusRequestEntityTooLarge, ErrCodeBadRequest, fmt.Sprintf("Recording is larger than %d MB", maxAdhocUploadBytes>>20))
+		} else {
+			respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid upload: "+err.Error())
+		}
+		return item, nil, false
+	}
+	return item, upload, true
+}
+
+// storeSafetyAudio stores a recording uploaded in a form; see storeSafetyAudioFrom
+func storeSafetyAudio(c *gin.Context, def catalogDefinition, item CatalogItem, upload *multipart.FileHeader) (string, bool) {
+	source, err := upload.Open()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Failed to read recording: "+err.Error())
+		return "", false
+	}
+	defer source.Close()
+	return storeSafetyAudioFrom(c, def, item, upload.Filename, source)
+}
+
+// storeSafetyAudioFrom transcodes and checks a recording and moves it into
+// place as the language's file, safety_<id>.mp3 or .wav. Another file the
+// language used before is removed. It writes the error response itself.
+func storeSafetyAudioFrom(c *gin.Context, def catalogDefinition, item CatalogItem, filename string, source io.Reader) (string, bool) {
+	extension := strings.ToLower(filepath.Ext(filename))
+	if message := checkUploadExtension(extension); message != "" {
+		respondValidationError(c, "Invalid safety recording", FieldError{Field: "audio", Message: message})
+		return "", false
+	}
+	dir := storedAudioPath(def.AudioDir)
+	if err := storageWritable(dir, "storing the recording"); err != nil {
+		respondError(c, http.StatusInsufficientStorage, ErrCodeUnavailable, err.Error())
+		return "", false
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to store recording: "+err.Error())
+		return "", false
+	}
+
+	// The temporary file keeps its extension so the check decodes it right
+	stored, err := os.CreateTemp(dir, ".upload_*"+extension)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to store recording: "+err.Error())
+		return "", false
+	}
+	temporary := stored.Name()
+	_, err = io.Copy(stored, source)
+	stored.Close()
+	if err != nil {
+		os.Remove(temporary)
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to store recording: "+err.Error())
+		return "", false
+	}
+	transcoded, err := transcodeUpload(temporary)
+	if err != nil {
+		os.Remove(temporary)
+		respondValidationError(c, "Invalid safety recording", FieldError{Field: "audio", Message: err.Error()})
+		return "", false
+	}
+	temporary = transcoded
+	if _, err := checkAdhocAudio(temporary); err != nil {
+		os.Remove(temporary)
+		respondValidationError(c, "Invalid safety recording", FieldError{Field: "audio", Message: err.Error()})
+		return "", false
+	}
+
+	// Renamed into place, so the language never plays a half-written file
+	file := def.FilePrefix + item.ID + strings.ToLower(filepath.Ext(temporary))
+	if err := os.Rename(temporary, filepath.Join(dir, file)); err != nil {
+		os.Remove(temporary)
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to store recording: "+err.Error())
+		return "", false
+	}
+	if previous := storedAudioPath(def.audioFile(item)); previous != filepath.Join(dir, file) && fileExists(previous) {
+		if err := os.Remove(previous); err != nil {
+			log.Printf("Warning: could not remove old safety recording %s: %v", previous, err)
+		}
+	}
+	return file, true
+}
+
+// Handlers
+
+// apiListSafetyLanguagesHandler lists the languages with their audio and the
+// rotation
+func apiListSafetyLanguagesHandler(c *gin.Context) {
+	def, _ := findCatalogDefinition("safety")
+	items, err := loadCatalog(def)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	rotation := loadSafetyRotation()
+	playing := safetyRotationLanguages(rotation)
+
+	dictionary := currentPronunciations()
+	views := make([]gin.H, 0, len(items))
+	for _, item := range items {
+		view := catalogItemView(def, item, dictionary)
+		view["in_rotation"] = containsString(playing, item.ID)
+		views = append(views, view)
+	}
+	respondOK(c, gin.H{
+		"languages": views,
+		"count":     len(views),
+		"rotation": gin.H{
+			"languages":     rotation.Languages,
+			"delay_seconds": rotation.DelaySeconds,
+			"effective":     playing,
+		},
+	})
+}
+
+// apiCreateSafetyLanguageHandler adds a language, with its recording when the
+// request is a multipart upload
+func apiCreateSafetyLanguageHandler(c *gin.Context) {
+	item, upload, ok := bindSafetyLanguage(c)
+	if !ok {
+		return
+	}
+	def, _ := findCatalogDefinition("safety")
+	allowMissing := upload != nil || c.Query("allow_missing_audio") == "true"
+	details := validateCatalogItem(def, item, allowMissing)
+	// These would be hidden by /safety/languages and /safety/rotation
+	if item.ID == "languages" || item.ID == "rotation" {
+		details = append(details, FieldError{Field: "id", Message: "'" + item.ID + "' is reserved"})
+	}
+	if len(details) > 0 {
+		respondValidationError(c, "Invalid safety language", details...)
+		return
+	}
+
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+	items, err := loadCatalog(def)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	for _, existing := range items {
+		if existing.ID == item.ID {
+			respondError(c, http.StatusConflict, ErrCodeConflict, fmt.Sprintf("Safety language '%s' already exists", item.ID))
+			return
+		}
+	}
+	if upload != nil {
+		file, ok := storeSafetyAudio(c, def, item, upload)
+		if !ok {
+			return
+		}
+		item.File = file
+	}
+
+	items = append(items, item)
+	if err := saveCatalog(def, items, requestOperator(c)); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save safety languages: "+err.Error())
+		return
+	}
+	log.Printf("Safety language '%s' added by %s", item.ID, requestOperator(c))
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+item.ID)
+	respondSuccess(c, http.StatusCreated, "Safety language created", gin.H{"language": catalogItemView(def, item, currentPronunciations())})
+}
+
+// apiUpdateSafetyLanguageHandler replaces a language's name and caption, and
+// its recording when the request is a multipart upload
+func apiUpdateSafetyLanguageHandler(c *gin.Context) {
+	id := c.Param("id")
+	item, upload, ok := bindSafetyLanguage(c)
+	if !ok {
+		return
+	}
+	if item.ID == "" {
+		item.ID = id
+	}
+	if item.ID != id {
+		respondValidationError(c, "ID cannot be changed", FieldError{Field: "id", Message: "must match the ID in the URL"})
+		return
+	}
+	def, _ := findCatalogDefinition("safety")
+
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+	items, err := loadCatalog(def)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	index := -1
+	for i := range items {
+		if items[i].ID == id {
+			index = i
+		}
+	}
+	if index < 0 {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("Safety language '%s' not found", id))
+		return
+	}
+	// The file only changes with an upload (or explicitly in JSON)
+	if item.File == "" {
+		item.File = items[index].File
+	}
+	allowMissing := upload != nil || c.Query("allow_missing_audio") == "true"
+	if details := validateCatalogItem(def, item, allowMissing); len(details) > 0 {
+		respondValidationError(c, "Invalid safety language", details...)
+		return
+	}
+	if upload != nil {
+		file, ok := storeSafetyAudio(c, def, items[index], upload)
+		if !ok {
+			return
+		}
+		item.File = file
+	}
+
+	items[index] = item
+	if err := saveCatalog(def, items, requestOperator(c)); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save safety languages: "+err.Error())
+		return
+	}
+	log.Printf("Safety language '%s' updated by %s", id, requestOperator(c))
+	respondSuccess(c, http.StatusOK, "Safety language updated", gin.H{"language": catalogItemView(def, item, currentPronunciations())})
+}
+
+// apiUploadSafetyAudioHandler replaces a language's recording, sent in a
+// form as "audio" or finished as a chunked upload: {"upload_id": "..."}
+func apiUploadSafetyAudioHandler(c *gin.Context) {
+	id := c.Param("id")
+	def, _ := findCatalogDefinition("safety")
+	var store func(item CatalogItem) (string, bool)
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		upload, err := c.FormFile("audio")
+		if err != nil {
+			if strings.Contains(err.Error(), "request body too large") {
+				respondError(c, http.StatusRequestEntityTooLarge, ErrCodeBadRequest, fmt.Sprintf("Recording is larger than %d MB", maxAdhocUploadBytes>>20))
+				return
+			}
+			respondValidationError(c, "Invalid safety recording", FieldError{Field: "audio", Message: "is required (multipart/form-data upload)"})
+			return
+		}
+		store = func(item CatalogItem) (string, bool) {
+			return storeSafetyAudio(c, def, item, upload)
+		}
+	} else {
+		var request struct {
+			UploadID string `json:"upload_id"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil || request.UploadID == "" {
+			respondValidationError(c, "Invalid safety recording", FieldError{Field: "audio", Message: "is required (multipart/form-data upload, or upload_id of a chunked upload)"})
+			return
+		}
+		session, path, release, err := claimUpload(request.UploadID, uploadSafetyAudio)
+		if err != nil {
+			respondValidationError(c, "Invalid safety recording", FieldError{Field: "upload_id", Message: err.Error()})
+			return
+		}
+		store = func(item CatalogItem) (string, bool) {
+			source, err := os.Open(path)
+			if err != nil {
+				respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to read upload: "+err.Error())
+				return "", false
+			}
+			defer source.Close()
+			file, ok := storeSafetyAudioFrom(c, def, item, session.Filename, source)
+			if ok {
+				release()
+			}
+			return file, ok
+		}
+	}
+
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+	items, err := loadCatalog(def)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	for i := range items {
+		if items[i].ID != id {
+			continue
+		}
+		file, ok := store(items[i])
+		if !ok {
+			return
+		}
+		items[i].File = file
+		if err := saveCatalog(def, items, requestOperator(c)); err != nil {
+			respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save safety languages: "+err.Error())
+			return
+		}
+		log.Printf("Safety recording for '%s' uploaded by %s", id, requestOperator(c))
+		respondSuccess(c, http.StatusOK, "Safety recording stored", gin.H{"language": catalogItemView(def, items[i], currentPronunciations())})
+		return
+	}
+	respondError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("Safety language '%s' not found", id))
+}
+
+// apiDeleteSafetyLanguageHandler removes a language and takes it out of the
+// rotation. It refuses while a schedule entry names it. ?delete_audio=true
+// also deletes the recording.
+func apiDeleteSafetyLanguageHandler(c *gin.Context) {
+	id := c.Param("id")
+	if entries := safetyLanguageSchedules(id); len(entries) > 0 {
+		respondError(c, http.StatusConflict, ErrCodeConflict,
+			fmt.Sprintf("Safety language '%s' is used by %s; remove it from the schedule first", id, strings.Join(entries, ", ")))
+		return
+	}
+	def, _ := findCatalogDefinition("safety")
+
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+	items, err := loadCatalog(def)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	remaining := make([]CatalogItem, 0, len(items))
+	var removed *CatalogItem
+	for i := range items {
+		if items[i].ID == id {
+			removed = &items[i]
+		} else {
+			remaining = append(remaining, items[i])
+		}
+	}
+	if removed == nil {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("Safety language '%s' not found", id))
+		return
+	}
+	if err := saveCatalog(def, remaining, requestOperator(c)); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save safety languages: "+err.Error())
+		return
+	}
+
+	rotation := loadSafetyRotation()
+	if containsString(rotation.Languages, id) {
+		kept := make([]string, 0, len(rotation.Languages))
+		for _, language := range rotation.Languages {
+			if language != id {
+				kept = append(kept, language)
+			}
+		}
+		rotation.Languages = kept
+		if err := saveSafetyRotation(rotation, requestOperator(c)); err != nil {
+			log.Printf("Warning: safety language '%s' deleted but still in the rotation: %v", id, err)
+		}
+	}
+	if c.Query("delete_audio") == "true" {
+		if err := os.Remove(storedAudioPath(def.audioFile(*removed))); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: could not delete safety recording for '%s': %v", id, err)
+		}
+	}
+	log.Printf("Safety language '%s' deleted by %s", id, requestOperator(c))
+	respondSuccess(c, http.StatusOK, "Safety language deleted", gin.H{"id": id})
+}
+
+// apiGetSafetyRotationHandler returns the rotation and the languages it plays
+func apiGetSafetyRotationHandler(c *gin.Context) {
+	rotation := loadSafetyRotation()
+	respondOK(c, gin.H{
+		"languages":     rotation.Languages,
+		"delay_seconds": rotation.DelaySeconds,
+		"effective":     safetyRotationLanguages(rotation),
+	})
+}
+
+// apiPutSafetyRotationHandler replaces the rotation. An empty list plays every
+// language in catalog order.
+func apiPutSafetyRotationHandler(c *gin.Context) {
+	var rotation SafetyRotation
+	if err := c.ShouldBindJSON(&rotation); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	if rotation.Languages == nil {
+		rotation.Languages = []string{}
+	}
+
+	def, _ := findCatalogDefinition("safety")
+	known := catalogIDs([]string{def.Name})
+	var details []FieldError
+	seen := make(map[string]bool)
+	for i, language := range rotation.Languages {
+		field := fmt.Sprintf("languages[%d]", i)
+		if !known[language] {
+			details = append(details, FieldError{Field: field, Message: unknownIDMessage(language, known)})
+		} else if seen[language] {
+			details = append(details, FieldError{Field: field, Message: "is listed twice"})
+		}
+		seen[language] = true
+	}
+	if rotation.DelaySeconds < 0 || rotation.DelaySeconds > 600 {
+		details = append(details, FieldError{Field: "delay_seconds", Message: "must be between 0 and 600"})
+	}
+	if len(details) > 0 {
+		respondValidationError(c, "Invalid safety rotation", details...)
+		return
+	}
+
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+	if err := saveSafetyRotation(rotation, requestOperator(c)); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save safety rotation: "+err.Error())
+		return
+	}
+	log.Printf("Safety rotation set to %v (%ds apart) by %s", rotation.Languages, rotation.DelaySeconds, requestOperator(c))
+	respondSuccess(c, http.StatusOK, "Safety rotation saved", gin.H{
+		"languages":     rotation.Languages,
+		"delay_seconds": rotation.DelaySeconds,
+		"effective":     safetyRotationLanguages(rotation),
+	})
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// With security.require_schedule_approval set in admin_config.json, schedule
+// edits by a user with the "operator" role are not applied straight away.
+// They are kept in schedule_changes.json until a user with the "admin" role,
+// other than the one who made the edit, approves or rejects them. Edits by
+// admins and API keys apply immediately as before.
+
+// Schedule change states
+const (
+	ScheduleChangePending  = "pending"
+	ScheduleChangeApproved = "approved"
+	ScheduleChangeRejected = "rejected"
+)
+
+// Resolved changes kept for reference
+const maxResolvedScheduleChanges = 50
+
+// ScheduleChange is an edit to cron.json waiting for (or past) review
+type ScheduleChange struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	SubmittedBy string     `json:"submitted_by"`
+	SubmittedAt time.Time  `json:"submitted_at"`
+	Comment     string     `json:"comment,omitempty"`
+	Base        CronData   `json:"base"`     // Schedule the edit was made against
+	Schedule    CronData   `json:"schedule"` // Proposed schedule
+	ReviewedBy  string     `json:"reviewed_by,omitempty"`
+	ReviewedAt  *time.Time `json:"reviewed_at,omitempty"`
+	ReviewNote  string     `json:"review_note,omitempty"`
+}
+
+var scheduleChangesMutex sync.Mutex
+
+// loadScheduleChangesLocked reads schedule_changes.json; the caller holds the mutex
+func loadScheduleChangesLocked() ([]ScheduleChange, error) {
+	filePath, _ := jsonFilePath("schedule_changes")
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return []ScheduleChange{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule_changes.json: %v", err)
+	}
+	var changes []ScheduleChange
+	if err := json.Unmarshal(data, &changes); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule_changes.json: %v", err)
+	}
+	return changes, nil
+}
+
+// saveScheduleChangesLocked writes the changes, dropping the oldest resolved
+// ones past the limit; the caller holds the mutex
+func saveScheduleChangesLocked(changes []ScheduleChange) error {
+	resolved := 0
+	for i := len(changes) - 1; i >= 0; i-- {
+		if changes[i].Status == ScheduleChangePending {
+			continue
+		}
+		resolved++
+		if resolved > maxResolvedScheduleChanges {
+			changes = append(changes[:i], changes[i+1:]...)
+		}
+	}
+	return saveJSON("schedule_changes", changes)
+}
+
+// scheduleApprovalRequired reports whether schedule edits must be approved
+func scheduleApprovalRequired() bool {
+	adminConfig, err := loadAdminConfig(adminConfigPath())
+	return err == nil && adminConfig.Security.RequireScheduleApproval
+}
+
+// sessionRole returns the logged-in user's role, or "" for API key callers.
+// Directory and SSO logins carry their role in the session; local accounts
+// are looked up.
+func sessionRole(c *gin.Context) string {
+	session := sessions.Default(c)
+	if loggedIn, ok := session.Get("admin_logged_in").(bool); !ok || !loggedIn {
+		return ""
+	}
+	if role, ok := session.Get("admin_role").(string); ok && role != "" {
+		return role
+	}
+	userID, _ := session.Get("admin_user_id").(string)
+	if user := findAdminUser(userID); user != nil {
+		return user.Role
+	}
+	return "admin" // Single-user fallback login
+}
+
+// sessionUserID returns the logged-in user's ID, or "" for API key callers
+func sessionUserID(c *gin.Context) string {
+	userID, _ := sessions.Default(c).Get("admin_user_id").(string)
+	return userID
+}
+
+// scheduleNeedsApproval reports whether this caller's schedule edit has to
+// wait for an admin
+func scheduleNeedsApproval(c *gin.Context) bool {
+	return sessionRole(c) == "operator" && scheduleApprovalRequired()
+}
+
+// submitScheduleChange records a proposed schedule for review
+func submitScheduleChange(schedule CronData, submittedBy, comment string) (ScheduleChange, error) {
+	scheduleChangesMutex.Lock()
+	defer scheduleChangesMutex.Unlock()
+
+	changes, err := loadScheduleChangesLocked()
+	if err != nil {
+		return ScheduleChange{}, err
+	}
+	change := ScheduleChange{
+		ID:          fmt.Sprintf("sched_%d", time.Now().UnixNano()),
+		Status:      ScheduleChangePending,
+		SubmittedBy: submittedBy,
+		SubmittedAt: time.Now(),
+		Comment:     comment,
+		Base:        loadJSON("cron", CronData{}).(CronData),
+		Schedule:    schedule,
+	}
+	changes = append(changes, change)
+	if err := saveScheduleChangesLocked(changes); err != nil {
+		return ScheduleChange{}, err
+	}
+	log.Printf("📝 Schedule change %s submitted by %s for approval", change.ID, submittedBy)
+	return change, nil
+}
+
+// scheduleChangeView adds the diff data the review screen needs: the
+// differences from the live schedule, and whether the live schedule has moved
+// on since the change was submitted
+func scheduleChangeView(change ScheduleChange) gin.H {
+	view := gin.H{"change": change}
+	if change.Status != ScheduleChangePending {
+		view["diff"] = diffJSON(change.Base, change.Schedule)
+		return view
+	}
+	current := loadJSON("cron", CronData{}).(CronData)
+	baseChanges := diffJSON(change.Base, current)
+	view["diff"] = diffJSON(current, change.Schedule)
+	view["stale"] = len(baseChanges) > 0
+	if len(baseChanges) > 0 {
+		view["changed_since_submitted"] = baseChanges
+	}
+	return view
+}
+
+// Handlers
+
+// apiListScheduleChangesHandler lists schedule changes, newest first.
+// ?status= filters by pending, approved or rejected.
+func apiListScheduleChangesHandler(c *gin.Context) {
+	scheduleChangesMutex.Lock()
+	changes, err := loadScheduleChangesLocked()
+	scheduleChangesMutex.Unlock()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	status := c.Query("status")
+	list := make([]gin.H, 0, len(changes))
+	for i := len(changes) - 1; i >= 0; i-- {
+		if status == "" || changes[i].Status == status {
+			list = append(list, scheduleChangeView(changes[i]))
+		}
+	}
+	respondOK(c, gin.H{
+		"approval_required": scheduleApprovalRequired(),
+		"changes":           list,
+		"count":             len(list),
+	})
+}
+
+// apiGetScheduleChangeHandler returns one change with its diff
+func apiGetScheduleChangeHandler(c *gin.Context) {
+	scheduleChangesMutex.Lock()
+	changes, err := loadScheduleChangesLocked()
+	scheduleChangesMutex.Unlock()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	for _, change := range changes {
+		if change.ID == c.Param("id") {
+			respondOK(c, scheduleChangeView(change))
+			return
+		}
+	}
+	respondError(c, http.StatusNotFound, ErrCodeNotFound, "Schedule change not found")
+}
+
+// reviewScheduleChange approves or rejects a pending change. Only a logged-in
+// admin other than the submitter may approve; the submitter may also
+// withdraw their own change by rejecting it.
+func reviewScheduleChange(c *gin.Context, approve bool) {
+	reviewer := sessionUserID(c)
+	role := sessionRole(c)
+
+	data, ok := bindRequestData(c, "note")
+	if !ok {
+		return
+	}
+	note, _ := data["note"].(string)
+
+	scheduleChangesMutex.Lock()
+	defer scheduleChangesMutex.Unlock()
+
+	changes, err := loadScheduleChangesLocked()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	index := -1
+	for i, change := range changes {
+		if change.ID == c.Param("id") {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Schedule change not found")
+		return
+	}
+	change := &changes[index]
+	if change.Status != ScheduleChangePending {
+		respondError(c, http.StatusConflict, ErrCodeConflict, "Schedule change was already "+change.Status)
+		return
+	}
+
+	withdrawing := !approve && reviewer != "" && reviewer == change.SubmittedBy
+	if !withdrawing {
+		if role != "admin" {
+			respondError(c, http.StatusForbidden, ErrCodeForbidden, "Schedule changes must be reviewed by a logged-in admin")
+			return
+		}
+		if reviewer == change.SubmittedBy {
+			respondError(c, http.StatusForbidden, ErrCodeForbidden, "Schedule changes must be approved by someone other than the submitter")
+			return
+		}
+	}
+
+	if approve {
+		current := loadJSON("cron", CronData{}).(CronData)
+		if len(diffJSON(change.Base, current)) > 0 {
+			respondError(c, http.StatusConflict, ErrCodeConflict, "The schedule has changed since this change was submitted; reject it and submit it again")
+			return
+		}
+		if err := saveJSONBy("cron", change.Schedule, change.SubmittedBy, "Approved by "+reviewer); err != nil {
+			respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to update schedule: "+err.Error())
+			return
+		}
+		updateScheduler()
+		change.Status = ScheduleChangeApproved
+	} else {
+		change.Status = ScheduleChangeRejected
+	}
+	now := time.Now()
+	change.ReviewedBy = reviewer
+	change.ReviewedAt = &now
+	change.ReviewNote = note
+	reviewed := *change
+
+	if err := saveScheduleChangesLocked(changes); err != nil {
+		log.Printf("Error saving schedule changes: %v", err)
+	}
+	log.Printf("📝 Schedule change %s %s by %s", reviewed.ID, reviewed.Status, reviewer)
+
+	message := "Schedule change rejected"
+	if approve {
+		message = "Schedule change approved and applied"
+	}
+	respondSuccess(c, http.StatusOK, message, gin.H{"change": reviewed})
+}
+
+func apiApproveScheduleChangeHandler(c *gin.Context) {
+	reviewScheduleChange(c, true)
+}
+
+func apiRejectScheduleChangeHandler(c *gin.Context) {
+	reviewScheduleChange(c, false)
+}
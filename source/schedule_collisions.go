@@ -0,0 +1,254 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Entries that fire in the same minute, or while another one is still
+// playing, queue behind each other, and the later one starts late every time
+// they meet. The collision planner plays a week of the standing schedule
+// through the schedule preview and reports each pair of entries that
+// collides more than once. Saving the schedule returns the collisions as
+// warnings. With "auto_offset" on in the "collisions" section of cron.json,
+// low-priority promo and weather entries are first moved a minute or more
+// later until they no longer collide.
+
+// CollisionSettings is the "collisions" section of cron.json
+type CollisionSettings struct {
+	AutoOffset       bool `json:"auto_offset"`                  // Move low-priority entries off collisions on save
+	MaxOffsetMinutes int  `json:"max_offset_minutes,omitempty"` // Furthest an entry is moved; default 10
+}
+
+const (
+	collisionPlanWindow = 7 * 24 * time.Hour
+	// A pair colliding fewer times than this in the planned week is chance,
+	// not the schedule
+	minCollisionRepeats       = 2
+	defaultMaxCollisionOffset = 10
+	maxCollisionOffset        = 30
+)
+
+// ScheduleCollision is a pair of entries that keeps colliding
+type ScheduleCollision struct {
+	Entry          string    `json:"entry"`       // The entry that waits
+	Behind         string    `json:"behind"`      // The entry it waits behind
+	Occurrences    int       `json:"occurrences"` // Times in the planned week
+	First          time.Time `json:"first"`
+	MaxWaitSeconds float64   `json:"max_wait_seconds"`
+
+	entry, behind *schedulePreviewItem
+}
+
+// ScheduleOffset is an entry moved off a collision
+type ScheduleOffset struct {
+	Entry   string `json:"entry"`
+	From    string `json:"from"` // Cron expression before the move
+	To      string `json:"to"`
+	Minutes int    `json:"minutes"`
+}
+
+// validate checks the offset limit
+func (s CollisionSettings) validate() []FieldError {
+	if s.MaxOffsetMinutes < 0 || s.MaxOffsetMinutes > maxCollisionOffset {
+		return []FieldError{{Field: "schedule.collisions.max_offset_minutes", Message: "must be between 0 and " + strconv.Itoa(maxCollisionOffset) + " minutes"}}
+	}
+	return nil
+}
+
+// collisionLabel names the entry an announcement comes from; countdowns are
+// told apart from the station entry they count down to
+func collisionLabel(item *schedulePreviewItem) string {
+	if item.Type == TypeCountdown {
+		return item.Entry + " countdown"
+	}
+	return item.Entry
+}
+
+// planScheduleCollisions plays a week of the schedule from tomorrow and
+// returns the pairs of entries colliding at least minCollisionRepeats times,
+// the most frequent first. lengths keeps announcement lengths between plans.
+func planScheduleCollisions(cronData CronData, lengths map[string]previewLength) []ScheduleCollision {
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	preview := &schedulePreview{
+		from:     from,
+		to:       from.Add(collisionPlanWindow),
+		profiles: loadScheduleProfiles(),
+		lengths:  lengths,
+		standing: true,
+	}
+	preview.addEntries(cronData)
+	preview.play()
+
+	pairs := make(map[string]*ScheduleCollision)
+	var order []string
+	for _, item := range preview.items {
+		if item.behind == nil {
+			continue
+		}
+		entry, behind := collisionLabel(item), collisionLabel(item.behind)
+		// The languages of one safety announcement follow each other by design
+		if entry == behind {
+			continue
+		}
+		key := entry + "|" + behind
+		pair, ok := pairs[key]
+		if !ok {
+			pair = &ScheduleCollision{Entry: entry, Behind: behind, First: item.At, entry: item, behind: item.behind}
+			pairs[key] = pair
+			order = append(order, key)
+		}
+		pair.Occurrences++
+		pair.MaxWaitSeconds = math.Max(pair.MaxWaitSeconds, item.DelaySeconds)
+	}
+
+	collisions := []ScheduleCollision{}
+	for _, key := range order {
+		if pairs[key].Occurrences >= minCollisionRepeats {
+			collisions = append(collisions, *pairs[key])
+		}
+	}
+	sort.SliceStable(collisions, func(i, j int) bool {
+		return collisions[i].Occurrences > collisions[j].Occurrences
+	})
+	return collisions
+}
+
+// collisionCount totals the occurrences of the collisions
+func collisionCount(collisions []ScheduleCollision) int {
+	total := 0
+	for _, collision := range collisions {
+		total += collision.Occurrences
+	}
+	return total
+}
+
+// movableCollisionEntry returns the entry of a collision that may be moved:
+// a low-priority promo or weather entry not tried yet, preferably the one
+// that waits
+func movableCollisionEntry(collision ScheduleCollision, tried map[string]bool) string {
+	for _, item := range []*schedulePreviewItem{collision.entry, collision.behind} {
+		if (item.Type == TypePromo || item.Type == TypeWeather) && item.priority <= PriorityLow && !tried[item.Entry] {
+			return item.Entry
+		}
+	}
+	return ""
+}
+
+// collisionEntryCron returns the cron expression of a promo or weather entry
+// so it can be changed in place, or nil for any other entry
+func collisionEntryCron(cronData *CronData, entry string) *string {
+	match := scheduleEntryPattern.FindStringSubmatch(entry)
+	if match == nil {
+		return nil
+	}
+	index, _ := strconv.Atoi(match[2])
+	switch {
+	case match[1] == "promo" && index < len(cronData.PromoAnnouncements):
+		return &cronData.PromoAnnouncements[index].Cron
+	case match[1] == "weather" && index < len(cronData.WeatherAnnouncements):
+		return &cronData.WeatherAnnouncements[index].Cron
+	}
+	return nil
+}
+
+// shiftCronMinute moves a cron expression firing at a single minute the given
+// minutes later within the same hour, keeping any CRON_TZ= in front
+func shiftCronMinute(spec string, minutes int) (string, bool) {
+	fields := strings.Fields(spec)
+	first := 0
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "CRON_TZ=") || strings.HasPrefix(fields[0], "TZ=")) {
+		first = 1
+	}
+	if len(fields)-first != 5 {
+		return "", false
+	}
+	minute, err := strconv.Atoi(fields[first])
+	if err != nil || minute < 0 || minute+minutes > 59 {
+		return "", false
+	}
+	fields[first] = strconv.Itoa(minute + minutes)
+	return strings.Join(fields, " "), true
+}
+
+// offsetScheduleCollisions plans the schedule and, when auto_offset is on,
+// moves low-priority entries off their collisions. An entry is moved to the
+// first minute, up to the limit, where it no longer collides and the
+// schedule collides less overall; otherwise it is left where it is. It
+// returns the moves and the collisions that remain.
+func offsetScheduleCollisions(cronData *CronData) ([]ScheduleOffset, []ScheduleCollision) {
+	lengths := make(map[string]previewLength)
+	collisions := planScheduleCollisions(*cronData, lengths)
+	offsets := []ScheduleOffset{}
+	if !cronData.Collisions.AutoOffset {
+		return offsets, collisions
+	}
+	limit := cronData.Collisions.MaxOffsetMinutes
+	if limit == 0 {
+		limit = defaultMaxCollisionOffset
+	}
+
+	tried := make(map[string]bool)
+	for {
+		entry := ""
+		for _, collision := range collisions {
+			if entry = movableCollisionEntry(collision, tried); entry != "" {
+				break
+			}
+		}
+		if entry == "" {
+			return offsets, collisions
+		}
+		tried[entry] = true
+		spec := collisionEntryCron(cronData, entry)
+		if spec == nil {
+			continue
+		}
+
+		original := *spec
+		for minutes := 1; minutes <= limit; minutes++ {
+			shifted, ok := shiftCronMinute(original, minutes)
+			if !ok {
+				break
+			}
+			*spec = shifted
+			remaining := planScheduleCollisions(*cronData, lengths)
+			if collisionCount(remaining) < collisionCount(collisions) && !collidesAgain(remaining, entry) {
+				offsets = append(offsets, ScheduleOffset{Entry: entry, From: original, To: shifted, Minutes: minutes})
+				log.Printf("Moved %s %d minutes later, from '%s' to '%s', off a collision", entry, minutes, original, shifted)
+				collisions = remaining
+				break
+			}
+			*spec = original
+		}
+	}
+}
+
+// collidesAgain reports whether an entry is in any of the collisions
+func collidesAgain(collisions []ScheduleCollision, entry string) bool {
+	for _, collision := range collisions {
+		if collision.Entry == entry || collision.Behind == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// Handlers
+
+// apiGetScheduleCollisionsHandler plans the saved schedule without moving
+// anything
+func apiGetScheduleCollisionsHandler(c *gin.Context) {
+	cronData := loadJSON("cron", CronData{}).(CronData)
+	respondOK(c, gin.H{
+		"collisions": planScheduleCollisions(cronData, make(map[string]previewLength)),
+		"settings":   cronData.Collisions,
+	})
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule holds stop scheduled announcements for a while without editing
+// cron.json: during a ceremony, say. A pause stops every entry of a section
+// (station, promo, safety, weather or clock), or the whole schedule, until a
+// given time. A skip drops the next occurrence of one entry, so the 2 PM
+// safety spiel can be left out while the 3 PM one plays. Announcements made
+// by hand are not affected; switch a type off for that (see
+// announcement_switches.go). Holds are kept in schedule_holds.json so a
+// restart doesn't lift them.
+
+// Schedule sections that can be paused, as named in cron.json without the
+// "_announcements" suffix; "all" pauses every one
+var scheduleSections = []string{"station", "promo", "safety", "weather", "clock"}
+
+const scheduleAllSections = "all"
+
+// SchedulePause holds a section's entries, or all of them, until a time
+type SchedulePause struct {
+	Section  string    `json:"section"`
+	Reason   string    `json:"reason,omitempty"`
+	Until    time.Time `json:"until"`
+	PausedBy string    `json:"paused_by"`
+	PausedAt time.Time `json:"paused_at"`
+}
+
+// describe explains why an entry didn't run
+func (p SchedulePause) describe() string {
+	message := fmt.Sprintf("the %s schedule is paused until %s", p.Section, p.Until.Format("2006-01-02 15:04"))
+	if p.Section == scheduleAllSections {
+		message = "the schedule is paused until " + p.Until.Format("2006-01-02 15:04")
+	}
+	if p.Reason != "" {
+		message += " (" + p.Reason + ")"
+	}
+	return message
+}
+
+// ScheduleSkip drops one occurrence of a schedule entry
+type ScheduleSkip struct {
+	Entry     string    `json:"entry"` // e.g. "safety_announcements[2]"
+	Cron      string    `json:"cron"`  // The entry's schedule when skipped, so an edited entry isn't skipped by mistake
+	At        time.Time `json:"at"`    // The occurrence skipped
+	Reason    string    `json:"reason,omitempty"`
+	SkippedBy string    `json:"skipped_by"`
+	SkippedAt time.Time `json:"skipped_at"`
+}
+
+// ScheduleHolds is the content of schedule_holds.json
+type ScheduleHolds struct {
+	Pauses []SchedulePause `json:"pauses"`
+	Skips  []ScheduleSkip  `json:"skips"`
+}
+
+// A skip matches an entry firing within this much of the skipped occurrence
+const scheduleSkipTolerance = time.Minute
+
+var (
+	scheduleHolds      = ScheduleHolds{Pauses: []SchedulePause{}, Skips: []ScheduleSkip{}}
+	scheduleHoldsMutex sync.Mutex
+
+	scheduleEntryPattern = regexp.MustCompile(`^([a-z]+)_announcements\[(\d+)\]$`)
+)
+
+// loadScheduleHolds reads schedule_holds.json, dropping holds that ran out
+// while the annunciator was stopped
+func loadScheduleHolds() error {
+	holds := ScheduleHolds{Pauses: []SchedulePause{}, Skips: []ScheduleSkip{}}
+	filePath, _ := jsonFilePath("schedule_holds")
+	data, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read schedule_holds.json: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &holds); err != nil {
+			return fmt.Errorf("failed to parse schedule_holds.json: %v", err)
+		}
+	}
+
+	scheduleHoldsMutex.Lock()
+	defer scheduleHoldsMutex.Unlock()
+	scheduleHolds = holds
+	pruneScheduleHoldsLocked(time.Now())
+	for _, pause := range scheduleHolds.Pauses {
+		log.Printf("⏸️  Scheduled announcements held: %s", pause.describe())
+	}
+	return nil
+}
+
+// pruneScheduleHoldsLocked drops pauses that are over and skips whose
+// occurrence has passed; the caller holds the mutex. It reports whether
+// anything was dropped.
+func pruneScheduleHoldsLocked(now time.Time) bool {
+	pauses := make([]SchedulePause, 0, len(scheduleHolds.Pauses))
+	for _, pause := range scheduleHolds.Pauses {
+		if now.Before(pause.Until) {
+			pauses = append(pauses, pause)
+		} else {
+			log.Printf("▶️  Scheduled %s announcements resumed (timer)", pause.Section)
+		}
+	}
+	skips := make([]ScheduleSkip, 0, len(scheduleHolds.Skips))
+	for _, skip := range scheduleHolds.Skips {
+		if now.Before(skip.At.Add(scheduleSkipTolerance)) {
+			skips = append(skips, skip)
+		}
+	}
+	changed := len(pauses) != len(scheduleHolds.Pauses) || len(skips) != len(scheduleHolds.Skips)
+	scheduleHolds.Pauses, scheduleHolds.Skips = pauses, skips
+	return changed
+}
+
+// saveScheduleHoldsLocked writes the holds; the caller holds the mutex
+func saveScheduleHoldsLocked() error {
+	sort.Slice(scheduleHolds.Skips, func(i, j int) bool {
+		return scheduleHolds.Skips[i].At.Before(scheduleHolds.Skips[j].At)
+	})
+	return saveJSON("schedule_holds", scheduleHolds)
+}
+
+// currentScheduleHolds returns a copy of the holds in force
+func currentScheduleHolds() ScheduleHolds {
+	scheduleHoldsMutex.Lock()
+	defer scheduleHoldsMutex.Unlock()
+	now := time.Now()
+	holds := ScheduleHolds{Pauses: []SchedulePause{}, Skips: []ScheduleSkip{}}
+	for _, pause := range scheduleHolds.Pauses {
+		if now.Before(pause.Until) {
+			holds.Pauses = append(holds.Pauses, pause)
+		}
+	}
+	for _, skip := range scheduleHolds.Skips {
+		if now.Before(skip.At.Add(scheduleSkipTolerance)) {
+			holds.Skips = append(holds.Skips, skip)
+		}
+	}
+	return holds
+}
+
+// scheduleEntryKey names a schedule entry the way cron.json lays it out
+func scheduleEntryKey(section string, index int) string {
+	return fmt.Sprintf("%s_announcements[%d]", section, index)
+}
+
+// scheduleEntryCron returns the cron expression of an entry such as
+// "safety_announcements[2]", and whether it exists and is enabled
+func scheduleEntryCron(cronData CronData, entry string) (string, bool, error) {
+	match := scheduleEntryPattern.FindStringSubmatch(entry)
+	if match == nil {
+		return "", false, fmt.Errorf("'%s' is not a schedule entry; use e.g. safety_announcements[0]", entry)
+	}
+	index, _ := strconv.Atoi(match[2])
+	missing := fmt.Errorf("schedule entry '%s' not found", entry)
+	switch match[1] {
+	case "station":
+		if index >= len(cronData.StationAnnouncements) {
+			return "", false, missing
+		}
+		item := cronData.StationAnnouncements[index]
+		return zonedCronSpec(cronData.Timezone, item.Cron), item.Enabled, nil
+	case "promo":
+		if index >= len(cronData.PromoAnnouncements) {
+			return "", false, missing
+		}
+		item := cronData.PromoAnnouncements[index]
+		return zonedCronSpec(cronData.Timezone, item.Cron), item.Enabled, nil
+	case "safety":
+		if index >= len(cronData.SafetyAnnouncements) {
+			return "", false, missing
+		}
+		item := cronData.SafetyAnnouncements[index]
+		return zonedCronSpec(cronData.Timezone, item.Cron), item.Enabled, nil
+	case "weather":
+		if index >= len(cronData.WeatherAnnouncements) {
+			return "", false, missing
+		}
+		item := cronData.WeatherAnnouncements[index]
+		return zonedCronSpec(cronData.Timezone, item.Cron), item.Enabled, nil
+	case "clock":
+		if index >= len(cronData.ClockAnnouncements) {
+			return "", false, missing
+		}
+		item := cronData.ClockAnnouncements[index]
+		spec, err := clockCronSpec(item)
+		if err != nil {
+			return "", false, err
+		}
+		return zonedCronSpec(cronData.Timezone, spec), item.Enabled, nil
+	}
+	return "", false, missing
+}
+
+// scheduledEntryRuns decides whether an entry firing now goes ahead: not
+// while its section or the whole schedule is paused, not when this
+// occurrence was skipped, and only in the entry's schedule profiles
+func scheduledEntryRuns(section string, index int, spec string, profiles []string, what string) bool {
+	entry := scheduleEntryKey(section, index)
+	now := time.Now()
+
+	scheduleHoldsMutex.Lock()
+	for _, pause := range scheduleHolds.Pauses {
+		if (pause.Section == section || pause.Section == scheduleAllSections) && now.Before(pause.Until) {
+			scheduleHoldsMutex.Unlock()
+			log.Printf("⏸️  %s skipped: %s", what, pause.describe())
+			return false
+		}
+	}
+	for i, skip := range scheduleHolds.Skips {
+		if skip.Entry != entry || skip.Cron != spec {
+			continue
+		}
+		if offset := now.Sub(skip.At); offset > -scheduleSkipTolerance && offset < scheduleSkipTolerance {
+			scheduleHolds.Skips = append(scheduleHolds.Skips[:i], scheduleHolds.Skips[i+1:]...)
+			if err := saveScheduleHoldsLocked(); err != nil {
+				log.Printf("Warning: failed to save schedule_holds.json: %v", err)
+			}
+			scheduleHoldsMutex.Unlock()
+			log.Printf("⏭️  %s skipped once by %s", what, skip.SkippedBy)
+			return false
+		}
+	}
+	scheduleHoldsMutex.Unlock()
+
+	return inActiveScheduleProfile(profiles, what)
+}
+
+// startScheduleHoldMonitor tidies pauses and skips once they are over
+func startScheduleHoldMonitor() {
+	go func() {
+		for {
+			time.Sleep(30 * time.Second)
+			scheduleHoldsMutex.Lock()
+			if pruneScheduleHoldsLocked(time.Now()) {
+				if err := saveScheduleHoldsLocked(); err != nil {
+					log.Printf("Warning: failed to save schedule_holds.json: %v", err)
+				}
+			}
+			scheduleHoldsMutex.Unlock()
+		}
+	}()
+}
+
+// Handlers
+
+// apiGetScheduleHoldsHandler lists the pauses and skips in force
+func apiGetScheduleHoldsHandler(c *gin.Context) {
+	respondOK(c, gin.H{
+		"holds":    currentScheduleHolds(),
+		"sections": append(append([]string{}, scheduleSections...), scheduleAllSections),
+	})
+}
+
+// apiPauseScheduleHandler pauses a section, or "all", until "until" (a
+// timestamp) or for "hours", with an optional "reason". Pausing a paused
+// section replaces its pause.
+func apiPauseScheduleHandler(c *gin.Context) {
+	section := c.Param("section")
+	if section != scheduleAllSections && !containsString(scheduleSections, section) {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound,
+			fmt.Sprintf("'%s' is not a schedule section. Sections: %s, %s", section, strings.Join(scheduleSections, ", "), scheduleAllSections))
+		return
+	}
+	data, ok := bindRequestData(c, "until", "hours", "reason")
+	if !ok {
+		return
+	}
+
+	var until time.Time
+	if raw, ok := data["until"].(string); ok && raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			respondValidationError(c, "Invalid until", FieldError{Field: "until", Message: "must be an RFC 3339 timestamp"})
+			return
+		}
+		until = parsed
+	} else if raw, ok := data["hours"]; ok && raw != "" && raw != nil {
+		hours, err := strconv.ParseFloat(fmt.Sprint(raw), 64)
+		if err != nil || hours <= 0 {
+			respondValidationError(c, "Invalid hours", FieldError{Field: "hours", Message: "must be a positive number"})
+			return
+		}
+		until = time.Now().Add(time.Duration(hours * float64(time.Hour)))
+	} else {
+		respondValidationError(c, "Invalid pause", FieldError{Field: "until", Message: "is required (or hours)"})
+		return
+	}
+	if !until.After(time.Now()) {
+		respondValidationError(c, "Invalid until", FieldError{Field: "until", Message: "must be in the future"})
+		return
+	}
+	reason, _ := data["reason"].(string)
+
+	pause := SchedulePause{
+		Section:  section,
+		Reason:   strings.TrimSpace(reason),
+		Until:    until,
+		PausedBy: requestOperator(c),
+		PausedAt: time.Now(),
+	}
+	scheduleHoldsMutex.Lock()
+	pauses := []SchedulePause{pause}
+	for _, existing := range scheduleHolds.Pauses {
+		if existing.Section != section {
+			pauses = append(pauses, existing)
+		}
+	}
+	scheduleHolds.Pauses = pauses
+	err := saveScheduleHoldsLocked()
+	scheduleHoldsMutex.Unlock()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save schedule holds: "+err.Error())
+		return
+	}
+	log.Printf("⏸️  Scheduled announcements held by %s: %s", pause.PausedBy, pause.describe())
+	respondSuccess(c, http.StatusOK, "Paused: "+pause.describe(), gin.H{"pause": pause})
+}
+
+// apiResumeScheduleHandler lifts a section's pause
+func apiResumeScheduleHandler(c *gin.Context) {
+	section := c.Param("section")
+	scheduleHoldsMutex.Lock()
+	pauses := make([]SchedulePause, 0, len(scheduleHolds.Pauses))
+	for _, existing := range scheduleHolds.Pauses {
+		if existing.Section != section {
+			pauses = append(pauses, existing)
+		}
+	}
+	if len(pauses) == len(scheduleHolds.Pauses) {
+		scheduleHoldsMutex.Unlock()
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("The %s schedule is not paused", section))
+		return
+	}
+	scheduleHolds.Pauses = pauses
+	err := saveScheduleHoldsLocked()
+	scheduleHoldsMutex.Unlock()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save schedule holds: "+err.Error())
+		return
+	}
+	log.Printf("▶️  Scheduled %s announcements resumed by %s", section, requestOperator(c))
+	respondSuccess(c, http.StatusOK, fmt.Sprintf("The %s schedule resumed", section), nil)
+}
+
+// apiSkipScheduleEntryHandler skips the next occurrence of an entry:
+// {"entry": "safety_announcements[2]", "reason": "Opening ceremony"}.
+// Skipping again skips the occurrence after that.
+func apiSkipScheduleEntryHandler(c *gin.Context) {
+	data, ok := bindRequestData(c, "entry", "reason")
+	if !ok {
+		return
+	}
+	entry, _ := data["entry"].(string)
+	spec, enabled, err := scheduleEntryCron(loadJSON("cron", CronData{}).(CronData), entry)
+	if err != nil {
+		respondValidationError(c, "Invalid skip", FieldError{Field: "entry", Message: err.Error()})
+		return
+	}
+	if !enabled {
+		respondValidationError(c, "Invalid skip", FieldError{Field: "entry", Message: "is disabled, so it won't run anyway"})
+		return
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		respondValidationError(c, "Invalid skip", FieldError{Field: "entry", Message: "has an invalid cron expression: " + err.Error()})
+		return
+	}
+	reason, _ := data["reason"].(string)
+
+	scheduleHoldsMutex.Lock()
+	// The next occurrence not already skipped
+	at := schedule.Next(time.Now())
+	for skipped := true; skipped && !at.IsZero(); {
+		skipped = false
+		for _, existing := range scheduleHolds.Skips {
+			if existing.Entry == entry && existing.Cron == spec && existing.At.Equal(at) {
+				skipped = true
+				at = schedule.Next(at)
+				break
+			}
+		}
+	}
+	if at.IsZero() {
+		scheduleHoldsMutex.Unlock()
+		respondValidationError(c, "Invalid skip", FieldError{Field: "entry", Message: "has no upcoming occurrence"})
+		return
+	}
+	skip := ScheduleSkip{
+		Entry:     entry,
+		Cron:      spec,
+		At:        at,
+		Reason:    strings.TrimSpace(reason),
+		SkippedBy: requestOperator(c),
+		SkippedAt: time.Now(),
+	}
+	scheduleHolds.Skips = append(scheduleHolds.Skips, skip)
+	err = saveScheduleHoldsLocked()
+	scheduleHoldsMutex.Unlock()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save schedule holds: "+err.Error())
+		return
+	}
+	log.Printf("⏭️  %s at %s will be skipped (by %s)", entry, at.Format("2006-01-02 15:04"), skip.SkippedBy)
+	respondSuccess(c, http.StatusOK, fmt.Sprintf("%s will be skipped at %s", entry, at.Format("2006-01-02 15:04")), gin.H{"skip": skip})
+}
+
+// apiUnskipScheduleEntryHandler cancels an entry's skips:
+// ?entry=safety_announcements[2]
+func apiUnskipScheduleEntryHandler(c *gin.Context) {
+	entry := c.Query("entry")
+	scheduleHoldsMutex.Lock()
+	skips := make([]ScheduleSkip, 0, len(scheduleHolds.Skips))
+	for _, existing := range scheduleHolds.Skips {
+		if existing.Entry != entry {
+			skips = append(skips, existing)
+		}
+	}
+	removed := len(scheduleHolds.Skips) - len(skips)
+	if removed == 0 {
+		scheduleHoldsMutex.Unlock()
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("No skips for '%s'", entry))
+		return
+	}
+	scheduleHolds.Skips = skips
+	err := saveScheduleHoldsLocked()
+	scheduleHoldsMutex.Unlock()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save schedule holds: "+err.Error())
+		return
+	}
+	log.Printf("Skips for %s cancelled by %s", entry, requestOperator(c))
+	respondSuccess(c, http.StatusOK, fmt.Sprintf("Cancelled %d skips for %s", removed, entry), nil)
+}
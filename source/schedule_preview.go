@@ -0,0 +1,556 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+)
+
+// The schedule preview projects what the scheduler will play over a window:
+// every enabled entry in cron.json, the countdowns before each departure, and
+// the safety rotation, with schedule profiles, pauses, skips and switched-off
+// types applied. Each announcement's length is estimated from its clips as
+// it would be when queued, and the queue is then played through in priority
+// order with the spacing, so an operator can see which announcements collide
+// and how late they would start. Spoken text (weather reports, the spoken
+// clock through TTS) isn't synthesized for the preview; it counts as
+// previewSpokenDuration.
+
+const (
+	maxPreviewWindow      = 7 * 24 * time.Hour
+	maxPreviewItems       = 5000
+	previewSpokenDuration = 20 * time.Second
+	// Announcements starting this much after they are due are listed as warnings
+	previewLateWarning = 30 * time.Second
+	// An hour with more announcement time than this is listed as a warning
+	previewBusyHour = 30 * time.Minute
+)
+
+// schedulePreviewItem is one announcement on the projected timeline
+type schedulePreviewItem struct {
+	At              time.Time        `json:"at"`
+	Type            AnnouncementType `json:"type"`
+	Priority        string           `json:"priority"`
+	Entry           string           `json:"entry"` // Countdowns name the departure's station entry
+	Description     string           `json:"description"`
+	Plays           bool             `json:"plays"`
+	SkippedBecause  string           `json:"skipped_because,omitempty"`
+	DurationSeconds float64          `json:"duration_seconds"`
+	Estimated       bool             `json:"estimated"` // False when the length is the allowance for spoken text or unplayable audio
+	ExpectedStart   *time.Time       `json:"expected_start,omitempty"`
+	DelaySeconds    float64          `json:"delay_seconds,omitempty"`
+	WaitsFor        string           `json:"waits_for,omitempty"` // Entry of the announcement it waits behind
+
+	priority AnnouncementPriority
+	duration time.Duration
+	behind   *schedulePreviewItem
+}
+
+// schedulePreview collects the timeline for a window
+type schedulePreview struct {
+	from, to  time.Time
+	profiles  *ScheduleProfiles
+	holds     ScheduleHolds
+	items     []*schedulePreviewItem
+	lengths   map[string]previewLength
+	warnings  []string
+	truncated bool
+	// standing leaves out the switches, for planning the schedule itself
+	// rather than a particular day
+	standing bool
+}
+
+// previewLength is an estimated announcement length, cached per entry
+type previewLength struct {
+	duration  time.Duration
+	estimated bool
+}
+
+// occurrences returns the times a cron expression fires within the window
+func (p *schedulePreview) occurrences(spec string) ([]time.Time, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, err
+	}
+	var times []time.Time
+	for next := schedule.Next(p.from.Add(-time.Second)); !next.IsZero() && next.Before(p.to); next = schedule.Next(next) {
+		if len(times) >= maxPreviewItems {
+			p.truncated = true
+			break
+		}
+		times = append(times, next)
+	}
+	return times, nil
+}
+
+// skippedBecause explains why a schedule entry firing at t won't play, or
+// returns "" when it will. This follows scheduledEntryRuns and the switches.
+func (p *schedulePreview) skippedBecause(section string, index int, spec string, entryProfiles []string, announcementType AnnouncementType, t time.Time) string {
+	for _, pause := range p.holds.Pauses {
+		if (pause.Section == section || pause.Section == scheduleAllSections) && t.Before(pause.Until) {
+			return pause.describe()
+		}
+	}
+	entry := scheduleEntryKey(section, index)
+	for _, skip := range p.holds.Skips {
+		if skip.Entry == entry && skip.Cron == spec && t.Sub(skip.At) > -scheduleSkipTolerance && t.Sub(skip.At) < scheduleSkipTolerance {
+			return "skipped once by " + skip.SkippedBy
+		}
+	}
+	if !p.profiles.allows(entryProfiles, t) {
+		active, _ := p.profiles.profileAt(t)
+		return fmt.Sprintf("the %s schedule profile is active", active)
+	}
+	return p.switchedOff(announcementType, t)
+}
+
+// switchedOff describes the switch holding a type off at t, if any
+func (p *schedulePreview) switchedOff(announcementType AnnouncementType, t time.Time) string {
+	if p.standing {
+		return ""
+	}
+	if setting, off := announcementTypeSwitch(announcementType); off && setting.activeAt(t) {
+		return setting.describe()
+	}
+	return ""
+}
+
+// length estimates an announcement's length once per key, from the clips it
+// would be built from
+func (p *schedulePreview) length(key string, announcementType AnnouncementType, parameters map[string]interface{}) previewLength {
+	if cached, ok := p.lengths[key]; ok {
+		return cached
+	}
+	length := previewLength{duration: previewSpokenDuration}
+	if parameters != nil && announcementManager != nil {
+		files, err := announcementManager.buildAudioSequence(announcementType, parameters)
+		if err != nil {
+			p.warnings = append(p.warnings, fmt.Sprintf("%s can't be played: %v", key, err))
+		} else {
+			length = previewLength{duration: expectedPlaybackDuration(&Announcement{Type: announcementType, Parameters: parameters, AudioFiles: files}), estimated: true}
+		}
+	}
+	p.lengths[key] = length
+	return length
+}
+
+// add puts an announcement on the timeline
+func (p *schedulePreview) add(item *schedulePreviewItem, length previewLength) {
+	if len(p.items) >= maxPreviewItems {
+		p.truncated = true
+		return
+	}
+	item.Priority = item.priority.String()
+	item.Plays = item.SkippedBecause == ""
+	item.duration = length.duration
+	item.DurationSeconds = length.duration.Round(100 * time.Millisecond).Seconds()
+	item.Estimated = length.estimated
+	p.items = append(p.items, item)
+}
+
+// addEntries puts every enabled cron.json entry, and the countdowns before
+// each departure, on the timeline
+func (p *schedulePreview) addEntries(cronData CronData) {
+	for i, item := range cronData.StationAnnouncements {
+		if !item.Enabled {
+			continue
+		}
+		spec := zonedCronSpec(cronData.Timezone, item.Cron)
+		times, err := p.occurrences(spec)
+		if err != nil {
+			p.warnings = append(p.warnings, fmt.Sprintf("%s has an invalid cron expression: %v", scheduleEntryKey("station", i), err))
+			continue
+		}
+		entry := scheduleEntryKey("station", i)
+		parameters := map[string]interface{}{
+			"train_number": item.TrainNumber,
+			"direction":    item.Direction,
+			"destination":  item.Destination,
+			"track_number": item.TrackNumber,
+		}
+		if len(item.Languages) > 0 {
+			parameters["languages"] = item.Languages
+		}
+		for _, t := range times {
+			p.add(&schedulePreviewItem{
+				At: t, Type: TypeStation, priority: PriorityNormal, Entry: entry,
+				Description:    "Train " + item.TrainNumber,
+				SkippedBecause: p.skippedBecause("station", i, spec, item.Profiles, TypeStation, t),
+			}, p.length(entry, TypeStation, parameters))
+		}
+		p.addCountdowns(cronData.Countdown, entry, spec, item)
+	}
+
+	for i, item := range cronData.PromoAnnouncements {
+		if !item.Enabled {
+			continue
+		}
+		spec := zonedCronSpec(cronData.Timezone, item.Cron)
+		times, err := p.occurrences(spec)
+		if err != nil {
+			p.warnings = append(p.warnings, fmt.Sprintf("%s has an invalid cron expression: %v", scheduleEntryKey("promo", i), err))
+			continue
+		}
+		entry := scheduleEntryKey("promo", i)
+		parameters := map[string]interface{}{"file": item.File}
+		if len(item.Languages) > 0 {
+			parameters["languages"] = item.Languages
+		}
+		for _, t := range times {
+			p.add(&schedulePreviewItem{
+				At: t, Type: TypePromo, priority: PriorityLow, Entry: entry,
+				Description:    "Promo " + item.File,
+				SkippedBecause: p.skippedBecause("promo", i, spec, item.Profiles, TypePromo, t),
+			}, p.length(entry, TypePromo, parameters))
+		}
+	}
+
+	rotation := loadSafetyRotation()
+	for i, item := range cronData.SafetyAnnouncements {
+		if !item.Enabled {
+			continue
+		}
+		spec := zonedCronSpec(cronData.Timezone, item.Cron)
+		times, err := p.occurrences(spec)
+		if err != nil {
+			p.warnings = append(p.warnings, fmt.Sprintf("%s has an invalid cron expression: %v", scheduleEntryKey("safety", i), err))
+			continue
+		}
+		entry := scheduleEntryKey("safety", i)
+		// The same languages and delay as updateScheduler
+		languages, delay := item.Languages, 2
+		if len(languages) > 0 {
+			if item.Delay > 0 {
+				delay = item.Delay
+			}
+		} else if item.Language != "" {
+			languages = []string{item.Language}
+		} else {
+			languages = safetyRotationLanguages(rotation)
+			delay = rotation.DelaySeconds
+			if item.Delay > 0 {
+				delay = item.Delay
+			}
+		}
+		for _, t := range times {
+			skipped := p.skippedBecause("safety", i, spec, item.Profiles, TypeSafety, t)
+			// Each language is queued delay seconds after the one before
+			for n, language := range languages {
+				p.add(&schedulePreviewItem{
+					At: t.Add(time.Duration(n*delay) * time.Second), Type: TypeSafety, priority: PriorityHigh, Entry: entry,
+					Description:    "Safety " + language,
+					SkippedBecause: skipped,
+				}, p.length(entry+"|"+language, TypeSafety, map[string]interface{}{"language": language}))
+			}
+		}
+	}
+
+	for i, item := range cronData.WeatherAnnouncements {
+		if !item.Enabled {
+			continue
+		}
+		spec := zonedCronSpec(cronData.Timezone, item.Cron)
+		times, err := p.occurrences(spec)
+		if err != nil {
+			p.warnings = append(p.warnings, fmt.Sprintf("%s has an invalid cron expression: %v", scheduleEntryKey("weather", i), err))
+			continue
+		}
+		entry := scheduleEntryKey("weather", i)
+		priority := PriorityNormal
+		if item.Priority != "" {
+			priority = ParsePriority(item.Priority)
+		}
+		for _, t := range times {
+			p.add(&schedulePreviewItem{
+				At: t, Type: TypeWeather, priority: priority, Entry: entry,
+				Description:    "Weather report",
+				SkippedBecause: p.skippedBecause("weather", i, spec, item.Profiles, TypeWeather, t),
+			}, p.length(entry, TypeWeather, nil))
+		}
+	}
+
+	location := scheduleLocation(cronData.Timezone)
+	for i, item := range cronData.ClockAnnouncements {
+		if !item.Enabled {
+			continue
+		}
+		entry := scheduleEntryKey("clock", i)
+		spec, err := clockCronSpec(item)
+		var times []time.Time
+		if err == nil {
+			spec = zonedCronSpec(cronData.Timezone, spec)
+			times, err = p.occurrences(spec)
+		}
+		if err != nil {
+			p.warnings = append(p.warnings, fmt.Sprintf("%s is invalid: %v", entry, err))
+			continue
+		}
+		mode := item.Mode
+		if mode == "" {
+			mode = "chime"
+		}
+		priority := PriorityLow
+		if item.Priority != "" {
+			priority = ParsePriority(item.Priority)
+		}
+		for _, t := range times {
+			hour := t.In(location).Hour()
+			var parameters map[string]interface{}
+			// The spoken time through TTS counts as spoken text
+			if item.Source != "tts" {
+				parameters = map[string]interface{}{"mode": mode, "hour": hour}
+				if item.Source != "" {
+					parameters["source"] = item.Source
+				}
+				if item.Strike {
+					parameters["strike"] = true
+				}
+				if item.Chime != "" {
+					parameters["chime"] = item.Chime
+				}
+			}
+			key := entry
+			if item.Strike || mode == "time" {
+				key += "|" + strconv.Itoa(hour)
+			}
+			p.add(&schedulePreviewItem{
+				At: t, Type: TypeClock, priority: priority, Entry: entry,
+				Description:    fmt.Sprintf("Clock %s for %s", mode, t.In(location).Format("15:04")),
+				SkippedBecause: p.skippedBecause("clock", i, spec, item.Profiles, TypeClock, t),
+			}, p.length(key, TypeClock, parameters))
+		}
+	}
+}
+
+// addCountdowns adds the countdowns before a station entry's departures. They
+// aren't held by schedule pauses, only by the entry's schedule profiles.
+func (p *schedulePreview) addCountdowns(settings CountdownSettings, entry, spec string, item StationCronJob) {
+	if !settings.Enabled || len(settings.Offsets) == 0 {
+		return
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return
+	}
+	priority := PriorityNormal
+	if settings.Priority != "" {
+		priority = ParsePriority(settings.Priority)
+	}
+	earliest := p.from.Add(-time.Second)
+	for next := schedule.Next(earliest); !next.IsZero() && next.Before(p.to.Add(maxCountdownOffset*time.Minute)); next = schedule.Next(next) {
+		if p.truncated {
+			return
+		}
+		if !p.profiles.allows(item.Profiles, next) {
+			continue
+		}
+		departure := Departure{TrainNumber: item.TrainNumber, DepartsAt: next, destinationID: item.Destination, trackNumber: item.TrackNumber, languages: item.Languages}
+		for _, offset := range settings.Offsets {
+			at := next.Add(-time.Duration(offset) * time.Minute)
+			if at.Before(p.from) || !at.Before(p.to) {
+				continue
+			}
+			p.add(&schedulePreviewItem{
+				At: at, Type: TypeCountdown, priority: priority, Entry: entry,
+				Description:    fmt.Sprintf("Train %s departs in %d minutes", item.TrainNumber, offset),
+				SkippedBecause: p.switchedOff(TypeCountdown, at),
+			}, p.length(entry+"|countdown|"+strconv.Itoa(offset), TypeCountdown, countdownParameters(departure, offset, settings.Languages)))
+		}
+	}
+}
+
+// play runs the announcements that play through a single output in the
+// queue's order: whatever is due, highest priority first, with the spacing
+// between them. It fills in each one's expected start and delay.
+func (p *schedulePreview) play() {
+	sort.SliceStable(p.items, func(i, j int) bool {
+		if !p.items[i].At.Equal(p.items[j].At) {
+			return p.items[i].At.Before(p.items[j].At)
+		}
+		return p.items[i].priority > p.items[j].priority
+	})
+	var queue []*schedulePreviewItem
+	for _, item := range p.items {
+		if item.Plays {
+			queue = append(queue, item)
+		}
+	}
+
+	spacing := announcementSpacing()
+	var waiting []*schedulePreviewItem
+	var previous *schedulePreviewItem
+	var free time.Time // When the previous announcement ends
+	next := 0
+	for next < len(queue) || len(waiting) > 0 {
+		if len(waiting) == 0 {
+			waiting = append(waiting, queue[next])
+			next++
+		}
+		due := waiting[0].At
+		for _, item := range waiting {
+			if item.At.Before(due) {
+				due = item.At
+			}
+		}
+		if free.After(due) {
+			due = free
+		}
+		for next < len(queue) && !queue[next].At.After(due) {
+			waiting = append(waiting, queue[next])
+			next++
+		}
+
+		best := 0
+		for i, item := range waiting {
+			if item.priority > waiting[best].priority || (item.priority == waiting[best].priority && item.At.Before(waiting[best].At)) {
+				best = i
+			}
+		}
+		item := waiting[best]
+		waiting = append(waiting[:best], waiting[best+1:]...)
+
+		start := item.At
+		if free.After(start) {
+			start = free
+		}
+		if spacing > 0 && previous != nil && spacesOut(&Announcement{Type: item.Type, Priority: item.priority}) && free.Add(spacing).After(start) {
+			start = free.Add(spacing)
+		}
+		item.ExpectedStart = &start
+		if delay := start.Sub(item.At); delay > 0 {
+			item.DelaySeconds = delay.Round(100 * time.Millisecond).Seconds()
+			if previous != nil {
+				item.WaitsFor = previous.Entry
+				item.behind = previous
+			}
+		}
+		free = start.Add(item.duration)
+		previous = item
+	}
+}
+
+// summary counts the timeline and lists late announcements and busy hours
+func (p *schedulePreview) summary() gin.H {
+	playing, skipped, late := 0, 0, 0
+	var total, maxDelay time.Duration
+	hours := make(map[time.Time]time.Duration)
+	hourCounts := make(map[time.Time]int)
+	for _, item := range p.items {
+		if !item.Plays {
+			skipped++
+			continue
+		}
+		playing++
+		total += item.duration
+		hour := item.At.Truncate(time.Hour)
+		hours[hour] += item.duration
+		hourCounts[hour]++
+		delay := time.Duration(item.DelaySeconds * float64(time.Second))
+		if delay > maxDelay {
+			maxDelay = delay
+		}
+		if delay >= previewLateWarning {
+			late++
+			message := fmt.Sprintf("%s (%s) due %s starts %s late", item.Entry, item.Description, item.At.Format("Mon 15:04"), delay.Round(time.Second))
+			if item.WaitsFor != "" {
+				message += ", behind " + item.WaitsFor
+			}
+			p.warnings = append(p.warnings, message)
+		}
+	}
+
+	var busiest gin.H
+	var busiestTime time.Duration
+	order := make([]time.Time, 0, len(hours))
+	for hour := range hours {
+		order = append(order, hour)
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i].Before(order[j]) })
+	for _, hour := range order {
+		if hours[hour] > busiestTime {
+			busiestTime = hours[hour]
+			busiest = gin.H{"hour": hour, "announcements": hourCounts[hour], "seconds": busiestTime.Round(time.Second).Seconds()}
+		}
+		if hours[hour] > previewBusyHour {
+			p.warnings = append(p.warnings, fmt.Sprintf("%s has %s of announcements", hour.Format("Mon 15:00"), hours[hour].Round(time.Minute)))
+		}
+	}
+
+	return gin.H{
+		"announcements":     playing,
+		"skipped":           skipped,
+		"late":              late,
+		"total_seconds":     total.Round(time.Second).Seconds(),
+		"max_delay_seconds": maxDelay.Round(100 * time.Millisecond).Seconds(),
+		"busiest_hour":      busiest,
+	}
+}
+
+// parsePreviewTime reads an RFC 3339 time or a local date
+func parsePreviewTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// Handlers
+
+// apiSchedulePreviewHandler projects the timeline between from and to (RFC
+// 3339 times or dates; a date for to means the end of that day). The default
+// is the next 24 hours.
+func apiSchedulePreviewHandler(c *gin.Context) {
+	from := time.Now().Truncate(time.Minute)
+	if value := c.Query("from"); value != "" {
+		parsed, err := parsePreviewTime(value)
+		if err != nil {
+			respondValidationError(c, "Invalid preview window", FieldError{Field: "from", Message: "must be an RFC 3339 time or YYYY-MM-DD"})
+			return
+		}
+		from = parsed
+	}
+	to := from.Add(24 * time.Hour)
+	if value := c.Query("to"); value != "" {
+		parsed, err := parsePreviewTime(value)
+		if err != nil {
+			respondValidationError(c, "Invalid preview window", FieldError{Field: "to", Message: "must be an RFC 3339 time or YYYY-MM-DD"})
+			return
+		}
+		if !strings.Contains(value, "T") {
+			parsed = parsed.AddDate(0, 0, 1)
+		}
+		to = parsed
+	}
+	if !to.After(from) || to.Sub(from) > maxPreviewWindow {
+		respondValidationError(c, "Invalid preview window", FieldError{Field: "to", Message: "must be after from and at most 7 days later"})
+		return
+	}
+
+	preview := &schedulePreview{
+		from:     from,
+		to:       to,
+		profiles: loadScheduleProfiles(),
+		holds:    currentScheduleHolds(),
+		lengths:  make(map[string]previewLength),
+	}
+	preview.addEntries(loadJSON("cron", CronData{}).(CronData))
+	preview.play()
+	summary := preview.summary()
+
+	if preview.warnings == nil {
+		preview.warnings = []string{}
+	}
+	respondOK(c, gin.H{
+		"from":      from,
+		"to":        to,
+		"summary":   summary,
+		"warnings":  preview.warnings,
+		"timeline":  preview.items,
+		"truncated": preview.truncated,
+	})
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Schedule profiles (weekday, weekend, night, special event...) switch parts
+// of the schedule on and off without editing cron.json. A schedule entry with
+// a "profiles" list only runs while one of those profiles is active; entries
+// without one always run. The active profile comes from the rules in
+// json/schedule_profiles.json, checked in order, or the default when none
+// matches. An operator can also activate a profile by hand, until a set time
+// or until cleared. The check is made when an entry fires, and the departure
+// board uses the profile active at each departure's time.
+
+// Checked ahead for the next automatic profile change
+const profileLookahead = 8 * 24 * time.Hour
+
+// ScheduleProfile is one named profile
+type ScheduleProfile struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// ScheduleProfileRule activates a profile on chosen days or dates, optionally
+// within a daily time window
+type ScheduleProfileRule struct {
+	Profile string   `json:"profile"`
+	Days    []string `json:"days,omitempty"`  // e.g. ["sat", "sun"]; empty for every day
+	Dates   []string `json:"dates,omitempty"` // "2026-12-24" or "2026-12-24..2026-12-26"; empty for any date
+	Start   string   `json:"start,omitempty"` // "22:00"; with end, a daily window that may wrap past midnight
+	End     string   `json:"end,omitempty"`
+}
+
+// ScheduleProfileOverride is a profile activated by hand
+type ScheduleProfileOverride struct {
+	Profile string     `json:"profile"`
+	Until   *time.Time `json:"until,omitempty"` // Until cleared when unset
+	SetBy   string     `json:"set_by"`
+	SetAt   time.Time  `json:"set_at"`
+}
+
+// ScheduleProfiles is the contents of schedule_profiles.json
+type ScheduleProfiles struct {
+	Profiles []ScheduleProfile        `json:"profiles"`
+	Default  string                   `json:"default"`
+	Rules    []ScheduleProfileRule    `json:"rules"`
+	Override *ScheduleProfileOverride `json:"override,omitempty"`
+
+	compiled []compiledProfileRule
+}
+
+// compiledProfileRule is a rule parsed for matching
+type compiledProfileRule struct {
+	profile    string
+	days       map[time.Weekday]bool // nil for every day
+	dates      [][2]string           // Inclusive YYYY-MM-DD ranges
+	window     bool
+	start, end int // Minutes after midnight
+}
+
+// Serialises changes to schedule_profiles.json
+var scheduleProfilesMutex sync.Mutex
+
+// loadScheduleProfiles reads schedule_profiles.json. Without the file, or
+// with no profiles in it, profiles are off and every entry runs.
+func loadScheduleProfiles() *ScheduleProfiles {
+	profiles := &ScheduleProfiles{}
+	filePath, _ := jsonFilePath("schedule_profiles")
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read schedule_profiles.json: %v", err)
+		}
+		return profiles
+	}
+	if err := json.Unmarshal(data, profiles); err != nil {
+		log.Printf("Warning: failed to parse schedule_profiles.json, profiles are off: %v", err)
+		return &ScheduleProfiles{}
+	}
+	for _, rule := range profiles.Rules {
+		compiled, err := compileProfileRule(rule)
+		if err != nil {
+			log.Printf("Warning: skipping schedule profile rule for %s: %v", rule.Profile, err)
+			continue
+		}
+		profiles.compiled = append(profiles.compiled, compiled)
+	}
+	return profiles
+}
+
+// compileProfileRule parses a rule's days, dates and window
+func compileProfileRule(rule ScheduleProfileRule) (compiledProfileRule, error) {
+	compiled := compiledProfileRule{profile: rule.Profile}
+	if len(rule.Days) > 0 {
+		compiled.days = make(map[time.Weekday]bool)
+		for _, day := range rule.Days {
+			key := strings.ToLower(strings.TrimSpace(day))
+			if len(key) > 3 {
+				key = key[:3] // "monday" as well as "mon"
+			}
+			weekday, ok := weekdayNames[key]
+			if !ok {
+				return compiled, fmt.Errorf("invalid day '%s'", day)
+			}
+			compiled.days[weekday] = true
+		}
+	}
+	for _, date := range rule.Dates {
+		first, last, isRange := strings.Cut(date, "..")
+		if !isRange {
+			last = first
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+		for _, value := range []string{first, last} {
+			if _, err := time.Parse("2006-01-02", value); err != nil {
+				return compiled, fmt.Errorf("invalid date '%s' (use YYYY-MM-DD or YYYY-MM-DD..YYYY-MM-DD)", date)
+			}
+		}
+		if last < first {
+			return compiled, fmt.Errorf("date range '%s' ends before it starts", date)
+		}
+		compiled.dates = append(compiled.dates, [2]string{first, last})
+	}
+	if rule.Start != "" || rule.End != "" {
+		if rule.Start == "" || rule.End == "" {
+			return compiled, fmt.Errorf("start and end must be set together")
+		}
+		var err error
+		if compiled.start, err = parseClockMinutes(rule.Start); err != nil {
+			return compiled, fmt.Errorf("start %v", err)
+		}
+		if compiled.end, err = parseClockMinutes(rule.End); err != nil {
+			return compiled, fmt.Errorf("end %v", err)
+		}
+		if compiled.start == compiled.end {
+			return compiled, fmt.Errorf("start and end must differ")
+		}
+		compiled.window = true
+	}
+	return compiled, nil
+}
+
+// matches reports whether the rule applies at t. The early-morning part of a
+// window that wraps past midnight belongs to the day it started, so a Friday
+// 22:00-06:00 window still holds at 2 AM on Saturday.
+func (r compiledProfileRule) matches(t time.Time) bool {
+	day := t
+	if r.window {
+		minute := t.Hour()*60 + t.Minute()
+		if r.start < r.end {
+			if minute < r.start || minute >= r.end {
+				return false
+			}
+		} else {
+			if minute >= r.end && minute < r.start {
+				return false
+			}
+			if minute < r.end {
+				day = t.AddDate(0, 0, -1)
+			}
+		}
+	}
+	if r.days != nil && !r.days[day.Weekday()] {
+		return false
+	}
+	if len(r.dates) > 0 {
+		date := day.Format("2006-01-02")
+		for _, span := range r.dates {
+			if date >= span[0] && date <= span[1] {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// enabled reports whether any profiles are set up
+func (p *ScheduleProfiles) enabled() bool {
+	return len(p.Profiles) > 0
+}
+
+// has reports whether a profile exists
+func (p *ScheduleProfiles) has(id string) bool {
+	for _, profile := range p.Profiles {
+		if profile.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// profileAt returns the profile active at t and why: "override", "rule" or
+// "default". It returns "" when profiles are off.
+func (p *ScheduleProfiles) profileAt(t time.Time) (string, string) {
+	if !p.enabled() {
+		return "", ""
+	}
+	if p.Override != nil && (p.Override.Until == nil || t.Before(*p.Override.Until)) {
+		return p.Override.Profile, "override"
+	}
+	for _, rule := range p.compiled {
+		if rule.matches(t) {
+			return rule.profile, "rule"
+		}
+	}
+	return p.Default, "default"
+}
+
+// allows reports whether an entry limited to the given profiles runs at t
+func (p *ScheduleProfiles) allows(entryProfiles []string, t time.Time) bool {
+	if len(entryProfiles) == 0 {
+		return true
+	}
+	active, _ := p.profileAt(t)
+	return active == "" || containsString(entryProfiles, active)
+}
+
+// nextChange finds when the active profile next changes, checking minute by
+// minute up to profileLookahead ahead
+func (p *ScheduleProfiles) nextChange(now time.Time) (time.Time, string, bool) {
+	current, _ := p.profileAt(now)
+	start := now.Truncate(time.Minute).Add(time.Minute)
+	for t := start; t.Sub(now) <= profileLookahead; t = t.Add(time.Minute) {
+		if profile, _ := p.profileAt(t); profile != current {
+			return t, profile, true
+		}
+	}
+	return time.Time{}, "", false
+}
+
+// validate checks profile IDs, the default, the rules and the override
+func (p *ScheduleProfiles) validate() []FieldError {
+	var details []FieldError
+	seen := map[string]bool{}
+	for i, profile := range p.Profiles {
+		field := fmt.Sprintf("profiles[%d]", i)
+		switch {
+		case !catalogIDPattern.MatchString(profile.ID):
+			details = append(details, FieldError{Field: field + ".id", Message: "must be 1-64 letters, digits, '-' or '_'"})
+		case seen[profile.ID]:
+			details = append(details, FieldError{Field: field + ".id", Message: "duplicate id '" + profile.ID + "'"})
+		}
+		seen[profile.ID] = true
+		if strings.TrimSpace(profile.Name) == "" {
+			details = append(details, FieldError{Field: field + ".name", Message: "is required"})
+		}
+	}
+	if len(p.Profiles) > 0 && !seen[p.Default] {
+		details = append(details, FieldError{Field: "default", Message: "must name one of the profiles"})
+	}
+	for i, rule := range p.Rules {
+		field := fmt.Sprintf("rules[%d]", i)
+		if !seen[rule.Profile] {
+			details = append(details, FieldError{Field: field + ".profile", Message: "unknown profile '" + rule.Profile + "'"})
+		}
+		if _, err := compileProfileRule(rule); err != nil {
+			details = append(details, FieldError{Field: field, Message: err.Error()})
+		}
+	}
+	if p.Override != nil && !seen[p.Override.Profile] {
+		details = append(details, FieldError{Field: "override.profile", Message: "unknown profile '" + p.Override.Profile + "'"})
+	}
+	return details
+}
+
+// scheduleProfileDetails checks that schedule entries only name existing
+// profiles
+func scheduleProfileDetails(cronData CronData) []FieldError {
+	profiles := loadScheduleProfiles()
+	var details []FieldError
+	check := func(field string, names []string) {
+		for j, name := range names {
+			if !profiles.has(name) {
+				details = append(details, FieldError{Field: fmt.Sprintf("schedule.%s.profiles[%d]", field, j), Message: "unknown schedule profile '" + name + "'"})
+			}
+		}
+	}
+	for i, item := range cronData.StationAnnouncements {
+		check(fmt.Sprintf("station_announcements[%d]", i), item.Profiles)
+	}
+	for i, item := range cronData.PromoAnnouncements {
+		check(fmt.Sprintf("promo_announcements[%d]", i), item.Profiles)
+	}
+	for i, item := range cronData.SafetyAnnouncements {
+		check(fmt.Sprintf("safety_announcements[%d]", i), item.Profiles)
+	}
+	for i, item := range cronData.WeatherAnnouncements {
+		check(fmt.Sprintf("weather_announcements[%d]", i), item.Profiles)
+	}
+	for i, item := range cronData.ClockAnnouncements {
+		check(fmt.Sprintf("clock_announcements[%d]", i), item.Profiles)
+	}
+	return details
+}
+
+// inActiveScheduleProfile is checked when a schedule entry fires; entries
+// outside the active profile are skipped
+func inActiveScheduleProfile(entryProfiles []string, what string) bool {
+	if len(entryProfiles) == 0 {
+		return true
+	}
+	profiles := loadScheduleProfiles()
+	if profiles.allows(entryProfiles, time.Now()) {
+		return true
+	}
+	active, _ := profiles.profileAt(time.Now())
+	log.Printf("🕐 %s skipped: the %s schedule profile is active", what, active)
+	return false
+}
+
+// scheduleProfileStatus reports the active profile and the next change
+func scheduleProfileStatus(profiles *ScheduleProfiles) gin.H {
+	now := time.Now()
+	active, source := profiles.profileAt(now)
+	status := gin.H{
+		"enabled": profiles.enabled(),
+		"active":  active,
+		"source":  source,
+	}
+	if at, profile, ok := profiles.nextChange(now); ok {
+		status["next_change"] = gin.H{"at": at, "profile": profile}
+	}
+	return status
+}
+
+// startScheduleProfileMonitor logs profile switches as they happen and drops
+// an expired override from the file
+func startScheduleProfileMonitor() {
+	go func() {
+		previous := ""
+		for {
+			profiles := loadScheduleProfiles()
+			now := time.Now()
+			if override := profiles.Override; override != nil && override.Until != nil && !now.Before(*override.Until) {
+				clearExpiredProfileOverride()
+			}
+			if active, source := profiles.profileAt(now); active != previous {
+				if previous != "" || active != "" {
+					log.Printf("🗓️  Schedule profile is now %s (%s)", active, source)
+				}
+				previous = active
+			}
+			time.Sleep(30 * time.Second)
+		}
+	}()
+}
+
+// clearExpiredProfileOverride removes an override whose time is up
+func clearExpiredProfileOverride() {
+	scheduleProfilesMutex.Lock()
+	defer scheduleProfilesMutex.Unlock()
+	profiles := loadScheduleProfiles()
+	if profiles.Override == nil || profiles.Override.Until == nil || time.Now().Before(*profiles.Override.Until) {
+		return
+	}
+	log.Printf("🗓️  Schedule profile override %s ended", profiles.Override.Profile)
+	profiles.Override = nil
+	if err := saveJSON("schedule_profiles", profiles); err != nil {
+		log.Printf("Warning: failed to save schedule_profiles.json: %v", err)
+	}
+}
+
+// Handlers
+
+// apiGetScheduleProfilesHandler returns the profiles, rules, override and
+// the active profile
+func apiGetScheduleProfilesHandler(c *gin.Context) {
+	profiles := loadScheduleProfiles()
+	respondOK(c, gin.H{
+		"profiles": profiles,
+		"status":   scheduleProfileStatus(profiles),
+	})
+}
+
+// apiPutScheduleProfilesHandler replaces the profiles, default and rules,
+// keeping any override
+func apiPutScheduleProfilesHandler(c *gin.Context) {
+	var submitted ScheduleProfiles
+	if err := c.ShouldBindJSON(&submitted); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+
+	scheduleProfilesMutex.Lock()
+	defer scheduleProfilesMutex.Unlock()
+	submitted.Override = loadScheduleProfiles().Override
+	if submitted.Override != nil && !submitted.has(submitted.Override.Profile) {
+		// The overridden profile was removed
+		submitted.Override = nil
+	}
+	if details := submitted.validate(); len(details) > 0 {
+		respondValidationError(c, "Invalid schedule profiles", details...)
+		return
+	}
+	if err := saveJSON("schedule_profiles", submitted); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save schedule profiles: "+err.Error())
+		return
+	}
+	log.Printf("Schedule profiles updated by %s: %d profiles, %d rules", requestOperator(c), len(submitted.Profiles), len(submitted.Rules))
+	profiles := loadScheduleProfiles()
+	respondSuccess(c, http.StatusOK, "Schedule profiles saved", gin.H{
+		"profiles": profiles,
+		"status":   scheduleProfileStatus(profiles),
+	})
+}
+
+// apiActivateScheduleProfileHandler activates a profile by hand. "until" (a
+// timestamp) or "hours" ends it; without either it lasts until cleared. An
+// empty profile clears the override and returns to the rules.
+func apiActivateScheduleProfileHandler(c *gin.Context) {
+	data, ok := bindRequestData(c, "profile", "until", "hours")
+	if !ok {
+		return
+	}
+	profileID, _ := data["profile"].(string)
+
+	var until *time.Time
+	if raw, ok := data["until"].(string); ok && raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			respondValidationError(c, "Invalid until", FieldError{Field: "until", Message: "must be an RFC 3339 timestamp"})
+			return
+		}
+		until = &parsed
+	} else if raw, ok := data["hours"]; ok && raw != "" && raw != nil {
+		hours, err := strconv.ParseFloat(fmt.Sprint(raw), 64)
+		if err != nil || hours <= 0 {
+			respondValidationError(c, "Invalid hours", FieldError{Field: "hours", Message: "must be a positive number"})
+			return
+		}
+		end := time.Now().Add(time.Duration(hours * float64(time.Hour)))
+		until = &end
+	}
+	if until != nil && !until.After(time.Now()) {
+		respondValidationError(c, "Invalid until", FieldError{Field: "until", Message: "must be in the future"})
+		return
+	}
+
+	scheduleProfilesMutex.Lock()
+	defer scheduleProfilesMutex.Unlock()
+	profiles := loadScheduleProfiles()
+	if !profiles.enabled() {
+		respondError(c, http.StatusConflict, ErrCodeConflict, "No schedule profiles are set up in schedule_profiles.json")
+		return
+	}
+	message := "Schedule profile override cleared"
+	if profileID == "" {
+		profiles.Override = nil
+		log.Printf("🗓️  Schedule profile override cleared by %s", requestOperator(c))
+	} else {
+		if !profiles.has(profileID) {
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("Schedule profile '%s' not found", profileID))
+			return
+		}
+		profiles.Override = &ScheduleProfileOverride{
+			Profile: profileID,
+			Until:   until,
+			SetBy:   requestOperator(c),
+			SetAt:   time.Now(),
+		}
+		message = "Schedule profile " + profileID + " activated"
+		if until != nil {
+			log.Printf("🗓️  Schedule profile %s activated by %s until %s", profileID, requestOperator(c), until.Format(time.RFC3339))
+		} else {
+			log.Printf("🗓️  Schedule profile %s activated by %s until cleared", profileID, requestOperator(c))
+		}
+	}
+	if err := saveJSON("schedule_profiles", profiles); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save schedule profiles: "+err.Error())
+		return
+	}
+	respondSuccess(c, http.StatusOK, message, gin.H{"status": scheduleProfileStatus(profiles)})
+}
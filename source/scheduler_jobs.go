@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// The cron scheduler only knows its entries by ID and next run. Every job is
+// added through addScheduledJob, which records what the job is (its name,
+// type, schedule entry and cron expression) keyed by its entry ID and wraps
+// it to remember when it last ran and how that went, for /scheduler_status.
+//
+// Reloading the schedule doesn't start from scratch. Between
+// beginSchedulerReload and finishSchedulerReload, a job added with the same
+// entry, expression and settings as one already running is left as it is,
+// keeping its next run; only new and changed jobs are added, and jobs no
+// longer in the schedule are removed at the end. A changed job takes over the
+// last run of the one it replaces.
+//
+// Cron expressions run in the system's time zone unless the schedule sets a
+// "timezone" in cron.json or an expression starts with its own
+// CRON_TZ=<zone>, e.g. "CRON_TZ=America/Chicago 0 8 * * *".
+
+// Results of a job's last run
+const (
+	JobResultOK      = "ok"
+	JobResultSkipped = "skipped"
+	JobResultFailed  = "failed"
+)
+
+// errScheduleHeld is returned by a job whose entry was paused, skipped or
+// outside the active schedule profile; the reason has been logged already
+var errScheduleHeld = errors.New("held")
+
+// ScheduledJob describes one cron job
+type ScheduledJob struct {
+	ID         cron.EntryID `json:"id"`
+	Name       string       `json:"name"`
+	Type       string       `json:"type"`            // station, promo, safety, weather, clock or speaker_test
+	Entry      string       `json:"entry,omitempty"` // e.g. "safety_announcements[2]"
+	Spec       string       `json:"spec"`
+	NextRun    *time.Time   `json:"next_run,omitempty"`
+	LastRun    *time.Time   `json:"last_run,omitempty"`
+	LastResult string       `json:"last_result,omitempty"`
+	LastError  string       `json:"last_error,omitempty"`
+
+	key string // Type, entry, expression and settings, to tell whether a reload changes the job
+}
+
+var (
+	scheduledJobs      = make(map[cron.EntryID]*ScheduledJob)
+	scheduledJobsMutex sync.Mutex
+
+	// Held for the length of a reload, so two don't interleave
+	schedulerReloadMutex sync.Mutex
+	// Jobs from before the reload in progress not added again yet, by key
+	reloadPending map[string]cron.EntryID
+	// Jobs the reload in progress added
+	reloadAdded []cron.EntryID
+)
+
+// zonedCronSpec puts a schedule's time zone in front of a cron expression
+// that doesn't name its own
+func zonedCronSpec(timezone, spec string) string {
+	spec = strings.TrimSpace(spec)
+	if timezone == "" || spec == "" || strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		return spec
+	}
+	return "CRON_TZ=" + timezone + " " + spec
+}
+
+// scheduleLocation returns the schedule's time zone, or the system's when it
+// has none or an unknown one
+func scheduleLocation(timezone string) *time.Location {
+	if timezone == "" {
+		return time.Local
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Local
+	}
+	return location
+}
+
+// scheduledJobKey identifies a job by everything its function was built from
+func scheduledJobKey(jobType, entry, spec string, settings interface{}) string {
+	data, _ := json.Marshal(settings)
+	return jobType + "|" + entry + "|" + spec + "|" + string(data)
+}
+
+// addScheduledJob adds run to the scheduler under spec and records it.
+// settings are what run was built from; during a reload, a job with the same
+// ones is kept instead of added again. run returns errScheduleHeld when the
+// entry didn't go ahead.
+func addScheduledJob(spec, jobType, entry, name string, settings interface{}, run func() error) error {
+	key := scheduledJobKey(jobType, entry, spec, settings)
+	scheduledJobsMutex.Lock()
+	if _, ok := reloadPending[key]; ok {
+		delete(reloadPending, key)
+		scheduledJobsMutex.Unlock()
+		return nil
+	}
+	scheduledJobsMutex.Unlock()
+
+	var id cron.EntryID
+	id, err := app.Scheduler.AddFunc(spec, func() {
+		err := run()
+		now := time.Now()
+		result := JobResultOK
+		switch {
+		case errors.Is(err, errScheduleHeld):
+			result = JobResultSkipped
+		case err != nil:
+			result = JobResultFailed
+		}
+		scheduledJobsMutex.Lock()
+		if job, ok := scheduledJobs[id]; ok {
+			job.LastRun = &now
+			job.LastResult = result
+			job.LastError = ""
+			if result == JobResultFailed {
+				job.LastError = err.Error()
+			}
+		}
+		scheduledJobsMutex.Unlock()
+	})
+	if err != nil {
+		return err
+	}
+	scheduledJobsMutex.Lock()
+	scheduledJobs[id] = &ScheduledJob{ID: id, Name: name, Type: jobType, Entry: entry, Spec: spec, key: key}
+	if reloadPending != nil {
+		reloadAdded = append(reloadAdded, id)
+	}
+	scheduledJobsMutex.Unlock()
+	return nil
+}
+
+// beginSchedulerReload marks every running job as pending until it is added
+// again. finishSchedulerReload must follow.
+func beginSchedulerReload() {
+	schedulerReloadMutex.Lock()
+	scheduledJobsMutex.Lock()
+	defer scheduledJobsMutex.Unlock()
+	reloadPending = make(map[string]cron.EntryID, len(scheduledJobs))
+	reloadAdded = nil
+	for id, job := range scheduledJobs {
+		reloadPending[job.key] = id
+	}
+}
+
+// finishSchedulerReload removes the jobs that weren't added again, and
+// returns how many jobs were kept, added and removed
+func finishSchedulerReload() (int, int, int) {
+	defer schedulerReloadMutex.Unlock()
+	scheduledJobsMutex.Lock()
+	defer scheduledJobsMutex.Unlock()
+
+	removed := make([]*ScheduledJob, 0, len(reloadPending))
+	for _, id := range reloadPending {
+		if job, ok := scheduledJobs[id]; ok {
+			removed = append(removed, job)
+			delete(scheduledJobs, id)
+		}
+		app.Scheduler.Remove(id)
+	}
+	// A changed job takes over the last run of the one it replaces
+	for _, id := range reloadAdded {
+		job := scheduledJobs[id]
+		for _, old := range removed {
+			if old.Type == job.Type && old.Entry == job.Entry && old.LastRun != nil {
+				job.LastRun, job.LastResult, job.LastError = old.LastRun, old.LastResult, old.LastError
+				break
+			}
+		}
+	}
+
+	added := len(reloadAdded)
+	reloadPending, reloadAdded = nil, nil
+	return len(scheduledJobs) - added, added, len(removed)
+}
+
+// scheduledJobList returns the jobs with their next runs, soonest first
+func scheduledJobList() []ScheduledJob {
+	scheduledJobsMutex.Lock()
+	defer scheduledJobsMutex.Unlock()
+	jobs := make([]ScheduledJob, 0, len(scheduledJobs))
+	for _, entry := range app.Scheduler.Entries() {
+		job := ScheduledJob{ID: entry.ID, Name: "Unnamed job"}
+		if known, ok := scheduledJobs[entry.ID]; ok {
+			job = *known
+		}
+		if !entry.Next.IsZero() {
+			next := entry.Next
+			job.NextRun = &next
+		}
+		jobs = append(jobs, job)
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		if jobs[i].NextRun == nil || jobs[j].NextRun == nil {
+			return jobs[j].NextRun == nil && jobs[i].NextRun != nil
+		}
+		return jobs[i].NextRun.Before(*jobs[j].NextRun)
+	})
+	return jobs
+}
+
+// queueScheduled queues a scheduled announcement and logs the outcome
+func queueScheduled(announcementType AnnouncementType, priority AnnouncementPriority, parameters map[string]interface{}, what string) error {
+	if announcementManager == nil {
+		log.Printf("⚠️  Announcement manager not available for scheduled announcement")
+		return fmt.Errorf("announcement manager not available")
+	}
+	announcement, err := announcementManager.QueueAnnouncement(announcementType, priority, parameters, time.Now())
+	if err != nil {
+		log.Printf("Error queuing scheduled %s: %v", what, err)
+		return err
+	}
+	log.Printf("Scheduled %s queued successfully (ID: %s)", what, announcement.ID)
+	return nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+)
+
+// Values that shipped in earlier releases. Anything still using one of them
+// is as good as public.
+var knownDefaultSecrets = map[string]bool{
+	"tarr-api-2025":                                    true,
+	"tarr2025":                                         true,
+	"tarr-session-secret-change-this":                  true,
+	"tarr-webhook-secret-change-this":                  true,
+	"tarr-token-secret-change-this":                    true,
+	"2932d8c03fb85143293c803ff3f7f1c27923787e520ce335": true, // old built-in session fallback
+}
+
+// A rotated-out session secret still validates cookies until it is retired,
+// so rotating does not log everyone out. Only the last one is kept.
+const maxPreviousSessionSecrets = 1
+
+// DefaultSecretFinding describes one credential still set to a shipped default
+type DefaultSecretFinding struct {
+	Kind string `json:"kind"` // admin_password, api_key, session_secret, webhook_secret or token_secret
+	ID   string `json:"id,omitempty"`
+	Fix  string `json:"fix"`
+}
+
+// generateAPIKey returns a new random API key
+func generateAPIKey() string {
+	return "tarr_" + randomSecret()
+}
+
+// findDefaultSecrets lists every credential in the config still set to a shipped default
+func findDefaultSecrets(config *AdminConfig) []DefaultSecretFinding {
+	findings := []DefaultSecretFinding{}
+	for _, user := range config.AdminUsers {
+		if user.Enabled && knownDefaultSecrets[user.Password] {
+			findings = append(findings, DefaultSecretFinding{Kind: "admin_password", ID: user.Username, Fix: "change the password in the admin panel"})
+		}
+	}
+	for _, key := range config.APIKeys {
+		if key.Enabled && knownDefaultSecrets[key.Key] {
+			findings = append(findings, DefaultSecretFinding{Kind: "api_key", ID: key.ID, Fix: "POST /api/v1/secrets/api-keys/" + key.ID + "/rotate"})
+		}
+	}
+	if config.Security.SessionSecret == "" || knownDefaultSecrets[config.Security.SessionSecret] {
+		findings = append(findings, DefaultSecretFinding{Kind: "session_secret", Fix: "POST /api/v1/secrets/session/rotate"})
+	}
+	if knownDefaultSecrets[config.API.WebhookSecret] {
+		findings = append(findings, DefaultSecretFinding{Kind: "webhook_secret", Fix: "POST /api/v1/secrets/webhook/rotate and update webhook receivers"})
+	}
+	if knownDefaultSecrets[config.API.TokenSecret] {
+		findings = append(findings, DefaultSecretFinding{Kind: "token_secret", Fix: "POST /api/v1/secrets/token/rotate"})
+	}
+	return findings
+}
+
+// ensureGeneratedSecrets replaces default or missing session and token secrets
+// with random ones at startup. Both are internal to this server, so nothing
+// outside has to change; the webhook secret, API keys and passwords are shared
+// with people and other systems and are only warned about.
+func ensureGeneratedSecrets(configPath string, config *AdminConfig) {
+	var replaced []string
+	if config.Security.SessionSecret == "" || knownDefaultSecrets[config.Security.SessionSecret] {
+		config.Security.SessionSecret = randomSecret()
+		replaced = append(replaced, "session secret")
+	}
+	if config.API.TokenSecret == "" || knownDefaultSecrets[config.API.TokenSecret] {
+		config.API.TokenSecret = randomSecret()
+		replaced = append(replaced, "token secret")
+	}
+	if len(replaced) == 0 {
+		return
+	}
+
+	config.Metadata.LastModified = time.Now().Format(time.RFC3339)
+	if err := saveAdminConfig(configPath, config); err != nil {
+		log.Printf("⚠️  Generated a random %s but could not save it, it will change on every restart: %v", strings.Join(replaced, " and "), err)
+		return
+	}
+	log.Printf("🔐 Replaced the default %s with randomly generated values", strings.Join(replaced, " and "))
+}
+
+// warnDefaultSecrets logs a banner for every credential still at a shipped default
+func warnDefaultSecrets(config *AdminConfig) {
+	findings := findDefaultSecrets(config)
+	if len(findings) == 0 {
+		return
+	}
+	log.Println("⚠️ ⚠️ ⚠️  SECURITY WARNING: DEFAULT CREDENTIALS IN USE  ⚠️ ⚠️ ⚠️")
+	for _, finding := range findings {
+		name := finding.Kind
+		if finding.ID != "" {
+			name += " " + finding.ID
+		}
+		log.Printf("⚠️    %s is a published default: %s", name, finding.Fix)
+	}
+	log.Println("⚠️    Anyone who knows the defaults can control this annunciator.")
+}
+
+// sessionHandler is the session middleware for the current secrets. It is
+// swapped on rotation rather than rebuilding the router.
+var sessionHandler atomic.Value // gin.HandlerFunc
+
+// newSessionStore signs new cookies with the current secret and accepts
+// cookies signed with any previous one still listed
+func newSessionStore(config *AdminConfig) sessions.Store {
+	current := config.Security.SessionSecret
+	if current == "" {
+		current = randomSecret()
+	}
+	keyPairs := [][]byte{[]byte(current), nil}
+	for _, previous := range config.Security.PreviousSessionSecrets {
+		keyPairs = append(keyPairs, []byte(previous), nil)
+	}
+	return cookie.NewStore(keyPairs...)
+}
+
+// installSessionStore makes the secrets in config the ones used for sessions
+func installSessionStore(config *AdminConfig) {
+	sessionHandler.Store(sessions.Sessions("session", newSessionStore(config)))
+}
+
+// sessionMiddleware runs whichever session store is current
+func sessionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionHandler.Load().(gin.HandlerFunc)(c)
+	}
+}
+
+// requireConfigPermission refuses API keys without the config permission
+func requireConfigPermission(c *gin.Context) bool {
+	value, _ := c.Get("api_key_data")
+	if apiKeyData, ok := value.(*APIKey); ok && !hasAPIPermission(apiKeyData, ScopeConfig) {
+		respondError(c, http.StatusForbidden, ErrCodeForbidden, "This needs an API key with the 'config' permission")
+		return false
+	}
+	return true
+}
+
+// loadSecretsConfig loads admin_config.json for a secrets handler, writing the error response itself
+func loadSecretsConfig(c *gin.Context) (*AdminConfig, bool) {
+	if !requireConfigPermission(c) {
+		return nil, false
+	}
+	config, err := loadAdminConfig(adminConfigPath())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to load admin config")
+		return nil, false
+	}
+	return config, true
+}
+
+func saveSecretsConfig(c *gin.Context, config *AdminConfig) bool {
+	config.Metadata.LastModified = time.Now().Format(time.RFC3339)
+	if err := saveAdminConfig(adminConfigPath(), config); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save admin config")
+		return false
+	}
+	return true
+}
+
+// Handlers
+
+// apiSecretsStatusHandler reports which credentials are still defaults, never the values
+func apiSecretsStatusHandler(c *gin.Context) {
+	config, ok := loadSecretsConfig(c)
+	if !ok {
+		return
+	}
+	findings := findDefaultSecrets(config)
+	respondOK(c, gin.H{
+		"defaults_in_use":          len(findings) > 0,
+		"default_secrets":          findings,
+		"previous_session_secrets": len(config.Security.PreviousSessionSecrets),
+	})
+}
+
+// apiRotateSessionSecretHandler generates a new session secret. The old one
+// keeps validating existing logins until it is retired, so nobody is logged out.
+func apiRotateSessionSecretHandler(c *gin.Context) {
+	config, ok := loadSecretsConfig(c)
+	if !ok {
+		return
+	}
+
+	previous := config.Security.PreviousSessionSecrets
+	if current := config.Security.SessionSecret; current != "" && !knownDefaultSecrets[current] {
+		previous = append([]string{current}, previous...)
+	}
+	if len(previous) > maxPreviousSessionSecrets {
+		previous = previous[:maxPreviousSessionSecrets]
+	}
+	config.Security.SessionSecret = randomSecret()
+	config.Security.PreviousSessionSecrets = previous
+
+	if !saveSecretsConfig(c, config) {
+		return
+	}
+	app.Config.SessionSecret = config.Security.SessionSecret
+	installSessionStore(config)
+
+	log.Printf("🔐 Session secret rotated (%d previous secret(s) still accepted)", len(previous))
+	respondSuccess(c, http.StatusOK, "Session secret rotated. Existing logins stay valid until the previous secret is retired.", gin.H{
+		"previous_session_secrets": len(previous),
+	})
+}
+
+// apiRetireSessionSecretsHandler stops accepting cookies signed with previous
+// session secrets, logging out anyone who has not logged in since the rotation
+func apiRetireSessionSecretsHandler(c *gin.Context) {
+	config, ok := loadSecretsConfig(c)
+	if !ok {
+		return
+	}
+	retired := len(config.Security.PreviousSessionSecrets)
+	config.Security.PreviousSessionSecrets = nil
+	if !saveSecretsConfig(c, config) {
+		return
+	}
+	installSessionStore(config)
+
+	log.Printf("🔐 Retired %d previous session secret(s)", retired)
+	respondSuccess(c, http.StatusOK, fmt.Sprintf("Retired %d previous session secret(s)", retired), gin.H{
+		"retired": retired,
+	})
+}
+
+// apiRotateTokenSecretHandler generates a new bearer token signing secret,
+// revoking every token issued so far
+func apiRotateTokenSecretHandler(c *gin.Context) {
+	config, ok := loadSecretsConfig(c)
+	if !ok {
+		return
+	}
+	config.API.TokenSecret = randomSecret()
+	if !saveSecretsConfig(c, config) {
+		return
+	}
+	log.Printf("🔐 Token secret rotated, all bearer tokens revoked")
+	respondSuccess(c, http.StatusOK, "Token secret rotated. Previously issued bearer tokens are no longer valid.", nil)
+}
+
+// apiRotateWebhookSecretHandler generates a new webhook signing secret. It is
+// returned so it can be given to the webhook receivers.
+func apiRotateWebhookSecretHandler(c *gin.Context) {
+	config, ok := loadSecretsConfig(c)
+	if !ok {
+		return
+	}
+	config.API.WebhookSecret = randomSecret()
+	if !saveSecretsConfig(c, config) {
+		return
+	}
+	log.Printf("🔐 Webhook secret rotated")
+	respondSuccess(c, http.StatusOK, "Webhook secret rotated. Update your webhook receivers with the new secret.", gin.H{
+		"webhook_secret": config.API.WebhookSecret,
+	})
+}
+
+// apiRotateAPIKeyHandler replaces an API key with a newly generated one,
+// keeping its name, permissions and limits. The new key is only shown here.
+func apiRotateAPIKeyHandler(c *gin.Context) {
+	config, ok := loadSecretsConfig(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("id")
+	var apiKey *APIKey
+	for i := range config.APIKeys {
+		if config.APIKeys[i].ID == id {
+			apiKey = &config.APIKeys[i]
+			break
+		}
+	}
+	if apiKey == nil {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "API key '"+id+"' not found")
+		return
+	}
+
+	apiKey.Key = generateAPIKey()
+	apiKey.LastUsed = ""
+	rotated := *apiKey
+	if !saveSecretsConfig(c, config) {
+		return
+	}
+	if len(config.APIKeys) > 0 && config.APIKeys[0].ID == id {
+		app.Config.APIKey = rotated.Key
+	}
+
+	log.Printf("🔐 API key %s rotated", id)
+	respondSuccess(c, http.StatusOK, "API key rotated. Store the new key now, it is not shown again.", gin.H{
+		"api_key": gin.H{
+			"id":   rotated.ID,
+			"name": rotated.Name,
+			"key":  rotated.Key,
+		},
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// On Windows and macOS the annunciator installs itself with the system's
+// service manager: -install-service registers a Windows service or writes a
+// launchd job that starts at boot with the other flags given, and
+// -uninstall-service removes it. On Linux the systemd unit written by
+// build_linux.sh does the same. A service that restarts exits with an error
+// and leaves starting it again to the service manager; otherwise the process
+// starts a copy of itself, which waits for the lock and the address to come
+// free (see instance_lock.go).
+
+const (
+	serviceName        = "tarr-annunciator"
+	serviceDisplayName = "TARR Annunciator"
+	serviceDescription = "TARR Annunciator Train Announcement System"
+	launchdLabel       = "com.tarr.annunciator"
+
+	// Set in a relaunched process's environment
+	relaunchEnv = "TARR_RELAUNCHED"
+	// How long a relaunched process waits for the lock and the address
+	relaunchWait = 10 * time.Second
+)
+
+// serviceManager names the service manager the process runs under, if any
+var serviceManager string
+
+// serviceArgs are the arguments the service is started with: those given
+// with -install-service less the service flags, pinned to the base directory
+// and config file found now, since a service starts in another directory
+func serviceArgs(args []string, config *StartupConfig) []string {
+	serviceArgs := []string{}
+	given := make(map[string]bool)
+	for _, arg := range args {
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		if strings.HasPrefix(arg, "-") && (name == "install-service" || name == "uninstall-service") {
+			continue
+		}
+		given[name] = true
+		serviceArgs = append(serviceArgs, arg)
+	}
+	if !given["base-dir"] {
+		serviceArgs = append(serviceArgs, "-base-dir", config.BaseDir)
+	}
+	if !given["config"] && config.Source != "" {
+		if source, err := filepath.Abs(config.Source); err == nil {
+			serviceArgs = append(serviceArgs, "-config", source)
+		}
+	}
+	return serviceArgs
+}
+
+// restartUnderServiceManager exits with an error for the service manager to
+// start the annunciator again, and returns false when there isn't one
+func restartUnderServiceManager() bool {
+	if serviceManager == "" {
+		return false
+	}
+	log.Printf("Exiting for %s to start the application again", serviceManager)
+	closeLogging()
+	os.Exit(1)
+	return true
+}
+
+// relaunchApplication starts a copy of the process with the same arguments
+// and exits
+func relaunchApplication() {
+	executable, err := os.Executable()
+	if err != nil {
+		executable = os.Args[0]
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), relaunchEnv+"=1")
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		log.Printf("Failed to relaunch: %v", err)
+		return
+	}
+	os.Exit(0)
+}
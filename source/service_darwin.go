@@ -0,0 +1,126 @@
+//go:build darwin
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Installed as root the launchd job is a daemon in /Library/LaunchDaemons,
+// started at boot; otherwise it is an agent in ~/Library/LaunchAgents,
+// started when the user logs in. launchd starts it again whenever it exits
+// with an error.
+
+// launchdEnv is set in the job's environment so the process knows launchd
+// started it
+const launchdEnv = "TARR_SERVICE"
+
+// runAsService notes whether launchd started the process; launchd needs no
+// service handler, so it always returns false
+func runAsService(run func()) bool {
+	if os.Getenv(launchdEnv) == "launchd" {
+		serviceManager = "launchd"
+	}
+	return false
+}
+
+// launchdPlistPath is where the job is installed
+func launchdPlistPath() (string, error) {
+	if os.Geteuid() == 0 {
+		return filepath.Join("/Library/LaunchDaemons", launchdLabel+".plist"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist"), nil
+}
+
+// launchdPlist generates the job's property list
+func launchdPlist(executable string, args []string, config *StartupConfig) []byte {
+	var buf bytes.Buffer
+	text := func(s string) string {
+		var escaped bytes.Buffer
+		xml.EscapeText(&escaped, []byte(s))
+		return escaped.String()
+	}
+
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	buf.WriteString("<plist version=\"1.0\">\n<dict>\n")
+	fmt.Fprintf(&buf, "\t<key>Label</key>\n\t<string>%s</string>\n", launchdLabel)
+	buf.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range append([]string{executable}, args...) {
+		fmt.Fprintf(&buf, "\t\t<string>%s</string>\n", text(arg))
+	}
+	buf.WriteString("\t</array>\n")
+	fmt.Fprintf(&buf, "\t<key>WorkingDirectory</key>\n\t<string>%s</string>\n", text(config.BaseDir))
+	fmt.Fprintf(&buf, "\t<key>EnvironmentVariables</key>\n\t<dict>\n\t\t<key>%s</key>\n\t\t<string>launchd</string>\n\t</dict>\n", launchdEnv)
+	buf.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	// Start it again when it exits with an error, not when it is stopped
+	buf.WriteString("\t<key>KeepAlive</key>\n\t<dict>\n\t\t<key>SuccessfulExit</key>\n\t\t<false/>\n\t</dict>\n")
+	buf.WriteString("\t<key>ThrottleInterval</key>\n\t<integer>5</integer>\n")
+	output := text(filepath.Join(config.LogDir, "launchd.log"))
+	fmt.Fprintf(&buf, "\t<key>StandardOutPath</key>\n\t<string>%s</string>\n", output)
+	fmt.Fprintf(&buf, "\t<key>StandardErrorPath</key>\n\t<string>%s</string>\n", output)
+	buf.WriteString("</dict>\n</plist>\n")
+	return buf.Bytes()
+}
+
+// installService writes the launchd job with args and loads it
+func installService(config *StartupConfig, args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the executable: %v", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+	path, err := launchdPlistPath()
+	if err != nil {
+		return fmt.Errorf("failed to find the LaunchAgents directory: %v", err)
+	}
+	if fileExists(path) {
+		return fmt.Errorf("%s already exists; remove it first with -uninstall-service", path)
+	}
+	if err := os.MkdirAll(config.LogDir, 0755); err != nil {
+		return fmt.Errorf("failed to create the log directory: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, launchdPlist(executable, args, config), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	fmt.Printf("Wrote %s\n", path)
+
+	if output, err := exec.Command("launchctl", "load", "-w", path).CombinedOutput(); err != nil {
+		return fmt.Errorf("written, but launchctl failed to load it: %v: %s", err, bytes.TrimSpace(output))
+	}
+	fmt.Printf("Loaded %s; logs are in %s\n", launchdLabel, config.LogDir)
+	return nil
+}
+
+// uninstallService unloads and removes the launchd job
+func uninstallService(config *StartupConfig) error {
+	path, err := launchdPlistPath()
+	if err != nil {
+		return fmt.Errorf("failed to find the LaunchAgents directory: %v", err)
+	}
+	if !fileExists(path) {
+		return fmt.Errorf("%s is not installed (no %s)", launchdLabel, path)
+	}
+	if output, err := exec.Command("launchctl", "unload", "-w", path).CombinedOutput(); err != nil {
+		fmt.Printf("Warning: launchctl failed to unload %s: %v: %s\n", launchdLabel, err, bytes.TrimSpace(output))
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove %s: %v", path, err)
+	}
+	fmt.Printf("Removed %s\n", path)
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !windows && !darwin
+
+package main
+
+import "fmt"
+
+// runAsService returns false; elsewhere a service is a systemd unit, which
+// needs no service handler
+func runAsService(run func()) bool {
+	return false
+}
+
+func installService(config *StartupConfig, args []string) error {
+	return fmt.Errorf("-install-service is for Windows and macOS; on Linux, build_linux.sh installs the %s systemd unit", serviceName)
+}
+
+func uninstallService(config *StartupConfig) error {
+	return fmt.Errorf("-uninstall-service is for Windows and macOS; on Linux, use systemctl disable %s", serviceName)
+}
@@ -0,0 +1,141 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// The service starts automatically, a little after the other services so the
+// audio devices are up, and the service manager starts it again 5 seconds
+// after it exits with an error, however often that happens
+const (
+	serviceRestartDelay = 5 * time.Second
+	serviceResetPeriod  = 24 * 60 * 60 // Seconds without failures before the count starts over
+	serviceStopWait     = 30 * time.Second
+)
+
+// annunciatorService runs the annunciator for the service manager
+type annunciatorService struct {
+	run func()
+}
+
+// Execute reports the service running once the annunciator starts, and shuts
+// it down when the service is stopped or Windows shuts down
+func (s *annunciatorService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	go s.run()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for request := range requests {
+		switch request.Cmd {
+		case svc.Interrogate:
+			status <- request.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			log.Println("Service stop requested, cleaning up...")
+			shutdownAnnunciator()
+			return false, 0
+		}
+	}
+	return false, 0
+}
+
+// runAsService runs the annunciator under the service manager when it
+// started the process, returning once the service stops, and returns false
+// straight away otherwise
+func runAsService(run func()) bool {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false
+	}
+	serviceManager = "the Windows service manager"
+	if err := svc.Run(serviceName, &annunciatorService{run: run}); err != nil {
+		log.Fatalf("Service failed: %v", err)
+	}
+	return true
+}
+
+// installService registers the annunciator as a service started at boot with
+// args, and starts it
+func installService(config *StartupConfig, args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the executable: %v", err)
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager (run as Administrator): %v", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("the %s service is already installed; remove it first with -uninstall-service", serviceName)
+	}
+	s, err := m.CreateService(serviceName, executable, mgr.Config{
+		DisplayName:      serviceDisplayName,
+		Description:      serviceDescription,
+		StartType:        mgr.StartAutomatic,
+		DelayedAutoStart: true,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("failed to create the service: %v", err)
+	}
+	defer s.Close()
+
+	// The last action repeats for every failure after the third
+	restart := mgr.RecoveryAction{Type: mgr.ServiceRestart, Delay: serviceRestartDelay}
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{restart, restart, restart}, serviceResetPeriod); err != nil {
+		log.Printf("Warning: Failed to set the service's recovery actions: %v", err)
+	}
+	if err := s.SetRecoveryActionsOnNonCrashFailures(true); err != nil {
+		log.Printf("Warning: Failed to set the service's recovery actions: %v", err)
+	}
+	fmt.Printf("Installed the %s service: %s %v\n", serviceName, executable, args)
+
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("installed, but failed to start the service: %v", err)
+	}
+	fmt.Printf("Started the %s service; logs are in %s\n", serviceName, config.LogDir)
+	return nil
+}
+
+// uninstallService stops and removes the service
+func uninstallService(config *StartupConfig) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager (run as Administrator): %v", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("the %s service is not installed", serviceName)
+	}
+	defer s.Close()
+
+	if status, err := s.Query(); err == nil && status.State != svc.Stopped {
+		if _, err := s.Control(svc.Stop); err != nil {
+			log.Printf("Warning: Failed to stop the service: %v", err)
+		}
+		deadline := time.Now().Add(serviceStopWait)
+		for status.State != svc.Stopped && time.Now().Before(deadline) {
+			time.Sleep(500 * time.Millisecond)
+			if status, err = s.Query(); err != nil {
+				break
+			}
+		}
+	}
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to remove the service: %v", err)
+	}
+	fmt.Printf("Removed the %s service\n", serviceName)
+	return nil
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Until admin_config.json exists the annunciator runs in setup mode: every page
+// redirects to /setup and the API answers 503 until an admin account and API
+// key have been created. Nothing is shipped with working credentials.
+//
+// The setup code is short enough to type, so guessing it is slowed down:
+// setup requests are rate limited per client, and after a few wrong codes
+// from anyone a new code is printed and the old one stops working.
+
+const maxSetupCodeFailures = 5 // Wrong codes before a new one is generated
+
+var (
+	setupMutex sync.Mutex
+	// setupCode is printed to the log on a first start and must be entered in
+	// the wizard, so only someone who can see the console can claim the device
+	setupCode string
+	// setupCodeFailures counts wrong codes entered since setupCode was generated
+	setupCodeFailures int
+
+	// Completing setup takes one or two tries, so this only slows guessing
+	setupRateLimiter = newIPRateLimiter(10, time.Minute)
+
+	errSetupComplete = fmt.Errorf("setup has already been completed")
+)
+
+func adminConfigPath() string {
+	return filepath.Join(app.Config.JSONDir, "admin_config.json")
+}
+
+// setupRequired reports whether the first-run wizard still has to be completed
+func setupRequired() bool {
+	return !fileExists(adminConfigPath())
+}
+
+// newSetupAdminConfig is the config used while in setup mode: the default
+// settings with no accounts or keys and freshly generated secrets. The session
+// secret is kept when setup completes so the cookie store stays valid.
+func newSetupAdminConfig() *AdminConfig {
+	config := getDefaultAdminConfig()
+	config.AdminUsers = []AdminUser{}
+	config.APIKeys = []APIKey{}
+	return config
+}
+
+// randomSecret returns 32 random bytes, URL-safe base64 encoded
+func randomSecret() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatalf("Failed to generate a random secret: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// beginSetup generates the one-time setup code and tells the operator where to go.
+// It also runs if admin_config.json is removed while the server is up.
+func beginSetup() {
+	setupCode = strings.ToUpper(randomToken()[:8])
+	setupCodeFailures = 0
+	log.Println("==================================================================")
+	log.Println("🔧 First start: no admin_config.json found, setup is required")
+	log.Printf("   Open http://%s/setup and enter setup code: %s", localURLHost(app.Config.ListenAddr), setupCode)
+	log.Println("   (or POST the same fields as JSON to /api/v1/setup)")
+	log.Println("==================================================================")
+}
+
+// setupPaths stay reachable while setup is pending
+func setupPathAllowed(path string) bool {
+	switch path {
+	case "/setup", "/api/setup", "/api/v1/setup", "/api/status", "/api/v1/status":
+		return true
+	}
+	return strings.HasPrefix(path, "/static/")
+}
+
+// requireSetupComplete sends everything to the setup wizard until it has run
+func requireSetupComplete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if setupPathAllowed(c.Request.URL.Path) || !setupRequired() {
+			c.Next()
+			return
+		}
+		if strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			respondError(c, http.StatusServiceUnavailable, ErrCodeUnavailable, "First-run setup has not been completed. POST to /api/v1/setup or open /setup.")
+			return
+		}
+		c.Redirect(http.StatusFound, "/setup")
+		c.Abort()
+	}
+}
+
+// validatePasswordPolicy checks a password against the configured policy
+func validatePasswordPolicy(config *AdminConfig, password string) error {
+	policy := config.Security.PasswordPolicy
+	if len(password) < policy.MinLength {
+		return fmt.Errorf("must be at least %d characters", policy.MinLength)
+	}
+	hasNumber, hasSpecial := false, false
+	for _, r := range password {
+		switch {
+		case unicode.IsDigit(r):
+			hasNumber = true
+		case !unicode.IsLetter(r) && !unicode.IsSpace(r):
+			hasSpecial = true
+		}
+	}
+	if policy.RequireNumbers && !hasNumber {
+		return fmt.Errorf("must contain a number")
+	}
+	if policy.RequireSpecialChars && !hasSpecial {
+		return fmt.Errorf("must contain a special character")
+	}
+	return nil
+}
+
+// setupRequest is what the wizard form and the setup API accept
+type setupRequest struct {
+	SetupCode     string
+	AdminUsername string
+	AdminPassword string
+	APIKeyName    string
+	AudioDevice   string
+}
+
+// setupCodeMatches checks a code against the current one. Caller must hold
+// setupMutex.
+func setupCodeMatches(code string) bool {
+	return setupCode != "" && subtle.ConstantTimeCompare([]byte(strings.ToUpper(strings.TrimSpace(code))), []byte(setupCode)) == 1
+}
+
+func validateSetupRequest(request setupRequest, config *AdminConfig) []FieldError {
+	var details []FieldError
+	if !setupCodeMatches(request.SetupCode) {
+		details = append(details, FieldError{Field: "setup_code", Message: "does not match the code printed in the server log"})
+	}
+	if request.AdminUsername == "" {
+		details = append(details, FieldError{Field: "admin_username", Message: "is required"})
+	} else if strings.ContainsAny(request.AdminUsername, " \t\r\n") {
+		details = append(details, FieldError{Field: "admin_username", Message: "must not contain spaces"})
+	}
+	if err := validatePasswordPolicy(config, request.AdminPassword); err != nil {
+		details = append(details, FieldError{Field: "admin_password", Message: err.Error()})
+	} else if request.AdminPassword == request.AdminUsername {
+		details = append(details, FieldError{Field: "admin_password", Message: "must differ from the username"})
+	}
+	if request.AudioDevice != "" && request.AudioDevice != "default" {
+		known := false
+		for _, device := range getAudioDevices() {
+			if device.ID == request.AudioDevice {
+				known = true
+				break
+			}
+		}
+		if !known {
+			details = append(details, FieldError{Field: "audio_device", Message: "unknown audio device '" + request.AudioDevice + "'"})
+		}
+	}
+	return details
+}
+
+// completeSetup writes admin_config.json with the new account, a generated API
+// key and random secrets, then applies it to the running server. The returned
+// API key is only ever shown in the setup response. clientIP is logged when
+// too many wrong codes have been entered.
+func completeSetup(request setupRequest, clientIP string) (*APIKey, []FieldError, error) {
+	setupMutex.Lock()
+	defer setupMutex.Unlock()
+
+	if !setupRequired() {
+		return nil, nil, errSetupComplete
+	}
+	if setupCode == "" {
+		beginSetup()
+	}
+
+	config := newSetupAdminConfig()
+	config.Security.SessionSecret = app.Config.SessionSecret
+	if details := validateSetupRequest(request, config); len(details) > 0 {
+		if !setupCodeMatches(request.SetupCode) {
+			setupCodeFailures++
+			if setupCodeFailures >= maxSetupCodeFailures {
+				log.Printf("⚠️  %d wrong setup codes entered, the last from %s; the setup code has been replaced", setupCodeFailures, clientIP)
+				beginSetup()
+			}
+		}
+		return nil, details, nil
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	config.AdminUsers = []AdminUser{{
+		ID:          "admin-001",
+		Username:    request.AdminUsername,
+		Password:    request.AdminPassword,
+		Role:        "admin",
+		Enabled:     true,
+		CreatedAt:   now,
+		Permissions: []string{"system_config", "user_management", "api_management", "audio_control", "announcements"},
+	}}
+
+	apiKey := APIKey{
+		ID:          "api-001",
+		Name:        request.APIKeyName,
+		Key:         generateAPIKey(),
+		Enabled:     true,
+		CreatedAt:   now,
+		CreatedBy:   "admin-001",
+		Permissions: []string{"announce", "status", "config"},
+	}
+	if apiKey.Name == "" {
+		apiKey.Name = "Default API Key"
+	}
+	apiKey.RateLimit.RequestsPerHour = 1000
+	config.APIKeys = []APIKey{apiKey}
+
+	if err := os.MkdirAll(app.Config.JSONDir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create %s: %v", app.Config.JSONDir, err)
+	}
+	if err := saveAdminConfig(adminConfigPath(), config); err != nil {
+		return nil, nil, fmt.Errorf("failed to save admin config: %v", err)
+	}
+
+	app.Config.AdminUsername = request.AdminUsername
+	app.Config.AdminPassword = request.AdminPassword
+	app.Config.APIKey = apiKey.Key
+	app.Config.APIEnabled = true
+
+	if request.AudioDevice != "" && request.AudioDevice != currentAudioDevice() {
+		if err := setAudioDevice(request.AudioDevice); err != nil {
+			log.Printf("Warning: failed to set audio device during setup: %v", err)
+		} else {
+			if err := reinitAudioForDevice(request.AudioDevice); err != nil {
+				log.Printf("Warning: audio backend re-init after device change failed: %v", err)
+			}
+			setCurrentAudioDevice(request.AudioDevice)
+			clearAudioFallback()
+			persistAudioDevice(request.AudioDevice)
+		}
+	}
+
+	setupCode = ""
+	log.Printf("✓ First-run setup complete: admin user %s and API key %s created", request.AdminUsername, apiKey.ID)
+	return &apiKey, nil, nil
+}
+
+// Handlers
+
+// setupPageHandler shows the first-run wizard
+func setupPageHandler(c *gin.Context) {
+	if !setupRequired() {
+		c.Redirect(http.StatusFound, "/admin/login")
+		return
+	}
+	setupMutex.Lock()
+	if setupCode == "" {
+		beginSetup()
+	}
+	setupMutex.Unlock()
+	c.HTML(http.StatusOK, "setup.html", setupPageData(setupRequest{AudioDevice: currentAudioDevice()}, nil))
+}
+
+func setupPageData(request setupRequest, details []FieldError) gin.H {
+	errors := make(map[string]string)
+	for _, detail := range details {
+		errors[detail.Field] = detail.Message
+	}
+	return gin.H{
+		"request":         request,
+		"errors":          errors,
+		"audio_devices":   getAudioDevices(),
+		"password_policy": getDefaultAdminConfig().Security.PasswordPolicy,
+	}
+}
+
+// setupPostHandler completes setup from the wizard form and shows the API key once
+func setupPostHandler(c *gin.Context) {
+	request := setupRequest{
+		SetupCode:     c.PostForm("setup_code"),
+		AdminUsername: strings.TrimSpace(c.PostForm("admin_username")),
+		AdminPassword: c.PostForm("admin_password"),
+		APIKeyName:    strings.TrimSpace(c.PostForm("api_key_name")),
+		AudioDevice:   c.PostForm("audio_device"),
+	}
+
+	apiKey, details, err := completeSetup(request, c.ClientIP())
+	if err == errSetupComplete {
+		c.Redirect(http.StatusFound, "/admin/login")
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Setup failed: %v", err)
+		return
+	}
+	if len(details) > 0 {
+		request.AdminPassword = ""
+		c.HTML(http.StatusBadRequest, "setup.html", setupPageData(request, details))
+		return
+	}
+
+	c.HTML(http.StatusOK, "setup.html", gin.H{
+		"complete":       true,
+		"admin_username": request.AdminUsername,
+		"api_key":        apiKey.Key,
+	})
+}
+
+// apiSetupStatusHandler tells clients whether setup is still pending
+func apiSetupStatusHandler(c *gin.Context) {
+	devices := []AudioDevice{}
+	required := setupRequired()
+	if required {
+		devices = getAudioDevices()
+	}
+	respondOK(c, gin.H{
+		"setup_required": required,
+		"audio_devices":  devices,
+	})
+}
+
+// apiSetupHandler completes setup from JSON and returns the new API key
+func apiSetupHandler(c *gin.Context) {
+	data, ok := bindRequestData(c, "setup_code", "admin_username", "admin_password", "api_key_name", "audio_device")
+	if !ok {
+		return
+	}
+	field := func(name string) string {
+		value, _ := data[name].(string)
+		return value
+	}
+	request := setupRequest{
+		SetupCode:     field("setup_code"),
+		AdminUsername: strings.TrimSpace(field("admin_username")),
+		AdminPassword: field("admin_password"),
+		APIKeyName:    strings.TrimSpace(field("api_key_name")),
+		AudioDevice:   field("audio_device"),
+	}
+
+	apiKey, details, err := completeSetup(request, c.ClientIP())
+	if err == errSetupComplete {
+		respondError(c, http.StatusConflict, ErrCodeConflict, "Setup has already been completed")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	if len(details) > 0 {
+		respondValidationError(c, "Invalid setup request", details...)
+		return
+	}
+
+	respondSuccess(c, http.StatusCreated, "Setup complete. Store the API key now, it is not shown again.", gin.H{
+		"admin_username": request.AdminUsername,
+		"api_key": gin.H{
+			"id":   apiKey.ID,
+			"name": apiKey.Name,
+			"key":  apiKey.Key,
+		},
+		"audio_device": currentAudioDevice(),
+	})
+}
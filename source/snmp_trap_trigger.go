@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The SNMP trap trigger listens for SNMPv1 and v2c traps (and v2c informs,
+// which are acknowledged) from equipment such as UPSes and generators. Each
+// entry of its mapping table matches a trap or variable OID, optionally with a
+// value, and queues an announcement and/or sends a notification. Every trap is
+// also published to the trigger rules: field "trap_oid" carries the trap's
+// OID, and each variable is published with its OID as the field.
+
+const (
+	snmpTrapOID      = "1.3.6.1.6.3.1.1.4.1.0" // snmpTrapOID.0, the trap identity in v2c traps
+	snmpSysUpTimeOID = "1.3.6.1.2.1.1.3.0"
+	maxRecentTraps   = 20
+)
+
+// SNMPTrapTriggerConfig is the SNMP trap trigger's settings in triggers.json
+type SNMPTrapTriggerConfig struct {
+	Listen      string            `json:"listen"`                // UDP address, default 0.0.0.0:162
+	Communities []string          `json:"communities,omitempty"` // accepted communities; empty accepts any
+	Mappings    []SNMPTrapMapping `json:"mappings"`
+}
+
+// SNMPTrapMapping maps a trap onto an announcement and/or a notification.
+// Text fields may use {value}, {oid}, {trap_oid} and {agent}.
+type SNMPTrapMapping struct {
+	ID           string                   `json:"id"`
+	OID          string                   `json:"oid"`             // the trap OID, or a variable OID in the trap
+	Value        string                   `json:"value,omitempty"` // for a variable OID, only this value matches
+	Announcement *TriggerRuleAnnouncement `json:"announcement,omitempty"`
+	Priority     string                   `json:"priority,omitempty"`
+	Notify       bool                     `json:"notify"`
+	Severity     string                   `json:"severity,omitempty"` // critical or warning
+	Title        string                   `json:"title,omitempty"`
+	Message      string                   `json:"message,omitempty"`
+}
+
+// SNMPVarBind is one variable carried by a trap
+type SNMPVarBind struct {
+	OID   string `json:"oid"`
+	Value string `json:"value"`
+}
+
+// SNMPTrap is a received trap
+type SNMPTrap struct {
+	Agent      string        `json:"agent"`
+	Version    string        `json:"version"`
+	Community  string        `json:"community"`
+	TrapOID    string        `json:"trap_oid"`
+	VarBinds   []SNMPVarBind `json:"varbinds"`
+	ReceivedAt time.Time     `json:"received_at"`
+	Matched    []string      `json:"matched"` // mapping IDs
+}
+
+// SNMPTrapTrigger receives SNMP traps on a UDP port
+type SNMPTrapTrigger struct {
+	ID      string
+	Name    string
+	Enabled bool
+	Config  SNMPTrapTriggerConfig
+
+	mutex    sync.Mutex
+	conn     net.PacketConn
+	done     chan struct{}
+	received int
+	rejected int
+	recent   []SNMPTrap
+	matches  map[string]int
+}
+
+// newSNMPTrapTriggerFromDefinition builds an SNMP trap trigger from triggers.json
+func newSNMPTrapTriggerFromDefinition(definition TriggerDefinition) (Trigger, error) {
+	t := &SNMPTrapTrigger{
+		ID:      definition.ID,
+		Name:    definition.Name,
+		Enabled: definition.Enabled,
+		Config:  SNMPTrapTriggerConfig{Listen: "0.0.0.0:162"},
+		matches: map[string]int{},
+	}
+	if t.Name == "" {
+		t.Name = definition.ID
+	}
+	if len(definition.Settings) > 0 {
+		if err := t.Configure(definition.Settings); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// validate checks the listen address and the mapping table
+func (c SNMPTrapTriggerConfig) validate() error {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("listen must be host:port: %v", err)
+	}
+	seen := map[string]bool{}
+	for _, mapping := range c.Mappings {
+		if !catalogIDPattern.MatchString(mapping.ID) || seen[mapping.ID] {
+			return fmt.Errorf("mapping id '%s' is missing, malformed or duplicated", mapping.ID)
+		}
+		seen[mapping.ID] = true
+		if !isNumericOID(mapping.OID) {
+			return fmt.Errorf("mapping '%s': oid must be numeric, e.g. 1.3.6.1.4.1.318.0.5", mapping.ID)
+		}
+		if mapping.Announcement == nil && !mapping.Notify {
+			return fmt.Errorf("mapping '%s' needs an announcement or notify", mapping.ID)
+		}
+		if mapping.Announcement != nil {
+			if details := validateTriggerAnnouncement("", *mapping.Announcement, mapping.Priority); len(details) > 0 {
+				return fmt.Errorf("mapping '%s': %s %s", mapping.ID, details[0].Field, details[0].Message)
+			}
+		}
+		switch mapping.Severity {
+		case "", "critical", "warning":
+		default:
+			return fmt.Errorf("mapping '%s': severity must be critical or warning", mapping.ID)
+		}
+	}
+	return nil
+}
+
+func isNumericOID(oid string) bool {
+	parts := strings.Split(strings.TrimPrefix(oid, "."), ".")
+	if len(parts) < 2 {
+		return false
+	}
+	for _, part := range parts {
+		if _, err := strconv.ParseUint(part, 10, 32); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Start opens the UDP port and handles traps until stopped
+func (t *SNMPTrapTrigger) Start() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.conn != nil {
+		return nil
+	}
+	conn, err := net.ListenPacket("udp", t.Config.Listen)
+	if err != nil {
+		return fmt.Errorf("cannot listen for SNMP traps on %s: %v", t.Config.Listen, err)
+	}
+	t.conn, t.done, t.Enabled = conn, make(chan struct{}), true
+	log.Printf("SNMP trap trigger '%s' listening on %s", t.Name, conn.LocalAddr())
+
+	go func(conn net.PacketConn, done chan struct{}) {
+		defer close(done)
+		buffer := make([]byte, 65535)
+		for {
+			n, from, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return // closed by Stop
+			}
+			packet := append([]byte{}, buffer[:n]...)
+			// One malformed trap mustn't stop the listener
+			runRecovered("SNMP trap trigger '"+t.Name+"'", func() { t.receive(conn, from, packet) })
+		}
+	}(conn, t.done)
+	return nil
+}
+
+// Stop closes the port and waits for the receiver to exit
+func (t *SNMPTrapTrigger) Stop() {
+	t.mutex.Lock()
+	conn, done := t.conn, t.done
+	t.conn, t.Enabled = nil, false
+	t.mutex.Unlock()
+	if conn == nil {
+		return
+	}
+	conn.Close()
+	<-done
+	log.Printf("SNMP trap trigger '%s' stopped", t.Name)
+}
+
+// Running reports whether the port is open
+func (t *SNMPTrapTrigger) Running() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.conn != nil
+}
+
+// Settings returns the settings stored in triggers.json
+func (t *SNMPTrapTrigger) Settings() interface{} {
+	return t.currentConfig()
+}
+
+// currentConfig copies the settings under the lock, since Configure may
+// replace them while traps are arriving
+func (t *SNMPTrapTrigger) currentConfig() SNMPTrapTriggerConfig {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.Config
+}
+
+// Configure replaces the listen address, communities and mappings, reopening
+// the port if it was open
+func (t *SNMPTrapTrigger) Configure(raw json.RawMessage) error {
+	config := t.currentConfig()
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return err
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	wasRunning := t.Running()
+	if wasRunning {
+		t.Stop()
+	}
+	t.mutex.Lock()
+	t.Config = config
+	t.mutex.Unlock()
+	if wasRunning {
+		return t.Start()
+	}
+	return nil
+}
+
+// Status describes the listener, counters and the most recent traps
+func (t *SNMPTrapTrigger) Status() map[string]interface{} {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	recent := append([]SNMPTrap{}, t.recent...)
+	matches := make(map[string]int, len(t.matches))
+	for id, count := range t.matches {
+		matches[id] = count
+	}
+	return map[string]interface{}{
+		"id":           t.ID,
+		"name":         t.Name,
+		"enabled":      t.Enabled,
+		"running":      t.conn != nil,
+		"listen":       t.Config.Listen,
+		"received":     t.received,
+		"rejected":     t.rejected,
+		"matches":      matches,
+		"recent_traps": recent,
+	}
+}
+
+// Test handles a made-up trap. The value is a trap OID, optionally followed by
+// variables: "1.3.6.1.4.1.318.0.5 1.3.6.1.2.1.33.1.2.1.0=2".
+func (t *SNMPTrapTrigger) Test(value string) (map[string]interface{}, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || !isNumericOID(fields[0]) {
+		return nil, fmt.Errorf("value must be a trap OID, optionally followed by oid=value pairs")
+	}
+	trap := SNMPTrap{Agent: "test", Version: "test", TrapOID: strings.TrimPrefix(fields[0], "."), ReceivedAt: time.Now()}
+	for _, field := range fields[1:] {
+		oid, varValue, ok := strings.Cut(field, "=")
+		if !ok || !isNumericOID(oid) {
+			return nil, fmt.Errorf("'%s' is not an oid=value pair", field)
+		}
+		trap.VarBinds = append(trap.VarBinds, SNMPVarBind{OID: strings.TrimPrefix(oid, "."), Value: varValue})
+	}
+	trap = t.handle(trap)
+	return map[string]interface{}{"trap": trap}, nil
+}
+
+// receive decodes one datagram, acknowledges informs and handles the trap
+func (t *SNMPTrapTrigger) receive(conn net.PacketConn, from net.Addr, packet []byte) {
+	trap, response, err := decodeSNMPTrap(packet)
+	if communities := t.currentConfig().Communities; err == nil && len(communities) > 0 && !containsString(communities, trap.Community) {
+		err = fmt.Errorf("community not accepted")
+	}
+	if err != nil {
+		t.mutex.Lock()
+		t.rejected++
+		t.mutex.Unlock()
+		log.Printf("SNMP trap trigger '%s' rejected a packet from %s: %v", t.Name, from, err)
+		return
+	}
+	if response != nil {
+		conn.WriteTo(response, from)
+	}
+	if host, _, err := net.SplitHostPort(from.String()); err == nil && trap.Agent == "" {
+		trap.Agent = host
+	}
+	trap.ReceivedAt = time.Now()
+	t.handle(trap)
+}
+
+// handle runs a trap through the mapping table and publishes it to the rules
+func (t *SNMPTrapTrigger) handle(trap SNMPTrap) SNMPTrap {
+	log.Printf("SNMP trap %s from %s (%d variables)", trap.TrapOID, trap.Agent, len(trap.VarBinds))
+	trap.Matched = []string{}
+	for _, mapping := range t.currentConfig().Mappings {
+		value, matched := mapping.match(trap)
+		if !matched {
+			continue
+		}
+		trap.Matched = append(trap.Matched, mapping.ID)
+		t.act(mapping, trap, value)
+	}
+
+	t.mutex.Lock()
+	t.received++
+	for _, id := range trap.Matched {
+		t.matches[id]++
+	}
+	t.recent = append([]SNMPTrap{trap}, t.recent...)
+	if len(t.recent) > maxRecentTraps {
+		t.recent = t.recent[:maxRecentTraps]
+	}
+	t.mutex.Unlock()
+
+	publishTriggerEvent(TriggerEvent{Source: t.ID, Type: "snmp_trap", Field: "trap_oid", Value: trap.TrapOID, Time: trap.ReceivedAt})
+	for _, varBind := range trap.VarBinds {
+		publishTriggerEvent(TriggerEvent{Source: t.ID, Type: "snmp_trap", Field: varBind.OID, Value: varBind.Value, Time: trap.ReceivedAt})
+	}
+	return trap
+}
+
+// match reports whether a trap matches the mapping, and the matched value
+func (m SNMPTrapMapping) match(trap SNMPTrap) (string, bool) {
+	oid := strings.TrimPrefix(m.OID, ".")
+	if oid == trap.TrapOID && m.Value == "" {
+		return trap.TrapOID, true
+	}
+	for _, varBind := range trap.VarBinds {
+		if varBind.OID == oid && (m.Value == "" || strings.EqualFold(varBind.Value, m.Value)) {
+			return varBind.Value, true
+		}
+	}
+	return "", false
+}
+
+// act queues the mapping's announcement and sends its notification
+func (t *SNMPTrapTrigger) act(mapping SNMPTrapMapping, trap SNMPTrap, value string) {
+	replacer := strings.NewReplacer("{value}", value, "{oid}", mapping.OID, "{trap_oid}", trap.TrapOID, "{agent}", trap.Agent)
+
+	if mapping.Announcement != nil {
+		parameters := map[string]interface{}{
+			"trigger_source": "SNMP_TRAP:" + mapping.ID,
+			"trigger_value":  value,
+		}
+		for key, parameter := range mapping.Announcement.Parameters {
+			parameters[key] = replacer.Replace(parameter)
+		}
+		priority := mapping.Priority
+		if priority == "" {
+			priority = "high"
+		}
+		announcement, err := queueTriggerAnnouncement(t.ID, AnnouncementType(mapping.Announcement.Type), ParsePriority(priority), parameters)
+		if isTriggerRateLimited(err) {
+			log.Printf("SNMP trap announcement for '%s' held back: %v", mapping.ID, err)
+		} else if err != nil {
+			log.Printf("Failed to queue SNMP trap announcement for '%s': %v", mapping.ID, err)
+		} else {
+			log.Printf("Queued SNMP trap announcement for '%s' (ID: %s)", mapping.ID, announcement.ID)
+		}
+	}
+
+	if mapping.Notify {
+		severity, title, message := mapping.Severity, mapping.Title, mapping.Message
+		if severity == "" {
+			severity = "warning"
+		}
+		if title == "" {
+			title = "SNMP trap: " + mapping.ID
+		}
+		if message == "" {
+			message = "Trap {trap_oid} from {agent}: {oid} = {value}"
+		}
+		notify(NotifySNMPTrap, mapping.ID+"|"+trap.Agent, severity, replacer.Replace(title), replacer.Replace(message))
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// SNMP decoding, using the BER helpers in ber.go
+
+// snmpValueString renders a variable's value as text
+func snmpValueString(value berElement) string {
+	switch value.tag {
+	case 0x02: // INTEGER
+		return strconv.Itoa(berParseInt(value.content))
+	case 0x04: // OCTET STRING
+		return string(value.content)
+	case 0x05: // NULL
+		return ""
+	case 0x06: // OBJECT IDENTIFIER
+		return snmpOID(value.content)
+	case 0x40: // IpAddress
+		if len(value.content) == 4 {
+			return net.IP(value.content).String()
+		}
+	case 0x41, 0x42, 0x43, 0x46: // Counter32, Gauge32, TimeTicks, Counter64
+		var n uint64
+		for _, b := range value.content {
+			n = n<<8 | uint64(b)
+		}
+		return strconv.FormatUint(n, 10)
+	}
+	return fmt.Sprintf("%x", value.content)
+}
+
+func snmpOID(content []byte) string {
+	if len(content) == 0 {
+		return ""
+	}
+	parts := []string{strconv.Itoa(int(content[0]) / 40), strconv.Itoa(int(content[0]) % 40)}
+	var n uint64
+	for _, b := range content[1:] {
+		n = n<<7 | uint64(b&0x7f)
+		if b&0x80 == 0 {
+			parts = append(parts, strconv.FormatUint(n, 10))
+			n = 0
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// snmpChildren checks an element has at least count children with the given tags
+func snmpChildren(element berElement, tags ...byte) error {
+	if len(element.children) < len(tags) {
+		return fmt.Errorf("malformed SNMP packet")
+	}
+	for i, tag := range tags {
+		if element.children[i].tag != tag {
+			return fmt.Errorf("malformed SNMP packet: expected tag 0x%02x, got 0x%02x", tag, element.children[i].tag)
+		}
+	}
+	return nil
+}
+
+// decodeSNMPTrap decodes an SNMPv1 Trap, or an SNMPv2c Trap or InformRequest.
+// For an inform, whose sender waits for an acknowledgement, response is the
+// Response packet to send back.
+func decodeSNMPTrap(packet []byte) (trap SNMPTrap, response []byte, err error) {
+	message, err := berRead(bufio.NewReader(bytes.NewReader(packet)))
+	if err != nil {
+		return trap, nil, err
+	}
+	if message.tag != 0x30 {
+		return trap, nil, fmt.Errorf("not an SNMP message")
+	}
+	if err := snmpChildren(message, 0x02, 0x04); err != nil || len(message.children) != 3 {
+		return trap, nil, fmt.Errorf("malformed SNMP message")
+	}
+	version := berParseInt(message.children[0].content)
+	trap.Community = string(message.children[1].content)
+	pdu := message.children[2]
+
+	var varBinds berElement
+	switch {
+	case version == 0 && pdu.tag == 0xa4:
+		trap.Version = "v1"
+		if err := snmpChildren(pdu, 0x06, 0x40, 0x02, 0x02, 0x43, 0x30); err != nil {
+			return trap, nil, err
+		}
+		if agent := pdu.children[1].content; len(agent) == 4 {
+			trap.Agent = net.IP(agent).String()
+		}
+		// RFC 3584: generic traps map onto snmpTraps, enterprise traps onto enterprise.0.specific
+		generic, specific := berParseInt(pdu.children[2].content), berParseInt(pdu.children[3].content)
+		if generic == 6 {
+			trap.TrapOID = fmt.Sprintf("%s.0.%d", snmpOID(pdu.children[0].content), specific)
+		} else {
+			trap.TrapOID = fmt.Sprintf("1.3.6.1.6.3.1.1.5.%d", generic+1)
+		}
+		varBinds = pdu.children[5]
+	case version == 1 && (pdu.tag == 0xa7 || pdu.tag == 0xa6):
+		trap.Version = "v2c"
+		if err := snmpChildren(pdu, 0x02, 0x02, 0x02, 0x30); err != nil {
+			return trap, nil, err
+		}
+		if pdu.tag == 0xa6 {
+			// A Response repeats the inform's request ID and variables
+			response = berEncode(0x30, concatBytes(
+				berEncode(0x02, message.children[0].content),
+				berEncode(0x04, message.children[1].content),
+				berEncode(0xa2, pdu.content),
+			))
+		}
+		varBinds = pdu.children[3]
+	default:
+		return trap, nil, fmt.Errorf("not an SNMPv1 or v2c trap (version %d, PDU 0x%02x)", version, pdu.tag)
+	}
+
+	for _, varBind := range varBinds.children {
+		if err := snmpChildren(varBind, 0x06); err != nil || len(varBind.children) != 2 {
+			return trap, nil, fmt.Errorf("malformed SNMP variable")
+		}
+		oid := snmpOID(varBind.children[0].content)
+		switch oid {
+		case snmpTrapOID:
+			trap.TrapOID = snmpOID(varBind.children[1].content)
+		case snmpSysUpTimeOID:
+		default:
+			trap.VarBinds = append(trap.VarBinds, SNMPVarBind{OID: oid, Value: snmpValueString(varBind.children[1])})
+		}
+	}
+	if trap.TrapOID == "" {
+		return trap, nil, fmt.Errorf("trap has no snmpTrapOID")
+	}
+	return trap, response, nil
+}
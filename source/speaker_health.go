@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SpeakerTestTarget is one zone/output device exercised by the health test
+type SpeakerTestTarget struct {
+	Name     string `json:"name"`
+	DeviceID string `json:"device_id"` // "" or "default" tests the currently selected device
+}
+
+// SpeakerTestSettings configures the scheduled speaker health test
+type SpeakerTestSettings struct {
+	Enabled     bool                `json:"enabled"`
+	Cron        string              `json:"cron"`
+	Targets     []SpeakerTestTarget `json:"targets"`
+	FrequencyHz float64             `json:"frequency_hz"`
+	DurationMs  int                 `json:"duration_ms"`
+	Level       float64             `json:"level"`
+}
+
+// SpeakerTestResult records the outcome of testing one target
+type SpeakerTestResult struct {
+	Name       string    `json:"name"`
+	DeviceID   string    `json:"device_id"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	TestedAt   time.Time `json:"tested_at"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// SpeakerTestRun is one pass over every configured target
+type SpeakerTestRun struct {
+	Trigger   string              `json:"trigger"` // "schedule" or "manual"
+	StartedAt time.Time           `json:"started_at"`
+	Results   []SpeakerTestResult `json:"results"`
+	Failures  int                 `json:"failures"`
+}
+
+// speakerHealthMonitor keeps recent speaker test runs
+type speakerHealthMonitor struct {
+	mutex       sync.Mutex
+	running     bool
+	history     []*SpeakerTestRun
+	maxHistory  int
+	lastFailure *SpeakerTestResult // Kept after it drops out of the history
+}
+
+var speakerHealth = &speakerHealthMonitor{maxHistory: 30}
+
+// scheduleSpeakerHealthTest adds the health test to the cron scheduler.
+// Called from updateScheduler so it survives schedule reloads.
+func scheduleSpeakerHealthTest() {
+	settings := getAudioSettings().SpeakerTest
+	if !settings.Enabled || settings.Cron == "" {
+		return
+	}
+
+	err := addScheduledJob(settings.Cron, "speaker_test", "", "Speaker health test", settings, func() error {
+		log.Printf("🕐 Scheduled speaker health test triggered")
+		if _, err := runSpeakerHealthTest("schedule"); err != nil {
+			log.Printf("Speaker health test not run: %v", err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error scheduling speaker health test: %v", err)
+	} else {
+		log.Printf("Scheduled: %s - Speaker health test (%d targets)", settings.Cron, len(settings.Targets))
+	}
+}
+
+// runSpeakerHealthTest plays a test tone on every target and records the result
+func runSpeakerHealthTest(trigger string) (*SpeakerTestRun, error) {
+	if !app.AudioEnabled || audioBackend == nil {
+		return nil, fmt.Errorf("audio system not available")
+	}
+
+	speakerHealth.mutex.Lock()
+	if speakerHealth.running {
+		speakerHealth.mutex.Unlock()
+		return nil, fmt.Errorf("speaker health test already running")
+	}
+	speakerHealth.running = true
+	speakerHealth.mutex.Unlock()
+
+	defer func() {
+		speakerHealth.mutex.Lock()
+		speakerHealth.running = false
+		speakerHealth.mutex.Unlock()
+	}()
+
+	settings := getAudioSettings().SpeakerTest
+	targets := settings.Targets
+	if len(targets) == 0 {
+		targets = []SpeakerTestTarget{{Name: "Default output", DeviceID: currentAudioDevice()}}
+	}
+
+	// The targets' zone amplifiers have to be on for the tone to be heard
+	var devices []string
+	for _, target := range targets {
+		devices = append(devices, target.DeviceID)
+	}
+	releaseAmplifiers := powerZoneAmplifiers(zonesForDevices(devices))
+	defer releaseAmplifiers()
+
+	// Wait for any announcement to finish so the test never talks over one
+	globalAudioMutex.Lock()
+	defer globalAudioMutex.Unlock()
+
+	run := &SpeakerTestRun{Trigger: trigger, StartedAt: time.Now()}
+	originalDevice := currentAudioDevice()
+
+	for _, target := range targets {
+		result := testSpeakerTarget(target, settings)
+		if !result.Success {
+			run.Failures++
+			log.Printf("🚨 Speaker health test FAILED for %s (%s): %s", result.Name, result.DeviceID, result.Error)
+			go notify(NotifySpeakerTestFailed, result.Name, "critical", "Speaker test failed",
+				fmt.Sprintf("The speaker health test failed for %s (device %s): %s", result.Name, result.DeviceID, result.Error))
+			failed := result
+			speakerHealth.mutex.Lock()
+			speakerHealth.lastFailure = &failed
+			speakerHealth.mutex.Unlock()
+		} else {
+			log.Printf("✓ Speaker health test passed for %s", result.Name)
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	// Put the original output device back
+	if err := switchOutputDevice(originalDevice); err != nil {
+		log.Printf("⚠️  Failed to restore audio device %s after speaker test: %v", originalDevice, err)
+	}
+
+	speakerHealth.mutex.Lock()
+	speakerHealth.history = append(speakerHealth.history, run)
+	if len(speakerHealth.history) > speakerHealth.maxHistory {
+		speakerHealth.history = speakerHealth.history[len(speakerHealth.history)-speakerHealth.maxHistory:]
+	}
+	speakerHealth.mutex.Unlock()
+
+	log.Printf("Speaker health test complete: %d/%d targets passed", len(run.Results)-run.Failures, len(run.Results))
+	return run, nil
+}
+
+// testSpeakerTarget switches to the target device and plays the test tone
+func testSpeakerTarget(target SpeakerTestTarget, settings SpeakerTestSettings) SpeakerTestResult {
+	start := time.Now()
+	result := SpeakerTestResult{Name: target.Name, DeviceID: target.DeviceID, TestedAt: start}
+	if result.Name == "" {
+		result.Name = target.DeviceID
+	}
+
+	if err := switchOutputDevice(target.DeviceID); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	frequency := settings.FrequencyHz
+	if frequency <= 0 {
+		frequency = 1000
+	}
+	duration := time.Duration(settings.DurationMs) * time.Millisecond
+	if duration <= 0 {
+		duration = 500 * time.Millisecond
+	}
+	level := settings.Level
+	if level <= 0 || level > 1 {
+		level = 0.2
+	}
+
+	sampleRate := audioBackend.SampleRate()
+	tone := newToneStreamer(sampleRate, frequency, level, sampleRate.N(duration))
+
+	// A wedged output never drains the stream, so treat a timeout as a failure
+	cancelChan := make(chan bool, 1)
+	timer := time.AfterFunc(duration+5*time.Second, func() {
+		cancelChan <- true
+	})
+	err := audioBackend.Play(tone, cancelChan)
+	timedOut := !timer.Stop()
+
+	result.DurationMs = time.Since(start).Milliseconds()
+	if timedOut {
+		result.Error = "test tone did not finish playing (output stalled)"
+		return result
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Success = true
+	return result
+}
+
+// switchOutputDevice selects an OS output device and re-opens the backend.
+// The caller must hold globalAudioMutex.
+func switchOutputDevice(deviceID string) error {
+	if deviceID == "" {
+		deviceID = "default"
+	}
+	if err := setAudioDevice(deviceID); err != nil {
+		return fmt.Errorf("failed to select device: %v", err)
+	}
+	if err := audioBackend.Init(deviceID); err != nil {
+		return fmt.Errorf("failed to open device: %v", err)
+	}
+	return nil
+}
+
+// getSpeakerHealthStatus summarises recent speaker test runs
+func getSpeakerHealthStatus() map[string]interface{} {
+	speakerHealth.mutex.Lock()
+	defer speakerHealth.mutex.Unlock()
+
+	settings := getAudioSettings().SpeakerTest
+	status := map[string]interface{}{
+		"enabled": settings.Enabled,
+		"cron":    settings.Cron,
+		"targets": settings.Targets,
+		"running": speakerHealth.running,
+		"history": speakerHealth.history,
+		"healthy": true,
+	}
+
+	if len(speakerHealth.history) > 0 {
+		last := speakerHealth.history[len(speakerHealth.history)-1]
+		status["last_run"] = last
+		status["healthy"] = last.Failures == 0
+	}
+	if speakerHealth.lastFailure != nil {
+		status["last_failure"] = speakerHealth.lastFailure
+	}
+	return status
+}
+
+// Handlers
+
+func getSpeakerHealthHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"status":  getSpeakerHealthStatus(),
+	})
+}
+
+func runSpeakerHealthTestHandler(c *gin.Context) {
+	run, err := runSpeakerHealthTest("manual")
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": run.Failures == 0,
+		"run":     run,
+	})
+}
+
+// API handlers
+
+func apiGetSpeakerHealthHandler(c *gin.Context) {
+	respondOK(c, getSpeakerHealthStatus())
+}
+
+func apiRunSpeakerHealthTestHandler(c *gin.Context) {
+	run, err := runSpeakerHealthTest("manual")
+	if err != nil {
+		respondError(c, http.StatusConflict, ErrCodeConflict, err.Error())
+		return
+	}
+	if run.Failures > 0 {
+		response := newAPIResponse(c, false)
+		response.Error = fmt.Sprintf("%d of %d speaker targets failed", run.Failures, len(run.Results))
+		response.Code = ErrCodeUnavailable
+		response.Data = run
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	respondSuccess(c, http.StatusOK, "All speaker targets passed", run)
+}
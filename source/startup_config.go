@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// StartupConfig holds the settings needed before anything else loads: where
+// files live, where to listen and the initial audio state. Each value is
+// layered: built-in defaults < config file < TARR_* environment < flags.
+type StartupConfig struct {
+	BaseDir        string  `json:"base_dir"`
+	JSONDir        string  `json:"json_dir"`
+	DefaultJSONDir string  `json:"default_json_dir"`
+	StaticDir      string  `json:"static_dir"`
+	MP3Dir         string  `json:"mp3_dir"`
+	AudioLibrary   string  `json:"audio_library"` // Read-only audio roots searched after mp3_dir, in filepath.ListSeparator form
+	TemplatesDir   string  `json:"templates_dir"`
+	LogDir         string  `json:"log_dir"`
+	Port           int     `json:"port"`
+	ListenAddr     string  `json:"listen_addr"` // Overrides port, e.g. "127.0.0.1:8080"
+	Volume         float64 `json:"volume"`
+	AudioDevice    string  `json:"audio_device"`
+	LogSink        string  `json:"log_sink"`     // udp://host:514, tcp://host:514 or a Loki push URL
+	LogSite        string  `json:"log_site"`     // "site" label on shipped logs
+	LogInstance    string  `json:"log_instance"` // "instance" label, defaults to the hostname
+
+	// Source is the config file that was read, if any
+	Source string `json:"-"`
+}
+
+// startupOption ties one setting to its environment variable and flag
+type startupOption struct {
+	flag  string
+	env   string
+	usage string
+	set   func(config *StartupConfig, value string) error
+}
+
+func stringOption(target func(*StartupConfig) *string) func(*StartupConfig, string) error {
+	return func(config *StartupConfig, value string) error {
+		*target(config) = value
+		return nil
+	}
+}
+
+var startupOptions = []startupOption{
+	{"base-dir", "TARR_BASE_DIR", "directory holding json/, static/, templates/ and logs/", stringOption(func(c *StartupConfig) *string { return &c.BaseDir })},
+	{"json-dir", "TARR_JSON_DIR", "configuration directory (default <base>/json)", stringOption(func(c *StartupConfig) *string { return &c.JSONDir })},
+	{"default-json-dir", "TARR_DEFAULT_JSON_DIR", "copy JSON files missing from the configuration directory from here", stringOption(func(c *StartupConfig) *string { return &c.DefaultJSONDir })},
+	{"static-dir", "TARR_STATIC_DIR", "static web files (default <base>/static)", stringOption(func(c *StartupConfig) *string { return &c.StaticDir })},
+	{"mp3-dir", "TARR_MP3_DIR", "announcement audio (default <static>/mp3)", stringOption(func(c *StartupConfig) *string { return &c.MP3Dir })},
+	{"audio-library", "TARR_AUDIO_LIBRARY", "read-only audio libraries searched after the mp3 directory, e.g. an NFS mount (several separated by '" + string(filepath.ListSeparator) + "')", stringOption(func(c *StartupConfig) *string { return &c.AudioLibrary })},
+	{"templates-dir", "TARR_TEMPLATES_DIR", "HTML templates (default <base>/templates)", stringOption(func(c *StartupConfig) *string { return &c.TemplatesDir })},
+	{"log-dir", "TARR_LOG_DIR", "log file directory (default <base>/logs)", stringOption(func(c *StartupConfig) *string { return &c.LogDir })},
+	{"port", "TARR_PORT", "HTTP port", func(c *StartupConfig, value string) error {
+		port, err := strconv.Atoi(value)
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port %q", value)
+		}
+		c.Port = port
+		return nil
+	}},
+	{"listen", "TARR_LISTEN_ADDR", "listen address, overrides the port (e.g. 127.0.0.1:8080)", stringOption(func(c *StartupConfig) *string { return &c.ListenAddr })},
+	{"volume", "TARR_VOLUME", "initial volume, 0.0 to 1.0", func(c *StartupConfig, value string) error {
+		volume, err := strconv.ParseFloat(value, 64)
+		if err != nil || volume < 0 || volume > 1 {
+			return fmt.Errorf("invalid volume %q (0.0 to 1.0)", value)
+		}
+		c.Volume = volume
+		return nil
+	}},
+	{"audio-device", "TARR_AUDIO_DEVICE", "initial audio output device", stringOption(func(c *StartupConfig) *string { return &c.AudioDevice })},
+	{"log-sink", "TARR_LOG_SINK", "ship logs to syslog (udp://host:514, tcp://host:514) or Loki (https://host/loki/api/v1/push)", stringOption(func(c *StartupConfig) *string { return &c.LogSink })},
+	{"log-site", "TARR_LOG_SITE", "site label for shipped logs", stringOption(func(c *StartupConfig) *string { return &c.LogSite })},
+	{"log-instance", "TARR_LOG_INSTANCE", "instance label for shipped logs (default hostname)", stringOption(func(c *StartupConfig) *string { return &c.LogInstance })},
+}
+
+// startupConfigCandidates are checked in order when no config file is named
+func startupConfigCandidates(workingDir string) []string {
+	return []string{
+		filepath.Join(workingDir, "tarr.json"),
+		"/etc/tarr-annunciator/tarr.json",
+	}
+}
+
+// defaultBaseDir is the working directory when it holds json/ or templates/,
+// otherwise the executable's directory when that does, so a binary started by
+// systemd from / still finds the files installed beside it. With neither the
+// working directory is used and the built-in templates and defaults fill in.
+func defaultBaseDir(workingDir string) string {
+	candidates := []string{workingDir}
+	if executable, err := os.Executable(); err == nil {
+		if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+			executable = resolved
+		}
+		candidates = append(candidates, filepath.Dir(executable))
+	}
+	for _, dir := range candidates {
+		if dirExists(filepath.Join(dir, "json")) || dirExists(filepath.Join(dir, "templates")) {
+			return dir
+		}
+	}
+	return workingDir
+}
+
+// loadStartupConfig builds the startup settings from defaults, the config file
+// (-config, TARR_CONFIG or the first of startupConfigCandidates), TARR_*
+// environment variables and command line flags, later layers winning.
+func loadStartupConfig(args []string) (*StartupConfig, error) {
+	workingDir, _ := os.Getwd()
+	config := &StartupConfig{
+		BaseDir:     defaultBaseDir(workingDir),
+		Port:        8080,
+		Volume:      0.7,
+		AudioDevice: "default",
+	}
+
+	flags := flag.NewFlagSet("tarr-annunciator", flag.ContinueOnError)
+	configPath := flags.String("config", os.Getenv("TARR_CONFIG"), "startup config file (JSON)")
+	printConfig := flags.Bool("print-config", false, "print the effective startup config and exit")
+	install := flags.Bool("install-service", false, "install as a Windows service or launchd job started at boot with the other flags given, and exit")
+	uninstall := flags.Bool("uninstall-service", false, "remove the Windows service or launchd job and exit")
+	flagValues := make(map[string]*string)
+	for _, option := range startupOptions {
+		flagValues[option.flag] = flags.String(option.flag, "", option.usage+" ["+option.env+"]")
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Config file
+	path := *configPath
+	if path == "" {
+		for _, candidate := range startupConfigCandidates(workingDir) {
+			if fileExists(candidate) {
+				path = candidate
+				break
+			}
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %v", err)
+		}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		config.Source = path
+	}
+
+	// Environment, then flags given on the command line
+	for _, option := range startupOptions {
+		if value := strings.TrimSpace(os.Getenv(option.env)); value != "" {
+			if err := option.set(config, value); err != nil {
+				return nil, fmt.Errorf("%s: %v", option.env, err)
+			}
+		}
+	}
+	var flagErr error
+	flags.Visit(func(f *flag.Flag) {
+		for _, option := range startupOptions {
+			if option.flag == f.Name && flagErr == nil {
+				if err := option.set(config, *flagValues[option.flag]); err != nil {
+					flagErr = fmt.Errorf("-%s: %v", option.flag, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	config.resolvePaths()
+
+	if *printConfig {
+		data, _ := json.MarshalIndent(config, "", "    ")
+		fmt.Println(string(data))
+		os.Exit(0)
+	}
+	if *install || *uninstall {
+		var err error
+		if *install {
+			err = installService(config, serviceArgs(args, config))
+		} else {
+			err = uninstallService(config)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Service error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	return config, nil
+}
+
+// resolvePaths fills unset directories from the base directory and makes
+// relative ones relative to it rather than to the working directory
+func (c *StartupConfig) resolvePaths() {
+	if abs, err := filepath.Abs(c.BaseDir); err == nil {
+		c.BaseDir = abs
+	}
+	resolve := func(path, fallback string) string {
+		if path == "" {
+			return fallback
+		}
+		if !filepath.IsAbs(path) {
+			return filepath.Join(c.BaseDir, path)
+		}
+		return path
+	}
+
+	c.JSONDir = resolve(c.JSONDir, filepath.Join(c.BaseDir, "json"))
+	c.StaticDir = resolve(c.StaticDir, filepath.Join(c.BaseDir, "static"))
+	c.MP3Dir = resolve(c.MP3Dir, filepath.Join(c.StaticDir, "mp3"))
+	var libraries []string
+	for _, dir := range filepath.SplitList(c.AudioLibrary) {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			libraries = append(libraries, resolve(dir, ""))
+		}
+	}
+	c.AudioLibrary = strings.Join(libraries, string(filepath.ListSeparator))
+	c.TemplatesDir = resolve(c.TemplatesDir, filepath.Join(c.BaseDir, "templates"))
+	c.LogDir = resolve(c.LogDir, filepath.Join(c.BaseDir, "logs"))
+	if c.DefaultJSONDir != "" {
+		c.DefaultJSONDir = resolve(c.DefaultJSONDir, "")
+	}
+	if c.ListenAddr == "" {
+		c.ListenAddr = fmt.Sprintf(":%d", c.Port)
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Units often run from an SD card, and a full card stops config saves and
+// logging at the worst moment. The storage monitor checks free space on
+// every directory the annunciator writes to and applies the retention of
+// each area: logs, saved lightning XML, the audio archive and backups. When
+// free space drops below warn_free_mb a notification goes out; below
+// min_free_mb, writes that can be done without (archive recordings, XML
+// copies, ad-hoc uploads, asset sync downloads) are refused so the space left
+// goes to configuration and logs.
+
+// Storage states, from best to worst
+const (
+	storageOK       = "ok"
+	storageLow      = "low"
+	storageCritical = "critical"
+)
+
+// StorageRetention limits one area; 0 turns a limit off
+type StorageRetention struct {
+	MaxAgeDays int `json:"max_age_days"` // Files older than this are deleted
+	MaxSizeMB  int `json:"max_size_mb"`  // Oldest files are deleted beyond this total
+}
+
+// StorageSettings is the storage section of admin_config.json. The audio
+// archive keeps its own limits in audio_settings.json.
+type StorageSettings struct {
+	CheckIntervalMinutes int              `json:"check_interval_minutes"`
+	WarnFreeMB           int              `json:"warn_free_mb"` // Notify below this
+	MinFreeMB            int              `json:"min_free_mb"`  // Refuse non-essential writes below this
+	Logs                 StorageRetention `json:"logs"`
+	XML                  StorageRetention `json:"xml"`
+	Backups              StorageRetention `json:"backups"`
+	History              StorageRetention `json:"history"` // Announcement history behind the statistics
+	Audit                StorageRetention `json:"audit"`
+}
+
+func getDefaultStorageSettings() StorageSettings {
+	return StorageSettings{
+		CheckIntervalMinutes: 10,
+		WarnFreeMB:           1024,
+		MinFreeMB:            256,
+		Logs:                 StorageRetention{MaxAgeDays: 30},
+		XML:                  StorageRetention{MaxAgeDays: 7},
+		Backups:              StorageRetention{MaxAgeDays: 90, MaxSizeMB: 1024},
+		History:              StorageRetention{MaxAgeDays: maxStatisticsDays},
+		Audit:                StorageRetention{MaxAgeDays: 400},
+	}
+}
+
+// storageArea is a directory whose files are subject to retention
+type storageArea struct {
+	Name      string
+	Dir       string
+	Extension string // Only files with this extension; "" for all
+	Recursive bool
+	Retention StorageRetention
+}
+
+// storageFilesystem is the free space seen from one directory
+type storageFilesystem struct {
+	Path    string `json:"path"`
+	TotalMB uint64 `json:"total_mb"`
+	FreeMB  uint64 `json:"free_mb"`
+	State   string `json:"state"`
+	Error   string `json:"error,omitempty"`
+}
+
+// storageAreaStatus is an area's size and what the last cleanup removed
+type storageAreaStatus struct {
+	Name        string           `json:"name"`
+	Dir         string           `json:"dir"`
+	Files       int              `json:"files"`
+	SizeMB      float64          `json:"size_mb"`
+	Retention   StorageRetention `json:"retention"`
+	LastRemoved int              `json:"last_removed"`
+}
+
+var storageMonitor struct {
+	mutex       sync.Mutex
+	state       string
+	filesystems []storageFilesystem
+	areas       []storageAreaStatus
+	checkedAt   time.Time
+}
+
+// loadStorageSettings reads the storage section of admin_config.json
+func loadStorageSettings() StorageSettings {
+	adminConfig, err := loadAdminConfig(adminConfigPath())
+	if err != nil {
+		return getDefaultStorageSettings()
+	}
+	settings := adminConfig.Storage
+	if settings.CheckIntervalMinutes <= 0 {
+		settings.CheckIntervalMinutes = getDefaultStorageSettings().CheckIntervalMinutes
+	}
+	return settings
+}
+
+// storageAreas lists the directories retention applies to
+func storageAreas(settings StorageSettings) []storageArea {
+	archive := getAudioSettings().Archive
+	return []storageArea{
+		// Only the log files themselves; the log directory also holds the
+		// archive, uploads and the TTS cache
+		{Name: "logs", Dir: app.Config.LogDir, Extension: ".log", Retention: settings.Logs},
+		{Name: "xml", Dir: "xml", Extension: ".xml", Retention: settings.XML},
+		{Name: "audio_archive", Dir: audioArchiveDir(), Extension: ".wav", Recursive: true,
+			Retention: StorageRetention{MaxAgeDays: archive.RetentionDays, MaxSizeMB: archive.MaxSizeMB}},
+		{Name: "backups", Dir: filepath.Join(app.Config.BaseDir, "backups"), Recursive: true, Retention: settings.Backups},
+		{Name: "history", Dir: announcementHistoryDir(), Extension: ".jsonl", Retention: settings.History},
+		{Name: "audit", Dir: auditLogDir(), Extension: ".jsonl", Retention: settings.Audit},
+	}
+}
+
+// storagePaths are the directories whose filesystems are watched
+func storagePaths() []string {
+	paths := []string{app.Config.BaseDir, app.Config.JSONDir, app.Config.LogDir, audioArchiveDir()}
+	var unique []string
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		unique = append(unique, path)
+	}
+	return unique
+}
+
+// existingParent returns path, or its nearest parent that exists, so space can
+// be measured for directories not created yet
+func existingParent(path string) string {
+	path, _ = filepath.Abs(path)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// measureFilesystem reads the free space for a directory and rates it
+func measureFilesystem(path string, settings StorageSettings) storageFilesystem {
+	fs := storageFilesystem{Path: path, State: storageOK}
+	total, free, err := diskUsage(existingParent(path))
+	if err != nil {
+		fs.Error = err.Error()
+		return fs
+	}
+	fs.TotalMB, fs.FreeMB = total>>20, free>>20
+	switch {
+	case settings.MinFreeMB > 0 && fs.FreeMB < uint64(settings.MinFreeMB):
+		fs.State = storageCritical
+	case settings.WarnFreeMB > 0 && fs.FreeMB < uint64(settings.WarnFreeMB):
+		fs.State = storageLow
+	}
+	return fs
+}
+
+// startStorageMonitor applies retention and checks free space now and every
+// check_interval_minutes
+func startStorageMonitor() {
+	go func() {
+		for {
+			settings := loadStorageSettings()
+			checkStorage(settings)
+			time.Sleep(time.Duration(settings.CheckIntervalMinutes) * time.Minute)
+		}
+	}()
+}
+
+// checkStorage runs retention on every area, then measures free space,
+// logging and notifying when a filesystem gets low
+func checkStorage(settings StorageSettings) {
+	var areas []storageAreaStatus
+	for _, area := range storageAreas(settings) {
+		status, err := pruneStorageArea(area)
+		if err != nil {
+			log.Printf("Warning: %s cleanup failed: %v", area.Name, err)
+		}
+		areas = append(areas, status)
+	}
+
+	var filesystems []storageFilesystem
+	state := storageOK
+	for _, path := range storagePaths() {
+		fs := measureFilesystem(path, settings)
+		filesystems = append(filesystems, fs)
+		if storageStateRank(fs.State) > storageStateRank(state) {
+			state = fs.State
+		}
+		switch fs.State {
+		case storageCritical:
+			notify(NotifyDiskSpaceLow, fs.Path, "critical", "Disk almost full",
+				fmt.Sprintf("Only %d MB free for %s (minimum %d MB). Recordings, XML copies, uploads and asset sync are paused.", fs.FreeMB, fs.Path, settings.MinFreeMB))
+		case storageLow:
+			notify(NotifyDiskSpaceLow, fs.Path, "warning", "Disk space low",
+				fmt.Sprintf("%d MB free for %s (warning below %d MB)", fs.FreeMB, fs.Path, settings.WarnFreeMB))
+		}
+	}
+
+	storageMonitor.mutex.Lock()
+	previous := storageMonitor.state
+	storageMonitor.state = state
+	storageMonitor.filesystems = filesystems
+	storageMonitor.areas = areas
+	storageMonitor.checkedAt = time.Now()
+	storageMonitor.mutex.Unlock()
+
+	// The first check only logs when something is wrong
+	if state != previous && !(previous == "" && state == storageOK) {
+		switch state {
+		case storageCritical:
+			log.Printf("❌ Disk space critical: non-essential writes are refused until space is freed")
+		case storageLow:
+			log.Printf("⚠️  Disk space low")
+		default:
+			log.Printf("✓ Disk space back to normal")
+		}
+	}
+}
+
+func storageStateRank(state string) int {
+	switch state {
+	case storageCritical:
+		return 2
+	case storageLow:
+		return 1
+	}
+	return 0
+}
+
+type storedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// pruneStorageArea deletes an area's files beyond its age and size limits,
+// newest kept first. The open log file is never deleted.
+func pruneStorageArea(area storageArea) (storageAreaStatus, error) {
+	status := storageAreaStatus{Name: area.Name, Dir: area.Dir, Retention: area.Retention}
+	current := ""
+	if logFile != nil {
+		current = filepath.Clean(logFile.Name())
+	}
+
+	var files []storedFile
+	err := filepath.Walk(area.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			if path != area.Dir && !area.Recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if area.Extension != "" && !strings.EqualFold(filepath.Ext(path), area.Extension) {
+			return nil
+		}
+		if filepath.Clean(path) == current {
+			return nil
+		}
+		files = append(files, storedFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return status, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	var total int64
+	cutoff := time.Now().AddDate(0, 0, -area.Retention.MaxAgeDays)
+	maxBytes := int64(area.Retention.MaxSizeMB) << 20
+	for _, file := range files {
+		expired := area.Retention.MaxAgeDays > 0 && file.modTime.Before(cutoff)
+		overSize := maxBytes > 0 && total+file.size > maxBytes
+		if !expired && !overSize {
+			total += file.size
+			status.Files++
+			continue
+		}
+		if err := os.Remove(file.path); err != nil {
+			log.Printf("Warning: could not delete %s: %v", file.path, err)
+			total += file.size
+			status.Files++
+			continue
+		}
+		status.LastRemoved++
+	}
+	status.SizeMB = float64(total*10>>20) / 10
+
+	// Remove subdirectories left empty, such as the archive's day directories
+	if area.Recursive {
+		if entries, err := os.ReadDir(area.Dir); err == nil {
+			for _, entry := range entries {
+				if entry.IsDir() {
+					os.Remove(filepath.Join(area.Dir, entry.Name())) // only succeeds when empty
+				}
+			}
+		}
+	}
+
+	if status.LastRemoved > 0 {
+		log.Printf("Storage cleanup: removed %d old %s files, %.1f MB kept", status.LastRemoved, area.Name, status.SizeMB)
+	}
+	return status, nil
+}
+
+// storageWritable returns an error when the filesystem holding dir is below
+// min_free_mb. Call it before writes the annunciator can do without.
+func storageWritable(dir, purpose string) error {
+	settings := loadStorageSettings()
+	if settings.MinFreeMB <= 0 {
+		return nil
+	}
+	fs := measureFilesystem(dir, settings)
+	if fs.State == storageCritical {
+		return fmt.Errorf("%s refused: only %d MB free, below the %d MB minimum", purpose, fs.FreeMB, settings.MinFreeMB)
+	}
+	return nil
+}
+
+// storageStatus returns the last check's results
+func storageStatus() gin.H {
+	storageMonitor.mutex.Lock()
+	defer storageMonitor.mutex.Unlock()
+	settings := loadStorageSettings()
+	return gin.H{
+		"state":        storageMonitor.state,
+		"checked_at":   storageMonitor.checkedAt,
+		"filesystems":  storageMonitor.filesystems,
+		"areas":        storageMonitor.areas,
+		"warn_free_mb": settings.WarnFreeMB,
+		"min_free_mb":  settings.MinFreeMB,
+	}
+}
+
+// lowestFreeMB is the least free space seen in the last check, for fleet reports
+func lowestFreeMB() (uint64, string) {
+	storageMonitor.mutex.Lock()
+	defer storageMonitor.mutex.Unlock()
+	var lowest uint64
+	measured := false
+	for _, fs := range storageMonitor.filesystems {
+		if fs.Error == "" && (!measured || fs.FreeMB < lowest) {
+			lowest, measured = fs.FreeMB, true
+		}
+	}
+	return lowest, storageMonitor.state
+}
+
+// Handlers
+
+// apiStorageStatusHandler returns free space and the size of each area
+func apiStorageStatusHandler(c *gin.Context) {
+	respondOK(c, storageStatus())
+}
+
+// apiStorageCleanupHandler applies retention now
+func apiStorageCleanupHandler(c *gin.Context) {
+	log.Printf("Storage cleanup requested by %s", requestOperator(c))
+	checkStorage(loadStorageSettings())
+	respondSuccess(c, http.StatusOK, "Storage cleanup finished", storageStatus())
+}
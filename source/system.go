@@ -16,11 +16,13 @@ import (
 
 // System information structure
 type SystemInfo struct {
-	Uptime      string `json:"uptime"`
-	MemoryUsage string `json:"memory_usage"`
-	GoVersion   string `json:"go_version"`
-	Platform    string `json:"platform"`
-	Arch        string `json:"arch"`
+	Uptime      string       `json:"uptime"`
+	MemoryUsage string       `json:"memory_usage"`
+	GoVersion   string       `json:"go_version"`
+	Platform    string       `json:"platform"`
+	Arch        string       `json:"arch"`
+	Host        *HostMetrics `json:"host"` // Temperature, throttling, load, disk and network
+	Clock       ClockStatus  `json:"clock"`
 }
 
 // Bluetooth device structure
@@ -48,6 +50,8 @@ func getSystemInfoHandler(c *gin.Context) {
 		GoVersion:   runtime.Version(),
 		Platform:    runtime.GOOS,
 		Arch:        runtime.GOARCH,
+		Host:        collectHostMetrics(),
+		Clock:       currentClockStatus(),
 	}
 
 	c.JSON(http.StatusOK, info)
@@ -85,8 +89,58 @@ func bToMb(b uint64) float64 {
 	return float64(b) / 1024 / 1024
 }
 
+// restartRequest is the optional body of a restart request
+type restartRequest struct {
+	Drain          bool   `json:"drain"`           // Wait for the queue to play out first
+	TimeoutSeconds int    `json:"timeout_seconds"` // Longest wait (default 300)
+	OnTimeout      string `json:"on_timeout"`      // restart (default) or cancel
+}
+
 // Restart Application Handler
 func restartApplicationHandler(c *gin.Context) {
+	var request restartRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error": "Invalid JSON data",
+			})
+			return
+		}
+	}
+
+	if request.Drain {
+		timeout := defaultDrainTimeout
+		if request.TimeoutSeconds != 0 {
+			timeout = time.Duration(request.TimeoutSeconds) * time.Second
+		}
+		if request.OnTimeout == "" {
+			request.OnTimeout = "restart"
+		}
+		if timeout <= 0 || timeout > maxDrainTimeout || (request.OnTimeout != "restart" && request.OnTimeout != "cancel") {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error": "timeout_seconds must be between 1 and 3600 and on_timeout restart or cancel",
+			})
+			return
+		}
+		drain, err := startRestartDrain(requestOperator(c), timeout, request.OnTimeout)
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{
+				"success": false,
+				"error": err.Error(),
+				"drain": drain,
+			})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{
+			"success": true,
+			"message": "Restart will follow once the queue is idle",
+			"drain": drain,
+		})
+		return
+	}
+
 	log.Printf("Application restart requested by admin user")
 	
 	c.JSON(http.StatusOK, gin.H{
@@ -95,32 +149,32 @@ func restartApplicationHandler(c *gin.Context) {
 	})
 
 	// Use a goroutine to restart after a short delay
-	go func() {
-		time.Sleep(2 * time.Second)
-		log.Printf("Restarting application...")
-		
-		if runtime.GOOS == "windows" {
-			// On Windows, we'll use a batch script approach
-			cmd := exec.Command("cmd", "/C", "timeout /T 3 && start", os.Args[0])
-			cmd.Start()
-			os.Exit(0)
-		} else {
-			// Check if this is a Raspberry Pi running in screen
-			if isRaspberryPi() && isRunningInScreen() {
-				log.Printf("Detected Raspberry Pi with screen session, using screen-based restart")
-				restartInScreen()
-			} else if _, err := exec.LookPath("systemctl"); err == nil {
-				// Try systemctl restart for regular Linux systems
-				exec.Command("systemctl", "restart", "tarr-annunciator").Run()
-				os.Exit(0)
-			} else {
-				// Direct restart for other systems
-				cmd := exec.Command(os.Args[0])
-				cmd.Start()
-				os.Exit(0)
-			}
-		}
-	}()
+	go restartApplication()
+}
+
+// restartApplication restarts the process after a short delay, through the
+// service manager, screen or systemd when that is how it was started
+func restartApplication() {
+	time.Sleep(2 * time.Second)
+	log.Printf("Restarting application...")
+	
+	// A Windows service or launchd job exits for its service manager to start it again
+	if restartUnderServiceManager() {
+		return
+	}
+	
+	// Check if this is a Raspberry Pi running in screen
+	if isRaspberryPi() && isRunningInScreen() {
+		log.Printf("Detected Raspberry Pi with screen session, using screen-based restart")
+		restartInScreen()
+	} else if _, err := exec.LookPath("systemctl"); err == nil && runtime.GOOS == "linux" {
+		// Try systemctl restart for regular Linux systems
+		exec.Command("systemctl", "restart", "tarr-annunciator").Run()
+		os.Exit(0)
+	} else {
+		// Direct restart for other systems, Windows and macOS included
+		relaunchApplication()
+	}
 }
 
 // isRaspberryPi checks if the system is a Raspberry Pi
@@ -308,7 +362,7 @@ log_msg "Restart script completed"
 	if err := os.WriteFile(scriptPath, []byte(restartScript), 0755); err != nil {
 		log.Printf("Error creating restart script: %v", err)
 		// Fallback to simple direct restart
-		cmd := exec.Command(os.Args[0])
+		cmd := exec.Command(os.Args[0], os.Args[1:]...)
 		cmd.Dir = workDir
 		if err := cmd.Start(); err != nil {
 			log.Printf("Fallback restart failed: %v", err)
@@ -336,7 +390,7 @@ log_msg "Restart script completed"
 	if err := cmd.Start(); err != nil {
 		log.Printf("Error starting restart script: %v", err)
 		// Final fallback to direct restart
-		fallbackCmd := exec.Command(os.Args[0])
+		fallbackCmd := exec.Command(os.Args[0], os.Args[1:]...)
 		fallbackCmd.Dir = workDir
 		if err := fallbackCmd.Start(); err != nil {
 			log.Printf("All restart methods failed: %v", err)
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The track layout describes the station: its platforms, the tracks beside
+// them and the audio zones (with the speakers in each) that cover them. It is
+// kept in json/track_layout.json. Announcements naming a track are routed to
+// that track's zones (see zone_routing.go), and the track choices on the
+// control pages come from the layout once it lists any tracks.
+
+// LayoutZone is an area of the station covered by a set of speakers
+type LayoutZone struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Speakers  []string `json:"speakers"`   // audio device IDs serving the zone
+	AllTracks bool     `json:"all_tracks"` // also hears every track's announcements, e.g. the concourse
+
+	Amplifier *ZoneAmplifier `json:"amplifier,omitempty"` // GPIO relay powering the zone's amplifier (see zone_amplifier.go)
+}
+
+// LayoutPlatform is a platform and the zones that cover it
+type LayoutPlatform struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	Zones []string `json:"zones,omitempty"`
+}
+
+// LayoutTrack is a track; its ID is the track clip announced (track/<id>.mp3)
+type LayoutTrack struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Platform string   `json:"platform,omitempty"`
+	Zones    []string `json:"zones,omitempty"` // in addition to the platform's zones
+}
+
+// TrackLayout is the contents of track_layout.json
+type TrackLayout struct {
+	Platforms []LayoutPlatform `json:"platforms"`
+	Tracks    []LayoutTrack    `json:"tracks"`
+	Zones     []LayoutZone     `json:"zones"`
+	UpdatedAt time.Time        `json:"updated_at,omitempty"`
+}
+
+var (
+	trackLayout      = &TrackLayout{}
+	trackLayoutMutex sync.RWMutex
+)
+
+// loadTrackLayout reads track_layout.json at startup
+func loadTrackLayout() error {
+	filePath, _ := jsonFilePath("track_layout")
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read track_layout.json: %v", err)
+	}
+
+	var layout TrackLayout
+	if err := json.Unmarshal(data, &layout); err != nil {
+		return fmt.Errorf("failed to parse track_layout.json: %v", err)
+	}
+	if details := layout.validate(); len(details) > 0 {
+		return fmt.Errorf("track_layout.json is invalid: %s %s", details[0].Field, details[0].Message)
+	}
+
+	trackLayoutMutex.Lock()
+	trackLayout = &layout
+	trackLayoutMutex.Unlock()
+	log.Printf("✓ Loaded track layout: %d platforms, %d tracks, %d zones", len(layout.Platforms), len(layout.Tracks), len(layout.Zones))
+	return nil
+}
+
+// getTrackLayout returns the current layout; callers must not modify it
+func getTrackLayout() *TrackLayout {
+	trackLayoutMutex.RLock()
+	defer trackLayoutMutex.RUnlock()
+	return trackLayout
+}
+
+// saveTrackLayout stores a validated layout and makes it current
+func saveTrackLayout(layout *TrackLayout) error {
+	layout.UpdatedAt = time.Now()
+
+	trackLayoutMutex.Lock()
+	if err := saveJSON("track_layout", layout); err != nil {
+		trackLayoutMutex.Unlock()
+		return err
+	}
+	trackLayout = layout
+	trackLayoutMutex.Unlock()
+	log.Printf("Track layout updated: %d platforms, %d tracks, %d zones", len(layout.Platforms), len(layout.Tracks), len(layout.Zones))
+
+	// Zone amplifier relays may have been added, moved or removed
+	syncZoneAmplifiers()
+	return nil
+}
+
+// validate checks IDs are well formed and unique and that every reference resolves
+func (l *TrackLayout) validate() []FieldError {
+	var details []FieldError
+	checkID := func(field, id string, seen map[string]bool) {
+		switch {
+		case !catalogIDPattern.MatchString(id):
+			details = append(details, FieldError{Field: field, Message: "must be 1-64 letters, digits, '-' or '_'"})
+		case seen[id]:
+			details = append(details, FieldError{Field: field, Message: "duplicate id '" + id + "'"})
+		}
+		seen[id] = true
+	}
+	checkName := func(field, name string) {
+		if strings.TrimSpace(name) == "" {
+			details = append(details, FieldError{Field: field, Message: "is required"})
+		}
+	}
+
+	zones := map[string]bool{}
+	for i, zone := range l.Zones {
+		field := fmt.Sprintf("zones[%d]", i)
+		checkID(field+".id", zone.ID, zones)
+		checkName(field+".name", zone.Name)
+		for j, speaker := range zone.Speakers {
+			if strings.TrimSpace(speaker) == "" {
+				details = append(details, FieldError{Field: fmt.Sprintf("%s.speakers[%d]", field, j), Message: "must not be empty"})
+			}
+		}
+		if zone.Amplifier != nil {
+			details = append(details, zone.Amplifier.validate(field+".amplifier")...)
+		}
+	}
+
+	platforms := map[string]bool{}
+	for i, platform := range l.Platforms {
+		field := fmt.Sprintf("platforms[%d]", i)
+		checkID(field+".id", platform.ID, platforms)
+		checkName(field+".name", platform.Name)
+		for j, zone := range platform.Zones {
+			if !zones[zone] {
+				details = append(details, FieldError{Field: fmt.Sprintf("%s.zones[%d]", field, j), Message: "unknown zone '" + zone + "'"})
+			}
+		}
+	}
+
+	tracks := map[string]bool{}
+	for i, track := range l.Tracks {
+		field := fmt.Sprintf("tracks[%d]", i)
+		checkID(field+".id", track.ID, tracks)
+		checkName(field+".name", track.Name)
+		if track.Platform != "" && !platforms[track.Platform] {
+			details = append(details, FieldError{Field: field + ".platform", Message: "unknown platform '" + track.Platform + "'"})
+		}
+		for j, zone := range track.Zones {
+			if !zones[zone] {
+				details = append(details, FieldError{Field: fmt.Sprintf("%s.zones[%d]", field, j), Message: "unknown zone '" + zone + "'"})
+			}
+		}
+	}
+	return details
+}
+
+// warnings lists problems that don't stop the layout being saved
+func (l *TrackLayout) warnings() []string {
+	var warnings []string
+	tracksDef, _ := findCatalogDefinition("tracks")
+	for _, track := range l.Tracks {
+		if !fileExists(tracksDef.audioPath(track.ID)) {
+			warnings = append(warnings, fmt.Sprintf("track '%s' has no announcement clip (%s)", track.ID, tracksDef.audioPath(track.ID)))
+		}
+	}
+	covered := map[string]bool{}
+	for _, platform := range l.Platforms {
+		for _, zone := range platform.Zones {
+			covered[zone] = true
+		}
+	}
+	for _, track := range l.Tracks {
+		for _, zone := range track.Zones {
+			covered[zone] = true
+		}
+	}
+	for _, zone := range l.Zones {
+		if len(zone.Speakers) == 0 {
+			warnings = append(warnings, fmt.Sprintf("zone '%s' has no speakers", zone.ID))
+		}
+		if !covered[zone.ID] && !zone.AllTracks {
+			warnings = append(warnings, fmt.Sprintf("zone '%s' is not used by any platform or track", zone.ID))
+		}
+	}
+	return warnings
+}
+
+// findTrack returns the layout entry for a track ID
+func (l *TrackLayout) findTrack(id string) (LayoutTrack, bool) {
+	for _, track := range l.Tracks {
+		if track.ID == id {
+			return track, true
+		}
+	}
+	return LayoutTrack{}, false
+}
+
+// findZone returns the layout entry for a zone ID
+func (l *TrackLayout) findZone(id string) (LayoutZone, bool) {
+	for _, zone := range l.Zones {
+		if zone.ID == id {
+			return zone, true
+		}
+	}
+	return LayoutZone{}, false
+}
+
+// zonesForTrack returns the zones an announcement for a track plays in: the
+// track's own, its platform's, then every all_tracks zone, without repeats
+func (l *TrackLayout) zonesForTrack(id string) []string {
+	track, ok := l.findTrack(id)
+	if !ok {
+		return nil
+	}
+	zones := append([]string{}, track.Zones...)
+	for _, platform := range l.Platforms {
+		if platform.ID == track.Platform {
+			zones = append(zones, platform.Zones...)
+		}
+	}
+	for _, zone := range l.Zones {
+		if zone.AllTracks {
+			zones = append(zones, zone.ID)
+		}
+	}
+	return uniqueStrings(zones)
+}
+
+// speakersForZones returns the devices serving a set of zones, without repeats
+func (l *TrackLayout) speakersForZones(zones []string) []string {
+	var speakers []string
+	for _, id := range zones {
+		if zone, ok := l.findZone(id); ok {
+			speakers = append(speakers, zone.Speakers...)
+		}
+	}
+	return uniqueStrings(speakers)
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := values[:0:0]
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// trackChoices returns the tracks offered on the control pages: the layout's
+// when it has any, otherwise tracks.json
+func trackChoices() []Track {
+	layout := getTrackLayout()
+	if len(layout.Tracks) == 0 {
+		return loadJSON("tracks", []Track{}).([]Track)
+	}
+	tracks := make([]Track, len(layout.Tracks))
+	for i, track := range layout.Tracks {
+		tracks[i] = Track{ID: track.ID, Name: track.Name}
+	}
+	return tracks
+}
+
+// applyTrackLayoutZones tags an announcement with the zones it plays in. An
+// explicit "zones" list or "zone" wins; otherwise an announcement naming a
+// track gets that track's zones. "zone" is set to the first zone so its
+// lead-in settings apply.
+func applyTrackLayoutZones(parameters map[string]interface{}) {
+	zones := languageList(parameters["zones"])
+	if zone, ok := parameters["zone"].(string); ok && zone != "" && len(zones) == 0 {
+		zones = []string{zone}
+	}
+	if len(zones) == 0 {
+		for _, field := range []string{"track_number", "new_track"} {
+			if track, ok := parameters[field].(string); ok && track != "" {
+				zones = getTrackLayout().zonesForTrack(track)
+				break
+			}
+		}
+	}
+	if len(zones) == 0 {
+		return
+	}
+	parameters["zones"] = zones
+	if zone, ok := parameters["zone"].(string); !ok || zone == "" {
+		parameters["zone"] = zones[0]
+	}
+}
+
+// API handlers
+
+func apiGetTrackLayoutHandler(c *gin.Context) {
+	layout := getTrackLayout()
+	respondOK(c, gin.H{
+		"layout":   layout,
+		"warnings": layout.warnings(),
+	})
+}
+
+func apiPutTrackLayoutHandler(c *gin.Context) {
+	var layout TrackLayout
+	if err := c.ShouldBindJSON(&layout); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	if details := layout.validate(); len(details) > 0 {
+		respondValidationError(c, "Invalid track layout", details...)
+		return
+	}
+	if err := saveTrackLayout(&layout); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save track layout: "+err.Error())
+		return
+	}
+	respondSuccess(c, http.StatusOK, "Track layout saved", gin.H{
+		"layout":   &layout,
+		"warnings": layout.warnings(),
+	})
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gopxl/beep"
+	"github.com/gopxl/beep/mp3"
+	"github.com/gopxl/beep/wav"
+)
+
+// Uploaded recordings (one-off announcements and safety languages) arrive
+// from phones and editing tools at whatever sample rate and level they were
+// made. Before an upload is checked and stored it is transcoded to one format,
+// sample rate and loudness, so a safety recording plays as loud as the
+// catalog clips around it. ffmpeg does the work when it is installed, which
+// also lets M4A, AAC, Ogg and FLAC uploads in. Without it, MP3 and WAV
+// uploads are resampled and levelled in Go and stored as WAV.
+
+// TranscodeSettings controls how uploads are normalized
+type TranscodeSettings struct {
+	Enabled        bool    `json:"enabled"`
+	Format         string  `json:"format"`          // mp3 or wav; WAV without ffmpeg
+	SampleRate     int     `json:"sample_rate"`     // Hz
+	LoudnessLUFS   float64 `json:"loudness_lufs"`   // Target loudness; 0 leaves the level alone
+	FFmpeg         string  `json:"ffmpeg"`          // Path to ffmpeg, looked up on the PATH when empty; "none" never uses it
+	TimeoutSeconds int     `json:"timeout_seconds"` // Longest a single ffmpeg run may take
+}
+
+func getDefaultTranscodeSettings() TranscodeSettings {
+	return TranscodeSettings{
+		Enabled:        true,
+		Format:         "mp3",
+		SampleRate:     44100,
+		LoudnessLUFS:   -16,
+		TimeoutSeconds: 120,
+	}
+}
+
+// Formats only ffmpeg can read
+var transcodeExtensions = map[string]bool{
+	".m4a": true, ".aac": true, ".ogg": true, ".oga": true, ".opus": true, ".flac": true,
+}
+
+// Highest peak the built-in converter raises a recording to, in dBFS
+const transcodePeakLimitDB = -1.0
+
+// ffmpegPath returns the ffmpeg to run, or "" when there is none
+func (s TranscodeSettings) ffmpegPath() string {
+	switch s.FFmpeg {
+	case "none":
+		return ""
+	case "":
+		path, err := exec.LookPath("ffmpeg")
+		if err != nil {
+			return ""
+		}
+		return path
+	}
+	return s.FFmpeg
+}
+
+// checkUploadExtension returns why a recording with extension can't be
+// uploaded, or "" when it can
+func checkUploadExtension(extension string) string {
+	if adhocExtensions[extension] {
+		return ""
+	}
+	settings := getAudioSettings().Transcode
+	if !settings.Enabled || settings.ffmpegPath() == "" {
+		return "must be an MP3 or WAV recording"
+	}
+	if !transcodeExtensions[extension] {
+		return "must be an MP3, WAV, M4A, AAC, Ogg, Opus or FLAC recording"
+	}
+	return ""
+}
+
+// transcodeUpload normalizes the recording at path and returns the new file,
+// next to it and with the target format's extension. The original is removed
+// once the new file is written; on failure it is left for the caller.
+func transcodeUpload(path string) (string, error) {
+	settings := getAudioSettings().Transcode
+	if !settings.Enabled {
+		return path, nil
+	}
+	if settings.SampleRate <= 0 {
+		settings.SampleRate = 44100
+	}
+	ffmpeg := settings.ffmpegPath()
+	format := strings.ToLower(settings.Format)
+	if format != "wav" && format != "mp3" {
+		format = "mp3"
+	}
+	if ffmpeg == "" {
+		format = "wav"
+	}
+	output := strings.TrimSuffix(path, filepath.Ext(path)) + "_normalized." + format
+
+	started := time.Now()
+	var err error
+	if ffmpeg != "" {
+		err = transcodeWithFFmpeg(ffmpeg, settings, path, output, format)
+	} else {
+		err = transcodeBuiltIn(settings, path, output)
+	}
+	if err != nil {
+		os.Remove(output)
+		return "", err
+	}
+	os.Remove(path)
+	log.Printf("🎚️  Transcoded %s to %s at %d Hz in %s", filepath.Ext(path), format, settings.SampleRate, time.Since(started).Round(time.Millisecond))
+	return output, nil
+}
+
+// transcodeWithFFmpeg converts input to output with ffmpeg, levelling it
+// with the loudnorm filter
+func transcodeWithFFmpeg(ffmpeg string, settings TranscodeSettings, input, output, format string) error {
+	args := []string{"-nostdin", "-hide_banner", "-loglevel", "error", "-y", "-i", input, "-vn", "-ar", strconv.Itoa(settings.SampleRate)}
+	if settings.LoudnessLUFS != 0 {
+		args = append(args, "-af", fmt.Sprintf("loudnorm=I=%g:TP=%g", settings.LoudnessLUFS, transcodePeakLimitDB-0.5))
+	}
+	if format == "mp3" {
+		args = append(args, "-c:a", "libmp3lame", "-q:a", "2")
+	} else {
+		args = append(args, "-c:a", "pcm_s16le")
+	}
+	args = append(args, output)
+
+	timeout := time.Duration(settings.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 120 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, ffmpeg, args...).CombinedOutput(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("could not be converted: ffmpeg timed out after %s", timeout)
+		}
+		return fmt.Errorf("could not be converted: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	if info, err := os.Stat(output); err != nil || info.Size() == 0 {
+		return fmt.Errorf("could not be converted: ffmpeg produced no audio")
+	}
+	return nil
+}
+
+// transcodeBuiltIn resamples an MP3 or WAV recording to a 16-bit stereo WAV.
+// The level is set from the recording's RMS, which for speech is close
+// enough to its loudness, without letting the peaks clip. The recording is
+// read twice, measuring then writing, so a long one isn't held in memory.
+func transcodeBuiltIn(settings TranscodeSettings, input, output string) error {
+	streamer, format, err := decodeAudioFile(input)
+	if err != nil {
+		return err
+	}
+	defer streamer.Close()
+	target := beep.SampleRate(settings.SampleRate)
+
+	gain := 1.0
+	if settings.LoudnessLUFS != 0 {
+		var sumSquares, peak float64
+		var count int
+		samples := make([][2]float64, 4096)
+		resampled := beep.Resample(4, format.SampleRate, target, streamer)
+		for {
+			n, ok := resampled.Stream(samples)
+			for _, sample := range samples[:n] {
+				for _, value := range sample {
+					sumSquares += value * value
+					peak = math.Max(peak, math.Abs(value))
+				}
+			}
+			count += n * 2
+			if !ok {
+				break
+			}
+		}
+		if count > 0 && sumSquares > 0 {
+			rmsDB := 10 * math.Log10(sumSquares/float64(count))
+			gain = math.Pow(10, (settings.LoudnessLUFS-rmsDB)/20)
+			if limit := math.Pow(10, transcodePeakLimitDB/20); peak*gain > limit {
+				gain = limit / peak
+			}
+		}
+		if err := streamer.Seek(0); err != nil {
+			return fmt.Errorf("could not be converted: %v", err)
+		}
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	writer.Write(wavFileHeader(target, 0))
+	var dataBytes uint32
+	samples := make([][2]float64, 4096)
+	resampled := beep.Resample(4, format.SampleRate, target, streamer)
+	for {
+		n, ok := resampled.Stream(samples)
+		for i := range samples[:n] {
+			samples[i][0] *= gain
+			samples[i][1] *= gain
+		}
+		if _, err := writer.Write(encodePCM16(samples[:n])); err != nil {
+			return err
+		}
+		dataBytes += uint32(n * 4)
+		if !ok {
+			break
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	// Sizes are only known now
+	_, err = file.WriteAt(wavFileHeader(target, dataBytes), 0)
+	return err
+}
+
+// decodeAudioFile opens an MP3 or WAV file for streaming
+func decodeAudioFile(path string) (beep.StreamSeekCloser, beep.Format, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, beep.Format{}, err
+	}
+	var streamer beep.StreamSeekCloser
+	var format beep.Format
+	if strings.EqualFold(filepath.Ext(path), ".wav") {
+		streamer, format, err = wav.Decode(file)
+	} else {
+		streamer, format, err = mp3.Decode(file)
+	}
+	if err != nil {
+		file.Close()
+		return nil, beep.Format{}, fmt.Errorf("not a valid %s file: %v", strings.TrimPrefix(strings.ToUpper(filepath.Ext(path)), "."), err)
+	}
+	return streamer, format, nil
+}
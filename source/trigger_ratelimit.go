@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Every announcement a trigger queues, whether from its own actions or from a
+// trigger rule, goes through queueTriggerAnnouncement. It enforces the
+// trigger's cooldown_seconds from triggers.json (the minimum time between
+// announcements from that trigger) and refuses an announcement identical to
+// one from the same source that is still queued or playing, so a feed that
+// flaps cannot flood the queue. Emergencies and lightning alerts skip the
+// cooldown, so a change from warning to red alert or all clear is never held
+// back; the duplicate check still applies to them.
+
+// triggerRateLimitError says why a trigger announcement was not queued
+type triggerRateLimitError struct {
+	reason string
+}
+
+func (e *triggerRateLimitError) Error() string {
+	return e.reason
+}
+
+func isTriggerRateLimited(err error) bool {
+	_, limited := err.(*triggerRateLimitError)
+	return limited
+}
+
+// triggerQueueCounters are kept in memory per trigger and reset on restart
+type triggerQueueCounters struct {
+	Queued      int       `json:"queued"`
+	CooledDown  int       `json:"cooled_down"`
+	Duplicates  int       `json:"duplicates"`
+	LastQueued  time.Time `json:"last_queued,omitempty"`
+	LastLimited time.Time `json:"last_limited,omitempty"`
+}
+
+// Cooldowns are copied here from the registry so the queue path never takes
+// the registry's mutex, which is held while a trigger's poll is stopped
+var (
+	triggerQueueStats = map[string]*triggerQueueCounters{}
+	triggerCooldowns  = map[string]time.Duration{}
+	triggerQueueMutex sync.Mutex
+)
+
+func setTriggerCooldown(triggerID string, seconds int) {
+	triggerQueueMutex.Lock()
+	defer triggerQueueMutex.Unlock()
+	triggerCooldowns[triggerID] = time.Duration(seconds) * time.Second
+}
+
+func triggerQueueStatsFor(triggerID string) *triggerQueueCounters {
+	stats := triggerQueueStats[triggerID]
+	if stats == nil {
+		stats = &triggerQueueCounters{}
+		triggerQueueStats[triggerID] = stats
+	}
+	return stats
+}
+
+// triggerQueueStatus returns a copy of a trigger's counters
+func triggerQueueStatus(triggerID string) triggerQueueCounters {
+	triggerQueueMutex.Lock()
+	defer triggerQueueMutex.Unlock()
+	return *triggerQueueStatsFor(triggerID)
+}
+
+// queueTriggerAnnouncement queues an announcement on behalf of a trigger.
+// parameters["trigger_source"] identifies the caller (the trigger or rule) for
+// the duplicate check. A *triggerRateLimitError means it was held back.
+func queueTriggerAnnouncement(triggerID string, announcementType AnnouncementType, priority AnnouncementPriority, parameters map[string]interface{}) (*Announcement, error) {
+	if announcementManager == nil {
+		return nil, fmt.Errorf("announcement manager not available")
+	}
+
+	triggerQueueMutex.Lock()
+	defer triggerQueueMutex.Unlock()
+	stats := triggerQueueStatsFor(triggerID)
+	now := time.Now()
+
+	urgent := announcementType == TypeEmergency || announcementType == TypeLightning || priority >= PriorityEmergency
+	if cooldown := triggerCooldowns[triggerID]; cooldown > 0 && !urgent && !stats.LastQueued.IsZero() && now.Sub(stats.LastQueued) < cooldown {
+		stats.CooledDown++
+		stats.LastLimited = now
+		return nil, &triggerRateLimitError{fmt.Sprintf("trigger '%s' is cooling down (%s left)", triggerID, (cooldown - now.Sub(stats.LastQueued)).Round(time.Second))}
+	}
+
+	source, _ := parameters["trigger_source"].(string)
+	if files, err := announcementManager.buildAudioSequence(announcementType, parameters); err == nil {
+		if existing := announcementManager.findInFlight(announcementType, source, files); existing != nil {
+			stats.Duplicates++
+			stats.LastLimited = now
+			return nil, &triggerRateLimitError{fmt.Sprintf("the same announcement from %s is already %s (%s)", source, existing.Status, existing.ID)}
+		}
+	}
+
+	announcement, err := announcementManager.QueueAnnouncement(announcementType, priority, parameters, now)
+	if err != nil {
+		return nil, err
+	}
+	stats.Queued++
+	stats.LastQueued = now
+	return announcement, nil
+}
+
+// findInFlight returns a queued or playing announcement of the same type, from
+// the same trigger source and with the same audio, or nil
+func (am *AnnouncementManager) findInFlight(announcementType AnnouncementType, source string, files []string) *Announcement {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+
+	same := func(announcement *Announcement) bool {
+		if announcement == nil || announcement.Type != announcementType {
+			return false
+		}
+		if existingSource, _ := announcement.Parameters["trigger_source"].(string); existingSource != source {
+			return false
+		}
+		return strings.Join(announcement.AudioFiles, "\n") == strings.Join(files, "\n")
+	}
+	if same(am.playing) {
+		return am.playing
+	}
+	for _, announcement := range *am.queue {
+		if same(announcement) {
+			return announcement
+		}
+	}
+	return nil
+}
+
+// API handlers
+
+// apiSetTriggerCooldownHandler sets a trigger's minimum interval between announcements
+func apiSetTriggerCooldownHandler(c *gin.Context) {
+	var request struct {
+		CooldownSeconds *int `json:"cooldown_seconds"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	if request.CooldownSeconds == nil || *request.CooldownSeconds < 0 {
+		respondValidationError(c, "Invalid trigger cooldown", FieldError{Field: "cooldown_seconds", Message: "must be zero or more seconds"})
+		return
+	}
+
+	id := c.Param("id")
+	if err := triggerRegistry.setCooldown(id, *request.CooldownSeconds); err != nil {
+		respondTriggerError(c, err)
+		return
+	}
+	log.Printf("Trigger '%s' cooldown set to %d seconds", id, *request.CooldownSeconds)
+	respondSuccess(c, http.StatusOK, "Trigger '"+id+"' cooldown updated", gin.H{
+		"id":               id,
+		"cooldown_seconds": *request.CooldownSeconds,
+		"counters":         triggerQueueStatus(id),
+	})
+}
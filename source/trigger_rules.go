@@ -0,0 +1,534 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Trigger rules map the events triggers publish onto announcements: if an
+// event of type X has a value matching Y and the time is inside window Z,
+// queue announcement A with priority P, at most once per cooldown C. The
+// rules are shared by every trigger type and kept in json/trigger_rules.json.
+
+// TriggerEvent is something a trigger observed
+type TriggerEvent struct {
+	Source   string    `json:"source"`             // trigger ID
+	Type     string    `json:"type"`               // trigger type, e.g. "lightning"
+	Field    string    `json:"field"`              // what changed, e.g. "condition" or an XML monitor ID
+	Value    string    `json:"value"`              // the new value
+	Previous string    `json:"previous,omitempty"` // the value before, if known
+	Time     time.Time `json:"time"`
+}
+
+// TriggerRule queues an announcement when a matching event arrives
+type TriggerRule struct {
+	ID              string                  `json:"id"`
+	Name            string                  `json:"name"`
+	Enabled         bool                    `json:"enabled"`
+	Event           string                  `json:"event"`            // trigger type, or "*" for any
+	Source          string                  `json:"source,omitempty"` // limit to one trigger ID
+	Field           string                  `json:"field,omitempty"`  // limit to one field
+	Match           TriggerRuleMatch        `json:"match"`
+	Window          *TriggerRuleWindow      `json:"window,omitempty"`
+	Announcement    TriggerRuleAnnouncement `json:"announcement"`
+	Priority        string                  `json:"priority"`
+	CooldownSeconds int                     `json:"cooldown_seconds"`
+}
+
+// TriggerRuleMatch compares the event value against Values. Comparisons:
+// any (the default), equals, not_equals, contains, regex, greater_than, less_than. Text
+// comparisons ignore case; greater_than and less_than are numeric.
+type TriggerRuleMatch struct {
+	Comparison string   `json:"comparison"`
+	Values     []string `json:"values,omitempty"`
+}
+
+// TriggerRuleWindow limits a rule to a time of day (HH:MM, local time) and
+// optionally to days of the week. A window whose end is before its start
+// runs past midnight.
+type TriggerRuleWindow struct {
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+	Days  []string `json:"days,omitempty"` // mon, tue, ...
+}
+
+// TriggerRuleAnnouncement is the announcement a rule queues. Parameter values
+// may use {value}, {previous}, {field} and {source} from the event.
+type TriggerRuleAnnouncement struct {
+	Type       string            `json:"type"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// triggerRuleCounters are kept in memory and reset on restart
+type triggerRuleCounters struct {
+	Matched     int       `json:"matched"`
+	Fired       int       `json:"fired"`
+	CooledDown  int       `json:"cooled_down"`
+	RateLimited int       `json:"rate_limited"` // held back by the trigger's cooldown or duplicate guard
+	Failed      int       `json:"failed"`
+	LastMatched time.Time `json:"last_matched,omitempty"`
+	LastFired   time.Time `json:"last_fired,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+var triggerRuleComparisons = map[string]bool{
+	"any": true, "equals": true, "not_equals": true, "contains": true,
+	"regex": true, "greater_than": true, "less_than": true,
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+var (
+	triggerRules      []TriggerRule
+	triggerRuleStats  = map[string]*triggerRuleCounters{}
+	triggerRulesMutex sync.Mutex
+)
+
+func triggerRulesPath() string {
+	path, _ := jsonFilePath("trigger_rules")
+	return path
+}
+
+// loadTriggerRules reads trigger_rules.json at startup
+func loadTriggerRules() error {
+	data, err := os.ReadFile(triggerRulesPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read trigger_rules.json: %v", err)
+	}
+	var wrapper struct {
+		Rules []TriggerRule `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return fmt.Errorf("failed to parse trigger_rules.json: %v", err)
+	}
+	if details := validateTriggerRules(wrapper.Rules); len(details) > 0 {
+		return fmt.Errorf("trigger_rules.json is invalid: %s %s", details[0].Field, details[0].Message)
+	}
+
+	triggerRulesMutex.Lock()
+	triggerRules = wrapper.Rules
+	triggerRulesMutex.Unlock()
+	log.Printf("✓ Loaded %d trigger rules", len(wrapper.Rules))
+	return nil
+}
+
+// saveTriggerRulesLocked writes the rules. Caller holds triggerRulesMutex.
+func saveTriggerRulesLocked(rules []TriggerRule) error {
+	if err := saveJSON("trigger_rules", map[string]interface{}{"rules": rules}); err != nil {
+		return err
+	}
+	triggerRules = rules
+	return nil
+}
+
+// validate checks one rule; field names are prefixed with prefix
+func (r TriggerRule) validate(prefix string) []FieldError {
+	var details []FieldError
+	add := func(field, message string) {
+		details = append(details, FieldError{Field: prefix + field, Message: message})
+	}
+
+	if !catalogIDPattern.MatchString(r.ID) {
+		add("id", "must be 1-64 letters, digits, '-' or '_'")
+	}
+	if _, known := triggerTypes[r.Event]; !known && r.Event != "*" {
+		add("event", "must be a trigger type or '*'")
+	}
+
+	comparison := r.Match.Comparison
+	if comparison != "" && !triggerRuleComparisons[comparison] {
+		add("match.comparison", "must be one of any, equals, not_equals, contains, regex, greater_than, less_than")
+	}
+	if comparison != "any" && comparison != "" && len(r.Match.Values) == 0 {
+		add("match.values", "is required for "+comparison)
+	}
+	for i, value := range r.Match.Values {
+		switch comparison {
+		case "regex":
+			if _, err := regexp.Compile(value); err != nil {
+				add(fmt.Sprintf("match.values[%d]", i), "invalid regular expression: "+err.Error())
+			}
+		case "greater_than", "less_than":
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				add(fmt.Sprintf("match.values[%d]", i), "must be a number")
+			}
+		}
+	}
+
+	if r.Window != nil {
+		if _, err := parseClockMinutes(r.Window.Start); err != nil {
+			add("window.start", err.Error())
+		}
+		if _, err := parseClockMinutes(r.Window.End); err != nil {
+			add("window.end", err.Error())
+		}
+		for i, day := range r.Window.Days {
+			if _, ok := weekdayNames[strings.ToLower(day)]; !ok {
+				add(fmt.Sprintf("window.days[%d]", i), "must be one of sun, mon, tue, wed, thu, fri, sat")
+			}
+		}
+	}
+
+	details = append(details, validateTriggerAnnouncement(prefix, r.Announcement, r.Priority)...)
+	if r.CooldownSeconds < 0 {
+		add("cooldown_seconds", "must not be negative")
+	}
+	return details
+}
+
+// validateTriggerAnnouncement checks an announcement a trigger queues has a
+// known type, the parameters that type needs and a valid priority
+func validateTriggerAnnouncement(prefix string, announcement TriggerRuleAnnouncement, priority string) []FieldError {
+	var details []FieldError
+	required, known := previewRequiredFields[AnnouncementType(announcement.Type)]
+	if !known {
+		details = append(details, FieldError{Field: prefix + "announcement.type", Message: "unknown announcement type '" + announcement.Type + "'"})
+	}
+	for _, field := range required {
+		if strings.TrimSpace(announcement.Parameters[field]) == "" {
+			details = append(details, FieldError{Field: prefix + "announcement.parameters." + field, Message: "is required for " + announcement.Type + " announcements"})
+		}
+	}
+	switch priority {
+	case "", "low", "normal", "high", "critical", "emergency":
+	default:
+		details = append(details, FieldError{Field: prefix + "priority", Message: "must be one of low, normal, high, critical, emergency"})
+	}
+	return details
+}
+
+// validateTriggerRules checks every rule and that IDs are unique
+func validateTriggerRules(rules []TriggerRule) []FieldError {
+	var details []FieldError
+	seen := map[string]bool{}
+	for i, rule := range rules {
+		prefix := fmt.Sprintf("rules[%d].", i)
+		details = append(details, rule.validate(prefix)...)
+		if seen[rule.ID] {
+			details = append(details, FieldError{Field: prefix + "id", Message: "duplicate id '" + rule.ID + "'"})
+		}
+		seen[rule.ID] = true
+	}
+	return details
+}
+
+// parseClockMinutes parses HH:MM into minutes after midnight
+func parseClockMinutes(value string) (int, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("must be a time of day as HH:MM")
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// contains reports whether now falls inside the window
+func (w *TriggerRuleWindow) contains(now time.Time) bool {
+	if w == nil {
+		return true
+	}
+	start, _ := parseClockMinutes(w.Start)
+	end, _ := parseClockMinutes(w.End)
+	minute := now.Hour()*60 + now.Minute()
+
+	day := now.Weekday()
+	inside := false
+	switch {
+	case start <= end:
+		inside = minute >= start && minute < end
+	case minute >= start:
+		inside = true
+	case minute < end:
+		// Past midnight: the window opened on the previous day
+		inside = true
+		day = (day + 6) % 7
+	}
+	if !inside || len(w.Days) == 0 {
+		return inside
+	}
+	for _, name := range w.Days {
+		if weekdayNames[strings.ToLower(name)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether an event satisfies the rule's event, source, field and value tests
+func (r TriggerRule) matches(event TriggerEvent) bool {
+	if r.Event != "*" && r.Event != event.Type {
+		return false
+	}
+	if r.Source != "" && r.Source != event.Source {
+		return false
+	}
+	if r.Field != "" && !strings.EqualFold(r.Field, event.Field) {
+		return false
+	}
+	return r.Match.matches(event.Value)
+}
+
+func (m TriggerRuleMatch) matches(value string) bool {
+	switch m.Comparison {
+	case "", "any":
+		return true
+	case "not_equals":
+		for _, candidate := range m.Values {
+			if strings.EqualFold(value, candidate) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, candidate := range m.Values {
+		switch m.Comparison {
+		case "equals":
+			if strings.EqualFold(value, candidate) {
+				return true
+			}
+		case "contains":
+			if strings.Contains(strings.ToLower(value), strings.ToLower(candidate)) {
+				return true
+			}
+		case "regex":
+			if pattern, err := regexp.Compile(candidate); err == nil && pattern.MatchString(value) {
+				return true
+			}
+		case "greater_than", "less_than":
+			number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			limit, limitErr := strconv.ParseFloat(candidate, 64)
+			if err != nil || limitErr != nil {
+				continue
+			}
+			if (m.Comparison == "greater_than" && number > limit) || (m.Comparison == "less_than" && number < limit) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parameters expands the announcement parameters for an event
+func (r TriggerRule) parameters(event TriggerEvent) map[string]interface{} {
+	replacer := strings.NewReplacer(
+		"{value}", event.Value,
+		"{previous}", event.Previous,
+		"{field}", event.Field,
+		"{source}", event.Source,
+	)
+	parameters := map[string]interface{}{
+		"trigger_source": "TRIGGER_RULE:" + r.ID,
+		"trigger_value":  event.Value,
+	}
+	for key, value := range r.Announcement.Parameters {
+		parameters[key] = replacer.Replace(value)
+	}
+	return parameters
+}
+
+// publishTriggerEvent runs an event through the rules, queuing an announcement
+// for each rule that matches and is not cooling down. It returns the IDs of the
+// rules that fired.
+func publishTriggerEvent(event TriggerEvent) []string {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	return evaluateTriggerRules(event, true)
+}
+
+// evaluateTriggerRules matches an event against the rules. With queue unset it
+// only reports which rules would fire, leaving counters and cooldowns alone.
+func evaluateTriggerRules(event TriggerEvent, queue bool) []string {
+	triggerRulesMutex.Lock()
+	defer triggerRulesMutex.Unlock()
+
+	fired := []string{}
+	for _, rule := range triggerRules {
+		if !rule.Enabled || !rule.matches(event) || !rule.Window.contains(event.Time) {
+			continue
+		}
+		stats := triggerRuleStats[rule.ID]
+		if stats == nil {
+			stats = &triggerRuleCounters{}
+			triggerRuleStats[rule.ID] = stats
+		}
+		cooldown := time.Duration(rule.CooldownSeconds) * time.Second
+		coolingDown := !stats.LastFired.IsZero() && event.Time.Sub(stats.LastFired) < cooldown
+		if !queue {
+			if !coolingDown {
+				fired = append(fired, rule.ID)
+			}
+			continue
+		}
+
+		stats.Matched++
+		stats.LastMatched = event.Time
+		if coolingDown {
+			stats.CooledDown++
+			log.Printf("Trigger rule '%s' matched %s=%s but is cooling down", rule.ID, event.Field, event.Value)
+			continue
+		}
+		if err := rule.queue(event); isTriggerRateLimited(err) {
+			stats.RateLimited++
+			log.Printf("Trigger rule '%s' matched %s=%s but was rate limited: %v", rule.ID, event.Field, event.Value, err)
+			continue
+		} else if err != nil {
+			stats.Failed++
+			stats.LastError = err.Error()
+			log.Printf("Trigger rule '%s' failed to queue announcement: %v", rule.ID, err)
+			continue
+		}
+		stats.Fired++
+		stats.LastFired = event.Time
+		stats.LastError = ""
+		fired = append(fired, rule.ID)
+	}
+	return fired
+}
+
+// queue queues the rule's announcement for an event
+func (r TriggerRule) queue(event TriggerEvent) error {
+	priority := r.Priority
+	if priority == "" {
+		priority = "normal"
+	}
+	announcement, err := queueTriggerAnnouncement(event.Source, AnnouncementType(r.Announcement.Type), ParsePriority(priority), r.parameters(event))
+	if err != nil {
+		return err
+	}
+	log.Printf("Trigger rule '%s' queued %s announcement %s for %s %s=%s", r.ID, r.Announcement.Type, announcement.ID, event.Source, event.Field, event.Value)
+	return nil
+}
+
+// describeTriggerRule returns a rule with its counters. Caller holds triggerRulesMutex.
+func describeTriggerRule(rule TriggerRule) gin.H {
+	stats := triggerRuleStats[rule.ID]
+	if stats == nil {
+		stats = &triggerRuleCounters{}
+	}
+	return gin.H{
+		"rule":     rule,
+		"counters": *stats,
+	}
+}
+
+// API handlers
+
+func apiListTriggerRulesHandler(c *gin.Context) {
+	triggerRulesMutex.Lock()
+	defer triggerRulesMutex.Unlock()
+	rules := make([]gin.H, 0, len(triggerRules))
+	for _, rule := range triggerRules {
+		rules = append(rules, describeTriggerRule(rule))
+	}
+	respondOK(c, gin.H{"rules": rules})
+}
+
+func apiGetTriggerRuleHandler(c *gin.Context) {
+	triggerRulesMutex.Lock()
+	defer triggerRulesMutex.Unlock()
+	for _, rule := range triggerRules {
+		if rule.ID == c.Param("id") {
+			respondOK(c, describeTriggerRule(rule))
+			return
+		}
+	}
+	respondError(c, http.StatusNotFound, ErrCodeNotFound, "Trigger rule '"+c.Param("id")+"' not found")
+}
+
+// apiPutTriggerRuleHandler creates or replaces one rule
+func apiPutTriggerRuleHandler(c *gin.Context) {
+	var rule TriggerRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	rule.ID = c.Param("id")
+	if details := rule.validate(""); len(details) > 0 {
+		respondValidationError(c, "Invalid trigger rule", details...)
+		return
+	}
+
+	triggerRulesMutex.Lock()
+	defer triggerRulesMutex.Unlock()
+	rules := append([]TriggerRule{}, triggerRules...)
+	status := http.StatusCreated
+	for i := range rules {
+		if rules[i].ID == rule.ID {
+			rules[i] = rule
+			status = http.StatusOK
+		}
+	}
+	if status == http.StatusCreated {
+		rules = append(rules, rule)
+	}
+	if err := saveTriggerRulesLocked(rules); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save trigger rules: "+err.Error())
+		return
+	}
+	respondSuccess(c, status, "Trigger rule '"+rule.ID+"' saved", describeTriggerRule(rule))
+}
+
+func apiDeleteTriggerRuleHandler(c *gin.Context) {
+	id := c.Param("id")
+	triggerRulesMutex.Lock()
+	defer triggerRulesMutex.Unlock()
+	rules := make([]TriggerRule, 0, len(triggerRules))
+	for _, rule := range triggerRules {
+		if rule.ID != id {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == len(triggerRules) {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Trigger rule '"+id+"' not found")
+		return
+	}
+	if err := saveTriggerRulesLocked(rules); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save trigger rules: "+err.Error())
+		return
+	}
+	delete(triggerRuleStats, id)
+	respondSuccess(c, http.StatusOK, "Trigger rule '"+id+"' deleted", nil)
+}
+
+// apiEvaluateTriggerRulesHandler runs an event through the rules. Without
+// "queue": true it is a dry run that only reports which rules would fire.
+func apiEvaluateTriggerRulesHandler(c *gin.Context) {
+	var request struct {
+		TriggerEvent
+		Queue bool `json:"queue"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	event := request.TriggerEvent
+	if strings.TrimSpace(event.Type) == "" {
+		respondValidationError(c, "Invalid trigger event", FieldError{Field: "type", Message: "is required"})
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	respondOK(c, gin.H{
+		"event":  event,
+		"queued": request.Queue,
+		"rules":  evaluateTriggerRules(event, request.Queue),
+	})
+}
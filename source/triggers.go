@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Triggers watch something outside the annunciator (the lightning sensor, an
+// XML feed, ...) and queue announcements when it changes. Each trigger type
+// implements Trigger and registers a constructor in triggerTypes; the
+// instances, their settings and whether they run are kept in json/triggers.json
+// and managed through /api/triggers.
+
+// Trigger is implemented by every trigger type
+type Trigger interface {
+	// Start begins monitoring in the background; starting a running trigger does nothing
+	Start() error
+	// Stop ends monitoring and waits for the trigger's goroutine to exit
+	Stop()
+	// Running reports whether the trigger is monitoring
+	Running() bool
+	// Status describes the trigger's state for the API
+	Status() map[string]interface{}
+	// Configure validates and applies new settings, restarting the trigger if it was running
+	Configure(settings json.RawMessage) error
+	// Settings returns the current settings as stored in triggers.json
+	Settings() interface{}
+	// Test exercises the trigger once; value is type specific and may be empty
+	Test(value string) (map[string]interface{}, error)
+}
+
+// TriggerDefinition is one trigger in triggers.json
+type TriggerDefinition struct {
+	ID       string          `json:"id"`
+	Type     string          `json:"type"`
+	Name     string          `json:"name"`
+	Enabled  bool            `json:"enabled"`
+	Settings json.RawMessage `json:"settings,omitempty"`
+	// Minimum time between announcements queued by the trigger or its rules
+	CooldownSeconds int `json:"cooldown_seconds,omitempty"`
+}
+
+// triggerTypes builds a trigger from its definition. New trigger types only
+// need an entry here.
+var triggerTypes = map[string]func(TriggerDefinition) (Trigger, error){
+	"lightning": newLightningTriggerFromDefinition,
+	"http_xml":  newHTTPXMLTriggerFromDefinition,
+	"modbus":    newModbusTriggerFromDefinition,
+	"snmp_trap": newSNMPTrapTriggerFromDefinition,
+}
+
+// Used when triggers.json does not exist, matching the built-in lightning monitor
+func getDefaultTriggerDefinitions() []TriggerDefinition {
+	return []TriggerDefinition{{
+		ID:       "lightning_monitor",
+		Type:     "lightning",
+		Name:     "Lightning Alert Monitor",
+		Enabled:  true,
+		Settings: json.RawMessage(`{"url": "https://broward.thormobile4.net/tp/FL0115.xml", "fetch_interval": 30, "timeout": 30}`),
+	}}
+}
+
+// triggerLoop runs a trigger's poll function on an interval in its own
+// goroutine. It is the lifecycle every polling trigger shares.
+type triggerLoop struct {
+	mutex    sync.Mutex
+	stopChan chan struct{}
+	done     chan struct{}
+}
+
+// start launches the loop; poll runs immediately when immediate is set and
+// then every interval. Starting a running loop does nothing.
+func (l *triggerLoop) start(name string, interval time.Duration, immediate bool, poll func()) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.stopChan != nil {
+		return
+	}
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	stopChan := make(chan struct{})
+	done := make(chan struct{})
+	l.stopChan, l.done = stopChan, done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		log.Printf("Trigger '%s' started with %s interval", name, interval)
+		// A poll that panics is recorded and the next one runs as usual
+		source := "trigger '" + name + "'"
+		if immediate {
+			runRecovered(source, poll)
+		}
+		for {
+			select {
+			case <-ticker.C:
+				runRecovered(source, poll)
+			case <-stopChan:
+				log.Printf("Trigger '%s' stopped", name)
+				return
+			}
+		}
+	}()
+}
+
+// stop ends the loop and waits for an in-progress poll to finish
+func (l *triggerLoop) stop() {
+	l.mutex.Lock()
+	stopChan, done := l.stopChan, l.done
+	l.stopChan, l.done = nil, nil
+	l.mutex.Unlock()
+	if stopChan == nil {
+		return
+	}
+	close(stopChan)
+	<-done
+}
+
+func (l *triggerLoop) running() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.stopChan != nil
+}
+
+// registeredTrigger is a trigger with the definition it was built from
+type registeredTrigger struct {
+	definition TriggerDefinition
+	trigger    Trigger
+	err        string // why the trigger could not be built
+}
+
+// TriggerRegistry holds every configured trigger
+type TriggerRegistry struct {
+	mutex    sync.Mutex
+	triggers []*registeredTrigger
+}
+
+var triggerRegistry = &TriggerRegistry{}
+
+func triggersPath() string {
+	path, _ := jsonFilePath("triggers")
+	return path
+}
+
+// loadTriggers builds the triggers in triggers.json and starts the enabled ones
+func loadTriggers() error {
+	definitions := getDefaultTriggerDefinitions()
+	data, err := os.ReadFile(triggersPath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read triggers.json: %v", err)
+	}
+	if err == nil {
+		var wrapper struct {
+			Triggers []TriggerDefinition `json:"triggers"`
+		}
+		if err := json.Unmarshal(data, &wrapper); err != nil {
+			return fmt.Errorf("failed to parse triggers.json: %v", err)
+		}
+		definitions = wrapper.Triggers
+	}
+
+	triggerRegistry.mutex.Lock()
+	defer triggerRegistry.mutex.Unlock()
+	seen := map[string]bool{}
+	for _, definition := range definitions {
+		if definition.ID == "" || seen[definition.ID] {
+			log.Printf("Warning: skipping trigger with missing or duplicate id '%s'", definition.ID)
+			continue
+		}
+		seen[definition.ID] = true
+		setTriggerCooldown(definition.ID, definition.CooldownSeconds)
+
+		entry := &registeredTrigger{definition: definition}
+		build, ok := triggerTypes[definition.Type]
+		if !ok {
+			entry.err = fmt.Sprintf("unknown trigger type '%s'", definition.Type)
+		} else if entry.trigger, err = build(definition); err != nil {
+			entry.err = err.Error()
+			entry.trigger = nil
+		}
+		if entry.err != "" {
+			log.Printf("Warning: trigger '%s' not loaded: %s", definition.ID, entry.err)
+		} else if definition.Enabled {
+			if err := entry.trigger.Start(); err != nil {
+				log.Printf("Warning: trigger '%s' failed to start: %v", definition.ID, err)
+			}
+		}
+		triggerRegistry.triggers = append(triggerRegistry.triggers, entry)
+	}
+	log.Printf("✓ Loaded %d triggers", len(triggerRegistry.triggers))
+	return nil
+}
+
+// saveLocked writes triggers.json from the current settings. Caller holds the mutex.
+func (r *TriggerRegistry) saveLocked() error {
+	definitions := make([]TriggerDefinition, 0, len(r.triggers))
+	for _, entry := range r.triggers {
+		definition := entry.definition
+		if entry.trigger != nil {
+			if settings, err := json.Marshal(entry.trigger.Settings()); err == nil {
+				definition.Settings = settings
+			}
+		}
+		definitions = append(definitions, definition)
+	}
+	data, err := json.MarshalIndent(map[string]interface{}{"triggers": definitions}, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(triggersPath(), data, 0644)
+}
+
+// findLocked returns a trigger by ID. Caller holds the mutex.
+func (r *TriggerRegistry) findLocked(id string) *registeredTrigger {
+	for _, entry := range r.triggers {
+		if entry.definition.ID == id {
+			return entry
+		}
+	}
+	return nil
+}
+
+// errTriggerNotFound is returned for unknown trigger IDs
+var errTriggerNotFound = fmt.Errorf("trigger not found")
+
+// get returns a trigger by ID, or an error if it does not exist or failed to load
+func (r *TriggerRegistry) get(id string) (Trigger, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	entry := r.findLocked(id)
+	if entry == nil {
+		return nil, errTriggerNotFound
+	}
+	if entry.trigger == nil {
+		return nil, fmt.Errorf("trigger '%s' is not loaded: %s", id, entry.err)
+	}
+	return entry.trigger, nil
+}
+
+// setEnabled starts or stops a trigger and records the choice
+func (r *TriggerRegistry) setEnabled(id string, enabled bool) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	entry := r.findLocked(id)
+	if entry == nil {
+		return errTriggerNotFound
+	}
+	if entry.trigger == nil {
+		return fmt.Errorf("trigger '%s' is not loaded: %s", id, entry.err)
+	}
+	if enabled {
+		if err := entry.trigger.Start(); err != nil {
+			return err
+		}
+	} else {
+		entry.trigger.Stop()
+	}
+	entry.definition.Enabled = enabled
+	log.Printf("Trigger '%s' %s", id, map[bool]string{true: "enabled", false: "disabled"}[enabled])
+	return r.saveLocked()
+}
+
+// configure applies new settings to a trigger and records them
+func (r *TriggerRegistry) configure(id string, settings json.RawMessage) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	entry := r.findLocked(id)
+	if entry == nil {
+		return errTriggerNotFound
+	}
+	if entry.trigger == nil {
+		return fmt.Errorf("trigger '%s' is not loaded: %s", id, entry.err)
+	}
+	if err := entry.trigger.Configure(settings); err != nil {
+		return err
+	}
+	return r.saveLocked()
+}
+
+// setCooldown changes a trigger's cooldown and records it
+func (r *TriggerRegistry) setCooldown(id string, seconds int) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	entry := r.findLocked(id)
+	if entry == nil {
+		return errTriggerNotFound
+	}
+	entry.definition.CooldownSeconds = seconds
+	setTriggerCooldown(id, seconds)
+	return r.saveLocked()
+}
+
+// describe returns the summary of every trigger for the API
+func (r *TriggerRegistry) describe() []map[string]interface{} {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	list := make([]map[string]interface{}, 0, len(r.triggers))
+	for _, entry := range r.triggers {
+		list = append(list, entry.describe())
+	}
+	return list
+}
+
+func (entry *registeredTrigger) describe() map[string]interface{} {
+	description := map[string]interface{}{
+		"id":      entry.definition.ID,
+		"type":    entry.definition.Type,
+		"name":    entry.definition.Name,
+		"enabled": entry.definition.Enabled,
+		"running": false,
+
+		"cooldown_seconds": entry.definition.CooldownSeconds,
+		"announcements":    triggerQueueStatus(entry.definition.ID),
+	}
+	if entry.trigger == nil {
+		description["error"] = entry.err
+		return description
+	}
+	description["running"] = entry.trigger.Running()
+	description["settings"] = entry.trigger.Settings()
+	description["status"] = entry.trigger.Status()
+	return description
+}
+
+// stopAllTriggers stops every trigger during shutdown
+func stopAllTriggers() {
+	triggerRegistry.mutex.Lock()
+	defer triggerRegistry.mutex.Unlock()
+	for _, entry := range triggerRegistry.triggers {
+		if entry.trigger != nil {
+			entry.trigger.Stop()
+		}
+	}
+}
+
+// API handlers
+
+// respondTriggerError maps registry errors onto the API envelope
+func respondTriggerError(c *gin.Context, err error) {
+	if err == errTriggerNotFound {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Trigger '"+c.Param("id")+"' not found")
+		return
+	}
+	respondError(c, http.StatusConflict, ErrCodeConflict, err.Error())
+}
+
+func apiListTriggersHandler(c *gin.Context) {
+	types := make([]string, 0, len(triggerTypes))
+	for name := range triggerTypes {
+		types = append(types, name)
+	}
+	respondOK(c, gin.H{
+		"triggers": triggerRegistry.describe(),
+		"types":    types,
+	})
+}
+
+func apiGetTriggerHandler(c *gin.Context) {
+	triggerRegistry.mutex.Lock()
+	entry := triggerRegistry.findLocked(c.Param("id"))
+	var description map[string]interface{}
+	if entry != nil {
+		description = entry.describe()
+	}
+	triggerRegistry.mutex.Unlock()
+	if entry == nil {
+		respondTriggerError(c, errTriggerNotFound)
+		return
+	}
+	respondOK(c, description)
+}
+
+func apiEnableTriggerHandler(c *gin.Context) {
+	setTriggerEnabled(c, true)
+}
+
+func apiDisableTriggerHandler(c *gin.Context) {
+	setTriggerEnabled(c, false)
+}
+
+func setTriggerEnabled(c *gin.Context, enabled bool) {
+	id := c.Param("id")
+	if err := triggerRegistry.setEnabled(id, enabled); err != nil {
+		respondTriggerError(c, err)
+		return
+	}
+	state := "disabled"
+	if enabled {
+		state = "enabled"
+	}
+	respondSuccess(c, http.StatusOK, "Trigger '"+id+"' "+state, gin.H{
+		"id":      id,
+		"enabled": enabled,
+	})
+}
+
+func apiConfigureTriggerHandler(c *gin.Context) {
+	id := c.Param("id")
+	var settings json.RawMessage
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	if _, err := triggerRegistry.get(id); err != nil {
+		respondTriggerError(c, err)
+		return
+	}
+	if err := triggerRegistry.configure(id, settings); err != nil {
+		respondValidationError(c, "Invalid trigger settings", FieldError{Field: "settings", Message: err.Error()})
+		return
+	}
+	trigger, _ := triggerRegistry.get(id)
+	respondSuccess(c, http.StatusOK, "Trigger '"+id+"' configured", gin.H{
+		"settings": trigger.Settings(),
+		"status":   trigger.Status(),
+	})
+}
+
+func apiTestTriggerHandler(c *gin.Context) {
+	id := c.Param("id")
+	data, ok := bindRequestData(c, "value")
+	if !ok {
+		return
+	}
+	value, _ := data["value"].(string)
+
+	trigger, err := triggerRegistry.get(id)
+	if err != nil {
+		respondTriggerError(c, err)
+		return
+	}
+	result, err := trigger.Test(value)
+	if err != nil {
+		respondError(c, http.StatusUnprocessableEntity, ErrCodeValidation, "Trigger test failed: "+err.Error())
+		return
+	}
+	respondSuccess(c, http.StatusOK, "Trigger '"+id+"' tested", result)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Spoken text (weather reports and the like) is synthesized by an external
+// text-to-speech program into WAV files under the TTS cache directory. Files
+// are named after a hash of the engine, voice, speed and text, so the same
+// sentence is only synthesized once and later plays from the cache.
+
+// TTSSettings selects and tunes the speech synthesizer
+type TTSSettings struct {
+	Engine         string   `json:"engine"`          // espeak-ng, piper or command
+	Command        string   `json:"command"`         // Program to run (defaults per engine)
+	Args           []string `json:"args"`            // Arguments; {text}, {output}, {voice} and {speed} are replaced
+	Voice          string   `json:"voice"`           // espeak-ng voice name or piper model path
+	Speed          int      `json:"speed"`           // Words per minute where the engine supports it
+	CacheDir       string   `json:"cache_dir"`       // Defaults to tts-cache in the log directory
+	CacheDays      int      `json:"cache_days"`      // Synthesized files unused for this long are deleted
+	TimeoutSeconds int      `json:"timeout_seconds"` // Longest a single synthesis may take
+}
+
+func getDefaultTTSSettings() TTSSettings {
+	return TTSSettings{
+		Engine:         "espeak-ng",
+		Voice:          "en-us",
+		Speed:          160,
+		CacheDays:      7,
+		TimeoutSeconds: 30,
+	}
+}
+
+// Default command lines per engine. Engines whose arguments have no {text}
+// placeholder read the text on standard input.
+var ttsEngineDefaults = map[string]struct {
+	command string
+	args    []string
+}{
+	"espeak-ng": {"espeak-ng", []string{"-v", "{voice}", "-s", "{speed}", "-w", "{output}", "{text}"}},
+	"piper":     {"piper", []string{"--model", "{voice}", "--output_file", "{output}"}},
+	"command":   {"", nil},
+}
+
+// Longest text accepted for synthesis
+const maxTTSTextLength = 2000
+
+var ttsMutex sync.Mutex
+
+// ttsCacheDir returns the directory synthesized speech is written to
+func ttsCacheDir() string {
+	if dir := getAudioSettings().TTS.CacheDir; dir != "" {
+		return dir
+	}
+	return filepath.Join(app.Config.LogDir, "tts-cache")
+}
+
+// commandLine returns the program and arguments for synthesizing text to output
+func (s TTSSettings) commandLine(text, output string) (string, []string, bool, error) {
+	engine := s.Engine
+	if engine == "" {
+		engine = "espeak-ng"
+	}
+	defaults, ok := ttsEngineDefaults[engine]
+	if !ok {
+		return "", nil, false, fmt.Errorf("unknown TTS engine '%s'", engine)
+	}
+	command, args := s.Command, s.Args
+	if command == "" {
+		command = defaults.command
+	}
+	if len(args) == 0 {
+		args = defaults.args
+	}
+	if command == "" {
+		return "", nil, false, fmt.Errorf("TTS engine '%s' needs a command", engine)
+	}
+
+	replacer := strings.NewReplacer("{text}", text, "{output}", output, "{voice}", s.Voice, "{speed}", strconv.Itoa(s.Speed))
+	expanded := make([]string, len(args))
+	stdin := true
+	for i, arg := range args {
+		if strings.Contains(arg, "{text}") {
+			stdin = false
+		}
+		expanded[i] = replacer.Replace(arg)
+	}
+	return command, expanded, stdin, nil
+}
+
+// cacheKey identifies a synthesized sentence
+func (s TTSSettings) cacheKey(text string) string {
+	sum := sha1.Sum([]byte(strings.Join([]string{s.Engine, s.Command, strings.Join(s.Args, " "), s.Voice, strconv.Itoa(s.Speed), text}, "\x00")))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// normalizeTTSText collapses whitespace so trivially different text shares a file
+func normalizeTTSText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// synthesizeSpeech returns a WAV file speaking text, running the TTS engine
+// if it is not already cached. Words in the pronunciation dictionary are
+// replaced by their spellings first.
+func synthesizeSpeech(text string) (string, error) {
+	text = normalizeTTSText(currentPronunciations().speakable(text))
+	if text == "" {
+		return "", fmt.Errorf("no text to speak")
+	}
+	if len(text) > maxTTSTextLength {
+		return "", fmt.Errorf("text is longer than %d characters", maxTTSTextLength)
+	}
+
+	settings := getAudioSettings().TTS
+	dir := ttsCacheDir()
+	output := filepath.Join(dir, "tts_"+settings.cacheKey(text)+".wav")
+
+	ttsMutex.Lock()
+	defer ttsMutex.Unlock()
+
+	if info, err := os.Stat(output); err == nil && info.Size() > 0 {
+		// Touch the file so pruning keeps sentences still in use
+		now := time.Now()
+		os.Chtimes(output, now, now)
+		return output, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create TTS cache directory: %v", err)
+	}
+
+	partial := output + ".partial.wav"
+	command, args, stdin, err := settings.commandLine(text, partial)
+	if err != nil {
+		return "", err
+	}
+
+	timeout := time.Duration(settings.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	started := time.Now()
+	cmd := exec.CommandContext(ctx, command, args...)
+	if stdin {
+		cmd.Stdin = strings.NewReader(text + "\n")
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(partial)
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("TTS engine timed out after %s", timeout)
+		}
+		return "", fmt.Errorf("TTS engine failed: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	if info, err := os.Stat(partial); err != nil || info.Size() == 0 {
+		os.Remove(partial)
+		return "", fmt.Errorf("TTS engine produced no audio")
+	}
+	if err := os.Rename(partial, output); err != nil {
+		os.Remove(partial)
+		return "", fmt.Errorf("failed to save synthesized speech: %v", err)
+	}
+
+	log.Printf("🗣️  Synthesized %d characters of speech in %s", len(text), time.Since(started).Round(time.Millisecond))
+	pruneTTSCache(dir, settings.CacheDays)
+	return output, nil
+}
+
+// pruneTTSCache deletes synthesized files that have not been used recently
+func pruneTTSCache(dir string, days int) {
+	if days <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), "tts_") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && info.ModTime().Before(cutoff) {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+}
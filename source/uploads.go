@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Long recordings and configuration archives don't always make it over the
+// park WiFi in one request. They can be uploaded in chunks instead: the client
+// opens an upload with the file's size and SHA-256, sends the bytes with
+// Content-Range headers, and after a dropped connection asks how far it got
+// and carries on from there. When the last chunk arrives the whole file is
+// checked against the hash. The finished upload is then handed to the
+// endpoint that uses it by its ID. Uploads wait in logs/uploads and are
+// deleted once used, cancelled or left unfinished for a day.
+
+// Upload purposes, each used by one endpoint
+const (
+	uploadSafetyAudio = "safety_audio" // PUT /api/v1/safety/languages/{id}/audio
+	uploadSnapshot    = "snapshot"     // POST /api/v1/snapshots/import
+)
+
+const (
+	maxChunkedUploadBytes = 200 << 20
+	maxUploadChunkBytes   = 8 << 20
+	uploadExpiry          = 24 * time.Hour
+)
+
+// UploadSession is a chunked upload in progress or finished
+type UploadSession struct {
+	ID        string    `json:"id"`
+	Purpose   string    `json:"purpose"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	Offset    int64     `json:"offset"` // Bytes received so far
+	Complete  bool      `json:"complete"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+var (
+	uploadMutex        sync.Mutex
+	uploadIDPattern    = regexp.MustCompile(`^[0-9a-f]{24}$`)
+	sha256Pattern      = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+	contentRangeHeader = regexp.MustCompile(`^bytes (\d+)-(\d+)/(\d+)$`)
+)
+
+// uploadDir is where chunked uploads are kept
+func uploadDir() string {
+	return filepath.Join(app.Config.LogDir, "uploads")
+}
+
+// uploadDataPath is the file an upload's bytes are appended to
+func uploadDataPath(id string) string {
+	return filepath.Join(uploadDir(), id+".part")
+}
+
+func uploadSessionPath(id string) string {
+	return filepath.Join(uploadDir(), id+".json")
+}
+
+// loadUploadSession reads an upload; the caller holds uploadMutex
+func loadUploadSession(id string) (*UploadSession, error) {
+	if !uploadIDPattern.MatchString(id) {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(uploadSessionPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var session UploadSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// save writes the upload's state; the caller holds uploadMutex
+func (s *UploadSession) save() error {
+	s.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(uploadSessionPath(s.ID), data, 0600)
+}
+
+// removeUpload deletes an upload's files; the caller holds uploadMutex
+func removeUpload(id string) {
+	os.Remove(uploadDataPath(id))
+	os.Remove(uploadSessionPath(id))
+}
+
+// pruneUploads removes uploads nothing has touched for uploadExpiry; the
+// caller holds uploadMutex
+func pruneUploads() {
+	matches, _ := filepath.Glob(filepath.Join(uploadDir(), "*.json"))
+	cutoff := time.Now().Add(-uploadExpiry)
+	for _, match := range matches {
+		id := strings.TrimSuffix(filepath.Base(match), ".json")
+		session, err := loadUploadSession(id)
+		if err != nil || session.UpdatedAt.Before(cutoff) {
+			removeUpload(id)
+		}
+	}
+}
+
+// checkUploadFilename returns why a file can't be uploaded for purpose, or ""
+func checkUploadFilename(purpose, filename string) string {
+	extension := strings.ToLower(filepath.Ext(filename))
+	switch purpose {
+	case uploadSafetyAudio:
+		return checkUploadExtension(extension)
+	case uploadSnapshot:
+		if extension != ".zip" {
+			return "must be a .zip snapshot archive"
+		}
+		return ""
+	}
+	return ""
+}
+
+// claimUpload returns the file of a finished upload for purpose. The caller
+// calls release once it is done with the file, which deletes the upload.
+func claimUpload(id, purpose string) (*UploadSession, string, func(), error) {
+	uploadMutex.Lock()
+	defer uploadMutex.Unlock()
+	session, err := loadUploadSession(id)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("upload '%s' not found", id)
+	}
+	if session.Purpose != purpose {
+		return nil, "", nil, fmt.Errorf("upload '%s' is for %s", id, session.Purpose)
+	}
+	if !session.Complete {
+		return nil, "", nil, fmt.Errorf("upload '%s' has %d of %d bytes", id, session.Offset, session.Size)
+	}
+	release := func() {
+		uploadMutex.Lock()
+		defer uploadMutex.Unlock()
+		removeUpload(id)
+	}
+	return session, uploadDataPath(id), release, nil
+}
+
+// Handlers
+
+// apiCreateUploadHandler opens a chunked upload:
+// {"purpose": "safety_audio", "filename": "...", "size": 52428800, "sha256": "..."}
+func apiCreateUploadHandler(c *gin.Context) {
+	var request struct {
+		Purpose  string `json:"purpose"`
+		Filename string `json:"filename"`
+		Size     int64  `json:"size"`
+		SHA256   string `json:"sha256"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+
+	var details []FieldError
+	if request.Purpose != uploadSafetyAudio && request.Purpose != uploadSnapshot {
+		details = append(details, FieldError{Field: "purpose", Message: "must be safety_audio or snapshot"})
+	} else if strings.TrimSpace(request.Filename) == "" {
+		details = append(details, FieldError{Field: "filename", Message: "is required"})
+	} else if message := checkUploadFilename(request.Purpose, request.Filename); message != "" {
+		details = append(details, FieldError{Field: "filename", Message: message})
+	}
+	if request.Size <= 0 || request.Size > maxChunkedUploadBytes {
+		details = append(details, FieldError{Field: "size", Message: fmt.Sprintf("must be between 1 byte and %d MB", maxChunkedUploadBytes>>20)})
+	}
+	if !sha256Pattern.MatchString(request.SHA256) {
+		details = append(details, FieldError{Field: "sha256", Message: "must be the file's SHA-256 in hex"})
+	}
+	if len(details) > 0 {
+		respondValidationError(c, "Invalid upload", details...)
+		return
+	}
+	if err := storageWritable(uploadDir(), "storing the upload"); err != nil {
+		respondError(c, http.StatusInsufficientStorage, ErrCodeUnavailable, err.Error())
+		return
+	}
+
+	uploadMutex.Lock()
+	defer uploadMutex.Unlock()
+	pruneUploads()
+	if err := os.MkdirAll(uploadDir(), 0700); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to create upload: "+err.Error())
+		return
+	}
+	now := time.Now()
+	session := &UploadSession{
+		ID:        randomToken()[:24],
+		Purpose:   request.Purpose,
+		Filename:  filepath.Base(request.Filename),
+		Size:      request.Size,
+		SHA256:    strings.ToLower(request.SHA256),
+		CreatedBy: requestOperator(c),
+		CreatedAt: now,
+	}
+	if err := os.WriteFile(uploadDataPath(session.ID), nil, 0600); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to create upload: "+err.Error())
+		return
+	}
+	if err := session.save(); err != nil {
+		removeUpload(session.ID)
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to create upload: "+err.Error())
+		return
+	}
+	log.Printf("Chunked upload %s opened by %s (%s, %d bytes)", session.ID, session.CreatedBy, session.Filename, session.Size)
+	c.Header("Location", "/api/v1/uploads/"+session.ID)
+	respondSuccess(c, http.StatusCreated, "Upload created", gin.H{
+		"upload":     session,
+		"chunk_size": maxUploadChunkBytes,
+	})
+}
+
+// apiGetUploadHandler reports how much of an upload has arrived, so a client
+// knows where to resume. The offset is also in the Upload-Offset header.
+func apiGetUploadHandler(c *gin.Context) {
+	uploadMutex.Lock()
+	session, err := loadUploadSession(c.Param("id"))
+	uploadMutex.Unlock()
+	if err != nil {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Upload not found")
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	respondOK(c, session)
+}
+
+// apiPutUploadChunkHandler appends one chunk. The Content-Range header gives
+// its place in the file ("bytes 0-8388607/52428800"), and it must start where
+// the upload stands. The last chunk completes the upload once the hash checks.
+func apiPutUploadChunkHandler(c *gin.Context) {
+	match := contentRangeHeader.FindStringSubmatch(c.GetHeader("Content-Range"))
+	if match == nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Content-Range must be 'bytes <first>-<last>/<size>'")
+		return
+	}
+	first, _ := strconv.ParseInt(match[1], 10, 64)
+	last, _ := strconv.ParseInt(match[2], 10, 64)
+	total, _ := strconv.ParseInt(match[3], 10, 64)
+	if last < first || last-first+1 > maxUploadChunkBytes {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, fmt.Sprintf("Chunks must be between 1 byte and %d MB", maxUploadChunkBytes>>20))
+		return
+	}
+
+	// The chunk is read before taking the lock, so a slow link doesn't hold
+	// up other uploads
+	chunk, err := io.ReadAll(io.LimitReader(c.Request.Body, maxUploadChunkBytes+1))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Failed to read chunk: "+err.Error())
+		return
+	}
+	if int64(len(chunk)) != last-first+1 {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, fmt.Sprintf("Content-Range says %d bytes but %d arrived", last-first+1, len(chunk)))
+		return
+	}
+
+	uploadMutex.Lock()
+	defer uploadMutex.Unlock()
+	session, err := loadUploadSession(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Upload not found")
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	if session.Complete {
+		respondError(c, http.StatusConflict, ErrCodeConflict, "Upload is already complete")
+		return
+	}
+	if total != session.Size || last >= session.Size {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, fmt.Sprintf("Content-Range is outside the %d byte upload", session.Size))
+		return
+	}
+	if first != session.Offset {
+		respondError(c, http.StatusConflict, ErrCodeConflict, fmt.Sprintf("Upload is at byte %d; send the chunk starting there", session.Offset))
+		return
+	}
+	if err := storageWritable(uploadDir(), "storing the upload"); err != nil {
+		respondError(c, http.StatusInsufficientStorage, ErrCodeUnavailable, err.Error())
+		return
+	}
+
+	file, err := os.OpenFile(uploadDataPath(session.ID), os.O_WRONLY, 0600)
+	if err == nil {
+		_, err = file.WriteAt(chunk, first)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to store chunk: "+err.Error())
+		return
+	}
+	session.Offset = last + 1
+
+	if session.Offset == session.Size {
+		sum, err := fileSHA256(uploadDataPath(session.ID))
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to check upload: "+err.Error())
+			return
+		}
+		if sum != session.SHA256 {
+			// The bytes can't be trusted, so the upload starts over
+			removeUpload(session.ID)
+			log.Printf("Chunked upload %s discarded: SHA-256 %s does not match %s", session.ID, sum, session.SHA256)
+			respondValidationError(c, "Upload failed its integrity check", FieldError{Field: "sha256", Message: "does not match the uploaded file; start a new upload"})
+			return
+		}
+		session.Complete = true
+		log.Printf("Chunked upload %s complete (%s, %d bytes)", session.ID, session.Filename, session.Size)
+	}
+	if err := session.save(); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to store chunk: "+err.Error())
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	respondOK(c, session)
+}
+
+// apiDeleteUploadHandler cancels an upload
+func apiDeleteUploadHandler(c *gin.Context) {
+	uploadMutex.Lock()
+	defer uploadMutex.Unlock()
+	session, err := loadUploadSession(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Upload not found")
+		return
+	}
+	removeUpload(session.ID)
+	respondSuccess(c, http.StatusOK, "Upload cancelled", nil)
+}
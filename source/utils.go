@@ -12,32 +12,11 @@ import (
 	"github.com/robfig/cron/v3"
 )
 
-// JSON file handling
+// loadJSON reads a registered JSON file as the type jsonFiles gives it,
+// returning defaultValue when the file is missing or unreadable
 func loadJSON(name string, defaultValue interface{}) interface{} {
-	var filePath string
-	
-	switch name {
-	case "trains":
-		filePath = filepath.Join(app.Config.JSONDir, "trains_selected.json")
-	case "trains_available":
-		filePath = filepath.Join(app.Config.JSONDir, "trains_available.json")
-	case "directions":
-		filePath = filepath.Join(app.Config.JSONDir, "directions.json")
-	case "destinations":
-		filePath = filepath.Join(app.Config.JSONDir, "destinations_selected.json")
-	case "destinations_available":
-		filePath = filepath.Join(app.Config.JSONDir, "destinations_available.json")
-	case "tracks":
-		filePath = filepath.Join(app.Config.JSONDir, "tracks.json")
-	case "promo":
-		filePath = filepath.Join(app.Config.JSONDir, "promo.json")
-	case "safety":
-		filePath = filepath.Join(app.Config.JSONDir, "safety.json")
-	case "emergencies":
-		filePath = filepath.Join(app.Config.JSONDir, "emergencies.json")
-	case "cron":
-		filePath = filepath.Join(app.Config.JSONDir, "cron.json")
-	default:
+	filePath, ok := jsonFilePath(name)
+	if !ok {
 		return defaultValue
 	}
 
@@ -51,122 +30,9 @@ func loadJSON(name string, defaultValue interface{}) interface{} {
 		return defaultValue
 	}
 
-	// Parse based on expected type
-	switch name {
-	case "trains":
-		var wrapper struct {
-			Trains []Train `json:"trains"`
-		}
-		if err := json.Unmarshal(data, &wrapper); err == nil && len(wrapper.Trains) > 0 {
-			return wrapper.Trains
-		}
-		// Try direct array format
-		var trains []Train
-		if err := json.Unmarshal(data, &trains); err == nil {
-			return trains
-		}
-		
-	case "trains_available":
-		var wrapper struct {
-			Trains []Train `json:"trains"`
-		}
-		if err := json.Unmarshal(data, &wrapper); err == nil && len(wrapper.Trains) > 0 {
-			return wrapper.Trains
-		}
-		// Try direct array format
-		var trains []Train
-		if err := json.Unmarshal(data, &trains); err == nil {
-			return trains
-		}
-		
-	case "directions":
-		var wrapper struct {
-			Directions []Direction `json:"directions"`
-		}
-		if err := json.Unmarshal(data, &wrapper); err == nil && len(wrapper.Directions) > 0 {
-			return wrapper.Directions
-		}
-		var directions []Direction
-		if err := json.Unmarshal(data, &directions); err == nil {
-			return directions
-		}
-		
-	case "destinations":
-		var wrapper struct {
-			Destinations []Destination `json:"destinations"`
-		}
-		if err := json.Unmarshal(data, &wrapper); err == nil && len(wrapper.Destinations) > 0 {
-			return wrapper.Destinations
-		}
-		var destinations []Destination
-		if err := json.Unmarshal(data, &destinations); err == nil {
-			return destinations
-		}
-		
-	case "destinations_available":
-		var wrapper struct {
-			Destinations []Destination `json:"destinations"`
-		}
-		if err := json.Unmarshal(data, &wrapper); err == nil && len(wrapper.Destinations) > 0 {
-			return wrapper.Destinations
-		}
-		var destinations []Destination
-		if err := json.Unmarshal(data, &destinations); err == nil {
-			return destinations
-		}
-		
-	case "tracks":
-		var wrapper struct {
-			Tracks []Track `json:"tracks"`
-		}
-		if err := json.Unmarshal(data, &wrapper); err == nil && len(wrapper.Tracks) > 0 {
-			return wrapper.Tracks
-		}
-		var tracks []Track
-		if err := json.Unmarshal(data, &tracks); err == nil {
-			return tracks
-		}
-		
-	case "promo":
-		var wrapper struct {
-			Promo []PromoAnnouncement `json:"promo"`
-		}
-		if err := json.Unmarshal(data, &wrapper); err == nil && len(wrapper.Promo) > 0 {
-			return wrapper.Promo
-		}
-		var promo []PromoAnnouncement
-		if err := json.Unmarshal(data, &promo); err == nil {
-			return promo
-		}
-		
-	case "safety":
-		var wrapper struct {
-			Safety []SafetyLanguage `json:"safety"`
-		}
-		if err := json.Unmarshal(data, &wrapper); err == nil && len(wrapper.Safety) > 0 {
-			return wrapper.Safety
-		}
-		var safety []SafetyLanguage
-		if err := json.Unmarshal(data, &safety); err == nil {
-			return safety
-		}
-		
-	case "emergencies":
-		var wrapper struct {
-			Emergencies []Emergency `json:"emergencies"`
-		}
-		if err := json.Unmarshal(data, &wrapper); err == nil && len(wrapper.Emergencies) > 0 {
-			return wrapper.Emergencies
-		}
-		var emergencies []Emergency
-		if err := json.Unmarshal(data, &emergencies); err == nil {
-			return emergencies
-		}
-		
-	case "cron":
-		var cronData CronData
-		if err := json.Unmarshal(data, &cronData); err == nil {
-			return cronData
+	if file := jsonFiles[name]; file.decode != nil {
+		if value, err := file.decode(data); err == nil {
+			return value
 		}
 	}
 
@@ -175,30 +41,14 @@ func loadJSON(name string, defaultValue interface{}) interface{} {
 }
 
 func saveJSON(name string, data interface{}) error {
-	var filePath string
-	
-	switch name {
-	case "trains":
-		filePath = filepath.Join(app.Config.JSONDir, "trains_selected.json")
-	case "trains_available":
-		filePath = filepath.Join(app.Config.JSONDir, "trains_available.json")
-	case "directions":
-		filePath = filepath.Join(app.Config.JSONDir, "directions.json")
-	case "destinations":
-		filePath = filepath.Join(app.Config.JSONDir, "destinations_selected.json")
-	case "destinations_available":
-		filePath = filepath.Join(app.Config.JSONDir, "destinations_available.json")
-	case "tracks":
-		filePath = filepath.Join(app.Config.JSONDir, "tracks.json")
-	case "promo":
-		filePath = filepath.Join(app.Config.JSONDir, "promo.json")
-	case "safety":
-		filePath = filepath.Join(app.Config.JSONDir, "safety.json")
-	case "emergencies":
-		filePath = filepath.Join(app.Config.JSONDir, "emergencies.json")
-	case "cron":
-		filePath = filepath.Join(app.Config.JSONDir, "cron.json")
-	default:
+	return saveJSONBy(name, data, "system", "")
+}
+
+// saveJSONBy is saveJSON naming who made the change, which is recorded in the
+// revision history of the schedule and catalog files
+func saveJSONBy(name string, data interface{}, author, note string) error {
+	filePath, ok := jsonFilePath(name)
+	if !ok {
 		return fmt.Errorf("unknown JSON file: %s", name)
 	}
 
@@ -207,6 +57,11 @@ func saveJSON(name string, data interface{}) error {
 		return err
 	}
 
+	if revisionTracked(name) {
+		if err := recordRevision(name, filePath, jsonData, author, note); err != nil {
+			log.Printf("Warning: failed to record revision of %s: %v", filepath.Base(filePath), err)
+		}
+	}
 	return os.WriteFile(filePath, jsonData, 0644)
 }
 
@@ -214,11 +69,8 @@ func saveJSON(name string, data interface{}) error {
 func updateScheduler() {
 	log.Println("Updating scheduler...")
 	
-	// Remove all existing jobs
-	entries := app.Scheduler.Entries()
-	for _, entry := range entries {
-		app.Scheduler.Remove(entry.ID)
-	}
+	// Unchanged jobs are kept; the rest are replaced once all are added
+	beginSchedulerReload()
 
 	cronData := loadJSON("cron", CronData{}).(CronData)
 
@@ -227,24 +79,23 @@ func updateScheduler() {
 		if item.Enabled {
 			// Capture variables for closure
 			trainNum, direction, destination, trackNum := item.TrainNumber, item.Direction, item.Destination, item.TrackNumber
-			_, err := app.Scheduler.AddFunc(item.Cron, func() {
+			languages, profiles := item.Languages, item.Profiles
+			index, spec := i, zonedCronSpec(cronData.Timezone, item.Cron)
+			err := addScheduledJob(spec, "station", scheduleEntryKey("station", i), "Train "+trainNum, item, func() error {
+				if !scheduledEntryRuns("station", index, spec, profiles, "Scheduled station announcement for train "+trainNum) {
+					return errScheduleHeld
+				}
 				log.Printf("🕐 Scheduled station announcement triggered: Train %s", trainNum)
-				if announcementManager != nil {
-					parameters := map[string]interface{}{
-						"train_number": trainNum,
-						"direction":    direction,
-						"destination":  destination,
-						"track_number": trackNum,
-					}
-					announcement, queueErr := announcementManager.QueueAnnouncement(TypeStation, PriorityNormal, parameters, time.Now())
-					if queueErr != nil {
-						log.Printf("Error queuing scheduled station announcement: %v", queueErr)
-					} else {
-						log.Printf("Scheduled station announcement queued successfully (ID: %s)", announcement.ID)
-					}
-				} else {
-					log.Printf("⚠️  Announcement manager not available for scheduled announcement")
+				parameters := map[string]interface{}{
+					"train_number": trainNum,
+					"direction":    direction,
+					"destination":  destination,
+					"track_number": trackNum,
+				}
+				if len(languages) > 0 {
+					parameters["languages"] = languages
 				}
+				return queueScheduled(TypeStation, PriorityNormal, parameters, "station announcement")
 			})
 			if err != nil {
 				log.Printf("Error scheduling station announcement %d: %v", i, err)
@@ -258,22 +109,20 @@ func updateScheduler() {
 	for i, item := range cronData.PromoAnnouncements {
 		if item.Enabled {
 			// Capture variables for closure
-			file := item.File
-			_, err := app.Scheduler.AddFunc(item.Cron, func() {
+			file, languages, profiles := item.File, item.Languages, item.Profiles
+			index, spec := i, zonedCronSpec(cronData.Timezone, item.Cron)
+			err := addScheduledJob(spec, "promo", scheduleEntryKey("promo", i), "Promo "+file, item, func() error {
+				if !scheduledEntryRuns("promo", index, spec, profiles, "Scheduled promo announcement "+file) {
+					return errScheduleHeld
+				}
 				log.Printf("🕐 Scheduled promo announcement triggered: %s", file)
-				if announcementManager != nil {
-					parameters := map[string]interface{}{
-						"file": file,
-					}
-					announcement, queueErr := announcementManager.QueueAnnouncement(TypePromo, PriorityLow, parameters, time.Now())
-					if queueErr != nil {
-						log.Printf("Error queuing scheduled promo announcement: %v", queueErr)
-					} else {
-						log.Printf("Scheduled promo announcement queued successfully (ID: %s)", announcement.ID)
-					}
-				} else {
-					log.Printf("⚠️  Announcement manager not available for scheduled announcement")
+				parameters := map[string]interface{}{
+					"file": file,
 				}
+				if len(languages) > 0 {
+					parameters["languages"] = languages
+				}
+				return queueScheduled(TypePromo, PriorityLow, parameters, "promo announcement")
 			})
 			if err != nil {
 				log.Printf("Error scheduling promo announcement %d: %v", i, err)
@@ -299,31 +148,54 @@ func updateScheduler() {
 			} else if item.Language != "" {
 				// Legacy single language format
 				languages = []string{item.Language}
+			} else if item.Delay > 0 {
+				// No languages: the rotation's, resolved when it fires
+				delay = item.Delay
 			} else {
-				log.Printf("Warning: Safety announcement %d has no language configured", i)
-				continue
+				delay = -1
 			}
 			
 			// Capture variables for closure
 			languagesCopy := make([]string, len(languages))
 			copy(languagesCopy, languages)
 			delaySeconds := delay
+			profiles := item.Profiles
+			index, spec := i, zonedCronSpec(cronData.Timezone, item.Cron)
+			name := "Safety rotation"
+			if len(languages) > 0 {
+				name = "Safety " + strings.Join(languages, ", ")
+			}
 			
-			_, err := app.Scheduler.AddFunc(item.Cron, func() {
-				if len(languagesCopy) == 1 {
+			err := addScheduledJob(spec, "safety", scheduleEntryKey("safety", i), name, item, func() error {
+				if !scheduledEntryRuns("safety", index, spec, profiles, "Scheduled safety announcement") {
+					return errScheduleHeld
+				}
+				languages, delaySeconds := languagesCopy, delaySeconds
+				if len(languages) == 0 {
+					rotation := loadSafetyRotation()
+					languages = safetyRotationLanguages(rotation)
+					if delaySeconds < 0 {
+						delaySeconds = rotation.DelaySeconds
+					}
+				}
+				if len(languages) == 0 {
+					log.Printf("Warning: Scheduled safety announcement has no languages to play")
+					return fmt.Errorf("no safety languages to play")
+				} else if len(languages) == 1 {
 					// Single language - use existing logic
-					log.Printf("🕐 Scheduled safety announcement triggered: %s", languagesCopy[0])
-					queueSafetyAnnouncement(languagesCopy[0])
-				} else {
-					// Multiple languages - queue sequentially with delays
-					log.Printf("🕐 Scheduled multi-language safety announcement triggered: %v", languagesCopy)
-					queueMultiLanguageSafetyAnnouncement(languagesCopy, delaySeconds)
+					log.Printf("🕐 Scheduled safety announcement triggered: %s", languages[0])
+					return queueSafetyAnnouncement(languages[0])
 				}
+				// Multiple languages - queue sequentially with delays
+				log.Printf("🕐 Scheduled multi-language safety announcement triggered: %v", languages)
+				return queueMultiLanguageSafetyAnnouncement(languages, delaySeconds)
 			})
 			if err != nil {
 				log.Printf("Error scheduling safety announcement %d: %v", i, err)
 			} else {
-				if len(languages) == 1 {
+				if len(languages) == 0 {
+					log.Printf("Scheduled: %s - safety rotation", item.Cron)
+				} else if len(languages) == 1 {
 					log.Printf("Scheduled: %s - %s", item.Cron, languages[0])
 				} else {
 					log.Printf("Scheduled: %s - %v (multi-language, %ds delay)", item.Cron, languages, delay)
@@ -332,31 +204,33 @@ func updateScheduler() {
 		}
 	}
 
-	log.Printf("Scheduler updated with %d active jobs.", len(app.Scheduler.Entries()))
+	// Weather reports
+	scheduleWeatherAnnouncements(cronData.WeatherAnnouncements, cronData.Timezone)
+
+	// Hourly chimes and time announcements
+	scheduleClockAnnouncements(cronData.ClockAnnouncements, cronData.Timezone)
+
+	// Speaker health test
+	scheduleSpeakerHealthTest()
+
+	kept, added, removed := finishSchedulerReload()
+	log.Printf("Scheduler updated with %d active jobs (%d unchanged, %d added, %d removed).", len(app.Scheduler.Entries()), kept, added, removed)
 }
 
 // queueSafetyAnnouncement queues a single safety announcement
-func queueSafetyAnnouncement(language string) {
-	if announcementManager != nil {
-		parameters := map[string]interface{}{
-			"language": language,
-		}
-		announcement, queueErr := announcementManager.QueueAnnouncement(TypeSafety, PriorityHigh, parameters, time.Now())
-		if queueErr != nil {
-			log.Printf("Error queuing scheduled safety announcement: %v", queueErr)
-		} else {
-			log.Printf("Scheduled safety announcement queued successfully (ID: %s)", announcement.ID)
-		}
-	} else {
-		log.Printf("⚠️  Announcement manager not available for scheduled announcement")
+func queueSafetyAnnouncement(language string) error {
+	parameters := map[string]interface{}{
+		"language": language,
 	}
+	return queueScheduled(TypeSafety, PriorityHigh, parameters, "safety announcement")
 }
 
 // queueMultiLanguageSafetyAnnouncement queues multiple safety announcements with delays
-func queueMultiLanguageSafetyAnnouncement(languages []string, delaySeconds int) {
+// The announcements are queued in the background and only logged
+func queueMultiLanguageSafetyAnnouncement(languages []string, delaySeconds int) error {
 	if announcementManager == nil {
 		log.Printf("⚠️  Announcement manager not available for scheduled announcements")
-		return
+		return fmt.Errorf("announcement manager not available")
 	}
 	
 	// Queue all languages with calculated delays
@@ -386,6 +260,7 @@ func queueMultiLanguageSafetyAnnouncement(languages []string, delaySeconds int)
 	}
 	
 	log.Printf("Queued %d safety announcements in sequence with %d second intervals", len(languages), delaySeconds)
+	return nil
 }
 
 // File system utilities
@@ -402,9 +277,12 @@ func dirExists(path string) bool {
 	return info.IsDir()
 }
 
-// Cron validation function
+// Cron validation function; the expression may start with CRON_TZ=<zone>
 func validateCronExpression(cronExpr string) error {
 	parts := strings.Fields(cronExpr)
+	if len(parts) > 0 && (strings.HasPrefix(parts[0], "CRON_TZ=") || strings.HasPrefix(parts[0], "TZ=")) {
+		parts = parts[1:]
+	}
 	if len(parts) != 5 {
 		return fmt.Errorf("cron expression must have exactly 5 fields")
 	}
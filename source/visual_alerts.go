@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Visual alerts put emergency and lightning announcements on screen for
+// guests who can't hear them, following ADA guidance that audible alarms have
+// a visual counterpart. When one of these announcements starts, an alert with
+// its text, severity and duration is published to the platform board, the
+// guest kiosk and any other display listening on /alerts/events, and an
+// optional strobe on a GPIO line flashes for as long as the alert lasts. The
+// alert goes up before any hooks or amplifiers, so it shows even if the audio
+// fails. Settings are the visual_alerts section of board_settings.json.
+
+// VisualAlertStrobe is a strobe or beacon switched by a GPIO line
+type VisualAlertStrobe struct {
+	Chip       string   `json:"chip"` // Default: gpiochip0
+	Line       int      `json:"line"`
+	ActiveLow  bool     `json:"active_low,omitempty"`
+	Severities []string `json:"severities,omitempty"` // Default: critical
+}
+
+// VisualAlertSettings is the visual_alerts section of board_settings.json
+type VisualAlertSettings struct {
+	Enabled            bool               `json:"enabled"`
+	Types              []string           `json:"types"`                // Announcement types that raise an alert
+	MinDurationSeconds int                `json:"min_duration_seconds"` // Shown for at least this long, however short the audio
+	Strobe             *VisualAlertStrobe `json:"strobe,omitempty"`
+}
+
+func getDefaultVisualAlertSettings() VisualAlertSettings {
+	return VisualAlertSettings{
+		Enabled:            true,
+		Types:              []string{string(TypeEmergency), string(TypeLightning)},
+		MinDurationSeconds: 30,
+	}
+}
+
+// VisualAlert is published to displays when an alerting announcement starts
+type VisualAlert struct {
+	ID              string           `json:"id"` // The announcement's ID
+	Type            AnnouncementType `json:"type"`
+	Severity        string           `json:"severity"` // critical, warning or info
+	Title           string           `json:"title"`
+	Text            string           `json:"text"`
+	DurationSeconds int              `json:"duration_seconds"`
+	StartedAt       time.Time        `json:"started_at"`
+	ExpiresAt       time.Time        `json:"expires_at"`
+	Test            bool             `json:"test,omitempty"`
+}
+
+var visualAlerts = struct {
+	sync.Mutex
+	current *VisualAlert
+	strobe  *gpioLine
+	// Bumped by every alert, so an older alert's timer leaves a newer one alone
+	generation int
+	strobeErr  string
+}{}
+
+// visualAlertSeverity grades an announcement: emergencies and a lightning red
+// alert are critical, a lightning warning is a warning, anything else info
+func visualAlertSeverity(announcement Announcement) string {
+	switch announcement.Type {
+	case TypeEmergency:
+		return "critical"
+	case TypeLightning:
+		condition, _ := announcement.Parameters["condition"].(string)
+		switch strings.ToLower(condition) {
+		case "redalert":
+			return "critical"
+		case "warning":
+			return "warning"
+		}
+	}
+	return "info"
+}
+
+// visualAlertText is what the alert says: the announcement's caption when it
+// has one, otherwise its title
+func visualAlertText(announcement Announcement, locale string) string {
+	if announcement.Caption != "" {
+		return announcement.Caption
+	}
+	return announcementTitle(announcement, locale)
+}
+
+// lightningAlertText tells guests what a lightning condition means for them,
+// or "" for a condition they needn't act on
+func lightningAlertText(locale, condition string) string {
+	switch strings.ToLower(condition) {
+	case "redalert":
+		return translate(locale, "kiosk.lightning_redalert", "Lightning in the area - please take shelter")
+	case "warning":
+		return translate(locale, "kiosk.lightning_warning", "Lightning nearby - be ready to take shelter")
+	case "allclear":
+		return translate(locale, "alert.lightning_allclear", "All clear - the lightning has passed")
+	}
+	return ""
+}
+
+// raiseVisualAlert publishes an alert for an announcement that is starting,
+// if its type raises one
+func raiseVisualAlert(announcement Announcement) {
+	board := loadBoardSettings()
+	settings := board.VisualAlerts
+	if !settings.Enabled || !containsString(settings.Types, string(announcement.Type)) {
+		return
+	}
+	duration := announcement.EstimatedDuration
+	if minimum := time.Duration(settings.MinDurationSeconds) * time.Second; duration < minimum {
+		duration = minimum
+	}
+	now := time.Now()
+	showVisualAlert(settings, VisualAlert{
+		ID:              announcement.ID,
+		Type:            announcement.Type,
+		Severity:        visualAlertSeverity(announcement),
+		Title:           announcementTitle(announcement, board.Locale),
+		Text:            visualAlertText(announcement, board.Locale),
+		DurationSeconds: int(duration.Round(time.Second).Seconds()),
+		StartedAt:       now,
+		ExpiresAt:       now.Add(duration),
+	})
+}
+
+// showVisualAlert makes an alert current, switches the strobe for it and
+// clears both when it expires
+func showVisualAlert(settings VisualAlertSettings, alert VisualAlert) {
+	visualAlerts.Lock()
+	visualAlerts.generation++
+	generation := visualAlerts.generation
+	visualAlerts.current = &alert
+	strobe := settings.Strobe
+	if strobe != nil && !containsString(strobeSeverities(strobe), alert.Severity) {
+		strobe = nil
+	}
+	setStrobeLocked(strobe)
+	visualAlerts.Unlock()
+
+	log.Printf("🚨 Visual alert (%s): %s", alert.Severity, alert.Text)
+	queueEvents.publish()
+
+	time.AfterFunc(time.Until(alert.ExpiresAt), func() {
+		visualAlerts.Lock()
+		if visualAlerts.generation != generation {
+			visualAlerts.Unlock()
+			return
+		}
+		visualAlerts.current = nil
+		setStrobeLocked(nil)
+		visualAlerts.Unlock()
+		queueEvents.publish()
+	})
+}
+
+// strobeSeverities lists the alert severities that flash the strobe
+func strobeSeverities(strobe *VisualAlertStrobe) []string {
+	if len(strobe.Severities) == 0 {
+		return []string{"critical"}
+	}
+	return strobe.Severities
+}
+
+// setStrobeLocked switches the strobe on for an alert, or off when strobe is
+// nil. The line is only held while it is on. Caller must hold visualAlerts.
+func setStrobeLocked(strobe *VisualAlertStrobe) {
+	if strobe == nil {
+		if visualAlerts.strobe != nil {
+			if err := visualAlerts.strobe.set(false); err != nil {
+				log.Printf("⚠️  Could not switch the alert strobe off: %v", err)
+			}
+			visualAlerts.strobe.close()
+			visualAlerts.strobe = nil
+		}
+		return
+	}
+	if visualAlerts.strobe != nil {
+		return
+	}
+	chip := strobe.Chip
+	if chip == "" {
+		chip = "gpiochip0"
+	}
+	line, err := openGPIOLine(chip, strobe.Line, strobe.ActiveLow)
+	if err == nil {
+		if err = line.set(true); err != nil {
+			line.close()
+		}
+	}
+	if err != nil {
+		visualAlerts.strobeErr = err.Error()
+		log.Printf("⚠️  Could not switch the alert strobe on: %v", err)
+		return
+	}
+	visualAlerts.strobeErr = ""
+	visualAlerts.strobe = line
+}
+
+// currentVisualAlert returns the alert on screen, or nil
+func currentVisualAlert() *VisualAlert {
+	visualAlerts.Lock()
+	defer visualAlerts.Unlock()
+	if visualAlerts.current == nil || time.Now().After(visualAlerts.current.ExpiresAt) {
+		return nil
+	}
+	alert := *visualAlerts.current
+	return &alert
+}
+
+// clearVisualAlert takes down the current alert and switches the strobe off
+func clearVisualAlert() {
+	visualAlerts.Lock()
+	visualAlerts.generation++
+	visualAlerts.current = nil
+	setStrobeLocked(nil)
+	visualAlerts.Unlock()
+	queueEvents.publish()
+}
+
+// Handlers
+
+// visualAlertEventsHandler streams the current alert as server-sent events
+// for displays other than the board and kiosk. An "alert" event is sent on
+// connect and whenever the alert changes; its data is null when it clears.
+func visualAlertEventsHandler(c *gin.Context) {
+	events := queueEvents.subscribe()
+	defer queueEvents.unsubscribe(events)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	lastID := "-"
+	send := func() bool {
+		alert := currentVisualAlert()
+		id := ""
+		if alert != nil {
+			id = alert.ID
+		}
+		if id == lastID {
+			return true
+		}
+		lastID = id
+		data, _ := json.Marshal(alert)
+		if _, err := fmt.Fprintf(c.Writer, "event: alert\ndata: %s\n\n", data); err != nil {
+			return false
+		}
+		c.Writer.Flush()
+		return true
+	}
+
+	if !send() {
+		return
+	}
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-events:
+			if !send() {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// apiVisualAlertHandler returns the current alert for displays that poll
+func apiVisualAlertHandler(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	respondOK(c, gin.H{"alert": currentVisualAlert()})
+}
+
+// apiVisualAlertStatusHandler returns the settings, the current alert and the
+// strobe's state
+func apiVisualAlertStatusHandler(c *gin.Context) {
+	settings := loadBoardSettings().VisualAlerts
+	alert := currentVisualAlert()
+	visualAlerts.Lock()
+	strobeOn := visualAlerts.strobe != nil
+	strobeErr := visualAlerts.strobeErr
+	visualAlerts.Unlock()
+	respondOK(c, gin.H{
+		"settings":     settings,
+		"alert":        alert,
+		"strobe_on":    strobeOn,
+		"strobe_error": strobeErr,
+	})
+}
+
+// apiTestVisualAlertHandler shows a test alert on the displays and flashes the
+// strobe, without playing anything. "clear": true takes the alert down.
+func apiTestVisualAlertHandler(c *gin.Context) {
+	data, ok := bindRequestData(c, "severity", "text", "duration_seconds", "clear")
+	if !ok {
+		return
+	}
+	if data["clear"] == true || data["clear"] == "true" {
+		clearVisualAlert()
+		respondSuccess(c, http.StatusOK, "Visual alert cleared", nil)
+		return
+	}
+
+	severity := "critical"
+	if value, ok := data["severity"].(string); ok && value != "" {
+		severity = value
+	}
+	if severity != "critical" && severity != "warning" && severity != "info" {
+		respondValidationError(c, "Invalid visual alert", FieldError{Field: "severity", Message: "must be critical, warning or info"})
+		return
+	}
+	duration, err := intField(data, "duration_seconds", 10)
+	if err != nil || duration < 1 || duration > 300 {
+		respondValidationError(c, "Invalid visual alert", FieldError{Field: "duration_seconds", Message: "must be between 1 and 300"})
+		return
+	}
+	board := loadBoardSettings()
+	text, _ := data["text"].(string)
+	if strings.TrimSpace(text) == "" {
+		text = translate(board.Locale, "alert.test", "This is a test of the visual alert system")
+	} else if text, err = applyContentFilter("text", text, "visual_alert", requestOperator(c)); err != nil {
+		respondQueueError(c, "Invalid visual alert", err)
+		return
+	}
+
+	now := time.Now()
+	alert := VisualAlert{
+		ID:              fmt.Sprintf("test-%d", now.UnixNano()),
+		Type:            TypeEmergency,
+		Severity:        severity,
+		Title:           translate(board.Locale, "alert.test_title", "Test alert"),
+		Text:            text,
+		DurationSeconds: duration,
+		StartedAt:       now,
+		ExpiresAt:       now.Add(time.Duration(duration) * time.Second),
+		Test:            true,
+	}
+	showVisualAlert(board.VisualAlerts, alert)
+	log.Printf("Visual alert test by %s", requestOperator(c))
+	respondSuccess(c, http.StatusOK, "Visual alert shown", gin.H{"alert": alert})
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A weather report is a spoken announcement built from live conditions: the
+// configured provider (OpenWeatherMap or the US National Weather Service) is
+// queried, the result is rendered through a text template ("Good morning.
+// Today's high is 84 degrees...") and the sentence is synthesized by the TTS
+// engine. Reports are queued on demand or from weather_announcements in
+// cron.json. Settings live in json/weather.json.
+
+// WeatherSettings selects the weather source and the report template
+type WeatherSettings struct {
+	Provider       string  `json:"provider"` // openweathermap or nws
+	APIKey         string  `json:"api_key"`  // OpenWeatherMap only
+	BaseURL        string  `json:"base_url"` // Overrides the provider's API address
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	Location       string  `json:"location"`   // Name used in the report (defaults to the provider's)
+	Units          string  `json:"units"`      // imperial or metric
+	UserAgent      string  `json:"user_agent"` // NWS asks for a contact in the User-Agent
+	TimeoutSeconds int     `json:"timeout_seconds"`
+	Template       string  `json:"template"` // text/template rendered with a WeatherReport
+}
+
+// WeatherReport holds the conditions a report template is rendered with
+type WeatherReport struct {
+	Provider    string    `json:"provider"`
+	Location    string    `json:"location"`
+	Greeting    string    `json:"greeting"`    // Good morning, Good afternoon or Good evening
+	Temperature int       `json:"temperature"` // Current temperature
+	High        int       `json:"high"`        // Today's (or the next day's) high
+	Low         int       `json:"low"`         // Tonight's low
+	Humidity    int       `json:"humidity,omitempty"`
+	Conditions  string    `json:"conditions"` // Short description, e.g. "partly cloudy"
+	Forecast    string    `json:"forecast"`   // Longer forecast text where the provider has one
+	Wind        string    `json:"wind"`       // e.g. "10 miles per hour from the southwest"
+	Unit        string    `json:"unit"`       // degrees unit spoken, Fahrenheit or Celsius
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+const defaultWeatherTemplate = "{{.Greeting}}. It is currently {{.Temperature}} degrees and {{.Conditions}}. " +
+	"Today's high is {{.High}} degrees with a low of {{.Low}} degrees."
+
+func getDefaultWeatherSettings() WeatherSettings {
+	return WeatherSettings{
+		Provider:       "nws",
+		Units:          "imperial",
+		UserAgent:      "TARR Annunciator",
+		TimeoutSeconds: 10,
+		Template:       defaultWeatherTemplate,
+	}
+}
+
+var weatherProviders = map[string]func(*http.Client, WeatherSettings) (*WeatherReport, error){
+	"openweathermap": fetchOpenWeatherMap,
+	"nws":            fetchNWSForecast,
+}
+
+// The last report fetched, shown by GET /api/weather
+var (
+	lastWeatherReport *WeatherReport
+	lastWeatherError  string
+	weatherMutex      sync.Mutex
+)
+
+// loadWeatherSettings reads weather.json, falling back to defaults
+func loadWeatherSettings() (WeatherSettings, error) {
+	settings := getDefaultWeatherSettings()
+	path, _ := jsonFilePath("weather")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, fmt.Errorf("failed to read weather.json: %v", err)
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return getDefaultWeatherSettings(), fmt.Errorf("failed to parse weather.json: %v", err)
+	}
+	return settings, nil
+}
+
+// validate checks the settings and returns any problems by field
+func (s WeatherSettings) validate() []FieldError {
+	var details []FieldError
+	if _, ok := weatherProviders[s.Provider]; !ok {
+		details = append(details, FieldError{Field: "provider", Message: "must be openweathermap or nws"})
+	}
+	if s.Provider == "openweathermap" && s.APIKey == "" {
+		details = append(details, FieldError{Field: "api_key", Message: "is required for openweathermap"})
+	}
+	if s.Latitude < -90 || s.Latitude > 90 {
+		details = append(details, FieldError{Field: "latitude", Message: "must be between -90 and 90"})
+	}
+	if s.Longitude < -180 || s.Longitude > 180 {
+		details = append(details, FieldError{Field: "longitude", Message: "must be between -180 and 180"})
+	}
+	if s.Units != "imperial" && s.Units != "metric" {
+		details = append(details, FieldError{Field: "units", Message: "must be imperial or metric"})
+	}
+	if s.BaseURL != "" {
+		if parsed, err := url.Parse(s.BaseURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			details = append(details, FieldError{Field: "base_url", Message: "must be an http or https URL"})
+		}
+	}
+	if s.TimeoutSeconds < 1 {
+		details = append(details, FieldError{Field: "timeout_seconds", Message: "must be at least 1"})
+	}
+	if _, err := parseWeatherTemplate(s.Template); err != nil {
+		details = append(details, FieldError{Field: "template", Message: err.Error()})
+	}
+	return details
+}
+
+func parseWeatherTemplate(text string) (*template.Template, error) {
+	if strings.TrimSpace(text) == "" {
+		text = defaultWeatherTemplate
+	}
+	return template.New("weather").Option("missingkey=error").Parse(text)
+}
+
+// fetchWeather queries the configured provider for current conditions
+func fetchWeather(settings WeatherSettings) (*WeatherReport, error) {
+	fetch, ok := weatherProviders[settings.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown weather provider '%s'", settings.Provider)
+	}
+	client := &http.Client{Timeout: time.Duration(settings.TimeoutSeconds) * time.Second}
+	report, err := fetch(client, settings)
+
+	weatherMutex.Lock()
+	defer weatherMutex.Unlock()
+	if err != nil {
+		lastWeatherError = err.Error()
+		return nil, err
+	}
+	report.Provider = settings.Provider
+	report.Greeting = weatherGreeting(time.Now())
+	report.Unit = "Fahrenheit"
+	if settings.Units == "metric" {
+		report.Unit = "Celsius"
+	}
+	if settings.Location != "" {
+		report.Location = settings.Location
+	}
+	report.FetchedAt = time.Now()
+	lastWeatherReport, lastWeatherError = report, ""
+	return report, nil
+}
+
+func weatherGreeting(now time.Time) string {
+	switch hour := now.Hour(); {
+	case hour < 12:
+		return "Good morning"
+	case hour < 17:
+		return "Good afternoon"
+	default:
+		return "Good evening"
+	}
+}
+
+// renderWeatherReport fills in the report template
+func renderWeatherReport(templateText string, report *WeatherReport) (string, error) {
+	tmpl, err := parseWeatherTemplate(templateText)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, report); err != nil {
+		return "", err
+	}
+	return normalizeTTSText(out.String()), nil
+}
+
+// getWeatherJSON fetches a provider URL and decodes the JSON response
+func getWeatherJSON(client *http.Client, requestURL, userAgent string, target interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/geo+json, application/json")
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("weather request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read weather response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("weather service returned %s", resp.Status)
+	}
+	if err := json.Unmarshal(body, target); err != nil {
+		return fmt.Errorf("failed to parse weather response: %v", err)
+	}
+	return nil
+}
+
+// fetchOpenWeatherMap reads current conditions from the OpenWeatherMap API
+func fetchOpenWeatherMap(client *http.Client, settings WeatherSettings) (*WeatherReport, error) {
+	base := settings.BaseURL
+	if base == "" {
+		base = "https://api.openweathermap.org"
+	}
+	query := url.Values{}
+	query.Set("lat", fmt.Sprint(settings.Latitude))
+	query.Set("lon", fmt.Sprint(settings.Longitude))
+	query.Set("units", settings.Units)
+	query.Set("appid", settings.APIKey)
+
+	var current struct {
+		Name string `json:"name"`
+		Main struct {
+			Temp     float64 `json:"temp"`
+			TempMin  float64 `json:"temp_min"`
+			TempMax  float64 `json:"temp_max"`
+			Humidity float64 `json:"humidity"`
+		} `json:"main"`
+		Weather []struct {
+			Description string `json:"description"`
+		} `json:"weather"`
+		Wind struct {
+			Speed float64 `json:"speed"`
+			Deg   float64 `json:"deg"`
+		} `json:"wind"`
+	}
+	if err := getWeatherJSON(client, strings.TrimRight(base, "/")+"/data/2.5/weather?"+query.Encode(), settings.UserAgent, &current); err != nil {
+		return nil, err
+	}
+
+	report := &WeatherReport{
+		Location:    current.Name,
+		Temperature: roundInt(current.Main.Temp),
+		High:        roundInt(current.Main.TempMax),
+		Low:         roundInt(current.Main.TempMin),
+		Humidity:    roundInt(current.Main.Humidity),
+	}
+	if len(current.Weather) > 0 {
+		report.Conditions = current.Weather[0].Description
+		report.Forecast = current.Weather[0].Description
+	}
+	speedUnit := "miles per hour"
+	if settings.Units == "metric" {
+		speedUnit = "meters per second"
+	}
+	report.Wind = fmt.Sprintf("%d %s from the %s", roundInt(current.Wind.Speed), speedUnit, compassDirection(current.Wind.Deg))
+	return report, nil
+}
+
+// fetchNWSForecast reads the forecast for a point from api.weather.gov. The
+// first period gives the current conditions; the first daytime and night
+// periods give the high and low.
+func fetchNWSForecast(client *http.Client, settings WeatherSettings) (*WeatherReport, error) {
+	base := settings.BaseURL
+	if base == "" {
+		base = "https://api.weather.gov"
+	}
+
+	var point struct {
+		Properties struct {
+			Forecast         string `json:"forecast"`
+			RelativeLocation struct {
+				Properties struct {
+					City  string `json:"city"`
+					State string `json:"state"`
+				} `json:"properties"`
+			} `json:"relativeLocation"`
+		} `json:"properties"`
+	}
+	pointURL := fmt.Sprintf("%s/points/%.4f,%.4f", strings.TrimRight(base, "/"), settings.Latitude, settings.Longitude)
+	if err := getWeatherJSON(client, pointURL, settings.UserAgent, &point); err != nil {
+		return nil, err
+	}
+	if point.Properties.Forecast == "" {
+		return nil, fmt.Errorf("weather service has no forecast for %.4f,%.4f", settings.Latitude, settings.Longitude)
+	}
+
+	forecastURL := point.Properties.Forecast
+	if settings.Units == "metric" {
+		forecastURL += "?units=si"
+	}
+	var forecast struct {
+		Properties struct {
+			Periods []struct {
+				Name             string  `json:"name"`
+				IsDaytime        bool    `json:"isDaytime"`
+				Temperature      float64 `json:"temperature"`
+				WindSpeed        string  `json:"windSpeed"`
+				WindDirection    string  `json:"windDirection"`
+				ShortForecast    string  `json:"shortForecast"`
+				DetailedForecast string  `json:"detailedForecast"`
+			} `json:"periods"`
+		} `json:"properties"`
+	}
+	if err := getWeatherJSON(client, forecastURL, settings.UserAgent, &forecast); err != nil {
+		return nil, err
+	}
+	periods := forecast.Properties.Periods
+	if len(periods) == 0 {
+		return nil, fmt.Errorf("weather service returned no forecast periods")
+	}
+
+	now := periods[0]
+	report := &WeatherReport{
+		Location:    point.Properties.RelativeLocation.Properties.City,
+		Temperature: roundInt(now.Temperature),
+		Conditions:  strings.ToLower(now.ShortForecast),
+		Forecast:    now.DetailedForecast,
+		Wind:        strings.TrimSpace(strings.Replace(now.WindSpeed, "mph", "miles per hour", 1) + " from the " + compassWords(now.WindDirection)),
+	}
+	highSet, lowSet := false, false
+	for _, period := range periods {
+		if period.IsDaytime && !highSet {
+			report.High, highSet = roundInt(period.Temperature), true
+		}
+		if !period.IsDaytime && !lowSet {
+			report.Low, lowSet = roundInt(period.Temperature), true
+		}
+	}
+	return report, nil
+}
+
+func roundInt(value float64) int {
+	return int(math.Round(value))
+}
+
+var compassPoints = []string{"N", "NE", "E", "SE", "S", "SW", "W", "NW"}
+
+// compassDirection converts a wind bearing in degrees to spoken words
+func compassDirection(degrees float64) string {
+	index := int(math.Round(math.Mod(degrees+360, 360)/45)) % len(compassPoints)
+	return compassWords(compassPoints[index])
+}
+
+// compassWords spells out a compass abbreviation such as SW or NNE
+func compassWords(abbreviation string) string {
+	words := map[byte]string{'N': "north", 'E': "east", 'S': "south", 'W': "west"}
+	abbreviation = strings.ToUpper(strings.TrimSpace(abbreviation))
+	if abbreviation == "" {
+		return "variable directions"
+	}
+	// Three letter points (NNE) are spoken as their two letter neighbour
+	if len(abbreviation) == 3 {
+		abbreviation = abbreviation[1:]
+	}
+	spoken := ""
+	for i := 0; i < len(abbreviation); i++ {
+		word, ok := words[abbreviation[i]]
+		if !ok {
+			return strings.ToLower(abbreviation)
+		}
+		spoken += word
+	}
+	return spoken
+}
+
+// prepareWeatherReport fetches the weather and renders the report text.
+// templateText overrides the template in weather.json when set.
+func prepareWeatherReport(templateText string) (string, *WeatherReport, error) {
+	settings, err := loadWeatherSettings()
+	if err != nil {
+		return "", nil, err
+	}
+	if details := settings.validate(); len(details) > 0 {
+		return "", nil, fmt.Errorf("weather.json is invalid: %s %s", details[0].Field, details[0].Message)
+	}
+	report, err := fetchWeather(settings)
+	if err != nil {
+		return "", nil, err
+	}
+	if strings.TrimSpace(templateText) == "" {
+		templateText = settings.Template
+	}
+	text, err := renderWeatherReport(templateText, report)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to render weather report: %v", err)
+	}
+	return text, report, nil
+}
+
+// queueWeatherAnnouncement fetches, renders and synthesizes a weather report
+// and queues it. Synthesis happens before queuing so the queue is never held
+// up waiting for the TTS engine.
+func queueWeatherAnnouncement(templateText string, priority AnnouncementPriority, parameters map[string]interface{}, options AnnouncementOptions) (*Announcement, string, error) {
+	if announcementManager == nil {
+		return nil, "", fmt.Errorf("announcement manager not available")
+	}
+	// Don't fetch and synthesize a report that won't be queued
+	if err := checkAnnouncementSwitch(TypeWeather); err != nil {
+		return nil, "", err
+	}
+	text, _, err := prepareWeatherReport(templateText)
+	if err != nil {
+		return nil, "", err
+	}
+	if text, err = applyContentFilter("template", text, string(TypeWeather), options.RequestedBy); err != nil {
+		return nil, "", err
+	}
+	if _, err := synthesizeSpeech(text); err != nil {
+		return nil, text, err
+	}
+
+	if parameters == nil {
+		parameters = map[string]interface{}{}
+	}
+	parameters["text"] = text
+	announcement, err := announcementManager.QueueAnnouncementWithOptions(TypeWeather, priority, parameters, time.Now(), options)
+	return announcement, text, err
+}
+
+// scheduleWeatherAnnouncements adds the weather_announcements cron jobs
+func scheduleWeatherAnnouncements(jobs []WeatherCronJob, timezone string) {
+	for i, item := range jobs {
+		if !item.Enabled {
+			continue
+		}
+		templateText, zone, profiles := item.Template, item.Zone, item.Profiles
+		index, spec := i, zonedCronSpec(timezone, item.Cron)
+		priority := PriorityNormal
+		if item.Priority != "" {
+			priority = ParsePriority(item.Priority)
+		}
+		err := addScheduledJob(spec, "weather", scheduleEntryKey("weather", i), "Weather report", item, func() error {
+			if !scheduledEntryRuns("weather", index, spec, profiles, "Scheduled weather report") {
+				return errScheduleHeld
+			}
+			log.Printf("🕐 Scheduled weather report triggered")
+			var parameters map[string]interface{}
+			if zone != "" {
+				parameters = map[string]interface{}{"zone": zone}
+			}
+			announcement, text, err := queueWeatherAnnouncement(templateText, priority, parameters, AnnouncementOptions{})
+			if err != nil {
+				log.Printf("Error queuing scheduled weather report: %v", err)
+				return err
+			}
+			log.Printf("Scheduled weather report queued successfully (ID: %s): %s", announcement.ID, text)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error scheduling weather report %d: %v", i, err)
+		} else {
+			log.Printf("Scheduled: %s - weather report", item.Cron)
+		}
+	}
+}
+
+// API handlers
+
+// weatherSettingsView hides the API key when settings are returned
+func weatherSettingsView(settings WeatherSettings) gin.H {
+	return gin.H{
+		"provider":        settings.Provider,
+		"api_key_set":     settings.APIKey != "",
+		"base_url":        settings.BaseURL,
+		"latitude":        settings.Latitude,
+		"longitude":       settings.Longitude,
+		"location":        settings.Location,
+		"units":           settings.Units,
+		"user_agent":      settings.UserAgent,
+		"timeout_seconds": settings.TimeoutSeconds,
+		"template":        settings.Template,
+	}
+}
+
+// apiGetWeatherHandler returns the weather settings and the last report fetched
+func apiGetWeatherHandler(c *gin.Context) {
+	settings, err := loadWeatherSettings()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	weatherMutex.Lock()
+	report, lastError := lastWeatherReport, lastWeatherError
+	weatherMutex.Unlock()
+	respondOK(c, gin.H{
+		"settings":    weatherSettingsView(settings),
+		"last_report": report,
+		"last_error":  lastError,
+	})
+}
+
+// apiUpdateWeatherSettingsHandler replaces weather.json. An empty api_key
+// keeps the current one.
+func apiUpdateWeatherSettingsHandler(c *gin.Context) {
+	current, err := loadWeatherSettings()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	settings := getDefaultWeatherSettings()
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	if settings.APIKey == "" {
+		settings.APIKey = current.APIKey
+	}
+	if strings.TrimSpace(settings.Template) == "" {
+		settings.Template = defaultWeatherTemplate
+	}
+	if details := settings.validate(); len(details) > 0 {
+		respondValidationError(c, "Invalid weather settings", details...)
+		return
+	}
+	if err := saveJSON("weather", settings); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save weather settings: "+err.Error())
+		return
+	}
+	log.Printf("Weather settings updated (provider %s)", settings.Provider)
+	respondSuccess(c, http.StatusOK, "Weather settings updated", weatherSettingsView(settings))
+}
+
+// apiWeatherPreviewHandler fetches the weather and returns the report text
+// without speaking it. An optional "template" tries out a new template.
+func apiWeatherPreviewHandler(c *gin.Context) {
+	data, ok := bindRequestData(c, "template")
+	if !ok {
+		return
+	}
+	templateText, _ := data["template"].(string)
+	if templateText != "" {
+		if _, err := parseWeatherTemplate(templateText); err != nil {
+			respondValidationError(c, "Invalid weather template", FieldError{Field: "template", Message: err.Error()})
+			return
+		}
+	}
+	text, report, err := prepareWeatherReport(templateText)
+	if err != nil {
+		respondError(c, http.StatusBadGateway, ErrCodeUnavailable, err.Error())
+		return
+	}
+	respondOK(c, gin.H{"text": text, "report": report})
+}
+
+// apiAnnounceWeatherHandler queues a weather report now
+func apiAnnounceWeatherHandler(c *gin.Context) {
+	data, ok := bindRequestData(c, "template", "priority", "zone")
+	if !ok {
+		return
+	}
+	templateText, _ := data["template"].(string)
+	if templateText != "" {
+		if _, err := parseWeatherTemplate(templateText); err != nil {
+			respondValidationError(c, "Invalid weather template", FieldError{Field: "template", Message: err.Error()})
+			return
+		}
+	}
+	priority := PriorityNormal
+	if value, _ := data["priority"].(string); value != "" {
+		priority = ParsePriority(value)
+	}
+	var parameters map[string]interface{}
+	if zone, _ := data["zone"].(string); zone != "" {
+		parameters = map[string]interface{}{"zone": zone}
+	}
+
+	options := AnnouncementOptions{RequestedBy: c.GetString("announcement_requester")}
+	announcement, text, err := queueWeatherAnnouncement(templateText, priority, parameters, options)
+	switch err.(type) {
+	case *announcementDisabledError, *announcementParameterError:
+		respondQueueError(c, "Failed to queue weather report", err)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusBadGateway, ErrCodeUnavailable, "Failed to queue weather report: "+err.Error())
+		return
+	}
+	respondSuccess(c, http.StatusOK, "Weather report queued", gin.H{
+		"announcement_id": announcement.ID,
+		"text":            text,
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Delays between webhook delivery attempts
+var webhookRetryDelays = []time.Duration{0, 2 * time.Second, 10 * time.Second, 30 * time.Second}
+
+// AnnouncementWebhookPayload is POSTed to an announcement's callback_url
+type AnnouncementWebhookPayload struct {
+	Event        string       `json:"event"` // announcement.completed, .failed, .cancelled or .expired
+	Announcement Announcement `json:"announcement"`
+	Timestamp    string       `json:"timestamp"`
+}
+
+// validateCallbackURL checks a callback URL supplied by an API client
+func validateCallbackURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %v", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("must be an http or https URL")
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("must include a host")
+	}
+	return nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>"
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookSecret reads the signing secret from admin_config.json
+func webhookSecret() string {
+	adminConfig, err := loadAdminConfig(filepath.Join(app.Config.JSONDir, "admin_config.json"))
+	if err != nil {
+		return ""
+	}
+	return adminConfig.API.WebhookSecret
+}
+
+// deliverAnnouncementWebhook POSTs the final state of an announcement to its
+// callback URL, retrying a few times on network errors and 5xx responses
+func deliverAnnouncementWebhook(announcement Announcement) {
+	payload := AnnouncementWebhookPayload{
+		Event:        "announcement." + string(announcement.Status),
+		Announcement: announcement,
+		Timestamp:    time.Now().Format(time.RFC3339),
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Webhook for %s not sent: %v", announcement.ID, err)
+		return
+	}
+
+	secret := webhookSecret()
+	if secret == "" {
+		log.Printf("⚠️  api.webhook_secret is not set - webhook for %s will be unsigned", announcement.ID)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	for attempt, delay := range webhookRetryDelays {
+		time.Sleep(delay)
+
+		req, err := http.NewRequest(http.MethodPost, announcement.CallbackURL, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Webhook for %s not sent: %v", announcement.ID, err)
+			return
+		}
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "TARR-Annunciator-Webhook")
+		req.Header.Set("X-TARR-Event", payload.Event)
+		req.Header.Set("X-TARR-Timestamp", timestamp)
+		if secret != "" {
+			req.Header.Set("X-TARR-Signature", "sha256="+signWebhook(secret, timestamp, body))
+		}
+
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 500 {
+				if resp.StatusCode >= 300 {
+					log.Printf("Webhook for %s rejected by %s: HTTP %d", announcement.ID, announcement.CallbackURL, resp.StatusCode)
+				} else {
+					log.Printf("Webhook %s delivered for %s", payload.Event, announcement.ID)
+				}
+				return
+			}
+			err = fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		log.Printf("Webhook attempt %d/%d for %s failed: %v", attempt+1, len(webhookRetryDelays), announcement.ID, err)
+	}
+
+	log.Printf("❌ Giving up on webhook for %s to %s", announcement.ID, announcement.CallbackURL)
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A zone's amplifier can be switched by a GPIO-driven relay, so it isn't
+// powered around the clock. The relay is energized warm_up_ms before an
+// announcement for the zone starts playing, which keeps the amplifier's
+// power-on mute from clipping the first word, and released hold_seconds after
+// the last announcement using it ends, so back-to-back announcements don't
+// cycle it. Zones that share an amplifier name the same chip and line and
+// share its relay. Announcements without zones power the zones served by the
+// selected output device. Relays are switched off at startup, when the layout
+// no longer lists them and when the annunciator shuts down.
+
+// ZoneAmplifier is the amplifier relay of a zone in track_layout.json
+type ZoneAmplifier struct {
+	Chip        string `json:"chip,omitempty"` // GPIO chip, gpiochip0 by default
+	Line        int    `json:"line"`           // Line on the chip; on a Raspberry Pi's gpiochip0 the BCM GPIO number
+	ActiveLow   bool   `json:"active_low"`     // The relay energizes when the line is low
+	WarmUpMs    int    `json:"warm_up_ms"`     // Energized this long before playback starts
+	HoldSeconds int    `json:"hold_seconds"`   // Kept energized this long after playback ends
+}
+
+// chipName returns the GPIO chip the relay is wired to
+func (a *ZoneAmplifier) chipName() string {
+	if a.Chip == "" {
+		return "gpiochip0"
+	}
+	return strings.TrimPrefix(a.Chip, "/dev/")
+}
+
+// relayKey identifies the relay; zones naming the same line share it
+func (a *ZoneAmplifier) relayKey() string {
+	return fmt.Sprintf("%s/%d", a.chipName(), a.Line)
+}
+
+// validate checks a zone's amplifier settings
+func (a *ZoneAmplifier) validate(field string) []FieldError {
+	var details []FieldError
+	if a.Line < 0 || a.Line > 1023 {
+		details = append(details, FieldError{Field: field + ".line", Message: "must be between 0 and 1023"})
+	}
+	if a.WarmUpMs < 0 || a.WarmUpMs > 30000 {
+		details = append(details, FieldError{Field: field + ".warm_up_ms", Message: "must be between 0 and 30000"})
+	}
+	if a.HoldSeconds < 0 || a.HoldSeconds > 3600 {
+		details = append(details, FieldError{Field: field + ".hold_seconds", Message: "must be between 0 and 3600"})
+	}
+	return details
+}
+
+// amplifierRelay is the state of one relay
+type amplifierRelay struct {
+	chip      string
+	line      int
+	activeLow bool
+	gpio      *gpioLine
+	zones     []string
+	warmUp    time.Duration // Longest warm-up of its zones
+	hold      time.Duration // Longest hold of its zones
+	on        bool
+	onSince   time.Time
+	users     int // Announcements and tests playing through it now
+	release   *time.Timer
+	releaseAt time.Time
+	err       string
+}
+
+var zoneAmplifiers = struct {
+	sync.Mutex
+	relays map[string]*amplifierRelay
+}{relays: make(map[string]*amplifierRelay)}
+
+// configuredAmplifiers collects the relays in the track layout
+func configuredAmplifiers() map[string]*amplifierRelay {
+	relays := make(map[string]*amplifierRelay)
+	for _, zone := range getTrackLayout().Zones {
+		amp := zone.Amplifier
+		if amp == nil {
+			continue
+		}
+		key := amp.relayKey()
+		relay, ok := relays[key]
+		if !ok {
+			relay = &amplifierRelay{chip: amp.chipName(), line: amp.Line, activeLow: amp.ActiveLow}
+			relays[key] = relay
+		}
+		relay.zones = append(relay.zones, zone.ID)
+		if warmUp := time.Duration(amp.WarmUpMs) * time.Millisecond; warmUp > relay.warmUp {
+			relay.warmUp = warmUp
+		}
+		if hold := time.Duration(amp.HoldSeconds) * time.Second; hold > relay.hold {
+			relay.hold = hold
+		}
+	}
+	return relays
+}
+
+// syncZoneAmplifiers claims the relays in the track layout, switched off, and
+// releases those it no longer lists. Called at startup and when the layout
+// is saved.
+func syncZoneAmplifiers() {
+	configured := configuredAmplifiers()
+
+	zoneAmplifiers.Lock()
+	defer zoneAmplifiers.Unlock()
+	for key, relay := range zoneAmplifiers.relays {
+		next, ok := configured[key]
+		if ok && next.activeLow == relay.activeLow {
+			relay.zones, relay.warmUp, relay.hold = next.zones, next.warmUp, next.hold
+			continue
+		}
+		if relay.users > 0 {
+			// Still playing; picked up again on the next sync
+			continue
+		}
+		relay.switchOff()
+		if relay.release != nil {
+			relay.release.Stop()
+		}
+		if relay.gpio != nil {
+			relay.gpio.close()
+		}
+		delete(zoneAmplifiers.relays, key)
+	}
+	for key, relay := range configured {
+		if _, ok := zoneAmplifiers.relays[key]; ok {
+			continue
+		}
+		zoneAmplifiers.relays[key] = relay
+		if err := relay.open(); err != nil {
+			log.Printf("⚠️  Amplifier relay %s for zones %v unavailable: %v", key, relay.zones, err)
+			continue
+		}
+		log.Printf("✓ Amplifier relay %s for zones %v ready (off)", key, relay.zones)
+	}
+}
+
+// open requests the relay's GPIO line if it isn't held yet. Caller must hold
+// zoneAmplifiers.
+func (r *amplifierRelay) open() error {
+	if r.gpio != nil {
+		return nil
+	}
+	line, err := openGPIOLine(r.chip, r.line, r.activeLow)
+	if err != nil {
+		r.err = err.Error()
+		return err
+	}
+	r.gpio = line
+	r.err = ""
+	return nil
+}
+
+// switchOn energizes the relay. Caller must hold zoneAmplifiers.
+func (r *amplifierRelay) switchOn() error {
+	if r.on {
+		return nil
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	if err := r.gpio.set(true); err != nil {
+		r.err = err.Error()
+		return err
+	}
+	r.on = true
+	r.onSince = time.Now()
+	r.err = ""
+	return nil
+}
+
+// switchOff releases the relay. Caller must hold zoneAmplifiers.
+func (r *amplifierRelay) switchOff() {
+	r.releaseAt = time.Time{}
+	if !r.on || r.gpio == nil {
+		return
+	}
+	if err := r.gpio.set(false); err != nil {
+		r.err = err.Error()
+		log.Printf("⚠️  Failed to switch off amplifier relay %s/%d: %v", r.chip, r.line, err)
+		return
+	}
+	r.on = false
+	log.Printf("🔈 Amplifier relay %s/%d off (zones %v)", r.chip, r.line, r.zones)
+}
+
+// amplifierZonesFor returns the zones whose amplifiers an announcement needs:
+// its own zones, or those served by the selected device when it has none
+func amplifierZonesFor(parameters map[string]interface{}) []string {
+	if zones := announcementZones(parameters); len(zones) > 0 {
+		return zones
+	}
+	return zonesForDevices([]string{currentAudioDevice()})
+}
+
+// zonesForDevices returns the zones with a speaker on one of the devices
+func zonesForDevices(devices []string) []string {
+	var zones []string
+	for _, zone := range getTrackLayout().Zones {
+		for _, speaker := range zone.Speakers {
+			if speaker == "" || speaker == "default" {
+				speaker = currentAudioDevice()
+			}
+			if containsString(devices, speaker) {
+				zones = append(zones, zone.ID)
+				break
+			}
+		}
+	}
+	return zones
+}
+
+// powerZoneAmplifiers switches on the relays of the zones and waits out the
+// longest remaining warm-up. The returned function must be called when
+// playback ends; each relay is released once its hold time passes with
+// nothing else using it. A relay that fails is reported and playback goes
+// ahead without it.
+func powerZoneAmplifiers(zones []string) func() {
+	if len(zones) == 0 {
+		return func() {}
+	}
+	zoneAmplifiers.Lock()
+	var used []*amplifierRelay
+	var wait time.Duration
+	for _, relay := range zoneAmplifiers.relays {
+		if !relay.servesAny(zones) {
+			continue
+		}
+		if relay.release != nil {
+			relay.release.Stop()
+			relay.release = nil
+			relay.releaseAt = time.Time{}
+		}
+		wasOn := relay.on
+		if err := relay.switchOn(); err != nil {
+			log.Printf("⚠️  Amplifier relay %s/%d for zones %v failed: %v", relay.chip, relay.line, relay.zones, err)
+			go notify(NotifyAmplifierFailed, fmt.Sprintf("%s/%d", relay.chip, relay.line), "critical", "Amplifier relay failed",
+				fmt.Sprintf("The amplifier relay on %s line %d (zones %s) could not be switched on: %v. Announcements in those zones may not be heard.",
+					relay.chip, relay.line, strings.Join(relay.zones, ", "), err))
+			continue
+		}
+		if !wasOn {
+			log.Printf("🔊 Amplifier relay %s/%d on (zones %v)", relay.chip, relay.line, relay.zones)
+		}
+		relay.users++
+		used = append(used, relay)
+		if remaining := relay.warmUp - time.Since(relay.onSince); remaining > wait {
+			wait = remaining
+		}
+	}
+	zoneAmplifiers.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			zoneAmplifiers.Lock()
+			defer zoneAmplifiers.Unlock()
+			for _, relay := range used {
+				relay.users--
+				if relay.users == 0 {
+					relay.scheduleRelease()
+				}
+			}
+		})
+	}
+}
+
+// servesAny reports whether the relay powers one of the zones
+func (r *amplifierRelay) servesAny(zones []string) bool {
+	for _, zone := range r.zones {
+		if containsString(zones, zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleRelease switches the relay off after its hold time unless it is
+// used again first. Caller must hold zoneAmplifiers.
+func (r *amplifierRelay) scheduleRelease() {
+	if r.hold <= 0 {
+		r.switchOff()
+		return
+	}
+	r.releaseAt = time.Now().Add(r.hold)
+	r.release = time.AfterFunc(r.hold, func() {
+		zoneAmplifiers.Lock()
+		defer zoneAmplifiers.Unlock()
+		if r.users == 0 {
+			r.release = nil
+			r.switchOff()
+		}
+	})
+}
+
+// releaseZoneAmplifiers switches every relay off and frees its line, at
+// shutdown
+func releaseZoneAmplifiers() {
+	zoneAmplifiers.Lock()
+	defer zoneAmplifiers.Unlock()
+	for _, relay := range zoneAmplifiers.relays {
+		if relay.release != nil {
+			relay.release.Stop()
+		}
+		relay.switchOff()
+		if relay.gpio != nil {
+			relay.gpio.close()
+			relay.gpio = nil
+		}
+	}
+}
+
+// amplifierStatus describes a relay for the API
+type amplifierStatus struct {
+	Relay       string     `json:"relay"`
+	Chip        string     `json:"chip"`
+	Line        int        `json:"line"`
+	ActiveLow   bool       `json:"active_low"`
+	Zones       []string   `json:"zones"`
+	WarmUpMs    int64      `json:"warm_up_ms"`
+	HoldSeconds int        `json:"hold_seconds"`
+	On          bool       `json:"on"`
+	OnSince     *time.Time `json:"on_since,omitempty"`
+	InUse       bool       `json:"in_use"`
+	ReleaseAt   *time.Time `json:"release_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// zoneAmplifierStatus lists every relay, sorted by chip and line
+func zoneAmplifierStatus() []amplifierStatus {
+	zoneAmplifiers.Lock()
+	defer zoneAmplifiers.Unlock()
+	statuses := make([]amplifierStatus, 0, len(zoneAmplifiers.relays))
+	for key, relay := range zoneAmplifiers.relays {
+		status := amplifierStatus{
+			Relay:       key,
+			Chip:        relay.chip,
+			Line:        relay.line,
+			ActiveLow:   relay.activeLow,
+			Zones:       relay.zones,
+			WarmUpMs:    relay.warmUp.Milliseconds(),
+			HoldSeconds: int(relay.hold.Seconds()),
+			On:          relay.on,
+			InUse:       relay.users > 0,
+			Error:       relay.err,
+		}
+		if relay.on {
+			onSince := relay.onSince
+			status.OnSince = &onSince
+		}
+		if !relay.releaseAt.IsZero() {
+			releaseAt := relay.releaseAt
+			status.ReleaseAt = &releaseAt
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Chip != statuses[j].Chip {
+			return statuses[i].Chip < statuses[j].Chip
+		}
+		return statuses[i].Line < statuses[j].Line
+	})
+	return statuses
+}
+
+// Handlers
+
+// apiAmplifierStatusHandler lists the amplifier relays and their state
+func apiAmplifierStatusHandler(c *gin.Context) {
+	respondOK(c, gin.H{"amplifiers": zoneAmplifierStatus()})
+}
+
+// apiSwitchAmplifierHandler switches a zone's amplifier by hand, for wiring
+// checks. "on": true powers it for its hold time; false switches it off now
+// unless something is playing through it.
+func apiSwitchAmplifierHandler(c *gin.Context) {
+	data, ok := bindRequestData(c, "on")
+	if !ok {
+		return
+	}
+	zoneID := c.Param("zone")
+	zone, found := getTrackLayout().findZone(zoneID)
+	if !found {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("Zone '%s' not found", zoneID))
+		return
+	}
+	if zone.Amplifier == nil {
+		respondError(c, http.StatusConflict, ErrCodeConflict, fmt.Sprintf("Zone '%s' has no amplifier relay", zoneID))
+		return
+	}
+	if data["on"] == true || data["on"] == "true" {
+		log.Printf("Amplifier for zone %s switched on by %s", zoneID, requestOperator(c))
+		powerZoneAmplifiers([]string{zoneID})()
+	} else {
+		log.Printf("Amplifier for zone %s switched off by %s", zoneID, requestOperator(c))
+		zoneAmplifiers.Lock()
+		relay := zoneAmplifiers.relays[zone.Amplifier.relayKey()]
+		if relay != nil && relay.users > 0 {
+			zoneAmplifiers.Unlock()
+			respondError(c, http.StatusConflict, ErrCodeConflict, "The amplifier is in use by an announcement")
+			return
+		}
+		if relay != nil {
+			if relay.release != nil {
+				relay.release.Stop()
+				relay.release = nil
+			}
+			relay.switchOff()
+		}
+		zoneAmplifiers.Unlock()
+	}
+
+	for _, status := range zoneAmplifierStatus() {
+		if status.Relay == zone.Amplifier.relayKey() {
+			if status.Error != "" {
+				respondError(c, http.StatusBadGateway, ErrCodeUnavailable, "Amplifier relay failed: "+status.Error)
+				return
+			}
+			respondSuccess(c, http.StatusOK, "Amplifier switched", gin.H{"amplifier": status})
+			return
+		}
+	}
+	respondError(c, http.StatusConflict, ErrCodeConflict, "The amplifier relay isn't set up yet; save the track layout again")
+}
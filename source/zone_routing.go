@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Announcements tagged with zones are played on the speakers of those zones.
+// The audio backend drives one output device at a time, so the announcement
+// is played on each zone device in turn, the same way the speaker health test
+// walks its targets, and the selected device is put back afterwards. A speaker
+// of "default" (or "") means the selected output device.
+
+// announcementZones returns the zones an announcement was routed to
+func announcementZones(parameters map[string]interface{}) []string {
+	return languageList(parameters["zones"])
+}
+
+// zoneOutputDevices returns the devices to play an announcement on, or nil to
+// play on the selected device only
+func zoneOutputDevices(parameters map[string]interface{}) []string {
+	zones := announcementZones(parameters)
+	if len(zones) == 0 {
+		return nil
+	}
+	var devices []string
+	for _, speaker := range getTrackLayout().speakersForZones(zones) {
+		if speaker == "" || speaker == "default" {
+			speaker = currentAudioDevice()
+		}
+		devices = append(devices, speaker)
+	}
+	devices = uniqueStrings(devices)
+	if len(devices) == 1 && devices[0] == currentAudioDevice() {
+		return nil
+	}
+	return devices
+}
+
+// playOnZoneDevices calls play once per device with that device selected,
+// then restores the selected device. Cancellation stops the remaining devices;
+// other failures are logged and the next device is tried. It fails only if
+// no device played. The caller must hold globalAudioMutex.
+func playOnZoneDevices(devices []string, play func(first bool) error) error {
+	originalDevice := currentAudioDevice()
+	defer func() {
+		if err := switchOutputDevice(originalDevice); err != nil {
+			log.Printf("⚠️  Failed to restore audio device %s after zone playback: %v", originalDevice, err)
+		}
+	}()
+
+	played := 0
+	var lastErr error
+	for _, device := range devices {
+		err := retryTransientAudio("Switching to zone device "+device, nil, func() error {
+			return switchOutputDevice(device)
+		})
+		if err != nil {
+			log.Printf("⚠️  Zone device %s unavailable: %v", device, err)
+			lastErr = err
+			continue
+		}
+		log.Printf("Playing on zone device: %s", device)
+		err = play(played == 0)
+		if errors.Is(err, errPlaybackCancelled) {
+			return err
+		}
+		if err != nil {
+			log.Printf("⚠️  Playback failed on zone device %s: %v", device, err)
+			lastErr = err
+			continue
+		}
+		played++
+	}
+	if played == 0 && lastErr != nil {
+		return fmt.Errorf("no zone device played: %v", lastErr)
+	}
+	return nil
+}
+
+// trackRouting is the resolved routing of one track
+type trackRouting struct {
+	Track    string   `json:"track"`
+	Name     string   `json:"name"`
+	Platform string   `json:"platform,omitempty"`
+	Zones    []string `json:"zones"`
+	Speakers []string `json:"speakers"`
+}
+
+// trackRoutingTable lists where each track's announcements are played
+func trackRoutingTable() []trackRouting {
+	layout := getTrackLayout()
+	table := make([]trackRouting, 0, len(layout.Tracks))
+	for _, track := range layout.Tracks {
+		zones := layout.zonesForTrack(track.ID)
+		table = append(table, trackRouting{
+			Track:    track.ID,
+			Name:     track.Name,
+			Platform: track.Platform,
+			Zones:    zones,
+			Speakers: layout.speakersForZones(zones),
+		})
+	}
+	return table
+}
+
+// API handlers
+
+// Serialises the read-modify-write of single zone and track edits
+var trackLayoutEditMutex sync.Mutex
+
+func apiTrackRoutingHandler(c *gin.Context) {
+	respondOK(c, gin.H{
+		"routing": trackRoutingTable(),
+	})
+}
+
+// apiPutZoneHandler creates or replaces one zone without resending the layout
+func apiPutZoneHandler(c *gin.Context) {
+	var zone LayoutZone
+	if err := c.ShouldBindJSON(&zone); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	zone.ID = c.Param("id")
+
+	trackLayoutEditMutex.Lock()
+	defer trackLayoutEditMutex.Unlock()
+	current := getTrackLayout()
+	layout := *current
+	layout.Zones = append([]LayoutZone{}, current.Zones...)
+	replaced := false
+	for i := range layout.Zones {
+		if layout.Zones[i].ID == zone.ID {
+			layout.Zones[i] = zone
+			replaced = true
+		}
+	}
+	if !replaced {
+		layout.Zones = append(layout.Zones, zone)
+	}
+
+	if details := layout.validate(); len(details) > 0 {
+		respondValidationError(c, "Invalid zone", details...)
+		return
+	}
+	if err := saveTrackLayout(&layout); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save track layout: "+err.Error())
+		return
+	}
+	respondSuccess(c, http.StatusOK, "Zone '"+zone.ID+"' saved", gin.H{
+		"zone":    zone,
+		"routing": trackRoutingTable(),
+	})
+}
+
+// apiPutTrackZonesHandler sets the zones of one track
+func apiPutTrackZonesHandler(c *gin.Context) {
+	data, ok := bindRequestData(c, "zones")
+	if !ok {
+		return
+	}
+	id := c.Param("id")
+
+	trackLayoutEditMutex.Lock()
+	defer trackLayoutEditMutex.Unlock()
+	current := getTrackLayout()
+	layout := *current
+	layout.Tracks = append([]LayoutTrack{}, current.Tracks...)
+	found := false
+	for i := range layout.Tracks {
+		if layout.Tracks[i].ID == id {
+			layout.Tracks[i].Zones = languageList(data["zones"])
+			found = true
+		}
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Track '"+id+"' is not in the track layout")
+		return
+	}
+
+	if details := layout.validate(); len(details) > 0 {
+		respondValidationError(c, "Invalid track zones", details...)
+		return
+	}
+	if err := saveTrackLayout(&layout); err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to save track layout: "+err.Error())
+		return
+	}
+	respondSuccess(c, http.StatusOK, "Zones for track '"+id+"' saved", gin.H{
+		"routing": trackRoutingTable(),
+	})
+}